@@ -15,6 +15,7 @@ import (
 	"eitec-vpn/internal/module/services"
 	"eitec-vpn/internal/shared/config"
 	"eitec-vpn/internal/shared/utils"
+	"eitec-vpn/internal/shared/wireguard"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +25,7 @@ var (
 	versionFlag = flag.Bool("version", false, "显示版本信息")
 	help        = flag.Bool("help", false, "显示帮助信息")
 	initDB      = flag.Bool("init", false, "初始化数据库和默认数据")
+	enrollURL   = flag.String("enroll", "", "使用一次性加入令牌向服务器注册: --enroll <服务器地址> <加入令牌>")
 )
 
 // 这些变量可以在构建时通过-ldflags设置
@@ -61,6 +63,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("加载配置失败: %v", err)
 	}
+	wireguard.SetConfigDir(cfg.WireGuard.ConfigDir)
+
+	// 注册模式：使用一次性加入令牌向服务器注册后退出
+	if *enrollURL != "" {
+		if flag.NArg() < 1 {
+			log.Fatalf("用法: %s --enroll <服务器地址> <加入令牌>", os.Args[0])
+		}
+		if _, err := services.Enroll(cfg, *configFile, *enrollURL, flag.Arg(0)); err != nil {
+			log.Fatalf("模块注册失败: %v", err)
+		}
+		log.Println("模块注册完成，请重新启动模块服务")
+		return
+	}
 
 	// 初始化模块端专用数据库
 	// 使用utils方法获取数据库绝对路径
@@ -103,7 +118,7 @@ func main() {
 	// 获取服务实例并创建路由
 	moduleService, statusService := moduleManager.GetServices()
 	db := database.GetDB()
-	router := routes.SetupModuleRoutes(moduleService, statusService, cfg, db)
+	router := routes.SetupModuleRoutes(moduleService, statusService, moduleManager.GetMetricsService(), cfg, db)
 
 	// 创建HTTP服务器并设置到管理器
 	server := &http.Server{
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,11 +12,14 @@ import (
 	"time"
 
 	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/server/routes"
 	"eitec-vpn/internal/server/services"
 	"eitec-vpn/internal/shared/auth"
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/envelope"
 	"eitec-vpn/internal/shared/utils"
+	"eitec-vpn/internal/shared/wireguard"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,6 +29,9 @@ var (
 	versionFlag = flag.Bool("version", false, "显示版本信息")
 	help        = flag.Bool("help", false, "显示帮助信息")
 	initDB      = flag.Bool("init", false, "初始化数据库和默认数据")
+	importFile  = flag.String("import", "", "导入已有的WireGuard服务端配置: --import <wgX.conf> [客户端配置...]")
+	dryRun      = flag.Bool("dry-run", false, "与--import一起使用，仅检查冲突不写入数据库")
+	rotateKey   = flag.String("rotate-master-key", "", "使用新的主密钥文件重新加密所有私钥后退出，文件不存在时自动生成")
 )
 
 // 这些变量可以在构建时通过-ldflags设置
@@ -65,6 +72,7 @@ func main() {
 
 	// 设置全局配置
 	config.SetGlobalServerConfig(cfg)
+	wireguard.SetConfigDir(cfg.WireGuard.ConfigDir)
 
 	// 设置Gin模式
 	gin.SetMode(cfg.App.Mode)
@@ -83,6 +91,14 @@ func main() {
 
 	log.Printf("数据库路径: %s", dbPath)
 
+	// 加载主密钥，数据库中的私钥和预共享密钥使用它加密
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		log.Fatalf("加载主密钥失败: %v", err)
+	}
+	envelope.SetKeyring(keyring)
+	log.Printf("主密钥已加载: %s", keyring.PrimaryID())
+
 	// 初始化数据库
 	if *initDB {
 		// 强制初始化模式
@@ -99,6 +115,22 @@ func main() {
 	}
 	log.Println("数据库初始化成功")
 
+	// 主密钥轮换模式：重新加密后退出
+	if *rotateKey != "" {
+		if err := runRotateMasterKey(keyring, *rotateKey); err != nil {
+			log.Fatalf("轮换主密钥失败: %v", err)
+		}
+		return
+	}
+
+	// 导入模式：导入已有的服务端配置后退出
+	if *importFile != "" {
+		if err := runImport(*importFile, flag.Args(), *dryRun); err != nil {
+			log.Fatalf("导入配置失败: %v", err)
+		}
+		return
+	}
+
 	// 创建服务层
 	moduleService := services.NewModuleService()
 	dashboardService := services.NewDashboardService(moduleService)
@@ -108,16 +140,26 @@ func main() {
 	jwtService := auth.NewJWTService(cfg.Auth.JWTSecret, cfg.Auth.RefreshSecret, cfg.Auth.AccessExpiry, cfg.Auth.RefreshExpiry)
 	userService := auth.NewUserService()
 	sessionManager := auth.NewSessionManager(cfg.Auth.SessionTimeout)
+	oidcService := auth.NewOIDCService(cfg.Auth.OIDC)
+	if err := oidcService.Validate(); err != nil {
+		log.Fatalf("单点登录配置错误: %v", err)
+	}
+	if ldap := userService.LDAP(); ldap != nil {
+		if err := ldap.Validate(); err != nil {
+			log.Fatalf("LDAP配置错误: %v", err)
+		}
+	}
 
 	// 设置路由
+	h := routes.NewHandlers(moduleService, dashboardService, configService, userService, jwtService, sessionManager, oidcService)
 	var router *gin.Engine
 	if cfg.App.Mode == "api" {
 		// 仅API模式
-		router = routes.SetupAPIRoutes(moduleService, dashboardService, configService, userService, jwtService, sessionManager)
+		router = routes.SetupAPIRoutes(h)
 		log.Println("运行模式: API Only")
 	} else {
 		// 完整模式 (API + Web界面)
-		router = routes.SetupRoutes(moduleService, dashboardService, configService, userService, jwtService, sessionManager)
+		router = routes.SetupRoutes(h)
 		log.Println("运行模式: Full Stack")
 	}
 
@@ -159,7 +201,7 @@ func main() {
 	}
 
 	// 启动后台任务
-	startBackgroundTasks(moduleService, cfg)
+	startBackgroundTasks(moduleService, sessionManager, cfg)
 
 	// 等待中断信号
 	quit := make(chan os.Signal, 1)
@@ -172,8 +214,114 @@ func main() {
 	gracefulShutdown(server)
 }
 
+// runImport 从命令行导入服务端配置，接口名称取自文件名
+func runImport(configPath string, peerPaths []string, dryRun bool) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	req := &models.InterfaceImportRequest{
+		Name:        services.ImportConfigName(configPath),
+		Description: "导入自 " + configPath,
+		Config:      string(content),
+		DryRun:      dryRun,
+	}
+	for _, peerPath := range peerPaths {
+		peerContent, err := os.ReadFile(peerPath)
+		if err != nil {
+			return fmt.Errorf("读取客户端配置失败: %w", err)
+		}
+		req.PeerConfigs = append(req.PeerConfigs, string(peerContent))
+	}
+
+	result, err := services.NewInterfaceImportService().Import(req)
+	if result != nil {
+		for _, peer := range result.Peers {
+			log.Printf("  [%s] %s %s %s 私钥:%v", peer.Kind, peer.Name, peer.IPAddress, peer.PublicKey, peer.HasPrivate)
+		}
+		for _, conflict := range result.Conflicts {
+			if conflict.Line > 0 {
+				log.Printf("  冲突 第%d行: %s", conflict.Line, conflict.Message)
+			} else {
+				log.Printf("  冲突: %s", conflict.Message)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.Imported {
+		log.Printf("接口 %s 导入完成，共 %d 个对等端", result.Interface.Name, len(result.Peers))
+	} else {
+		log.Printf("检查通过，接口 %s 共 %d 个对等端，未写入数据库", result.Interface.Name, len(result.Peers))
+	}
+	return nil
+}
+
+// loadKeyring 加载主密钥，环境变量优先，否则读取配置的密钥文件（不存在时自动生成）
+func loadKeyring(cfg *config.ServerConfig) (*envelope.Keyring, error) {
+	var primary []byte
+	if value := os.Getenv(envelope.EnvMasterKey); value != "" {
+		key, err := envelope.ParseKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("环境变量 %s 无效: %w", envelope.EnvMasterKey, err)
+		}
+		primary = key
+	} else {
+		path, err := utils.GetAbsolutePath(cfg.Encryption.MasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("获取主密钥路径失败: %w", err)
+		}
+		key, created, err := envelope.LoadOrCreateKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			log.Printf("⚠️ 已生成主密钥文件 %s，请妥善备份，丢失后无法解密已保存的私钥", path)
+		}
+		primary = key
+	}
+
+	var previous [][]byte
+	for _, path := range cfg.Encryption.PreviousMasterKeyFiles {
+		key, err := envelope.LoadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return envelope.NewKeyring(primary, previous...)
+}
+
+// runRotateMasterKey 使用新主密钥重新加密所有敏感字段，完成后需将配置中的主密钥改为新密钥
+func runRotateMasterKey(current *envelope.Keyring, keyFile string) error {
+	key, created, err := envelope.LoadOrCreateKeyFile(keyFile)
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("已生成新主密钥文件 %s", keyFile)
+	}
+
+	rotated, err := current.Rotate(key)
+	if err != nil {
+		return err
+	}
+	resealed, err := database.ResealSecrets(rotated)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("已使用新主密钥 %s 重新加密 %d 个敏感字段", rotated.PrimaryID(), resealed)
+	log.Printf("请将 encryption.master_key_file 改为 %s（或更新环境变量 %s）后重启服务，旧主密钥 %s 不再需要", keyFile, envelope.EnvMasterKey, current.PrimaryID())
+	return nil
+}
+
 // startBackgroundTasks 启动后台任务
-func startBackgroundTasks(moduleService *services.ModuleService, cfg *config.ServerConfig) {
+func startBackgroundTasks(moduleService *services.ModuleService, sessionManager *auth.SessionManager, cfg *config.ServerConfig) {
 	// 启动模块状态同步任务
 	go func() {
 		ticker := time.NewTicker(time.Duration(cfg.WireGuard.SyncInterval) * time.Second)
@@ -186,14 +334,52 @@ func startBackgroundTasks(moduleService *services.ModuleService, cfg *config.Ser
 		}
 	}()
 
+	// 启动流量历史清理任务
+	go func() {
+		trafficService := services.NewTrafficService()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if removed, err := trafficService.Prune(time.Now()); err != nil {
+				log.Printf("清理流量历史失败: %v", err)
+			} else if removed > 0 {
+				log.Printf("清理过期流量历史 %d 条", removed)
+			}
+		}
+	}()
+
+	// 启动用户VPN到期检查任务：到期用户从接口移除，延期后恢复，并在到期前发出提醒
+	go func() {
+		userVPNService := services.NewUserVPNService()
+		interval := cfg.UserVPN.CheckInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			result, err := userVPNService.EnforceLifecycle(time.Now(), cfg.UserVPN.ExpiryWarningDays)
+			if err != nil {
+				log.Printf("检查用户VPN到期失败: %v", err)
+			} else if result.Changed() {
+				log.Printf("用户VPN到期检查: 过期 %d 个, 恢复 %d 个, 提醒 %d 个", len(result.Expired), len(result.Restored), len(result.Warned))
+			}
+		}
+	}()
+
 	// 启动会话清理任务
 	go func() {
 		ticker := time.NewTicker(5 * time.Minute) // 每5分钟清理一次
 		defer ticker.Stop()
 
 		for range ticker.C {
-			// TODO: 实现会话清理逻辑
-			log.Println("执行会话清理任务")
+			if removed, err := sessionManager.CleanupExpiredSessions(); err != nil {
+				log.Printf("清理过期会话失败: %v", err)
+			} else if removed > 0 {
+				log.Printf("清理过期会话 %d 个", removed)
+			}
 		}
 	}()
 
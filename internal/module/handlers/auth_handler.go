@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"log"
+	"time"
 
 	"eitec-vpn/internal/module/models"
+	"eitec-vpn/internal/shared/lockout"
 	"eitec-vpn/internal/shared/response"
 	"eitec-vpn/internal/shared/utils"
 
@@ -13,14 +17,23 @@ import (
 	"gorm.io/gorm"
 )
 
+// ErrSessionInvalid 会话不存在、已过期或用户已停用
+var ErrSessionInvalid = errors.New("会话无效或已过期")
+
 // AuthHandler 认证处理器
 type AuthHandler struct {
-	db *gorm.DB
+	db             *gorm.DB
+	sessionTimeout time.Duration
+	lockout        *lockout.Tracker
 }
 
-// NewAuthHandler 创建认证处理器
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+// NewAuthHandler 创建认证处理器，sessionTimeout为登录会话有效期，policy为登录失败锁定策略
+func NewAuthHandler(db *gorm.DB, sessionTimeout time.Duration, policy lockout.Policy) *AuthHandler {
+	return &AuthHandler{
+		db:             db,
+		sessionTimeout: sessionTimeout,
+		lockout:        lockout.NewTracker(db, func() lockout.Policy { return policy }),
+	}
 }
 
 // Login 处理登录请求
@@ -35,11 +48,18 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// 预占一次尝试，账户或来源IP失败次数过多时拒绝登录
+	clientIP := c.ClientIP()
+	if err := h.lockout.Attempt(req.Username, clientIP); err != nil {
+		h.respondLoginFailure(c, err)
+		return
+	}
+
 	// 查找用户
 	var user models.LocalUser
 	if err := h.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			response.Unauthorized(c, "用户名或密码错误")
+			h.respondLoginFailure(c, h.lockout.Fail(req.Username, clientIP))
 			return
 		}
 		log.Printf("查询用户失败: %v", err)
@@ -49,11 +69,19 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	// 验证密码
 	if !utils.CheckPassword(req.Password, user.Password) {
-		response.Unauthorized(c, "用户名或密码错误")
+		h.respondLoginFailure(c, h.lockout.Fail(req.Username, clientIP))
 		return
 	}
+	if err := h.lockout.Succeed(req.Username, clientIP); err != nil {
+		log.Printf("清除登录失败记录失败: %v", err)
+	}
 
-	// 生成会话token
+	if !user.IsActive {
+		response.Unauthorized(c, "用户已被禁用")
+		return
+	}
+
+	// 生成会话token，数据库中只保存哈希
 	token, err := generateToken()
 	if err != nil {
 		log.Printf("生成token失败: %v", err)
@@ -61,24 +89,85 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	now := time.Now()
+	session := &models.LocalSession{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ClientIP:  clientIP,
+		ExpiresAt: now.Add(h.sessionTimeout),
+	}
+	if err := h.db.Create(session).Error; err != nil {
+		log.Printf("创建会话失败: %v", err)
+		response.InternalError(c, "系统错误")
+		return
+	}
+	h.db.Model(&user).Update("last_login", now)
+
+	// 顺带清理过期会话
+	if err := h.db.Where("expires_at < ?", now).Delete(&models.LocalSession{}).Error; err != nil {
+		log.Printf("清理过期会话失败: %v", err)
+	}
+
 	// 设置cookie - 使用module_token保持一致性
-	c.SetCookie("module_token", token, 3600*24, "/", "", false, true)
+	c.SetCookie("module_token", token, int(h.sessionTimeout.Seconds()), "/", "", false, true)
 
 	// 记录登录日志
 	log.Printf("用户 %s 登录成功", user.Username)
 
 	response.Success(c, gin.H{
-		"message": "登录成功",
-		"token":   token,
+		"message":    "登录成功",
+		"token":      token,
+		"expires_at": session.ExpiresAt,
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
+			"role":     user.Role.String(),
 		},
 	})
 }
 
-// Logout 处理登出请求
+// respondLoginFailure 根据锁定状态返回登录失败响应，err为Check或Fail的结果
+func (h *AuthHandler) respondLoginFailure(c *gin.Context, err error) {
+	var locked *lockout.LockedError
+	if errors.As(err, &locked) {
+		log.Printf("登录被锁定: %s %s 至 %s", locked.Kind, locked.Key, locked.Until.Format(time.RFC3339))
+		response.TooManyRequests(c, locked.Error(), locked.RetryAfter())
+		return
+	}
+	if err != nil {
+		log.Printf("记录登录失败失败: %v", err)
+	}
+	response.Unauthorized(c, "用户名或密码错误")
+}
+
+// ValidateSession 校验token对应的会话，返回会话及其用户
+func (h *AuthHandler) ValidateSession(token string) (*models.LocalSession, error) {
+	var session models.LocalSession
+	if err := h.db.Preload("User").Where("token_hash = ?", hashToken(token)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, err
+	}
+
+	// 已删除的用户不会被Preload加载
+	if session.IsExpired() || session.User.ID == 0 || !session.User.IsActive {
+		return nil, ErrSessionInvalid
+	}
+
+	return &session, nil
+}
+
+// Logout 处理登出请求，删除当前会话
 func (h *AuthHandler) Logout(c *gin.Context) {
+	if sessionID := c.GetUint("session_id"); sessionID != 0 {
+		if err := h.db.Delete(&models.LocalSession{}, sessionID).Error; err != nil {
+			log.Printf("删除会话失败: %v", err)
+			response.InternalError(c, "系统错误")
+			return
+		}
+	}
+
 	// 清除cookie - 使用module_token保持一致性
 	c.SetCookie("module_token", "", -1, "/", "", false, true)
 
@@ -92,6 +181,26 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	})
 }
 
+// LogoutAll 删除当前用户的所有会话，使所有设备上的登录失效
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	result := h.db.Where("user_id = ?", userID).Delete(&models.LocalSession{})
+	if result.Error != nil {
+		log.Printf("删除会话失败: %v", result.Error)
+		response.InternalError(c, "系统错误")
+		return
+	}
+
+	c.SetCookie("module_token", "", -1, "/", "", false, true)
+	log.Printf("用户 %s 退出所有会话，共 %d 个", c.GetString("username"), result.RowsAffected)
+
+	response.Success(c, gin.H{
+		"message":  "已退出所有会话",
+		"sessions": result.RowsAffected,
+	})
+}
+
 // ChangePassword 修改密码
 func (h *AuthHandler) ChangePassword(c *gin.Context) {
 	var req struct {
@@ -140,6 +249,11 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// 修改密码后其他会话失效，保留当前会话
+	if err := h.db.Where("user_id = ? AND id <> ?", user.ID, c.GetUint("session_id")).Delete(&models.LocalSession{}).Error; err != nil {
+		log.Printf("删除其他会话失败: %v", err)
+	}
+
 	// 记录修改密码日志
 	log.Printf("用户 %s 修改密码成功", user.Username)
 
@@ -163,11 +277,15 @@ func (h *AuthHandler) Verify(c *gin.Context) {
 		return
 	}
 
+	role, _ := c.Get("role")
+	localRole, _ := role.(models.LocalUserRole)
+
 	response.Success(c, gin.H{
 		"message": "token有效",
 		"user": gin.H{
 			"id":       userID,
 			"username": username,
+			"role":     localRole.String(),
 		},
 	})
 }
@@ -180,3 +298,55 @@ func generateToken() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// hashToken 计算会话token的哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetLockouts 获取当前被锁定的账户和IP
+func (h *AuthHandler) GetLockouts(c *gin.Context) {
+	records, err := h.lockout.Locked()
+	if err != nil {
+		log.Printf("查询锁定记录失败: %v", err)
+		response.InternalError(c, "系统错误")
+		return
+	}
+
+	response.Success(c, records)
+}
+
+// GetLockoutEvents 获取最近的锁定和解锁事件
+func (h *AuthHandler) GetLockoutEvents(c *gin.Context) {
+	events, err := h.lockout.Events(100)
+	if err != nil {
+		log.Printf("查询锁定事件失败: %v", err)
+		response.InternalError(c, "系统错误")
+		return
+	}
+
+	response.Success(c, events)
+}
+
+// Unlock 手动解锁账户或IP，kind为user或ip
+func (h *AuthHandler) Unlock(c *gin.Context) {
+	kind, key := c.Param("kind"), c.Param("key")
+	if kind != lockout.KindUser && kind != lockout.KindIP {
+		response.BadRequest(c, "锁定类型必须为user或ip")
+		return
+	}
+
+	if err := h.lockout.Unlock(kind, key, c.GetString("username")); err != nil {
+		if errors.Is(err, lockout.ErrNotLocked) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		log.Printf("解锁失败: %v", err)
+		response.InternalError(c, "系统错误")
+		return
+	}
+
+	log.Printf("用户 %s 解锁 %s %s", c.GetString("username"), kind, key)
+	response.Success(c, gin.H{"message": "解锁成功"})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"eitec-vpn/internal/module/services"
 	"eitec-vpn/internal/shared/response"
+	"eitec-vpn/internal/shared/wireguard"
 	"fmt"
 	"sync"
 	"time"
@@ -294,7 +295,7 @@ func (h *DashboardHandler) GetWireGuardConfigFile(c *gin.Context) {
 	}
 
 	// 构建配置文件路径
-	configPath := "/etc/wireguard/" + interfaceName + ".conf"
+	configPath := wireguard.ConfigPath(interfaceName)
 
 	// 读取配置文件内容
 	configContent, err := h.moduleService.ReadWireGuardConfigFile(configPath)
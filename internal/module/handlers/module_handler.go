@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"errors"
+	"net/http"
+
 	"eitec-vpn/internal/module/services"
 	"eitec-vpn/internal/shared/response"
 
@@ -49,7 +52,7 @@ func (h *ModuleHandler) GetStats(c *gin.Context) {
 	response.Success(c, trafficStats)
 }
 
-// ConfigureModule 配置模块 - 用于初始配置
+// ConfigureModule 配置模块 - 仅用于初始配置，无需认证，模块已配置后返回409
 func (h *ModuleHandler) ConfigureModule(c *gin.Context) {
 	var req services.SetupInfo
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -57,7 +60,11 @@ func (h *ModuleHandler) ConfigureModule(c *gin.Context) {
 		return
 	}
 
-	if err := h.moduleService.ApplySetup(&req); err != nil {
+	if err := h.moduleService.ApplyInitialSetup(&req); err != nil {
+		if errors.Is(err, services.ErrAlreadyConfigured) {
+			response.Error(c, http.StatusConflict, err.Error())
+			return
+		}
 		response.InternalError(c, err.Error())
 		return
 	}
@@ -1,15 +1,18 @@
 package middleware
 
 import (
+	"errors"
+	"log"
+	"strings"
+
 	"eitec-vpn/internal/module/handlers"
 	"eitec-vpn/internal/module/models"
 	"eitec-vpn/internal/shared/response"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware 认证中间件
+// AuthMiddleware 认证中间件，从数据库校验登录会话
 func AuthMiddleware(authHandler *handlers.AuthHandler) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 检查Authorization header中的Bearer token
@@ -31,19 +34,35 @@ func AuthMiddleware(authHandler *handlers.AuthHandler) gin.HandlerFunc {
 			return
 		}
 
-		// 验证token格式（检查token长度）
-		if len(token) < 20 {
-			response.Unauthorized(c, "无效token")
+		session, err := authHandler.ValidateSession(token)
+		if err != nil {
+			if errors.Is(err, handlers.ErrSessionInvalid) {
+				response.Unauthorized(c, "无效token")
+			} else {
+				log.Printf("校验会话失败: %v", err)
+				response.InternalError(c, "系统错误")
+			}
 			c.Abort()
 			return
 		}
 
-		// 验证token有效性 - 这里简化处理，实际应该从数据库验证
-		// 暂时使用默认管理员信息，后续可以扩展为数据库验证
-		c.Set("username", "admin")
-		c.Set("user_id", uint(1))
-		c.Set("role", models.LocalUserRoleAdmin)
+		c.Set("session_id", session.ID)
+		c.Set("user_id", session.User.ID)
+		c.Set("username", session.User.Username)
+		c.Set("role", session.User.Role)
+
+		c.Next()
+	}
+}
 
+// RequireAdmin 要求当前用户为管理员，只读用户返回403
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role, _ := c.Get("role"); role != models.LocalUserRoleAdmin {
+			response.Forbidden(c, "权限不足")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,143 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"eitec-vpn/internal/module/database"
+	"eitec-vpn/internal/module/handlers"
+	"eitec-vpn/internal/module/middleware"
+	"eitec-vpn/internal/module/models"
+	"eitec-vpn/internal/shared/lockout"
+	"eitec-vpn/internal/shared/response"
+	"eitec-vpn/internal/shared/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuthRouter 创建只包含认证相关路由的测试路由
+func newAuthRouter(t *testing.T, sessionTimeout time.Duration) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	if err := database.InitModuleDatabase(filepath.Join(t.TempDir(), "module.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	password, err := utils.HashPassword("viewer123")
+	if err != nil {
+		t.Fatalf("密码哈希失败: %v", err)
+	}
+	viewer := &models.LocalUser{Username: "viewer", Password: password, Role: models.LocalUserRoleViewer, IsActive: true}
+	if err := database.DB.Create(viewer).Error; err != nil {
+		t.Fatalf("创建只读用户失败: %v", err)
+	}
+
+	authHandler := handlers.NewAuthHandler(database.DB, sessionTimeout, lockout.DefaultPolicy(3, time.Minute))
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	auth := router.Group("", middleware.AuthMiddleware(authHandler))
+	auth.GET("/verify", authHandler.Verify)
+	auth.POST("/logout", authHandler.Logout)
+	auth.POST("/logout-all", authHandler.LogoutAll)
+	auth.POST("/control", middleware.RequireAdmin(), func(c *gin.Context) {
+		response.Success(c, gin.H{"message": "操作成功"})
+	})
+	return router
+}
+
+func doRequest(router *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func login(t *testing.T, router *gin.Engine, username, password string) string {
+	t.Helper()
+	recorder := doRequest(router, http.MethodPost, "/login", "", `{"username":"`+username+`","password":"`+password+`"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s 登录返回 %d: %s", username, recorder.Code, recorder.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil || resp.Data.Token == "" {
+		t.Fatalf("解析登录响应失败: %v %s", err, recorder.Body.String())
+	}
+	return resp.Data.Token
+}
+
+func TestSessionValidation(t *testing.T) {
+	router := newAuthRouter(t, time.Hour)
+
+	// 格式正确但未登录得到的token不再被接受
+	if code := doRequest(router, http.MethodGet, "/verify", strings.Repeat("a", 64), "").Code; code != http.StatusUnauthorized {
+		t.Errorf("伪造token返回 %d, 期望 401", code)
+	}
+
+	first := login(t, router, "admin", "admin123")
+	second := login(t, router, "admin", "admin123")
+	for _, token := range []string{first, second} {
+		if code := doRequest(router, http.MethodGet, "/verify", token, "").Code; code != http.StatusOK {
+			t.Errorf("有效会话返回 %d, 期望 200", code)
+		}
+	}
+
+	// 登出只影响当前会话
+	doRequest(router, http.MethodPost, "/logout", first, "")
+	if code := doRequest(router, http.MethodGet, "/verify", first, "").Code; code != http.StatusUnauthorized {
+		t.Errorf("登出后返回 %d, 期望 401", code)
+	}
+	if code := doRequest(router, http.MethodGet, "/verify", second, "").Code; code != http.StatusOK {
+		t.Errorf("其他会话返回 %d, 期望 200", code)
+	}
+
+	// 退出所有会话
+	third := login(t, router, "admin", "admin123")
+	doRequest(router, http.MethodPost, "/logout-all", third, "")
+	for _, token := range []string{second, third} {
+		if code := doRequest(router, http.MethodGet, "/verify", token, "").Code; code != http.StatusUnauthorized {
+			t.Errorf("退出所有会话后返回 %d, 期望 401", code)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.LocalSession{}).Count(&count)
+	if count != 0 {
+		t.Errorf("剩余 %d 个会话, 期望 0", count)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	router := newAuthRouter(t, time.Hour)
+	token := login(t, router, "admin", "admin123")
+
+	database.DB.Model(&models.LocalSession{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+	if code := doRequest(router, http.MethodGet, "/verify", token, "").Code; code != http.StatusUnauthorized {
+		t.Errorf("过期会话返回 %d, 期望 401", code)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	router := newAuthRouter(t, time.Hour)
+
+	if code := doRequest(router, http.MethodPost, "/control", login(t, router, "viewer", "viewer123"), "").Code; code != http.StatusForbidden {
+		t.Errorf("只读用户返回 %d, 期望 403", code)
+	}
+	if code := doRequest(router, http.MethodPost, "/control", login(t, router, "admin", "admin123"), "").Code; code != http.StatusOK {
+		t.Errorf("管理员返回 %d, 期望 200", code)
+	}
+}
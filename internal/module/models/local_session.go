@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// LocalSession 模块端登录会话，只保存token的哈希
+type LocalSession struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	TokenHash string    `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	ClientIP  string    `json:"client_ip" gorm:"size:64"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+
+	User LocalUser `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// TableName 指定表名
+func (LocalSession) TableName() string {
+	return "local_sessions"
+}
+
+// IsExpired 会话是否已过期
+func (s *LocalSession) IsExpired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
@@ -11,7 +11,7 @@ type LocalUser struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`
 	Username  string         `json:"username" gorm:"not null;size:50;uniqueIndex"`
 	Password  string         `json:"-" gorm:"not null;size:255"` // 哈希后的密码
-	Role      LocalUserRole  `json:"role" gorm:"default:1"`
+	Role      LocalUserRole  `json:"role"`                       // 不设默认值，否则只读用户（零值）会被写成管理员
 	IsActive  bool           `json:"is_active" gorm:"default:true"`
 	LastLogin *time.Time     `json:"last_login"`
 	CreatedAt time.Time      `json:"created_at"`
@@ -1,12 +1,19 @@
 package models
 
 import (
+	"eitec-vpn/internal/shared/lockout"
+
 	"gorm.io/gorm"
 )
 
 // AutoMigrate 自动迁移数据库表结构
 func AutoMigrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(lockout.Models()...); err != nil {
+		return err
+	}
+
 	return db.AutoMigrate(
 		&LocalUser{},
+		&LocalSession{},
 	)
 }
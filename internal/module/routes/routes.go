@@ -5,6 +5,8 @@ import (
 	"eitec-vpn/internal/module/middleware"
 	"eitec-vpn/internal/module/services"
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/lockout"
+	"eitec-vpn/internal/shared/metrics"
 	"eitec-vpn/internal/shared/utils"
 	"fmt"
 	"net/http"
@@ -15,9 +17,15 @@ import (
 )
 
 // SetupModuleRoutes 设置模块路由 - 参考server端设计，使用handlers模式
-func SetupModuleRoutes(moduleService *services.ModuleService, statusService *services.StatusService, cfg *config.ModuleConfig, db *gorm.DB) *gin.Engine {
+func SetupModuleRoutes(moduleService *services.ModuleService, statusService *services.StatusService, metricsService *services.MetricsService, cfg *config.ModuleConfig, db *gorm.DB) *gin.Engine {
 	router := gin.New()
 
+	// 只采信配置的反向代理转发的客户端地址，防止伪造X-Forwarded-For绕过按IP的登录锁定
+	if err := router.SetTrustedProxies(cfg.App.TrustedProxies); err != nil {
+		fmt.Printf("设置可信代理失败，将忽略X-Forwarded-For: %v\n", err)
+		router.SetTrustedProxies(nil)
+	}
+
 	// 基础中间件
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
@@ -51,7 +59,7 @@ func SetupModuleRoutes(moduleService *services.ModuleService, statusService *ser
 	}
 
 	// 创建处理器
-	authHandler := handlers.NewAuthHandler(db)
+	authHandler := handlers.NewAuthHandler(db, cfg.Auth.SessionTimeout, lockout.DefaultPolicy(cfg.Auth.MaxLoginAttempts, cfg.Auth.LockoutDuration))
 	moduleHandler := handlers.NewModuleHandler(moduleService, statusService)
 	// 创建仪表板控制器
 	dashboardHandler := handlers.NewDashboardHandler(statusService, moduleService)
@@ -64,6 +72,9 @@ func SetupModuleRoutes(moduleService *services.ModuleService, statusService *ser
 		})
 	})
 
+	// Prometheus指标 (配置了metrics.token时需要Bearer令牌)
+	router.GET("/metrics", metrics.Handler(cfg.Metrics.Token, metricsService.Collect))
+
 	// 页面路由
 	router.GET("/", func(c *gin.Context) {
 		// 主页面显示index.html，集成了配置功能，由前端JavaScript处理认证和配置状态检查
@@ -104,7 +115,7 @@ func SetupModuleRoutes(moduleService *services.ModuleService, statusService *ser
 					"configured": moduleService.IsConfigured(),
 				})
 			})
-			// 初始配置接口（无需认证，用于首次配置）
+			// 初始配置接口（无需认证，仅用于首次配置，已配置后拒绝）
 			public.POST("/configure", moduleHandler.ConfigureModule)
 		}
 
@@ -115,6 +126,7 @@ func SetupModuleRoutes(moduleService *services.ModuleService, statusService *ser
 			// 认证相关
 			auth.GET("/auth/verify", authHandler.Verify)
 			auth.POST("/auth/logout", authHandler.Logout)
+			auth.POST("/auth/logout-all", authHandler.LogoutAll)
 			auth.POST("/auth/change-password", authHandler.ChangePassword)
 
 			// 状态相关
@@ -123,11 +135,6 @@ func SetupModuleRoutes(moduleService *services.ModuleService, statusService *ser
 			// 流量统计
 			auth.GET("/stats", moduleHandler.GetStats)
 
-			// WireGuard控制
-			auth.POST("/wireguard/start", moduleHandler.StartWireGuard)
-			auth.POST("/wireguard/stop", moduleHandler.StopWireGuard)
-			auth.POST("/wireguard/restart", moduleHandler.RestartWireGuard)
-
 			// Dashboard相关API
 			auth.GET("/dashboard/stats", dashboardHandler.GetDashboardStats)
 			auth.GET("/dashboard/vpn-status", dashboardHandler.GetVPNStatus)
@@ -136,13 +143,28 @@ func SetupModuleRoutes(moduleService *services.ModuleService, statusService *ser
 			auth.GET("/dashboard/system-status", dashboardHandler.GetSystemStatus)
 			auth.POST("/dashboard/refresh", dashboardHandler.RefreshDashboard)
 
-			// WireGuard控制接口
+			// WireGuard接口列表
 			auth.GET("/wireguard/interfaces", dashboardHandler.GetWireGuardInterfaces)
-			auth.POST("/wireguard/control", dashboardHandler.ControlWireGuard)
-			auth.POST("/wireguard/config/upload", dashboardHandler.UploadWireGuardConfig)
 
-			// 配置文件读取接口
-			auth.GET("/wireguard/config/:interface", dashboardHandler.GetWireGuardConfigFile)
+			// 以下操作会改变隧道状态或读取私钥，只允许管理员
+			admin := auth.Group("")
+			admin.Use(middleware.RequireAdmin())
+			{
+				// WireGuard控制
+				admin.POST("/wireguard/start", moduleHandler.StartWireGuard)
+				admin.POST("/wireguard/stop", moduleHandler.StopWireGuard)
+				admin.POST("/wireguard/restart", moduleHandler.RestartWireGuard)
+				admin.POST("/wireguard/control", dashboardHandler.ControlWireGuard)
+				admin.POST("/wireguard/config/upload", dashboardHandler.UploadWireGuardConfig)
+
+				// 配置文件读取接口
+				admin.GET("/wireguard/config/:interface", dashboardHandler.GetWireGuardConfigFile)
+
+				// 登录失败锁定
+				admin.GET("/auth/lockouts", authHandler.GetLockouts)
+				admin.GET("/auth/lockout-events", authHandler.GetLockoutEvents)
+				admin.DELETE("/auth/lockouts/:kind/:key", authHandler.Unlock)
+			}
 		}
 	}
 
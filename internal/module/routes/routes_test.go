@@ -0,0 +1,122 @@
+package routes_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"eitec-vpn/internal/module/database"
+	"eitec-vpn/internal/module/routes"
+	"eitec-vpn/internal/module/services"
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/lockout"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newModuleRouter 使用临时目录创建完整的模块端路由
+func newModuleRouter(t *testing.T) (*gin.Engine, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	// 页面模板按相对路径查找，切换到项目根目录
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(wd, "..", "..", "..")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	configDir := t.TempDir()
+	t.Setenv("EITEC_CONFIG_DIR", configDir)
+	t.Setenv("MODULE_ID", "")
+	wireguard.SetConfigDir(t.TempDir())
+	t.Cleanup(func() { wireguard.SetConfigDir("") })
+
+	if err := database.InitModuleDatabase(filepath.Join(t.TempDir(), "module.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	cfg := &config.ModuleConfig{}
+	cfg.Auth.MaxLoginAttempts = 5
+	cfg.Auth.LockoutDuration = 15 * time.Minute
+	moduleService := services.NewModuleService(cfg)
+	statusService := services.NewStatusService(cfg)
+	router := routes.SetupModuleRoutes(moduleService, statusService, services.NewMetricsService(cfg, statusService), cfg, database.DB)
+	return router, configDir
+}
+
+func post(router *gin.Engine, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestConfigureOnlyBeforeFirstSetup(t *testing.T) {
+	router, configDir := newModuleRouter(t)
+
+	first := `{"module_id":1,"api_key":"first-key","server_url":"https://vpn.example.com","config_data":"[Interface]\nPrivateKey = a\n"}`
+	if resp := post(router, "/api/v1/configure", first, nil); resp.Code != http.StatusOK {
+		t.Fatalf("首次配置返回 %d: %s", resp.Code, resp.Body.String())
+	}
+
+	// 已配置后未认证的请求不能替换配置
+	second := `{"module_id":2,"api_key":"attacker-key","server_url":"https://evil.example.com","config_data":"[Interface]\nPrivateKey = b\n"}`
+	if resp := post(router, "/api/v1/configure", second, nil); resp.Code != http.StatusConflict {
+		t.Errorf("再次配置返回 %d, 期望 409", resp.Code)
+	}
+
+	info, err := os.ReadFile(filepath.Join(configDir, "module.info"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(info), "API_KEY=first-key") || strings.Contains(string(info), "attacker-key") {
+		t.Errorf("模块信息被覆盖:\n%s", info)
+	}
+	conf, err := os.ReadFile(wireguard.ConfigPath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(conf), "PrivateKey = a") {
+		t.Errorf("WireGuard配置被覆盖:\n%s", conf)
+	}
+}
+
+func TestLoginIgnoresForgedForwardedFor(t *testing.T) {
+	router, _ := newModuleRouter(t)
+
+	// 未配置可信代理时，每次伪造不同的X-Forwarded-For仍按连接地址累计失败次数
+	for i := 1; i <= 20; i++ {
+		want := http.StatusUnauthorized
+		if i == 20 {
+			want = http.StatusTooManyRequests
+		}
+		body := fmt.Sprintf(`{"username":"ghost%d","password":"wrong"}`, i)
+		headers := map[string]string{"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i)}
+		if resp := post(router, "/api/v1/auth/login", body, headers); resp.Code != want {
+			t.Fatalf("第%d次失败返回 %d, 期望 %d", i, resp.Code, want)
+		}
+	}
+
+	var record lockout.Record
+	if err := database.DB.Where("kind = ?", lockout.KindIP).First(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != "192.0.2.1" || !record.IsLocked(time.Now()) {
+		t.Errorf("IP锁定记录: %+v", record)
+	}
+}
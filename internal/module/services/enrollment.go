@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/wireguard"
+)
+
+// EnrollRequest 模块注册请求
+type EnrollRequest struct {
+	Token     string `json:"token"`
+	PublicKey string `json:"public_key"`
+	Hostname  string `json:"hostname"`
+}
+
+// EnrollResult 模块注册结果
+type EnrollResult struct {
+	ModuleID       uint   `json:"module_id"`
+	ModuleName     string `json:"module_name"`
+	Location       string `json:"location"`
+	APIKey         string `json:"api_key"`
+	ServerEndpoint string `json:"server_endpoint"`
+	Config         string `json:"config"`
+}
+
+// enrollResponse 服务器统一响应格式
+type enrollResponse struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    EnrollResult `json:"data"`
+}
+
+// Enroll 使用一次性加入令牌向服务器注册模块
+// 私钥在本地生成且只保存在本地，服务器只接收公钥
+func Enroll(cfg *config.ModuleConfig, configPath, serverURL, token string) (*EnrollResult, error) {
+	serverURL = strings.TrimRight(serverURL, "/")
+	if serverURL == "" || token == "" {
+		return nil, fmt.Errorf("服务器地址和加入令牌不能为空")
+	}
+
+	// 1. 本地生成密钥对
+	keyPair, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("生成密钥对失败: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	reqBody, err := json.Marshal(&EnrollRequest{
+		Token:     token,
+		PublicKey: keyPair.PublicKey,
+		Hostname:  hostname,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求数据失败: %w", err)
+	}
+
+	// 2. 向服务器注册
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Post(serverURL+"/api/v1/enroll", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("注册请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取注册响应失败: %w", err)
+	}
+
+	var result enrollResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("服务器返回错误 %d: %s", resp.StatusCode, body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("注册失败: %s", result.Message)
+	}
+
+	enrolled := &result.Data
+
+	// 3. 写入WireGuard配置（填入本地私钥）和模块信息
+	configContent := wireguard.InjectPrivateKey(enrolled.Config, keyPair.PrivateKey)
+	moduleService := NewModuleService(cfg)
+	if err := moduleService.ApplySetup(&SetupInfo{
+		ModuleID:   enrolled.ModuleID,
+		APIKey:     enrolled.APIKey,
+		ServerURL:  serverURL,
+		ConfigData: configContent,
+	}); err != nil {
+		return nil, fmt.Errorf("应用配置失败: %w", err)
+	}
+
+	// 4. 保存模块配置文件
+	cfg.Module.ID = enrolled.ModuleID
+	cfg.Module.Name = enrolled.ModuleName
+	cfg.Module.Location = enrolled.Location
+	cfg.Module.PrivateKey = keyPair.PrivateKey
+	cfg.Module.ServerEndpoint = enrolled.ServerEndpoint
+	cfg.Module.APIKey = enrolled.APIKey
+	cfg.Server.URL = serverURL
+
+	actualPath := config.ResolveConfigPath(configPath)
+	if err := config.SaveModuleConfig(cfg, actualPath); err != nil {
+		return nil, err
+	}
+
+	log.Printf("模块注册成功: ID=%d, 名称=%s, 配置已保存到 %s", enrolled.ModuleID, enrolled.ModuleName, actualPath)
+
+	return enrolled, nil
+}
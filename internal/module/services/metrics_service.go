@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/metrics"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+// networkMetricsInterval 网络质量测量间隔，ping测量耗时较长，不在抓取时同步执行
+const networkMetricsInterval = time.Minute
+
+// MetricsService 模块端Prometheus指标
+type MetricsService struct {
+	config        *config.ModuleConfig
+	statusService *StatusService
+
+	mu              sync.RWMutex
+	network         *NetworkMetrics
+	networkMeasured time.Time
+}
+
+// NewMetricsService 创建指标服务
+func NewMetricsService(cfg *config.ModuleConfig, statusService *StatusService) *MetricsService {
+	return &MetricsService{
+		config:        cfg,
+		statusService: statusService,
+	}
+}
+
+// Run 定期测量网络延迟和丢包率，直到ctx取消
+func (ms *MetricsService) Run(ctx context.Context) {
+	ticker := time.NewTicker(networkMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		ms.measureNetwork()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// measureNetwork 测量并缓存网络性能指标
+func (ms *MetricsService) measureNetwork() {
+	network, err := ms.statusService.GetNetworkMetrics()
+	if err != nil {
+		log.Printf("测量网络指标失败: %v", err)
+		return
+	}
+
+	ms.mu.Lock()
+	ms.network = network
+	ms.networkMeasured = time.Now()
+	ms.mu.Unlock()
+}
+
+// Collect 采集当前指标
+func (ms *MetricsService) Collect() []*metrics.Family {
+	families := ms.collectTunnel()
+	families = append(families, ms.collectNetwork()...)
+	families = append(families, ms.collectHost()...)
+	return families
+}
+
+// collectTunnel 采集隧道状态、握手和流量指标
+func (ms *MetricsService) collectTunnel() []*metrics.Family {
+	name := ms.config.WireGuard.Interface
+	tunnelUp := metrics.NewGauge("eitec_vpn_module_tunnel_up", "WireGuard接口是否在运行")
+	tunnelConnected := metrics.NewGauge("eitec_vpn_module_tunnel_connected", "是否在超时时间内与对端完成握手")
+	peerHandshake := metrics.NewGauge("eitec_vpn_module_peer_last_handshake_age_seconds", "距对等端最近一次握手的秒数，从未握手的对等端不输出")
+	peerRx := metrics.NewCounter("eitec_vpn_module_peer_receive_bytes_total", "从对等端接收的字节数（自接口启动）")
+	peerTx := metrics.NewCounter("eitec_vpn_module_peer_transmit_bytes_total", "向对等端发送的字节数（自接口启动）")
+	families := []*metrics.Family{tunnelUp, tunnelConnected, peerHandshake, peerRx, peerTx}
+
+	device, err := wireguard.GetBackend().GetDevice(name)
+	if err != nil {
+		tunnelUp.Add(0, "interface", name)
+		tunnelConnected.Add(0, "interface", name)
+		return families
+	}
+	tunnelUp.Add(1, "interface", name)
+
+	now := time.Now()
+	connected := 0.0
+	for _, peer := range device.Peers {
+		labels := []string{"interface", name, "public_key", peer.PublicKey, "endpoint", peer.Endpoint}
+		peerRx.Add(float64(peer.ReceiveBytes), labels...)
+		peerTx.Add(float64(peer.TransmitBytes), labels...)
+		if peer.LatestHandshake.IsZero() {
+			continue
+		}
+		age := now.Sub(peer.LatestHandshake)
+		peerHandshake.Add(age.Seconds(), labels...)
+		if age <= config.WireGuardOnlineTimeout {
+			connected = 1
+		}
+	}
+	tunnelConnected.Add(connected, "interface", name)
+
+	return families
+}
+
+// collectNetwork 输出最近一次测量的网络延迟和丢包率
+func (ms *MetricsService) collectNetwork() []*metrics.Family {
+	latency := metrics.NewGauge("eitec_vpn_module_ping_latency_seconds", "到服务器的平均ping延迟（秒）")
+	loss := metrics.NewGauge("eitec_vpn_module_ping_packet_loss_ratio", "到服务器的ping丢包率（0-1）")
+	measured := metrics.NewGauge("eitec_vpn_module_network_measured_timestamp_seconds", "最近一次网络测量的时间")
+	families := []*metrics.Family{latency, loss, measured}
+
+	ms.mu.RLock()
+	network, at := ms.network, ms.networkMeasured
+	ms.mu.RUnlock()
+
+	// 未连接时没有测量结果，不输出延迟和丢包率
+	if network == nil || network.Status != "connected" {
+		return families
+	}
+	latency.Add(float64(network.Latency) / 1000)
+	loss.Add(network.PacketLoss / 100)
+	measured.Add(float64(at.Unix()))
+
+	return families
+}
+
+// collectHost 采集主机CPU、内存和磁盘使用情况
+func (ms *MetricsService) collectHost() []*metrics.Family {
+	cpuUsage := metrics.NewGauge("eitec_vpn_module_cpu_usage_ratio", "CPU使用率（0-1，自上次抓取以来）")
+	memoryTotal := metrics.NewGauge("eitec_vpn_module_memory_total_bytes", "内存总量")
+	memoryUsed := metrics.NewGauge("eitec_vpn_module_memory_used_bytes", "已用内存")
+	diskTotal := metrics.NewGauge("eitec_vpn_module_disk_total_bytes", "根分区容量")
+	diskUsed := metrics.NewGauge("eitec_vpn_module_disk_used_bytes", "根分区已用容量")
+	families := []*metrics.Family{cpuUsage, memoryTotal, memoryUsed, diskTotal, diskUsed}
+
+	// 间隔为0时返回与上次调用之间的平均使用率，不阻塞抓取
+	if percent, err := cpu.Percent(0, false); err == nil && len(percent) > 0 {
+		cpuUsage.Add(percent[0] / 100)
+	}
+	if memory, err := ms.statusService.getMemoryInfoV2(); err == nil {
+		memoryTotal.Add(float64(memory.Total))
+		memoryUsed.Add(float64(memory.Used))
+	}
+	if disk, err := ms.statusService.getDiskInfoV2(); err == nil {
+		diskTotal.Add(float64(disk.Total))
+		diskUsed.Add(float64(disk.Used))
+	}
+
+	return families
+}
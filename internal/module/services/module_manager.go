@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/wireguard"
 )
 
 // ModuleManager 模块管理器
@@ -16,8 +17,10 @@ type ModuleManager struct {
 	server        *http.Server
 	moduleService *ModuleService
 	statusService *StatusService
+	metrics       *MetricsService
 	serverClient  *ServerClient
 	wgManager     *WireGuardManager
+	configETag    string // 最近一次从服务器同步的配置ETag
 	ctx           context.Context
 	cancel        context.CancelFunc
 }
@@ -29,6 +32,7 @@ func NewModuleManager(cfg *config.ModuleConfig) (*ModuleManager, error) {
 	// 创建各种服务
 	moduleService := NewModuleService(cfg)
 	statusService := NewStatusService(cfg)
+	metricsService := NewMetricsService(cfg, statusService)
 	serverClient := NewServerClient(cfg)
 	wgManager := NewWireGuardManager(cfg)
 
@@ -36,6 +40,7 @@ func NewModuleManager(cfg *config.ModuleConfig) (*ModuleManager, error) {
 		config:        cfg,
 		moduleService: moduleService,
 		statusService: statusService,
+		metrics:       metricsService,
 		serverClient:  serverClient,
 		wgManager:     wgManager,
 		ctx:           ctx,
@@ -55,6 +60,11 @@ func (mm *ModuleManager) GetServices() (*ModuleService, *StatusService) {
 	return mm.moduleService, mm.statusService
 }
 
+// GetMetricsService 获取指标服务（用于注册/metrics）
+func (mm *ModuleManager) GetMetricsService() *MetricsService {
+	return mm.metrics
+}
+
 // Start 启动模块管理器
 func (mm *ModuleManager) Start() error {
 	log.Println("启动模块管理器...")
@@ -75,7 +85,18 @@ func (mm *ModuleManager) Start() error {
 		log.Println("模块未配置，请通过Web界面完成配置")
 	}
 
-	// 3. 启动HTTP服务器（如果已设置）
+	// 3. 启动与服务器的通信（如果已配置服务器地址和API密钥）
+	if mm.isServerConfigured() {
+		go mm.runAgentLoop()
+		log.Printf("已启动服务器通信: %s", mm.config.Server.URL)
+	} else {
+		log.Println("未配置服务器地址或API密钥，跳过心跳和配置同步")
+	}
+
+	// 4. 定期测量网络质量供/metrics输出
+	go mm.metrics.Run(mm.ctx)
+
+	// 5. 启动HTTP服务器（如果已设置）
 	if mm.server != nil {
 		go func() {
 			log.Printf("模块Web界面启动在端口 %s", mm.server.Addr)
@@ -93,6 +114,9 @@ func (mm *ModuleManager) Start() error {
 func (mm *ModuleManager) Stop() error {
 	log.Println("停止模块管理器...")
 
+	// 停止后台任务
+	mm.cancel()
+
 	// 1. 停止HTTP服务器
 	if mm.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -133,10 +157,98 @@ func (mm *ModuleManager) isConfigured() bool {
 	}
 
 	// 检查WireGuard配置文件是否存在
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		return false
 	}
 
 	return true
 }
+
+// isServerConfigured 检查是否已配置服务器通信
+func (mm *ModuleManager) isServerConfigured() bool {
+	return mm.config.Server.URL != "" && mm.config.Module.ID != 0 && mm.config.Module.APIKey != ""
+}
+
+// runAgentLoop 定期向服务器发送心跳、上报流量并同步配置
+func (mm *ModuleManager) runAgentLoop() {
+	heartbeatTicker := time.NewTicker(agentInterval(mm.config.Server.HeartbeatInterval, 30))
+	defer heartbeatTicker.Stop()
+	reportTicker := time.NewTicker(agentInterval(mm.config.Server.ReportInterval, 60))
+	defer reportTicker.Stop()
+	syncTicker := time.NewTicker(agentInterval(mm.config.Server.SyncInterval, 300))
+	defer syncTicker.Stop()
+
+	// 启动后立即上报一次心跳并同步配置
+	mm.sendHeartbeat()
+	mm.syncConfiguration()
+
+	for {
+		select {
+		case <-mm.ctx.Done():
+			return
+		case <-heartbeatTicker.C:
+			mm.sendHeartbeat()
+		case <-reportTicker.C:
+			mm.reportTraffic()
+		case <-syncTicker.C:
+			mm.syncConfiguration()
+		}
+	}
+}
+
+// sendHeartbeat 发送心跳
+func (mm *ModuleManager) sendHeartbeat() {
+	heartbeat := &HeartbeatRequest{
+		WireGuardRunning: mm.wgManager.IsRunning(),
+	}
+	if err := mm.serverClient.SendHeartbeat(heartbeat); err != nil {
+		log.Printf("发送心跳失败: %v", err)
+	}
+}
+
+// reportTraffic 上报流量统计
+func (mm *ModuleManager) reportTraffic() {
+	stats, err := mm.statusService.GetTrafficStats()
+	if err != nil {
+		log.Printf("获取流量统计失败: %v", err)
+		return
+	}
+	if err := mm.serverClient.ReportTraffic(*stats); err != nil {
+		log.Printf("上报流量统计失败: %v", err)
+	}
+}
+
+// syncConfiguration 从服务器同步配置，仅在配置变化时应用
+func (mm *ModuleManager) syncConfiguration() {
+	configContent, etag, err := mm.serverClient.GetConfiguration(mm.configETag)
+	if err != nil {
+		log.Printf("同步配置失败: %v", err)
+		return
+	}
+
+	// 配置未变化
+	if configContent == nil {
+		return
+	}
+
+	// 通过加入令牌注册的模块，服务器下发的配置不含私钥，使用本地私钥补全
+	if mm.config.Module.PrivateKey != "" {
+		configContent = []byte(wireguard.InjectPrivateKey(string(configContent), mm.config.Module.PrivateKey))
+	}
+
+	if err := mm.wgManager.UpdateConfig(configContent); err != nil {
+		log.Printf("应用配置失败: %v", err)
+		return
+	}
+
+	mm.configETag = etag
+}
+
+// agentInterval 将秒数转换为时间间隔，无效时使用默认值
+func agentInterval(seconds, defaultSeconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
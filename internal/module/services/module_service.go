@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,19 +10,27 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"eitec-vpn/internal/shared/config"
 	"eitec-vpn/internal/shared/utils"
+	"eitec-vpn/internal/shared/wgconf"
+	"eitec-vpn/internal/shared/wireguard"
 )
 
 // 配置路径常量
 const (
-	DefaultWireGuardConfigPath = "/etc/wireguard/wg0.conf"
-	DefaultModuleConfigDir     = "/etc/eitec-vpn"
-	DefaultModuleInfoPath      = "/etc/eitec-vpn/module.info"
+	DefaultModuleConfigDir = "/etc/eitec-vpn"
+	DefaultModuleInfoPath  = "/etc/eitec-vpn/module.info"
 )
 
+// ErrAlreadyConfigured 模块已完成初始配置，不再接受未认证的配置请求
+var ErrAlreadyConfigured = errors.New("模块已完成初始配置，请登录后由管理员修改配置")
+
+// initialSetupMu 串行化初始配置，避免并发请求都通过未配置检查
+var initialSetupMu sync.Mutex
+
 // WireGuardInterface WireGuard接口信息
 type WireGuardInterface struct {
 	Name       string `json:"name"`        // 接口名称，如 wg0, wg1
@@ -47,7 +56,7 @@ func NewModuleService(cfg *config.ModuleConfig) *ModuleService {
 
 	wgConfPath := os.Getenv("WIREGUARD_CONFIG_PATH")
 	if wgConfPath == "" {
-		wgConfPath = DefaultWireGuardConfigPath
+		wgConfPath = wireguard.ConfigPath("wg0")
 	}
 
 	return &ModuleService{
@@ -103,7 +112,7 @@ func (ms *ModuleService) GetModuleInfo() *ModuleInfo {
 // IsConfigured 检查是否已配置
 func (ms *ModuleService) IsConfigured() bool {
 	// 检查配置文件是否存在
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		return false
 	}
@@ -118,15 +127,24 @@ func (ms *ModuleService) IsConfigured() bool {
 
 // IsWireGuardRunning 检查WireGuard是否运行
 func (ms *ModuleService) IsWireGuardRunning() bool {
-	cmd := exec.Command("wg", "show", "wg0")
-	err := cmd.Run()
-	return err == nil
+	return wireguard.IsInterfaceUp("wg0")
+}
+
+// ApplyInitialSetup 应用首次配置，模块已配置时返回 ErrAlreadyConfigured
+func (ms *ModuleService) ApplyInitialSetup(setup *SetupInfo) error {
+	initialSetupMu.Lock()
+	defer initialSetupMu.Unlock()
+
+	if ms.IsConfigured() {
+		return ErrAlreadyConfigured
+	}
+	return ms.ApplySetup(setup)
 }
 
 // ApplySetup 应用设置
 func (ms *ModuleService) ApplySetup(setup *SetupInfo) error {
 	// 写入WireGuard配置文件
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 	if err := os.WriteFile(configPath, []byte(setup.ConfigData), 0600); err != nil {
 		return fmt.Errorf("写入配置文件失败: %v", err)
 	}
@@ -183,34 +201,22 @@ func (ms *ModuleService) isResolvconfAvailable() bool {
 
 // createDNSFriendlyConfig 创建兼容的配置文件
 func (ms *ModuleService) createDNSFriendlyConfig() error {
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 
 	// 读取当前配置
-	content, err := os.ReadFile(configPath)
+	conf, err := wgconf.ParseFile(configPath)
 	if err != nil {
 		return fmt.Errorf("读取配置文件失败: %v", err)
 	}
 
-	configStr := string(content)
-
 	// 如果配置包含DNS设置，创建一个不包含DNS的备用配置
-	if strings.Contains(configStr, "DNS") {
+	if len(conf.Interface.DNS) > 0 {
 		// 移除DNS行，避免触发resolvconf
-		lines := strings.Split(configStr, "\n")
-		var newLines []string
-
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if !strings.HasPrefix(line, "DNS") {
-				newLines = append(newLines, line)
-			}
-		}
-
-		newConfig := strings.Join(newLines, "\n")
+		conf.Interface.DNS = nil
 
 		// 创建备用配置文件
 		backupPath := configPath + ".nodns"
-		if err := os.WriteFile(backupPath, []byte(newConfig), 0600); err != nil {
+		if err := conf.WriteFile(backupPath); err != nil {
 			return fmt.Errorf("创建备用配置失败: %v", err)
 		}
 
@@ -220,7 +226,7 @@ func (ms *ModuleService) createDNSFriendlyConfig() error {
 	return nil
 }
 
-// stopWireGuardWithTimeout 带超时的停止WireGuard
+// stopWireGuardWithTimeout 停止WireGuard
 func (ms *ModuleService) stopWireGuardWithTimeout() error {
 	// 首先检查接口是否存在
 	if !ms.wireGuardInterfaceExists() {
@@ -228,28 +234,15 @@ func (ms *ModuleService) stopWireGuardWithTimeout() error {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "wg-quick", "down", "wg0")
-	output, err := cmd.CombinedOutput()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("停止WireGuard超时")
-	}
-
-	if err != nil {
-		// 检查是否是"接口不存在"的错误
-		outputStr := string(output)
-		if strings.Contains(outputStr, "is not a WireGuard interface") ||
-			strings.Contains(outputStr, "does not exist") {
+	if err := wireguard.QuickDown("wg0", wireguard.ConfigPath("wg0")); err != nil {
+		if errors.Is(err, wireguard.ErrDeviceNotFound) {
 			log.Println("WireGuard接口已经不存在，无需停止")
 			return nil
 		}
 
 		// 其他错误，尝试强制清理
 		ms.forceCleanupWireGuard()
-		return fmt.Errorf("停止WireGuard失败: %v, 输出: %s", err, output)
+		return fmt.Errorf("停止WireGuard失败: %v", err)
 	}
 
 	return nil
@@ -257,60 +250,47 @@ func (ms *ModuleService) stopWireGuardWithTimeout() error {
 
 // wireGuardInterfaceExists 检查WireGuard接口是否存在
 func (ms *ModuleService) wireGuardInterfaceExists() bool {
-	// 方法1: 使用 wg show 命令检查
-	cmd := exec.Command("wg", "show", "wg0")
-	if err := cmd.Run(); err == nil {
-		return true
-	}
-
-	// 方法2: 使用 ip link show 命令检查
-	cmd = exec.Command("ip", "link", "show", "wg0")
-	if err := cmd.Run(); err == nil {
-		return true
-	}
-
-	return false
+	return wireguard.GetBackend().DeviceExists("wg0")
 }
 
 // startWireGuardWithTimeout 带超时的启动WireGuard
 func (ms *ModuleService) startWireGuardWithTimeout() error {
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 
-	// 首先尝试使用wg-quick启动
-	if err := ms.attemptStartWithConfig(configPath); err != nil {
-		log.Printf("使用wg-quick启动失败: %v", err)
-		log.Println("尝试使用手动方式启动WireGuard")
+	// 首先通过netlink直接启动（不依赖wireguard-tools）
+	if err := ms.manualStartWireGuard(configPath); err != nil {
+		log.Printf("通过netlink启动失败: %v", err)
+		log.Println("尝试使用wg-quick启动WireGuard")
 
-		// 尝试手动启动（不依赖wg-quick）
-		if err := ms.manualStartWireGuard(configPath); err != nil {
-			log.Printf("手动启动也失败: %v", err)
+		// 回退到wg-quick（例如内核不支持WireGuard时使用用户态实现）
+		if err := ms.attemptStartWithConfig(configPath); err != nil {
+			log.Printf("使用wg-quick启动也失败: %v", err)
 
 			// 如果失败且存在备用配置，尝试使用备用配置
 			backupPath := configPath + ".nodns"
 			if _, statErr := os.Stat(backupPath); statErr == nil {
-				log.Println("尝试使用无DNS配置手动启动")
+				log.Println("尝试使用无DNS配置启动")
 
 				// 临时替换配置文件
 				if err := ms.useBackupConfig(configPath, backupPath); err != nil {
 					return fmt.Errorf("切换到备用配置失败: %v", err)
 				}
 
-				// 尝试手动启动
-				if err := ms.manualStartWireGuard(configPath); err != nil {
-					return fmt.Errorf("使用备用配置手动启动也失败: %v", err)
+				if err := ms.attemptStartWithConfig(configPath); err != nil {
+					return fmt.Errorf("使用备用配置启动也失败: %v", err)
 				}
 
 				// 启动成功后手动设置DNS
 				go ms.setDNSManually()
 
-				log.Println("WireGuard已使用备用配置手动启动，DNS将手动设置")
+				log.Println("WireGuard已使用备用配置启动，DNS将手动设置")
 				return nil
 			}
 
 			return err
 		}
 
-		log.Println("WireGuard手动启动成功")
+		log.Println("WireGuard通过wg-quick启动成功")
 		return nil
 	}
 
@@ -375,188 +355,24 @@ func (ms *ModuleService) attemptStartWithConfig(configPath string) error {
 	return nil
 }
 
-// manualStartWireGuard 手动启动WireGuard（不依赖wg-quick）
+// manualStartWireGuard 通过netlink直接启动WireGuard（不依赖wireguard-tools）
 func (ms *ModuleService) manualStartWireGuard(configPath string) error {
-	log.Println("开始手动启动WireGuard")
+	log.Println("开始通过netlink启动WireGuard")
 
-	// 解析配置文件
-	config, err := ms.parseWireGuardConfig(configPath)
-	if err != nil {
-		return fmt.Errorf("解析配置文件失败: %v", err)
-	}
-
-	// 1. 创建网络接口
-	log.Println("步骤1: 创建WireGuard接口")
-	if err := ms.runCommandWithTimeout("ip", []string{"link", "add", "wg0", "type", "wireguard"}, 5); err != nil {
-		return fmt.Errorf("创建接口失败: %v", err)
-	}
-
-	// 2. 设置私钥和端口
-	log.Println("步骤2: 配置私钥")
-	if err := ms.setWireGuardPrivateKey(config.PrivateKey); err != nil {
-		ms.runCommandWithTimeout("ip", []string{"link", "delete", "wg0"}, 3)
-		return fmt.Errorf("设置私钥失败: %v", err)
-	}
-
-	// 3. 配置IP地址
-	log.Println("步骤3: 设置IP地址")
-	if err := ms.runCommandWithTimeout("ip", []string{"-4", "address", "add", config.Address, "dev", "wg0"}, 5); err != nil {
-		ms.runCommandWithTimeout("ip", []string{"link", "delete", "wg0"}, 3)
-		return fmt.Errorf("设置IP地址失败: %v", err)
-	}
-
-	// 4. 设置MTU
-	if config.MTU != "" {
-		log.Printf("步骤4: 设置MTU为%s", config.MTU)
-		if err := ms.runCommandWithTimeout("ip", []string{"link", "set", "mtu", config.MTU, "dev", "wg0"}, 5); err != nil {
-			log.Printf("设置MTU失败: %v", err) // 不是致命错误
-		}
-	}
-
-	// 5. 启动接口
-	log.Println("步骤5: 启动网络接口")
-	if err := ms.runCommandWithTimeout("ip", []string{"link", "set", "up", "dev", "wg0"}, 5); err != nil {
-		ms.runCommandWithTimeout("ip", []string{"link", "delete", "wg0"}, 3)
-		return fmt.Errorf("启动接口失败: %v", err)
-	}
-
-	// 6. 配置Peer（如果有）
-	if config.PeerPublicKey != "" {
-		log.Println("步骤6: 配置Peer")
-		if err := ms.configurePeer(config); err != nil {
-			ms.runCommandWithTimeout("ip", []string{"link", "delete", "wg0"}, 3)
-			return fmt.Errorf("配置Peer失败: %v", err)
-		}
+	if err := wireguard.QuickUp("wg0", configPath); err != nil {
+		return err
 	}
 
-	// 7. 验证接口状态
-	log.Println("步骤7: 验证接口状态")
+	// 验证接口状态
 	if !ms.IsWireGuardRunning() {
-		ms.runCommandWithTimeout("ip", []string{"link", "delete", "wg0"}, 3)
+		wireguard.GetBackend().DeleteDevice("wg0")
 		return fmt.Errorf("接口创建成功但未运行")
 	}
 
-	log.Println("WireGuard手动启动成功")
+	log.Println("WireGuard通过netlink启动成功")
 	return nil
 }
 
-// 简化的配置结构
-type WireGuardConfig struct {
-	PrivateKey     string
-	Address        string
-	MTU            string
-	PeerPublicKey  string
-	PeerEndpoint   string
-	PeerAllowedIPs string
-}
-
-// parseWireGuardConfig 解析WireGuard配置文件
-func (ms *ModuleService) parseWireGuardConfig(configPath string) (*WireGuardConfig, error) {
-	content, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, err
-	}
-
-	config := &WireGuardConfig{}
-	lines := strings.Split(string(content), "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "PrivateKey") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				config.PrivateKey = strings.TrimSpace(parts[1])
-			}
-		} else if strings.HasPrefix(line, "Address") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				config.Address = strings.TrimSpace(parts[1])
-			}
-		} else if strings.HasPrefix(line, "MTU") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				config.MTU = strings.TrimSpace(parts[1])
-			}
-		} else if strings.HasPrefix(line, "PublicKey") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				config.PeerPublicKey = strings.TrimSpace(parts[1])
-			}
-		} else if strings.HasPrefix(line, "Endpoint") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				config.PeerEndpoint = strings.TrimSpace(parts[1])
-			}
-		} else if strings.HasPrefix(line, "AllowedIPs") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				config.PeerAllowedIPs = strings.TrimSpace(parts[1])
-			}
-		}
-	}
-
-	return config, nil
-}
-
-// runCommandWithTimeout 运行带超时的命令
-func (ms *ModuleService) runCommandWithTimeout(command string, args []string, timeoutSec int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSec)*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, command, args...)
-	output, err := cmd.CombinedOutput()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("命令超时: %s %v", command, args)
-	}
-
-	if err != nil {
-		return fmt.Errorf("命令失败: %s %v, 错误: %v, 输出: %s", command, args, err, output)
-	}
-
-	log.Printf("命令成功: %s %v", command, args)
-	return nil
-}
-
-// setWireGuardPrivateKey 设置WireGuard私钥
-func (ms *ModuleService) setWireGuardPrivateKey(privateKey string) error {
-	if privateKey == "" {
-		return fmt.Errorf("私钥为空")
-	}
-
-	// 使用echo和管道传递私钥给wg命令
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "sh", "-c", fmt.Sprintf("echo '%s' | wg set wg0 private-key /dev/stdin", privateKey))
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		return fmt.Errorf("设置私钥失败: %v, 输出: %s", err, output)
-	}
-
-	return nil
-}
-
-// configurePeer 配置Peer
-func (ms *ModuleService) configurePeer(config *WireGuardConfig) error {
-	if config.PeerPublicKey == "" {
-		return nil // 没有Peer配置
-	}
-
-	args := []string{"set", "wg0", "peer", config.PeerPublicKey}
-
-	if config.PeerEndpoint != "" {
-		args = append(args, "endpoint", config.PeerEndpoint)
-	}
-
-	if config.PeerAllowedIPs != "" {
-		args = append(args, "allowed-ips", config.PeerAllowedIPs)
-	}
-
-	return ms.runCommandWithTimeout("wg", args, 5)
-}
-
 // useBackupConfig 使用备用配置
 func (ms *ModuleService) useBackupConfig(originalPath, backupPath string) error {
 	// 备份原始配置
@@ -642,7 +458,7 @@ func (ms *ModuleService) tryIPRoute(dnsServers []string) bool {
 // forceCleanupWireGuard 强制清理WireGuard接口
 func (ms *ModuleService) forceCleanupWireGuard() {
 	// 尝试删除接口
-	exec.Command("ip", "link", "delete", "wg0").Run()
+	wireguard.GetBackend().DeleteDevice("wg0")
 
 	// 清理可能的iptables规则
 	exec.Command("iptables", "-D", "FORWARD", "-i", "wg0", "-j", "ACCEPT").Run()
@@ -652,10 +468,8 @@ func (ms *ModuleService) forceCleanupWireGuard() {
 
 // StopWireGuard 停止WireGuard
 func (ms *ModuleService) StopWireGuard() error {
-	cmd := exec.Command("wg-quick", "down", "wg0")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("停止WireGuard失败: %v, 输出: %s", err, output)
+	if err := wireguard.QuickDown("wg0", wireguard.ConfigPath("wg0")); err != nil {
+		return fmt.Errorf("停止WireGuard失败: %v", err)
 	}
 
 	return nil
@@ -674,7 +488,7 @@ func (ms *ModuleService) RestartWireGuard() error {
 
 // GetWireGuardConfig 获取WireGuard配置
 func (ms *ModuleService) GetWireGuardConfig() (string, error) {
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 	content, err := os.ReadFile(configPath)
 	if err != nil {
 		return "", fmt.Errorf("读取配置文件失败: %v", err)
@@ -685,7 +499,7 @@ func (ms *ModuleService) GetWireGuardConfig() (string, error) {
 
 // UpdateWireGuardConfig 更新WireGuard配置
 func (ms *ModuleService) UpdateWireGuardConfig(config string) error {
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 
 	// 备份当前配置
 	backupPath := configPath + ".backup"
@@ -721,7 +535,7 @@ func (ms *ModuleService) ResetConfiguration() error {
 	ms.StopWireGuard()
 
 	// 删除配置文件
-	configPath := DefaultWireGuardConfigPath
+	configPath := wireguard.ConfigPath("wg0")
 	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("删除配置文件失败: %v", err)
 	}
@@ -836,20 +650,25 @@ func (ms *ModuleService) GetSystemInfo() map[string]interface{} {
 
 // ValidateConfig 验证配置
 func (ms *ModuleService) ValidateConfig(config string) error {
-	// 检查配置格式
-	if !strings.Contains(config, "[Interface]") {
-		return fmt.Errorf("配置缺少 [Interface] 部分")
+	// 检查配置格式和字段取值
+	conf, err := wgconf.Parse(config)
+	if err != nil {
+		return err
 	}
-
-	if !strings.Contains(config, "[Peer]") {
-		return fmt.Errorf("配置缺少 [Peer] 部分")
+	if err := conf.Validate(); err != nil {
+		return err
 	}
 
 	// 检查必要字段
-	requiredFields := []string{"PrivateKey", "Address", "PublicKey", "Endpoint"}
-	for _, field := range requiredFields {
-		if !strings.Contains(config, field) {
-			return fmt.Errorf("配置缺少必要字段: %s", field)
+	if len(conf.Interface.Address) == 0 {
+		return fmt.Errorf("配置缺少必要字段: Address")
+	}
+	if len(conf.Peers) == 0 {
+		return fmt.Errorf("配置缺少 [Peer] 部分")
+	}
+	for _, peer := range conf.Peers {
+		if peer.Endpoint == "" {
+			return fmt.Errorf("配置缺少必要字段: Endpoint")
 		}
 	}
 
@@ -864,7 +683,7 @@ func (ms *ModuleService) GetWireGuardInterfaces() ([]WireGuardInterface, error)
 	commonNames := []string{"wg0", "wg1", "wg2", "wg3"}
 
 	for _, name := range commonNames {
-		configPath := fmt.Sprintf("/etc/wireguard/%s.conf", name)
+		configPath := wireguard.ConfigPath(name)
 		interfaceInfo := WireGuardInterface{
 			Name:       name,
 			ConfigPath: configPath,
@@ -893,8 +712,7 @@ func (ms *ModuleService) GetWireGuardInterfaces() ([]WireGuardInterface, error)
 
 // isInterfaceRunning 检查指定接口是否运行
 func (ms *ModuleService) isInterfaceRunning(interfaceName string) bool {
-	cmd := exec.Command("wg", "show", interfaceName)
-	return cmd.Run() == nil
+	return wireguard.IsInterfaceUp(interfaceName)
 }
 
 // StartWireGuardInterface 启动指定的WireGuard接口
@@ -903,10 +721,9 @@ func (ms *ModuleService) StartWireGuardInterface(interfaceName string) error {
 		return fmt.Errorf("接口 %s 未配置", interfaceName)
 	}
 
-	cmd := exec.Command("wg-quick", "up", interfaceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("启动接口 %s 失败: %v, 输出: %s", interfaceName, err, output)
+	configPath := wireguard.ConfigPath(interfaceName)
+	if err := wireguard.QuickUp(interfaceName, configPath); err != nil {
+		return fmt.Errorf("启动接口 %s 失败: %v", interfaceName, err)
 	}
 
 	return nil
@@ -914,10 +731,9 @@ func (ms *ModuleService) StartWireGuardInterface(interfaceName string) error {
 
 // StopWireGuardInterface 停止指定的WireGuard接口
 func (ms *ModuleService) StopWireGuardInterface(interfaceName string) error {
-	cmd := exec.Command("wg-quick", "down", interfaceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("停止接口 %s 失败: %v, 输出: %s", interfaceName, err, output)
+	configPath := wireguard.ConfigPath(interfaceName)
+	if err := wireguard.QuickDown(interfaceName, configPath); err != nil {
+		return fmt.Errorf("停止接口 %s 失败: %v", interfaceName, err)
 	}
 
 	return nil
@@ -936,14 +752,14 @@ func (ms *ModuleService) RestartWireGuardInterface(interfaceName string) error {
 
 // isInterfaceConfigured 检查接口是否已配置
 func (ms *ModuleService) isInterfaceConfigured(interfaceName string) bool {
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", interfaceName)
+	configPath := wireguard.ConfigPath(interfaceName)
 	_, err := os.Stat(configPath)
 	return err == nil
 }
 
 // UpdateWireGuardConfigWithInterface 更新指定接口的WireGuard配置
 func (ms *ModuleService) UpdateWireGuardConfigWithInterface(interfaceName, config string) error {
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", interfaceName)
+	configPath := wireguard.ConfigPath(interfaceName)
 
 	// 备份当前配置
 	backupPath := configPath + ".backup"
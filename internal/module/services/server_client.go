@@ -52,11 +52,17 @@ func (sc *ServerClient) sendRequest(method, endpoint string, body interface{}) (
 	return sc.httpClient.Do(req)
 }
 
+// HeartbeatRequest 心跳请求
+type HeartbeatRequest struct {
+	WireGuardRunning bool   `json:"wireguard_running"`
+	PublicKey        string `json:"public_key,omitempty"`
+}
+
 // SendHeartbeat 发送心跳
-func (sc *ServerClient) SendHeartbeat() error {
-	endpoint := fmt.Sprintf("/api/v1/modules/%d/heartbeat", sc.config.Module.ID)
+func (sc *ServerClient) SendHeartbeat(heartbeat *HeartbeatRequest) error {
+	endpoint := fmt.Sprintf("/api/v1/agent/modules/%d/heartbeat", sc.config.Module.ID)
 
-	resp, err := sc.sendRequest("POST", endpoint, nil)
+	resp, err := sc.sendRequest("POST", endpoint, heartbeat)
 	if err != nil {
 		return fmt.Errorf("发送心跳请求失败: %w", err)
 	}
@@ -72,7 +78,7 @@ func (sc *ServerClient) SendHeartbeat() error {
 
 // ReportTraffic 上报流量统计
 func (sc *ServerClient) ReportTraffic(stats TrafficStats) error {
-	endpoint := fmt.Sprintf("/api/v1/modules/%d/traffic", sc.config.Module.ID)
+	endpoint := fmt.Sprintf("/api/v1/agent/modules/%d/traffic", sc.config.Module.ID)
 
 	resp, err := sc.sendRequest("POST", endpoint, stats)
 	if err != nil {
@@ -88,20 +94,38 @@ func (sc *ServerClient) ReportTraffic(stats TrafficStats) error {
 	return nil
 }
 
-// GetConfiguration 获取最新配置
-func (sc *ServerClient) GetConfiguration() ([]byte, error) {
-	endpoint := fmt.Sprintf("/api/v1/modules/%d/config", sc.config.Module.ID)
+// GetConfiguration 获取最新配置，配置未变化（与etag一致）时返回的配置为nil
+func (sc *ServerClient) GetConfiguration(etag string) ([]byte, string, error) {
+	url := fmt.Sprintf("%s/api/v1/agent/modules/%d/config", sc.config.Server.URL, sc.config.Module.ID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("X-API-Key", sc.config.Module.APIKey)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 
-	resp, err := sc.sendRequest("GET", endpoint, nil)
+	resp, err := sc.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("获取配置请求失败: %w", err)
+		return nil, "", fmt.Errorf("获取配置请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("服务器返回错误 %d: %s", resp.StatusCode, body)
+		return nil, "", fmt.Errorf("服务器返回错误 %d: %s", resp.StatusCode, body)
+	}
+
+	config, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("读取配置失败: %w", err)
 	}
 
-	return io.ReadAll(resp.Body)
+	return config, resp.Header.Get("ETag"), nil
 }
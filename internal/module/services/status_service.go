@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/wireguard"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -131,214 +132,27 @@ func (ss *StatusService) GetWireGuardStatus() (*WireGuardStatus, error) {
 		return status, nil
 	}
 
-	// 使用wg show wg0 dump命令获取更简洁的输出
-	cmd := exec.Command("wg", "show", "wg0", "dump")
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice("wg0")
 	if err != nil {
-		// 如果dump命令失败，尝试普通的wg show命令
-		cmd = exec.Command("wg", "show", "wg0")
-		output, err = cmd.Output()
-		if err != nil {
-			// 接口存在但命令失败，可能是配置问题
-			status.Status = "configured"
-			return status, nil
-		}
-		// 使用普通格式解析
-		return ss.parseWireGuardOutput(string(output))
-	}
-
-	// 使用dump格式解析
-	return ss.parseWireGuardDump(string(output))
-}
-
-// parseWireGuardDump 解析wg show wg0 dump输出
-func (ss *StatusService) parseWireGuardDump(output string) (*WireGuardStatus, error) {
-	status := &WireGuardStatus{
-		Interface:   "wg0",
-		Status:      "running",
-		LastUpdated: time.Now(),
-	}
-
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	if len(lines) < 1 {
+		// 接口存在但读取失败，可能是配置问题
+		status.Status = "configured"
 		return status, nil
 	}
 
-	// 第一行是interface信息
-	// 格式: private_key public_key listen_port status
-	interfaceFields := strings.Fields(lines[0])
-	if len(interfaceFields) >= 4 {
-		status.PublicKey = interfaceFields[1]
-		if port, err := strconv.Atoi(interfaceFields[2]); err == nil {
-			status.ListenPort = port
-		}
-		// interfaceFields[3] 是状态 (off/on)
-		if len(interfaceFields) >= 4 {
-			if interfaceFields[3] == "off" {
-				status.Status = "stopped"
-			} else {
-				status.Status = "running"
-			}
-		}
-	}
-
-	// 后续行是peer信息
-	// 格式: public_key preshared_key endpoint allowed_ips last_handshake rx_bytes tx_bytes persistent_keepalive
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) >= 8 {
-			peer := &PeerStatus{
-				PublicKey: fields[0],
-				Endpoint:  fields[2],
-			}
-
-			// 解析allowed ips
-			if len(fields) >= 4 {
-				peer.AllowedIPs = strings.Split(fields[3], ",")
-			}
-
-			// 解析last handshake (Unix timestamp)
-			if len(fields) >= 5 {
-				if timestamp, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
-					peer.LatestHandshake = time.Unix(timestamp, 0)
-				}
-			}
-
-			// 解析流量统计
-			if len(fields) >= 7 {
-				if rx, err := strconv.ParseUint(fields[5], 10, 64); err == nil {
-					peer.TransferRx = rx
-				}
-				if tx, err := strconv.ParseUint(fields[6], 10, 64); err == nil {
-					peer.TransferTx = tx
-				}
-			}
-
-			// 解析persistent keepalive
-			if len(fields) >= 8 {
-				if ka, err := strconv.Atoi(fields[7]); err == nil {
-					peer.PersistentKA = ka
-				}
-			}
-
-			status.Peers = append(status.Peers, *peer)
-		}
-	}
-
-	return status, nil
-}
-
-// parseWireGuardOutput 解析普通的wg show wg0输出（备用方法）
-func (ss *StatusService) parseWireGuardOutput(output string) (*WireGuardStatus, error) {
-	status := &WireGuardStatus{
-		Interface:   "wg0",
-		Status:      "stopped", // 默认为stopped，需要根据实际输出判断
-		LastUpdated: time.Now(),
-	}
-
-	lines := strings.Split(output, "\n")
-	var currentPeer *PeerStatus
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-
-		switch fields[0] {
-		case "interface:":
-			status.Interface = fields[1]
-		case "public":
-			if len(fields) >= 3 && fields[1] == "key:" {
-				status.PublicKey = fields[2]
-			}
-		case "listening":
-			if len(fields) >= 3 && fields[1] == "port:" {
-				if port, err := strconv.Atoi(fields[2]); err == nil {
-					status.ListenPort = port
-				}
-			}
-		case "peer:":
-			if currentPeer != nil {
-				status.Peers = append(status.Peers, *currentPeer)
-			}
-			currentPeer = &PeerStatus{
-				PublicKey: fields[1],
-			}
-		case "endpoint:":
-			if currentPeer != nil {
-				currentPeer.Endpoint = fields[1]
-			}
-		case "allowed":
-			if currentPeer != nil && len(fields) >= 3 && fields[1] == "ips:" {
-				ips := strings.Join(fields[2:], " ")
-				currentPeer.AllowedIPs = strings.Split(ips, ",")
-				for i, ip := range currentPeer.AllowedIPs {
-					currentPeer.AllowedIPs[i] = strings.TrimSpace(ip)
-				}
-			}
-		case "latest":
-			if currentPeer != nil && len(fields) >= 3 && fields[1] == "handshake:" {
-				timeStr := strings.Join(fields[2:], " ")
-				if strings.Contains(timeStr, "ago") {
-					currentPeer.LatestHandshake = time.Now().Add(-time.Minute)
-				} else if t, err := time.Parse("2006-01-02 15:04:05", timeStr); err == nil {
-					currentPeer.LatestHandshake = t
-				}
-			}
-		case "transfer:":
-			if currentPeer != nil && len(fields) >= 5 {
-				if rx, err := parseTransferSize(fields[1], fields[2]); err == nil {
-					currentPeer.TransferRx = rx
-				}
-				if len(fields) >= 6 {
-					if tx, err := parseTransferSize(fields[4], fields[5]); err == nil {
-						currentPeer.TransferTx = tx
-					}
-				}
-			}
-		case "persistent":
-			if currentPeer != nil && len(fields) >= 4 && fields[1] == "keepalive:" {
-				if ka, err := strconv.Atoi(fields[3]); err == nil {
-					currentPeer.PersistentKA = ka
-				}
-			}
-		}
-	}
-
-	if currentPeer != nil {
-		status.Peers = append(status.Peers, *currentPeer)
-	}
+	status.Status = "running"
+	status.PublicKey = device.PublicKey
+	status.ListenPort = device.ListenPort
 
-	// 根据是否有peer和handshake时间来判断状态
-	if len(status.Peers) > 0 {
-		// 检查最新的handshake时间
-		latestHandshake := time.Time{}
-		for _, peer := range status.Peers {
-			if peer.LatestHandshake.After(latestHandshake) {
-				latestHandshake = peer.LatestHandshake
-			}
-		}
-
-		// 如果handshake时间在2分钟内，认为是running状态
-		if time.Since(latestHandshake) < 2*time.Minute {
-			status.Status = "running"
-		} else {
-			status.Status = "stopped"
-		}
-	} else {
-		// 没有peer，可能是configured状态
-		status.Status = "configured"
+	for _, p := range device.Peers {
+		status.Peers = append(status.Peers, PeerStatus{
+			PublicKey:       p.PublicKey,
+			Endpoint:        p.Endpoint,
+			AllowedIPs:      p.AllowedIPs,
+			LatestHandshake: p.LatestHandshake,
+			TransferRx:      p.ReceiveBytes,
+			TransferTx:      p.TransmitBytes,
+			PersistentKA:    p.PersistentKeepalive,
+		})
 	}
 
 	return status, nil
@@ -350,50 +164,24 @@ func (ss *StatusService) GetTrafficStats() (*TrafficStats, error) {
 		LastUpdated: time.Now(),
 	}
 
-	// 直接执行 wg show wg0 dump 命令获取状态
-	cmd := exec.Command("wg", "show", "wg0", "dump")
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice("wg0")
 	if err != nil {
-		// 命令执行失败，说明接口不存在或未配置
-		return stats, nil
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) <= 1 {
-		// 没有peer，返回默认值
+		// 读取失败，说明接口不存在或未配置
 		return stats, nil
 	}
 
 	// 找到最新的handshake时间和对应的流量数据
 	latestHandshake := time.Time{}
 	var rxBytes, txBytes uint64
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) >= 7 {
-			// 第5个字段是last_handshake (Unix timestamp)
-			if timestamp, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
-				handshakeTime := time.Unix(timestamp, 0)
-				if handshakeTime.After(latestHandshake) {
-					latestHandshake = handshakeTime
-					// 第6、7个字段是rx_bytes和tx_bytes
-					if rx, err := strconv.ParseUint(fields[5], 10, 64); err == nil {
-						rxBytes = rx
-					}
-					if tx, err := strconv.ParseUint(fields[6], 10, 64); err == nil {
-						txBytes = tx
-					}
-				}
-			}
+	for _, peer := range device.Peers {
+		if peer.LatestHandshake.After(latestHandshake) {
+			latestHandshake = peer.LatestHandshake
+			rxBytes = peer.ReceiveBytes
+			txBytes = peer.TransmitBytes
 		}
 	}
 
 	// 基于handshake时间判断是否有活跃连接
-	// 注意：即使接口状态是"off"，如果有活跃的handshake，仍然认为连接是活跃的
 	if !latestHandshake.IsZero() && time.Since(latestHandshake) < 2*time.Minute {
 		stats.RxBytes = rxBytes
 		stats.TxBytes = txBytes
@@ -585,28 +373,6 @@ func (ss *StatusService) getNetworkInfo() (*NetworkInfo, error) {
 	return networkInfo, nil
 }
 
-// parseTransferSize 解析传输大小
-func parseTransferSize(sizeStr, unitStr string) (uint64, error) {
-	size, err := strconv.ParseFloat(sizeStr, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	unit := strings.ToLower(unitStr)
-	switch unit {
-	case "b", "bytes":
-		return uint64(size), nil
-	case "kib":
-		return uint64(size * 1024), nil
-	case "mib":
-		return uint64(size * 1024 * 1024), nil
-	case "gib":
-		return uint64(size * 1024 * 1024 * 1024), nil
-	default:
-		return uint64(size), nil
-	}
-}
-
 // IsHealthy 检查系统健康状态
 func (ss *StatusService) IsHealthy() bool {
 	// 检查WireGuard是否运行
@@ -745,24 +511,17 @@ func (ss *StatusService) GetVPNStatus() (*VPNStatus, error) {
 		Uptime:            "0秒",
 	}
 
-	// 直接执行 wg show wg0 dump 命令获取状态
-	cmd := exec.Command("wg", "show", "wg0", "dump")
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice("wg0")
 	if err != nil {
-		// 命令执行失败，说明接口不存在或未配置
+		// 读取失败，说明接口不存在或未配置
 		status.Status = "stopped"
 		status.ConnectionQuality = "disconnected"
 		status.Uptime = "接口未配置"
 		return status, nil
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) < 1 {
-		return status, nil
-	}
-
 	// 检查是否有peer连接
-	if len(lines) <= 1 {
+	if len(device.Peers) == 0 {
 		// 没有peer，可能是configured状态
 		status.Status = "configured"
 		status.ConnectionQuality = "unknown"
@@ -772,21 +531,9 @@ func (ss *StatusService) GetVPNStatus() (*VPNStatus, error) {
 
 	// 找到最新的handshake时间
 	latestHandshake := time.Time{}
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) >= 5 {
-			// 第5个字段是last_handshake (Unix timestamp)
-			if timestamp, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
-				handshakeTime := time.Unix(timestamp, 0)
-				if handshakeTime.After(latestHandshake) {
-					latestHandshake = handshakeTime
-				}
-			}
+	for _, peer := range device.Peers {
+		if peer.LatestHandshake.After(latestHandshake) {
+			latestHandshake = peer.LatestHandshake
 		}
 	}
 
@@ -802,7 +549,6 @@ func (ss *StatusService) GetVPNStatus() (*VPNStatus, error) {
 	timeSinceHandshake := time.Since(latestHandshake)
 
 	// 核心判断逻辑：如果handshake时间在2分钟内，说明在线
-	// 注意：即使接口状态是"off"，如果有活跃的handshake，仍然认为连接是活跃的
 	if timeSinceHandshake < 2*time.Minute {
 		status.Status = "running"
 
@@ -844,18 +590,15 @@ func formatDuration(d time.Duration) string {
 func (ss *StatusService) GetNetworkMetrics() (*NetworkMetrics, error) {
 	metrics := &NetworkMetrics{}
 
-	// 直接执行 wg show wg0 dump 命令获取状态
-	cmd := exec.Command("wg", "show", "wg0", "dump")
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice("wg0")
 	if err != nil {
-		// 命令执行失败，说明接口不存在或未配置
+		// 读取失败，说明接口不存在或未配置
 		metrics.Status = "disconnected"
 		metrics.Quality = "unknown"
 		return metrics, nil
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) <= 1 {
+	if len(device.Peers) == 0 {
 		// 没有peer，可能是configured状态
 		metrics.Status = "configured"
 		metrics.Quality = "unknown"
@@ -865,30 +608,14 @@ func (ss *StatusService) GetNetworkMetrics() (*NetworkMetrics, error) {
 	// 找到最新的handshake时间和对应的endpoint
 	latestHandshake := time.Time{}
 	var endpoint string
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) >= 5 {
-			// 第5个字段是last_handshake (Unix timestamp)
-			if timestamp, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
-				handshakeTime := time.Unix(timestamp, 0)
-				if handshakeTime.After(latestHandshake) {
-					latestHandshake = handshakeTime
-					// 第3个字段是endpoint
-					if len(fields) >= 3 {
-						endpoint = fields[2]
-					}
-				}
-			}
+	for _, peer := range device.Peers {
+		if peer.LatestHandshake.After(latestHandshake) {
+			latestHandshake = peer.LatestHandshake
+			endpoint = peer.Endpoint
 		}
 	}
 
 	// 基于handshake时间判断连接状态
-	// 注意：即使接口状态是"off"，如果有活跃的handshake，仍然认为连接是活跃的
 	timeSinceHandshake := time.Since(latestHandshake)
 	if timeSinceHandshake < 2*time.Minute {
 		// 2分钟内有handshake，说明连接是活跃的
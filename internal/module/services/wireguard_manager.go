@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/wireguard"
 )
 
 // WireGuardManager WireGuard管理器
@@ -19,7 +19,7 @@ type WireGuardManager struct {
 func NewWireGuardManager(cfg *config.ModuleConfig) *WireGuardManager {
 	configPath := os.Getenv("WIREGUARD_CONFIG_PATH")
 	if configPath == "" {
-		configPath = DefaultWireGuardConfigPath
+		configPath = wireguard.ConfigPath("wg0")
 	}
 
 	return &WireGuardManager{
@@ -33,13 +33,11 @@ func (wm *WireGuardManager) Start() error {
 	log.Println("启动WireGuard...")
 
 	// 停止现有的WireGuard实例
-	exec.Command("wg-quick", "down", "wg0").Run()
+	wireguard.QuickDown("wg0", wm.configPath)
 
 	// 启动WireGuard
-	cmd := exec.Command("wg-quick", "up", "wg0")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("启动WireGuard失败: %v, 输出: %s", err, output)
+	if err := wireguard.QuickUp("wg0", wm.configPath); err != nil {
+		return fmt.Errorf("启动WireGuard失败: %w", err)
 	}
 
 	log.Println("WireGuard启动成功")
@@ -49,8 +47,8 @@ func (wm *WireGuardManager) Start() error {
 // Stop 停止WireGuard
 func (wm *WireGuardManager) Stop() error {
 	log.Println("停止WireGuard...")
-	if err := exec.Command("wg-quick", "down", "wg0").Run(); err != nil {
-		return fmt.Errorf("停止WireGuard失败: %v", err)
+	if err := wireguard.QuickDown("wg0", wm.configPath); err != nil {
+		return fmt.Errorf("停止WireGuard失败: %w", err)
 	}
 	return nil
 }
@@ -92,60 +90,29 @@ func (wm *WireGuardManager) UpdateConfig(configContent []byte) error {
 
 // GetPublicKeyFromPrivate 从私钥生成公钥
 func (wm *WireGuardManager) GetPublicKeyFromPrivate(privateKey string) (string, error) {
-	// 临时文件存储私钥
-	tmpFile, err := os.CreateTemp("", "wg-private-*")
-	if err != nil {
-		return "", fmt.Errorf("创建临时文件失败: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	// 写入私钥
-	if _, err := tmpFile.WriteString(privateKey); err != nil {
-		return "", fmt.Errorf("写入私钥失败: %w", err)
-	}
-	tmpFile.Close()
-
-	// 使用wg工具生成公钥
-	cmd := exec.Command("wg", "pubkey")
-	stdinFile, err := os.Open(tmpFile.Name())
-	if err != nil {
-		return "", fmt.Errorf("打开临时文件失败: %w", err)
-	}
-	defer stdinFile.Close()
-	cmd.Stdin = stdinFile
-
-	output, err := cmd.Output()
+	publicKey, err := wireguard.PublicKeyFromPrivate(privateKey)
 	if err != nil {
 		return "", fmt.Errorf("生成公钥失败: %w", err)
 	}
-
-	// 去除换行符
-	publicKey := string(output)
-	if len(publicKey) > 0 && publicKey[len(publicKey)-1] == '\n' {
-		publicKey = publicKey[:len(publicKey)-1]
-	}
-
 	return publicKey, nil
 }
 
 // IsRunning 检查WireGuard是否运行
 func (wm *WireGuardManager) IsRunning() bool {
-	cmd := exec.Command("wg", "show", "wg0")
-	err := cmd.Run()
-	return err == nil
+	return wireguard.IsInterfaceUp("wg0")
 }
 
 // GetStatus 获取WireGuard状态
 func (wm *WireGuardManager) GetStatus() (map[string]interface{}, error) {
-	cmd := exec.Command("wg", "show", "wg0", "dump")
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice("wg0")
 	if err != nil {
 		return nil, fmt.Errorf("获取WireGuard状态失败: %w", err)
 	}
 
-	// 简化处理，返回原始输出
 	return map[string]interface{}{
-		"raw_output": string(output),
-		"running":    true,
+		"running":     true,
+		"public_key":  device.PublicKey,
+		"listen_port": device.ListenPort,
+		"peers":       device.Peers,
 	}, nil
 }
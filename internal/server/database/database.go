@@ -6,12 +6,17 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/envelope"
+	"eitec-vpn/internal/shared/ipaddr"
+	"eitec-vpn/internal/shared/lockout"
 	"eitec-vpn/internal/shared/utils"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -48,11 +53,29 @@ func InitDatabaseWithOptions(dbPath string, forceInit bool) error {
 	if err != nil {
 		return fmt.Errorf("连接数据库失败: %w", err)
 	}
+	if err := models.RegisterEncryptedCallbacks(DB); err != nil {
+		return fmt.Errorf("注册加密回调失败: %w", err)
+	}
+
+	// 敏感字段加密完成前，升级前保存的明文按原样读取
+	envelope.AllowPlaintext(true)
 
 	// 自动迁移数据库结构
 	if err := AutoMigrate(); err != nil {
 		return fmt.Errorf("数据库迁移失败: %w", err)
 	}
+	if err := migrateUserRoles(); err != nil {
+		return fmt.Errorf("数据库迁移失败: %w", err)
+	}
+	if err := migrateIPPool(); err != nil {
+		return fmt.Errorf("数据库迁移失败: %w", err)
+	}
+	if err := migrateIPAllocationOwners(); err != nil {
+		return fmt.Errorf("数据库迁移失败: %w", err)
+	}
+	if err := encryptLegacySecrets(); err != nil {
+		return fmt.Errorf("加密敏感字段失败: %w", err)
+	}
 
 	// 只有新数据库或强制初始化时才执行
 	if isNewDB || forceInit {
@@ -78,16 +101,192 @@ func AutoMigrate() error {
 		&models.Module{},
 		&models.User{},
 		&models.UserVPN{}, // 添加UserVPN模型
+		&models.UserVPNDevice{},
 		&models.SystemConfig{},
-		&models.IPPool{},
+		&models.IPAllocation{},
+		&models.IPReservation{},
+		&models.ModuleCredential{},
+		&models.ModuleJoinToken{},
+		&models.TrafficSample{},
+		&models.TrafficCounter{},
+		&models.UserSession{},
+		&models.RefreshToken{},
+		&models.AuditLog{},
+		&models.APIToken{},
 	)
 	if err != nil {
 		return fmt.Errorf("自动迁移失败: %w", err)
 	}
+	if err := protectAuditLogs(); err != nil {
+		return fmt.Errorf("自动迁移失败: %w", err)
+	}
 
+	// 登录失败锁定记录
+	if err := DB.AutoMigrate(lockout.Models()...); err != nil {
+		return fmt.Errorf("自动迁移失败: %w", err)
+	}
+
+	return nil
+}
+
+// protectAuditLogs 在数据库层禁止修改和删除审计日志，绕过模型钩子的SQL同样无法改写历史记录
+func protectAuditLogs() error {
+	for _, operation := range []string{"UPDATE", "DELETE"} {
+		trigger := fmt.Sprintf(
+			"CREATE TRIGGER IF NOT EXISTS audit_logs_no_%s BEFORE %s ON audit_logs BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END",
+			strings.ToLower(operation), operation)
+		if err := DB.Exec(trigger).Error; err != nil {
+			return fmt.Errorf("创建审计日志保护触发器失败: %w", err)
+		}
+	}
 	return nil
 }
 
+// migrateUserRoles 引入角色之前的用户都拥有全部权限，迁移为管理员以免升级后无法管理
+func migrateUserRoles() error {
+	result := DB.Model(&models.User{}).Where("role IS NULL OR role = ''").Update("role", models.RoleAdmin)
+	if result.Error != nil {
+		return fmt.Errorf("迁移用户角色失败: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("已将 %d 个未设置角色的用户设为管理员", result.RowsAffected)
+	}
+	return nil
+}
+
+// migrateIPPool 旧版本为每个地址预先生成一条ip_pools记录，改为只记录已分配的地址。
+// 按模块和用户VPN当前使用的地址补齐分配记录后删除旧表
+func migrateIPPool() error {
+	if !DB.Migrator().HasTable("ip_pools") {
+		return nil
+	}
+
+	var wgInterfaces []models.WireGuardInterface
+	if err := DB.Find(&wgInterfaces).Error; err != nil {
+		return fmt.Errorf("迁移IP池失败: %w", err)
+	}
+	interfaces := make(map[uint]*models.WireGuardInterface)
+	var allocations []models.IPAllocation
+	for i := range wgInterfaces {
+		interfaces[wgInterfaces[i].ID] = &wgInterfaces[i]
+		allocations = append(allocations, gatewayAllocations(&wgInterfaces[i])...)
+	}
+	addAllocations := func(interfaceID uint, ownerType string, ownerID uint, addresses ...string) {
+		if wgInterface, ok := interfaces[interfaceID]; ok {
+			allocations = append(allocations, ownedAllocations(wgInterface, ownerType, ownerID, addresses...)...)
+		}
+	}
+
+	var modules []models.Module
+	if err := DB.Find(&modules).Error; err != nil {
+		return fmt.Errorf("迁移IP池失败: %w", err)
+	}
+	moduleInterfaces := make(map[uint]uint)
+	for i := range modules {
+		module := &modules[i]
+		moduleInterfaces[module.ID] = module.InterfaceID
+		addAllocations(module.InterfaceID, models.IPOwnerModule, module.ID, module.IPAddress, module.IPAddress6)
+	}
+
+	var userVPNs []models.UserVPN
+	if err := DB.Find(&userVPNs).Error; err != nil {
+		return fmt.Errorf("迁移IP池失败: %w", err)
+	}
+	for i := range userVPNs {
+		userVPN := &userVPNs[i]
+		if interfaceID, ok := moduleInterfaces[userVPN.ModuleID]; ok {
+			addAllocations(interfaceID, models.IPOwnerUserVPN, userVPN.ID, userVPN.IPAddress, userVPN.IPAddress6)
+		}
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if len(allocations) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(allocations, 100).Error; err != nil {
+				return fmt.Errorf("迁移IP池失败: %w", err)
+			}
+		}
+		if err := tx.Migrator().DropTable("ip_pools"); err != nil {
+			return fmt.Errorf("删除旧IP池失败: %w", err)
+		}
+		log.Printf("已将IP池迁移为 %d 条地址分配记录", len(allocations))
+		return nil
+	})
+}
+
+// migrateIPAllocationOwners 早期的地址分配记录用module_id和user_vpn_id两列记录持有者，
+// 改为持有者类型和ID，并补齐接口服务器地址的记录
+func migrateIPAllocationOwners() error {
+	migrator := DB.Migrator()
+	if !migrator.HasColumn(&models.IPAllocation{}, "module_id") {
+		return nil
+	}
+
+	var wgInterfaces []models.WireGuardInterface
+	if err := DB.Find(&wgInterfaces).Error; err != nil {
+		return fmt.Errorf("迁移地址持有者失败: %w", err)
+	}
+	var gateways []models.IPAllocation
+	for i := range wgInterfaces {
+		gateways = append(gateways, gatewayAllocations(&wgInterfaces[i])...)
+	}
+
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE ip_allocations SET owner_type = ?, owner_id = module_id WHERE module_id IS NOT NULL",
+			models.IPOwnerModule).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE ip_allocations SET owner_type = ?, owner_id = user_vpn_id WHERE user_vpn_id IS NOT NULL",
+			models.IPOwnerUserVPN).Error; err != nil {
+			return err
+		}
+		if len(gateways) > 0 {
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&gateways).Error
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("迁移地址持有者失败: %w", err)
+	}
+
+	// SQLite删除列时会重建表，索引需要重新创建
+	for _, column := range []string{"module_id", "user_vpn_id"} {
+		if err := migrator.DropColumn(&models.IPAllocation{}, column); err != nil {
+			return fmt.Errorf("删除旧持有者列失败: %w", err)
+		}
+	}
+	if err := DB.AutoMigrate(&models.IPAllocation{}); err != nil {
+		return fmt.Errorf("重建地址分配索引失败: %w", err)
+	}
+	log.Println("已将地址分配记录迁移为按持有者类型记录")
+	return nil
+}
+
+// gatewayAllocations 接口服务器地址的分配记录
+func gatewayAllocations(wgInterface *models.WireGuardInterface) []models.IPAllocation {
+	return ownedAllocations(wgInterface, models.IPOwnerGateway, wgInterface.ID, wgInterface.ServerIP, wgInterface.ServerIP6)
+}
+
+// ownedAllocations 持有者在接口下各地址的分配记录，空地址忽略
+func ownedAllocations(wgInterface *models.WireGuardInterface, ownerType string, ownerID uint, addresses ...string) []models.IPAllocation {
+	var allocations []models.IPAllocation
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		network := wgInterface.Network
+		if ipaddr.IsIPv6(address) {
+			network = wgInterface.Network6
+		}
+		allocations = append(allocations, models.IPAllocation{
+			InterfaceID: wgInterface.ID,
+			Network:     network,
+			IPAddress:   address,
+			OwnerType:   ownerType,
+			OwnerID:     ownerID,
+		})
+	}
+	return allocations
+}
+
 // InitDefaultData 初始化默认数据
 func InitDefaultData() error {
 	// 初始化系统配置
@@ -128,6 +327,7 @@ func initDefaultAdmin() error {
 	admin := &models.User{
 		Username: "admin",
 		Password: hashedPassword, // 使用哈希后的密码
+		Role:     models.RoleAdmin,
 		IsActive: true,
 	}
 
@@ -139,35 +339,6 @@ func initDefaultAdmin() error {
 	return nil
 }
 
-// initIPPool 初始化IP地址池
-func initIPPool() error {
-	var count int64
-	DB.Model(&models.IPPool{}).Count(&count)
-
-	// 如果已有IP池，跳过
-	if count > 0 {
-		return nil
-	}
-
-	// 生成 10.10.0.2-10.10.0.254 的IP地址池
-	network := "10.10.0.0/24"
-	for i := 2; i <= 254; i++ {
-		ip := fmt.Sprintf("10.10.0.%d", i)
-		ipPool := &models.IPPool{
-			Network:   network,
-			IPAddress: ip,
-			IsUsed:    false,
-		}
-
-		if err := DB.Create(ipPool).Error; err != nil {
-			return fmt.Errorf("创建IP池失败 %s: %w", ip, err)
-		}
-	}
-
-	log.Println("初始化IP地址池: 10.10.0.2-10.10.0.254")
-	return nil
-}
-
 // initSystemConfig 初始化系统配置
 func initSystemConfig() error {
 	configs := []models.SystemConfig{
@@ -272,17 +443,16 @@ func initDefaultInterfaces() error {
 			SaveConfig:  true,
 		}
 
-		if err := DB.Create(wgInterface).Error; err != nil {
+		if err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(wgInterface).Error; err != nil {
+				return err
+			}
+			return tx.Create(gatewayAllocations(wgInterface)).Error
+		}); err != nil {
 			log.Printf("创建接口 %s 失败: %v", template.Name, err)
 			continue
 		}
 
-		// 为接口创建IP池
-		if err := createIPPoolForInterface(wgInterface); err != nil {
-			log.Printf("为接口 %s 创建IP池失败: %v", template.Name, err)
-			// 不删除接口，只记录错误
-		}
-
 		log.Printf("成功创建接口: %s (%s)", template.Name, template.Description)
 	}
 
@@ -317,120 +487,12 @@ func calculateServerIP(network string) (string, error) {
 		return "", err
 	}
 
-	// 使用网络段的第一个可用IP作为服务器IP
-	ip := ipNet.IP.To4()
-	if ip == nil {
-		return "", fmt.Errorf("不支持IPv6")
-	}
-
 	// 第一个IP通常是网络地址，第二个IP作为服务器IP
-	ip[3] = ip[3] + 1
-	return ip.String(), nil
-}
-
-// createIPPoolForInterface 为接口创建IP池
-func createIPPoolForInterface(wgInterface *models.WireGuardInterface) error {
-	_, ipNet, err := net.ParseCIDR(wgInterface.Network)
-	if err != nil {
-		return err
-	}
-
-	// 生成IP池
-	ip := ipNet.IP.To4()
+	ip := ipaddr.Nth(ipNet, 1)
 	if ip == nil {
-		return fmt.Errorf("不支持IPv6")
+		return "", fmt.Errorf("网段 %s 太小", network)
 	}
-
-	// 跳过网络地址和服务器IP，从第三个IP开始
-	startIP := make(net.IP, 4)
-	copy(startIP, ip)
-	startIP[3] = startIP[3] + 2
-
-	// 计算可用IP数量
-	ones, bits := ipNet.Mask.Size()
-	availableIPs := 1<<(bits-ones) - 3 // 减去网络地址、广播地址和服务器IP
-
-	// 限制IP数量避免过多
-	if availableIPs > 200 {
-		availableIPs = 200
-	}
-
-	// 批量创建IP池记录
-	var ipPools []models.IPPool
-	for i := 0; i < availableIPs; i++ {
-		currentIP := make(net.IP, 4)
-		copy(currentIP, startIP)
-		currentIP[3] = currentIP[3] + byte(i)
-
-		// 检查是否超出网络范围
-		if !ipNet.Contains(currentIP) {
-			break
-		}
-
-		ipPools = append(ipPools, models.IPPool{
-			Network:   wgInterface.Network,
-			IPAddress: currentIP.String(),
-			IsUsed:    false,
-		})
-	}
-
-	// 批量插入
-	if len(ipPools) > 0 {
-		if err := DB.CreateInBatches(ipPools, 100).Error; err != nil {
-			return fmt.Errorf("批量创建IP池失败: %w", err)
-		}
-	}
-
-	return nil
-}
-
-// GetAvailableIP 获取可用IP地址
-func GetAvailableIP() (string, error) {
-	var ipPool models.IPPool
-	if err := DB.Where("is_used = ?", false).First(&ipPool).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return "", fmt.Errorf("没有可用的IP地址")
-		}
-		return "", fmt.Errorf("查询可用IP失败: %w", err)
-	}
-
-	return ipPool.IPAddress, nil
-}
-
-// AllocateIP 分配IP地址给模块
-func AllocateIP(ip string, moduleID uint) error {
-	result := DB.Model(&models.IPPool{}).
-		Where("ip_address = ? AND is_used = ?", ip, false).
-		Updates(map[string]interface{}{
-			"is_used":   true,
-			"module_id": moduleID,
-		})
-
-	if result.Error != nil {
-		return fmt.Errorf("分配IP地址失败: %w", result.Error)
-	}
-
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("IP地址 %s 不可用", ip)
-	}
-
-	return nil
-}
-
-// ReleaseIP 释放IP地址
-func ReleaseIP(ip string) error {
-	result := DB.Model(&models.IPPool{}).
-		Where("ip_address = ?", ip).
-		Updates(map[string]interface{}{
-			"is_used":   false,
-			"module_id": nil,
-		})
-
-	if result.Error != nil {
-		return fmt.Errorf("释放IP地址失败: %w", result.Error)
-	}
-
-	return nil
+	return ip.String(), nil
 }
 
 // GetSystemConfig 获取系统配置
@@ -443,11 +505,22 @@ func GetSystemConfig(key string) (string, error) {
 		return "", fmt.Errorf("查询配置失败: %w", err)
 	}
 
+	if secretConfigKeys[key] {
+		return envelope.Open(config.Value, configAAD(key))
+	}
 	return config.Value, nil
 }
 
-// SetSystemConfig 设置系统配置
+// SetSystemConfig 设置系统配置，敏感配置项加密存储
 func SetSystemConfig(key, value string) error {
+	if secretConfigKeys[key] {
+		sealed, err := envelope.Seal(value, configAAD(key))
+		if err != nil {
+			return err
+		}
+		value = sealed
+	}
+
 	var config models.SystemConfig
 	if err := DB.Where("key = ?", key).First(&config).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"log"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/envelope"
+
+	"gorm.io/gorm"
+)
+
+// secretConfigKeys 加密存储的系统配置项，新建配置时ID尚未生成，以配置项名代替主键作为附加数据
+var secretConfigKeys = map[string]bool{
+	"server.private_key": true,
+}
+
+// secretColumns 加密存储的字段，与模型中 serializer:encrypted 的字段保持一致
+var secretColumns = []struct {
+	model  interface{}
+	column string
+}{
+	{&models.WireGuardInterface{}, "private_key"},
+	{&models.Module{}, "private_key"},
+	{&models.Module{}, "preshared_key"},
+	{&models.UserVPN{}, "private_key"},
+	{&models.UserVPN{}, "preshared_key"},
+	{&models.UserVPNDevice{}, "private_key"},
+	{&models.UserVPNDevice{}, "preshared_key"},
+	{&models.User{}, "totp_secret"},
+}
+
+// ResealSecrets 用密钥环的主密钥重新加密所有敏感字段，返回更新的值数量。
+// 升级前的明文和使用旧主密钥加密的值都会以"表名:列名:主键"为附加数据重新加密，
+// 已使用主密钥加密的值跳过。
+// 按列读写原始值，不经过模型的加密序列化器，因此可以使用与全局密钥环不同的密钥环
+func ResealSecrets(keyring *envelope.Keyring) (int, error) {
+	resealed := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, target := range secretColumns {
+			n, err := resealColumn(tx, keyring, target.model, target.column, nil)
+			if err != nil {
+				return err
+			}
+			resealed += n
+		}
+
+		keys := make([]string, 0, len(secretConfigKeys))
+		for key := range secretConfigKeys {
+			keys = append(keys, key)
+		}
+		n, err := resealColumn(tx, keyring, &models.SystemConfig{}, "value", keys)
+		if err != nil {
+			return err
+		}
+		resealed += n
+		return nil
+	})
+	return resealed, err
+}
+
+// resealColumn 重新加密一列中需要轮换的值，configKeys不为空时只处理对应的系统配置项
+func resealColumn(tx *gorm.DB, keyring *envelope.Keyring, model interface{}, column string, configKeys []string) (int, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return 0, fmt.Errorf("解析模型 %T 失败: %w", model, err)
+	}
+
+	query := tx.Model(model)
+	fields := "id, '' AS name, " + column + " AS value"
+	if len(configKeys) > 0 {
+		query = query.Where("key IN ?", configKeys)
+		fields = "id, key AS name, " + column + " AS value"
+	}
+
+	var rows []struct {
+		ID    uint
+		Name  string
+		Value string
+	}
+	if err := query.Select(fields).Scan(&rows).Error; err != nil {
+		return 0, fmt.Errorf("读取 %T.%s 失败: %w", model, column, err)
+	}
+
+	resealed := 0
+	for _, row := range rows {
+		if !keyring.NeedsReseal(row.Value) {
+			continue
+		}
+		aad := envelope.AAD(stmt.Schema.Table, column, row.ID)
+		if len(configKeys) > 0 {
+			aad = configAAD(row.Name)
+		}
+		plaintext := row.Value
+		if envelope.IsSealed(row.Value) {
+			var err error
+			if plaintext, err = keyring.Open(row.Value, aad); err != nil {
+				return 0, fmt.Errorf("解密 %T.%s (id=%d) 失败: %w", model, column, row.ID, err)
+			}
+		}
+		sealed, err := keyring.Seal(plaintext, aad)
+		if err != nil {
+			return 0, err
+		}
+		// 按列名更新不经过序列化器，写入的就是上面加密的值
+		if err := tx.Model(model).Where("id = ?", row.ID).UpdateColumn(column, sealed).Error; err != nil {
+			return 0, fmt.Errorf("更新 %T.%s (id=%d) 失败: %w", model, column, row.ID, err)
+		}
+		resealed++
+	}
+	return resealed, nil
+}
+
+// configAAD 敏感系统配置项的附加数据
+func configAAD(key string) string {
+	return envelope.AAD("system_configs", "value", key)
+}
+
+// encryptLegacySecrets 加载主密钥后加密升级前保存的明文，并把旧主密钥加密的值迁移到当前主密钥。
+// 完成后不再按明文读取敏感字段，数据库中被写入的明文读取时报错
+func encryptLegacySecrets() error {
+	keyring := envelope.Default()
+	if keyring == nil {
+		log.Println("⚠️ 未加载主密钥，敏感字段无法加密存储")
+		return nil
+	}
+
+	resealed, err := ResealSecrets(keyring)
+	if err != nil {
+		return err
+	}
+	if resealed > 0 {
+		log.Printf("已使用主密钥 %s 加密 %d 个敏感字段", keyring.PrimaryID(), resealed)
+	}
+	envelope.AllowPlaintext(false)
+	return nil
+}
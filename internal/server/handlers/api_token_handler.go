@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APITokenHandler 个人访问令牌处理器
+type APITokenHandler struct {
+	tokenService *auth.APITokenService
+	userService  *auth.UserService
+	auditService *services.AuditService
+}
+
+// NewAPITokenHandler 创建个人访问令牌处理器
+func NewAPITokenHandler(tokenService *auth.APITokenService, userService *auth.UserService) *APITokenHandler {
+	return &APITokenHandler{
+		tokenService: tokenService,
+		userService:  userService,
+		auditService: services.NewAuditService(),
+	}
+}
+
+// CreateAPITokenResponse 创建个人访问令牌响应，明文令牌只返回这一次
+type CreateAPITokenResponse struct {
+	*models.APIToken
+	Token string `json:"token"`
+}
+
+// GetTokens 获取当前用户的个人访问令牌
+func (th *APITokenHandler) GetTokens(c *gin.Context) {
+	tokens, err := th.tokenService.GetUserTokens(c.GetUint("user_id"))
+	if err != nil {
+		response.InternalError(c, "获取令牌列表失败")
+		return
+	}
+
+	response.Success(c, tokens)
+}
+
+// CreateToken 为当前用户创建个人访问令牌
+func (th *APITokenHandler) CreateToken(c *gin.Context) {
+	var req models.APITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	user, err := th.userService.GetUserByID(c.GetUint("user_id"))
+	if err != nil {
+		response.NotFound(c, "用户不存在")
+		return
+	}
+
+	token, plaintext, err := th.tokenService.CreateToken(user, &req)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditTokenCreate, models.AuditTargetAPIToken, token.ID, token.Name)
+	entry.Changes = services.AuditDiff(nil, token)
+	th.auditService.Record(entry)
+
+	response.SuccessWithMessage(c, "令牌仅显示一次，请妥善保存", CreateAPITokenResponse{APIToken: token, Token: plaintext})
+}
+
+// RevokeToken 吊销当前用户的个人访问令牌
+func (th *APITokenHandler) RevokeToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "令牌ID格式错误")
+		return
+	}
+
+	th.revoke(c, c.GetUint("user_id"), uint(id))
+}
+
+// GetUserTokens 获取指定用户的个人访问令牌
+func (th *APITokenHandler) GetUserTokens(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "用户ID格式错误")
+		return
+	}
+
+	tokens, err := th.tokenService.GetUserTokens(uint(id))
+	if err != nil {
+		response.InternalError(c, "获取令牌列表失败")
+		return
+	}
+
+	response.Success(c, tokens)
+}
+
+// RevokeUserToken 吊销指定用户的个人访问令牌，用于令牌泄露或人员离职
+func (th *APITokenHandler) RevokeUserToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "用户ID格式错误")
+		return
+	}
+	tokenID, err := strconv.ParseUint(c.Param("token_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "令牌ID格式错误")
+		return
+	}
+
+	th.revoke(c, uint(id), uint(tokenID))
+}
+
+// revoke 吊销令牌并记录审计日志
+func (th *APITokenHandler) revoke(c *gin.Context, userID, tokenID uint) {
+	token, err := th.tokenService.RevokeToken(userID, tokenID)
+	if err != nil {
+		if errors.Is(err, auth.ErrAPITokenNotFound) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		response.InternalError(c, "吊销令牌失败")
+		return
+	}
+
+	th.auditService.Record(auditEntry(c, models.AuditTokenRevoke, models.AuditTargetAPIToken, token.ID, token.Name))
+
+	response.SuccessWithMessage(c, "令牌已吊销", nil)
+}
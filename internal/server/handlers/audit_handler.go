@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/ipaddr"
+	"eitec-vpn/internal/shared/response"
+	"eitec-vpn/internal/shared/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditHandler 审计日志处理器
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+// NewAuditHandler 创建审计日志处理器
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+	}
+}
+
+// GetAuditLogs 分页查询审计日志。支持 actor、action（以 . 结尾时按前缀匹配）、target_type、
+// target_id、source_ip、success、from/to（RFC3339）过滤
+func (ah *AuditHandler) GetAuditLogs(c *gin.Context) {
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	query.Page, query.Size = utils.ParsePagination(c.Request)
+
+	logs, total, err := ah.auditService.Query(query)
+	if err != nil {
+		response.InternalError(c, "查询审计日志失败: "+err.Error())
+		return
+	}
+
+	response.Paged(c, logs, total, query.Page, query.Size)
+}
+
+// ExportAuditLogs 以JSON Lines格式导出符合条件的审计日志，过滤参数与查询接口相同
+func (ah *AuditHandler) ExportAuditLogs(c *gin.Context) {
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	// 响应已开始写入，导出中途失败只能记录日志
+	if _, err := ah.auditService.Export(query, c.Writer); err != nil {
+		log.Printf("导出审计日志失败: %v", err)
+	}
+}
+
+// parseAuditQuery 解析审计日志过滤参数
+func parseAuditQuery(c *gin.Context) (*services.AuditQuery, error) {
+	query := &services.AuditQuery{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		SourceIP:   c.Query("source_ip"),
+	}
+
+	if value := c.Query("target_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("无效的对象ID: %s", value)
+		}
+		query.TargetID = uint(id)
+	}
+	if value := c.Query("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的success参数: %s", value)
+		}
+		query.Success = &success
+	}
+	if value := c.Query("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的开始时间: %s", value)
+		}
+		query.From = from
+	}
+	if value := c.Query("to"); value != "" {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的结束时间: %s", value)
+		}
+		query.To = to
+	}
+
+	return query, nil
+}
+
+// auditEntry 创建审计日志，操作者和来源IP取自请求上下文，使用个人访问令牌时记录令牌
+func auditEntry(c *gin.Context, action, targetType string, targetID uint, targetName string) *models.AuditLog {
+	role, _ := c.Get("user_role")
+	userRole, _ := role.(models.UserRole)
+
+	entry := &models.AuditLog{
+		ActorID:    c.GetUint("user_id"),
+		Actor:      c.GetString("username"),
+		ActorRole:  userRole,
+		SourceIP:   c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		TargetName: targetName,
+		Success:    true,
+	}
+	if value, ok := c.Get("api_token"); ok {
+		if token, _ := value.(*models.APIToken); token != nil {
+			entry.Message = fmt.Sprintf("个人访问令牌 %s (%s)", token.Name, token.TokenPrefix)
+		}
+	}
+	return entry
+}
+
+// addressChanges 把模块或用户VPN的地址变化转换为审计日志的字段变化
+func addressChanges(result *models.RenumberResult) map[string]models.AuditChange {
+	changes := make(map[string]models.AuditChange, len(result.Changes))
+	for _, change := range result.Changes {
+		field := "ip_address"
+		if ipaddr.IsIPv6(change.NewAddress) {
+			field = "ip_address6"
+		}
+		changes[field] = models.AuditChange{Before: change.OldAddress, After: change.NewAddress}
+	}
+	return changes
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
 	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/lockout"
 	"eitec-vpn/internal/shared/response"
 	"eitec-vpn/internal/shared/utils"
 
-	"strconv"
-
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,14 +19,18 @@ type AuthHandler struct {
 	userService    *auth.UserService
 	jwtService     *auth.JWTService
 	sessionManager *auth.SessionManager
+	oidcService    *auth.OIDCService
+	auditService   *services.AuditService
 }
 
 // NewAuthHandler 创建认证处理器
-func NewAuthHandler(userService *auth.UserService, jwtService *auth.JWTService, sessionManager *auth.SessionManager) *AuthHandler {
+func NewAuthHandler(userService *auth.UserService, jwtService *auth.JWTService, sessionManager *auth.SessionManager, oidcService *auth.OIDCService) *AuthHandler {
 	return &AuthHandler{
 		userService:    userService,
 		jwtService:     jwtService,
 		sessionManager: sessionManager,
+		oidcService:    oidcService,
+		auditService:   services.NewAuditService(),
 	}
 }
 
@@ -34,10 +42,11 @@ type LoginRequest struct {
 
 // LoginResponse 登录响应
 type LoginResponse struct {
-	User         interface{} `json:"user"`
-	AccessToken  string      `json:"access_token"`
-	RefreshToken string      `json:"refresh_token"`
-	ExpiresIn    int64       `json:"expires_in"`
+	User          interface{} `json:"user"`
+	AccessToken   string      `json:"access_token"`
+	RefreshToken  string      `json:"refresh_token"`
+	ExpiresIn     int64       `json:"expires_in"`
+	RecoveryCodes []string    `json:"recovery_codes,omitempty"` // 登录时完成两步验证绑定才返回
 }
 
 // RefreshTokenRequest 刷新令牌请求
@@ -60,54 +69,96 @@ func (ah *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// 验证用户凭证
-	user, err := ah.userService.Login(req.Username, req.Password)
+	user, err := ah.userService.Login(req.Username, req.Password, c.ClientIP())
 	if err != nil {
+		ah.recordLogin(c, req.Username, nil, err.Error())
+		// 密码登录已关闭，或外部目录校验密码通过后拒绝登录，可以告诉用户原因
+		if errors.Is(err, auth.ErrPasswordLoginDisabled) || errors.Is(err, auth.ErrAccessDenied) ||
+			errors.Is(err, auth.ErrAccountDisabled) || errors.Is(err, auth.ErrUsernameTaken) {
+			response.Forbidden(c, err.Error())
+			return
+		}
+		var locked *lockout.LockedError
+		if errors.As(err, &locked) {
+			response.TooManyRequests(c, locked.Error(), locked.RetryAfter())
+			return
+		}
 		response.Unauthorized(c, "用户名或密码错误")
 		return
 	}
 
 	// 检查用户状态
 	if !user.IsActive {
+		ah.recordLogin(c, user.Username, user, "账户已被禁用")
 		response.Forbidden(c, "账户已被禁用")
 		return
 	}
 
-	// 生成JWT令牌
-	tokenPair, err := ah.jwtService.GenerateTokenPair(user)
-	if err != nil {
-		response.InternalError(c, "生成令牌失败")
+	// 已启用两步验证的用户需要再提交验证码，策略要求两步验证但尚未绑定的用户需要先完成绑定
+	if user.TOTPEnabled {
+		ah.respondMFAChallenge(c, user, auth.MFAToken)
+		return
+	}
+	if ah.userService.TwoFactorRequired() {
+		ah.respondMFAChallenge(c, user, auth.MFASetupToken)
 		return
 	}
 
-	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	ah.completeLogin(c, user, nil)
+}
 
-	// 创建会话
-	session, err := ah.sessionManager.CreateSession(user, ipAddress, userAgent)
+// completeLogin 创建会话并签发令牌对，recoveryCodes不为空时随响应返回
+func (ah *AuthHandler) completeLogin(c *gin.Context, user *models.User, recoveryCodes []string) {
+	tokenPair, err := ah.startSession(c, user)
 	if err != nil {
-		response.InternalError(c, "创建会话失败")
+		response.InternalError(c, err.Error())
 		return
 	}
 
 	// 返回响应
 	loginResponse := LoginResponse{
 		User: map[string]interface{}{
-			"id":       user.ID,
-			"username": user.Username,
+			"id":           user.ID,
+			"username":     user.Username,
+			"role":         user.Role,
+			"permissions":  user.Role.Permissions(),
+			"totp_enabled": user.TOTPEnabled || len(recoveryCodes) > 0,
 		},
-		AccessToken:  tokenPair.AccessToken,
-		RefreshToken: tokenPair.RefreshToken,
-		ExpiresIn:    tokenPair.ExpiresIn,
+		AccessToken:   tokenPair.AccessToken,
+		RefreshToken:  tokenPair.RefreshToken,
+		ExpiresIn:     tokenPair.ExpiresIn,
+		RecoveryCodes: recoveryCodes,
 	}
 
-	// 设置会话cookie (1小时有效期)
-	c.SetCookie("session_id", session.ID, 3600, "/", "", false, true)
+	ah.recordLogin(c, user.Username, user, "")
 
 	response.Success(c, loginResponse)
 }
 
-// RefreshToken 刷新访问令牌
+// startSession 创建会话、签发令牌对并设置会话cookie
+func (ah *AuthHandler) startSession(c *gin.Context, user *models.User) (*auth.TokenPair, error) {
+	// 创建会话
+	session, err := ah.sessionManager.CreateSession(user, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		return nil, errors.New("创建会话失败")
+	}
+
+	// 生成JWT令牌
+	tokenPair, err := ah.jwtService.GenerateTokenPair(user, session.ID)
+	if err != nil {
+		return nil, errors.New("生成令牌失败")
+	}
+	if err := ah.sessionManager.IssueRefreshToken(session.ID, tokenPair); err != nil {
+		return nil, errors.New("生成令牌失败")
+	}
+
+	// 设置会话cookie (1小时有效期)
+	c.SetCookie("session_id", session.Token, 3600, "/", "", false, true)
+	return tokenPair, nil
+}
+
+// RefreshToken 刷新令牌。刷新令牌只能使用一次，每次返回新的令牌对；
+// 已使用的刷新令牌再次提交时撤销整个会话
 func (ah *AuthHandler) RefreshToken(c *gin.Context) {
 	var req RefreshTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -122,6 +173,17 @@ func (ah *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
+	// 消费刷新令牌
+	session, err := ah.sessionManager.UseRefreshToken(claims)
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) || errors.Is(err, auth.ErrSessionInvalid) {
+			response.Unauthorized(c, err.Error())
+			return
+		}
+		response.InternalError(c, "刷新令牌失败")
+		return
+	}
+
 	// 获取用户信息
 	user, err := ah.userService.GetUserByID(claims.UserID)
 	if err != nil {
@@ -131,81 +193,76 @@ func (ah *AuthHandler) RefreshToken(c *gin.Context) {
 
 	// 检查用户状态
 	if !user.IsActive {
+		ah.sessionManager.DestroySession(session.ID)
 		response.Forbidden(c, "账户已被禁用")
 		return
 	}
 
 	// 生成新的令牌对
-	tokenPair, err := ah.jwtService.GenerateTokenPair(user)
+	tokenPair, err := ah.jwtService.GenerateTokenPair(user, session.ID)
 	if err != nil {
 		response.InternalError(c, "生成令牌失败")
 		return
 	}
-
-	refreshResponse := map[string]interface{}{
-		"access_token": tokenPair.AccessToken,
-		"expires_in":   tokenPair.ExpiresIn,
+	if err := ah.sessionManager.IssueRefreshToken(session.ID, tokenPair); err != nil {
+		response.InternalError(c, "生成令牌失败")
+		return
 	}
 
-	response.Success(c, refreshResponse)
+	response.Success(c, tokenPair)
 }
 
 // GetCurrentUser 获取当前用户信息
 func (ah *AuthHandler) GetCurrentUser(c *gin.Context) {
-	// 临时注释掉认证检查，直接返回默认管理员信息
-	/*
-		// 从上下文获取用户ID
-		userIDStr := c.GetString("user_id")
-		if userIDStr == "" {
-			response.Unauthorized(c, "用户未认证")
-			return
-		}
-
-		userID, err := strconv.ParseUint(userIDStr, 10, 32)
-		if err != nil {
-			response.BadRequest(c, "用户ID格式错误")
-			return
-		}
-
-		// 获取用户信息
-		user, err := ah.userService.GetUserByID(uint(userID))
-		if err != nil {
-			response.NotFound(c, "用户不存在")
-			return
-		}
+	role, _ := c.Get("user_role")
+	userRole, _ := role.(models.UserRole)
+
+	// 关闭认证时没有对应的数据库用户
+	userID := c.GetUint("user_id")
+	if userID == 0 {
+		response.Success(c, map[string]interface{}{
+			"id":          0,
+			"username":    c.GetString("username"),
+			"role":        userRole,
+			"permissions": userRole.Permissions(),
+			"is_active":   true,
+		})
+		return
+	}
 
-		userInfo := map[string]interface{}{
-			"id":         user.ID,
-			"username":   user.Username,
-			"role":       user.Role,
-			"is_active":  user.IsActive,
-			"created_at": user.CreatedAt,
-			"last_login": user.LastLogin,
-		}
-	*/
+	user, err := ah.userService.GetUserByID(userID)
+	if err != nil {
+		response.NotFound(c, "用户不存在")
+		return
+	}
 
-	// 返回默认的管理员用户信息
 	userInfo := map[string]interface{}{
-		"id":        1,
-		"username":  "admin",
-		"role":      "admin",
-		"is_active": true,
+		"id":           user.ID,
+		"username":     user.Username,
+		"role":         user.Role,
+		"permissions":  user.Role.Permissions(),
+		"is_active":    user.IsActive,
+		"totp_enabled": user.TOTPEnabled,
+		"created_at":   user.CreatedAt,
+		"last_login":   user.LastLogin,
 	}
 
 	response.Success(c, userInfo)
 }
 
-// Logout 用户注销
+// Logout 用户注销，当前会话的访问令牌和刷新令牌随之失效
 func (ah *AuthHandler) Logout(c *gin.Context) {
-	// 从cookie获取会话ID
-	sessionID, err := c.Cookie("session_id")
-	if err == nil && sessionID != "" {
+	if sessionID := c.GetUint("session_id"); sessionID != 0 {
 		// 销毁会话
-		ah.sessionManager.DestroySession(sessionID)
+		if err := ah.sessionManager.DestroySession(sessionID); err != nil {
+			response.InternalError(c, "注销失败")
+			return
+		}
 	}
 
 	// 清除cookie
 	c.SetCookie("session_id", "", -1, "/", "", false, true)
+	ah.auditService.Record(auditEntry(c, models.AuditLogout, models.AuditTargetUser, c.GetUint("user_id"), c.GetString("username")))
 
 	response.SuccessWithMessage(c, "注销成功", nil)
 }
@@ -219,20 +276,14 @@ func (ah *AuthHandler) ChangePassword(c *gin.Context) {
 	}
 
 	// 从上下文获取用户ID
-	userIDStr := c.GetString("user_id")
-	if userIDStr == "" {
+	userID := c.GetUint("user_id")
+	if userID == 0 {
 		response.Unauthorized(c, "用户未认证")
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 32)
-	if err != nil {
-		response.BadRequest(c, "用户ID格式错误")
-		return
-	}
-
 	// 获取用户信息
-	user, err := ah.userService.GetUserByID(uint(userID))
+	user, err := ah.userService.GetUserByID(userID)
 	if err != nil {
 		response.NotFound(c, "用户不存在")
 		return
@@ -245,10 +296,88 @@ func (ah *AuthHandler) ChangePassword(c *gin.Context) {
 	}
 
 	// 更新密码
-	if err := ah.userService.ChangePassword(uint(userID), req.OldPassword, req.NewPassword); err != nil {
+	if err := ah.userService.ChangePassword(userID, req.OldPassword, req.NewPassword); err != nil {
 		response.InternalError(c, "密码更新失败")
 		return
 	}
 
+	// 其他设备上的会话需要使用新密码重新登录
+	if _, err := ah.sessionManager.DestroyOtherSessions(userID, c.GetUint("session_id")); err != nil {
+		response.InternalError(c, "撤销其他会话失败")
+		return
+	}
+	ah.auditService.Record(auditEntry(c, models.AuditPasswordChange, models.AuditTargetUser, user.ID, user.Username))
+
 	response.SuccessWithMessage(c, "密码修改成功", nil)
 }
+
+// SessionResponse 会话列表项
+type SessionResponse struct {
+	models.UserSession
+	Current bool `json:"current"` // 是否为发起请求的会话
+}
+
+// GetSessions 获取当前用户的有效会话
+func (ah *AuthHandler) GetSessions(c *gin.Context) {
+	sessions, err := ah.sessionManager.GetUserSessions(c.GetUint("user_id"))
+	if err != nil {
+		response.InternalError(c, "获取会话列表失败")
+		return
+	}
+
+	current := c.GetUint("session_id")
+	result := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		result[i] = SessionResponse{UserSession: session, Current: session.ID == current}
+	}
+
+	response.Success(c, result)
+}
+
+// RevokeSession 撤销当前用户的某个会话
+func (ah *AuthHandler) RevokeSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "会话ID格式错误")
+		return
+	}
+
+	if err := ah.sessionManager.RevokeUserSession(c.GetUint("user_id"), uint(id)); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		response.InternalError(c, "撤销会话失败")
+		return
+	}
+
+	response.SuccessWithMessage(c, "会话已撤销", nil)
+}
+
+// RevokeOtherSessions 撤销当前用户除本会话以外的所有会话
+func (ah *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	count, err := ah.sessionManager.DestroyOtherSessions(c.GetUint("user_id"), c.GetUint("session_id"))
+	if err != nil {
+		response.InternalError(c, "撤销会话失败")
+		return
+	}
+
+	response.Success(c, gin.H{"revoked": count})
+}
+
+// recordLogin 记录登录结果，failure为空表示登录成功。登录请求未经过认证，操作者取自登录的用户
+func (ah *AuthHandler) recordLogin(c *gin.Context, username string, user *models.User, failure string) {
+	entry := auditEntry(c, models.AuditLogin, models.AuditTargetUser, 0, username)
+	entry.Actor = username
+	if user != nil {
+		entry.ActorID = user.ID
+		entry.ActorRole = user.Role
+		entry.TargetID = user.ID
+	}
+	if failure != "" {
+		entry.Action = models.AuditLoginFailed
+		entry.Success = false
+		entry.Message = failure
+	}
+	ah.auditService.Record(entry)
+}
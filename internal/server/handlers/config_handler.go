@@ -4,7 +4,10 @@ import (
 	"net/http"
 
 	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/middleware"
+	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/response"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,19 +15,29 @@ import (
 // ConfigHandler 配置管理处理器
 type ConfigHandler struct {
 	configService *services.ConfigService
+	auditService  *services.AuditService
 }
 
 // NewConfigHandler 创建配置处理器
 func NewConfigHandler(configService *services.ConfigService) *ConfigHandler {
 	return &ConfigHandler{
 		configService: configService,
+		auditService:  services.NewAuditService(),
 	}
 }
 
-// GetSystemConfig 获取系统配置
+// GetSystemConfig 获取系统配置，默认不含服务器私钥和JWT密钥，
+// include_secrets=true 时返回敏感信息，需要 secrets:read 权限
 func (ch *ConfigHandler) GetSystemConfig(c *gin.Context) {
-	// 简化：移除敏感信息检查，统一返回所有配置
-	config, err := ch.configService.GetSystemConfig(true)
+	includeSecrets := c.Query("include_secrets") == "true"
+	if includeSecrets {
+		if !middleware.HasPermission(c, models.PermSecretsRead) {
+			response.Forbidden(c, "权限不足")
+			return
+		}
+	}
+
+	config, err := ch.configService.GetSystemConfig(includeSecrets)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -51,6 +64,7 @@ func (ch *ConfigHandler) UpdateSystemConfig(c *gin.Context) {
 		return
 	}
 
+	before, _ := ch.configService.ExportConfig()
 	if err := ch.configService.UpdateSystemConfig(updates); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    400,
@@ -58,6 +72,7 @@ func (ch *ConfigHandler) UpdateSystemConfig(c *gin.Context) {
 		})
 		return
 	}
+	ch.recordAudit(c, models.AuditConfigUpdate, before)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -67,6 +82,7 @@ func (ch *ConfigHandler) UpdateSystemConfig(c *gin.Context) {
 
 // ResetToDefaults 重置为默认配置
 func (ch *ConfigHandler) ResetToDefaults(c *gin.Context) {
+	before, _ := ch.configService.ExportConfig()
 	if err := ch.configService.ResetToDefaults(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -74,6 +90,7 @@ func (ch *ConfigHandler) ResetToDefaults(c *gin.Context) {
 		})
 		return
 	}
+	ch.recordAudit(c, models.AuditConfigReset, before)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -108,6 +125,7 @@ func (ch *ConfigHandler) ImportConfig(c *gin.Context) {
 		return
 	}
 
+	before, _ := ch.configService.ExportConfig()
 	if err := ch.configService.ImportConfig(configMap); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    400,
@@ -115,6 +133,7 @@ func (ch *ConfigHandler) ImportConfig(c *gin.Context) {
 		})
 		return
 	}
+	ch.recordAudit(c, models.AuditConfigImport, before)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -226,3 +245,12 @@ func (ch *ConfigHandler) GetConfigStatus(c *gin.Context) {
 		"data":    status,
 	})
 }
+
+// recordAudit 记录系统配置变更，差异不含服务器私钥和JWT密钥
+func (ch *ConfigHandler) recordAudit(c *gin.Context, action string, before map[string]string) {
+	entry := auditEntry(c, action, models.AuditTargetConfig, 0, "system")
+	if after, err := ch.configService.ExportConfig(); err == nil {
+		entry.Changes = services.AuditDiff(before, after)
+	}
+	ch.auditService.Record(entry)
+}
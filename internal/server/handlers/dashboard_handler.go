@@ -44,6 +44,12 @@ func (dh *DashboardHandler) GetDashboardStats(c *gin.Context) {
 		}
 	}
 
+	// 最近的管理操作
+	recentActivity, err := dh.dashboardService.GetRecentActivity(10)
+	if err != nil {
+		recentActivity = []services.ActivityInfo{}
+	}
+
 	// 获取系统资源使用率
 	systemResources, err := dh.dashboardService.GetSystemHealth()
 	if err != nil {
@@ -67,6 +73,7 @@ func (dh *DashboardHandler) GetDashboardStats(c *gin.Context) {
 				"database_status":  "unknown",
 				"api_status":       "unknown",
 			},
+			"recent_activity": recentActivity,
 		}
 		response.Success(c, stats)
 		return
@@ -118,6 +125,7 @@ func (dh *DashboardHandler) GetDashboardStats(c *gin.Context) {
 			"database_status":  databaseStatus,
 			"api_status":       apiStatus,
 		},
+		"recent_activity": recentActivity,
 	}
 
 	response.Success(c, stats)
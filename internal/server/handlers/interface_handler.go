@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -13,11 +16,19 @@ import (
 
 type InterfaceHandler struct {
 	interfaceService *services.WireGuardInterfaceService
+	importService    *services.InterfaceImportService
+	ipamService      *services.IPAMService
+	renumberService  *services.RenumberService
+	auditService     *services.AuditService
 }
 
 func NewInterfaceHandler() *InterfaceHandler {
 	return &InterfaceHandler{
 		interfaceService: services.NewWireGuardInterfaceService(),
+		importService:    services.NewInterfaceImportService(),
+		ipamService:      services.NewIPAMService(),
+		renumberService:  services.NewRenumberService(),
+		auditService:     services.NewAuditService(),
 	}
 }
 
@@ -87,12 +98,20 @@ func (h *InterfaceHandler) StartInterface(c *gin.Context) {
 		return
 	}
 
+	wgInterface, err := h.interfaceService.GetInterface(uint(id))
+	if err != nil {
+		response.NotFound(c, "接口不存在")
+		return
+	}
+
 	err = h.interfaceService.StartInterface(uint(id))
 	if err != nil {
 		response.InternalError(c, "启动接口失败")
 		return
 	}
 
+	h.auditService.Record(auditEntry(c, models.AuditInterfaceStart, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name))
+
 	response.Success(c, gin.H{"message": "接口启动成功"})
 }
 
@@ -105,12 +124,20 @@ func (h *InterfaceHandler) StopInterface(c *gin.Context) {
 		return
 	}
 
+	wgInterface, err := h.interfaceService.GetInterface(uint(id))
+	if err != nil {
+		response.NotFound(c, "接口不存在")
+		return
+	}
+
 	err = h.interfaceService.StopInterface(uint(id))
 	if err != nil {
 		response.InternalError(c, "停止接口失败")
 		return
 	}
 
+	h.auditService.Record(auditEntry(c, models.AuditInterfaceStop, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name))
+
 	response.Success(c, gin.H{"message": "接口停止成功"})
 }
 
@@ -120,6 +147,7 @@ func (h *InterfaceHandler) CreateInterface(c *gin.Context) {
 		Name        string `json:"name" binding:"required"`
 		Description string `json:"description"`
 		Network     string `json:"network" binding:"required"`
+		Network6    string `json:"network6"` // IPv6 ULA网段，auto表示自动生成
 		ListenPort  int    `json:"listen_port" binding:"required"`
 		DNS         string `json:"dns"`
 		MaxPeers    int    `json:"max_peers"`
@@ -139,6 +167,7 @@ func (h *InterfaceHandler) CreateInterface(c *gin.Context) {
 		Name:        req.Name,
 		Description: req.Description,
 		Network:     req.Network,
+		Network6:    req.Network6,
 		ListenPort:  req.ListenPort,
 		DNS:         req.DNS,
 		MaxPeers:    req.MaxPeers,
@@ -161,6 +190,10 @@ func (h *InterfaceHandler) CreateInterface(c *gin.Context) {
 		return
 	}
 
+	entry := auditEntry(c, models.AuditInterfaceCreate, models.AuditTargetInterface, createdInterface.ID, createdInterface.Name)
+	entry.Changes = services.AuditDiff(nil, createdInterface)
+	h.auditService.Record(entry)
+
 	// 如果设置了自动启动，则启动接口
 	if req.AutoStart {
 		go func() {
@@ -175,6 +208,40 @@ func (h *InterfaceHandler) CreateInterface(c *gin.Context) {
 	response.Success(c, createdInterface)
 }
 
+// ImportInterface 导入已有的WireGuard服务端配置
+func (h *InterfaceHandler) ImportInterface(c *gin.Context) {
+	var req models.InterfaceImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	result, err := h.importService.Import(&req)
+	if errors.Is(err, services.ErrImportConflicts) {
+		c.JSON(http.StatusConflict, response.Response{
+			Code:    http.StatusConflict,
+			Message: err.Error(),
+			Data:    result,
+		})
+		return
+	}
+	if err != nil {
+		response.BadRequest(c, "导入接口失败: "+err.Error())
+		return
+	}
+
+	if !result.Imported {
+		response.SuccessWithMessage(c, "检查通过，未写入数据库", result)
+		return
+	}
+
+	entry := auditEntry(c, models.AuditInterfaceImport, models.AuditTargetInterface, result.Interface.ID, result.Interface.Name)
+	entry.Message = fmt.Sprintf("导入 %d 个对等端", len(result.Peers))
+	entry.Changes = services.AuditDiff(nil, result.Interface)
+	h.auditService.Record(entry)
+	response.SuccessWithMessage(c, "接口导入成功", result)
+}
+
 // DeleteInterface 删除WireGuard接口
 func (h *InterfaceHandler) DeleteInterface(c *gin.Context) {
 	idStr := c.Param("id")
@@ -184,12 +251,22 @@ func (h *InterfaceHandler) DeleteInterface(c *gin.Context) {
 		return
 	}
 
+	wgInterface, err := h.interfaceService.GetInterface(uint(id))
+	if err != nil {
+		response.NotFound(c, "接口不存在")
+		return
+	}
+
 	err = h.interfaceService.DeleteInterface(uint(id))
 	if err != nil {
 		response.InternalError(c, "删除接口失败: "+err.Error())
 		return
 	}
 
+	entry := auditEntry(c, models.AuditInterfaceDelete, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+	entry.Changes = services.AuditDiff(wgInterface, nil)
+	h.auditService.Record(entry)
+
 	response.Success(c, gin.H{"message": "接口删除成功"})
 }
 
@@ -216,3 +293,241 @@ func (h *InterfaceHandler) GetInterfaceConfig(c *gin.Context) {
 		"interface": wgInterface,
 	})
 }
+
+// GetIPUtilization 获取接口各网段的地址使用情况和保留范围
+func (h *InterfaceHandler) GetIPUtilization(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.ipamService.GetUtilization(wgInterface)
+	if err != nil {
+		response.InternalError(c, "统计地址使用情况失败: "+err.Error())
+		return
+	}
+	response.Success(c, report)
+}
+
+// GetIPReservations 获取接口的保留地址范围
+func (h *InterfaceHandler) GetIPReservations(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	reservations, err := h.ipamService.GetReservations(wgInterface.ID)
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+	response.Success(c, reservations)
+}
+
+// CreateIPReservation 添加保留地址范围，不指定结束地址时为静态保留单个地址
+func (h *InterfaceHandler) CreateIPReservation(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		StartIP     string `json:"start_ip" binding:"required"`
+		EndIP       string `json:"end_ip"`
+		Description string `json:"description" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	reservation, err := h.ipamService.CreateReservation(wgInterface, req.StartIP, req.EndIP, req.Description)
+	if err != nil {
+		response.BadRequest(c, "添加保留地址失败: "+err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditIPReservationCreate, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+	entry.Changes = services.AuditDiff(nil, reservation)
+	h.auditService.Record(entry)
+
+	response.Success(c, reservation)
+}
+
+// DeleteIPReservation 删除保留地址范围
+func (h *InterfaceHandler) DeleteIPReservation(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+	reservationID, err := strconv.ParseUint(c.Param("reservation_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的保留地址ID")
+		return
+	}
+
+	if err := h.ipamService.DeleteReservation(wgInterface.ID, uint(reservationID)); err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditIPReservationDelete, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+	entry.Message = fmt.Sprintf("保留地址ID %d", reservationID)
+	h.auditService.Record(entry)
+
+	response.Success(c, gin.H{"message": "保留地址删除成功"})
+}
+
+// GetIPAllocations 获取接口已分配的地址及其持有者
+func (h *InterfaceHandler) GetIPAllocations(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	allocations, err := h.ipamService.GetAllocations(wgInterface)
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+	response.Success(c, allocations)
+}
+
+// ReserveIPAddress 手动占用地址，用于不受本系统管理的设备
+func (h *InterfaceHandler) ReserveIPAddress(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IPAddress   string `json:"ip_address" binding:"required"`
+		Description string `json:"description" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	allocation, err := h.ipamService.Reserve(wgInterface, req.IPAddress, req.Description)
+	if err != nil {
+		response.BadRequest(c, "占用地址失败: "+err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditIPAllocationReserve, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+	entry.Changes = services.AuditDiff(nil, allocation)
+	h.auditService.Record(entry)
+
+	response.Success(c, allocation)
+}
+
+// ReleaseIPAddress 释放手动占用的地址
+func (h *InterfaceHandler) ReleaseIPAddress(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+	allocationID, err := strconv.ParseUint(c.Param("allocation_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的分配记录ID")
+		return
+	}
+
+	if err := h.ipamService.ReleaseReserved(wgInterface.ID, uint(allocationID)); err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditIPAllocationRelease, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+	entry.Message = fmt.Sprintf("分配记录ID %d", allocationID)
+	h.auditService.Record(entry)
+
+	response.Success(c, gin.H{"message": "地址已释放"})
+}
+
+// CheckIPConsistency 检查接口的地址分配记录是否与实际使用的地址一致
+func (h *InterfaceHandler) CheckIPConsistency(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.ipamService.CheckConsistency(wgInterface)
+	if err != nil {
+		response.InternalError(c, "检查地址分配失败: "+err.Error())
+		return
+	}
+	response.Success(c, report)
+}
+
+// RepairIPConsistency 修复接口地址分配的孤立、缺失和冲突问题
+func (h *InterfaceHandler) RepairIPConsistency(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.ipamService.RepairConsistency(wgInterface)
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
+	if len(report.Issues) > 0 {
+		entry := auditEntry(c, models.AuditIPAllocationRepair, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+		entry.Message = fmt.Sprintf("修复 %d 个地址分配问题", len(report.Issues))
+		h.auditService.Record(entry)
+	}
+
+	response.Success(c, report)
+}
+
+// RenumberInterface 把接口迁移到新网段，dry_run时只返回各地址的变化
+func (h *InterfaceHandler) RenumberInterface(c *gin.Context) {
+	wgInterface, ok := h.interfaceParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.InterfaceRenumberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	result, err := h.renumberService.RenumberInterface(wgInterface.ID, &req)
+	if err != nil {
+		response.BadRequest(c, "重新编址失败: "+err.Error())
+		return
+	}
+	if !result.Applied {
+		response.SuccessWithMessage(c, "编址计划已生成，未写入数据库", result)
+		return
+	}
+
+	entry := auditEntry(c, models.AuditInterfaceRenumber, models.AuditTargetInterface, wgInterface.ID, wgInterface.Name)
+	entry.Message = fmt.Sprintf("%d 个地址变更", len(result.Changes))
+	entry.Changes = services.AuditDiff(
+		map[string]string{"network": wgInterface.Network, "network6": wgInterface.Network6},
+		map[string]string{"network": result.Network, "network6": result.Network6})
+	h.auditService.Record(entry)
+
+	response.SuccessWithMessage(c, "接口重新编址完成，模块和用户需要更新配置", result)
+}
+
+// interfaceParam 解析路径中的接口ID并查询接口，失败时已写入响应
+func (h *InterfaceHandler) interfaceParam(c *gin.Context) (*models.WireGuardInterface, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的接口ID")
+		return nil, false
+	}
+
+	wgInterface, err := h.interfaceService.GetInterface(uint(id))
+	if err != nil {
+		response.NotFound(c, "接口不存在")
+		return nil, false
+	}
+	return wgInterface, true
+}
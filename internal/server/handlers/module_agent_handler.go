@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModuleAgentHandler 模块代理处理器（模块端调用）
+type ModuleAgentHandler struct {
+	agentService *services.ModuleAgentService
+}
+
+// NewModuleAgentHandler 创建模块代理处理器
+func NewModuleAgentHandler(agentService *services.ModuleAgentService) *ModuleAgentHandler {
+	return &ModuleAgentHandler{
+		agentService: agentService,
+	}
+}
+
+// Heartbeat 接收模块心跳
+func (mah *ModuleAgentHandler) Heartbeat(c *gin.Context) {
+	moduleID := c.GetUint("module_id")
+
+	// 心跳请求体是可选的
+	var heartbeat models.ModuleHeartbeat
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&heartbeat); err != nil {
+			response.BadRequest(c, "请求参数无效: "+err.Error())
+			return
+		}
+	}
+
+	if err := mah.agentService.RecordHeartbeat(moduleID, &heartbeat); err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "心跳已记录", nil)
+}
+
+// ReportTraffic 接收模块流量上报，流量以服务端计数器为准，上报内容不保存
+func (mah *ModuleAgentHandler) ReportTraffic(c *gin.Context) {
+	moduleID := c.GetUint("module_id")
+
+	if err := mah.agentService.RecordTraffic(moduleID); err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "流量上报已接收", nil)
+}
+
+// GetConfig 拉取模块当前配置，配置未变化时返回304
+func (mah *ModuleAgentHandler) GetConfig(c *gin.Context) {
+	moduleID := c.GetUint("module_id")
+
+	config, etag, err := mah.agentService.GetAgentConfig(moduleID)
+	if err != nil {
+		response.InternalError(c, "生成配置失败: "+err.Error())
+		return
+	}
+
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.String(http.StatusOK, config)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModuleCredentialHandler 模块凭证处理器
+type ModuleCredentialHandler struct {
+	credentialService *services.ModuleCredentialService
+}
+
+// NewModuleCredentialHandler 创建模块凭证处理器
+func NewModuleCredentialHandler(credentialService *services.ModuleCredentialService) *ModuleCredentialHandler {
+	return &ModuleCredentialHandler{
+		credentialService: credentialService,
+	}
+}
+
+// RotateCredentialRequest 轮换凭证请求
+type RotateCredentialRequest struct {
+	GraceSeconds int `json:"grace_seconds"` // 旧密钥的宽限期（秒），0表示立即失效
+}
+
+// parseCredentialParams 解析模块ID和凭证ID
+func parseCredentialParams(c *gin.Context) (uint, uint, bool) {
+	moduleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "模块ID无效")
+		return 0, 0, false
+	}
+
+	credentialID, err := strconv.ParseUint(c.Param("credential_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "凭证ID无效")
+		return 0, 0, false
+	}
+
+	return uint(moduleID), uint(credentialID), true
+}
+
+// GetCredentials 获取模块凭证列表
+func (mch *ModuleCredentialHandler) GetCredentials(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		response.BadRequest(c, "模块ID无效")
+		return
+	}
+
+	credentials, err := mch.credentialService.GetCredentials(uint(id))
+	if err != nil {
+		response.InternalError(c, err.Error())
+		return
+	}
+
+	response.Success(c, credentials)
+}
+
+// IssueCredential 签发模块凭证
+func (mch *ModuleCredentialHandler) IssueCredential(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		response.BadRequest(c, "模块ID无效")
+		return
+	}
+
+	var req models.ModuleCredentialRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, "请求参数无效: "+err.Error())
+			return
+		}
+	}
+
+	credential, apiKey, err := mch.credentialService.IssueCredential(uint(id), &req)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "模块凭证签发成功，密钥仅显示一次，请妥善保存", gin.H{
+		"credential": credential,
+		"api_key":    apiKey,
+	})
+}
+
+// RotateCredential 轮换模块凭证
+func (mch *ModuleCredentialHandler) RotateCredential(c *gin.Context) {
+	moduleID, credentialID, ok := parseCredentialParams(c)
+	if !ok {
+		return
+	}
+
+	var req RotateCredentialRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, "请求参数无效: "+err.Error())
+			return
+		}
+	}
+
+	if req.GraceSeconds < 0 {
+		response.BadRequest(c, "宽限期不能为负数")
+		return
+	}
+
+	credential, apiKey, err := mch.credentialService.RotateCredential(moduleID, credentialID, time.Duration(req.GraceSeconds)*time.Second)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "模块凭证轮换成功，密钥仅显示一次，请妥善保存", gin.H{
+		"credential": credential,
+		"api_key":    apiKey,
+	})
+}
+
+// RevokeCredential 吊销模块凭证
+func (mch *ModuleCredentialHandler) RevokeCredential(c *gin.Context) {
+	moduleID, credentialID, ok := parseCredentialParams(c)
+	if !ok {
+		return
+	}
+
+	if err := mch.credentialService.RevokeCredential(moduleID, credentialID); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "模块凭证已吊销", nil)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModuleEnrollmentHandler 模块注册处理器
+type ModuleEnrollmentHandler struct {
+	enrollmentService *services.ModuleEnrollmentService
+}
+
+// NewModuleEnrollmentHandler 创建模块注册处理器
+func NewModuleEnrollmentHandler(enrollmentService *services.ModuleEnrollmentService) *ModuleEnrollmentHandler {
+	return &ModuleEnrollmentHandler{
+		enrollmentService: enrollmentService,
+	}
+}
+
+// CreateJoinToken 为模块签发一次性加入令牌（管理端调用）
+func (meh *ModuleEnrollmentHandler) CreateJoinToken(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		response.BadRequest(c, "模块ID无效")
+		return
+	}
+
+	var req models.JoinTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, "请求参数无效: "+err.Error())
+			return
+		}
+	}
+
+	joinToken, token, err := meh.enrollmentService.CreateJoinToken(uint(id), time.Duration(req.TTLMinutes)*time.Minute)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "加入令牌签发成功，令牌仅显示一次且只能使用一次", gin.H{
+		"join_token": joinToken,
+		"token":      token,
+	})
+}
+
+// Enroll 模块使用加入令牌注册（模块端调用，无需登录）
+func (meh *ModuleEnrollmentHandler) Enroll(c *gin.Context) {
+	var req models.ModuleEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	result, err := meh.enrollmentService.Enroll(&req, c.ClientIP())
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "模块注册成功", result)
+}
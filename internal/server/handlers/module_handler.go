@@ -15,13 +15,17 @@ import (
 
 // ModuleHandler 模块管理处理器
 type ModuleHandler struct {
-	moduleService *services.ModuleService
+	moduleService   *services.ModuleService
+	renumberService *services.RenumberService
+	auditService    *services.AuditService
 }
 
 // NewModuleHandler 创建模块处理器
 func NewModuleHandler(moduleService *services.ModuleService) *ModuleHandler {
 	return &ModuleHandler{
-		moduleService: moduleService,
+		moduleService:   moduleService,
+		renumberService: services.NewRenumberService(),
+		auditService:    services.NewAuditService(),
 	}
 }
 
@@ -37,6 +41,8 @@ type CreateModuleRequest struct {
 	DNS                 string `json:"dns,omitempty"`
 	AutoGenerateKeys    bool   `json:"auto_generate_keys,omitempty"`
 	AutoAssignIP        bool   `json:"auto_assign_ip,omitempty"`
+	IPAddress           string `json:"ip_address,omitempty"`  // 手动指定VPN地址，可使用静态保留的地址
+	IPAddress6          string `json:"ip_address6,omitempty"` // 手动指定VPN IPv6地址
 	ConfigTemplate      string `json:"config_template,omitempty"`
 }
 
@@ -82,6 +88,10 @@ func (mh *ModuleHandler) CreateModule(c *gin.Context) {
 	if !req.AutoAssignIP {
 		req.AutoAssignIP = true
 	}
+	// 手动指定地址时不自动分配
+	if req.IPAddress != "" {
+		req.AutoAssignIP = false
+	}
 
 	// 验证配置参数
 	if req.PersistentKeepalive < 0 || req.PersistentKeepalive > 300 {
@@ -101,6 +111,8 @@ func (mh *ModuleHandler) CreateModule(c *gin.Context) {
 		DNS:                 req.DNS,
 		AutoGenerateKeys:    req.AutoGenerateKeys,
 		AutoAssignIP:        req.AutoAssignIP,
+		IPAddress:           req.IPAddress,
+		IPAddress6:          req.IPAddress6,
 		ConfigTemplate:      req.ConfigTemplate,
 	}
 
@@ -110,6 +122,10 @@ func (mh *ModuleHandler) CreateModule(c *gin.Context) {
 		return
 	}
 
+	entry := auditEntry(c, models.AuditModuleCreate, models.AuditTargetModule, module.ID, module.Name)
+	entry.Changes = services.AuditDiff(nil, module)
+	mh.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "模块创建成功，WireGuard配置已自动更新", gin.H{
 		"data": module,
 		"note": "如果接口正在运行，配置文件已自动重新生成并应用",
@@ -223,11 +239,23 @@ func (mh *ModuleHandler) UpdateModule(c *gin.Context) {
 		return
 	}
 
+	before, err := mh.moduleService.GetModule(uint(id))
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
 	if err := mh.moduleService.UpdateModule(uint(id), updates); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
 
+	entry := auditEntry(c, models.AuditModuleUpdate, models.AuditTargetModule, before.ID, before.Name)
+	if after, err := mh.moduleService.GetModule(uint(id)); err == nil {
+		entry.Changes = services.AuditDiff(before, after)
+	}
+	mh.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "模块更新成功", nil)
 }
 
@@ -240,15 +268,52 @@ func (mh *ModuleHandler) DeleteModule(c *gin.Context) {
 		return
 	}
 
+	module, err := mh.moduleService.GetModule(uint(id))
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
 	// 先删除模块相关的用户VPN配置，再删除模块本身
 	if err := mh.moduleService.DeleteModule(uint(id)); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
 
+	entry := auditEntry(c, models.AuditModuleDelete, models.AuditTargetModule, module.ID, module.Name)
+	entry.Changes = services.AuditDiff(module, nil)
+	mh.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "模块删除成功，相关用户VPN配置已同步清理", nil)
 }
 
+// ChangeModuleAddress 修改模块的VPN地址
+func (mh *ModuleHandler) ChangeModuleAddress(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "模块ID无效")
+		return
+	}
+
+	var req models.PeerAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	result, err := mh.renumberService.ChangeModuleAddress(uint(id), &req)
+	if err != nil {
+		response.BadRequest(c, "修改模块地址失败: "+err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditModuleChangeAddress, models.AuditTargetModule, uint(id), result.Changes[0].Name)
+	entry.Changes = addressChanges(result)
+	mh.auditService.Record(entry)
+
+	response.SuccessWithMessage(c, "模块地址已修改，需要更新模块配置", result)
+}
+
 // GenerateModuleConfig 生成模块配置
 func (mh *ModuleHandler) GenerateModuleConfig(c *gin.Context) {
 	idStr := c.Param("id")
@@ -309,12 +374,23 @@ func (mh *ModuleHandler) RegenerateKeys(c *gin.Context) {
 		return
 	}
 
+	before, err := mh.moduleService.GetModule(uint(id))
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
 	module, err := mh.moduleService.RegenerateModuleKeys(uint(id))
 	if err != nil {
 		response.InternalError(c, "重新生成密钥失败: "+err.Error())
 		return
 	}
 
+	// 私钥不记录，公钥的变化即可说明密钥已更换
+	entry := auditEntry(c, models.AuditModuleRegenerateKeys, models.AuditTargetModule, module.ID, module.Name)
+	entry.Changes = services.AuditDiff(before, module)
+	mh.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "密钥重新生成成功", module)
 }
 
@@ -375,11 +451,21 @@ func (mh *ModuleHandler) UpdateModuleStatus(c *gin.Context) {
 		return
 	}
 
+	before, err := mh.moduleService.GetModule(uint(id))
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
 	if err := mh.moduleService.UpdateModuleStatus(uint(id), status); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
 
+	entry := auditEntry(c, models.AuditModuleStatus, models.AuditTargetModule, before.ID, before.Name)
+	entry.Changes = map[string]models.AuditChange{"status": {Before: before.Status, After: status}}
+	mh.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "模块状态更新成功", nil)
 }
 
@@ -398,11 +484,19 @@ func (mh *ModuleHandler) BatchDeleteModules(c *gin.Context) {
 	successCount := 0
 
 	for _, id := range req.IDs {
-		if err := mh.moduleService.DeleteModule(id); err != nil {
+		module, err := mh.moduleService.GetModule(id)
+		if err == nil {
+			err = mh.moduleService.DeleteModule(id)
+		}
+		if err != nil {
 			errors = append(errors, fmt.Sprintf("删除模块 %d 失败: %s", id, err.Error()))
-		} else {
-			successCount++
+			continue
 		}
+		successCount++
+
+		entry := auditEntry(c, models.AuditModuleDelete, models.AuditTargetModule, module.ID, module.Name)
+		entry.Changes = services.AuditDiff(module, nil)
+		mh.auditService.Record(entry)
 	}
 
 	result := gin.H{
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// oidcStateCookie 保存单点登录状态的cookie
+	oidcStateCookie = "oidc_state"
+	// oidcCookiePath 登录状态cookie只发送给单点登录接口
+	oidcCookiePath = "/api/v1/auth/oidc"
+	// oidcLoginPage 单点登录完成后跳转的登录页，令牌或错误信息放在URL片段中，不会发送到服务器
+	oidcLoginPage = "/login"
+)
+
+// oidcUserErrors 可以直接展示给用户的单点登录错误，其他错误只记录日志
+var oidcUserErrors = []error{
+	auth.ErrOIDCState,
+	auth.ErrAccessDenied,
+	auth.ErrUsernameTaken,
+	auth.ErrAccountDisabled,
+}
+
+// GetAuthProviders 获取登录页可用的登录方式
+func (ah *AuthHandler) GetAuthProviders(c *gin.Context) {
+	response.Success(c, gin.H{
+		"password_login": auth.PasswordLoginEnabled(),
+		"oidc": gin.H{
+			"enabled":   ah.oidcService.Enabled(),
+			"name":      ah.oidcService.Name(),
+			"login_url": oidcCookiePath + "/login",
+		},
+	})
+}
+
+// OIDCLogin 发起单点登录：写入登录状态cookie后跳转到身份提供方
+func (ah *AuthHandler) OIDCLogin(c *gin.Context) {
+	authURL, state, err := ah.oidcService.Begin(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCDisabled) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		log.Printf("发起单点登录失败: %v", err)
+		oidcRedirect(c, url.Values{"error": {"无法连接身份提供方，请稍后重试"}})
+		return
+	}
+
+	// 身份提供方回调是跨站的顶级导航，需要SameSite=Lax才能带上cookie
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(auth.OIDCStateExpiry.Seconds()), oidcCookiePath, "", ah.oidcService.SecureCookie(), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback 身份提供方回调：校验后创建会话，并跳转回登录页交付令牌
+func (ah *AuthHandler) OIDCCallback(c *gin.Context) {
+	// 登录状态只能使用一次
+	state, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", ah.oidcService.SecureCookie(), true)
+
+	if idpError := c.Query("error"); idpError != "" {
+		message := c.Query("error_description")
+		if message == "" {
+			message = idpError
+		}
+		ah.recordLogin(c, "", nil, "身份提供方拒绝登录: "+message)
+		oidcRedirect(c, url.Values{"error": {"身份提供方拒绝登录: " + message}})
+		return
+	}
+
+	user, created, err := ah.oidcService.Complete(c.Request.Context(), state, c.Query("state"), c.Query("code"))
+	if err != nil {
+		ah.recordLogin(c, "", nil, "单点登录失败: "+err.Error())
+		oidcRedirect(c, url.Values{"error": {oidcErrorMessage(err)}})
+		return
+	}
+
+	if created {
+		entry := auditEntry(c, models.AuditUserCreate, models.AuditTargetUser, user.ID, user.Username)
+		entry.Actor = user.Username
+		entry.ActorID = user.ID
+		entry.ActorRole = user.Role
+		entry.Changes = services.AuditDiff(nil, user)
+		ah.auditService.Record(entry)
+	}
+
+	tokenPair, err := ah.startSession(c, user)
+	if err != nil {
+		oidcRedirect(c, url.Values{"error": {err.Error()}})
+		return
+	}
+	ah.recordLogin(c, user.Username, user, "")
+
+	oidcRedirect(c, url.Values{
+		"access_token":  {tokenPair.AccessToken},
+		"refresh_token": {tokenPair.RefreshToken},
+		"expires_in":    {strconv.FormatInt(tokenPair.ExpiresIn, 10)},
+	})
+}
+
+// oidcErrorMessage 获取展示给用户的错误信息，内部错误只记录日志
+func oidcErrorMessage(err error) string {
+	for _, userError := range oidcUserErrors {
+		if errors.Is(err, userError) {
+			return userError.Error()
+		}
+	}
+	log.Printf("单点登录失败: %v", err)
+	return "单点登录失败，请稍后重试"
+}
+
+// oidcRedirect 跳转回登录页，参数放在URL片段中
+func oidcRedirect(c *gin.Context, fragment url.Values) {
+	c.Redirect(http.StatusFound, oidcLoginPage+"#"+fragment.Encode())
+}
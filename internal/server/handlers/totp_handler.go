@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/lockout"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MFAChallengeResponse 密码验证通过后要求两步验证的响应
+type MFAChallengeResponse struct {
+	MFARequired      bool   `json:"mfa_required,omitempty"`       // 需要提交验证码
+	MFASetupRequired bool   `json:"mfa_setup_required,omitempty"` // 需要先绑定验证器
+	MFAToken         string `json:"mfa_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+}
+
+// MFALoginRequest 登录第二步请求
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code"` // 验证码或恢复码
+}
+
+// TOTPCodeRequest 验证码请求
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTOTPRequest 关闭两步验证请求
+type DisableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// respondMFAChallenge 返回两步验证临时令牌，此时不签发访问令牌
+func (ah *AuthHandler) respondMFAChallenge(c *gin.Context, user *models.User, tokenType auth.TokenType) {
+	token, err := ah.jwtService.GenerateMFAToken(user, tokenType)
+	if err != nil {
+		response.InternalError(c, "生成令牌失败")
+		return
+	}
+
+	response.Success(c, MFAChallengeResponse{
+		MFARequired:      tokenType == auth.MFAToken,
+		MFASetupRequired: tokenType == auth.MFASetupToken,
+		MFAToken:         token,
+		ExpiresIn:        int64(auth.MFATokenExpiry.Seconds()),
+	})
+}
+
+// mfaUser 校验两步验证临时令牌并返回对应的用户
+func (ah *AuthHandler) mfaUser(c *gin.Context, token string, tokenType auth.TokenType) (*models.User, bool) {
+	claims, err := ah.jwtService.ValidateMFAToken(token, tokenType)
+	if err != nil {
+		response.Unauthorized(c, "两步验证令牌无效或已过期")
+		return nil, false
+	}
+
+	user, err := ah.userService.GetUserByID(claims.UserID)
+	if err != nil {
+		response.Unauthorized(c, "用户不存在或已被禁用")
+		return nil, false
+	}
+	return user, true
+}
+
+// LoginTOTP 登录第二步：提交验证码或恢复码，通过后签发令牌对
+func (ah *AuthHandler) LoginTOTP(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	user, ok := ah.mfaUser(c, req.MFAToken, auth.MFAToken)
+	if !ok {
+		return
+	}
+
+	verified, err := ah.userService.VerifySecondFactor(user.ID, req.Code, c.ClientIP())
+	if err != nil {
+		ah.recordLogin(c, user.Username, user, err.Error())
+		if errors.Is(err, auth.ErrInvalidTOTPCode) {
+			response.Unauthorized(c, err.Error())
+			return
+		}
+		respondTOTPError(c, err)
+		return
+	}
+
+	ah.completeLogin(c, verified, nil)
+}
+
+// LoginTOTPSetup 策略要求两步验证时，未绑定的用户在登录过程中生成密钥
+func (ah *AuthHandler) LoginTOTPSetup(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	user, ok := ah.mfaUser(c, req.MFAToken, auth.MFASetupToken)
+	if !ok {
+		return
+	}
+
+	enrollment, err := ah.userService.BeginTOTPEnrollment(user.ID)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+
+	response.Success(c, enrollment)
+}
+
+// LoginTOTPEnable 策略要求两步验证时，未绑定的用户在登录过程中确认绑定，成功后签发令牌对和恢复码
+func (ah *AuthHandler) LoginTOTPEnable(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	user, ok := ah.mfaUser(c, req.MFAToken, auth.MFASetupToken)
+	if !ok {
+		return
+	}
+
+	codes, err := ah.userService.EnableTOTP(user.ID, req.Code, c.ClientIP())
+	if err != nil {
+		ah.recordLogin(c, user.Username, user, err.Error())
+		respondTOTPError(c, err)
+		return
+	}
+
+	ah.completeLogin(c, user, codes)
+}
+
+// GetTOTPStatus 获取当前用户的两步验证状态
+func (ah *AuthHandler) GetTOTPStatus(c *gin.Context) {
+	user, err := ah.userService.GetUserByID(c.GetUint("user_id"))
+	if err != nil {
+		response.NotFound(c, "用户不存在")
+		return
+	}
+
+	response.Success(c, gin.H{
+		"enabled":                  user.TOTPEnabled,
+		"required":                 ah.userService.TwoFactorRequired(),
+		"recovery_codes_remaining": user.RecoveryCodesRemaining(),
+	})
+}
+
+// SetupTOTP 为当前用户生成待确认的两步验证密钥
+func (ah *AuthHandler) SetupTOTP(c *gin.Context) {
+	enrollment, err := ah.userService.BeginTOTPEnrollment(c.GetUint("user_id"))
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+
+	response.Success(c, enrollment)
+}
+
+// EnableTOTP 当前用户确认绑定两步验证，返回恢复码
+func (ah *AuthHandler) EnableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	codes, err := ah.userService.EnableTOTP(c.GetUint("user_id"), req.Code, c.ClientIP())
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "两步验证已启用，请妥善保存恢复码", gin.H{"recovery_codes": codes})
+}
+
+// DisableTOTP 当前用户关闭两步验证
+func (ah *AuthHandler) DisableTOTP(c *gin.Context) {
+	var req DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	if err := ah.userService.DisableTOTP(c.GetUint("user_id"), req.Password, req.Code); err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "两步验证已关闭", nil)
+}
+
+// RegenerateRecoveryCodes 重新生成当前用户的恢复码
+func (ah *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+
+	codes, err := ah.userService.RegenerateRecoveryCodes(c.GetUint("user_id"), req.Code)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+
+	response.Success(c, gin.H{"recovery_codes": codes})
+}
+
+// respondTOTPError 将两步验证相关错误转换为响应
+func respondTOTPError(c *gin.Context, err error) {
+	var locked *lockout.LockedError
+	if errors.As(err, &locked) {
+		response.TooManyRequests(c, locked.Error(), locked.RetryAfter())
+		return
+	}
+	response.BadRequest(c, err.Error())
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrafficHandler 流量历史处理器
+type TrafficHandler struct {
+	trafficService *services.TrafficService
+}
+
+// NewTrafficHandler 创建流量历史处理器
+func NewTrafficHandler(trafficService *services.TrafficService) *TrafficHandler {
+	return &TrafficHandler{
+		trafficService: trafficService,
+	}
+}
+
+// GetTraffic 查询全部对等端的流量历史
+func (th *TrafficHandler) GetTraffic(c *gin.Context) {
+	th.query(c, &services.TrafficQuery{})
+}
+
+// GetModuleTraffic 查询模块的流量历史
+func (th *TrafficHandler) GetModuleTraffic(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的模块ID")
+		return
+	}
+	th.query(c, &services.TrafficQuery{PeerType: models.TrafficPeerModule, PeerID: uint(id)})
+}
+
+// GetUserVPNTraffic 查询用户VPN的流量历史
+func (th *TrafficHandler) GetUserVPNTraffic(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的用户VPN ID")
+		return
+	}
+	th.query(c, &services.TrafficQuery{PeerType: models.TrafficPeerUserVPN, PeerID: uint(id)})
+}
+
+// GetInterfaceTraffic 查询接口下所有对等端的流量历史
+func (th *TrafficHandler) GetInterfaceTraffic(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的接口ID")
+		return
+	}
+	th.query(c, &services.TrafficQuery{InterfaceID: uint(id)})
+}
+
+// query 解析时间范围参数并查询。支持 from/to（RFC3339）、range（如6h，相对于to）和 resolution（1m/1h/1d）
+func (th *TrafficHandler) query(c *gin.Context, query *services.TrafficQuery) {
+	if err := parseTrafficRange(c, query); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	history, err := th.trafficService.Query(query)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.Success(c, history)
+}
+
+// parseTrafficRange 解析流量查询的时间范围参数
+func parseTrafficRange(c *gin.Context, query *services.TrafficQuery) error {
+	if value := c.Query("to"); value != "" {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("无效的结束时间: %s", value)
+		}
+		query.To = to
+	}
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+
+	if value := c.Query("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("无效的开始时间: %s", value)
+		}
+		query.From = from
+	} else if value := c.Query("range"); value != "" {
+		span, err := time.ParseDuration(value)
+		if err != nil || span <= 0 {
+			return fmt.Errorf("无效的时间范围: %s", value)
+		}
+		query.From = query.To.Add(-span)
+	}
+
+	if value := c.Query("resolution"); value != "" {
+		resolution := models.TrafficResolution(value)
+		if resolution.Duration() == 0 {
+			return fmt.Errorf("无效的汇总粒度: %s，可选 1m、1h、1d", value)
+		}
+		query.Resolution = resolution
+	}
+
+	return nil
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"strconv"
 
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
 	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/lockout"
 	"eitec-vpn/internal/shared/response"
 	"eitec-vpn/internal/shared/utils"
 
@@ -12,27 +17,38 @@ import (
 
 // UserHandler 用户管理处理器
 type UserHandler struct {
-	userService *auth.UserService
+	userService    *auth.UserService
+	sessionManager *auth.SessionManager
+	auditService   *services.AuditService
 }
 
 // NewUserHandler 创建用户管理处理器
-func NewUserHandler(userService *auth.UserService) *UserHandler {
+func NewUserHandler(userService *auth.UserService, sessionManager *auth.SessionManager) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		userService:    userService,
+		sessionManager: sessionManager,
+		auditService:   services.NewAuditService(),
 	}
 }
 
 // CreateUserRequest 创建用户请求
 type CreateUserRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required,min=6"`
+	Username string          `json:"username" binding:"required"`
+	Password string          `json:"password" binding:"required,min=6"`
+	Role     models.UserRole `json:"role"` // admin、operator 或 viewer，默认 viewer
 }
 
 // UpdateUserRequest 更新用户请求
 type UpdateUserRequest struct {
+	Username string          `json:"username"`
+	Role     models.UserRole `json:"role"`
+	IsActive *bool           `json:"is_active"`
+}
+
+// LDAPTestRequest LDAP连接测试请求，用户名和密码可选
+type LDAPTestRequest struct {
 	Username string `json:"username"`
-	Role     string `json:"role"`
-	IsActive *bool  `json:"is_active"`
+	Password string `json:"password"`
 }
 
 // GetUsers 获取用户列表
@@ -65,7 +81,7 @@ func (uh *UserHandler) CreateUser(c *gin.Context) {
 	}
 
 	// 创建用户
-	user, err := uh.userService.CreateUser(req.Username, req.Password)
+	user, err := uh.userService.CreateUser(req.Username, req.Password, req.Role)
 	if err != nil {
 		response.BadRequest(c, err.Error())
 		return
@@ -74,6 +90,10 @@ func (uh *UserHandler) CreateUser(c *gin.Context) {
 	// 移除密码字段
 	user.Password = ""
 
+	entry := auditEntry(c, models.AuditUserCreate, models.AuditTargetUser, user.ID, user.Username)
+	entry.Changes = services.AuditDiff(nil, user)
+	uh.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "用户创建成功", user)
 }
 
@@ -110,6 +130,12 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// 不允许修改自己的角色或禁用自己，避免误操作后失去管理权限
+	if uint(id) == c.GetUint("user_id") && (req.Role != "" || (req.IsActive != nil && !*req.IsActive)) {
+		response.BadRequest(c, "不能修改自己的角色或禁用自己")
+		return
+	}
+
 	// 构建更新数据
 	updates := make(map[string]interface{})
 	if req.Username != "" {
@@ -122,10 +148,17 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 		updates["is_active"] = *req.IsActive
 	}
 
+	before, _ := uh.userService.FindUser(uint(id))
 	if err := uh.userService.UpdateUser(uint(id), updates); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
+	uh.recordChange(c, uint(id), before)
+
+	// 角色变化或被禁用后，已签发令牌中的旧权限不能继续使用
+	if req.Role != "" || (req.IsActive != nil && !*req.IsActive) {
+		uh.revokeSessions(uint(id))
+	}
 
 	response.SuccessWithMessage(c, "用户更新成功", nil)
 }
@@ -139,10 +172,24 @@ func (uh *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if uint(id) == c.GetUint("user_id") {
+		response.BadRequest(c, "不能删除自己")
+		return
+	}
+
+	user, _ := uh.userService.FindUser(uint(id))
 	if err := uh.userService.DeleteUser(uint(id)); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
+	uh.revokeSessions(uint(id))
+
+	entry := auditEntry(c, models.AuditUserDelete, models.AuditTargetUser, uint(id), "")
+	if user != nil {
+		entry.TargetName = user.Username
+		entry.Changes = services.AuditDiff(user, nil)
+	}
+	uh.auditService.Record(entry)
 
 	response.SuccessWithMessage(c, "用户删除成功", nil)
 }
@@ -164,18 +211,26 @@ func (uh *UserHandler) UpdateUserStatus(c *gin.Context) {
 		return
 	}
 
+	if uint(id) == c.GetUint("user_id") && !req.IsActive {
+		response.BadRequest(c, "不能禁用自己")
+		return
+	}
+
 	updates := map[string]interface{}{
 		"is_active": req.IsActive,
 	}
 
+	before, _ := uh.userService.FindUser(uint(id))
 	if err := uh.userService.UpdateUser(uint(id), updates); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
+	uh.recordChange(c, uint(id), before)
 
 	status := "启用"
 	if !req.IsActive {
 		status = "禁用"
+		uh.revokeSessions(uint(id))
 	}
 
 	response.SuccessWithMessage(c, "用户"+status+"成功", nil)
@@ -207,6 +262,168 @@ func (uh *UserHandler) ResetPassword(c *gin.Context) {
 		response.BadRequest(c, err.Error())
 		return
 	}
+	uh.revokeSessions(uint(id))
+	uh.recordAction(c, models.AuditUserResetPassword, uint(id))
 
 	response.SuccessWithMessage(c, "密码重置成功", nil)
 }
+
+// ResetTOTP 清除用户的两步验证，用户丢失验证器时由管理员操作
+func (uh *UserHandler) ResetTOTP(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "用户ID格式错误")
+		return
+	}
+
+	if err := uh.userService.ResetTOTP(uint(id)); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	uh.recordAction(c, models.AuditUserResetTOTP, uint(id))
+
+	response.SuccessWithMessage(c, "两步验证已重置", nil)
+}
+
+// GetUserSessions 获取用户的有效会话
+func (uh *UserHandler) GetUserSessions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "用户ID格式错误")
+		return
+	}
+
+	sessions, err := uh.sessionManager.GetUserSessions(uint(id))
+	if err != nil {
+		response.InternalError(c, "获取会话列表失败")
+		return
+	}
+
+	response.Success(c, sessions)
+}
+
+// RevokeUserSessions 强制用户下线，撤销其全部会话
+func (uh *UserHandler) RevokeUserSessions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "用户ID格式错误")
+		return
+	}
+
+	if err := uh.sessionManager.DestroyUserSessions(uint(id)); err != nil {
+		response.InternalError(c, "撤销会话失败")
+		return
+	}
+	uh.recordAction(c, models.AuditUserRevokeSessions, uint(id))
+
+	response.SuccessWithMessage(c, "用户会话已撤销", nil)
+}
+
+// recordChange 记录用户信息的修改，before为修改前的用户
+func (uh *UserHandler) recordChange(c *gin.Context, userID uint, before *models.User) {
+	entry := auditEntry(c, models.AuditUserUpdate, models.AuditTargetUser, userID, "")
+	if after, err := uh.userService.FindUser(userID); err == nil {
+		entry.TargetName = after.Username
+		if before != nil {
+			entry.Changes = services.AuditDiff(before, after)
+		}
+	}
+	uh.auditService.Record(entry)
+}
+
+// recordAction 记录不涉及可见字段变化的用户操作，如重置密码
+func (uh *UserHandler) recordAction(c *gin.Context, action string, userID uint) {
+	entry := auditEntry(c, action, models.AuditTargetUser, userID, "")
+	if user, err := uh.userService.FindUser(userID); err == nil {
+		entry.TargetName = user.Username
+	}
+	uh.auditService.Record(entry)
+}
+
+// revokeSessions 撤销用户的全部会话，已签发的令牌随之失效
+func (uh *UserHandler) revokeSessions(userID uint) {
+	if err := uh.sessionManager.DestroyUserSessions(userID); err != nil {
+		log.Printf("撤销用户 %d 的会话失败: %v", userID, err)
+	}
+}
+
+// GetLockouts 获取当前被锁定的账户和IP
+func (uh *UserHandler) GetLockouts(c *gin.Context) {
+	records, err := uh.userService.GetLockouts()
+	if err != nil {
+		response.InternalError(c, "获取锁定列表失败")
+		return
+	}
+
+	response.Success(c, records)
+}
+
+// GetLockoutEvents 获取最近的锁定和解锁事件，limit默认50，最大500
+func (uh *UserHandler) GetLockoutEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		response.BadRequest(c, "limit格式错误")
+		return
+	}
+	limit = min(limit, 500)
+
+	events, err := uh.userService.GetLockoutEvents(limit)
+	if err != nil {
+		response.InternalError(c, "获取锁定事件失败")
+		return
+	}
+
+	response.Success(c, events)
+}
+
+// Unlock 手动解锁账户或IP，kind为user或ip
+func (uh *UserHandler) Unlock(c *gin.Context) {
+	kind, key := c.Param("kind"), c.Param("key")
+	if kind != lockout.KindUser && kind != lockout.KindIP {
+		response.BadRequest(c, "锁定类型必须为user或ip")
+		return
+	}
+
+	if err := uh.userService.Unlock(kind, key, c.GetString("username")); err != nil {
+		if errors.Is(err, lockout.ErrNotLocked) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		response.InternalError(c, "解锁失败")
+		return
+	}
+
+	response.SuccessWithMessage(c, "解锁成功", nil)
+}
+
+// TestLDAP 测试LDAP连接和服务账号绑定，提供用户名时返回用户DN、所属组和映射的角色，
+// 同时提供密码时校验密码
+func (uh *UserHandler) TestLDAP(c *gin.Context) {
+	ldap := uh.userService.LDAP()
+	if ldap == nil {
+		response.NotFound(c, "未启用LDAP认证")
+		return
+	}
+
+	var req LDAPTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数错误")
+		return
+	}
+	if req.Password != "" && req.Username == "" {
+		response.BadRequest(c, "校验密码需要提供用户名")
+		return
+	}
+
+	result, err := ldap.TestConnection(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			response.BadRequest(c, "用户不存在或密码错误")
+			return
+		}
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "LDAP连接成功", result)
+}
@@ -14,13 +14,17 @@ import (
 
 // UserVPNHandler 用户VPN处理器
 type UserVPNHandler struct {
-	userVPNService *services.UserVPNService
+	userVPNService  *services.UserVPNService
+	renumberService *services.RenumberService
+	auditService    *services.AuditService
 }
 
 // NewUserVPNHandler 创建用户VPN处理器
 func NewUserVPNHandler() *UserVPNHandler {
 	return &UserVPNHandler{
-		userVPNService: services.NewUserVPNService(),
+		userVPNService:  services.NewUserVPNService(),
+		renumberService: services.NewRenumberService(),
+		auditService:    services.NewAuditService(),
 	}
 }
 
@@ -47,6 +51,10 @@ func (h *UserVPNHandler) CreateUserVPN(c *gin.Context) {
 		return
 	}
 
+	entry := auditEntry(c, models.AuditUserVPNCreate, models.AuditTargetUserVPN, userVPN.ID, userVPN.Username)
+	entry.Changes = services.AuditDiff(nil, userVPN)
+	h.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "用户VPN创建成功", userVPN)
 }
 
@@ -111,11 +119,23 @@ func (h *UserVPNHandler) UpdateUserVPN(c *gin.Context) {
 		return
 	}
 
+	before, err := h.userVPNService.GetUserVPN(uint(id))
+	if err != nil {
+		response.NotFound(c, "用户VPN不存在: "+err.Error())
+		return
+	}
+
 	if err := h.userVPNService.UpdateUserVPN(uint(id), updates); err != nil {
 		response.InternalError(c, "更新用户VPN失败: "+err.Error())
 		return
 	}
 
+	entry := auditEntry(c, models.AuditUserVPNUpdate, models.AuditTargetUserVPN, before.ID, before.Username)
+	if after, err := h.userVPNService.GetUserVPN(uint(id)); err == nil {
+		entry.Changes = services.AuditDiff(before, after)
+	}
+	h.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "用户VPN更新成功", nil)
 }
 
@@ -128,14 +148,51 @@ func (h *UserVPNHandler) DeleteUserVPN(c *gin.Context) {
 		return
 	}
 
+	userVPN, err := h.userVPNService.GetUserVPN(uint(id))
+	if err != nil {
+		response.NotFound(c, "用户VPN不存在: "+err.Error())
+		return
+	}
+
 	if err := h.userVPNService.DeleteUserVPN(uint(id)); err != nil {
 		response.InternalError(c, "删除用户VPN失败: "+err.Error())
 		return
 	}
 
+	entry := auditEntry(c, models.AuditUserVPNDelete, models.AuditTargetUserVPN, userVPN.ID, userVPN.Username)
+	entry.Changes = services.AuditDiff(userVPN, nil)
+	h.auditService.Record(entry)
+
 	response.SuccessWithMessage(c, "用户VPN删除成功", nil)
 }
 
+// ChangeUserVPNAddress 修改用户VPN的地址
+func (h *UserVPNHandler) ChangeUserVPNAddress(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的用户VPN ID")
+		return
+	}
+
+	var req models.PeerAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "请求参数无效: "+err.Error())
+		return
+	}
+
+	result, err := h.renumberService.ChangeUserVPNAddress(uint(id), &req)
+	if err != nil {
+		response.BadRequest(c, "修改用户VPN地址失败: "+err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditUserVPNChangeAddress, models.AuditTargetUserVPN, uint(id), result.Changes[0].Name)
+	entry.Changes = addressChanges(result)
+	h.auditService.Record(entry)
+
+	response.SuccessWithMessage(c, "用户VPN地址已修改，需要重新下载配置", result)
+}
+
 // GenerateUserVPNConfig 生成用户VPN配置文件
 func (h *UserVPNHandler) GenerateUserVPNConfig(c *gin.Context) {
 	idStr := c.Param("id")
@@ -167,6 +224,118 @@ func (h *UserVPNHandler) GenerateUserVPNConfig(c *gin.Context) {
 	c.String(http.StatusOK, config)
 }
 
+// GetUserVPNDevices 获取用户VPN的附加设备列表
+func (h *UserVPNHandler) GetUserVPNDevices(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的用户VPN ID")
+		return
+	}
+
+	devices, err := h.userVPNService.GetDevices(uint(id))
+	if err != nil {
+		response.NotFound(c, "用户VPN不存在: "+err.Error())
+		return
+	}
+
+	response.Success(c, devices)
+}
+
+// CreateUserVPNDevice 为用户VPN添加设备
+func (h *UserVPNHandler) CreateUserVPNDevice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的用户VPN ID")
+		return
+	}
+
+	var req models.UserVPNDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "参数验证失败: "+err.Error())
+		return
+	}
+
+	userVPN, err := h.userVPNService.GetUserVPN(uint(id))
+	if err != nil {
+		response.NotFound(c, "用户VPN不存在: "+err.Error())
+		return
+	}
+
+	device, err := h.userVPNService.CreateDevice(userVPN.ID, &req)
+	if err != nil {
+		response.BadRequest(c, "添加设备失败: "+err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditUserVPNDeviceCreate, models.AuditTargetUserVPN, userVPN.ID, userVPN.Username)
+	entry.Changes = services.AuditDiff(nil, device)
+	h.auditService.Record(entry)
+
+	response.SuccessWithMessage(c, "设备添加成功", device)
+}
+
+// DeleteUserVPNDevice 删除用户VPN的附加设备
+func (h *UserVPNHandler) DeleteUserVPNDevice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的用户VPN ID")
+		return
+	}
+	deviceID, err := strconv.ParseUint(c.Param("device_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的设备ID")
+		return
+	}
+
+	userVPN, err := h.userVPNService.GetUserVPN(uint(id))
+	if err != nil {
+		response.NotFound(c, "用户VPN不存在: "+err.Error())
+		return
+	}
+	device, err := h.userVPNService.GetDevice(userVPN.ID, uint(deviceID))
+	if err != nil {
+		response.NotFound(c, err.Error())
+		return
+	}
+
+	if err := h.userVPNService.DeleteDevice(userVPN.ID, device.ID); err != nil {
+		response.InternalError(c, "删除设备失败: "+err.Error())
+		return
+	}
+
+	entry := auditEntry(c, models.AuditUserVPNDeviceDelete, models.AuditTargetUserVPN, userVPN.ID, userVPN.Username)
+	entry.Changes = services.AuditDiff(device, nil)
+	h.auditService.Record(entry)
+
+	response.SuccessWithMessage(c, "设备删除成功", nil)
+}
+
+// GenerateUserVPNDeviceConfig 生成附加设备的配置文件
+func (h *UserVPNHandler) GenerateUserVPNDeviceConfig(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的用户VPN ID")
+		return
+	}
+	deviceID, err := strconv.ParseUint(c.Param("device_id"), 10, 32)
+	if err != nil {
+		response.BadRequest(c, "无效的设备ID")
+		return
+	}
+
+	config, err := h.userVPNService.GenerateDeviceConfig(uint(id), uint(deviceID))
+	if err != nil {
+		response.NotFound(c, "生成配置文件失败: "+err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("user_%d_device_%d_vpn_config.conf", id, deviceID)
+
+	c.Header("Content-Type", "text/plain")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.String(http.StatusOK, config)
+}
+
 // GetUserVPNStats 获取模块的用户VPN统计
 func (h *UserVPNHandler) GetUserVPNStats(c *gin.Context) {
 	moduleIDStr := c.Param("id") // 从 moduleId 改为 id
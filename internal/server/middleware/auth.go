@@ -1,14 +1,16 @@
 package middleware
 
 import (
+	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/shared/auth"
 	"eitec-vpn/internal/shared/response"
 
 	"github.com/gin-gonic/gin"
 )
 
-// JWTAuthMiddleware JWT认证中间件
-func JWTAuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
+// JWTAuthMiddleware JWT认证中间件，除校验签名外还要求令牌所属会话未被撤销且属于令牌中的用户。
+// 以 evp_ 开头的Bearer令牌按个人访问令牌校验
+func JWTAuthMiddleware(jwtService *auth.JWTService, sessionManager *auth.SessionManager, tokenService *auth.APITokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从Header获取token
 		authHeader := c.GetHeader("Authorization")
@@ -26,6 +28,23 @@ func JWTAuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
 			return
 		}
 
+		if auth.IsAPIToken(token) {
+			apiToken, user, err := tokenService.Authenticate(token, c.ClientIP())
+			if err != nil {
+				response.Unauthorized(c, "个人访问令牌无效或已失效")
+				c.Abort()
+				return
+			}
+
+			c.Set("user_id", user.ID)
+			c.Set("username", user.Username)
+			c.Set("user_role", user.Role)
+			c.Set("api_token", apiToken)
+
+			c.Next()
+			return
+		}
+
 		// 验证token
 		claims, err := jwtService.ValidateAccessToken(token)
 		if err != nil {
@@ -34,69 +53,104 @@ func JWTAuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
 			return
 		}
 
+		// 验证会话，用户名和角色以数据库为准
+		session, user, err := sessionManager.AuthenticateAccessToken(claims)
+		if err != nil {
+			response.Unauthorized(c, "会话已失效，请重新登录")
+			c.Abort()
+			return
+		}
+
 		// 设置用户信息到上下文
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("user_role", user.Role)
+		c.Set("session_id", session.ID)
 		c.Set("claims", claims)
 
 		c.Next()
 	}
 }
 
-// SessionAuthMiddleware 会话认证中间件
-func SessionAuthMiddleware(sessionManager *auth.SessionManager) gin.HandlerFunc {
+// NoAuthMiddleware 关闭认证时使用，所有请求视为内置管理员 (仅用于本地调试)
+func NoAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 从Cookie获取会话ID
-		sessionID, err := c.Cookie("session_id")
-		if err != nil {
-			response.Unauthorized(c, "未登录")
+		c.Set("user_id", uint(0))
+		c.Set("username", "anonymous")
+		c.Set("user_role", models.RoleAdmin)
+		c.Next()
+	}
+}
+
+// RequirePermission 要求当前用户的角色拥有指定权限，使用个人访问令牌时令牌的授权范围也需包含该权限
+func RequirePermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			response.Forbidden(c, "权限不足")
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		// 验证会话
-		session, err := sessionManager.GetSession(sessionID)
-		if err != nil {
-			response.Unauthorized(c, "会话已过期")
+// RequireSession 要求使用登录会话访问，个人访问令牌不能管理令牌、会话和账户安全设置
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("api_token"); ok {
+			response.Forbidden(c, "个人访问令牌不能访问此接口")
 			c.Abort()
 			return
 		}
-
-		// 更新会话活跃时间
-		sessionManager.UpdateSession(sessionID)
-
-		// 设置用户信息到上下文
-		c.Set("user_id", session.UserID)
-		c.Set("username", session.Username)
-		c.Set("session_id", sessionID)
-
 		c.Next()
 	}
 }
 
-// APIKeyAuthMiddleware API密钥认证中间件
-func APIKeyAuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
+// HasPermission 当前请求是否拥有权限
+func HasPermission(c *gin.Context, permission models.Permission) bool {
+	if !currentRole(c).Can(permission) {
+		return false
+	}
+	if value, ok := c.Get("api_token"); ok {
+		token, _ := value.(*models.APIToken)
+		return token != nil && token.HasScope(permission)
+	}
+	return true
+}
+
+// currentRole 获取认证中间件设置的用户角色
+func currentRole(c *gin.Context) models.UserRole {
+	role, _ := c.Get("user_role")
+	userRole, _ := role.(models.UserRole)
+	return userRole
+}
+
+// SessionAuthMiddleware 会话认证中间件
+func SessionAuthMiddleware(sessionManager *auth.SessionManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 从Header获取API Key
-		apiKey := c.GetHeader("X-API-Key")
-		if apiKey == "" {
-			response.Unauthorized(c, "缺少API密钥")
+		// 从Cookie获取会话令牌
+		token, err := c.Cookie("session_id")
+		if err != nil {
+			response.Unauthorized(c, "未登录")
 			c.Abort()
 			return
 		}
 
-		// 验证API Key
-		claims, err := jwtService.ValidateAPIKey(apiKey)
+		// 验证会话
+		session, err := sessionManager.GetSessionByToken(token)
 		if err != nil {
-			response.Unauthorized(c, "无效的API密钥")
+			response.Unauthorized(c, "会话已过期")
 			c.Abort()
 			return
 		}
 
+		// 更新会话活跃时间
+		sessionManager.UpdateSession(session.ID)
+
 		// 设置用户信息到上下文
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("claims", claims)
+		c.Set("user_id", session.UserID)
+		c.Set("username", session.Username)
+		c.Set("session_id", session.ID)
 
 		c.Next()
 	}
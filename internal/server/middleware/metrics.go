@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"time"
+
+	"eitec-vpn/internal/server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware 记录HTTP请求耗时，按路由模板分组，未匹配的路由统一记为unmatched
+func MetricsMiddleware(metricsService *services.MetricsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metricsService.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
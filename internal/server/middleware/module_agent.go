@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"strconv"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModuleAgentAuthMiddleware 模块代理认证中间件，校验X-API-Key是否属于路径中的模块
+func ModuleAgentAuthMiddleware(credentialService *services.ModuleCredentialService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			response.BadRequest(c, "模块ID无效")
+			c.Abort()
+			return
+		}
+
+		apiKey := c.GetHeader("X-API-Key")
+		credential, err := credentialService.Authenticate(uint(id), apiKey, c.ClientIP())
+		if err != nil {
+			response.Unauthorized(c, err.Error())
+			c.Abort()
+			return
+		}
+
+		// 设置模块信息到上下文
+		c.Set("module_id", credential.ModuleID)
+		c.Set("module_credential", credential)
+
+		c.Next()
+	}
+}
+
+// RequireModuleScope 模块凭证授权范围检查中间件
+func RequireModuleScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("module_credential")
+		credential, ok := value.(*models.ModuleCredential)
+		if !exists || !ok {
+			response.Unauthorized(c, "未认证的模块")
+			c.Abort()
+			return
+		}
+
+		if !credential.HasScope(scope) {
+			response.Forbidden(c, "模块凭证无权访问: "+scope)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
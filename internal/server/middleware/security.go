@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/shared/response"
 
 	"github.com/gin-gonic/gin"
@@ -31,13 +32,15 @@ func TimeoutMiddleware() gin.HandlerFunc {
 	return Timeout(30 * time.Second)
 }
 
-// RequireStringRole 需要特定角色的中间件 (临时简化实现)
+// RequireStringRole 要求当前用户的角色不低于指定角色 (admin > operator > viewer)
 func RequireStringRole(role string) gin.HandlerFunc {
+	required := models.UserRole(role)
 	return func(c *gin.Context) {
-		// 设置默认用户信息，模拟管理员权限
-		c.Set("user_id", uint(1))
-		c.Set("username", "admin")
-		c.Set("user_role", "admin")
+		if !currentRole(c).AtLeast(required) {
+			response.Forbidden(c, "权限不足")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIToken 用户的个人访问令牌，供CI和自动化脚本以 Authorization: Bearer 方式调用API。
+// 令牌的权限为所属用户当前角色的权限与令牌授权范围的交集
+type APIToken struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`         // 所属用户
+	Name        string     `json:"name" gorm:"not null;size:100"`         // 令牌名称，如 ci-deploy
+	TokenPrefix string     `json:"token_prefix" gorm:"not null;size:16"`  // 令牌明文前缀，便于识别
+	TokenHash   string     `json:"-" gorm:"not null;size:64;uniqueIndex"` // 令牌SHA-256哈希
+	Scopes      string     `json:"scopes" gorm:"size:500"`                // 授权范围，逗号分隔
+	LastUsedAt  *time.Time `json:"last_used_at"`                          // 最后使用时间
+	LastUsedIP  string     `json:"last_used_ip" gorm:"size:45"`           // 最后使用来源IP
+	ExpiresAt   *time.Time `json:"expires_at"`                            // 过期时间，为空表示永不过期
+	RevokedAt   *time.Time `json:"revoked_at"`                            // 吊销时间
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ScopeList 获取授权范围列表
+func (t *APIToken) ScopeList() []Permission {
+	var scopes []Permission
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, Permission(scope))
+		}
+	}
+	return scopes
+}
+
+// HasScope 检查令牌是否包含指定授权范围
+func (t *APIToken) HasScope(permission Permission) bool {
+	for _, scope := range t.ScopeList() {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive 检查令牌是否可用（未吊销且未过期）
+func (t *APIToken) IsActive() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// APITokenRequest 创建个人访问令牌请求
+type APITokenRequest struct {
+	Name          string       `json:"name" binding:"required,max=100"` // 令牌名称
+	Scopes        []Permission `json:"scopes" binding:"required,min=1"` // 授权范围，如 modules:read
+	ExpiresInDays int          `json:"expires_in_days"`                 // 有效天数，0表示永不过期
+}
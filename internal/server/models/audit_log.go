@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditLogImmutable 审计日志只允许追加
+var ErrAuditLogImmutable = errors.New("审计日志不允许修改或删除")
+
+// AuditLog 管理操作审计日志：谁在什么时间从哪里对什么对象做了什么，只允许追加
+type AuditLog struct {
+	ID         uint                   `json:"id" gorm:"primaryKey"`
+	ActorID    uint                   `json:"actor_id" gorm:"index"`      // 操作用户ID，登录失败或关闭认证时为0
+	Actor      string                 `json:"actor" gorm:"size:50;index"` // 操作用户名
+	ActorRole  UserRole               `json:"actor_role" gorm:"size:20"`  // 操作时的角色
+	SourceIP   string                 `json:"source_ip" gorm:"size:45"`   // 请求来源IP
+	Action     string                 `json:"action" gorm:"not null;size:50;index"`
+	TargetType string                 `json:"target_type" gorm:"size:30;index"`
+	TargetID   uint                   `json:"target_id,omitempty" gorm:"index"`
+	TargetName string                 `json:"target_name,omitempty" gorm:"size:100"`
+	Success    bool                   `json:"success"`
+	Message    string                 `json:"message,omitempty" gorm:"size:500"`
+	Changes    map[string]AuditChange `json:"changes,omitempty" gorm:"type:text;serializer:json"` // 变化的字段
+	CreatedAt  time.Time              `json:"created_at" gorm:"index"`
+}
+
+// AuditChange 字段修改前后的值，创建时Before为空，删除时After为空
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// BeforeUpdate 禁止修改审计日志
+func (*AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+// BeforeDelete 禁止删除审计日志
+func (*AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+// 审计操作
+const (
+	AuditLogin          = "auth.login"
+	AuditLoginFailed    = "auth.login_failed"
+	AuditLogout         = "auth.logout"
+	AuditPasswordChange = "auth.change_password"
+	AuditTokenCreate    = "auth.token_create"
+	AuditTokenRevoke    = "auth.token_revoke"
+
+	AuditModuleCreate         = "module.create"
+	AuditModuleUpdate         = "module.update"
+	AuditModuleDelete         = "module.delete"
+	AuditModuleStatus         = "module.status"
+	AuditModuleRegenerateKeys = "module.regenerate_keys"
+	AuditModuleChangeAddress  = "module.change_address"
+
+	AuditUserVPNCreate        = "user_vpn.create"
+	AuditUserVPNUpdate        = "user_vpn.update"
+	AuditUserVPNDelete        = "user_vpn.delete"
+	AuditUserVPNChangeAddress = "user_vpn.change_address"
+	AuditUserVPNExpire        = "user_vpn.expire"
+	AuditUserVPNRestore       = "user_vpn.restore"
+	AuditUserVPNExpiryWarning = "user_vpn.expiry_warning"
+	AuditUserVPNDeviceCreate  = "user_vpn.device_create"
+	AuditUserVPNDeviceDelete  = "user_vpn.device_delete"
+
+	AuditInterfaceCreate   = "interface.create"
+	AuditInterfaceImport   = "interface.import"
+	AuditInterfaceStart    = "interface.start"
+	AuditInterfaceStop     = "interface.stop"
+	AuditInterfaceDelete   = "interface.delete"
+	AuditInterfaceRenumber = "interface.renumber"
+
+	AuditIPReservationCreate = "ip_reservation.create"
+	AuditIPReservationDelete = "ip_reservation.delete"
+	AuditIPAllocationReserve = "ip_allocation.reserve"
+	AuditIPAllocationRelease = "ip_allocation.release"
+	AuditIPAllocationRepair  = "ip_allocation.repair"
+
+	AuditConfigUpdate = "config.update"
+	AuditConfigImport = "config.import"
+	AuditConfigReset  = "config.reset"
+
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserResetPassword  = "user.reset_password"
+	AuditUserResetTOTP      = "user.reset_totp"
+	AuditUserRevokeSessions = "user.revoke_sessions"
+)
+
+// 审计对象类型
+const (
+	AuditTargetModule    = "module"
+	AuditTargetUserVPN   = "user_vpn"
+	AuditTargetInterface = "interface"
+	AuditTargetConfig    = "config"
+	AuditTargetUser      = "user"
+	AuditTargetAPIToken  = "api_token"
+)
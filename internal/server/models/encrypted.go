@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"eitec-vpn/internal/shared/envelope"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// EncryptedSerializer 敏感字段序列化器，写入时用全局主密钥加密，读取时解密，启动迁移完成后不再接受明文。
+// 密文以"表名:列名:主键"作为附加数据，需要先调用RegisterEncryptedCallbacks，插入后用生成的主键重新加密。
+// 使用方式: gorm:"serializer:encrypted"。注意按列名的map更新不经过序列化器，需要先调用SealColumn
+type EncryptedSerializer struct{}
+
+// Scan 读取时解密，主键需要在敏感字段之前读出
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("字段 %s 类型错误: %T", field.Name, dbValue)
+	}
+
+	plaintext, err := envelope.Open(value, fieldAAD(ctx, field, dst))
+	if err != nil {
+		return fmt.Errorf("解密字段 %s 失败: %w", field.Name, err)
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+// Value 写入时加密，插入时主键为零值，由创建回调重新加密
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, _ := fieldValue.(string)
+	sealed, err := envelope.Seal(plaintext, fieldAAD(ctx, field, dst))
+	if err != nil {
+		return nil, fmt.Errorf("加密字段 %s 失败: %w", field.Name, err)
+	}
+	return sealed, nil
+}
+
+// SealColumn 加密按列名更新的敏感字段，附加数据与序列化器相同
+func SealColumn(db *gorm.DB, model interface{}, column string, primaryKey interface{}, plaintext string) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", fmt.Errorf("解析模型 %T 失败: %w", model, err)
+	}
+	return envelope.Seal(plaintext, envelope.AAD(stmt.Schema.Table, column, primaryKey))
+}
+
+// RegisterEncryptedCallbacks 注册创建回调：插入后在同一事务中用生成的主键重新加密敏感字段
+func RegisterEncryptedCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().
+		After("gorm:create").
+		Before("gorm:commit_or_rollback_transaction").
+		Register("encrypted:bind_primary_key", bindPrimaryKey)
+}
+
+// bindPrimaryKey 创建回调，支持单条和批量插入
+func bindPrimaryKey(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.PrioritizedPrimaryField == nil {
+		return
+	}
+	var fields []*schema.Field
+	for _, field := range db.Statement.Schema.Fields {
+		if _, ok := field.Serializer.(EncryptedSerializer); ok {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	ctx := db.Statement.Context
+	primaryField := db.Statement.Schema.PrioritizedPrimaryField
+	table := db.Statement.Schema.Table
+	rebind := func(row reflect.Value) error {
+		primaryKey, zero := primaryField.ValueOf(ctx, row)
+		if zero {
+			return nil
+		}
+		updates := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			// 序列化字段的ValueOf返回包装后的值，直接读取结构体字段
+			plaintext := field.ReflectValueOf(ctx, row).String()
+			sealed, err := envelope.Seal(plaintext, envelope.AAD(table, field.DBName, primaryKey))
+			if err != nil {
+				return fmt.Errorf("加密字段 %s 失败: %w", field.Name, err)
+			}
+			updates[field.DBName] = sealed
+		}
+		// 按列名更新不经过序列化器，写入的就是上面加密的值
+		return db.Session(&gorm.Session{NewDB: true}).Table(table).
+			Where(primaryField.DBName+" = ?", primaryKey).UpdateColumns(updates).Error
+	}
+
+	switch rv := reflect.Indirect(db.Statement.ReflectValue); rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := rebind(reflect.Indirect(rv.Index(i))); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	case reflect.Struct:
+		if err := rebind(rv); err != nil {
+			db.AddError(err)
+		}
+	}
+}
+
+// fieldAAD 字段所在行的附加数据
+func fieldAAD(ctx context.Context, field *schema.Field, row reflect.Value) string {
+	var primaryKey interface{}
+	if primaryField := field.Schema.PrioritizedPrimaryField; primaryField != nil {
+		primaryKey, _ = primaryField.ValueOf(ctx, row)
+	}
+	return envelope.AAD(field.Schema.Table, field.DBName, primaryKey)
+}
@@ -0,0 +1,43 @@
+package models
+
+// InterfaceImportRequest 导入已有WireGuard服务端配置的请求
+type InterfaceImportRequest struct {
+	Name        string   `json:"name" binding:"required"`   // 接口名称，如wg0
+	Description string   `json:"description"`               // 接口描述
+	Config      string   `json:"config" binding:"required"` // 服务端wgX.conf内容
+	PeerConfigs []string `json:"peer_configs"`              // 可选的对等端客户端配置，用于补全私钥和AllowedIPs
+	DryRun      bool     `json:"dry_run"`                   // 仅检查不写入数据库
+}
+
+// 导入的对等端类型
+const (
+	ImportPeerModule = "module"
+	ImportPeerUser   = "user"
+)
+
+// InterfaceImportPeer 导入的对等端
+type InterfaceImportPeer struct {
+	Kind       string `json:"kind"`        // module 或 user
+	Name       string `json:"name"`        // 取自[Peer]段注释
+	Location   string `json:"location"`    // 模块位置，取自注释“名称 - 位置”
+	PublicKey  string `json:"public_key"`  // 对等端公钥
+	IPAddress  string `json:"ip_address"`  // VPN网段中的IP地址
+	AllowedIPs string `json:"allowed_ips"` // 模块内网网段或用户可访问网段
+	HasPrivate bool   `json:"has_private"` // 是否从客户端配置中取得了私钥
+	ID         uint   `json:"id"`          // 导入后的模块或用户VPN ID
+}
+
+// InterfaceImportConflict 导入冲突
+type InterfaceImportConflict struct {
+	Line      int    `json:"line,omitempty"`       // 配置中的行号
+	PublicKey string `json:"public_key,omitempty"` // 相关对等端公钥
+	Message   string `json:"message"`
+}
+
+// InterfaceImportResult 导入结果
+type InterfaceImportResult struct {
+	Interface *WireGuardInterface       `json:"interface"`
+	Peers     []InterfaceImportPeer     `json:"peers"`
+	Conflicts []InterfaceImportConflict `json:"conflicts"`
+	Imported  bool                      `json:"imported"` // 是否已写入数据库
+}
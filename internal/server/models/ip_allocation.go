@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// IP地址持有者类型
+const (
+	IPOwnerGateway    = "gateway"     // 接口的服务器地址，持有者ID为接口ID
+	IPOwnerModule     = "module"      // 模块
+	IPOwnerUserVPN    = "user_vpn"    // 用户VPN
+	IPOwnerUserDevice = "user_device" // 用户VPN的附加设备
+	IPOwnerReserved   = "reserved"    // 手动占用，如不受本系统管理的设备，持有者ID为0
+)
+
+// IPAllocation 已分配的VPN地址。空闲地址由网段、已分配地址和保留范围计算得出，不预先生成地址池。
+// 同一接口内地址唯一，并发分配时由唯一索引保证不会重复
+type IPAllocation struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	InterfaceID uint      `json:"interface_id" gorm:"not null;uniqueIndex:idx_ip_allocation_address"`
+	Network     string    `json:"network" gorm:"not null;size:64;index"`                                    // 所属网段，IPv4或IPv6
+	IPAddress   string    `json:"ip_address" gorm:"not null;size:45;uniqueIndex:idx_ip_allocation_address"` // 分配的地址
+	OwnerType   string    `json:"owner_type" gorm:"size:20;index:idx_ip_allocation_owner"`                  // 持有者类型，见IPOwner*
+	OwnerID     uint      `json:"owner_id" gorm:"index:idx_ip_allocation_owner"`                            // 持有者ID
+	Description string    `json:"description,omitempty" gorm:"size:255"`                                    // 手动占用的说明
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IPReservation 接口网段内的保留地址范围，自动分配时跳过。
+// 起止地址相同时为静态保留，只能通过显式指定该地址使用
+type IPReservation struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	InterfaceID uint      `json:"interface_id" gorm:"not null;index"`
+	StartIP     string    `json:"start_ip" gorm:"not null;size:45"`
+	EndIP       string    `json:"end_ip" gorm:"not null;size:45"`
+	Description string    `json:"description" gorm:"size:255"`
+	CreatedAt   time.Time `json:"created_at"`
+}
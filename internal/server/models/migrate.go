@@ -1,17 +1,33 @@
 package models
 
 import (
+	"eitec-vpn/internal/shared/lockout"
+
 	"gorm.io/gorm"
 )
 
 // AutoMigrate 自动迁移数据库表结构
 func AutoMigrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(lockout.Models()...); err != nil {
+		return err
+	}
+
 	return db.AutoMigrate(
 		&WireGuardInterface{},
 		&Module{},
 		&User{},
 		&SystemConfig{},
-		&IPPool{},
+		&IPAllocation{},
+		&IPReservation{},
 		&UserVPN{},
+		&UserVPNDevice{},
+		&ModuleCredential{},
+		&ModuleJoinToken{},
+		&TrafficSample{},
+		&TrafficCounter{},
+		&UserSession{},
+		&RefreshToken{},
+		&AuditLog{},
+		&APIToken{},
 	)
 }
@@ -12,9 +12,10 @@ type Module struct {
 	Description string       `json:"description" gorm:"size:500"`
 	InterfaceID uint         `json:"interface_id" gorm:"not null;index"` // 关联的WireGuard接口ID
 	PublicKey   string       `json:"public_key" gorm:"not null;size:44;uniqueIndex"`
-	PrivateKey  string       `json:"private_key" gorm:"not null;size:44"`
-	IPAddress   string       `json:"ip_address" gorm:"not null;size:15;uniqueIndex"` // VPN网段中的IP地址
-	LocalIP     string       `json:"local_ip" gorm:"size:15"`                        // 模块在内网的IP地址，用于NAT转发
+	PrivateKey  string       `json:"-" gorm:"not null;size:255;serializer:encrypted"` // 加密存储
+	IPAddress   string       `json:"ip_address" gorm:"not null;size:15;uniqueIndex"`  // VPN网段中的IP地址
+	IPAddress6  string       `json:"ip_address6" gorm:"size:45;index"`                // VPN IPv6网段中的地址，接口未启用IPv6时为空
+	LocalIP     string       `json:"local_ip" gorm:"size:15"`                         // 模块在内网的IP地址，用于NAT转发
 	Status      ModuleStatus `json:"status" gorm:"default:0"`
 	LastSeen    *time.Time   `json:"last_seen"`
 	CreatedAt   time.Time    `json:"created_at"`
@@ -30,10 +31,17 @@ type Module struct {
 	// 配置信息
 	AllowedIPs       string `json:"allowed_ips" gorm:"default:'192.168.1.0/24'"`
 	PersistentKA     int    `json:"persistent_keepalive" gorm:"default:25"`
-	PresharedKey     string `json:"preshared_key" gorm:"size:44"`                     // 预共享密钥，增强安全性
+	PresharedKey     string `json:"-" gorm:"size:255;serializer:encrypted"`           // 预共享密钥，增强安全性（加密存储）
 	Endpoint         string `json:"endpoint" gorm:"size:100"`                         // 服务端端点（公网IP:端口）
 	NetworkInterface string `json:"network_interface" gorm:"default:'wlan0';size:20"` // 模块网卡名称，用于生成PostUp/PostDown规则
 
+	// 地址或接口网段变更后置位，模块拉取或下载新配置后清除
+	ConfigRefreshRequired bool `json:"config_refresh_required" gorm:"default:false"`
+
+	// 模块端心跳上报的信息
+	AgentVersion      string `json:"agent_version" gorm:"size:50"`             // 模块端程序版本
+	PublicKeyMismatch bool   `json:"public_key_mismatch" gorm:"default:false"` // 模块端使用的公钥与PublicKey不一致，需要重新下发配置
+
 	// 关联
 	Interface *WireGuardInterface `json:"interface,omitempty" gorm:"foreignKey:InterfaceID"`
 	UserVPNs  []UserVPN           `json:"user_vpns,omitempty" gorm:"foreignKey:ModuleID"`
@@ -80,5 +88,6 @@ type ModuleCreateRequest struct {
 	PublicKey           string `json:"public_key,omitempty"`                     // 自定义公钥（当AutoGenerateKeys为false时使用）
 	PrivateKey          string `json:"private_key,omitempty"`                    // 自定义私钥（当AutoGenerateKeys为false时使用）
 	IPAddress           string `json:"ip_address,omitempty"`                     // 自定义IP地址（当AutoAssignIP为false时使用）
+	IPAddress6          string `json:"ip_address6,omitempty"`                    // 自定义IPv6地址（当AutoAssignIP为false时使用）
 	NetworkInterface    string `json:"network_interface" gorm:"default:'wlan0'"` // 模块网卡名称
 }
@@ -0,0 +1,8 @@
+package models
+
+// ModuleHeartbeat 模块端心跳上报
+type ModuleHeartbeat struct {
+	WireGuardRunning *bool  `json:"wireguard_running,omitempty"` // 模块端WireGuard是否运行
+	PublicKey        string `json:"public_key,omitempty"`        // 模块端当前使用的公钥
+	Version          string `json:"version,omitempty"`           // 模块端程序版本
+}
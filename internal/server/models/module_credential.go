@@ -0,0 +1,84 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// ModuleCredential 模块API凭证（模块端以X-API-Key方式携带）
+type ModuleCredential struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	ModuleID   uint       `json:"module_id" gorm:"not null;index"`       // 关联的模块ID
+	Name       string     `json:"name" gorm:"size:100"`                  // 凭证名称
+	KeyPrefix  string     `json:"key_prefix" gorm:"not null;size:16"`    // 密钥明文前缀，便于识别
+	SecretHash string     `json:"-" gorm:"not null;size:64;uniqueIndex"` // 密钥SHA-256哈希
+	Scopes     string     `json:"scopes" gorm:"size:255"`                // 授权范围，逗号分隔
+	LastUsedAt *time.Time `json:"last_used_at"`                          // 最后使用时间
+	LastUsedIP string     `json:"last_used_ip" gorm:"size:45"`           // 最后使用来源IP
+	ExpiresAt  *time.Time `json:"expires_at"`                            // 过期时间，为空表示永不过期
+	RevokedAt  *time.Time `json:"revoked_at"`                            // 吊销时间
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// 关联
+	Module *Module `json:"module,omitempty" gorm:"foreignKey:ModuleID"`
+}
+
+// 模块凭证授权范围
+const (
+	ModuleScopeHeartbeat = "heartbeat" // 上报心跳
+	ModuleScopeTraffic   = "traffic"   // 上报流量
+	ModuleScopeConfig    = "config"    // 拉取配置
+)
+
+// DefaultModuleScopes 默认授权范围
+var DefaultModuleScopes = []string{ModuleScopeHeartbeat, ModuleScopeTraffic, ModuleScopeConfig}
+
+// IsValidModuleScope 检查授权范围是否有效
+func IsValidModuleScope(scope string) bool {
+	for _, s := range DefaultModuleScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeList 获取授权范围列表
+func (mc *ModuleCredential) ScopeList() []string {
+	var scopes []string
+	for _, scope := range strings.Split(mc.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// HasScope 检查凭证是否包含指定授权范围
+func (mc *ModuleCredential) HasScope(scope string) bool {
+	for _, s := range mc.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive 检查凭证是否可用（未吊销且未过期）
+func (mc *ModuleCredential) IsActive() bool {
+	if mc.RevokedAt != nil {
+		return false
+	}
+	if mc.ExpiresAt != nil && time.Now().After(*mc.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// ModuleCredentialRequest 签发模块凭证请求
+type ModuleCredentialRequest struct {
+	Name          string   `json:"name"`            // 凭证名称
+	Scopes        []string `json:"scopes"`          // 授权范围，为空时使用默认范围
+	ExpiresInDays int      `json:"expires_in_days"` // 有效天数，0表示永不过期
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// ModuleJoinToken 模块一次性加入令牌
+type ModuleJoinToken struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	ModuleID    uint       `json:"module_id" gorm:"not null;index"`       // 关联的模块ID
+	TokenPrefix string     `json:"token_prefix" gorm:"not null;size:16"`  // 令牌明文前缀，便于识别
+	TokenHash   string     `json:"-" gorm:"not null;size:64;uniqueIndex"` // 令牌SHA-256哈希
+	ExpiresAt   time.Time  `json:"expires_at"`                            // 过期时间
+	UsedAt      *time.Time `json:"used_at"`                               // 使用时间，为空表示未使用
+	UsedIP      string     `json:"used_ip" gorm:"size:45"`                // 使用来源IP
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// JoinTokenRequest 签发加入令牌请求
+type JoinTokenRequest struct {
+	TTLMinutes int `json:"ttl_minutes"` // 有效期（分钟），0表示使用默认值
+}
+
+// ModuleEnrollRequest 模块注册请求（模块端调用）
+type ModuleEnrollRequest struct {
+	Token     string `json:"token" binding:"required"`      // 一次性加入令牌
+	PublicKey string `json:"public_key" binding:"required"` // 模块本地生成的WireGuard公钥
+	Hostname  string `json:"hostname"`                      // 模块主机名
+}
+
+// ModuleEnrollResponse 模块注册响应
+type ModuleEnrollResponse struct {
+	ModuleID       uint   `json:"module_id"`
+	ModuleName     string `json:"module_name"`
+	Location       string `json:"location"`
+	APIKey         string `json:"api_key"`         // 模块API凭证密钥
+	ServerEndpoint string `json:"server_endpoint"` // WireGuard服务端端点
+	Config         string `json:"config"`          // 不含私钥的WireGuard配置
+}
@@ -0,0 +1,37 @@
+package models
+
+// AddressAuto 修改地址时表示自动分配新地址
+const AddressAuto = "auto"
+
+// PeerAddressRequest 修改模块或用户VPN地址的请求，为空的地址保持不变，auto表示自动分配
+type PeerAddressRequest struct {
+	IPAddress  string `json:"ip_address"`
+	IPAddress6 string `json:"ip_address6"`
+}
+
+// InterfaceRenumberRequest 接口重新编址请求，为空的网段保持不变
+type InterfaceRenumberRequest struct {
+	Network  string `json:"network"`  // 新的IPv4网段，如10.20.0.0/16
+	Network6 string `json:"network6"` // 新的IPv6网段，只能修改已启用IPv6的接口
+	DryRun   bool   `json:"dry_run"`  // 只生成计划不写入数据库
+}
+
+// RenumberChange 一个地址的变化
+type RenumberChange struct {
+	OwnerType  string `json:"owner_type"` // 持有者类型，见IPOwner*
+	OwnerID    uint   `json:"owner_id"`
+	Name       string `json:"name"`
+	OldAddress string `json:"old_address"`
+	NewAddress string `json:"new_address"`
+}
+
+// RenumberResult 重新编址的计划和结果
+type RenumberResult struct {
+	InterfaceID uint             `json:"interface_id"`
+	Interface   string           `json:"interface"`
+	Network     string           `json:"network"`
+	Network6    string           `json:"network6,omitempty"`
+	Changes     []RenumberChange `json:"changes"`
+	Warnings    []string         `json:"warnings"`
+	Applied     bool             `json:"applied"` // 是否已写入数据库
+}
@@ -0,0 +1,84 @@
+package models
+
+// UserRole 用户角色
+type UserRole string
+
+const (
+	RoleAdmin    UserRole = "admin"    // 管理员：全部权限，包括用户管理和系统配置
+	RoleOperator UserRole = "operator" // 运维：管理模块、接口和用户VPN，可下载含私钥的配置
+	RoleViewer   UserRole = "viewer"   // 只读：查看状态和统计
+)
+
+// roleLevels 角色等级，高等级角色包含低等级角色的权限
+var roleLevels = map[UserRole]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Valid 是否为已知角色
+func (r UserRole) Valid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+// AtLeast 角色等级是否不低于other
+func (r UserRole) AtLeast(other UserRole) bool {
+	return r.Valid() && roleLevels[r] >= roleLevels[other]
+}
+
+// Permission 对某类资源的操作权限
+type Permission string
+
+const (
+	PermModulesRead     Permission = "modules:read"
+	PermModulesWrite    Permission = "modules:write"
+	PermInterfacesRead  Permission = "interfaces:read"
+	PermInterfacesWrite Permission = "interfaces:write"
+	PermUserVPNRead     Permission = "user-vpn:read"
+	PermUserVPNWrite    Permission = "user-vpn:write"
+	PermSecretsRead     Permission = "secrets:read" // 下载包含私钥的配置
+	PermConfigRead      Permission = "config:read"
+	PermConfigWrite     Permission = "config:write"
+	PermUsersRead       Permission = "users:read"
+	PermUsersWrite      Permission = "users:write"
+	PermAuditRead       Permission = "audit:read" // 查询和导出审计日志
+)
+
+// rolePermissions 各角色的权限矩阵
+var rolePermissions = map[UserRole][]Permission{
+	RoleViewer: {
+		PermModulesRead, PermInterfacesRead, PermUserVPNRead,
+	},
+	RoleOperator: {
+		PermModulesRead, PermInterfacesRead, PermUserVPNRead,
+		PermModulesWrite, PermInterfacesWrite, PermUserVPNWrite,
+		PermSecretsRead, PermConfigRead,
+	},
+	RoleAdmin: {
+		PermModulesRead, PermInterfacesRead, PermUserVPNRead,
+		PermModulesWrite, PermInterfacesWrite, PermUserVPNWrite,
+		PermSecretsRead, PermConfigRead, PermConfigWrite,
+		PermUsersRead, PermUsersWrite, PermAuditRead,
+	},
+}
+
+// Valid 是否为已定义的权限，管理员拥有全部权限
+func (p Permission) Valid() bool {
+	return RoleAdmin.Can(p)
+}
+
+// Can 角色是否拥有权限
+func (r UserRole) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions 角色的全部权限
+func (r UserRole) Permissions() []Permission {
+	return append([]Permission(nil), rolePermissions[r]...)
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+)
+
+// 流量采样的对等端类型
+const (
+	TrafficPeerModule     = "module"
+	TrafficPeerUserVPN    = "user_vpn"
+	TrafficPeerUserDevice = "user_device"
+)
+
+// TrafficResolution 流量汇总粒度
+type TrafficResolution string
+
+const (
+	TrafficResolutionMinute TrafficResolution = "1m"
+	TrafficResolutionHour   TrafficResolution = "1h"
+	TrafficResolutionDay    TrafficResolution = "1d"
+)
+
+// Duration 汇总粒度对应的时间长度
+func (r TrafficResolution) Duration() time.Duration {
+	switch r {
+	case TrafficResolutionMinute:
+		return time.Minute
+	case TrafficResolutionHour:
+		return time.Hour
+	case TrafficResolutionDay:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// TrafficSample 对等端流量采样，按粒度汇总时间段内的流量增量（服务器视角）
+type TrafficSample struct {
+	ID          uint              `json:"-" gorm:"primaryKey"`
+	PeerType    string            `json:"peer_type" gorm:"not null;size:16;uniqueIndex:idx_traffic_sample_bucket"`       // module、user_vpn 或 user_device
+	PeerID      uint              `json:"peer_id" gorm:"not null;uniqueIndex:idx_traffic_sample_bucket"`                 // 模块或用户VPN ID
+	Resolution  TrafficResolution `json:"resolution" gorm:"not null;size:4;uniqueIndex:idx_traffic_sample_bucket;index"` // 汇总粒度
+	BucketStart time.Time         `json:"bucket_start" gorm:"not null;uniqueIndex:idx_traffic_sample_bucket;index"`      // 时间段起点（UTC）
+	InterfaceID uint              `json:"interface_id" gorm:"not null;index"`                                            // 所属接口ID
+	RxBytes     uint64            `json:"rx_bytes" gorm:"default:0"`                                                     // 时间段内接收字节数
+	TxBytes     uint64            `json:"tx_bytes" gorm:"default:0"`                                                     // 时间段内发送字节数
+}
+
+// TrafficCounter 对等端最近一次读取的WireGuard计数器，用于计算增量和识别计数器重置
+type TrafficCounter struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	PeerType  string    `json:"peer_type" gorm:"not null;size:16;uniqueIndex:idx_traffic_counter_peer"`
+	PeerID    uint      `json:"peer_id" gorm:"not null;uniqueIndex:idx_traffic_counter_peer"`
+	PublicKey string    `json:"public_key" gorm:"size:44"` // 读取时的公钥，公钥变化视为计数器重置
+	RxBytes   uint64    `json:"rx_bytes"`                  // WireGuard接收计数器
+	TxBytes   uint64    `json:"tx_bytes"`                  // WireGuard发送计数器
+	SampledAt time.Time `json:"sampled_at"`
+}
+
+// TrafficPoint 流量历史查询结果中的数据点
+type TrafficPoint struct {
+	Time    time.Time `json:"time"`
+	RxBytes uint64    `json:"rx_bytes"`
+	TxBytes uint64    `json:"tx_bytes"`
+}
+
+// TrafficHistory 流量历史查询结果
+type TrafficHistory struct {
+	PeerType    string            `json:"peer_type,omitempty"`
+	PeerID      uint              `json:"peer_id,omitempty"`
+	InterfaceID uint              `json:"interface_id,omitempty"`
+	Resolution  TrafficResolution `json:"resolution"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	TotalRx     uint64            `json:"total_rx"`
+	TotalTx     uint64            `json:"total_tx"`
+	Points      []TrafficPoint    `json:"points"`
+}
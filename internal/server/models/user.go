@@ -1,19 +1,46 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// User 用户信息 (简化版，只支持管理员)
+// User 用户信息
 type User struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`
 	Username  string         `json:"username" gorm:"not null;size:50;uniqueIndex"`
 	Password  string         `json:"-" gorm:"not null;size:255"`
+	Role      UserRole       `json:"role" gorm:"size:20"` // 角色，决定可访问的API
 	IsActive  bool           `json:"is_active" gorm:"default:true"`
 	LastLogin *time.Time     `json:"last_login"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+
+	// 账户来源
+	AuthSource string `json:"auth_source" gorm:"size:20;default:local"`    // local、oidc 或 ldap
+	ExternalID string `json:"external_id,omitempty" gorm:"size:255;index"` // 身份提供方中的用户标识 (OIDC为sub，LDAP为用户DN)
+
+	// 两步验证
+	TOTPEnabled       bool   `json:"totp_enabled"`
+	TOTPSecret        string `json:"-" gorm:"size:255;serializer:encrypted"` // 未启用时为待确认的密钥（加密存储）
+	TOTPLastCounter   int64  `json:"-"`                                      // 最近一次通过验证的时间步，防止验证码重放
+	TOTPRecoveryCodes string `json:"-" gorm:"type:text"`                     // 未使用的恢复码哈希，换行分隔
+}
+
+// 账户来源
+const (
+	AuthSourceLocal = "local" // 本地账户，使用密码登录
+	AuthSourceOIDC  = "oidc"  // 通过单点登录自动创建，不能使用密码登录
+	AuthSourceLDAP  = "ldap"  // 通过LDAP登录自动创建，使用目录中的密码登录
+)
+
+// RecoveryCodesRemaining 剩余的恢复码数量
+func (u *User) RecoveryCodesRemaining() int {
+	if u.TOTPRecoveryCodes == "" {
+		return 0
+	}
+	return len(strings.Split(u.TOTPRecoveryCodes, "\n"))
 }
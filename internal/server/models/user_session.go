@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// UserSession 用户登录会话，访问令牌和刷新令牌都绑定到会话，撤销会话后令牌立即失效
+type UserSession struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"not null;index"`
+	Username     string     `json:"username" gorm:"size:50"`
+	TokenHash    string     `json:"-" gorm:"not null;size:64;uniqueIndex"` // 会话cookie的SHA-256哈希
+	IPAddress    string     `json:"ip_address" gorm:"size:45"`
+	UserAgent    string     `json:"user_agent" gorm:"size:255"`
+	LastSeen     time.Time  `json:"last_seen"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"index"` // 最后签发的刷新令牌的过期时间
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" gorm:"index"`
+	RevokeReason string     `json:"revoke_reason,omitempty" gorm:"size:50"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	Token string `json:"-" gorm:"-"` // 会话cookie明文，仅创建时有值
+}
+
+// 会话撤销原因
+const (
+	SessionRevokedLogout  = "logout"
+	SessionRevokedByUser  = "revoked"
+	SessionRevokedByAdmin = "user_changed"
+	SessionRevokedReuse   = "refresh_token_reuse"
+)
+
+// IsActive 会话在指定时间是否有效，idleTimeout为最长空闲时间，<=0 表示不限制
+func (s *UserSession) IsActive(now time.Time, idleTimeout time.Duration) bool {
+	if s.RevokedAt != nil || !now.Before(s.ExpiresAt) {
+		return false
+	}
+	return idleTimeout <= 0 || now.Sub(s.LastSeen) <= idleTimeout
+}
+
+// RefreshToken 已签发的刷新令牌，按jti记录。每个刷新令牌只能使用一次，
+// 已使用的令牌再次出现说明被窃取，此时撤销整个会话
+type RefreshToken struct {
+	JTI       string     `json:"jti" gorm:"primaryKey;size:64"`
+	SessionID uint       `json:"session_id" gorm:"not null;index"`
+	UserID    uint       `json:"user_id" gorm:"not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at"` // 为空表示未使用
+	CreatedAt time.Time  `json:"created_at"`
+}
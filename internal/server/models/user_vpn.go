@@ -7,14 +7,15 @@ import (
 // UserVPN 用户VPN配置信息
 type UserVPN struct {
 	ID          uint          `json:"id" gorm:"primaryKey"`
-	ModuleID    uint          `json:"module_id" gorm:"not null;index"`                // 关联的模块ID
-	Username    string        `json:"username" gorm:"not null;size:100"`              // 用户名
-	Email       string        `json:"email" gorm:"size:255"`                          // 用户邮箱
-	Description string        `json:"description" gorm:"size:500"`                    // 用户描述
-	PublicKey   string        `json:"public_key" gorm:"not null;size:44;uniqueIndex"` // 用户的WireGuard公钥
-	PrivateKey  string        `json:"private_key" gorm:"not null;size:44"`            // 用户的WireGuard私钥
-	IPAddress   string        `json:"ip_address" gorm:"not null;size:15;uniqueIndex"` // 分配给用户的IP地址
-	Status      UserVPNStatus `json:"status" gorm:"default:0"`                        // 用户状态
+	ModuleID    uint          `json:"module_id" gorm:"not null;index"`                 // 关联的模块ID
+	Username    string        `json:"username" gorm:"not null;size:100"`               // 用户名
+	Email       string        `json:"email" gorm:"size:255"`                           // 用户邮箱
+	Description string        `json:"description" gorm:"size:500"`                     // 用户描述
+	PublicKey   string        `json:"public_key" gorm:"not null;size:44;uniqueIndex"`  // 用户的WireGuard公钥
+	PrivateKey  string        `json:"-" gorm:"not null;size:255;serializer:encrypted"` // 用户的WireGuard私钥（加密存储）
+	IPAddress   string        `json:"ip_address" gorm:"not null;size:15;uniqueIndex"`  // 分配给用户的IP地址
+	IPAddress6  string        `json:"ip_address6" gorm:"size:45;index"`                // 分配给用户的IPv6地址，接口未启用IPv6时为空
+	Status      UserVPNStatus `json:"status" gorm:"default:0"`                         // 用户状态
 	CreatedAt   time.Time     `json:"created_at"`
 	UpdatedAt   time.Time     `json:"updated_at"`
 
@@ -29,9 +30,15 @@ type UserVPN struct {
 	// 配置信息
 	AllowedIPs   string     `json:"allowed_ips" gorm:"default:'0.0.0.0/0'"` // 用户可访问的网段
 	PersistentKA int        `json:"persistent_keepalive" gorm:"default:25"` // 保活间隔
-	PresharedKey string     `json:"preshared_key" gorm:"size:44"`           // 预共享密钥
+	PresharedKey string     `json:"-" gorm:"size:255;serializer:encrypted"` // 预共享密钥（加密存储）
 	ExpiresAt    *time.Time `json:"expires_at"`                             // 配置过期时间
 
+	// 已发出到期提醒的时间，修改到期时间后清除
+	ExpiryWarnedAt *time.Time `json:"expiry_warned_at"`
+
+	// 地址或接口网段变更后置位，下载新配置后清除
+	ConfigRefreshRequired bool `json:"config_refresh_required" gorm:"default:false"`
+
 	// 权限控制
 	IsActive   bool `json:"is_active" gorm:"default:true"` // 是否激活
 	MaxDevices int  `json:"max_devices" gorm:"default:1"`  // 最大设备数
@@ -50,6 +57,14 @@ const (
 	UserVPNStatusExpired                        // 已过期
 )
 
+// Enabled 用户VPN是否允许接入：已激活、未暂停且未过期。不允许接入的用户不写入接口配置
+func (u *UserVPN) Enabled(now time.Time) bool {
+	if !u.IsActive || u.Status == UserVPNStatusSuspended || u.Status == UserVPNStatusExpired {
+		return false
+	}
+	return u.ExpiresAt == nil || now.Before(*u.ExpiresAt)
+}
+
 func (s UserVPNStatus) String() string {
 	switch s {
 	case UserVPNStatusOnline:
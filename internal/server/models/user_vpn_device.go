@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// UserVPNDevice 用户VPN的附加设备，每台设备使用独立的密钥和地址，接入权限和AllowedIPs跟随所属用户。
+// 用户自身的密钥算作第一台设备，附加设备最多 MaxDevices-1 台
+type UserVPNDevice struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserVPNID    uint      `json:"user_vpn_id" gorm:"not null;index"`               // 所属用户VPN
+	Name         string    `json:"name" gorm:"not null;size:100"`                   // 设备名称
+	PublicKey    string    `json:"public_key" gorm:"not null;size:44;uniqueIndex"`  // 设备的WireGuard公钥
+	PrivateKey   string    `json:"-" gorm:"not null;size:255;serializer:encrypted"` // 设备的WireGuard私钥（加密存储）
+	PresharedKey string    `json:"-" gorm:"size:255;serializer:encrypted"`          // 预共享密钥（加密存储）
+	IPAddress    string    `json:"ip_address" gorm:"not null;size:15;index"`        // 分配给设备的IP地址
+	IPAddress6   string    `json:"ip_address6" gorm:"size:45;index"`                // 分配给设备的IPv6地址，接口未启用IPv6时为空
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// 统计信息
+	TotalTxBytes    uint64     `json:"total_tx_bytes" gorm:"default:0"`
+	TotalRxBytes    uint64     `json:"total_rx_bytes" gorm:"default:0"`
+	LastSeen        *time.Time `json:"last_seen"`
+	LatestHandshake *time.Time `json:"latest_handshake"`
+
+	// 地址或接口网段变更后置位，下载新配置后清除
+	ConfigRefreshRequired bool `json:"config_refresh_required" gorm:"default:false"`
+}
+
+// UserVPNDeviceRequest 添加设备请求
+type UserVPNDeviceRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
@@ -9,15 +9,17 @@ import (
 // WireGuardInterface WireGuard接口配置
 type WireGuardInterface struct {
 	ID          uint            `json:"id" gorm:"primaryKey"`
-	Name        string          `json:"name" gorm:"not null;size:20;uniqueIndex"` // wg0, wg1, wg2等
-	Description string          `json:"description" gorm:"size:200"`              // 接口描述
-	Network     string          `json:"network" gorm:"not null;size:20"`          // 网络段，如10.10.0.0/24
-	ServerIP    string          `json:"server_ip" gorm:"not null;size:15"`        // 服务器IP，如10.10.0.1
-	ListenPort  int             `json:"listen_port" gorm:"not null"`              // 监听端口
-	PublicKey   string          `json:"public_key" gorm:"not null;size:44"`       // 服务器公钥
-	PrivateKey  string          `json:"private_key" gorm:"not null;size:44"`      // 服务器私钥
-	Status      InterfaceStatus `json:"status" gorm:"default:0"`                  // 接口状态
-	MaxPeers    int             `json:"max_peers" gorm:"default:100"`             // 最大连接数
+	Name        string          `json:"name" gorm:"not null;size:20;uniqueIndex"`        // wg0, wg1, wg2等
+	Description string          `json:"description" gorm:"size:200"`                     // 接口描述
+	Network     string          `json:"network" gorm:"not null;size:20"`                 // 网络段，如10.10.0.0/24
+	ServerIP    string          `json:"server_ip" gorm:"not null;size:15"`               // 服务器IP，如10.10.0.1
+	Network6    string          `json:"network6" gorm:"size:64"`                         // IPv6 ULA网段，如fd3c:91a2:5e07:1::/64，为空时只使用IPv4
+	ServerIP6   string          `json:"server_ip6" gorm:"size:45"`                       // 服务器IPv6地址，如fd3c:91a2:5e07:1::1
+	ListenPort  int             `json:"listen_port" gorm:"not null"`                     // 监听端口
+	PublicKey   string          `json:"public_key" gorm:"not null;size:44"`              // 服务器公钥
+	PrivateKey  string          `json:"-" gorm:"not null;size:255;serializer:encrypted"` // 服务器私钥（加密存储）
+	Status      InterfaceStatus `json:"status" gorm:"default:0"`                         // 接口状态
+	MaxPeers    int             `json:"max_peers" gorm:"default:100"`                    // 最大连接数
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt  `json:"deleted_at" gorm:"index"`
@@ -75,6 +77,7 @@ type InterfaceTemplate struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Network     string `json:"network"`
+	Network6    string `json:"network6"` // IPv6网段，auto表示自动生成ULA网段，为空时只使用IPv4
 	ListenPort  int    `json:"listen_port"`
 	MaxPeers    int    `json:"max_peers"`
 	DNS         string `json:"dns"`
@@ -127,3 +130,8 @@ func GetDefaultTemplates() []InterfaceTemplate {
 		},
 	}
 }
+
+// DualStack 是否同时启用IPv6
+func (i *WireGuardInterface) DualStack() bool {
+	return i.Network6 != ""
+}
@@ -0,0 +1,2374 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/server/routes"
+	"eitec-vpn/internal/server/services"
+	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/auth/ldaptest"
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/envelope"
+	"eitec-vpn/internal/shared/oidc/oidctest"
+	"eitec-vpn/internal/shared/totp"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer 基于内存数据库和模拟WireGuard后端的API测试服务器
+type testServer struct {
+	t       *testing.T
+	router  *gin.Engine
+	backend *wireguard.FakeBackend
+	token   string
+}
+
+// apiResponse 统一响应结构
+type apiResponse struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// apiInterface 接口响应中测试关心的字段
+type apiInterface struct {
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	ServerIP   string `json:"server_ip"`
+	ListenPort int    `json:"listen_port"`
+	PublicKey  string `json:"public_key"`
+	Status     int    `json:"status"`
+}
+
+// apiPeerRecord 模块和用户VPN响应中测试关心的字段
+type apiPeerRecord struct {
+	ID        uint   `json:"id"`
+	PublicKey string `json:"public_key"`
+	IPAddress string `json:"ip_address"`
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWithConfig(t, "")
+}
+
+// testSecrets 测试用的令牌密钥，未设置时服务端拒绝启动
+const testSecrets = "  jwt_secret: \"test-jwt-secret\"\n  refresh_secret: \"test-refresh-secret\"\n"
+
+// withTestSecrets 在auth配置段中加入测试密钥，extraConfig没有auth段时追加一段
+func withTestSecrets(extraConfig string) string {
+	if strings.HasPrefix(extraConfig, "auth:\n") {
+		return strings.Replace(extraConfig, "auth:\n", "auth:\n"+testSecrets, 1)
+	}
+	return extraConfig + "auth:\n" + testSecrets
+}
+
+// newTestServerWithConfig 创建测试服务器，extraConfig追加到默认测试配置之后
+func newTestServerWithConfig(t *testing.T, extraConfig string) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	configDir := t.TempDir()
+	configFile := filepath.Join(configDir, "server.yaml")
+	if err := os.WriteFile(configFile, []byte("app:\n  secret: \"test-secret\"\n  server_ip: \"198.51.100.1\"\n"+withTestSecrets(extraConfig)), 0600); err != nil {
+		t.Fatalf("写入测试配置失败: %v", err)
+	}
+	cfg, err := config.LoadServerConfig(configFile)
+	if err != nil {
+		t.Fatalf("加载默认配置失败: %v", err)
+	}
+	config.SetGlobalServerConfig(cfg)
+
+	wireguard.SetConfigDir(t.TempDir())
+	backend := wireguard.NewFakeBackend()
+	wireguard.SetBackend(backend)
+	t.Cleanup(func() {
+		wireguard.SetBackend(nil)
+		wireguard.SetConfigDir("")
+	})
+
+	key, _ := envelope.GenerateKey()
+	keyring, err := envelope.NewKeyring(key)
+	if err != nil {
+		t.Fatalf("创建主密钥失败: %v", err)
+	}
+	envelope.SetKeyring(keyring)
+
+	// 每个测试使用独立的共享缓存内存数据库
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	if err := database.InitDatabase(dsn); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		t.Fatalf("获取数据库连接失败: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	moduleService := services.NewModuleService()
+	router := routes.SetupAPIRoutes(routes.NewHandlers(
+		moduleService,
+		services.NewDashboardService(moduleService),
+		services.NewConfigService(),
+		auth.NewUserService(),
+		auth.NewJWTService(cfg.Auth.JWTSecret, cfg.Auth.RefreshSecret, cfg.Auth.AccessExpiry, cfg.Auth.RefreshExpiry),
+		auth.NewSessionManager(cfg.Auth.SessionTimeout),
+		auth.NewOIDCService(cfg.Auth.OIDC),
+	))
+
+	ts := &testServer{t: t, router: router, backend: backend}
+	ts.login(cfg.Auth.AdminUsername, cfg.Auth.AdminPassword)
+	return ts
+}
+
+// request 发送请求并返回原始响应
+func (ts *testServer) request(method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("序列化请求失败: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ts.token)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	ts.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// call 发送请求，要求返回200并将data解码到out
+func (ts *testServer) call(method, path string, body, out interface{}) {
+	ts.t.Helper()
+
+	recorder := ts.request(method, path, body, nil)
+	if recorder.Code != http.StatusOK {
+		ts.t.Fatalf("%s %s 返回 %d: %s", method, path, recorder.Code, recorder.Body.String())
+	}
+	if out == nil {
+		return
+	}
+
+	var resp apiResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		ts.t.Fatalf("%s %s 响应解析失败: %v", method, path, err)
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		ts.t.Fatalf("%s %s 响应数据解析失败: %v, 数据: %s", method, path, err, resp.Data)
+	}
+}
+
+func (ts *testServer) login(username, password string) {
+	ts.t.Helper()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{
+		"username": username,
+		"password": password,
+	}, &result)
+	if result.AccessToken == "" {
+		ts.t.Fatal("登录未返回访问令牌")
+	}
+	ts.token = result.AccessToken
+}
+
+// createInterface 创建接口
+func (ts *testServer) createInterface(name, network string, port int) apiInterface {
+	ts.t.Helper()
+
+	var wgInterface apiInterface
+	ts.call(http.MethodPost, "/api/v1/interfaces", map[string]interface{}{
+		"name":        name,
+		"network":     network,
+		"listen_port": port,
+	}, &wgInterface)
+	return wgInterface
+}
+
+// startInterface 启动接口
+func (ts *testServer) startInterface(id uint) {
+	ts.t.Helper()
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/interfaces/%d/start", id), nil, nil)
+}
+
+// createModule 在接口下创建模块
+func (ts *testServer) createModule(interfaceID uint, name string) apiPeerRecord {
+	ts.t.Helper()
+
+	var result struct {
+		Data apiPeerRecord `json:"data"`
+	}
+	ts.call(http.MethodPost, "/api/v1/modules", map[string]interface{}{
+		"name":         name,
+		"location":     "测试机房",
+		"interface_id": interfaceID,
+		"allowed_ips":  "192.168.50.0/24",
+	}, &result)
+	return result.Data
+}
+
+// peer 从模拟后端读取对等端
+func (ts *testServer) peer(interfaceName, publicKey string) (wireguard.Peer, bool) {
+	ts.t.Helper()
+
+	device, err := ts.backend.GetDevice(interfaceName)
+	if err != nil {
+		ts.t.Fatalf("读取接口 %s 失败: %v", interfaceName, err)
+	}
+	for _, peer := range device.Peers {
+		if peer.PublicKey == publicKey {
+			return peer, true
+		}
+	}
+	return wireguard.Peer{}, false
+}
+
+func TestInterfaceStartStop(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg8", "10.88.0.0/24", 51888)
+	ts.startInterface(wgInterface.ID)
+
+	device, err := ts.backend.GetDevice("wg8")
+	if err != nil {
+		t.Fatalf("启动后接口不存在: %v", err)
+	}
+	if device.PublicKey != wgInterface.PublicKey {
+		t.Errorf("接口公钥 = %s, 期望 %s", device.PublicKey, wgInterface.PublicKey)
+	}
+	if device.ListenPort != 51888 {
+		t.Errorf("监听端口 = %d, 期望 51888", device.ListenPort)
+	}
+	if addresses := ts.backend.Addresses("wg8"); len(addresses) != 1 || addresses[0] != wgInterface.ServerIP+"/24" {
+		t.Errorf("接口地址 = %v, 期望 [%s/24]", addresses, wgInterface.ServerIP)
+	}
+	if up, mtu := ts.backend.LinkState("wg8"); !up || mtu != 1420 {
+		t.Errorf("接口状态 up=%v mtu=%d, 期望 up=true mtu=1420", up, mtu)
+	}
+	if len(ts.backend.Hooks()) == 0 {
+		t.Error("PostUp钩子未执行")
+	}
+	if _, err := os.Stat(wireguard.ConfigPath("wg8")); err != nil {
+		t.Errorf("配置文件未写入配置目录: %v", err)
+	}
+
+	var running apiInterface
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/interfaces/%d", wgInterface.ID), nil, &running)
+	if running.Status == wgInterface.Status {
+		t.Errorf("启动后接口状态未变化: %d", running.Status)
+	}
+
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/interfaces/%d/stop", wgInterface.ID), nil, nil)
+	if ts.backend.DeviceExists("wg8") {
+		t.Error("停止后接口仍然存在")
+	}
+
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/interfaces/%d", wgInterface.ID), nil, nil)
+	if _, err := os.Stat(wireguard.ConfigPath("wg8")); !os.IsNotExist(err) {
+		t.Errorf("删除接口后配置文件仍然存在: %v", err)
+	}
+}
+
+func TestModuleAndUserVPNPeersReconciled(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg8", "10.88.0.0/24", 51888)
+	module := ts.createModule(wgInterface.ID, "reconcile-module")
+	ts.startInterface(wgInterface.ID)
+
+	modulePeer, ok := ts.peer("wg8", module.PublicKey)
+	if !ok {
+		t.Fatal("启动接口后模块对等端不存在")
+	}
+	allowedIPs := append([]string(nil), modulePeer.AllowedIPs...)
+	sort.Strings(allowedIPs)
+	if want := module.IPAddress + "/32,192.168.50.0/24"; strings.Join(allowedIPs, ",") != want {
+		t.Errorf("模块AllowedIPs = %v, 期望 %s", modulePeer.AllowedIPs, want)
+	}
+
+	// 模拟模块已建立隧道，后续变更不应重启接口
+	handshake := time.Now().Add(-10 * time.Second).Truncate(time.Second)
+	if err := ts.backend.SimulateHandshake("wg8", module.PublicKey, "203.0.113.10:51820", handshake); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.backend.AddTraffic("wg8", module.PublicKey, 4096, 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	var userVPN apiPeerRecord
+	ts.call(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+		"module_id":   module.ID,
+		"username":    "alice",
+		"max_devices": 1,
+	}, &userVPN)
+
+	userPeer, ok := ts.peer("wg8", userVPN.PublicKey)
+	if !ok {
+		t.Fatal("创建用户VPN后对等端未下发")
+	}
+	if len(userPeer.AllowedIPs) != 1 || userPeer.AllowedIPs[0] != userVPN.IPAddress+"/32" {
+		t.Errorf("用户VPN AllowedIPs = %v, 期望 [%s/32]", userPeer.AllowedIPs, userVPN.IPAddress)
+	}
+
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/user-vpn/%d", userVPN.ID), nil, nil)
+	if _, ok := ts.peer("wg8", userVPN.PublicKey); ok {
+		t.Error("删除用户VPN后对等端仍然存在")
+	}
+
+	modulePeer, ok = ts.peer("wg8", module.PublicKey)
+	if !ok {
+		t.Fatal("删除用户VPN后模块对等端丢失")
+	}
+	if !modulePeer.LatestHandshake.Equal(handshake) || modulePeer.ReceiveBytes != 4096 || modulePeer.TransmitBytes != 2048 {
+		t.Errorf("模块隧道被中断: 握手 %v, 接收 %d, 发送 %d", modulePeer.LatestHandshake, modulePeer.ReceiveBytes, modulePeer.TransmitBytes)
+	}
+	if modulePeer.Endpoint != "203.0.113.10:51820" {
+		t.Errorf("模块漫游后的Endpoint被覆盖: %s", modulePeer.Endpoint)
+	}
+
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/modules/%d", module.ID), nil, nil)
+	if _, ok := ts.peer("wg8", module.PublicKey); ok {
+		t.Error("删除模块后对等端仍然存在")
+	}
+}
+
+func TestDualStackInterface(t *testing.T) {
+	ts := newTestServer(t)
+
+	var wgInterface struct {
+		apiInterface
+		Network6  string `json:"network6"`
+		ServerIP6 string `json:"server_ip6"`
+	}
+	ts.call(http.MethodPost, "/api/v1/interfaces", map[string]interface{}{
+		"name":        "wg8",
+		"network":     "10.88.0.0/24",
+		"network6":    "fd10:88::/64",
+		"listen_port": 51888,
+	}, &wgInterface)
+	if wgInterface.Network6 != "fd10:88::/64" || wgInterface.ServerIP6 != "fd10:88::1" {
+		t.Fatalf("IPv6网段 = %s, 服务器地址 = %s", wgInterface.Network6, wgInterface.ServerIP6)
+	}
+
+	// 非ULA网段和已使用的网段都不允许
+	for _, network6 := range []string{"2001:db8::/64", "fd10:88::/64"} {
+		resp := ts.request(http.MethodPost, "/api/v1/interfaces", map[string]interface{}{
+			"name":        "wg9",
+			"network":     "10.89.0.0/24",
+			"network6":    network6,
+			"listen_port": 51889,
+		}, nil)
+		if resp.Code == http.StatusOK {
+			t.Errorf("使用IPv6网段 %s 创建接口成功", network6)
+		}
+	}
+
+	// apiDualStackPeer 带IPv6地址的模块和用户VPN响应
+	type apiDualStackPeer struct {
+		apiPeerRecord
+		IPAddress6 string `json:"ip_address6"`
+	}
+	var created struct {
+		Data apiDualStackPeer `json:"data"`
+	}
+	ts.call(http.MethodPost, "/api/v1/modules", map[string]interface{}{
+		"name":         "dual-module",
+		"location":     "测试机房",
+		"interface_id": wgInterface.ID,
+		"allowed_ips":  "192.168.50.0/24",
+	}, &created)
+	module := created.Data
+	var userVPN apiDualStackPeer
+	ts.call(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+		"module_id":   module.ID,
+		"username":    "alice",
+		"max_devices": 1,
+	}, &userVPN)
+	if module.IPAddress6 == "" || userVPN.IPAddress6 == "" || module.IPAddress6 == userVPN.IPAddress6 {
+		t.Fatalf("IPv6地址分配错误: 模块 %q, 用户 %q", module.IPAddress6, userVPN.IPAddress6)
+	}
+
+	ts.startInterface(wgInterface.ID)
+	addresses := ts.backend.Addresses("wg8")
+	if strings.Join(addresses, ",") != "10.88.0.1/24,fd10:88::1/64" {
+		t.Errorf("接口地址 = %v", addresses)
+	}
+	modulePeer, _ := ts.peer("wg8", module.PublicKey)
+	allowedIPs := append([]string(nil), modulePeer.AllowedIPs...)
+	sort.Strings(allowedIPs)
+	if want := module.IPAddress + "/32,192.168.50.0/24," + module.IPAddress6 + "/128"; strings.Join(allowedIPs, ",") != want {
+		t.Errorf("模块AllowedIPs = %v, 期望 %s", allowedIPs, want)
+	}
+	userPeer, _ := ts.peer("wg8", userVPN.PublicKey)
+	if strings.Join(userPeer.AllowedIPs, ",") != userVPN.IPAddress+"/32,"+userVPN.IPAddress6+"/128" {
+		t.Errorf("用户VPN AllowedIPs = %v", userPeer.AllowedIPs)
+	}
+	if hooks := strings.Join(ts.backend.Hooks(), "\n"); !strings.Contains(hooks, "ip6tables -A FORWARD") {
+		t.Errorf("PostUp未包含IPv6转发规则: %s", hooks)
+	}
+
+	moduleConfig := ts.request(http.MethodGet, fmt.Sprintf("/api/v1/modules/%d/config", module.ID), nil, nil).Body.String()
+	for _, want := range []string{module.IPAddress6 + "/128", "fd10:88::/64", "ip6tables"} {
+		if !strings.Contains(moduleConfig, want) {
+			t.Errorf("模块配置缺少 %s:\n%s", want, moduleConfig)
+		}
+	}
+	userConfig := ts.request(http.MethodGet, fmt.Sprintf("/api/v1/user-vpn/%d/config", userVPN.ID), nil, nil).Body.String()
+	for _, want := range []string{userVPN.IPAddress6 + "/128", "fd10:88::/64"} {
+		if !strings.Contains(userConfig, want) {
+			t.Errorf("用户配置缺少 %s:\n%s", want, userConfig)
+		}
+	}
+}
+
+func TestIPAM(t *testing.T) {
+	ts := newTestServer(t)
+
+	// /16网段不再预先生成地址记录
+	wgInterface := ts.createInterface("wg7", "10.70.0.0/16", 51870)
+	base := fmt.Sprintf("/api/v1/interfaces/%d", wgInterface.ID)
+
+	var reservation struct {
+		ID      uint   `json:"id"`
+		StartIP string `json:"start_ip"`
+		EndIP   string `json:"end_ip"`
+	}
+	ts.call(http.MethodPost, base+"/reservations", map[string]string{
+		"start_ip":    "10.70.0.2",
+		"end_ip":      "10.70.0.9",
+		"description": "机房设备",
+	}, &reservation)
+	ts.call(http.MethodPost, base+"/reservations", map[string]string{"start_ip": "10.70.0.10"}, nil)
+	for _, body := range []map[string]string{
+		{"start_ip": "10.70.0.5"},                          // 与已有保留重叠
+		{"start_ip": "10.71.0.1"},                          // 不在接口网段内
+		{"start_ip": "10.70.0.20", "end_ip": "10.70.0.19"}, // 起止颠倒
+	} {
+		if resp := ts.request(http.MethodPost, base+"/reservations", body, nil); resp.Code != http.StatusBadRequest {
+			t.Errorf("添加保留 %v 返回 %d", body, resp.Code)
+		}
+	}
+
+	// 自动分配跳过服务器地址和保留范围
+	module := ts.createModule(wgInterface.ID, "ipam-module")
+	if module.IPAddress != "10.70.0.11" {
+		t.Errorf("模块地址 = %s, 期望 10.70.0.11", module.IPAddress)
+	}
+
+	// 静态保留的地址可以显式指定
+	var static struct {
+		Data apiPeerRecord `json:"data"`
+	}
+	ts.call(http.MethodPost, "/api/v1/modules", map[string]interface{}{
+		"name":         "static-module",
+		"location":     "测试机房",
+		"interface_id": wgInterface.ID,
+		"allowed_ips":  "192.168.51.0/24",
+		"ip_address":   "10.70.0.10",
+	}, &static)
+	if static.Data.IPAddress != "10.70.0.10" {
+		t.Errorf("静态地址 = %s", static.Data.IPAddress)
+	}
+	resp := ts.request(http.MethodPost, "/api/v1/modules", map[string]interface{}{
+		"name":         "duplicate-module",
+		"location":     "测试机房",
+		"interface_id": wgInterface.ID,
+		"allowed_ips":  "192.168.52.0/24",
+		"ip_address":   "10.70.0.10",
+	}, nil)
+	if resp.Code == http.StatusOK {
+		t.Error("重复指定已分配地址成功")
+	}
+
+	// 并发分配的地址互不相同
+	const users = 8
+	addresses := make([]string, users)
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := ts.request(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+				"module_id":   module.ID,
+				"username":    fmt.Sprintf("user%d", i),
+				"max_devices": 1,
+			}, nil)
+			var result struct {
+				Data apiPeerRecord `json:"data"`
+			}
+			if resp.Code == http.StatusOK && json.Unmarshal(resp.Body.Bytes(), &result) == nil {
+				addresses[i] = result.Data.IPAddress
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := map[string]bool{module.IPAddress: true, static.Data.IPAddress: true}
+	for i, address := range addresses {
+		if address == "" || seen[address] {
+			t.Fatalf("用户 %d 地址 %q 为空或重复: %v", i, address, addresses)
+		}
+		seen[address] = true
+	}
+
+	var report struct {
+		Networks []struct {
+			Network   string `json:"network"`
+			Total     uint64 `json:"total"`
+			Allocated uint64 `json:"allocated"`
+			Reserved  uint64 `json:"reserved"`
+			Free      uint64 `json:"free"`
+		} `json:"networks"`
+	}
+	ts.call(http.MethodGet, base+"/ipam", nil, &report)
+	if len(report.Networks) != 1 {
+		t.Fatalf("网段统计 = %+v", report.Networks)
+	}
+	usage := report.Networks[0]
+	if usage.Total != 65533 || usage.Allocated != 2+users || usage.Reserved != 8 || usage.Free != 65533-10-8 {
+		t.Errorf("地址使用情况 = %+v", usage)
+	}
+
+	// 删除模块后地址回收，可再次分配
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/modules/%d", module.ID), nil, nil)
+	ts.call(http.MethodDelete, fmt.Sprintf("%s/reservations/%d", base, reservation.ID), nil, nil)
+	ts.call(http.MethodGet, base+"/ipam", nil, &report)
+	if usage := report.Networks[0]; usage.Allocated != 1 || usage.Reserved != 0 {
+		t.Errorf("回收后地址使用情况 = %+v", usage)
+	}
+	if reused := ts.createModule(wgInterface.ID, "reused-module"); reused.IPAddress != "10.70.0.2" {
+		t.Errorf("回收后分配地址 = %s, 期望 10.70.0.2", reused.IPAddress)
+	}
+}
+
+func TestIPAllocationOwners(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg6", "10.60.0.0/24", 51860)
+	base := fmt.Sprintf("/api/v1/interfaces/%d", wgInterface.ID)
+	module := ts.createModule(wgInterface.ID, "owner-module")
+	var userVPNs []apiPeerRecord
+	for i := 0; i < 3; i++ {
+		var userVPN apiPeerRecord
+		ts.call(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+			"module_id":   module.ID,
+			"username":    fmt.Sprintf("owner%d", i),
+			"max_devices": 1,
+		}, &userVPN)
+		userVPNs = append(userVPNs, userVPN)
+	}
+
+	var reserved struct {
+		ID        uint   `json:"id"`
+		IPAddress string `json:"ip_address"`
+		OwnerType string `json:"owner_type"`
+	}
+	ts.call(http.MethodPost, base+"/allocations", map[string]string{
+		"ip_address":  "10.60.0.6",
+		"description": "打印机",
+	}, &reserved)
+	if reserved.OwnerType != models.IPOwnerReserved {
+		t.Errorf("手动占用的持有者类型 = %s", reserved.OwnerType)
+	}
+	if next := ts.createModule(wgInterface.ID, "next-module"); next.IPAddress != "10.60.0.7" {
+		t.Errorf("自动分配未跳过手动占用的地址: %s", next.IPAddress)
+	}
+
+	// 模块和用户VPN的ID相同，删除用户VPN不能释放模块的地址
+	if module.ID != userVPNs[0].ID {
+		t.Fatalf("测试前提不成立: 模块ID %d, 用户VPN ID %d", module.ID, userVPNs[0].ID)
+	}
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/user-vpn/%d", userVPNs[0].ID), nil, nil)
+
+	type apiAllocation struct {
+		ID        uint   `json:"id"`
+		IPAddress string `json:"ip_address"`
+		OwnerType string `json:"owner_type"`
+		OwnerID   uint   `json:"owner_id"`
+		OwnerName string `json:"owner_name"`
+	}
+	var allocations []apiAllocation
+	ts.call(http.MethodGet, base+"/allocations", nil, &allocations)
+	var holders []string
+	for _, allocation := range allocations {
+		holders = append(holders, allocation.IPAddress+"="+allocation.OwnerType+":"+allocation.OwnerName)
+	}
+	want := "10.60.0.1=gateway:wg6,10.60.0.2=module:owner-module,10.60.0.4=user_vpn:owner1," +
+		"10.60.0.5=user_vpn:owner2,10.60.0.6=reserved:打印机,10.60.0.7=module:next-module"
+	if strings.Join(holders, ",") != want {
+		t.Errorf("地址持有者 = %v", holders)
+	}
+
+	type apiReport struct {
+		Issues []struct {
+			Kind       string `json:"kind"`
+			IPAddress  string `json:"ip_address"`
+			OwnerType  string `json:"owner_type"`
+			OwnerID    uint   `json:"owner_id"`
+			NewAddress string `json:"new_address"`
+		} `json:"issues"`
+	}
+	var report apiReport
+	ts.call(http.MethodGet, base+"/ipam/check", nil, &report)
+	if len(report.Issues) != 0 {
+		t.Fatalf("一致的分配记录报告了问题: %+v", report.Issues)
+	}
+
+	// 构造孤立、缺失和重复使用的地址
+	database.DB.Create(&models.IPAllocation{InterfaceID: wgInterface.ID, Network: "10.60.0.0/24", IPAddress: "10.60.0.50",
+		OwnerType: models.IPOwnerModule, OwnerID: 999})
+	database.DB.Where("ip_address = ?", userVPNs[1].IPAddress).Delete(&models.IPAllocation{})
+	database.DB.Model(&models.UserVPN{}).Where("id = ?", userVPNs[2].ID).Update("ip_address", module.IPAddress)
+
+	ts.call(http.MethodGet, base+"/ipam/check", nil, &report)
+	var kinds []string
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind+":"+issue.IPAddress)
+	}
+	sort.Strings(kinds)
+	want = "conflict:10.60.0.2,orphaned:10.60.0.5,orphaned:10.60.0.50,untracked:10.60.0.4"
+	if strings.Join(kinds, ",") != want {
+		t.Fatalf("一致性问题 = %v, 期望 %s", kinds, want)
+	}
+
+	ts.call(http.MethodPost, base+"/ipam/repair", nil, &report)
+	for _, issue := range report.Issues {
+		if issue.Kind == "conflict" && (issue.OwnerID != userVPNs[2].ID || issue.NewAddress != "10.60.0.3") {
+			t.Errorf("冲突修复结果 = %+v", issue)
+		}
+	}
+	var repaired apiPeerRecord
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/user-vpn/%d", userVPNs[2].ID), nil, &repaired)
+	if repaired.IPAddress != "10.60.0.3" {
+		t.Errorf("重新分配后的地址 = %s", repaired.IPAddress)
+	}
+	ts.call(http.MethodGet, base+"/ipam/check", nil, &report)
+	if len(report.Issues) != 0 {
+		t.Errorf("修复后仍有问题: %+v", report.Issues)
+	}
+
+	// 只有手动占用的地址可以直接释放
+	for _, allocation := range allocations {
+		resp := ts.request(http.MethodDelete, fmt.Sprintf("%s/allocations/%d", base, allocation.ID), nil, nil)
+		if allocation.OwnerType == models.IPOwnerReserved && resp.Code != http.StatusOK ||
+			allocation.OwnerType != models.IPOwnerReserved && resp.Code != http.StatusNotFound {
+			t.Errorf("释放 %s 的地址返回 %d", allocation.OwnerType, resp.Code)
+		}
+	}
+}
+
+func TestRenumber(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg5", "10.50.0.0/24", 51850)
+	base := fmt.Sprintf("/api/v1/interfaces/%d", wgInterface.ID)
+	module := ts.createModule(wgInterface.ID, "renumber-module")
+	var userVPNs []apiPeerRecord
+	for i := 0; i < 2; i++ {
+		var userVPN apiPeerRecord
+		ts.call(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+			"module_id":   module.ID,
+			"username":    fmt.Sprintf("renumber%d", i),
+			"max_devices": 1,
+		}, &userVPN)
+		userVPNs = append(userVPNs, userVPN)
+	}
+	ts.call(http.MethodPost, base+"/reservations", map[string]string{"start_ip": "10.50.0.10", "end_ip": "10.50.0.12"}, nil)
+	ts.call(http.MethodPost, base+"/allocations", map[string]string{"ip_address": "10.50.0.20", "description": "交换机"}, nil)
+	ts.startInterface(wgInterface.ID)
+
+	type apiRenumberResult struct {
+		Network string `json:"network"`
+		Changes []struct {
+			OwnerType  string `json:"owner_type"`
+			OwnerID    uint   `json:"owner_id"`
+			OldAddress string `json:"old_address"`
+			NewAddress string `json:"new_address"`
+		} `json:"changes"`
+		Warnings []string `json:"warnings"`
+		Applied  bool     `json:"applied"`
+	}
+	// newAddress 编址结果中持有者的新地址
+	newAddress := func(result apiRenumberResult, ownerType string, ownerID uint) string {
+		for _, change := range result.Changes {
+			if change.OwnerType == ownerType && change.OwnerID == ownerID {
+				return change.NewAddress
+			}
+		}
+		return ""
+	}
+
+	// 修改单个模块的地址，运行中的接口热同步
+	var result apiRenumberResult
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/modules/%d/address", module.ID), map[string]string{"ip_address": "10.50.0.30"}, &result)
+	if !result.Applied || newAddress(result, "module", module.ID) != "10.50.0.30" {
+		t.Fatalf("修改模块地址结果 = %+v", result)
+	}
+	if peer, _ := ts.peer("wg5", module.PublicKey); !strings.Contains(strings.Join(peer.AllowedIPs, ","), "10.50.0.30/32") {
+		t.Errorf("模块对等端AllowedIPs未更新: %v", peer.AllowedIPs)
+	}
+	if resp := ts.request(http.MethodPut, fmt.Sprintf("/api/v1/modules/%d/address", module.ID), map[string]string{"ip_address": userVPNs[0].IPAddress}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("修改为已使用的地址返回 %d", resp.Code)
+	}
+
+	var refreshed struct {
+		ConfigRefreshRequired bool `json:"config_refresh_required"`
+	}
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/modules/%d", module.ID), nil, &refreshed)
+	if !refreshed.ConfigRefreshRequired {
+		t.Error("修改地址后模块未标记需要更新配置")
+	}
+	ts.request(http.MethodGet, fmt.Sprintf("/api/v1/modules/%d/config", module.ID), nil, nil)
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/modules/%d", module.ID), nil, &refreshed)
+	if refreshed.ConfigRefreshRequired {
+		t.Error("下载配置后未清除更新标记")
+	}
+
+	// 用户VPN自动分配新地址，取模块让出的地址
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/user-vpn/%d/address", userVPNs[0].ID), map[string]string{"ip_address": "auto"}, &result)
+	if got := newAddress(result, "user_vpn", userVPNs[0].ID); got != module.IPAddress {
+		t.Errorf("用户VPN自动分配的地址 = %s, 期望 %s", got, module.IPAddress)
+	}
+
+	// 与其他接口重叠的网段不允许
+	ts.createInterface("wg4", "10.150.5.0/24", 51840)
+	if resp := ts.request(http.MethodPost, base+"/renumber", map[string]interface{}{"network": "10.150.0.0/16"}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("重叠网段重新编址返回 %d", resp.Code)
+	}
+
+	// 缩小到/28：.30放不下，按顺序分配空闲地址并跳过保留范围；手动占用的.20被释放
+	request := map[string]interface{}{"network": "10.160.0.0/28", "dry_run": true}
+	ts.call(http.MethodPost, base+"/renumber", request, &result)
+	if result.Applied || len(result.Warnings) != 1 {
+		t.Errorf("编址计划 = %+v", result)
+	}
+	want := map[string]string{
+		"gateway":  "10.160.0.1",
+		"module":   "10.160.0.3",
+		"user_vpn": "10.160.0.4",
+	}
+	ids := map[string]uint{"gateway": wgInterface.ID, "module": module.ID, "user_vpn": userVPNs[1].ID}
+	for ownerType, address := range want {
+		if got := newAddress(result, ownerType, ids[ownerType]); got != address {
+			t.Errorf("%s 新地址 = %s, 期望 %s", ownerType, got, address)
+		}
+	}
+	var current apiInterface
+	ts.call(http.MethodGet, base, nil, &current)
+	if current.ServerIP != "10.50.0.1" {
+		t.Fatalf("dry_run修改了接口: %+v", current)
+	}
+
+	request["dry_run"] = false
+	ts.call(http.MethodPost, base+"/renumber", request, &result)
+	if !result.Applied || result.Network != "10.160.0.0/28" {
+		t.Fatalf("重新编址结果 = %+v", result)
+	}
+	if addresses := ts.backend.Addresses("wg5"); strings.Join(addresses, ",") != "10.160.0.1/28" {
+		t.Errorf("接口地址 = %v", addresses)
+	}
+	if peer, _ := ts.peer("wg5", module.PublicKey); !strings.Contains(strings.Join(peer.AllowedIPs, ","), "10.160.0.3/32") {
+		t.Errorf("模块对等端AllowedIPs = %v", peer.AllowedIPs)
+	}
+	userConfig := ts.request(http.MethodGet, fmt.Sprintf("/api/v1/user-vpn/%d/config", userVPNs[1].ID), nil, nil).Body.String()
+	for _, want := range []string{"10.160.0.4/32", "10.160.0.0/28"} {
+		if !strings.Contains(userConfig, want) {
+			t.Errorf("用户配置缺少 %s:\n%s", want, userConfig)
+		}
+	}
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/user-vpn/%d", userVPNs[0].ID), nil, &refreshed)
+	if !refreshed.ConfigRefreshRequired {
+		t.Error("重新编址后用户VPN未标记需要更新配置")
+	}
+
+	var reservations []struct {
+		StartIP string `json:"start_ip"`
+		EndIP   string `json:"end_ip"`
+	}
+	ts.call(http.MethodGet, base+"/reservations", nil, &reservations)
+	if len(reservations) != 1 || reservations[0].StartIP != "10.160.0.10" || reservations[0].EndIP != "10.160.0.12" {
+		t.Errorf("保留范围 = %+v", reservations)
+	}
+	var report struct {
+		Issues []interface{} `json:"issues"`
+	}
+	ts.call(http.MethodGet, base+"/ipam/check", nil, &report)
+	if len(report.Issues) != 0 {
+		t.Errorf("重新编址后地址分配不一致: %+v", report.Issues)
+	}
+}
+
+func TestUserVPNLifecycleAndDevices(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg3", "10.30.0.0/24", 51830)
+	module := ts.createModule(wgInterface.ID, "lifecycle-module")
+	var userVPN apiPeerRecord
+	ts.call(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+		"module_id":   module.ID,
+		"username":    "carol",
+		"email":       "carol@example.com",
+		"max_devices": 2,
+		"expires_at":  time.Now().Add(72 * time.Hour).Format(time.RFC3339),
+	}, &userVPN)
+	ts.startInterface(wgInterface.ID)
+	base := fmt.Sprintf("/api/v1/user-vpn/%d", userVPN.ID)
+
+	// 用户自身算作第一台设备，max_devices=2 时只能再添加一台
+	var device apiPeerRecord
+	ts.call(http.MethodPost, base+"/devices", map[string]string{"name": "laptop"}, &device)
+	if device.IPAddress == "" || device.IPAddress == userVPN.IPAddress {
+		t.Fatalf("设备地址 = %q, 用户地址 %s", device.IPAddress, userVPN.IPAddress)
+	}
+	if _, ok := ts.peer("wg3", device.PublicKey); !ok {
+		t.Fatal("添加设备后对等端未下发")
+	}
+	if resp := ts.request(http.MethodPost, base+"/devices", map[string]string{"name": "phone"}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("超过最大设备数: 状态码 %d, 期望 400", resp.Code)
+	}
+	if resp := ts.request(http.MethodPut, base, map[string]interface{}{"max_devices": 1}, nil); resp.Code == http.StatusOK {
+		t.Error("最大设备数小于已有设备数时应拒绝修改")
+	}
+	deviceConfig := ts.request(http.MethodGet, fmt.Sprintf("%s/devices/%d/config", base, device.ID), nil, nil).Body.String()
+	if !strings.Contains(deviceConfig, "Address = "+device.IPAddress+"/32") {
+		t.Errorf("设备配置地址错误:\n%s", deviceConfig)
+	}
+
+	// assertPeers 检查用户和设备的对等端是否都在接口上
+	assertPeers := func(step string, want bool) {
+		t.Helper()
+		for _, publicKey := range []string{userVPN.PublicKey, device.PublicKey} {
+			if _, ok := ts.peer("wg3", publicKey); ok != want {
+				t.Errorf("%s: 对等端 %s 存在 = %v, 期望 %v", step, publicKey, ok, want)
+			}
+		}
+	}
+	// userStatus 查询用户VPN状态
+	userStatus := func() models.UserVPNStatus {
+		var current struct {
+			Status models.UserVPNStatus `json:"status"`
+		}
+		ts.call(http.MethodGet, base, nil, &current)
+		return current.Status
+	}
+
+	ts.call(http.MethodPut, base, map[string]interface{}{"status": models.UserVPNStatusSuspended}, nil)
+	assertPeers("暂停后", false)
+	ts.call(http.MethodPut, base, map[string]interface{}{"status": models.UserVPNStatusOffline}, nil)
+	assertPeers("恢复后", true)
+	ts.call(http.MethodPut, base, map[string]interface{}{"is_active": false}, nil)
+	assertPeers("停用后", false)
+	ts.call(http.MethodPut, base, map[string]interface{}{"is_active": true}, nil)
+	assertPeers("重新启用后", true)
+
+	// 距到期3天，提醒只发一次
+	userVPNService := services.NewUserVPNService()
+	for i := 0; i < 2; i++ {
+		result, err := userVPNService.EnforceLifecycle(time.Now(), 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 1 - i; len(result.Warned) != want || len(result.Expired) != 0 {
+			t.Errorf("第%d次检查: 提醒 %v, 过期 %v, 期望提醒 %d 个", i+1, result.Warned, result.Expired, want)
+		}
+	}
+	var warnings int64
+	database.DB.Model(&models.AuditLog{}).Where("action = ? AND target_id = ?", models.AuditUserVPNExpiryWarning, userVPN.ID).Count(&warnings)
+	if warnings != 1 {
+		t.Errorf("到期提醒审计日志 %d 条, 期望 1 条", warnings)
+	}
+
+	// 到期后从接口移除
+	result, err := userVPNService.EnforceLifecycle(time.Now().Add(73*time.Hour), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Expired) != 1 || result.Expired[0] != "carol" {
+		t.Errorf("过期用户 = %v, 期望 [carol]", result.Expired)
+	}
+	if status := userStatus(); status != models.UserVPNStatusExpired {
+		t.Errorf("到期后状态 = %d, 期望已过期", status)
+	}
+	assertPeers("到期后", false)
+
+	// 延长到期时间后恢复接入
+	ts.call(http.MethodPut, base, map[string]interface{}{"expires_at": time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339)}, nil)
+	if status := userStatus(); status != models.UserVPNStatusOffline {
+		t.Errorf("延期后状态 = %d, 期望离线", status)
+	}
+	assertPeers("延期后", true)
+
+	ts.call(http.MethodDelete, base, nil, nil)
+	var allocations int64
+	database.DB.Model(&models.IPAllocation{}).Where("owner_type = ?", models.IPOwnerUserDevice).Count(&allocations)
+	if allocations != 0 {
+		t.Errorf("删除用户后仍有 %d 条设备地址分配记录", allocations)
+	}
+	assertPeers("删除用户后", false)
+}
+
+func TestModuleEnrollmentAndAgentAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg8", "10.88.0.0/24", 51888)
+	module := ts.createModule(wgInterface.ID, "enroll-module")
+	ts.startInterface(wgInterface.ID)
+
+	var joinToken struct {
+		Token string `json:"token"`
+	}
+	ts.call(http.MethodPost, fmt.Sprintf("/api/v1/modules/%d/join-token", module.ID), nil, &joinToken)
+
+	keyPair, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var enrolled struct {
+		ModuleID uint   `json:"module_id"`
+		APIKey   string `json:"api_key"`
+	}
+	ts.call(http.MethodPost, "/api/v1/enroll", map[string]string{
+		"token":      joinToken.Token,
+		"public_key": keyPair.PublicKey,
+		"hostname":   "module-host",
+	}, &enrolled)
+	if enrolled.ModuleID != module.ID || enrolled.APIKey == "" {
+		t.Fatalf("注册结果异常: %+v", enrolled)
+	}
+
+	if _, ok := ts.peer("wg8", keyPair.PublicKey); !ok {
+		t.Error("注册后新公钥未下发到接口")
+	}
+	if _, ok := ts.peer("wg8", module.PublicKey); ok {
+		t.Error("注册后旧公钥仍然存在")
+	}
+
+	// 加入令牌只能使用一次
+	reused := ts.request(http.MethodPost, "/api/v1/enroll", map[string]string{
+		"token":      joinToken.Token,
+		"public_key": keyPair.PublicKey,
+	}, nil)
+	if reused.Code == http.StatusOK {
+		t.Error("加入令牌被重复使用")
+	}
+
+	agentPath := fmt.Sprintf("/api/v1/agent/modules/%d", module.ID)
+	heartbeat := ts.request(http.MethodPost, agentPath+"/heartbeat", map[string]interface{}{
+		"wireguard_running": true,
+		"public_key":        keyPair.PublicKey,
+	}, map[string]string{"X-API-Key": enrolled.APIKey})
+	if heartbeat.Code != http.StatusOK {
+		t.Errorf("心跳返回 %d: %s", heartbeat.Code, heartbeat.Body.String())
+	}
+
+	var detail struct {
+		Status            int    `json:"status"`
+		AgentVersion      string `json:"agent_version"`
+		PublicKeyMismatch bool   `json:"public_key_mismatch"`
+	}
+	modulePath := fmt.Sprintf("/api/v1/modules/%d", module.ID)
+
+	// 心跳记录模块端版本，公钥与服务端一致
+	ts.request(http.MethodPost, agentPath+"/heartbeat", map[string]interface{}{
+		"wireguard_running": true,
+		"public_key":        keyPair.PublicKey,
+		"version":           "1.4.2",
+	}, map[string]string{"X-API-Key": enrolled.APIKey})
+	ts.call(http.MethodGet, modulePath, nil, &detail)
+	if detail.AgentVersion != "1.4.2" || detail.PublicKeyMismatch || detail.Status != int(models.ModuleStatusOnline) {
+		t.Errorf("心跳后模块: %+v", detail)
+	}
+
+	// 模块端仍使用旧公钥时标记为警告
+	ts.request(http.MethodPost, agentPath+"/heartbeat", map[string]interface{}{
+		"wireguard_running": true,
+		"public_key":        module.PublicKey,
+	}, map[string]string{"X-API-Key": enrolled.APIKey})
+	ts.call(http.MethodGet, modulePath, nil, &detail)
+	if !detail.PublicKeyMismatch || detail.Status != int(models.ModuleStatusWarning) || detail.AgentVersion != "1.4.2" {
+		t.Errorf("公钥不一致时模块: %+v", detail)
+	}
+
+	// 流量上报只刷新在线时间，不计入流量历史
+	traffic := ts.request(http.MethodPost, agentPath+"/traffic", map[string]interface{}{
+		"rx_bytes": 1000, "tx_bytes": 2000,
+	}, map[string]string{"X-API-Key": enrolled.APIKey})
+	if traffic.Code != http.StatusOK {
+		t.Errorf("流量上报返回 %d: %s", traffic.Code, traffic.Body.String())
+	}
+	var samples int64
+	database.DB.Model(&models.TrafficSample{}).Where("peer_type = ? AND peer_id = ?", models.TrafficPeerModule, module.ID).Count(&samples)
+	if samples != 0 {
+		t.Errorf("模块上报的流量写入了 %d 条流量历史", samples)
+	}
+
+	unauthorized := ts.request(http.MethodPost, agentPath+"/heartbeat", nil, map[string]string{"X-API-Key": "invalid"})
+	if unauthorized.Code != http.StatusUnauthorized {
+		t.Errorf("无效密钥心跳返回 %d, 期望 401", unauthorized.Code)
+	}
+
+	configResp := ts.request(http.MethodGet, agentPath+"/config", nil, map[string]string{"X-API-Key": enrolled.APIKey})
+	if configResp.Code != http.StatusOK {
+		t.Fatalf("拉取配置返回 %d: %s", configResp.Code, configResp.Body.String())
+	}
+	if !strings.Contains(configResp.Body.String(), wgInterface.PublicKey) {
+		t.Error("模块配置中缺少服务端公钥")
+	}
+
+	etag := configResp.Header().Get("ETag")
+	notModified := ts.request(http.MethodGet, agentPath+"/config", nil, map[string]string{
+		"X-API-Key":     enrolled.APIKey,
+		"If-None-Match": etag,
+	})
+	if notModified.Code != http.StatusNotModified {
+		t.Errorf("配置未变化时返回 %d, 期望 304", notModified.Code)
+	}
+}
+
+func TestInterfaceImport(t *testing.T) {
+	ts := newTestServer(t)
+
+	keys := make([]*models.WireGuardKey, 3)
+	for i := range keys {
+		keyPair, err := wireguard.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = keyPair
+	}
+	serverKey, moduleKey, userKey := keys[0], keys[1], keys[2]
+
+	serverConfig := func(userAllowedIPs string) string {
+		return fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.88.0.1/24
+ListenPort = 51888
+PostUp = iptables -A FORWARD -i %%i -j ACCEPT
+
+[Peer]
+# beijing - 机房A
+PublicKey = %s
+AllowedIPs = 10.88.0.2/32, 192.168.60.0/24
+Endpoint = 203.0.113.20:51820
+
+[Peer]
+# User: bob
+PublicKey = %s
+AllowedIPs = %s
+`, serverKey.PrivateKey, moduleKey.PublicKey, userKey.PublicKey, userAllowedIPs)
+	}
+	userConfig := fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.88.0.3/32
+
+[Peer]
+PublicKey = %s
+Endpoint = 198.51.100.1:51888
+AllowedIPs = 10.88.0.0/24, 192.168.60.0/24
+PersistentKeepalive = 25
+`, userKey.PrivateKey, serverKey.PublicKey)
+
+	var result struct {
+		Interface apiInterface `json:"interface"`
+		Peers     []struct {
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			IPAddress  string `json:"ip_address"`
+			HasPrivate bool   `json:"has_private"`
+			ID         uint   `json:"id"`
+		} `json:"peers"`
+		Conflicts []struct {
+			Line    int    `json:"line"`
+			Message string `json:"message"`
+		} `json:"conflicts"`
+		Imported bool `json:"imported"`
+	}
+
+	// 网段外的IP作为冲突报告，且不写入数据库
+	conflict := ts.request(http.MethodPost, "/api/v1/interfaces/import", map[string]interface{}{
+		"name":   "wg8",
+		"config": serverConfig("10.77.0.3/32"),
+	}, nil)
+	if conflict.Code != http.StatusConflict {
+		t.Fatalf("冲突导入返回 %d: %s", conflict.Code, conflict.Body.String())
+	}
+	var conflictResp apiResponse
+	if err := json.Unmarshal(conflict.Body.Bytes(), &conflictResp); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(conflictResp.Data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Line != 13 {
+		t.Errorf("冲突 = %+v, 期望第13行的一个冲突", result.Conflicts)
+	}
+	if _, err := services.NewWireGuardInterfaceService().GetInterfaceByName("wg8"); err == nil {
+		t.Fatal("存在冲突时接口被写入数据库")
+	}
+
+	request := map[string]interface{}{
+		"name":         "wg8",
+		"config":       serverConfig("10.88.0.3/32"),
+		"peer_configs": []string{userConfig},
+		"dry_run":      true,
+	}
+	ts.call(http.MethodPost, "/api/v1/interfaces/import", request, &result)
+	if result.Imported || len(result.Peers) != 2 {
+		t.Fatalf("检查模式结果异常: %+v", result)
+	}
+
+	request["dry_run"] = false
+	ts.call(http.MethodPost, "/api/v1/interfaces/import", request, &result)
+	if !result.Imported || result.Interface.PublicKey != serverKey.PublicKey || result.Interface.ServerIP != "10.88.0.1" {
+		t.Fatalf("导入结果异常: %+v", result)
+	}
+	module, user := result.Peers[0], result.Peers[1]
+	if module.Kind != "module" || module.Name != "beijing" || module.IPAddress != "10.88.0.2" || module.HasPrivate {
+		t.Errorf("模块导入结果异常: %+v", module)
+	}
+	if user.Kind != "user" || user.Name != "bob" || user.IPAddress != "10.88.0.3" || !user.HasPrivate {
+		t.Errorf("用户导入结果异常: %+v", user)
+	}
+
+	userVPNConfig := ts.request(http.MethodGet, fmt.Sprintf("/api/v1/user-vpn/%d/config", user.ID), nil, nil).Body.String()
+	if !strings.Contains(userVPNConfig, userKey.PrivateKey) || !strings.Contains(userVPNConfig, "192.168.60.0/24") {
+		t.Errorf("用户配置未使用导入的私钥和AllowedIPs:\n%s", userVPNConfig)
+	}
+
+	// IP池中已占用导入的地址，新模块不会重复分配
+	created := ts.createModule(result.Interface.ID, "after-import")
+	if created.IPAddress == "10.88.0.2" || created.IPAddress == "10.88.0.3" {
+		t.Errorf("新模块分配到已导入的IP %s", created.IPAddress)
+	}
+
+	ts.startInterface(result.Interface.ID)
+	if peer, ok := ts.peer("wg8", moduleKey.PublicKey); !ok || peer.Endpoint != "203.0.113.20:51820" {
+		t.Errorf("导入的模块未下发到接口: %+v", peer)
+	}
+	if _, ok := ts.peer("wg8", userKey.PublicKey); !ok {
+		t.Error("导入的用户未下发到接口")
+	}
+
+	again := ts.request(http.MethodPost, "/api/v1/interfaces/import", request, nil)
+	if again.Code != http.StatusConflict {
+		t.Errorf("重复导入返回 %d, 期望 409", again.Code)
+	}
+}
+
+func TestTrafficHistory(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg8", "10.88.0.0/24", 51888)
+	module := ts.createModule(wgInterface.ID, "traffic-module")
+	ts.startInterface(wgInterface.ID)
+
+	var userVPN apiPeerRecord
+	ts.call(http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{
+		"module_id":   module.ID,
+		"username":    "carol",
+		"max_devices": 1,
+	}, &userVPN)
+
+	addTraffic := func(publicKey string, rx, tx uint64) {
+		t.Helper()
+		if err := ts.backend.AddTraffic("wg8", publicKey, rx, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sync := func() {
+		t.Helper()
+		ts.call(http.MethodPost, "/api/v1/modules/sync", nil, nil)
+	}
+
+	addTraffic(module.PublicKey, 1000, 500)
+	addTraffic(userVPN.PublicKey, 10, 20)
+	sync()
+	addTraffic(module.PublicKey, 200, 100)
+	sync()
+
+	// 接口重启后计数器归零，重启后的流量继续累计
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/interfaces/%d/stop", wgInterface.ID), nil, nil)
+	ts.startInterface(wgInterface.ID)
+	addTraffic(module.PublicKey, 300, 50)
+	sync()
+
+	var stored struct {
+		TotalRxBytes uint64 `json:"total_rx_bytes"`
+		TotalTxBytes uint64 `json:"total_tx_bytes"`
+	}
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/modules/%d", module.ID), nil, &stored)
+	if stored.TotalRxBytes != 1500 || stored.TotalTxBytes != 650 {
+		t.Errorf("模块累计流量 rx=%d tx=%d, 期望 rx=1500 tx=650", stored.TotalRxBytes, stored.TotalTxBytes)
+	}
+
+	var history struct {
+		Resolution string `json:"resolution"`
+		TotalRx    uint64 `json:"total_rx"`
+		TotalTx    uint64 `json:"total_tx"`
+		Points     []struct {
+			RxBytes uint64 `json:"rx_bytes"`
+		} `json:"points"`
+	}
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/traffic/modules/%d?range=1h", module.ID), nil, &history)
+	if history.Resolution != "1m" || len(history.Points) < 60 {
+		t.Errorf("1小时范围应按分钟返回, 粒度 %s, 数据点 %d", history.Resolution, len(history.Points))
+	}
+	if history.TotalRx != 1500 || history.TotalTx != 650 {
+		t.Errorf("模块流量历史 rx=%d tx=%d, 期望 rx=1500 tx=650", history.TotalRx, history.TotalTx)
+	}
+
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/traffic/user-vpn/%d?range=24h&resolution=1h", userVPN.ID), nil, &history)
+	if history.TotalRx != 10 || history.TotalTx != 20 || len(history.Points) != 25 {
+		t.Errorf("用户流量历史 rx=%d tx=%d 点数=%d", history.TotalRx, history.TotalTx, len(history.Points))
+	}
+
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/traffic/interfaces/%d?range=48h&resolution=1d", wgInterface.ID), nil, &history)
+	if history.TotalRx != 1510 || history.TotalTx != 670 {
+		t.Errorf("接口流量历史 rx=%d tx=%d, 期望 rx=1510 tx=670", history.TotalRx, history.TotalTx)
+	}
+
+	dashboard, err := services.NewDashboardService(services.NewModuleService()).GetDashboardStats()
+	if err != nil {
+		t.Fatalf("获取仪表盘统计失败: %v", err)
+	}
+	var chartRx uint64
+	for _, point := range dashboard.TrafficChart {
+		chartRx += point.Rx
+	}
+	if chartRx != 1510 || dashboard.TrafficStats.TodayRx != 1510 {
+		t.Errorf("仪表盘流量图表 rx=%d, 今日 rx=%d, 期望 1510", chartRx, dashboard.TrafficStats.TodayRx)
+	}
+
+	if resp := ts.request(http.MethodGet, "/api/v1/traffic?resolution=5m", nil, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("无效粒度返回 %d, 期望 400", resp.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+
+	wgInterface := ts.createInterface("wg9", "10.90.0.0/24", 51890)
+	module := ts.createModule(wgInterface.ID, "metrics-module")
+	ts.startInterface(wgInterface.ID)
+
+	if err := ts.backend.AddTraffic("wg9", module.PublicKey, 1234, 567); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.backend.SimulateHandshake("wg9", module.PublicKey, "", time.Now().Add(-30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := ts.request(http.MethodGet, "/metrics", nil, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("/metrics 返回 %d: %s", resp.Code, resp.Body.String())
+	}
+	body := resp.Body.String()
+
+	peerLabels := fmt.Sprintf(`interface="wg9",peer_type="module",peer_id="%d",name="metrics-module",public_key="%s"`, module.ID, module.PublicKey)
+	for _, line := range []string{
+		`eitec_vpn_interface_up{interface="wg9"} 1`,
+		`eitec_vpn_interface_up{interface="wg0"} 0`,
+		`eitec_vpn_interface_receive_bytes_total{interface="wg9"} 1234`,
+		`eitec_vpn_peer_receive_bytes_total{` + peerLabels + `} 1234`,
+		`eitec_vpn_peer_transmit_bytes_total{` + peerLabels + `} 567`,
+		`eitec_vpn_modules{status="unconfigured"} 1`,
+		`eitec_vpn_modules{status="online"} 0`,
+		`eitec_vpn_http_request_duration_seconds_count{method="POST",route="/api/v1/interfaces",code="200"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("指标缺少 %q", line)
+		}
+	}
+	if !strings.Contains(body, `eitec_vpn_peer_last_handshake_age_seconds{`+peerLabels+`} `) {
+		t.Error("指标缺少握手时间")
+	}
+	if !strings.Contains(body, `eitec_vpn_ip_pool_utilization_ratio{network="10.90.0.0/24"} `) {
+		t.Error("指标缺少IP池使用率")
+	}
+	if ct := resp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q", ct)
+	}
+}
+
+func TestRoleBasedAccess(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.token
+
+	wgInterface := ts.createInterface("wg9", "10.91.0.0/24", 51891)
+	module := ts.createModule(wgInterface.ID, "rbac-module")
+
+	// 未认证请求被拒绝
+	ts.token = ""
+	if resp := ts.request(http.MethodGet, "/api/v1/modules", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("未认证请求返回 %d, 期望 401", resp.Code)
+	}
+	ts.token = adminToken
+
+	for _, user := range []map[string]string{
+		{"username": "olivia", "password": "operator-pass", "role": "operator"},
+		{"username": "victor", "password": "viewer-pass", "role": "viewer"},
+	} {
+		ts.call(http.MethodPost, "/api/v1/users", user, nil)
+	}
+	if resp := ts.request(http.MethodPost, "/api/v1/users", map[string]string{
+		"username": "mallory", "password": "mallory-pass", "role": "root",
+	}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("无效角色返回 %d, 期望 400", resp.Code)
+	}
+
+	moduleConfig := fmt.Sprintf("/api/v1/modules/%d/config", module.ID)
+	cases := []struct {
+		method string
+		path   string
+		body   interface{}
+		viewer int
+		oper   int
+	}{
+		{http.MethodGet, "/api/v1/modules", nil, http.StatusOK, http.StatusOK},
+		{http.MethodGet, fmt.Sprintf("/api/v1/interfaces/%d", wgInterface.ID), nil, http.StatusOK, http.StatusOK},
+		{http.MethodGet, moduleConfig, nil, http.StatusForbidden, http.StatusOK},
+		{http.MethodGet, fmt.Sprintf("/api/v1/interfaces/%d/config", wgInterface.ID), nil, http.StatusForbidden, http.StatusOK},
+		{http.MethodPut, fmt.Sprintf("/api/v1/modules/%d", module.ID), map[string]string{"location": "新机房"}, http.StatusForbidden, http.StatusOK},
+		{http.MethodGet, "/api/v1/config", nil, http.StatusForbidden, http.StatusOK},
+		{http.MethodPost, "/api/v1/config/reset", nil, http.StatusForbidden, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/users", nil, http.StatusForbidden, http.StatusForbidden},
+	}
+
+	for _, account := range []struct {
+		username, password string
+		expect             func(i int) int
+	}{
+		{"victor", "viewer-pass", func(i int) int { return cases[i].viewer }},
+		{"olivia", "operator-pass", func(i int) int { return cases[i].oper }},
+	} {
+		ts.login(account.username, account.password)
+		for i, tc := range cases {
+			if resp := ts.request(tc.method, tc.path, tc.body, nil); resp.Code != account.expect(i) {
+				t.Errorf("%s: %s %s 返回 %d, 期望 %d", account.username, tc.method, tc.path, resp.Code, account.expect(i))
+			}
+		}
+	}
+
+	// 角色随令牌下发，当前用户信息返回角色和权限
+	var me struct {
+		Role        string   `json:"role"`
+		Permissions []string `json:"permissions"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &me)
+	if me.Role != "operator" || len(me.Permissions) == 0 {
+		t.Errorf("当前用户角色 %q, 权限 %v", me.Role, me.Permissions)
+	}
+
+	// 模块详情不再返回私钥
+	resp := ts.request(http.MethodGet, fmt.Sprintf("/api/v1/modules/%d", module.ID), nil, nil)
+	if strings.Contains(resp.Body.String(), "private_key") || strings.Contains(resp.Body.String(), "preshared_key") {
+		t.Errorf("模块详情包含密钥: %s", resp.Body.String())
+	}
+
+	// 管理员不能降级或删除自己
+	ts.token = adminToken
+	var admin struct {
+		ID uint `json:"id"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &admin)
+	if resp := ts.request(http.MethodPut, fmt.Sprintf("/api/v1/users/%d", admin.ID), map[string]string{"role": "viewer"}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("修改自己的角色返回 %d, 期望 400", resp.Code)
+	}
+	if resp := ts.request(http.MethodDelete, fmt.Sprintf("/api/v1/users/%d", admin.ID), nil, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("删除自己返回 %d, 期望 400", resp.Code)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.token
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "lucy", "password": "lucy-pass"}, nil)
+
+	// 默认5次失败后锁定，锁定期间正确密码也被拒绝
+	ts.token = ""
+	for i := 1; i <= 5; i++ {
+		want := http.StatusUnauthorized
+		if i == 5 {
+			want = http.StatusTooManyRequests
+		}
+		resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "lucy", "password": "wrong"}, nil)
+		if resp.Code != want {
+			t.Fatalf("第%d次失败返回 %d, 期望 %d", i, resp.Code, want)
+		}
+	}
+	resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "lucy", "password": "lucy-pass"}, nil)
+	if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") == "" {
+		t.Fatalf("锁定期间返回 %d, Retry-After=%q", resp.Code, resp.Header().Get("Retry-After"))
+	}
+
+	ts.token = adminToken
+	var lockouts []struct {
+		Kind        string     `json:"kind"`
+		Key         string     `json:"key"`
+		LockedUntil *time.Time `json:"locked_until"`
+	}
+	ts.call(http.MethodGet, "/api/v1/users/lockouts", nil, &lockouts)
+	if len(lockouts) != 1 || lockouts[0].Kind != "user" || lockouts[0].Key != "lucy" || lockouts[0].LockedUntil == nil {
+		t.Fatalf("锁定列表: %+v", lockouts)
+	}
+
+	ts.call(http.MethodDelete, "/api/v1/users/lockouts/user/lucy", nil, nil)
+	if resp := ts.request(http.MethodDelete, "/api/v1/users/lockouts/user/lucy", nil, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("重复解锁返回 %d, 期望 404", resp.Code)
+	}
+
+	var events []struct {
+		Action string `json:"action"`
+		Actor  string `json:"actor"`
+	}
+	ts.call(http.MethodGet, "/api/v1/users/lockout-events", nil, &events)
+	if len(events) != 2 || events[0].Action != "unlocked" || events[0].Actor != "admin" || events[1].Action != "locked" {
+		t.Errorf("锁定事件: %+v", events)
+	}
+
+	ts.login("lucy", "lucy-pass")
+}
+
+func TestLoginLockoutIgnoresForgedForwardedFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		lockKey string
+	}{
+		// 默认不信任任何代理，伪造的X-Forwarded-For不能分散来源IP的失败次数
+		{name: "未配置可信代理", lockKey: "192.0.2.1"},
+		// 请求来自可信代理时按转发头中的客户端地址计数
+		{name: "来自可信代理", config: "  trusted_proxies: [\"192.0.2.0/24\"]\n", lockKey: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServerWithConfig(t, tt.config)
+			adminToken := ts.token
+
+			// 每次换一个账户，只有来源IP的失败总数（默认20次）会触发锁定
+			ts.token = ""
+			for i := 1; i <= 20; i++ {
+				want := http.StatusUnauthorized
+				if i == 20 {
+					want = http.StatusTooManyRequests
+				}
+				forwarded := fmt.Sprintf("203.0.113.%d", i)
+				if tt.config != "" {
+					forwarded = "203.0.113.7"
+				}
+				resp := ts.request(http.MethodPost, "/api/v1/auth/login",
+					map[string]string{"username": fmt.Sprintf("ghost%d", i), "password": "wrong"},
+					map[string]string{"X-Forwarded-For": forwarded})
+				if resp.Code != want {
+					t.Fatalf("第%d次失败返回 %d, 期望 %d", i, resp.Code, want)
+				}
+			}
+
+			ts.token = adminToken
+			var lockouts []struct {
+				Kind string `json:"kind"`
+				Key  string `json:"key"`
+			}
+			ts.call(http.MethodGet, "/api/v1/users/lockouts", nil, &lockouts)
+			if len(lockouts) != 1 || lockouts[0].Kind != "ip" || lockouts[0].Key != tt.lockKey {
+				t.Errorf("锁定列表: %+v, 期望锁定IP %s", lockouts, tt.lockKey)
+			}
+		})
+	}
+}
+
+// loginResult 登录及两步验证响应中测试关心的字段
+type loginResult struct {
+	AccessToken      string   `json:"access_token"`
+	RefreshToken     string   `json:"refresh_token"`
+	RecoveryCodes    []string `json:"recovery_codes"`
+	MFARequired      bool     `json:"mfa_required"`
+	MFASetupRequired bool     `json:"mfa_setup_required"`
+	MFAToken         string   `json:"mfa_token"`
+}
+
+func TestTOTPLogin(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.token
+
+	// 绑定：生成密钥后用当前验证码确认，返回恢复码
+	var enrollment struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/totp/setup", nil, &enrollment)
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") {
+		t.Fatalf("绑定URI格式错误: %s", enrollment.ProvisioningURI)
+	}
+	counter := totp.Counter(time.Now())
+	code, _ := totp.Code(enrollment.Secret, counter)
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/totp/enable", map[string]string{"code": code}, &enabled)
+	if len(enabled.RecoveryCodes) != 10 {
+		t.Fatalf("恢复码数量 %d, 期望 10", len(enabled.RecoveryCodes))
+	}
+
+	// 密码正确只返回临时令牌，不签发访问令牌
+	ts.token = ""
+	var challenge loginResult
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "admin", "password": "admin123"}, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" || challenge.AccessToken != "" {
+		t.Fatalf("登录第一步: %+v", challenge)
+	}
+	ts.token = challenge.MFAToken
+	if resp := ts.request(http.MethodGet, "/api/v1/modules", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("临时令牌访问API返回 %d, 期望 401", resp.Code)
+	}
+	ts.token = ""
+
+	// 已用于绑定的验证码不能重放
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login/totp", map[string]string{"mfa_token": challenge.MFAToken, "code": code}, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("重放验证码返回 %d, 期望 401", resp.Code)
+	}
+	next, _ := totp.Code(enrollment.Secret, counter+1)
+	var result loginResult
+	ts.call(http.MethodPost, "/api/v1/auth/login/totp", map[string]string{"mfa_token": challenge.MFAToken, "code": next}, &result)
+	if result.AccessToken == "" {
+		t.Fatal("第二步未返回访问令牌")
+	}
+
+	// 恢复码只能使用一次
+	recovery := map[string]string{"mfa_token": challenge.MFAToken, "code": enabled.RecoveryCodes[0]}
+	ts.call(http.MethodPost, "/api/v1/auth/login/totp", recovery, &result)
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login/totp", recovery, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("重复使用恢复码返回 %d, 期望 401", resp.Code)
+	}
+
+	// 策略要求两步验证后，未绑定的用户在登录过程中完成绑定
+	ts.token = adminToken
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "tina", "password": "tina-pass"}, nil)
+	ts.call(http.MethodPut, "/api/v1/config", map[string]interface{}{"security.require_2fa": true}, nil)
+
+	ts.token = ""
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "tina", "password": "tina-pass"}, &challenge)
+	if !challenge.MFASetupRequired || challenge.AccessToken != "" {
+		t.Fatalf("策略要求时登录第一步: %+v", challenge)
+	}
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login/totp", map[string]string{"mfa_token": challenge.MFAToken, "code": "000000"}, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("绑定令牌用于验证返回 %d, 期望 401", resp.Code)
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/login/totp/setup", map[string]string{"mfa_token": challenge.MFAToken}, &enrollment)
+	code, _ = totp.Code(enrollment.Secret, totp.Counter(time.Now()))
+	ts.call(http.MethodPost, "/api/v1/auth/login/totp/enable", map[string]string{"mfa_token": challenge.MFAToken, "code": code}, &result)
+	if result.AccessToken == "" || len(result.RecoveryCodes) != 10 {
+		t.Fatalf("登录时绑定: %+v", result)
+	}
+
+	// 策略要求时不能自行关闭
+	ts.token = result.AccessToken
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/totp/disable", map[string]string{"password": "tina-pass", "code": result.RecoveryCodes[0]}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("策略要求时关闭返回 %d, 期望 400", resp.Code)
+	}
+
+	// 管理员重置后需要重新绑定
+	var users struct {
+		Users []struct {
+			ID          uint   `json:"id"`
+			Username    string `json:"username"`
+			TOTPEnabled bool   `json:"totp_enabled"`
+		} `json:"users"`
+	}
+	ts.token = adminToken
+	ts.call(http.MethodGet, "/api/v1/users", nil, &users)
+	for _, user := range users.Users {
+		if user.Username == "tina" {
+			if !user.TOTPEnabled {
+				t.Error("用户列表未显示已启用两步验证")
+			}
+			ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/users/%d/totp", user.ID), nil, nil)
+		}
+	}
+	ts.token = ""
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "tina", "password": "tina-pass"}, &challenge)
+	if !challenge.MFASetupRequired {
+		t.Errorf("重置后登录: %+v", challenge)
+	}
+}
+
+func TestTOTPEnableLockout(t *testing.T) {
+	ts := newTestServer(t)
+	var tom struct {
+		ID uint `json:"id"`
+	}
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "tom", "password": "tom-pass"}, &tom)
+	ts.call(http.MethodPut, "/api/v1/config", map[string]interface{}{"security.require_2fa": true}, nil)
+
+	ts.token = ""
+	var challenge loginResult
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "tom", "password": "tom-pass"}, &challenge)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/login/totp/setup", map[string]string{"mfa_token": challenge.MFAToken}, &enrollment)
+
+	// 待确认的密钥加密存储
+	if sealed := rawColumn(t, &models.User{}, "totp_secret", tom.ID); !envelope.IsSealed(sealed) || strings.Contains(sealed, enrollment.Secret) {
+		t.Errorf("TOTP密钥未加密: %s", sealed)
+	}
+
+	// 确认绑定时的错误验证码计入登录锁定，锁定后正确的验证码也被拒绝
+	counter := totp.Counter(time.Now())
+	valid := make(map[string]bool)
+	for _, c := range []int64{counter - 1, counter, counter + 1} {
+		code, _ := totp.Code(enrollment.Secret, c)
+		valid[code] = true
+	}
+	wrong := "000000"
+	for _, candidate := range []string{"000000", "111111", "222222", "333333"} {
+		if !valid[candidate] {
+			wrong = candidate
+			break
+		}
+	}
+	for i := 1; i <= 5; i++ {
+		want := http.StatusBadRequest
+		if i == 5 {
+			want = http.StatusTooManyRequests
+		}
+		resp := ts.request(http.MethodPost, "/api/v1/auth/login/totp/enable", map[string]string{"mfa_token": challenge.MFAToken, "code": wrong}, nil)
+		if resp.Code != want {
+			t.Fatalf("第%d次错误验证码返回 %d, 期望 %d", i, resp.Code, want)
+		}
+	}
+	code, _ := totp.Code(enrollment.Secret, counter)
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login/totp/enable", map[string]string{"mfa_token": challenge.MFAToken, "code": code}, nil); resp.Code != http.StatusTooManyRequests {
+		t.Errorf("锁定期间确认绑定返回 %d, 期望 429", resp.Code)
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.token
+
+	var second loginResult
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "admin", "password": "admin123"}, &second)
+
+	var sessions []struct {
+		ID      uint `json:"id"`
+		Current bool `json:"current"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/sessions", nil, &sessions)
+	if len(sessions) != 2 || sessions[0].Current == sessions[1].Current {
+		t.Fatalf("会话列表: %+v", sessions)
+	}
+
+	// 刷新令牌轮换：新令牌可用，旧令牌再次使用时撤销整个会话
+	var rotated loginResult
+	ts.call(http.MethodPost, "/api/v1/auth/refresh", map[string]string{"refresh_token": second.RefreshToken}, &rotated)
+	if rotated.AccessToken == "" || rotated.RefreshToken == "" || rotated.RefreshToken == second.RefreshToken {
+		t.Fatalf("刷新结果: %+v", rotated)
+	}
+	ts.token = rotated.AccessToken
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, nil)
+
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/refresh", map[string]string{"refresh_token": second.RefreshToken}, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("重复使用刷新令牌返回 %d, 期望 401", resp.Code)
+	}
+	if resp := ts.request(http.MethodGet, "/api/v1/auth/me", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("会话撤销后访问令牌返回 %d, 期望 401", resp.Code)
+	}
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/refresh", map[string]string{"refresh_token": rotated.RefreshToken}, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("会话撤销后新刷新令牌返回 %d, 期望 401", resp.Code)
+	}
+
+	// 撤销自己的其他会话
+	ts.token = ""
+	var third loginResult
+	ts.call(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "admin", "password": "admin123"}, &third)
+	ts.token = adminToken
+	ts.call(http.MethodGet, "/api/v1/auth/sessions", nil, &sessions)
+	for _, session := range sessions {
+		if !session.Current {
+			ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/auth/sessions/%d", session.ID), nil, nil)
+		}
+	}
+	ts.token = third.AccessToken
+	if resp := ts.request(http.MethodGet, "/api/v1/auth/me", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("撤销后的会话返回 %d, 期望 401", resp.Code)
+	}
+
+	// 管理员重置密码后，用户已签发的令牌立即失效
+	ts.token = adminToken
+	var user struct {
+		ID uint `json:"id"`
+	}
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "mike", "password": "mike-pass"}, &user)
+	ts.login("mike", "mike-pass")
+	mikeToken := ts.token
+	ts.token = adminToken
+	ts.call(http.MethodPost, fmt.Sprintf("/api/v1/users/%d/reset-password", user.ID), map[string]string{"new_password": "mike-new-pass"}, nil)
+	ts.token = mikeToken
+	if resp := ts.request(http.MethodGet, "/api/v1/auth/me", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("重置密码后旧令牌返回 %d, 期望 401", resp.Code)
+	}
+
+	// 注销后当前令牌失效
+	ts.token = adminToken
+	ts.call(http.MethodPost, "/api/v1/auth/logout", nil, nil)
+	if resp := ts.request(http.MethodGet, "/api/v1/auth/me", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("注销后令牌返回 %d, 期望 401", resp.Code)
+	}
+}
+
+func TestAccessTokenBoundToSessionUser(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.token
+
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "olga", "password": "olga-pass", "role": "operator"}, nil)
+	ts.login("olga", "olga-pass")
+	var sessions []struct {
+		ID      uint `json:"id"`
+		Current bool `json:"current"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/sessions", nil, &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("会话列表: %+v", sessions)
+	}
+
+	// 管理员身份的令牌指向其他用户的会话时被拒绝
+	var admin models.User
+	if err := database.DB.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("查询管理员失败: %v", err)
+	}
+	cfg := config.GetGlobalServerConfig()
+	jwtService := auth.NewJWTService(cfg.Auth.JWTSecret, cfg.Auth.RefreshSecret, cfg.Auth.AccessExpiry, cfg.Auth.RefreshExpiry)
+	forged, err := jwtService.GenerateTokenPair(&admin, sessions[0].ID)
+	if err != nil {
+		t.Fatalf("生成令牌失败: %v", err)
+	}
+	olgaToken := ts.token
+	ts.token = forged.AccessToken
+	if resp := ts.request(http.MethodGet, "/api/v1/users", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("会话与令牌用户不一致返回 %d, 期望 401", resp.Code)
+	}
+
+	// 角色以数据库为准，降级后已签发的令牌立即失去权限
+	ts.token = olgaToken
+	ts.call(http.MethodGet, "/api/v1/config", nil, nil)
+	if err := database.DB.Model(&models.User{}).Where("username = ?", "olga").Update("role", models.RoleViewer).Error; err != nil {
+		t.Fatalf("修改角色失败: %v", err)
+	}
+	if resp := ts.request(http.MethodGet, "/api/v1/config", nil, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("降级后访问配置返回 %d, 期望 403", resp.Code)
+	}
+	var me struct {
+		Role string `json:"role"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &me)
+	if me.Role != string(models.RoleViewer) {
+		t.Errorf("降级后当前用户角色 %q, 期望 viewer", me.Role)
+	}
+
+	// 停用的用户令牌立即失效
+	if err := database.DB.Model(&models.User{}).Where("username = ?", "olga").Update("is_active", false).Error; err != nil {
+		t.Fatalf("停用用户失败: %v", err)
+	}
+	if resp := ts.request(http.MethodGet, "/api/v1/auth/me", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("停用后令牌返回 %d, 期望 401", resp.Code)
+	}
+
+	ts.token = adminToken
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, nil)
+}
+
+// rawColumn 绕过加密序列化器读取数据库中保存的原始值
+func rawColumn(t *testing.T, model interface{}, column string, id uint) string {
+	t.Helper()
+	var value string
+	if err := database.DB.Model(model).Select(column).Where("id = ?", id).Row().Scan(&value); err != nil {
+		t.Fatalf("读取 %s 失败: %v", column, err)
+	}
+	return value
+}
+
+func TestSecretsEncryptedAtRest(t *testing.T) {
+	ts := newTestServer(t)
+	wgInterface := ts.createInterface("wg9", "10.92.0.0/24", 51892)
+	module := ts.createModule(wgInterface.ID, "sealed-module")
+
+	// 数据库中只保存密文，下载的配置中是解密后的私钥
+	sealed := rawColumn(t, &models.Module{}, "private_key", module.ID)
+	if !envelope.IsSealed(sealed) {
+		t.Fatalf("模块私钥未加密: %s", sealed)
+	}
+	moduleAAD := envelope.AAD("modules", "private_key", module.ID)
+	privateKey, err := envelope.Open(sealed, moduleAAD)
+	if err != nil || envelope.IsSealed(privateKey) || len(privateKey) != 44 {
+		t.Fatalf("解密模块私钥: %q, %v", privateKey, err)
+	}
+	moduleConfig := fmt.Sprintf("/api/v1/modules/%d/config", module.ID)
+	if body := ts.request(http.MethodGet, moduleConfig, nil, nil).Body.String(); !strings.Contains(body, privateKey) {
+		t.Errorf("模块配置未包含解密后的私钥:\n%s", body)
+	}
+
+	// 按列更新的私钥同样加密，且只加密一次
+	ts.call(http.MethodPost, fmt.Sprintf("/api/v1/modules/%d/regenerate-keys", module.ID), nil, nil)
+	regenerated, err := envelope.Open(rawColumn(t, &models.Module{}, "private_key", module.ID), moduleAAD)
+	if err != nil || regenerated == privateKey || envelope.IsSealed(regenerated) {
+		t.Fatalf("重新生成的私钥: %q, %v", regenerated, err)
+	}
+	if !envelope.IsSealed(rawColumn(t, &models.WireGuardInterface{}, "private_key", wgInterface.ID)) {
+		t.Error("接口私钥未加密")
+	}
+
+	// 系统配置默认不返回敏感信息，显式请求需要secrets:read权限
+	if body := ts.request(http.MethodGet, "/api/v1/config", nil, nil).Body.String(); strings.Contains(body, "private_key") {
+		t.Errorf("系统配置包含私钥: %s", body)
+	}
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "vera", "password": "viewer-pass", "role": "viewer"}, nil)
+	adminToken := ts.token
+	ts.login("vera", "viewer-pass")
+	if resp := ts.request(http.MethodGet, "/api/v1/config?include_secrets=true", nil, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("只读用户请求敏感配置返回 %d, 期望 403", resp.Code)
+	}
+	ts.token = adminToken
+
+	// 轮换主密钥后所有密文改用新主密钥，配置仍可正常生成
+	newKey, _ := envelope.GenerateKey()
+	rotated, err := envelope.Default().Rotate(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resealed, err := database.ResealSecrets(rotated)
+	if err != nil || resealed == 0 {
+		t.Fatalf("重新加密 %d 个字段, %v", resealed, err)
+	}
+	envelope.SetKeyring(rotated)
+	if id := envelope.KeyID(rawColumn(t, &models.Module{}, "private_key", module.ID)); id != rotated.PrimaryID() {
+		t.Errorf("轮换后密钥ID %s, 期望 %s", id, rotated.PrimaryID())
+	}
+	if body := ts.request(http.MethodGet, moduleConfig, nil, nil).Body.String(); !strings.Contains(body, regenerated) {
+		t.Errorf("轮换后模块配置异常:\n%s", body)
+	}
+	if again, _ := database.ResealSecrets(rotated); again != 0 {
+		t.Errorf("重复轮换更新了 %d 个字段", again)
+	}
+
+	// 密文绑定所在的行，复制到其他模块后无法读取
+	other := ts.createModule(wgInterface.ID, "other-module")
+	stolen := rawColumn(t, &models.Module{}, "private_key", module.ID)
+	database.DB.Model(&models.Module{}).Where("id = ?", other.ID).UpdateColumn("private_key", stolen)
+	var copied models.Module
+	if err := database.DB.First(&copied, other.ID).Error; err == nil {
+		t.Errorf("复制到其他行的密文被解密: %q", copied.PrivateKey)
+	}
+
+	// 启动迁移完成后数据库中出现的明文不再按明文读取
+	database.DB.Model(&models.Module{}).Where("id = ?", other.ID).UpdateColumn("private_key", "legacy-plaintext")
+	if err := database.DB.First(&copied, other.ID).Error; !errors.Is(err, envelope.ErrNotSealed) {
+		t.Errorf("读取明文私钥应返回ErrNotSealed, 实际: %v", err)
+	}
+
+	// 升级前的明文重新加密时绑定所在的行
+	if resealed, err := database.ResealSecrets(rotated); err != nil || resealed != 1 {
+		t.Fatalf("重新加密 %d 个字段, %v", resealed, err)
+	}
+	otherSealed := rawColumn(t, &models.Module{}, "private_key", other.ID)
+	if plaintext, err := envelope.Open(otherSealed, envelope.AAD("modules", "private_key", other.ID)); err != nil || plaintext != "legacy-plaintext" {
+		t.Errorf("重新加密后解密: %q, %v", plaintext, err)
+	}
+	if err := database.DB.First(&copied, other.ID).Error; err != nil || copied.PrivateKey != "legacy-plaintext" {
+		t.Errorf("读取重新加密的模块: %q, %v", copied.PrivateKey, err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	ts := newTestServer(t)
+
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "admin", "password": "wrong-password"}, nil); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("错误密码登录返回 %d", resp.Code)
+	}
+
+	wgInterface := ts.createInterface("wg9", "10.93.0.0/24", 51893)
+	module := ts.createModule(wgInterface.ID, "audited-module")
+	ts.startInterface(wgInterface.ID)
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/interfaces/%d/stop", wgInterface.ID), nil, nil)
+	modulePath := fmt.Sprintf("/api/v1/modules/%d", module.ID)
+	ts.call(http.MethodPut, modulePath, map[string]string{"location": "新机房"}, nil)
+	ts.call(http.MethodPost, modulePath+"/regenerate-keys", nil, nil)
+	ts.call(http.MethodDelete, modulePath, nil, nil)
+
+	type auditEntry struct {
+		Actor      string                            `json:"actor"`
+		SourceIP   string                            `json:"source_ip"`
+		Action     string                            `json:"action"`
+		TargetID   uint                              `json:"target_id"`
+		TargetName string                            `json:"target_name"`
+		Success    bool                              `json:"success"`
+		Changes    map[string]map[string]interface{} `json:"changes"`
+	}
+
+	// 按操作前缀过滤，最新的在前
+	var entries []auditEntry
+	ts.call(http.MethodGet, "/api/v1/audit-logs?action=module.", nil, &entries)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		if entry.Actor != "admin" || entry.TargetID != module.ID || entry.TargetName != "audited-module" || entry.SourceIP == "" {
+			t.Errorf("审计日志字段错误: %+v", entry)
+		}
+	}
+	expected := []string{models.AuditModuleDelete, models.AuditModuleRegenerateKeys, models.AuditModuleUpdate, models.AuditModuleCreate}
+	if strings.Join(actions, ",") != strings.Join(expected, ",") {
+		t.Fatalf("模块审计日志 %v, 期望 %v", actions, expected)
+	}
+	if change := entries[2].Changes["location"]; change["before"] != "测试机房" || change["after"] != "新机房" {
+		t.Errorf("修改前后差异错误: %v", entries[2].Changes)
+	}
+	if _, ok := entries[1].Changes["public_key"]; !ok {
+		t.Errorf("重新生成密钥未记录公钥变化: %v", entries[1].Changes)
+	}
+
+	var failed []auditEntry
+	ts.call(http.MethodGet, "/api/v1/audit-logs?action=auth.login_failed&success=false", nil, &failed)
+	if len(failed) != 1 || failed[0].Actor != "admin" || failed[0].Success {
+		t.Errorf("登录失败记录: %+v", failed)
+	}
+	var interfaceEntries []auditEntry
+	ts.call(http.MethodGet, fmt.Sprintf("/api/v1/audit-logs?target_type=interface&target_id=%d", wgInterface.ID), nil, &interfaceEntries)
+	if len(interfaceEntries) != 3 || interfaceEntries[0].Action != models.AuditInterfaceStop || interfaceEntries[1].Action != models.AuditInterfaceStart {
+		t.Errorf("接口审计日志: %+v", interfaceEntries)
+	}
+
+	// 导出为JSON Lines，按时间正序
+	resp := ts.request(http.MethodGet, "/api/v1/audit-logs/export?target_type=module", nil, nil)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("导出返回 %d, %s", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("导出 %d 行, 期望 %d", len(lines), len(expected))
+	}
+	var first auditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.Action != models.AuditModuleCreate {
+		t.Errorf("导出首行 %s: %v", lines[0], err)
+	}
+	if strings.Contains(resp.Body.String(), "private_key") {
+		t.Error("审计日志包含私钥")
+	}
+
+	// 审计日志只允许追加
+	if err := database.DB.Model(&models.AuditLog{}).Where("1 = 1").Update("actor", "mallory").Error; err == nil {
+		t.Error("审计日志不应允许修改")
+	}
+	if err := database.DB.Exec("DELETE FROM audit_logs").Error; err == nil {
+		t.Error("审计日志不应允许删除")
+	}
+
+	// 仪表盘最近活动来自审计日志
+	var stats struct {
+		RecentActivity []struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"recent_activity"`
+	}
+	ts.call(http.MethodGet, "/api/v1/dashboard/stats", nil, &stats)
+	if len(stats.RecentActivity) == 0 || stats.RecentActivity[0].Message != "admin 删除模块 audited-module" {
+		t.Errorf("最近活动: %+v", stats.RecentActivity)
+	}
+
+	// 审计日志仅管理员可查看
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "olivia", "password": "operator-pass", "role": "operator"}, nil)
+	ts.login("olivia", "operator-pass")
+	if resp := ts.request(http.MethodGet, "/api/v1/audit-logs", nil, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("操作员查看审计日志返回 %d, 期望 403", resp.Code)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	ts := newTestServer(t)
+	sessionToken := ts.token
+
+	wgInterface := ts.createInterface("wg9", "10.94.0.0/24", 51894)
+
+	// 授权范围必须有效且不能超出角色权限
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "victor", "password": "viewer-pass", "role": "viewer"}, nil)
+	ts.login("victor", "viewer-pass")
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/tokens", map[string]interface{}{"name": "ci", "scopes": []string{"modules:write"}}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("超出角色权限的授权范围返回 %d, 期望 400", resp.Code)
+	}
+	ts.token = sessionToken
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/tokens", map[string]interface{}{"name": "ci", "scopes": []string{"modules:everything"}}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("无效授权范围返回 %d, 期望 400", resp.Code)
+	}
+
+	var created struct {
+		ID          uint   `json:"id"`
+		Token       string `json:"token"`
+		TokenPrefix string `json:"token_prefix"`
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/tokens", map[string]interface{}{
+		"name":            "ci-readonly",
+		"scopes":          []string{"modules:read"},
+		"expires_in_days": 30,
+	}, &created)
+	if !strings.HasPrefix(created.Token, "evp_") || !strings.HasPrefix(created.Token, created.TokenPrefix) {
+		t.Fatalf("创建的令牌 %q, 前缀 %q", created.Token, created.TokenPrefix)
+	}
+
+	// 令牌权限为角色权限与授权范围的交集
+	ts.token = created.Token
+	if resp := ts.request(http.MethodGet, "/api/v1/modules", nil, nil); resp.Code != http.StatusOK {
+		t.Fatalf("令牌读取模块返回 %d: %s", resp.Code, resp.Body.String())
+	}
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "/api/v1/modules", map[string]interface{}{"name": "pat-module", "location": "测试机房", "interface_id": wgInterface.ID, "allowed_ips": "192.168.50.0/24"}},
+		{http.MethodPost, "/api/v1/user-vpn", map[string]interface{}{"username": "pat-user", "module_id": 1}},
+		{http.MethodGet, "/api/v1/users", nil},
+		{http.MethodPost, "/api/v1/auth/tokens", map[string]interface{}{"name": "escalate", "scopes": []string{"users:write"}}},
+		{http.MethodGet, "/api/v1/auth/sessions", nil},
+	} {
+		if resp := ts.request(tc.method, tc.path, tc.body, nil); resp.Code != http.StatusForbidden {
+			t.Errorf("令牌请求 %s %s 返回 %d, 期望 403", tc.method, tc.path, resp.Code)
+		}
+	}
+
+	// 列表返回前缀和最后使用时间，不返回哈希
+	ts.token = sessionToken
+	resp := ts.request(http.MethodGet, "/api/v1/auth/tokens", nil, nil)
+	if strings.Contains(resp.Body.String(), "hash") || strings.Contains(resp.Body.String(), created.Token) {
+		t.Errorf("令牌列表泄露令牌: %s", resp.Body.String())
+	}
+	var tokens []struct {
+		TokenPrefix string     `json:"token_prefix"`
+		Scopes      string     `json:"scopes"`
+		LastUsedAt  *time.Time `json:"last_used_at"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/tokens", nil, &tokens)
+	if len(tokens) != 1 || tokens[0].TokenPrefix != created.TokenPrefix || tokens[0].Scopes != "modules:read" || tokens[0].LastUsedAt == nil || tokens[0].ExpiresAt == nil {
+		t.Fatalf("令牌列表: %+v", tokens)
+	}
+
+	// 吊销后令牌立即失效
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/auth/tokens/%d", created.ID), nil, nil)
+	ts.token = created.Token
+	if resp := ts.request(http.MethodGet, "/api/v1/modules", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("吊销后的令牌返回 %d, 期望 401", resp.Code)
+	}
+
+	// 管理员可以查看和吊销其他用户的令牌
+	ts.login("victor", "viewer-pass")
+	var victorToken struct {
+		ID    uint   `json:"id"`
+		Token string `json:"token"`
+	}
+	ts.call(http.MethodPost, "/api/v1/auth/tokens", map[string]interface{}{"name": "dashboard", "scopes": []string{"modules:read"}}, &victorToken)
+	var victor struct {
+		ID uint `json:"id"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &victor)
+	ts.token = sessionToken
+	ts.call(http.MethodDelete, fmt.Sprintf("/api/v1/users/%d/tokens/%d", victor.ID, victorToken.ID), nil, nil)
+	ts.token = victorToken.Token
+	if resp := ts.request(http.MethodGet, "/api/v1/modules", nil, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("管理员吊销的令牌返回 %d, 期望 401", resp.Code)
+	}
+
+	ts.token = sessionToken
+	var entries []struct {
+		Action string `json:"action"`
+	}
+	ts.call(http.MethodGet, "/api/v1/audit-logs?target_type=api_token", nil, &entries)
+	if len(entries) != 4 {
+		t.Errorf("令牌审计日志: %+v", entries)
+	}
+}
+
+// oidcLogin 模拟浏览器完成单点登录，返回登录页URL片段中的参数
+func (ts *testServer) oidcLogin(state string) url.Values {
+	ts.t.Helper()
+
+	resp := ts.request(http.MethodGet, "/api/v1/auth/oidc/login", nil, nil)
+	if resp.Code != http.StatusFound {
+		ts.t.Fatalf("发起单点登录返回 %d: %s", resp.Code, resp.Body.String())
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == "oidc_state" {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		ts.t.Fatalf("未设置登录状态cookie: %v", resp.Result().Cookies())
+	}
+
+	// 身份提供方授权后跳转回回调地址
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	idpResp, err := client.Get(resp.Header().Get("Location"))
+	if err != nil {
+		ts.t.Fatalf("访问身份提供方失败: %v", err)
+	}
+	idpResp.Body.Close()
+	callback, err := url.Parse(idpResp.Header.Get("Location"))
+	if err != nil || callback.Path != "/api/v1/auth/oidc/callback" {
+		ts.t.Fatalf("身份提供方回调地址 %s", idpResp.Header.Get("Location"))
+	}
+	if state != "" {
+		query := callback.Query()
+		query.Set("state", state)
+		callback.RawQuery = query.Encode()
+	}
+
+	resp = ts.request(http.MethodGet, callback.RequestURI(), nil, map[string]string{"Cookie": "oidc_state=" + cookie.Value})
+	location, err := url.Parse(resp.Header().Get("Location"))
+	if resp.Code != http.StatusFound || err != nil || location.Path != "/login" {
+		ts.t.Fatalf("单点登录回调返回 %d, Location %s", resp.Code, resp.Header().Get("Location"))
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		ts.t.Fatalf("解析登录结果失败: %v", err)
+	}
+	return fragment
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := oidctest.NewProvider("eitec-vpn", "idp-secret")
+	defer idp.Close()
+
+	ts := newTestServerWithConfig(t, fmt.Sprintf(`auth:
+  disable_password_login: true
+  oidc:
+    enabled: true
+    name: "Example SSO"
+    issuer: %q
+    client_id: "eitec-vpn"
+    client_secret: "idp-secret"
+    redirect_url: "http://vpn.example.com/api/v1/auth/oidc/callback"
+    role_mapping:
+      vpn-admins: admin
+      vpn-ops: operator
+`, idp.Issuer()))
+	adminToken := ts.token
+
+	var providers struct {
+		PasswordLogin bool `json:"password_login"`
+		OIDC          struct {
+			Enabled bool   `json:"enabled"`
+			Name    string `json:"name"`
+		} `json:"oidc"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/providers", nil, &providers)
+	if providers.PasswordLogin || !providers.OIDC.Enabled || providers.OIDC.Name != "Example SSO" {
+		t.Errorf("登录方式: %+v", providers)
+	}
+
+	// 关闭密码登录后只有应急账户（默认管理员）可以使用密码登录
+	ts.call(http.MethodPost, "/api/v1/users", map[string]string{"username": "olivia", "password": "operator-pass", "role": "operator"}, nil)
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "olivia", "password": "operator-pass"}, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("关闭密码登录后普通账户登录返回 %d, 期望 403", resp.Code)
+	}
+
+	// 首次登录自动创建用户，按用户组映射角色
+	ts.token = ""
+	idp.SetUser(map[string]interface{}{"sub": "idp-alice", "preferred_username": "alice", "groups": []string{"staff", "vpn-admins"}})
+	result := ts.oidcLogin("")
+	if result.Get("error") != "" || result.Get("access_token") == "" || result.Get("refresh_token") == "" {
+		t.Fatalf("单点登录结果: %v", result)
+	}
+	ts.token = result.Get("access_token")
+	var me struct {
+		ID       uint   `json:"id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &me)
+	if me.Username != "alice" || me.Role != "admin" {
+		t.Fatalf("单点登录用户: %+v", me)
+	}
+	aliceID := me.ID
+
+	// 再次登录时以身份提供方的用户组为准更新角色，不重复创建用户
+	idp.SetUser(map[string]interface{}{"sub": "idp-alice", "preferred_username": "alice", "groups": []string{"vpn-ops"}})
+	ts.token = ts.oidcLogin("").Get("access_token")
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &me)
+	if me.ID != aliceID || me.Role != "operator" {
+		t.Errorf("再次登录后的用户: %+v", me)
+	}
+
+	// 单点登录用户不能使用密码登录
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "alice", "password": "anything"}, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("单点登录用户密码登录返回 %d, 期望 403", resp.Code)
+	}
+
+	// 没有映射角色的用户组、与本地账户同名、state不一致都会被拒绝
+	idp.SetUser(map[string]interface{}{"sub": "idp-bob", "preferred_username": "bob", "groups": []string{"staff"}})
+	if result := ts.oidcLogin(""); result.Get("error") != auth.ErrAccessDenied.Error() {
+		t.Errorf("无角色用户登录结果: %v", result)
+	}
+	idp.SetUser(map[string]interface{}{"sub": "idp-olivia", "preferred_username": "olivia", "groups": []string{"vpn-admins"}})
+	if result := ts.oidcLogin(""); result.Get("error") != auth.ErrUsernameTaken.Error() {
+		t.Errorf("与本地账户同名登录结果: %v", result)
+	}
+	idp.SetUser(map[string]interface{}{"sub": "idp-alice", "preferred_username": "alice", "groups": []string{"vpn-admins"}})
+	if result := ts.oidcLogin("forged-state"); result.Get("error") != auth.ErrOIDCState.Error() || result.Get("access_token") != "" {
+		t.Errorf("伪造state登录结果: %v", result)
+	}
+
+	// 应急账户仍可使用密码登录，停用的单点登录用户不能再登录
+	ts.login("admin", "admin123")
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/users/%d/status", aliceID), map[string]bool{"is_active": false}, nil)
+	if result := ts.oidcLogin(""); result.Get("error") != auth.ErrAccountDisabled.Error() {
+		t.Errorf("停用用户登录结果: %v", result)
+	}
+
+	ts.token = adminToken
+	var created []struct {
+		Actor      string `json:"actor"`
+		TargetName string `json:"target_name"`
+	}
+	ts.call(http.MethodGet, "/api/v1/audit-logs?action=user.create", nil, &created)
+	if len(created) != 2 || created[0].Actor != "alice" || created[0].TargetName != "alice" {
+		t.Errorf("自动创建用户的审计日志: %+v", created)
+	}
+}
+
+func TestLDAPLogin(t *testing.T) {
+	directory := ldaptest.NewServer(
+		ldaptest.Entry{DN: "dc=example,dc=com", Attributes: map[string][]string{"objectClass": {"domain"}}},
+		ldaptest.Entry{DN: "cn=svc-vpn,ou=services,dc=example,dc=com", Password: "svc-pass"},
+		ldaptest.Entry{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "alice-pass", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"alice"}, "memberOf": {"cn=vpn-ops,ou=groups,dc=example,dc=com"},
+		}},
+		ldaptest.Entry{DN: "uid=carol,ou=people,dc=example,dc=com", Password: "carol-pass", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"carol"},
+		}},
+		ldaptest.Entry{DN: "uid=bob,ou=people,dc=example,dc=com", Password: "bob-pass", Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"bob"}, "memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+		}},
+		ldaptest.Entry{DN: "cn=vpn-admins,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"objectClass": {"groupOfNames"}, "member": {"uid=carol,ou=people,dc=example,dc=com"},
+		}},
+	)
+	defer directory.Close()
+
+	ts := newTestServerWithConfig(t, fmt.Sprintf(`auth:
+  ldap:
+    enabled: true
+    url: %q
+    bind_dn: "cn=svc-vpn,ou=services,dc=example,dc=com"
+    bind_password: "svc-pass"
+    base_dn: "dc=example,dc=com"
+    group_base_dn: "ou=groups,dc=example,dc=com"
+    role_mapping:
+      vpn-admins: admin
+      "cn=vpn-ops,ou=groups,dc=example,dc=com": operator
+`, directory.URL()))
+	adminToken := ts.token
+
+	// 连接测试：只测试服务账号，以及查找用户并校验密码
+	var result auth.LDAPTestResult
+	ts.call(http.MethodPost, "/api/v1/users/ldap/test", map[string]string{}, &result)
+	if result.URL != directory.URL() || result.UserDN != "" {
+		t.Errorf("连接测试结果: %+v", result)
+	}
+	ts.call(http.MethodPost, "/api/v1/users/ldap/test", map[string]string{"username": "carol", "password": "carol-pass"}, &result)
+	if result.UserDN != "uid=carol,ou=people,dc=example,dc=com" || result.Role != models.RoleAdmin || !result.Authenticated {
+		t.Errorf("用户测试结果: %+v", result)
+	}
+	if resp := ts.request(http.MethodPost, "/api/v1/users/ldap/test", map[string]string{"username": "carol", "password": "wrong"}, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("错误密码测试返回 %d, 期望 400", resp.Code)
+	}
+
+	// 目录用户首次登录自动创建，按用户条目的memberOf或组查找的结果映射角色
+	ts.login("alice", "alice-pass")
+	var me struct {
+		ID       uint   `json:"id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &me)
+	if me.Username != "alice" || me.Role != "operator" {
+		t.Errorf("LDAP用户: %+v", me)
+	}
+	aliceID := me.ID
+	ts.login("carol", "carol-pass")
+	ts.call(http.MethodGet, "/api/v1/auth/me", nil, &me)
+	if me.Username != "carol" || me.Role != "admin" {
+		t.Errorf("LDAP组成员: %+v", me)
+	}
+
+	// 错误密码、不存在的用户、没有映射角色的用户都不能登录
+	for _, tc := range []struct {
+		username, password string
+		code               int
+	}{
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"alice", "", http.StatusBadRequest},
+		{"nobody", "whatever", http.StatusUnauthorized},
+		{"bob", "bob-pass", http.StatusForbidden},
+	} {
+		resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": tc.username, "password": tc.password}, nil)
+		if resp.Code != tc.code {
+			t.Errorf("%s 登录返回 %d, 期望 %d", tc.username, resp.Code, tc.code)
+		}
+	}
+
+	// 本地账户仍然可以登录，停用的目录用户不能再登录
+	ts.login("admin", "admin123")
+	ts.call(http.MethodPut, fmt.Sprintf("/api/v1/users/%d/status", aliceID), map[string]bool{"is_active": false}, nil)
+	if resp := ts.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"username": "alice", "password": "alice-pass"}, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("停用的LDAP用户登录返回 %d, 期望 403", resp.Code)
+	}
+
+	ts.token = adminToken
+	var list struct {
+		Users []struct {
+			Username   string `json:"username"`
+			AuthSource string `json:"auth_source"`
+		} `json:"users"`
+	}
+	ts.call(http.MethodGet, "/api/v1/users", nil, &list)
+	sources := make(map[string]string)
+	for _, user := range list.Users {
+		sources[user.Username] = user.AuthSource
+	}
+	if sources["admin"] != "local" || sources["alice"] != "ldap" || sources["carol"] != "ldap" || sources["bob"] != "" {
+		t.Errorf("用户来源: %v", sources)
+	}
+}
@@ -3,12 +3,17 @@ package routes
 import (
 	"eitec-vpn/internal/server/handlers"
 	"eitec-vpn/internal/server/middleware"
+	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/server/services"
 	"eitec-vpn/internal/shared/auth"
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/metrics"
 	"eitec-vpn/internal/shared/utils"
 	"fmt"
+	"log"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,31 +31,80 @@ type RedirectRoute struct {
 	To   string
 }
 
-// SetupRoutes 设置服务端路由 - 优化版本，消除重复代码
-func SetupRoutes(
+// Handlers 路由使用的处理器及其依赖，启动时创建一次，供 SetupRoutes 和 SetupAPIRoutes 使用
+type Handlers struct {
+	Module           *handlers.ModuleHandler
+	Dashboard        *handlers.DashboardHandler
+	Config           *handlers.ConfigHandler
+	Auth             *handlers.AuthHandler
+	User             *handlers.UserHandler
+	Interface        *handlers.InterfaceHandler
+	ModuleAgent      *handlers.ModuleAgentHandler
+	ModuleCredential *handlers.ModuleCredentialHandler
+	ModuleEnrollment *handlers.ModuleEnrollmentHandler
+	Traffic          *handlers.TrafficHandler
+	Audit            *handlers.AuditHandler
+	APIToken         *handlers.APITokenHandler
+
+	// 认证中间件依赖
+	jwtService              *auth.JWTService
+	sessionManager          *auth.SessionManager
+	apiTokenService         *auth.APITokenService
+	moduleCredentialService *services.ModuleCredentialService
+}
+
+// NewHandlers 创建路由处理器
+func NewHandlers(
 	moduleService *services.ModuleService,
 	dashboardService *services.DashboardService,
 	configService *services.ConfigService,
 	userService *auth.UserService,
 	jwtService *auth.JWTService,
 	sessionManager *auth.SessionManager,
-) *gin.Engine {
+	oidcService *auth.OIDCService,
+) *Handlers {
+	// 模块代理服务
+	moduleCredentialService := services.NewModuleCredentialService()
+	enrollmentService := services.NewModuleEnrollmentService(moduleService, moduleCredentialService)
+	apiTokenService := auth.NewAPITokenService()
+
+	return &Handlers{
+		Module:           handlers.NewModuleHandler(moduleService),
+		Dashboard:        handlers.NewDashboardHandler(dashboardService),
+		Config:           handlers.NewConfigHandler(configService),
+		Auth:             handlers.NewAuthHandler(userService, jwtService, sessionManager, oidcService),
+		User:             handlers.NewUserHandler(userService, sessionManager),
+		Interface:        handlers.NewInterfaceHandler(),
+		ModuleAgent:      handlers.NewModuleAgentHandler(services.NewModuleAgentService(moduleService)),
+		ModuleCredential: handlers.NewModuleCredentialHandler(moduleCredentialService),
+		ModuleEnrollment: handlers.NewModuleEnrollmentHandler(enrollmentService),
+		Traffic:          handlers.NewTrafficHandler(services.NewTrafficService()),
+		Audit:            handlers.NewAuditHandler(services.NewAuditService()),
+		APIToken:         handlers.NewAPITokenHandler(apiTokenService, userService),
+
+		jwtService:              jwtService,
+		sessionManager:          sessionManager,
+		apiTokenService:         apiTokenService,
+		moduleCredentialService: moduleCredentialService,
+	}
+}
+
+// SetupRoutes 设置服务端路由 - 优化版本，消除重复代码
+func SetupRoutes(h *Handlers) *gin.Engine {
 	r := gin.New()
+	setTrustedProxies(r)
+	metricsService := services.NewMetricsService()
 
 	// 全局中间件
 	r.Use(middleware.LoggerMiddleware())
 	r.Use(middleware.RecoveryMiddleware())
+	r.Use(middleware.MetricsMiddleware(metricsService))
 	r.Use(middleware.CORSMiddleware())
 	r.Use(middleware.SecurityMiddleware())
 	r.Use(middleware.TimeoutMiddleware())
 
-	// 创建处理器
-	moduleHandler := handlers.NewModuleHandler(moduleService)
-	dashboardHandler := handlers.NewDashboardHandler(dashboardService)
-	configHandler := handlers.NewConfigHandler(configService)
-	authHandler := handlers.NewAuthHandler(userService, jwtService, sessionManager)
-	userHandler := handlers.NewUserHandler(userService)
-	interfaceHandler := handlers.NewInterfaceHandler()
+	// Prometheus指标
+	r.GET("/metrics", metrics.Handler(metricsToken(), metricsService.Collect))
 
 	// 健康检查 (无需认证)
 	r.GET("/health", func(c *gin.Context) {
@@ -67,36 +121,27 @@ func SetupRoutes(
 	setupPageRoutes(r)
 
 	// 设置API路由
-	setupAPIRoutes(r, moduleHandler, dashboardHandler, configHandler, authHandler, userHandler, interfaceHandler)
+	setupAPIRoutes(r, h)
 
 	return r
 }
 
 // SetupAPIRoutes 设置API路由 (用于生产环境，仅API)
-func SetupAPIRoutes(
-	moduleService *services.ModuleService,
-	dashboardService *services.DashboardService,
-	configService *services.ConfigService,
-	userService *auth.UserService,
-	jwtService *auth.JWTService,
-	sessionManager *auth.SessionManager,
-) *gin.Engine {
+func SetupAPIRoutes(h *Handlers) *gin.Engine {
 	r := gin.New()
+	setTrustedProxies(r)
+	metricsService := services.NewMetricsService()
 
 	// 全局中间件
 	r.Use(middleware.LoggerMiddleware())
 	r.Use(middleware.RecoveryMiddleware())
+	r.Use(middleware.MetricsMiddleware(metricsService))
 	r.Use(middleware.CORSMiddleware())
 	r.Use(middleware.SecurityMiddleware())
 	r.Use(middleware.TimeoutMiddleware())
 
-	// 创建处理器
-	moduleHandler := handlers.NewModuleHandler(moduleService)
-	dashboardHandler := handlers.NewDashboardHandler(dashboardService)
-	configHandler := handlers.NewConfigHandler(configService)
-	authHandler := handlers.NewAuthHandler(userService, jwtService, sessionManager)
-	userHandler := handlers.NewUserHandler(userService)
-	interfaceHandler := handlers.NewInterfaceHandler()
+	// Prometheus指标
+	r.GET("/metrics", metrics.Handler(metricsToken(), metricsService.Collect))
 
 	// 健康检查
 	r.GET("/health", func(c *gin.Context) {
@@ -116,11 +161,40 @@ func SetupAPIRoutes(
 	})
 
 	// 设置API路由
-	setupAPIRoutes(r, moduleHandler, dashboardHandler, configHandler, authHandler, userHandler, interfaceHandler)
+	setupAPIRoutes(r, h)
 
 	return r
 }
 
+// authMiddleware 获取API认证中间件，配置 auth.disabled 时跳过认证
+func authMiddleware(jwtService *auth.JWTService, sessionManager *auth.SessionManager, apiTokenService *auth.APITokenService) gin.HandlerFunc {
+	if cfg := config.GetGlobalServerConfig(); cfg != nil && cfg.Auth.Disabled {
+		log.Println("⚠️ 已关闭API认证 (auth.disabled)，所有请求视为管理员，请勿在生产环境使用")
+		return middleware.NoAuthMiddleware()
+	}
+	return middleware.JWTAuthMiddleware(jwtService, sessionManager, apiTokenService)
+}
+
+// metricsToken 获取/metrics的访问令牌，未加载配置时不校验
+func metricsToken() string {
+	if cfg := config.GetGlobalServerConfig(); cfg != nil {
+		return cfg.Metrics.Token
+	}
+	return ""
+}
+
+// setTrustedProxies 只采信配置的反向代理转发的客户端地址，防止伪造X-Forwarded-For绕过按IP的登录锁定
+func setTrustedProxies(r *gin.Engine) {
+	var proxies []string
+	if cfg := config.GetGlobalServerConfig(); cfg != nil {
+		proxies = cfg.App.TrustedProxies
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Printf("设置可信代理失败，将忽略X-Forwarded-For: %v", err)
+		r.SetTrustedProxies(nil)
+	}
+}
+
 // setupStaticAndTemplates 设置静态文件和模板
 func setupStaticAndTemplates(r *gin.Engine) {
 	// 智能查找静态文件和模板路径
@@ -201,15 +275,7 @@ func setupPageRoutes(r *gin.Engine) {
 }
 
 // setupAPIRoutes 设置API路由
-func setupAPIRoutes(
-	r *gin.Engine,
-	moduleHandler *handlers.ModuleHandler,
-	dashboardHandler *handlers.DashboardHandler,
-	configHandler *handlers.ConfigHandler,
-	authHandler *handlers.AuthHandler,
-	userHandler *handlers.UserHandler,
-	interfaceHandler *handlers.InterfaceHandler,
-) {
+func setupAPIRoutes(r *gin.Engine, h *Handlers) {
 
 	// API路由组
 	api := r.Group("/api/v1")
@@ -217,41 +283,83 @@ func setupAPIRoutes(
 		// 公共路由 (无需认证)
 		public := api.Group("")
 		{
-			public.POST("/auth/login", authHandler.Login)
-			public.POST("/auth/refresh", authHandler.RefreshToken)
+			public.POST("/auth/login", h.Auth.Login)
+			public.POST("/auth/refresh", h.Auth.RefreshToken)
+			public.POST("/auth/login/totp", h.Auth.LoginTOTP)
+			public.POST("/auth/login/totp/setup", h.Auth.LoginTOTPSetup)
+			public.POST("/auth/login/totp/enable", h.Auth.LoginTOTPEnable)
+			public.GET("/auth/providers", h.Auth.GetAuthProviders)
+			public.GET("/auth/oidc/login", h.Auth.OIDCLogin)
+			public.GET("/auth/oidc/callback", h.Auth.OIDCCallback)
+
+			// 模块使用一次性加入令牌注册 (限制频率防止暴力猜测)
+			public.POST("/enroll", middleware.RateLimit(10, time.Minute), h.ModuleEnrollment.Enroll)
+		}
+
+		// 模块代理路由 (使用模块API密钥认证)
+		agent := api.Group("/agent/modules/:id")
+		agent.Use(middleware.ModuleAgentAuthMiddleware(h.moduleCredentialService))
+		{
+			setupAgentRoutes(agent, h.ModuleAgent)
 		}
 
-		// 认证路由 (需要JWT认证) - 暂时注释掉认证要求
+		// 认证路由 (需要JWT或个人访问令牌认证，各路由组再按角色权限控制)
 		auth := api.Group("")
-		// auth.Use(middleware.JWTAuthMiddleware()) // 注释掉JWT认证中间件
+		auth.Use(authMiddleware(h.jwtService, h.sessionManager, h.apiTokenService))
 		{
 			// 认证相关
-			setupAuthRoutes(auth, authHandler)
+			setupAuthRoutes(auth, h.Auth, h.APIToken)
 
 			// 仪表盘相关
-			setupDashboardRoutes(auth, dashboardHandler)
+			setupDashboardRoutes(auth, h.Dashboard)
 
 			// 模块管理相关
-			setupModuleRoutes(auth, moduleHandler)
+			setupModuleRoutes(auth, h.Module)
+
+			// 模块API凭证相关
+			setupModuleCredentialRoutes(auth, h.ModuleCredential)
+			auth.POST("/modules/:id/join-token", middleware.RequirePermission(models.PermModulesWrite), h.ModuleEnrollment.CreateJoinToken)
 
-			// 系统配置相关 (仅管理员) - 注释掉权限检查
-			setupConfigRoutes(auth, configHandler)
+			// 系统配置相关
+			setupConfigRoutes(auth, h.Config)
 
-			// 用户管理相关 (仅管理员) - 注释掉权限检查
-			setupUserRoutes(auth, userHandler)
+			// 用户管理相关 (仅管理员)
+			setupUserRoutes(auth, h.User, h.APIToken)
 
 			// WireGuard接口管理相关
-			setupInterfaceRoutes(auth, interfaceHandler)
+			setupInterfaceRoutes(auth, h.Interface)
+
+			// 流量历史相关
+			setupTrafficRoutes(auth, h.Traffic)
+
+			// 审计日志相关
+			setupAuditRoutes(auth, h.Audit)
 
 		}
 	}
 }
 
 // setupAuthRoutes 设置认证相关路由
-func setupAuthRoutes(auth *gin.RouterGroup, authHandler *handlers.AuthHandler) {
+func setupAuthRoutes(auth *gin.RouterGroup, authHandler *handlers.AuthHandler, apiTokenHandler *handlers.APITokenHandler) {
 	auth.GET("/auth/me", authHandler.GetCurrentUser)
-	auth.POST("/auth/logout", authHandler.Logout)
-	auth.POST("/auth/change-password", authHandler.ChangePassword)
+
+	// 账户安全相关接口只能使用登录会话访问，个人访问令牌泄露后不能被用来扩大权限
+	account := auth.Group("/auth", middleware.RequireSession())
+	{
+		account.POST("/logout", authHandler.Logout)
+		account.POST("/change-password", authHandler.ChangePassword)
+		account.GET("/sessions", authHandler.GetSessions)
+		account.DELETE("/sessions", authHandler.RevokeOtherSessions)
+		account.DELETE("/sessions/:id", authHandler.RevokeSession)
+		account.GET("/totp", authHandler.GetTOTPStatus)
+		account.POST("/totp/setup", authHandler.SetupTOTP)
+		account.POST("/totp/enable", authHandler.EnableTOTP)
+		account.POST("/totp/disable", authHandler.DisableTOTP)
+		account.POST("/totp/recovery-codes", authHandler.RegenerateRecoveryCodes)
+		account.GET("/tokens", apiTokenHandler.GetTokens)
+		account.POST("/tokens", apiTokenHandler.CreateToken)
+		account.DELETE("/tokens/:id", apiTokenHandler.RevokeToken)
+	}
 }
 
 // setupDashboardRoutes 设置仪表盘相关路由
@@ -270,86 +378,171 @@ func setupDashboardRoutes(auth *gin.RouterGroup, dashboardHandler *handlers.Dash
 
 // setupModuleRoutes 设置模块管理相关路由
 func setupModuleRoutes(auth *gin.RouterGroup, moduleHandler *handlers.ModuleHandler) {
+	read := middleware.RequirePermission(models.PermModulesRead)
+	write := middleware.RequirePermission(models.PermModulesWrite)
+	secrets := middleware.RequirePermission(models.PermSecretsRead)
+
 	modules := auth.Group("/modules")
 	{
-		modules.GET("", moduleHandler.GetModules)
-		modules.POST("" /* middleware.RequireRole("admin"), */, moduleHandler.CreateModule) // 注释掉角色权限检查
-		modules.GET("/stats", moduleHandler.GetModuleStats)
-		modules.POST("/sync" /* middleware.RequireRole("admin"), */, moduleHandler.SyncModuleStatus)      // 注释掉角色权限检查
-		modules.DELETE("/batch" /* middleware.RequireRole("admin"), */, moduleHandler.BatchDeleteModules) // 注释掉角色权限检查
+		modules.GET("", read, moduleHandler.GetModules)
+		modules.POST("", write, moduleHandler.CreateModule)
+		modules.GET("/stats", read, moduleHandler.GetModuleStats)
+		modules.POST("/sync", write, moduleHandler.SyncModuleStatus)
+		modules.DELETE("/batch", write, moduleHandler.BatchDeleteModules)
 
 		// 单个模块操作
 		module := modules.Group("/:id")
 		{
-			module.GET("", moduleHandler.GetModule)
-			module.PUT("" /* middleware.RequireRole("admin"), */, moduleHandler.UpdateModule)                    // 注释掉角色权限检查
-			module.DELETE("" /* middleware.RequireRole("admin"), */, moduleHandler.DeleteModule)                 // 注释掉角色权限检查
-			module.PUT("/status" /* middleware.RequireRole("admin"), */, moduleHandler.UpdateModuleStatus)       // 注释掉角色权限检查
-			module.POST("/regenerate-keys" /* middleware.RequireRole("admin"), */, moduleHandler.RegenerateKeys) // 注释掉角色权限检查
-			module.GET("/config", moduleHandler.GenerateModuleConfig)
-			module.GET("/peer-config", moduleHandler.GeneratePeerConfig)
+			module.GET("", read, moduleHandler.GetModule)
+			module.PUT("", write, moduleHandler.UpdateModule)
+			module.DELETE("", write, moduleHandler.DeleteModule)
+			module.PUT("/status", write, moduleHandler.UpdateModuleStatus)
+			module.POST("/regenerate-keys", write, moduleHandler.RegenerateKeys)
+			module.PUT("/address", write, moduleHandler.ChangeModuleAddress)
+			module.GET("/config", secrets, moduleHandler.GenerateModuleConfig) // 包含模块私钥
+			module.GET("/peer-config", read, moduleHandler.GeneratePeerConfig)
 		}
 	}
 
 	// 用户VPN管理路由
 	userVPNHandler := handlers.NewUserVPNHandler()
+	userVPNRead := middleware.RequirePermission(models.PermUserVPNRead)
+	userVPNWrite := middleware.RequirePermission(models.PermUserVPNWrite)
 
 	// 用户VPN CRUD操作
-	auth.POST("/user-vpn", userVPNHandler.CreateUserVPN)
-	auth.GET("/user-vpn/:id", userVPNHandler.GetUserVPN)
-	auth.PUT("/user-vpn/:id", userVPNHandler.UpdateUserVPN)
-	auth.DELETE("/user-vpn/:id", userVPNHandler.DeleteUserVPN)
-	auth.GET("/user-vpn/:id/config", userVPNHandler.GenerateUserVPNConfig)
+	auth.POST("/user-vpn", userVPNWrite, userVPNHandler.CreateUserVPN)
+	auth.GET("/user-vpn/:id", userVPNRead, userVPNHandler.GetUserVPN)
+	auth.PUT("/user-vpn/:id", userVPNWrite, userVPNHandler.UpdateUserVPN)
+	auth.DELETE("/user-vpn/:id", userVPNWrite, userVPNHandler.DeleteUserVPN)
+	auth.PUT("/user-vpn/:id/address", userVPNWrite, userVPNHandler.ChangeUserVPNAddress)
+	auth.GET("/user-vpn/:id/config", secrets, userVPNHandler.GenerateUserVPNConfig) // 包含用户私钥
+	auth.GET("/user-vpn/:id/devices", userVPNRead, userVPNHandler.GetUserVPNDevices)
+	auth.POST("/user-vpn/:id/devices", userVPNWrite, userVPNHandler.CreateUserVPNDevice)
+	auth.DELETE("/user-vpn/:id/devices/:device_id", userVPNWrite, userVPNHandler.DeleteUserVPNDevice)
+	auth.GET("/user-vpn/:id/devices/:device_id/config", secrets, userVPNHandler.GenerateUserVPNDeviceConfig) // 包含设备私钥
 
 	// 模块相关的用户VPN操作 - 修复参数名冲突
-	auth.GET("/modules/:id/users", userVPNHandler.GetUserVPNsByModule)
-	auth.GET("/modules/:id/user-stats", userVPNHandler.GetUserVPNStats)
+	auth.GET("/modules/:id/users", userVPNRead, userVPNHandler.GetUserVPNsByModule)
+	auth.GET("/modules/:id/user-stats", userVPNRead, userVPNHandler.GetUserVPNStats)
+}
+
+// setupAgentRoutes 设置模块代理相关路由
+func setupAgentRoutes(agent *gin.RouterGroup, moduleAgentHandler *handlers.ModuleAgentHandler) {
+	agent.POST("/heartbeat", middleware.RequireModuleScope(models.ModuleScopeHeartbeat), moduleAgentHandler.Heartbeat)
+	agent.POST("/traffic", middleware.RequireModuleScope(models.ModuleScopeTraffic), moduleAgentHandler.ReportTraffic)
+	agent.GET("/config", middleware.RequireModuleScope(models.ModuleScopeConfig), moduleAgentHandler.GetConfig)
+}
+
+// setupModuleCredentialRoutes 设置模块API凭证相关路由
+func setupModuleCredentialRoutes(auth *gin.RouterGroup, moduleCredentialHandler *handlers.ModuleCredentialHandler) {
+	credentials := auth.Group("/modules/:id/credentials")
+	{
+		credentials.GET("", middleware.RequirePermission(models.PermModulesRead), moduleCredentialHandler.GetCredentials)
+		credentials.Use(middleware.RequirePermission(models.PermModulesWrite))
+		credentials.POST("", moduleCredentialHandler.IssueCredential)
+		credentials.POST("/:credential_id/rotate", moduleCredentialHandler.RotateCredential)
+		credentials.DELETE("/:credential_id", moduleCredentialHandler.RevokeCredential)
+	}
 }
 
 // setupConfigRoutes 设置系统配置相关路由
 func setupConfigRoutes(auth *gin.RouterGroup, configHandler *handlers.ConfigHandler) {
+	read := middleware.RequirePermission(models.PermConfigRead)
+	write := middleware.RequirePermission(models.PermConfigWrite)
+
 	config := auth.Group("/config")
-	// config.Use(middleware.RequireRole("admin")) // 注释掉管理员权限检查
 	{
-		config.GET("", configHandler.GetSystemConfig)
-		config.GET("/status", configHandler.GetConfigStatus)
-		config.PUT("", configHandler.UpdateSystemConfig)
-		config.POST("/reset", configHandler.ResetToDefaults)
-		config.GET("/export", configHandler.ExportConfig)
-		config.POST("/import", configHandler.ImportConfig)
-		config.POST("/wireguard/init", configHandler.InitializeWireGuard)
-		config.POST("/wireguard/apply", configHandler.ApplyWireGuardConfig)
-		config.GET("/wireguard/server-config", configHandler.GenerateServerConfig)
-		config.POST("/wireguard/validate", configHandler.ValidateNetworkSettings)
+		config.GET("", read, configHandler.GetSystemConfig)
+		config.GET("/status", read, configHandler.GetConfigStatus)
+		config.PUT("", write, configHandler.UpdateSystemConfig)
+		config.POST("/reset", write, configHandler.ResetToDefaults)
+		config.GET("/export", write, configHandler.ExportConfig) // 导出内容包含密钥，仅允许可修改配置的角色
+		config.POST("/import", write, configHandler.ImportConfig)
+		config.POST("/wireguard/init", write, configHandler.InitializeWireGuard)
+		config.POST("/wireguard/apply", write, configHandler.ApplyWireGuardConfig)
+		config.GET("/wireguard/server-config", read, middleware.RequirePermission(models.PermSecretsRead), configHandler.GenerateServerConfig)
+		config.POST("/wireguard/validate", read, configHandler.ValidateNetworkSettings)
 	}
 }
 
 // setupUserRoutes 设置用户管理相关路由
-func setupUserRoutes(auth *gin.RouterGroup, userHandler *handlers.UserHandler) {
+func setupUserRoutes(auth *gin.RouterGroup, userHandler *handlers.UserHandler, apiTokenHandler *handlers.APITokenHandler) {
+	read := middleware.RequirePermission(models.PermUsersRead)
+	write := middleware.RequirePermission(models.PermUsersWrite)
+
 	users := auth.Group("/users")
-	// users.Use(middleware.RequireRole("admin")) // 注释掉管理员权限检查
 	{
-		users.GET("", userHandler.GetUsers)
-		users.POST("", userHandler.CreateUser)
-		users.GET("/:id", userHandler.GetUser)
-		users.PUT("/:id", userHandler.UpdateUser)
-		users.DELETE("/:id", userHandler.DeleteUser)
-		users.PUT("/:id/status", userHandler.UpdateUserStatus)
-		users.POST("/:id/reset-password", userHandler.ResetPassword)
+		users.GET("", read, userHandler.GetUsers)
+		users.POST("", write, userHandler.CreateUser)
+		users.GET("/:id", read, userHandler.GetUser)
+		users.PUT("/:id", write, userHandler.UpdateUser)
+		users.DELETE("/:id", write, userHandler.DeleteUser)
+		users.PUT("/:id/status", write, userHandler.UpdateUserStatus)
+		users.POST("/:id/reset-password", write, userHandler.ResetPassword)
+		users.DELETE("/:id/totp", write, userHandler.ResetTOTP)
+		users.GET("/:id/sessions", read, userHandler.GetUserSessions)
+		users.DELETE("/:id/sessions", write, userHandler.RevokeUserSessions)
+		users.GET("/:id/tokens", read, apiTokenHandler.GetUserTokens)
+		users.DELETE("/:id/tokens/:token_id", write, apiTokenHandler.RevokeUserToken)
+
+		// 登录失败锁定
+		users.GET("/lockouts", read, userHandler.GetLockouts)
+		users.GET("/lockout-events", read, userHandler.GetLockoutEvents)
+		users.DELETE("/lockouts/:kind/:key", write, userHandler.Unlock)
+
+		// LDAP认证
+		users.POST("/ldap/test", write, userHandler.TestLDAP)
 	}
 }
 
 // setupInterfaceRoutes 设置WireGuard接口管理相关路由
 func setupInterfaceRoutes(auth *gin.RouterGroup, interfaceHandler *handlers.InterfaceHandler) {
+	read := middleware.RequirePermission(models.PermInterfacesRead)
+	write := middleware.RequirePermission(models.PermInterfacesWrite)
+
 	interfaces := auth.Group("/interfaces")
 	{
-		interfaces.GET("", interfaceHandler.GetInterfaces)
-		interfaces.POST("", interfaceHandler.CreateInterface) // 添加创建接口路由
-		interfaces.GET("/:id", interfaceHandler.GetInterface)
-		interfaces.GET("/:id/config", interfaceHandler.GetInterfaceConfig) // 添加获取接口配置路由
-		interfaces.PUT("/:id/start", interfaceHandler.StartInterface)
-		interfaces.PUT("/:id/stop", interfaceHandler.StopInterface)
-		interfaces.DELETE("/:id", interfaceHandler.DeleteInterface) // 添加删除接口路由
-		interfaces.GET("/stats", interfaceHandler.GetInterfaceStats)
+		interfaces.GET("", read, interfaceHandler.GetInterfaces)
+		interfaces.POST("", write, interfaceHandler.CreateInterface)        // 添加创建接口路由
+		interfaces.POST("/import", write, interfaceHandler.ImportInterface) // 导入已有的服务端配置
+		interfaces.GET("/:id", read, interfaceHandler.GetInterface)
+		interfaces.GET("/:id/config", middleware.RequirePermission(models.PermSecretsRead), interfaceHandler.GetInterfaceConfig) // 包含服务器私钥
+		interfaces.PUT("/:id/start", write, interfaceHandler.StartInterface)
+		interfaces.PUT("/:id/stop", write, interfaceHandler.StopInterface)
+		interfaces.DELETE("/:id", write, interfaceHandler.DeleteInterface) // 添加删除接口路由
+		interfaces.GET("/stats", read, interfaceHandler.GetInterfaceStats)
+
+		// 地址管理
+		interfaces.GET("/:id/ipam", read, interfaceHandler.GetIPUtilization)
+		interfaces.GET("/:id/reservations", read, interfaceHandler.GetIPReservations)
+		interfaces.POST("/:id/reservations", write, interfaceHandler.CreateIPReservation)
+		interfaces.DELETE("/:id/reservations/:reservation_id", write, interfaceHandler.DeleteIPReservation)
+		interfaces.GET("/:id/allocations", read, interfaceHandler.GetIPAllocations)
+		interfaces.POST("/:id/allocations", write, interfaceHandler.ReserveIPAddress)
+		interfaces.DELETE("/:id/allocations/:allocation_id", write, interfaceHandler.ReleaseIPAddress)
+		interfaces.GET("/:id/ipam/check", read, interfaceHandler.CheckIPConsistency)
+		interfaces.POST("/:id/ipam/repair", write, interfaceHandler.RepairIPConsistency)
+		interfaces.POST("/:id/renumber", write, interfaceHandler.RenumberInterface) // dry_run时只返回编址计划
+	}
+}
+
+// setupTrafficRoutes 设置流量历史相关路由
+func setupTrafficRoutes(auth *gin.RouterGroup, trafficHandler *handlers.TrafficHandler) {
+	traffic := auth.Group("/traffic")
+	{
+		traffic.GET("", middleware.RequirePermission(models.PermModulesRead), trafficHandler.GetTraffic)
+		traffic.GET("/modules/:id", middleware.RequirePermission(models.PermModulesRead), trafficHandler.GetModuleTraffic)
+		traffic.GET("/user-vpn/:id", middleware.RequirePermission(models.PermUserVPNRead), trafficHandler.GetUserVPNTraffic)
+		traffic.GET("/interfaces/:id", middleware.RequirePermission(models.PermInterfacesRead), trafficHandler.GetInterfaceTraffic)
+	}
+}
+
+// setupAuditRoutes 设置审计日志相关路由
+func setupAuditRoutes(auth *gin.RouterGroup, auditHandler *handlers.AuditHandler) {
+	audit := auth.Group("/audit-logs")
+	audit.Use(middleware.RequirePermission(models.PermAuditRead))
+	{
+		audit.GET("", auditHandler.GetAuditLogs)
+		audit.GET("/export", auditHandler.ExportAuditLogs)
 	}
 }
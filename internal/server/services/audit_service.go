@@ -0,0 +1,243 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+
+	"gorm.io/gorm"
+)
+
+// auditExportBatch 导出审计日志时每批读取的条数
+const auditExportBatch = 500
+
+// auditIgnoredFields 计算修改前后差异时忽略的字段
+var auditIgnoredFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"deleted_at": true,
+}
+
+// auditActionNames 审计操作的中文描述
+var auditActionNames = map[string]string{
+	models.AuditLogin:                "登录",
+	models.AuditLoginFailed:          "登录失败",
+	models.AuditLogout:               "注销",
+	models.AuditPasswordChange:       "修改密码",
+	models.AuditTokenCreate:          "创建个人访问令牌",
+	models.AuditTokenRevoke:          "吊销个人访问令牌",
+	models.AuditModuleCreate:         "创建模块",
+	models.AuditModuleUpdate:         "修改模块",
+	models.AuditModuleDelete:         "删除模块",
+	models.AuditModuleStatus:         "修改模块状态",
+	models.AuditModuleRegenerateKeys: "重新生成模块密钥",
+	models.AuditUserVPNCreate:        "创建用户VPN",
+	models.AuditUserVPNUpdate:        "修改用户VPN",
+	models.AuditUserVPNDelete:        "删除用户VPN",
+	models.AuditUserVPNExpire:        "用户VPN到期",
+	models.AuditUserVPNRestore:       "用户VPN恢复接入",
+	models.AuditUserVPNExpiryWarning: "用户VPN到期提醒",
+	models.AuditUserVPNDeviceCreate:  "添加用户VPN设备",
+	models.AuditUserVPNDeviceDelete:  "删除用户VPN设备",
+	models.AuditInterfaceCreate:      "创建接口",
+	models.AuditInterfaceImport:      "导入接口",
+	models.AuditInterfaceStart:       "启动接口",
+	models.AuditInterfaceStop:        "停止接口",
+	models.AuditInterfaceDelete:      "删除接口",
+	models.AuditConfigUpdate:         "修改系统配置",
+	models.AuditConfigImport:         "导入系统配置",
+	models.AuditConfigReset:          "重置系统配置",
+	models.AuditUserCreate:           "创建用户",
+	models.AuditUserUpdate:           "修改用户",
+	models.AuditUserDelete:           "删除用户",
+	models.AuditUserResetPassword:    "重置用户密码",
+	models.AuditUserResetTOTP:        "重置用户两步验证",
+	models.AuditUserRevokeSessions:   "强制用户下线",
+}
+
+// AuditService 审计日志服务
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService 创建审计日志服务
+func NewAuditService() *AuditService {
+	return &AuditService{
+		db: database.DB,
+	}
+}
+
+// AuditQuery 审计日志查询条件，零值表示不限制
+type AuditQuery struct {
+	Actor      string
+	Action     string // 完整操作名，或以 . 结尾的前缀，如 module.
+	TargetType string
+	TargetID   uint
+	SourceIP   string
+	Success    *bool
+	From       time.Time
+	To         time.Time
+	Page       int
+	Size       int
+}
+
+// Record 追加一条审计日志。记录失败只打印日志，不影响被审计的操作
+func (as *AuditService) Record(entry *models.AuditLog) {
+	if err := as.db.Create(entry).Error; err != nil {
+		log.Printf("记录审计日志失败 (%s %s %d): %v", entry.Action, entry.TargetType, entry.TargetID, err)
+	}
+}
+
+// Query 分页查询审计日志，按时间倒序
+func (as *AuditService) Query(query *AuditQuery) ([]models.AuditLog, int64, error) {
+	var total int64
+	if err := as.filter(query).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("统计审计日志失败: %w", err)
+	}
+
+	var logs []models.AuditLog
+	offset := (query.Page - 1) * query.Size
+	if err := as.filter(query).Order("id DESC").Offset(offset).Limit(query.Size).Find(&logs).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询审计日志失败: %w", err)
+	}
+	return logs, total, nil
+}
+
+// Export 按时间正序把符合条件的审计日志以JSON Lines格式写入w，返回导出条数
+func (as *AuditService) Export(query *AuditQuery, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	exported := 0
+
+	var batch []models.AuditLog
+	result := as.filter(query).Order("id").FindInBatches(&batch, auditExportBatch, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := encoder.Encode(&batch[i]); err != nil {
+				return err
+			}
+		}
+		exported += len(batch)
+		return nil
+	})
+	if result.Error != nil {
+		return exported, fmt.Errorf("导出审计日志失败: %w", result.Error)
+	}
+	return exported, nil
+}
+
+// Recent 获取最近的审计日志
+func (as *AuditService) Recent(limit int) ([]models.AuditLog, error) {
+	var logs []models.AuditLog
+	if err := as.db.Order("id DESC").Limit(limit).Find(&logs).Error; err != nil {
+		return nil, fmt.Errorf("查询审计日志失败: %w", err)
+	}
+	return logs, nil
+}
+
+// filter 根据查询条件构建查询
+func (as *AuditService) filter(query *AuditQuery) *gorm.DB {
+	db := as.db.Model(&models.AuditLog{})
+	if query.Actor != "" {
+		db = db.Where("actor = ?", query.Actor)
+	}
+	if query.Action != "" {
+		if query.Action[len(query.Action)-1] == '.' {
+			db = db.Where("action LIKE ?", query.Action+"%")
+		} else {
+			db = db.Where("action = ?", query.Action)
+		}
+	}
+	if query.TargetType != "" {
+		db = db.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != 0 {
+		db = db.Where("target_id = ?", query.TargetID)
+	}
+	if query.SourceIP != "" {
+		db = db.Where("source_ip = ?", query.SourceIP)
+	}
+	if query.Success != nil {
+		db = db.Where("success = ?", *query.Success)
+	}
+	if !query.From.IsZero() {
+		db = db.Where("created_at >= ?", query.From)
+	}
+	if !query.To.IsZero() {
+		db = db.Where("created_at <= ?", query.To)
+	}
+	return db
+}
+
+// AuditMessage 生成审计日志的一句话描述，如 "admin 删除模块 gateway-1"
+func AuditMessage(entry *models.AuditLog) string {
+	action, ok := auditActionNames[entry.Action]
+	if !ok {
+		action = entry.Action
+	}
+
+	message := action
+	if entry.Actor != "" {
+		message = entry.Actor + " " + action
+	}
+	// 登录操作的对象就是操作者本人
+	if entry.TargetName != "" && entry.TargetName != entry.Actor {
+		message += " " + entry.TargetName
+	}
+	if !entry.Success && entry.Message != "" {
+		message += ": " + entry.Message
+	}
+	return message
+}
+
+// AuditDiff 比较对象修改前后按JSON序列化的字段，返回发生变化的字段。
+// before为nil表示创建，after为nil表示删除。json:"-" 的私钥、密码等字段不会出现在结果中，
+// 关联对象和时间戳字段也不记录
+func AuditDiff(before, after interface{}) map[string]models.AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	changes := make(map[string]models.AuditChange)
+	for key, value := range beforeFields {
+		if other, ok := afterFields[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = models.AuditChange{Before: value, After: afterFields[key]}
+		}
+	}
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			changes[key] = models.AuditChange{After: value}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// auditFields 把对象转换为字段表，忽略时间戳和关联对象
+func auditFields(value interface{}) map[string]interface{} {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	for key, field := range fields {
+		if _, nested := field.(map[string]interface{}); nested || auditIgnoredFields[key] {
+			delete(fields, key)
+		}
+	}
+	return fields
+}
@@ -69,6 +69,7 @@ type SecurityConfigInfo struct {
 	EnableRateLimit   bool   `json:"enable_rate_limit"`
 	RateLimitRequests int    `json:"rate_limit_requests"`
 	RateLimitWindow   int    `json:"rate_limit_window"`
+	RequireTwoFactor  bool   `json:"require_2fa"` // 要求所有用户启用两步验证
 }
 
 // NetworkConfigInfo 网络配置信息
@@ -251,6 +252,9 @@ func (cs *ConfigService) getSecurityConfig(includeSecrets bool) (*SecurityConfig
 		rateLimitWindow = 3600 // 1小时
 	}
 
+	requireTwoFactorStr, _ := database.GetSystemConfig("security.require_2fa")
+	requireTwoFactor, _ := strconv.ParseBool(requireTwoFactorStr)
+
 	return &SecurityConfigInfo{
 		JWTSecret:         jwtSecret,
 		SessionTimeout:    sessionTimeout,
@@ -261,6 +265,7 @@ func (cs *ConfigService) getSecurityConfig(includeSecrets bool) (*SecurityConfig
 		EnableRateLimit:   enableRateLimit,
 		RateLimitRequests: rateLimitRequests,
 		RateLimitWindow:   rateLimitWindow,
+		RequireTwoFactor:  requireTwoFactor,
 	}, nil
 }
 
@@ -425,6 +430,11 @@ func (cs *ConfigService) validateConfigUpdates(updates map[string]interface{}) e
 			if mtu < 1280 || mtu > 1500 {
 				return errors.New("MTU必须在1280-1500之间")
 			}
+
+		case "security.require_2fa":
+			if _, err := strconv.ParseBool(fmt.Sprintf("%v", value)); err != nil {
+				return fmt.Errorf("配置项 %s 必须是true或false", key)
+			}
 		}
 	}
 
@@ -522,7 +532,7 @@ func (cs *ConfigService) ApplyWireGuardConfig() error {
 	}
 
 	// 写入配置文件
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", interfaceName)
+	configPath := wireguard.ConfigPath(interfaceName)
 	if err := wireguard.WriteConfigFile(configPath, config); err != nil {
 		return fmt.Errorf("写入WireGuard配置文件失败: %w", err)
 	}
@@ -597,6 +607,7 @@ func (cs *ConfigService) ResetToDefaults() error {
 		"security.enable_rate_limit":   "true",
 		"security.rate_limit_requests": "100",
 		"security.rate_limit_window":   "3600",
+		"security.require_2fa":         "false",
 	}
 
 	for key, value := range defaults {
@@ -6,6 +6,7 @@ import (
 
 	"eitec-vpn/internal/server/database"
 	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/ipaddr"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -15,15 +16,19 @@ import (
 
 // DashboardService 仪表盘服务
 type DashboardService struct {
-	db            *gorm.DB
-	moduleService *ModuleService
+	db             *gorm.DB
+	moduleService  *ModuleService
+	trafficService *TrafficService
+	auditService   *AuditService
 }
 
 // NewDashboardService 创建仪表盘服务
 func NewDashboardService(moduleService *ModuleService) *DashboardService {
 	return &DashboardService{
-		db:            database.DB,
-		moduleService: moduleService,
+		db:             database.DB,
+		moduleService:  moduleService,
+		trafficService: NewTrafficService(),
+		auditService:   NewAuditService(),
 	}
 }
 
@@ -138,7 +143,7 @@ func (ds *DashboardService) GetDashboardStats() (*DashboardStats, error) {
 	stats.SystemStats = *systemStats
 
 	// 获取最近活动
-	recentActivity, err := ds.getRecentActivity(10)
+	recentActivity, err := ds.GetRecentActivity(10)
 	if err != nil {
 		return nil, fmt.Errorf("获取最近活动失败: %w", err)
 	}
@@ -198,9 +203,17 @@ func (ds *DashboardService) getTrafficStats() (*TrafficStatsInfo, error) {
 		return nil, err
 	}
 
-	// 获取今日流量 (这里简化处理，实际应该有流量历史记录表)
-	todayRx := totalTraffic["total_rx"] // 简化处理
-	todayTx := totalTraffic["total_tx"]
+	// 获取今日流量（UTC自然日）
+	today, err := ds.trafficService.Query(&TrafficQuery{
+		From:       time.Now().UTC().Truncate(24 * time.Hour),
+		To:         time.Now(),
+		Resolution: models.TrafficResolutionDay,
+	})
+	if err != nil {
+		return nil, err
+	}
+	todayRx := today.TotalRx
+	todayTx := today.TotalTx
 
 	// 计算每个模块的平均流量
 	var moduleCount int64
@@ -269,16 +282,21 @@ func (ds *DashboardService) getSystemStats() (*SystemStatsInfo, error) {
 	}, nil
 }
 
-// getIPPoolUsage 计算IP池使用率
+// getIPPoolUsage 计算IPv4地址池使用率，IPv6网段地址数量巨大不参与计算
 func (ds *DashboardService) getIPPoolUsage() (float64, error) {
-	var usedCount int64
-	if err := ds.db.Model(&models.IPPool{}).Where("is_used = ?", true).Count(&usedCount).Error; err != nil {
+	reports, err := (&IPAMService{db: ds.db}).GetAllUtilization()
+	if err != nil {
 		return 0, err
 	}
 
-	var totalCount int64
-	if err := ds.db.Model(&models.IPPool{}).Count(&totalCount).Error; err != nil {
-		return 0, err
+	var usedCount, totalCount uint64
+	for _, report := range reports {
+		for _, network := range report.Networks {
+			if !ipaddr.IsIPv6(network.Network) {
+				usedCount += network.Allocated
+				totalCount += network.Total
+			}
+		}
 	}
 
 	if totalCount == 0 {
@@ -288,50 +306,54 @@ func (ds *DashboardService) getIPPoolUsage() (float64, error) {
 	return float64(usedCount) / float64(totalCount) * 100, nil
 }
 
-// getRecentActivity 获取最近活动
-func (ds *DashboardService) getRecentActivity(limit int) ([]ActivityInfo, error) {
-	var activities []ActivityInfo
+// GetRecentActivity 获取最近活动，来自审计日志
+func (ds *DashboardService) GetRecentActivity(limit int) ([]ActivityInfo, error) {
+	logs, err := ds.auditService.Recent(limit)
+	if err != nil {
+		return nil, err
+	}
 
-	// 简化：不再从数据库获取活动日志，可以从系统日志或其他方式获取
-	// 这里返回空切片或模拟数据
+	activities := make([]ActivityInfo, 0, len(logs))
+	for _, entry := range logs {
+		activity := ActivityInfo{
+			ID:        entry.ID,
+			Type:      entry.Action,
+			Message:   AuditMessage(&entry),
+			Timestamp: entry.CreatedAt,
+		}
+		if entry.TargetType == models.AuditTargetModule && entry.TargetID != 0 {
+			moduleID := entry.TargetID
+			activity.ModuleID = &moduleID
+		}
+		if entry.ActorID != 0 {
+			userID := entry.ActorID
+			activity.UserID = &userID
+		}
+		activities = append(activities, activity)
+	}
 
 	return activities, nil
 }
 
-// getTrafficChart 获取流量图表数据
+// getTrafficChart 获取流量图表数据，每个点为该小时内的流量
 func (ds *DashboardService) getTrafficChart(hours int) ([]TrafficDataPoint, error) {
-	var dataPoints []TrafficDataPoint
-
-	// 获取当前真实流量统计
-	totalTraffic, err := ds.moduleService.GetTotalTraffic()
+	now := time.Now()
+	history, err := ds.trafficService.Query(&TrafficQuery{
+		From:       now.Add(time.Duration(-hours) * time.Hour),
+		To:         now,
+		Resolution: models.TrafficResolutionHour,
+	})
 	if err != nil {
-		return dataPoints, fmt.Errorf("获取总流量失败: %w", err)
+		return nil, fmt.Errorf("查询流量历史失败: %w", err)
 	}
 
-	// 使用当前流量数据作为最新点
-	now := time.Now()
-	currentDataPoint := TrafficDataPoint{
-		Time: now,
-		Rx:   totalTraffic["total_rx"],
-		Tx:   totalTraffic["total_tx"],
-	}
-
-	// 如果没有历史数据表，至少返回当前流量点
-	// 在实际应用中，应该创建流量历史记录表来存储时序数据
-	for i := hours; i >= 0; i-- {
-		pointTime := now.Add(time.Duration(-i) * time.Hour)
-
-		if i == 0 {
-			// 最新的点使用真实数据
-			dataPoints = append(dataPoints, currentDataPoint)
-		} else {
-			// 历史点暂时使用零值，待实现流量历史记录功能
-			dataPoints = append(dataPoints, TrafficDataPoint{
-				Time: pointTime,
-				Rx:   0,
-				Tx:   0,
-			})
-		}
+	dataPoints := make([]TrafficDataPoint, 0, len(history.Points))
+	for _, point := range history.Points {
+		dataPoints = append(dataPoints, TrafficDataPoint{
+			Time: point.Time,
+			Rx:   point.RxBytes,
+			Tx:   point.TxBytes,
+		})
 	}
 
 	return dataPoints, nil
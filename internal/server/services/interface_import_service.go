@@ -0,0 +1,377 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/wgconf"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"gorm.io/gorm"
+)
+
+// ErrImportConflicts 导入存在冲突，未写入数据库
+var ErrImportConflicts = errors.New("导入存在冲突，未写入数据库")
+
+// InterfaceImportService 导入已有WireGuard服务端配置
+type InterfaceImportService struct {
+	db *gorm.DB
+}
+
+// NewInterfaceImportService 创建配置导入服务
+func NewInterfaceImportService() *InterfaceImportService {
+	return &InterfaceImportService{
+		db: database.DB,
+	}
+}
+
+// importPlan 待写入数据库的记录，与result.Peers一一对应
+type importPlan struct {
+	result   *models.InterfaceImportResult
+	modules  map[int]*models.Module
+	userVPNs map[int]*models.UserVPN
+}
+
+// conflict 记录一个冲突
+func (p *importPlan) conflict(line int, publicKey, format string, args ...interface{}) {
+	p.result.Conflicts = append(p.result.Conflicts, models.InterfaceImportConflict{
+		Line:      line,
+		PublicKey: publicKey,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// ImportConfigName 由配置文件路径得到接口名称，如/etc/wireguard/wg0.conf得到wg0
+func ImportConfigName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), ".conf")
+}
+
+// Import 解析服务端配置，检查冲突后创建接口、模块和用户VPN并占用IP池。
+// [Peer]段注释为“User: 用户名”的导入为用户VPN，归属第一个模块；其余按“名称 - 位置”导入为模块。
+// 存在冲突或DryRun时不写入数据库，冲突时返回ErrImportConflicts
+func (iis *InterfaceImportService) Import(req *models.InterfaceImportRequest) (*models.InterfaceImportResult, error) {
+	conf, err := wgconf.Parse(req.Config)
+	if err != nil {
+		return nil, fmt.Errorf("解析服务端配置失败: %w", err)
+	}
+
+	plan := &importPlan{
+		result:   &models.InterfaceImportResult{Peers: []models.InterfaceImportPeer{}, Conflicts: []models.InterfaceImportConflict{}},
+		modules:  make(map[int]*models.Module),
+		userVPNs: make(map[int]*models.UserVPN),
+	}
+	iis.planImport(req, conf, plan)
+
+	if len(plan.result.Conflicts) > 0 {
+		return plan.result, ErrImportConflicts
+	}
+	if req.DryRun {
+		return plan.result, nil
+	}
+
+	if err := iis.db.Transaction(func(tx *gorm.DB) error {
+		return iis.commit(tx, conf, plan)
+	}); err != nil {
+		return plan.result, fmt.Errorf("写入数据库失败: %w", err)
+	}
+	plan.result.Imported = true
+
+	return plan.result, nil
+}
+
+// planImport 生成接口和对等端记录并检查冲突
+func (iis *InterfaceImportService) planImport(req *models.InterfaceImportRequest, conf *wgconf.Config, plan *importPlan) {
+	var validation wgconf.ErrorList
+	if err := conf.Validate(); errors.As(err, &validation) {
+		for _, e := range validation {
+			plan.conflict(e.Line, "", "%s %s", e.Section, e.Msg)
+		}
+		return
+	}
+
+	wis := &WireGuardInterfaceService{db: iis.db}
+	if req.Name == "" {
+		plan.conflict(0, "", "接口名称不能为空")
+	} else if _, err := wis.GetInterfaceByName(req.Name); err == nil {
+		plan.conflict(0, "", "接口名称 %s 已存在", req.Name)
+	}
+
+	// 服务器地址和网段取第一个带前缀长度的IPv4地址
+	var serverIP net.IP
+	var network *net.IPNet
+	for _, address := range conf.Interface.Address {
+		if ip, ipNet, err := net.ParseCIDR(address); err == nil && ip.To4() != nil {
+			serverIP, network = ip, ipNet
+			break
+		}
+	}
+	if network == nil {
+		plan.conflict(conf.Interface.Line(), "", "[Interface] 缺少带前缀长度的IPv4 Address，无法确定网段")
+		return
+	}
+	if err := wis.validateNetwork(network.String()); err != nil {
+		plan.conflict(conf.Interface.Line(), "", "网段 %s: %v", network, err)
+	}
+
+	if conf.Interface.ListenPort == 0 {
+		plan.conflict(conf.Interface.Line(), "", "[Interface] 缺少ListenPort")
+	} else if err := wis.checkPortAvailable(conf.Interface.ListenPort); err != nil {
+		plan.conflict(conf.Interface.Line(), "", "端口 %d: %v", conf.Interface.ListenPort, err)
+	}
+
+	publicKey, _ := wireguard.PublicKeyFromPrivate(conf.Interface.PrivateKey)
+	wgInterface := &models.WireGuardInterface{
+		Name:        req.Name,
+		Description: req.Description,
+		Network:     network.String(),
+		ServerIP:    serverIP.String(),
+		ListenPort:  conf.Interface.ListenPort,
+		PublicKey:   publicKey,
+		PrivateKey:  conf.Interface.PrivateKey,
+		Status:      models.InterfaceStatusDown,
+		MaxPeers:    100,
+		DNS:         strings.Join(conf.Interface.DNS, ","),
+		MTU:         conf.Interface.MTU,
+		PostUp:      strings.Join(conf.Interface.PostUp, "; "),
+		PostDown:    strings.Join(conf.Interface.PostDown, "; "),
+		PreUp:       strings.Join(conf.Interface.PreUp, "; "),
+		PreDown:     strings.Join(conf.Interface.PreDown, "; "),
+		SaveConfig:  conf.Interface.SaveConfig,
+	}
+	if len(conf.Peers) > wgInterface.MaxPeers {
+		wgInterface.MaxPeers = len(conf.Peers)
+	}
+	// 已在运行的接口直接标记为运行中，后续变更通过热同步生效
+	if wireguard.GetBackend().DeviceExists(req.Name) {
+		wgInterface.Status = models.InterfaceStatusUp
+	}
+	plan.result.Interface = wgInterface
+
+	clients := iis.parsePeerConfigs(req.PeerConfigs, conf, plan)
+
+	usedIPs := map[string]string{serverIP.String(): "服务器地址"}
+	usernames := make(map[string]bool)
+	hasModule := false
+
+	for i := range conf.Peers {
+		peer := &conf.Peers[i]
+
+		ip, allowedIPs := splitPeerAllowedIPs(peer.AllowedIPs, network)
+		if ip == "" {
+			plan.conflict(peer.Line(), peer.PublicKey, "AllowedIPs中没有网段 %s 内的/32地址: %s", network, strings.Join(peer.AllowedIPs, ", "))
+			continue
+		}
+		if owner, exists := usedIPs[ip]; exists {
+			plan.conflict(peer.Line(), peer.PublicKey, "IP地址 %s 与%s冲突", ip, owner)
+			continue
+		}
+		usedIPs[ip] = "对等端 " + peer.PublicKey
+		iis.checkExisting(peer, ip, plan)
+
+		entry := models.InterfaceImportPeer{
+			PublicKey: peer.PublicKey,
+			IPAddress: ip,
+		}
+		name := ""
+		for _, comment := range peer.Comments {
+			if name = strings.TrimSpace(comment); name != "" {
+				break
+			}
+		}
+
+		client := clients[peer.PublicKey]
+		privateKey := ""
+		if client != nil {
+			privateKey = client.Interface.PrivateKey
+			entry.HasPrivate = true
+		}
+
+		if username, isUser := strings.CutPrefix(name, "User:"); isUser {
+			entry.Kind = models.ImportPeerUser
+			entry.Name = strings.TrimSpace(username)
+			if entry.Name == "" {
+				entry.Name = "user-" + ip
+			}
+			if usernames[entry.Name] {
+				plan.conflict(peer.Line(), peer.PublicKey, "用户名 %s 重复", entry.Name)
+			}
+			usernames[entry.Name] = true
+
+			// 用户可访问网段以客户端配置为准，否则使用VPN网段
+			entry.AllowedIPs = network.String()
+			keepalive := peer.PersistentKeepalive
+			if client != nil && len(client.Peers) > 0 {
+				if len(client.Peers[0].AllowedIPs) > 0 {
+					entry.AllowedIPs = strings.Join(client.Peers[0].AllowedIPs, ", ")
+				}
+				keepalive = client.Peers[0].PersistentKeepalive
+			}
+
+			plan.userVPNs[len(plan.result.Peers)] = &models.UserVPN{
+				Username:     entry.Name,
+				Description:  "导入自 " + req.Name,
+				PublicKey:    peer.PublicKey,
+				PrivateKey:   privateKey,
+				PresharedKey: peer.PresharedKey,
+				IPAddress:    ip,
+				Status:       models.UserVPNStatusOffline,
+				AllowedIPs:   entry.AllowedIPs,
+				PersistentKA: keepalive,
+				IsActive:     true,
+				MaxDevices:   1,
+			}
+		} else {
+			hasModule = true
+			entry.Kind = models.ImportPeerModule
+			entry.Name, entry.Location, _ = strings.Cut(name, " - ")
+			entry.Name = strings.TrimSpace(entry.Name)
+			entry.Location = strings.TrimSpace(entry.Location)
+			if entry.Name == "" {
+				entry.Name = "module-" + ip
+			}
+			entry.AllowedIPs = strings.Join(allowedIPs, ",")
+
+			plan.modules[len(plan.result.Peers)] = &models.Module{
+				Name:         entry.Name,
+				Location:     entry.Location,
+				Description:  "导入自 " + req.Name,
+				PublicKey:    peer.PublicKey,
+				PrivateKey:   privateKey,
+				IPAddress:    ip,
+				LocalIP:      (&ModuleService{}).inferLocalIPFromAllowedIPs(entry.AllowedIPs),
+				Status:       models.ModuleStatusOffline,
+				AllowedIPs:   entry.AllowedIPs,
+				PersistentKA: peer.PersistentKeepalive,
+				PresharedKey: peer.PresharedKey,
+				Endpoint:     peer.Endpoint,
+			}
+		}
+
+		plan.result.Peers = append(plan.result.Peers, entry)
+	}
+
+	if len(plan.userVPNs) > 0 && !hasModule {
+		plan.conflict(0, "", "配置中没有模块对等端，用户VPN无法归属到模块")
+	}
+}
+
+// parsePeerConfigs 解析客户端配置，按公钥索引
+func (iis *InterfaceImportService) parsePeerConfigs(contents []string, conf *wgconf.Config, plan *importPlan) map[string]*wgconf.Config {
+	clients := make(map[string]*wgconf.Config)
+	for i, content := range contents {
+		client, err := wgconf.Parse(content)
+		if err != nil {
+			plan.conflict(0, "", "第%d个客户端配置解析失败: %v", i+1, err)
+			continue
+		}
+		publicKey, err := wireguard.PublicKeyFromPrivate(client.Interface.PrivateKey)
+		if err != nil {
+			plan.conflict(client.Interface.Line(), "", "第%d个客户端配置缺少有效的PrivateKey", i+1)
+			continue
+		}
+		if conf.FindPeer(publicKey) == nil {
+			plan.conflict(0, publicKey, "第%d个客户端配置的公钥不在服务端配置中", i+1)
+			continue
+		}
+		clients[publicKey] = client
+	}
+	return clients
+}
+
+// checkExisting 检查公钥和IP是否已被数据库中的模块、用户VPN或设备使用
+func (iis *InterfaceImportService) checkExisting(peer *wgconf.Peer, ip string, plan *importPlan) {
+	var module models.Module
+	if err := iis.db.Where("public_key = ? OR ip_address = ?", peer.PublicKey, ip).First(&module).Error; err == nil {
+		plan.conflict(peer.Line(), peer.PublicKey, "公钥或IP地址 %s 已被模块 %s 使用", ip, module.Name)
+	}
+
+	var userVPN models.UserVPN
+	if err := iis.db.Where("public_key = ? OR ip_address = ?", peer.PublicKey, ip).First(&userVPN).Error; err == nil {
+		plan.conflict(peer.Line(), peer.PublicKey, "公钥或IP地址 %s 已被用户 %s 使用", ip, userVPN.Username)
+	}
+
+	var device models.UserVPNDevice
+	if err := iis.db.Where("public_key = ? OR ip_address = ?", peer.PublicKey, ip).First(&device).Error; err == nil {
+		plan.conflict(peer.Line(), peer.PublicKey, "公钥或IP地址 %s 已被用户设备 %s 使用", ip, device.Name)
+	}
+}
+
+// splitPeerAllowedIPs 从AllowedIPs中取出网段内的/32地址作为对等端IP，其余作为内网网段
+func splitPeerAllowedIPs(allowedIPs []string, network *net.IPNet) (string, []string) {
+	ip := ""
+	var rest []string
+	for _, allowedIP := range allowedIPs {
+		host := strings.TrimSuffix(allowedIP, "/32")
+		if parsed := net.ParseIP(host); ip == "" && parsed != nil && parsed.To4() != nil && network.Contains(parsed) {
+			ip = parsed.String()
+			continue
+		}
+		rest = append(rest, allowedIP)
+	}
+	return ip, rest
+}
+
+// commit 在事务中写入接口、模块和用户VPN并占用其地址
+func (iis *InterfaceImportService) commit(tx *gorm.DB, conf *wgconf.Config, plan *importPlan) error {
+	wgInterface := plan.result.Interface
+	if err := tx.Create(wgInterface).Error; err != nil {
+		return fmt.Errorf("创建接口失败: %w", err)
+	}
+	// 零值会被数据库默认值覆盖，需单独写回
+	if !conf.Interface.SaveConfig {
+		if err := tx.Model(wgInterface).Update("save_config", false).Error; err != nil {
+			return fmt.Errorf("更新接口失败: %w", err)
+		}
+	}
+
+	ipam := &IPAMService{db: tx}
+	if err := ipam.ClaimGateway(wgInterface); err != nil {
+		return err
+	}
+	var owner *models.Module
+	for i := range plan.result.Peers {
+		module, exists := plan.modules[i]
+		if !exists {
+			continue
+		}
+		module.InterfaceID = wgInterface.ID
+		if err := tx.Create(module).Error; err != nil {
+			return fmt.Errorf("创建模块 %s 失败: %w", module.Name, err)
+		}
+		if err := ipam.Claim(wgInterface, module.IPAddress); err != nil {
+			return err
+		}
+		if err := ipam.Assign(wgInterface.ID, ModuleOwner(module.ID), module.IPAddress); err != nil {
+			return err
+		}
+		if owner == nil {
+			owner = module
+		}
+		plan.result.Peers[i].ID = module.ID
+	}
+
+	for i := range plan.result.Peers {
+		userVPN, exists := plan.userVPNs[i]
+		if !exists {
+			continue
+		}
+		userVPN.ModuleID = owner.ID
+		if err := tx.Create(userVPN).Error; err != nil {
+			return fmt.Errorf("创建用户VPN %s 失败: %w", userVPN.Username, err)
+		}
+		if err := ipam.Claim(wgInterface, userVPN.IPAddress); err != nil {
+			return err
+		}
+		if err := ipam.Assign(wgInterface.ID, UserVPNOwner(userVPN.ID), userVPN.IPAddress); err != nil {
+			return err
+		}
+		plan.result.Peers[i].ID = userVPN.ID
+	}
+
+	return nil
+}
@@ -0,0 +1,934 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/ipaddr"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ipamAllocateAttempts 分配地址时遇到并发冲突的重试次数
+const ipamAllocateAttempts = 5
+
+// IPAMService IP地址管理：根据网段、已分配地址和保留范围计算空闲地址，不预先生成地址池。
+// 在事务中使用时以事务创建：&IPAMService{db: tx}
+type IPAMService struct {
+	db *gorm.DB
+}
+
+// IPOwner 地址持有者，类型见models.IPOwner*
+type IPOwner struct {
+	Type string
+	ID   uint
+}
+
+// ModuleOwner 模块持有地址
+func ModuleOwner(moduleID uint) IPOwner {
+	return IPOwner{Type: models.IPOwnerModule, ID: moduleID}
+}
+
+// UserVPNOwner 用户VPN持有地址
+func UserVPNOwner(userVPNID uint) IPOwner {
+	return IPOwner{Type: models.IPOwnerUserVPN, ID: userVPNID}
+}
+
+// UserDeviceOwner 用户VPN的附加设备持有地址
+func UserDeviceOwner(deviceID uint) IPOwner {
+	return IPOwner{Type: models.IPOwnerUserDevice, ID: deviceID}
+}
+
+// IPAllocationInfo 地址分配记录及持有者名称
+type IPAllocationInfo struct {
+	models.IPAllocation
+	OwnerName string `json:"owner_name,omitempty"`
+}
+
+// IP地址一致性问题类型
+const (
+	IPIssueOrphaned  = "orphaned"  // 分配记录的持有者不存在或已不使用该地址
+	IPIssueUntracked = "untracked" // 持有者使用的地址没有分配记录
+	IPIssueConflict  = "conflict"  // 地址已被其他持有者使用或被手动占用
+	IPIssueInvalid   = "invalid"   // 持有者的地址不在接口网段内或不可分配
+)
+
+// IPIssue 一致性检查发现的问题
+type IPIssue struct {
+	Kind       string `json:"kind"`
+	IPAddress  string `json:"ip_address"`
+	OwnerType  string `json:"owner_type"`
+	OwnerID    uint   `json:"owner_id"`
+	OwnerName  string `json:"owner_name,omitempty"`
+	Message    string `json:"message"`
+	NewAddress string `json:"new_address,omitempty"` // 修复时重新分配的地址
+}
+
+// IPConsistencyReport 接口地址一致性检查结果
+type IPConsistencyReport struct {
+	InterfaceID uint      `json:"interface_id"`
+	Interface   string    `json:"interface"`
+	Issues      []IPIssue `json:"issues"`
+	Repaired    bool      `json:"repaired"`
+}
+
+// ipHolder 实际使用地址的网关、模块或用户VPN
+type ipHolder struct {
+	owner   IPOwner
+	name    string
+	address string
+	column  string // 记录地址的字段，ip_address或ip_address6
+}
+
+// ipIssue 一致性问题及修复所需的信息
+type ipIssue struct {
+	IPIssue
+	holder       *ipHolder
+	allocationID uint
+}
+
+// NetworkUtilization 单个网段的地址使用情况
+type NetworkUtilization struct {
+	Network   string  `json:"network"`
+	Total     uint64  `json:"total"`     // 可分配地址数，不含网络地址、广播地址和服务器地址；超过2^64时取上限
+	Allocated uint64  `json:"allocated"` // 已分配地址数
+	Reserved  uint64  `json:"reserved"`  // 保留范围内尚未分配的地址数
+	Free      uint64  `json:"free"`      // 可自动分配的空闲地址数
+	Usage     float64 `json:"usage"`     // 已分配占比（0-1）
+}
+
+// IPUtilization 接口的地址使用情况
+type IPUtilization struct {
+	InterfaceID  uint                   `json:"interface_id"`
+	Interface    string                 `json:"interface"`
+	Networks     []NetworkUtilization   `json:"networks"`
+	Reservations []models.IPReservation `json:"reservations"`
+}
+
+// addressSpan 网段内的地址序号区间，两端都包含
+type addressSpan struct {
+	start, end uint64
+}
+
+// NewIPAMService 创建IP地址管理服务
+func NewIPAMService() *IPAMService {
+	return &IPAMService{
+		db: database.DB,
+	}
+}
+
+// Allocate 在接口的指定网段内分配第一个空闲地址，跳过服务器地址、已分配地址和保留范围。
+// 分配在事务中完成，并发分配到同一地址时由唯一索引拦截后重试
+func (s *IPAMService) Allocate(wgInterface *models.WireGuardInterface, network string) (string, error) {
+	ipNet, err := s.interfaceNetwork(wgInterface, network)
+	if err != nil {
+		return "", err
+	}
+
+	var address string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := takenSpans(tx, wgInterface, ipNet, true)
+		if err != nil {
+			return err
+		}
+		first, last, ok := usableRange(ipNet)
+		if !ok {
+			return fmt.Errorf("网段 %s 没有可分配的地址", network)
+		}
+
+		for attempt := 0; attempt < ipamAllocateAttempts; attempt++ {
+			offset, ok := firstFree(first, last, taken)
+			if !ok {
+				return fmt.Errorf("接口 %s 的网段 %s 没有可用的IP地址", wgInterface.Name, network)
+			}
+			candidate := ipaddr.Nth(ipNet, offset).String()
+			created, err := insertAllocation(tx, &models.IPAllocation{
+				InterfaceID: wgInterface.ID,
+				Network:     network,
+				IPAddress:   candidate,
+			})
+			if err != nil {
+				return err
+			}
+			if created {
+				address = candidate
+				return nil
+			}
+			// 地址刚被其他请求占用
+			taken = append(taken, addressSpan{offset, offset})
+		}
+		return fmt.Errorf("接口 %s 分配IP地址冲突，请重试", wgInterface.Name)
+	})
+	return address, err
+}
+
+// Claim 占用指定地址，用于手动指定地址和导入已有配置。保留范围内的地址可以显式占用
+func (s *IPAMService) Claim(wgInterface *models.WireGuardInterface, address string) error {
+	_, err := s.claim(wgInterface, address, IPOwner{}, "")
+	return err
+}
+
+// Reserve 手动占用地址，用于不受本系统管理的设备
+func (s *IPAMService) Reserve(wgInterface *models.WireGuardInterface, address, description string) (*models.IPAllocation, error) {
+	return s.claim(wgInterface, address, IPOwner{Type: models.IPOwnerReserved}, description)
+}
+
+// ClaimGateway 记录接口的服务器地址
+func (s *IPAMService) ClaimGateway(wgInterface *models.WireGuardInterface) error {
+	for _, address := range nonEmpty([]string{wgInterface.ServerIP, wgInterface.ServerIP6}) {
+		created, err := insertAllocation(s.db, &models.IPAllocation{
+			InterfaceID: wgInterface.ID,
+			Network:     addressNetwork(wgInterface, address),
+			IPAddress:   address,
+			OwnerType:   models.IPOwnerGateway,
+			OwnerID:     wgInterface.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if !created {
+			return fmt.Errorf("服务器地址 %s 已被使用", address)
+		}
+	}
+	return nil
+}
+
+// claim 按持有者占用指定地址
+func (s *IPAMService) claim(wgInterface *models.WireGuardInterface, address string, owner IPOwner, description string) (*models.IPAllocation, error) {
+	network := addressNetwork(wgInterface, address)
+	ipNet, err := s.interfaceNetwork(wgInterface, network)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(address)
+	offset, ok := ipaddr.Offset(ipNet, ip)
+	if ip == nil || !ok {
+		return nil, fmt.Errorf("IP地址 %s 不在接口网段 %s 内", address, network)
+	}
+	if first, last, ok := usableRange(ipNet); !ok || offset < first || offset > last {
+		return nil, fmt.Errorf("IP地址 %s 是网段 %s 的网络地址或广播地址", address, network)
+	}
+	if ip.Equal(net.ParseIP(wgInterface.ServerIP)) || ip.Equal(net.ParseIP(wgInterface.ServerIP6)) {
+		return nil, fmt.Errorf("IP地址 %s 是接口的服务器地址", address)
+	}
+
+	allocation := &models.IPAllocation{
+		InterfaceID: wgInterface.ID,
+		Network:     network,
+		IPAddress:   ip.String(),
+		OwnerType:   owner.Type,
+		OwnerID:     owner.ID,
+		Description: description,
+	}
+	created, err := insertAllocation(s.db, allocation)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, fmt.Errorf("IP地址 %s 已被使用", address)
+	}
+	return allocation, nil
+}
+
+// Assign 把已分配的地址关联到持有者
+func (s *IPAMService) Assign(interfaceID uint, owner IPOwner, addresses ...string) error {
+	addresses = nonEmpty(addresses)
+	if len(addresses) == 0 {
+		return nil
+	}
+	if err := s.db.Model(&models.IPAllocation{}).
+		Where("interface_id = ? AND ip_address IN ?", interfaceID, addresses).
+		Updates(map[string]interface{}{"owner_type": owner.Type, "owner_id": owner.ID}).Error; err != nil {
+		return fmt.Errorf("关联IP地址失败: %w", err)
+	}
+	return nil
+}
+
+// Release 释放持有者在接口下的全部地址
+func (s *IPAMService) Release(interfaceID uint, owners ...IPOwner) error {
+	for _, owner := range owners {
+		if err := s.db.Where("interface_id = ? AND owner_type = ? AND owner_id = ?", interfaceID, owner.Type, owner.ID).
+			Delete(&models.IPAllocation{}).Error; err != nil {
+			return fmt.Errorf("释放IP地址失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReleaseReserved 释放手动占用的地址，其他持有者的地址随持有者删除时释放
+func (s *IPAMService) ReleaseReserved(interfaceID, id uint) error {
+	result := s.db.Where("interface_id = ? AND owner_type = ?", interfaceID, models.IPOwnerReserved).
+		Delete(&models.IPAllocation{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("释放IP地址失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("手动占用的地址不存在")
+	}
+	return nil
+}
+
+// GetAllocations 获取接口的全部地址分配记录及持有者，按地址排序
+func (s *IPAMService) GetAllocations(wgInterface *models.WireGuardInterface) ([]IPAllocationInfo, error) {
+	var allocations []models.IPAllocation
+	if err := s.db.Where("interface_id = ?", wgInterface.ID).Find(&allocations).Error; err != nil {
+		return nil, fmt.Errorf("查询地址分配记录失败: %w", err)
+	}
+	holders, err := collectHolders(s.db, wgInterface)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[IPOwner]string, len(holders))
+	for _, holder := range holders {
+		names[holder.owner] = holder.name
+	}
+
+	result := make([]IPAllocationInfo, 0, len(allocations))
+	for _, allocation := range allocations {
+		info := IPAllocationInfo{IPAllocation: allocation, OwnerName: names[IPOwner{allocation.OwnerType, allocation.OwnerID}]}
+		if allocation.OwnerType == models.IPOwnerReserved {
+			info.OwnerName = allocation.Description
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(result[i].IPAddress).To16(), net.ParseIP(result[j].IPAddress).To16()) < 0
+	})
+	return result, nil
+}
+
+// CheckConsistency 检查接口的地址分配记录与网关、模块和用户VPN实际使用的地址是否一致
+func (s *IPAMService) CheckConsistency(wgInterface *models.WireGuardInterface) (*IPConsistencyReport, error) {
+	issues, err := findIssues(s.db, wgInterface)
+	if err != nil {
+		return nil, err
+	}
+	return consistencyReport(wgInterface, issues, false), nil
+}
+
+// RepairConsistency 修复一致性问题：删除孤立记录，补齐缺失记录，
+// 冲突或无效地址的模块和用户VPN重新分配地址，需重新下发其配置
+func (s *IPAMService) RepairConsistency(wgInterface *models.WireGuardInterface) (*IPConsistencyReport, error) {
+	var issues []ipIssue
+	renumbered := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if issues, err = findIssues(tx, wgInterface); err != nil {
+			return err
+		}
+		ipam := &IPAMService{db: tx}
+
+		// 先删除孤立记录、补齐缺失记录，重新分配时不会分到仍在使用的地址
+		for _, issue := range issues {
+			if issue.Kind == IPIssueOrphaned {
+				if err := tx.Delete(&models.IPAllocation{}, issue.allocationID).Error; err != nil {
+					return fmt.Errorf("删除孤立地址记录失败: %w", err)
+				}
+			}
+		}
+		for _, issue := range issues {
+			if issue.Kind != IPIssueUntracked {
+				continue
+			}
+			allocation := &models.IPAllocation{
+				InterfaceID: wgInterface.ID,
+				Network:     addressNetwork(wgInterface, issue.IPAddress),
+				IPAddress:   issue.IPAddress,
+				OwnerType:   issue.OwnerType,
+				OwnerID:     issue.OwnerID,
+			}
+			if _, err := insertAllocation(tx, allocation); err != nil {
+				return err
+			}
+		}
+
+		for i := range issues {
+			issue := &issues[i]
+			if (issue.Kind != IPIssueConflict && issue.Kind != IPIssueInvalid) || issue.OwnerType == models.IPOwnerGateway {
+				continue
+			}
+			network := wgInterface.Network
+			if issue.holder.column == "ip_address6" {
+				network = wgInterface.Network6
+			}
+			// 接口已不再启用IPv6时清空IPv6地址
+			if network != "" {
+				if issue.NewAddress, err = ipam.Allocate(wgInterface, network); err != nil {
+					return err
+				}
+				if err := ipam.Assign(wgInterface.ID, issue.holder.owner, issue.NewAddress); err != nil {
+					return err
+				}
+			}
+			if err := updateHolderAddress(tx, issue.holder, issue.NewAddress); err != nil {
+				return err
+			}
+			renumbered = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("修复地址分配失败: %w", err)
+	}
+
+	if renumbered {
+		if err := NewModuleService().updateInterfaceConfig(wgInterface.ID); err != nil {
+			fmt.Printf("⚠️ 更新接口配置失败: %v\n", err)
+		}
+	}
+	return consistencyReport(wgInterface, issues, true), nil
+}
+
+// ReleaseInterface 释放接口的全部地址和保留范围
+func (s *IPAMService) ReleaseInterface(interfaceID uint) error {
+	if err := s.db.Where("interface_id = ?", interfaceID).Delete(&models.IPAllocation{}).Error; err != nil {
+		return fmt.Errorf("释放接口地址失败: %w", err)
+	}
+	if err := s.db.Where("interface_id = ?", interfaceID).Delete(&models.IPReservation{}).Error; err != nil {
+		return fmt.Errorf("删除保留地址失败: %w", err)
+	}
+	return nil
+}
+
+// GetReservations 获取接口的保留范围
+func (s *IPAMService) GetReservations(interfaceID uint) ([]models.IPReservation, error) {
+	var reservations []models.IPReservation
+	if err := s.db.Where("interface_id = ?", interfaceID).Order("id").Find(&reservations).Error; err != nil {
+		return nil, fmt.Errorf("查询保留地址失败: %w", err)
+	}
+	return reservations, nil
+}
+
+// CreateReservation 添加保留范围，结束地址为空时为单个地址的静态保留。
+// 范围必须在接口的同一网段内且不能与已有保留范围重叠，范围内已分配的地址不受影响
+func (s *IPAMService) CreateReservation(wgInterface *models.WireGuardInterface, startIP, endIP, description string) (*models.IPReservation, error) {
+	if endIP == "" {
+		endIP = startIP
+	}
+	start, end := net.ParseIP(startIP), net.ParseIP(endIP)
+	if start == nil || end == nil {
+		return nil, errors.New("无效的IP地址")
+	}
+
+	network := addressNetwork(wgInterface, startIP)
+	ipNet, err := s.interfaceNetwork(wgInterface, network)
+	if err != nil {
+		return nil, err
+	}
+	first, ok1 := ipaddr.Offset(ipNet, start)
+	last, ok2 := ipaddr.Offset(ipNet, end)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("保留范围必须在接口网段 %s 内", network)
+	}
+	if first > last {
+		return nil, errors.New("起始地址不能大于结束地址")
+	}
+
+	existing, err := s.GetReservations(wgInterface.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range existing {
+		span, ok := reservationSpan(ipNet, &reservation)
+		if ok && span.start <= last && first <= span.end {
+			return nil, fmt.Errorf("与已有保留范围 %s-%s 重叠", reservation.StartIP, reservation.EndIP)
+		}
+	}
+
+	reservation := &models.IPReservation{
+		InterfaceID: wgInterface.ID,
+		StartIP:     start.String(),
+		EndIP:       end.String(),
+		Description: description,
+	}
+	if err := s.db.Create(reservation).Error; err != nil {
+		return nil, fmt.Errorf("创建保留地址失败: %w", err)
+	}
+	return reservation, nil
+}
+
+// DeleteReservation 删除保留范围
+func (s *IPAMService) DeleteReservation(interfaceID, id uint) error {
+	result := s.db.Where("interface_id = ?", interfaceID).Delete(&models.IPReservation{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("删除保留地址失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("保留地址不存在")
+	}
+	return nil
+}
+
+// GetUtilization 统计接口各网段的地址使用情况
+func (s *IPAMService) GetUtilization(wgInterface *models.WireGuardInterface) (*IPUtilization, error) {
+	reservations, err := s.GetReservations(wgInterface.ID)
+	if err != nil {
+		return nil, err
+	}
+	report := &IPUtilization{
+		InterfaceID:  wgInterface.ID,
+		Interface:    wgInterface.Name,
+		Networks:     []NetworkUtilization{},
+		Reservations: reservations,
+	}
+
+	networks := []string{wgInterface.Network}
+	if wgInterface.DualStack() {
+		networks = append(networks, wgInterface.Network6)
+	}
+	for _, network := range networks {
+		usage, err := s.networkUtilization(wgInterface, network, reservations)
+		if err != nil {
+			return nil, err
+		}
+		report.Networks = append(report.Networks, *usage)
+	}
+	return report, nil
+}
+
+// GetAllUtilization 统计所有接口的地址使用情况
+func (s *IPAMService) GetAllUtilization() ([]IPUtilization, error) {
+	var interfaces []models.WireGuardInterface
+	if err := s.db.Order("id").Find(&interfaces).Error; err != nil {
+		return nil, fmt.Errorf("查询接口列表失败: %w", err)
+	}
+
+	reports := make([]IPUtilization, 0, len(interfaces))
+	for i := range interfaces {
+		report, err := s.GetUtilization(&interfaces[i])
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// networkUtilization 统计单个网段的地址使用情况
+func (s *IPAMService) networkUtilization(wgInterface *models.WireGuardInterface, network string, reservations []models.IPReservation) (*NetworkUtilization, error) {
+	usage := &NetworkUtilization{Network: network}
+	ipNet, err := s.interfaceNetwork(wgInterface, network)
+	if err != nil {
+		return nil, err
+	}
+	first, last, ok := usableRange(ipNet)
+	if !ok {
+		return usage, nil
+	}
+
+	usage.Total = spanSize(addressSpan{first, last})
+	serverOffset, hasServer := ipaddr.Offset(ipNet, net.ParseIP(serverAddress(wgInterface, network)))
+	hasServer = hasServer && serverOffset >= first && serverOffset <= last
+	if hasServer {
+		usage.Total--
+	}
+
+	var addresses []string
+	if err := s.db.Model(&models.IPAllocation{}).
+		Where("interface_id = ? AND network = ? AND owner_type <> ?", wgInterface.ID, network, models.IPOwnerGateway).
+		Pluck("ip_address", &addresses).Error; err != nil {
+		return nil, fmt.Errorf("查询已分配地址失败: %w", err)
+	}
+	usage.Allocated = uint64(len(addresses))
+
+	var reserved []addressSpan
+	for i := range reservations {
+		if span, ok := reservationSpan(ipNet, &reservations[i]); ok {
+			reserved = append(reserved, clampSpan(span, first, last)...)
+		}
+	}
+	for _, span := range reserved {
+		usage.Reserved += spanSize(span)
+	}
+	if hasServer && inSpans(serverOffset, reserved) {
+		usage.Reserved--
+	}
+	// 保留范围内已分配的地址计入已分配
+	for _, address := range addresses {
+		if offset, ok := ipaddr.Offset(ipNet, net.ParseIP(address)); ok && inSpans(offset, reserved) {
+			usage.Reserved--
+		}
+	}
+
+	if used := usage.Allocated + usage.Reserved; used < usage.Total {
+		usage.Free = usage.Total - used
+	}
+	if usage.Total > 0 {
+		usage.Usage = float64(usage.Allocated) / float64(usage.Total)
+	}
+	return usage, nil
+}
+
+// interfaceNetwork 解析接口的网段，network必须是接口的IPv4或IPv6网段
+func (s *IPAMService) interfaceNetwork(wgInterface *models.WireGuardInterface, network string) (*net.IPNet, error) {
+	if network == "" {
+		return nil, fmt.Errorf("接口 %s 未启用IPv6", wgInterface.Name)
+	}
+	if network != wgInterface.Network && network != wgInterface.Network6 {
+		return nil, fmt.Errorf("网段 %s 不属于接口 %s", network, wgInterface.Name)
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("无效的网段 %s: %w", network, err)
+	}
+	return ipNet, nil
+}
+
+// takenSpans 网段内不能自动分配的地址：服务器地址、已分配地址，以及withReserved时的保留范围
+func takenSpans(db *gorm.DB, wgInterface *models.WireGuardInterface, ipNet *net.IPNet, withReserved bool) ([]addressSpan, error) {
+	var taken []addressSpan
+	add := func(address string) {
+		if offset, ok := ipaddr.Offset(ipNet, net.ParseIP(address)); ok {
+			taken = append(taken, addressSpan{offset, offset})
+		}
+	}
+
+	network := ipNet.String()
+	add(serverAddress(wgInterface, network))
+
+	var addresses []string
+	if err := db.Model(&models.IPAllocation{}).
+		Where("interface_id = ?", wgInterface.ID).
+		Pluck("ip_address", &addresses).Error; err != nil {
+		return nil, fmt.Errorf("查询已分配地址失败: %w", err)
+	}
+	for _, address := range addresses {
+		add(address)
+	}
+
+	if withReserved {
+		var reservations []models.IPReservation
+		if err := db.Where("interface_id = ?", wgInterface.ID).Find(&reservations).Error; err != nil {
+			return nil, fmt.Errorf("查询保留地址失败: %w", err)
+		}
+		for i := range reservations {
+			if span, ok := reservationSpan(ipNet, &reservations[i]); ok {
+				taken = append(taken, span)
+			}
+		}
+	}
+	return taken, nil
+}
+
+// insertAllocation 写入分配记录，地址已被占用时返回false
+func insertAllocation(db *gorm.DB, allocation *models.IPAllocation) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(allocation)
+	if result.Error != nil {
+		return false, fmt.Errorf("分配IP地址失败: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// findIssues 对比分配记录和实际使用的地址。同一地址有多个使用者时，
+// 保留分配记录中的持有者，没有有效记录时保留最先出现的使用者（网关优先）
+func findIssues(db *gorm.DB, wgInterface *models.WireGuardInterface) ([]ipIssue, error) {
+	var allocations []models.IPAllocation
+	if err := db.Where("interface_id = ?", wgInterface.ID).Order("id").Find(&allocations).Error; err != nil {
+		return nil, fmt.Errorf("查询地址分配记录失败: %w", err)
+	}
+	holders, err := collectHolders(db, wgInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	allocationByAddress := make(map[string]*models.IPAllocation, len(allocations))
+	for i := range allocations {
+		allocationByAddress[allocations[i].IPAddress] = &allocations[i]
+	}
+
+	var issues []ipIssue
+	addIssue := func(kind string, holder *ipHolder, message string) {
+		issues = append(issues, ipIssue{
+			IPIssue: IPIssue{
+				Kind:      kind,
+				IPAddress: holder.address,
+				OwnerType: holder.owner.Type,
+				OwnerID:   holder.owner.ID,
+				OwnerName: holder.name,
+				Message:   message,
+			},
+			holder: holder,
+		})
+	}
+
+	var addresses []string
+	groups := make(map[string][]*ipHolder)
+	for i := range holders {
+		holder := &holders[i]
+		if !holderAddressValid(wgInterface, holder) {
+			addIssue(IPIssueInvalid, holder, fmt.Sprintf("地址 %s 不在接口网段内或不可分配", holder.address))
+			continue
+		}
+		if _, ok := groups[holder.address]; !ok {
+			addresses = append(addresses, holder.address)
+		}
+		groups[holder.address] = append(groups[holder.address], holder)
+	}
+
+	matched := make(map[uint]bool)
+	for _, address := range addresses {
+		group := groups[address]
+		allocation := allocationByAddress[address]
+		keeper := -1
+		if allocation != nil {
+			matched[allocation.ID] = allocation.OwnerType == models.IPOwnerReserved
+			for i, holder := range group {
+				if holder.owner == (IPOwner{allocation.OwnerType, allocation.OwnerID}) {
+					keeper = i
+					matched[allocation.ID] = true
+				}
+			}
+		}
+		if keeper < 0 && (allocation == nil || allocation.OwnerType != models.IPOwnerReserved) {
+			keeper = 0
+			addIssue(IPIssueUntracked, group[0], fmt.Sprintf("地址 %s 没有对应的分配记录", address))
+		}
+		for i, holder := range group {
+			if i == keeper {
+				continue
+			}
+			owner := "手动占用"
+			if keeper >= 0 {
+				owner = group[keeper].name
+			}
+			addIssue(IPIssueConflict, holder, fmt.Sprintf("地址 %s 已被 %s 使用", address, owner))
+		}
+	}
+
+	for i := range allocations {
+		allocation := &allocations[i]
+		if matched[allocation.ID] || (allocation.OwnerType == models.IPOwnerReserved && len(groups[allocation.IPAddress]) == 0) {
+			continue
+		}
+		issues = append(issues, ipIssue{
+			IPIssue: IPIssue{
+				Kind:      IPIssueOrphaned,
+				IPAddress: allocation.IPAddress,
+				OwnerType: allocation.OwnerType,
+				OwnerID:   allocation.OwnerID,
+				Message:   "分配记录的持有者不存在或已不使用该地址",
+			},
+			allocationID: allocation.ID,
+		})
+	}
+	return issues, nil
+}
+
+// collectHolders 收集接口下实际使用地址的网关、模块、用户VPN和附加设备，网关在前，其余各自按ID排序
+func collectHolders(db *gorm.DB, wgInterface *models.WireGuardInterface) ([]ipHolder, error) {
+	gateway := IPOwner{Type: models.IPOwnerGateway, ID: wgInterface.ID}
+	var holders []ipHolder
+	add := func(owner IPOwner, name, address, address6 string) {
+		if address != "" {
+			holders = append(holders, ipHolder{owner: owner, name: name, address: canonicalIP(address), column: "ip_address"})
+		}
+		if address6 != "" {
+			holders = append(holders, ipHolder{owner: owner, name: name, address: canonicalIP(address6), column: "ip_address6"})
+		}
+	}
+	add(gateway, wgInterface.Name, wgInterface.ServerIP, wgInterface.ServerIP6)
+
+	var modules []models.Module
+	if err := db.Where("interface_id = ?", wgInterface.ID).Order("id").Find(&modules).Error; err != nil {
+		return nil, fmt.Errorf("查询模块失败: %w", err)
+	}
+	moduleIDs := make([]uint, 0, len(modules))
+	for _, module := range modules {
+		moduleIDs = append(moduleIDs, module.ID)
+		add(ModuleOwner(module.ID), module.Name, module.IPAddress, module.IPAddress6)
+	}
+
+	if len(moduleIDs) > 0 {
+		var userVPNs []models.UserVPN
+		if err := db.Where("module_id IN ?", moduleIDs).Order("id").Find(&userVPNs).Error; err != nil {
+			return nil, fmt.Errorf("查询用户VPN失败: %w", err)
+		}
+		usernames := make(map[uint]string, len(userVPNs))
+		userVPNIDs := make([]uint, 0, len(userVPNs))
+		for _, userVPN := range userVPNs {
+			usernames[userVPN.ID] = userVPN.Username
+			userVPNIDs = append(userVPNIDs, userVPN.ID)
+			add(UserVPNOwner(userVPN.ID), userVPN.Username, userVPN.IPAddress, userVPN.IPAddress6)
+		}
+
+		if len(userVPNIDs) > 0 {
+			var devices []models.UserVPNDevice
+			if err := db.Where("user_vpn_id IN ?", userVPNIDs).Order("id").Find(&devices).Error; err != nil {
+				return nil, fmt.Errorf("查询用户VPN设备失败: %w", err)
+			}
+			for _, device := range devices {
+				add(UserDeviceOwner(device.ID), usernames[device.UserVPNID]+"/"+device.Name, device.IPAddress, device.IPAddress6)
+			}
+		}
+	}
+	return holders, nil
+}
+
+// holderAddressValid 地址是否在接口对应网段内；模块和用户VPN的地址还不能是网络地址或广播地址
+func holderAddressValid(wgInterface *models.WireGuardInterface, holder *ipHolder) bool {
+	network := wgInterface.Network
+	if holder.column == "ip_address6" {
+		network = wgInterface.Network6
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return false
+	}
+	offset, ok := ipaddr.Offset(ipNet, net.ParseIP(holder.address))
+	if !ok {
+		return false
+	}
+	if holder.owner.Type == models.IPOwnerGateway {
+		return true
+	}
+	first, last, ok := usableRange(ipNet)
+	return ok && offset >= first && offset <= last
+}
+
+// updateHolderAddress 修改模块、用户VPN或附加设备记录中的地址
+func updateHolderAddress(db *gorm.DB, holder *ipHolder, address string) error {
+	if err := db.Model(ownerModel(holder.owner)).Where("id = ?", holder.owner.ID).Update(holder.column, address).Error; err != nil {
+		return fmt.Errorf("更新 %s 的地址失败: %w", holder.name, err)
+	}
+	return nil
+}
+
+// ownerModel 持有者对应的记录模型
+func ownerModel(owner IPOwner) interface{} {
+	switch owner.Type {
+	case models.IPOwnerUserVPN:
+		return &models.UserVPN{}
+	case models.IPOwnerUserDevice:
+		return &models.UserVPNDevice{}
+	default:
+		return &models.Module{}
+	}
+}
+
+// consistencyReport 生成对外的检查结果
+func consistencyReport(wgInterface *models.WireGuardInterface, issues []ipIssue, repaired bool) *IPConsistencyReport {
+	report := &IPConsistencyReport{
+		InterfaceID: wgInterface.ID,
+		Interface:   wgInterface.Name,
+		Issues:      make([]IPIssue, 0, len(issues)),
+		Repaired:    repaired,
+	}
+	for _, issue := range issues {
+		report.Issues = append(report.Issues, issue.IPIssue)
+	}
+	return report
+}
+
+// addressNetwork 地址所属的接口网段
+func addressNetwork(wgInterface *models.WireGuardInterface, address string) string {
+	if ipaddr.IsIPv6(address) {
+		return wgInterface.Network6
+	}
+	return wgInterface.Network
+}
+
+// canonicalIP 规范化地址写法，无法解析时原样返回
+func canonicalIP(address string) string {
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+	return address
+}
+
+// serverAddress 接口在网段内的服务器地址
+func serverAddress(wgInterface *models.WireGuardInterface, network string) string {
+	if ipaddr.IsIPv6(network) {
+		return wgInterface.ServerIP6
+	}
+	return wgInterface.ServerIP
+}
+
+// usableRange 网段内可分配地址的序号范围：跳过网络地址，IPv4网段还跳过广播地址
+func usableRange(ipNet *net.IPNet) (uint64, uint64, bool) {
+	size := ipaddr.Size(ipNet, math.MaxUint64)
+	last := size - 1
+	if ipNet.IP.To4() != nil {
+		last--
+	}
+	if size < 2 || last < 1 {
+		return 0, 0, false
+	}
+	return 1, last, true
+}
+
+// reservationSpan 保留范围在网段内的序号区间，不属于该网段时返回false
+func reservationSpan(ipNet *net.IPNet, reservation *models.IPReservation) (addressSpan, bool) {
+	start, ok1 := ipaddr.Offset(ipNet, net.ParseIP(reservation.StartIP))
+	end, ok2 := ipaddr.Offset(ipNet, net.ParseIP(reservation.EndIP))
+	if !ok1 || !ok2 || start > end {
+		return addressSpan{}, false
+	}
+	return addressSpan{start, end}, true
+}
+
+// firstFree 在[first, last]内找到第一个不在占用区间内的序号
+func firstFree(first, last uint64, taken []addressSpan) (uint64, bool) {
+	sort.Slice(taken, func(i, j int) bool { return taken[i].start < taken[j].start })
+
+	candidate := first
+	for _, span := range taken {
+		if span.end < candidate {
+			continue
+		}
+		if span.start > candidate {
+			break
+		}
+		if span.end >= last {
+			return 0, false
+		}
+		candidate = span.end + 1
+	}
+	return candidate, candidate <= last
+}
+
+// clampSpan 把区间裁剪到[first, last]内，没有交集时返回空
+func clampSpan(span addressSpan, first, last uint64) []addressSpan {
+	span.start = max(span.start, first)
+	span.end = min(span.end, last)
+	if span.start > span.end {
+		return nil
+	}
+	return []addressSpan{span}
+}
+
+// spanSize 区间内的地址数，超过uint64时取上限
+func spanSize(span addressSpan) uint64 {
+	if span.end-span.start == math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return span.end - span.start + 1
+}
+
+// inSpans 序号是否在任一区间内
+func inSpans(offset uint64, spans []addressSpan) bool {
+	for _, span := range spans {
+		if offset >= span.start && offset <= span.end {
+			return true
+		}
+	}
+	return false
+}
+
+// nonEmpty 去掉空地址
+func nonEmpty(addresses []string) []string {
+	result := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address != "" {
+			result = append(result, address)
+		}
+	}
+	return result
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/metrics"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"gorm.io/gorm"
+)
+
+// moduleStatusLabels 模块状态在指标中的标签值
+var moduleStatusLabels = map[models.ModuleStatus]string{
+	models.ModuleStatusOffline:      "offline",
+	models.ModuleStatusOnline:       "online",
+	models.ModuleStatusWarning:      "warning",
+	models.ModuleStatusUnconfigured: "unconfigured",
+}
+
+// MetricsService 服务端Prometheus指标
+type MetricsService struct {
+	db              *gorm.DB
+	requestDuration *metrics.HistogramVec
+}
+
+// NewMetricsService 创建指标服务
+func NewMetricsService() *MetricsService {
+	return &MetricsService{
+		db: database.DB,
+		requestDuration: metrics.NewHistogramVec(
+			"eitec_vpn_http_request_duration_seconds",
+			"HTTP请求处理耗时（秒）",
+			metrics.DefaultBuckets,
+			"method", "route", "code",
+		),
+	}
+}
+
+// ObserveRequest 记录一次HTTP请求耗时，route为路由模板以避免标签基数过大
+func (ms *MetricsService) ObserveRequest(method, route string, code int, duration time.Duration) {
+	ms.requestDuration.Observe(duration.Seconds(), method, route, strconv.Itoa(code))
+}
+
+// peerInfo 对等端在数据库中的归属
+type peerInfo struct {
+	peerType string
+	id       uint
+	name     string
+}
+
+// Collect 采集当前指标
+func (ms *MetricsService) Collect() []*metrics.Family {
+	families := ms.collectInterfaces()
+	families = append(families, ms.collectModuleStatus(), ms.collectIPPools(), ms.requestDuration.Family())
+	return families
+}
+
+// collectInterfaces 采集接口及对等端流量和握手指标
+func (ms *MetricsService) collectInterfaces() []*metrics.Family {
+	interfaceUp := metrics.NewGauge("eitec_vpn_interface_up", "WireGuard接口是否在运行")
+	interfacePeers := metrics.NewGauge("eitec_vpn_interface_peers", "WireGuard接口上的对等端数量")
+	interfaceRx := metrics.NewCounter("eitec_vpn_interface_receive_bytes_total", "WireGuard接口接收的字节数（自接口启动）")
+	interfaceTx := metrics.NewCounter("eitec_vpn_interface_transmit_bytes_total", "WireGuard接口发送的字节数（自接口启动）")
+	peerRx := metrics.NewCounter("eitec_vpn_peer_receive_bytes_total", "从对等端接收的字节数（自接口启动）")
+	peerTx := metrics.NewCounter("eitec_vpn_peer_transmit_bytes_total", "向对等端发送的字节数（自接口启动）")
+	peerHandshake := metrics.NewGauge("eitec_vpn_peer_last_handshake_age_seconds", "距对等端最近一次握手的秒数，从未握手的对等端不输出")
+	families := []*metrics.Family{interfaceUp, interfacePeers, interfaceRx, interfaceTx, peerRx, peerTx, peerHandshake}
+
+	var interfaces []models.WireGuardInterface
+	if err := ms.db.Order("id").Find(&interfaces).Error; err != nil {
+		log.Printf("采集指标时查询接口失败: %v", err)
+		return families
+	}
+
+	peers, err := ms.peerIndex()
+	if err != nil {
+		log.Printf("采集指标时查询对等端失败: %v", err)
+	}
+
+	now := time.Now()
+	backend := wireguard.GetBackend()
+	for _, wgInterface := range interfaces {
+		device, err := backend.GetDevice(wgInterface.Name)
+		if err != nil {
+			interfaceUp.Add(0, "interface", wgInterface.Name)
+			continue
+		}
+		interfaceUp.Add(1, "interface", wgInterface.Name)
+		interfacePeers.Add(float64(len(device.Peers)), "interface", wgInterface.Name)
+
+		var rxTotal, txTotal uint64
+		for _, peer := range device.Peers {
+			rxTotal += peer.ReceiveBytes
+			txTotal += peer.TransmitBytes
+
+			info, ok := peers[peer.PublicKey]
+			if !ok {
+				info = peerInfo{peerType: "unknown"}
+			}
+			labels := []string{
+				"interface", wgInterface.Name,
+				"peer_type", info.peerType,
+				"peer_id", strconv.FormatUint(uint64(info.id), 10),
+				"name", info.name,
+				"public_key", peer.PublicKey,
+			}
+			peerRx.Add(float64(peer.ReceiveBytes), labels...)
+			peerTx.Add(float64(peer.TransmitBytes), labels...)
+			if !peer.LatestHandshake.IsZero() {
+				peerHandshake.Add(now.Sub(peer.LatestHandshake).Seconds(), labels...)
+			}
+		}
+		interfaceRx.Add(float64(rxTotal), "interface", wgInterface.Name)
+		interfaceTx.Add(float64(txTotal), "interface", wgInterface.Name)
+	}
+
+	return families
+}
+
+// peerIndex 按公钥索引模块、用户VPN和附加设备
+func (ms *MetricsService) peerIndex() (map[string]peerInfo, error) {
+	index := make(map[string]peerInfo)
+
+	var modules []models.Module
+	if err := ms.db.Select("id", "name", "public_key").Find(&modules).Error; err != nil {
+		return index, err
+	}
+	for _, module := range modules {
+		if module.PublicKey != "" {
+			index[module.PublicKey] = peerInfo{peerType: models.TrafficPeerModule, id: module.ID, name: module.Name}
+		}
+	}
+
+	var userVPNs []models.UserVPN
+	if err := ms.db.Select("id", "username", "public_key").Find(&userVPNs).Error; err != nil {
+		return index, err
+	}
+	usernames := make(map[uint]string, len(userVPNs))
+	for _, userVPN := range userVPNs {
+		usernames[userVPN.ID] = userVPN.Username
+		if userVPN.PublicKey != "" {
+			index[userVPN.PublicKey] = peerInfo{peerType: models.TrafficPeerUserVPN, id: userVPN.ID, name: userVPN.Username}
+		}
+	}
+
+	var devices []models.UserVPNDevice
+	if err := ms.db.Select("id", "user_vpn_id", "name", "public_key").Find(&devices).Error; err != nil {
+		return index, err
+	}
+	for _, device := range devices {
+		index[device.PublicKey] = peerInfo{peerType: models.TrafficPeerUserDevice, id: device.ID, name: usernames[device.UserVPNID] + "/" + device.Name}
+	}
+
+	return index, nil
+}
+
+// collectModuleStatus 按状态统计模块数量，没有模块的状态输出0
+func (ms *MetricsService) collectModuleStatus() *metrics.Family {
+	family := metrics.NewGauge("eitec_vpn_modules", "各状态的模块数量")
+
+	var rows []struct {
+		Status models.ModuleStatus
+		Count  int64
+	}
+	if err := ms.db.Model(&models.Module{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		log.Printf("采集指标时统计模块状态失败: %v", err)
+		return family
+	}
+
+	counts := make(map[models.ModuleStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	for _, status := range []models.ModuleStatus{
+		models.ModuleStatusOnline,
+		models.ModuleStatusOffline,
+		models.ModuleStatusWarning,
+		models.ModuleStatusUnconfigured,
+	} {
+		family.Add(float64(counts[status]), "status", moduleStatusLabels[status])
+	}
+
+	return family
+}
+
+// collectIPPools 按网段统计IP池使用率
+func (ms *MetricsService) collectIPPools() *metrics.Family {
+	family := metrics.NewGauge("eitec_vpn_ip_pool_utilization_ratio", "IP池已分配地址占比（0-1）")
+
+	reports, err := (&IPAMService{db: ms.db}).GetAllUtilization()
+	if err != nil {
+		log.Printf("采集指标时统计IP池失败: %v", err)
+		return family
+	}
+
+	for _, report := range reports {
+		for _, network := range report.Networks {
+			if network.Total > 0 {
+				family.Add(network.Usage, "network", network.Network)
+			}
+		}
+	}
+
+	return family
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+
+	"gorm.io/gorm"
+)
+
+// ModuleAgentService 模块代理服务（处理模块端心跳、流量上报和配置拉取）
+type ModuleAgentService struct {
+	db            *gorm.DB
+	moduleService *ModuleService
+}
+
+// NewModuleAgentService 创建模块代理服务
+func NewModuleAgentService(moduleService *ModuleService) *ModuleAgentService {
+	return &ModuleAgentService{
+		db:            database.DB,
+		moduleService: moduleService,
+	}
+}
+
+// RecordHeartbeat 记录模块心跳，保存模块端版本，模块端公钥与服务端记录不一致时标记为警告
+func (mas *ModuleAgentService) RecordHeartbeat(moduleID uint, heartbeat *models.ModuleHeartbeat) error {
+	var module models.Module
+	if err := mas.db.Select("id, name, public_key, public_key_mismatch").First(&module, moduleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("模块不存在")
+		}
+		return fmt.Errorf("查询模块失败: %w", err)
+	}
+
+	status := models.ModuleStatusOnline
+	updates := map[string]interface{}{
+		"last_seen": time.Now(),
+	}
+
+	if heartbeat != nil {
+		// 模块端进程在线但WireGuard未运行，标记为警告
+		if heartbeat.WireGuardRunning != nil && !*heartbeat.WireGuardRunning {
+			status = models.ModuleStatusWarning
+		}
+		if heartbeat.Version != "" {
+			updates["agent_version"] = heartbeat.Version
+		}
+		// 模块端仍在使用旧密钥时服务端的对等端无法握手，需要模块重新拉取配置
+		if heartbeat.PublicKey != "" {
+			mismatch := heartbeat.PublicKey != module.PublicKey
+			if mismatch && !module.PublicKeyMismatch {
+				log.Printf("⚠️ 模块 %s 上报的公钥 %s 与服务端记录的 %s 不一致", module.Name, heartbeat.PublicKey, module.PublicKey)
+			}
+			updates["public_key_mismatch"] = mismatch
+			module.PublicKeyMismatch = mismatch
+		}
+	}
+	if module.PublicKeyMismatch {
+		status = models.ModuleStatusWarning
+	}
+	updates["status"] = status
+
+	if err := mas.db.Model(&models.Module{}).Where("id = ?", moduleID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("记录模块心跳失败: %w", err)
+	}
+	return nil
+}
+
+// RecordTraffic 处理模块的流量上报。模块端计数器与服务端对等端计数器统计的是同一条隧道，
+// 流量历史和累计流量以服务端同步时的计数器增量为准（见 SyncModuleStatus），
+// 上报内容不再保存，只用于刷新模块在线时间
+func (mas *ModuleAgentService) RecordTraffic(moduleID uint) error {
+	updates := map[string]interface{}{
+		"last_seen": time.Now(),
+	}
+
+	result := mas.db.Model(&models.Module{}).Where("id = ?", moduleID).Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("记录模块流量失败: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("模块不存在")
+	}
+
+	return nil
+}
+
+// GetAgentConfig 获取模块当前的WireGuard配置及其ETag
+func (mas *ModuleAgentService) GetAgentConfig(moduleID uint) (string, string, error) {
+	config, err := mas.moduleService.GenerateModuleConfig(moduleID)
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(config))
+	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:]))
+
+	return config, etag, nil
+}
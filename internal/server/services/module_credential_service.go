@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// moduleKeyPrefix 模块API密钥前缀
+const moduleKeyPrefix = "emk_"
+
+// ModuleCredentialService 模块API凭证服务
+type ModuleCredentialService struct {
+	db *gorm.DB
+}
+
+// NewModuleCredentialService 创建模块API凭证服务
+func NewModuleCredentialService() *ModuleCredentialService {
+	return &ModuleCredentialService{
+		db: database.DB,
+	}
+}
+
+// hashModuleKey 计算模块API密钥的哈希
+func hashModuleKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueCredential 为模块签发API凭证，明文密钥只在签发时返回一次
+func (mcs *ModuleCredentialService) IssueCredential(moduleID uint, req *models.ModuleCredentialRequest) (*models.ModuleCredential, string, error) {
+	return mcs.issueCredential(mcs.db, moduleID, req)
+}
+
+// issueCredential 使用指定的数据库连接（可为事务）签发凭证
+func (mcs *ModuleCredentialService) issueCredential(db *gorm.DB, moduleID uint, req *models.ModuleCredentialRequest) (*models.ModuleCredential, string, error) {
+	var module models.Module
+	if err := db.First(&module, moduleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", errors.New("模块不存在")
+		}
+		return nil, "", fmt.Errorf("查询模块失败: %w", err)
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = models.DefaultModuleScopes
+	}
+	for _, scope := range scopes {
+		if !models.IsValidModuleScope(scope) {
+			return nil, "", fmt.Errorf("无效的授权范围: %s", scope)
+		}
+	}
+
+	if req.ExpiresInDays < 0 {
+		return nil, "", errors.New("有效天数不能为负数")
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("%s-agent", module.Name)
+	}
+
+	return mcs.createCredential(db, moduleID, name, strings.Join(scopes, ","), expiresAt)
+}
+
+// createCredential 生成密钥并保存凭证
+func (mcs *ModuleCredentialService) createCredential(db *gorm.DB, moduleID uint, name, scopes string, expiresAt *time.Time) (*models.ModuleCredential, string, error) {
+	secret, err := utils.GenerateRandomString(40)
+	if err != nil {
+		return nil, "", fmt.Errorf("生成模块API密钥失败: %w", err)
+	}
+	apiKey := moduleKeyPrefix + secret
+
+	credential := &models.ModuleCredential{
+		ModuleID:   moduleID,
+		Name:       name,
+		KeyPrefix:  apiKey[:12],
+		SecretHash: hashModuleKey(apiKey),
+		Scopes:     scopes,
+		ExpiresAt:  expiresAt,
+	}
+
+	if err := db.Create(credential).Error; err != nil {
+		return nil, "", fmt.Errorf("保存模块凭证失败: %w", err)
+	}
+
+	fmt.Printf("签发模块凭证 - 模块ID: %d, 凭证ID: %d, 前缀: %s\n", moduleID, credential.ID, credential.KeyPrefix)
+
+	return credential, apiKey, nil
+}
+
+// GetCredentials 获取模块的所有凭证
+func (mcs *ModuleCredentialService) GetCredentials(moduleID uint) ([]models.ModuleCredential, error) {
+	var credentials []models.ModuleCredential
+	if err := mcs.db.Where("module_id = ?", moduleID).Order("created_at DESC").Find(&credentials).Error; err != nil {
+		return nil, fmt.Errorf("查询模块凭证失败: %w", err)
+	}
+	return credentials, nil
+}
+
+// getCredential 获取属于指定模块的凭证
+func (mcs *ModuleCredentialService) getCredential(moduleID, credentialID uint) (*models.ModuleCredential, error) {
+	var credential models.ModuleCredential
+	if err := mcs.db.Where("id = ? AND module_id = ?", credentialID, moduleID).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("凭证不存在")
+		}
+		return nil, fmt.Errorf("查询模块凭证失败: %w", err)
+	}
+	return &credential, nil
+}
+
+// RotateCredential 轮换凭证：签发新密钥，旧密钥在宽限期后失效
+func (mcs *ModuleCredentialService) RotateCredential(moduleID, credentialID uint, gracePeriod time.Duration) (*models.ModuleCredential, string, error) {
+	old, err := mcs.getCredential(moduleID, credentialID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !old.IsActive() {
+		return nil, "", errors.New("凭证已失效，无法轮换")
+	}
+
+	// 新凭证沿用原凭证的有效期长度
+	var expiresAt *time.Time
+	if old.ExpiresAt != nil {
+		t := time.Now().Add(old.ExpiresAt.Sub(old.CreatedAt))
+		expiresAt = &t
+	}
+
+	credential, apiKey, err := mcs.createCredential(mcs.db, moduleID, old.Name, old.Scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 宽限期为0时立即吊销旧凭证，否则提前其过期时间
+	now := time.Now()
+	updates := map[string]interface{}{}
+	if gracePeriod <= 0 {
+		updates["revoked_at"] = now
+	} else {
+		updates["expires_at"] = now.Add(gracePeriod)
+	}
+	if err := mcs.db.Model(old).Updates(updates).Error; err != nil {
+		return nil, "", fmt.Errorf("更新旧凭证失败: %w", err)
+	}
+
+	fmt.Printf("轮换模块凭证 - 模块ID: %d, 旧凭证ID: %d, 新凭证ID: %d\n", moduleID, old.ID, credential.ID)
+
+	return credential, apiKey, nil
+}
+
+// RevokeCredential 吊销凭证
+func (mcs *ModuleCredentialService) RevokeCredential(moduleID, credentialID uint) error {
+	credential, err := mcs.getCredential(moduleID, credentialID)
+	if err != nil {
+		return err
+	}
+
+	if credential.RevokedAt != nil {
+		return nil
+	}
+
+	if err := mcs.db.Model(credential).Update("revoked_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("吊销模块凭证失败: %w", err)
+	}
+
+	fmt.Printf("吊销模块凭证 - 模块ID: %d, 凭证ID: %d\n", moduleID, credentialID)
+
+	return nil
+}
+
+// Authenticate 校验API密钥，并确认其属于指定模块
+func (mcs *ModuleCredentialService) Authenticate(moduleID uint, apiKey, clientIP string) (*models.ModuleCredential, error) {
+	if apiKey == "" {
+		return nil, errors.New("缺少模块API密钥")
+	}
+
+	var credential models.ModuleCredential
+	if err := mcs.db.Where("secret_hash = ?", hashModuleKey(apiKey)).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("模块API密钥无效")
+		}
+		return nil, fmt.Errorf("查询模块凭证失败: %w", err)
+	}
+
+	// 凭证只能用于其所属模块，防止一个模块替另一个模块上报
+	if credential.ModuleID != moduleID {
+		return nil, errors.New("模块API密钥与模块不匹配")
+	}
+
+	if !credential.IsActive() {
+		return nil, errors.New("模块API密钥已失效")
+	}
+
+	now := time.Now()
+	mcs.db.Model(&credential).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"last_used_ip": clientIP,
+	})
+	credential.LastUsedAt = &now
+	credential.LastUsedIP = clientIP
+
+	return &credential, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/utils"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"gorm.io/gorm"
+)
+
+// 加入令牌相关常量
+const (
+	joinTokenPrefix     = "ejt_"
+	DefaultJoinTokenTTL = 30 * time.Minute
+	MaxJoinTokenTTL     = 7 * 24 * time.Hour
+)
+
+// ModuleEnrollmentService 模块注册服务（一次性加入令牌）
+type ModuleEnrollmentService struct {
+	db                *gorm.DB
+	moduleService     *ModuleService
+	credentialService *ModuleCredentialService
+}
+
+// NewModuleEnrollmentService 创建模块注册服务
+func NewModuleEnrollmentService(moduleService *ModuleService, credentialService *ModuleCredentialService) *ModuleEnrollmentService {
+	return &ModuleEnrollmentService{
+		db:                database.DB,
+		moduleService:     moduleService,
+		credentialService: credentialService,
+	}
+}
+
+// CreateJoinToken 为模块签发一次性加入令牌，同一模块之前未使用的令牌将失效
+func (mes *ModuleEnrollmentService) CreateJoinToken(moduleID uint, ttl time.Duration) (*models.ModuleJoinToken, string, error) {
+	if _, err := mes.moduleService.GetModule(moduleID); err != nil {
+		return nil, "", err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultJoinTokenTTL
+	}
+	if ttl > MaxJoinTokenTTL {
+		return nil, "", fmt.Errorf("令牌有效期不能超过 %s", MaxJoinTokenTTL)
+	}
+
+	secret, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("生成加入令牌失败: %w", err)
+	}
+	token := joinTokenPrefix + secret
+
+	joinToken := &models.ModuleJoinToken{
+		ModuleID:    moduleID,
+		TokenPrefix: token[:12],
+		TokenHash:   hashModuleKey(token),
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+
+	err = mes.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("module_id = ? AND used_at IS NULL", moduleID).Delete(&models.ModuleJoinToken{}).Error; err != nil {
+			return fmt.Errorf("清理旧加入令牌失败: %w", err)
+		}
+		if err := tx.Create(joinToken).Error; err != nil {
+			return fmt.Errorf("保存加入令牌失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	fmt.Printf("签发加入令牌 - 模块ID: %d, 前缀: %s, 过期时间: %s\n", moduleID, joinToken.TokenPrefix, joinToken.ExpiresAt.Format(time.RFC3339))
+
+	return joinToken, token, nil
+}
+
+// Enroll 使用加入令牌注册模块：绑定模块本地生成的公钥，签发API凭证并返回不含私钥的配置
+func (mes *ModuleEnrollmentService) Enroll(req *models.ModuleEnrollRequest, clientIP string) (*models.ModuleEnrollResponse, error) {
+	if !wireguard.ValidateKey(req.PublicKey) {
+		return nil, errors.New("公钥格式无效")
+	}
+
+	var module models.Module
+	var apiKey string
+
+	err := mes.db.Transaction(func(tx *gorm.DB) error {
+		var joinToken models.ModuleJoinToken
+		if err := tx.Where("token_hash = ?", hashModuleKey(req.Token)).First(&joinToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("加入令牌无效")
+			}
+			return fmt.Errorf("查询加入令牌失败: %w", err)
+		}
+
+		// 条件更新保证令牌只能被使用一次
+		now := time.Now()
+		result := tx.Model(&models.ModuleJoinToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", joinToken.ID, now).
+			Updates(map[string]interface{}{
+				"used_at": now,
+				"used_ip": clientIP,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("更新加入令牌失败: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("加入令牌已使用或已过期")
+		}
+
+		if err := tx.First(&module, joinToken.ModuleID).Error; err != nil {
+			return errors.New("模块不存在")
+		}
+
+		// 公钥不能与其他模块、用户VPN或设备冲突
+		var count int64
+		tx.Model(&models.Module{}).Where("public_key = ? AND id <> ?", req.PublicKey, module.ID).Count(&count)
+		if count == 0 {
+			tx.Model(&models.UserVPN{}).Where("public_key = ?", req.PublicKey).Count(&count)
+		}
+		if count == 0 {
+			tx.Model(&models.UserVPNDevice{}).Where("public_key = ?", req.PublicKey).Count(&count)
+		}
+		if count > 0 {
+			return errors.New("公钥已被使用")
+		}
+
+		// 服务器不再保存模块私钥
+		if err := tx.Model(&module).Updates(map[string]interface{}{
+			"public_key":  req.PublicKey,
+			"private_key": "",
+		}).Error; err != nil {
+			return fmt.Errorf("更新模块公钥失败: %w", err)
+		}
+
+		var err error
+		_, apiKey, err = mes.credentialService.issueCredential(tx, module.ID, &models.ModuleCredentialRequest{
+			Name: fmt.Sprintf("%s-enroll", module.Name),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 公钥变化后更新服务端接口配置
+	if err := mes.moduleService.updateInterfaceConfig(module.InterfaceID); err != nil {
+		fmt.Printf("警告：更新WireGuard配置失败 - 接口ID: %d, 错误: %v\n", module.InterfaceID, err)
+	}
+
+	config, err := mes.moduleService.GenerateModuleConfig(module.ID)
+	if err != nil {
+		return nil, fmt.Errorf("生成模块配置失败: %w", err)
+	}
+
+	var wgInterface models.WireGuardInterface
+	if err := mes.db.First(&wgInterface, module.InterfaceID).Error; err != nil {
+		return nil, fmt.Errorf("获取WireGuard接口配置失败: %w", err)
+	}
+
+	fmt.Printf("模块注册成功 - 模块ID: %d, 名称: %s, 主机名: %s, 来源: %s\n", module.ID, module.Name, req.Hostname, clientIP)
+
+	return &models.ModuleEnrollResponse{
+		ModuleID:       module.ID,
+		ModuleName:     module.Name,
+		Location:       module.Location,
+		APIKey:         apiKey,
+		ServerEndpoint: mes.moduleService.resolveServerEndpoint(&wgInterface),
+		Config:         config,
+	}, nil
+}
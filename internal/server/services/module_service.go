@@ -10,6 +10,7 @@ import (
 	"eitec-vpn/internal/server/database"
 	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/ipaddr"
 	"eitec-vpn/internal/shared/wireguard"
 
 	"gorm.io/gorm"
@@ -99,16 +100,6 @@ func (ms *ModuleService) CreateModuleWithConfig(config *ModuleConfig) (*models.M
 		}
 	}
 
-	var ipAddress string
-	// 根据配置分配或不分配IP地址
-	if config.AutoAssignIP {
-		// 从指定接口的IP池中分配IP
-		ipAddress, err = ms.getAvailableIPForInterface(config.InterfaceID)
-		if err != nil {
-			return nil, fmt.Errorf("分配IP地址失败: %w", err)
-		}
-	}
-
 	// 根据配置模板设置参数
 	allowedIPs := config.AllowedIPs
 	persistentKA := config.PersistentKeepalive
@@ -138,28 +129,26 @@ func (ms *ModuleService) CreateModuleWithConfig(config *ModuleConfig) (*models.M
 		InterfaceID:  config.InterfaceID,
 		PublicKey:    keyPair.PublicKey,
 		PrivateKey:   keyPair.PrivateKey,
-		PresharedKey: presharedKey, // 保存预共享密钥
-		IPAddress:    ipAddress,
+		PresharedKey: presharedKey,   // 保存预共享密钥
 		LocalIP:      config.LocalIP, // 保存模块的内网IP地址
 		Status:       models.ModuleStatusUnconfigured,
 		AllowedIPs:   allowedIPs,
 		PersistentKA: persistentKA,
 	}
 
-	if err := ms.db.Create(module).Error; err != nil {
-		// 如果创建失败，释放IP地址
-		if config.AutoAssignIP && ipAddress != "" {
-			ms.releaseIPForInterface(config.InterfaceID, ipAddress)
+	// 分配地址和创建模块在同一事务中完成，失败时地址自动回滚
+	if err := ms.db.Transaction(func(tx *gorm.DB) error {
+		if config.AutoAssignIP {
+			if err := allocateAddresses(&IPAMService{db: tx}, &wgInterface, &module.IPAddress, &module.IPAddress6); err != nil {
+				return err
+			}
 		}
-		return nil, fmt.Errorf("创建模块失败: %w", err)
-	}
-
-	// 分配IP地址给模块
-	if config.AutoAssignIP && ipAddress != "" {
-		if err := ms.allocateIPForInterface(config.InterfaceID, ipAddress, module.ID); err != nil {
-			ms.db.Delete(module)
-			return nil, fmt.Errorf("分配IP地址失败: %w", err)
+		if err := tx.Create(module).Error; err != nil {
+			return fmt.Errorf("创建模块失败: %w", err)
 		}
+		return (&IPAMService{db: tx}).Assign(wgInterface.ID, ModuleOwner(module.ID), module.IPAddress, module.IPAddress6)
+	}); err != nil {
+		return nil, err
 	}
 
 	// 保存模块配置到系统配置表
@@ -243,17 +232,6 @@ func (ms *ModuleService) CreateModule(moduleData *models.ModuleCreateRequest) (*
 		}
 	}
 
-	// 自动分配IP地址（如果需要）
-	var ipAddress string
-	if moduleData.AutoAssignIP {
-		ipAddress, err = ms.assignIPAddress(wgInterface.Network, wgInterface.ID)
-		if err != nil {
-			return nil, fmt.Errorf("分配IP地址失败: %w", err)
-		}
-	} else {
-		ipAddress = moduleData.IPAddress
-	}
-
 	// 如果没有提供 LocalIP，尝试从 AllowedIPs 中推导
 	localIP := moduleData.LocalIP
 	if localIP == "" && moduleData.AllowedIPs != "" {
@@ -274,7 +252,6 @@ func (ms *ModuleService) CreateModule(moduleData *models.ModuleCreateRequest) (*
 		InterfaceID:      moduleData.InterfaceID,
 		PublicKey:        keyPair.PublicKey,
 		PrivateKey:       keyPair.PrivateKey,
-		IPAddress:        ipAddress,
 		LocalIP:          localIP,
 		Status:           models.ModuleStatusUnconfigured,
 		AllowedIPs:       moduleData.AllowedIPs,
@@ -285,9 +262,37 @@ func (ms *ModuleService) CreateModule(moduleData *models.ModuleCreateRequest) (*
 		UpdatedAt:        time.Now(),
 	}
 
-	// 保存到数据库
-	if err := ms.db.Create(module).Error; err != nil {
-		return nil, fmt.Errorf("保存模块失败: %w", err)
+	// 分配IP地址并保存到数据库，在同一事务中完成，并发创建不会分到相同地址
+	if err := ms.db.Transaction(func(tx *gorm.DB) error {
+		ipam := &IPAMService{db: tx}
+		if moduleData.AutoAssignIP {
+			if err := allocateAddresses(ipam, &wgInterface, &module.IPAddress, &module.IPAddress6); err != nil {
+				return err
+			}
+		} else {
+			// 手动指定地址，可以使用静态保留的地址；双栈接口未指定IPv6地址时自动分配
+			module.IPAddress = moduleData.IPAddress
+			if err := ipam.Claim(&wgInterface, module.IPAddress); err != nil {
+				return err
+			}
+			if wgInterface.DualStack() {
+				if moduleData.IPAddress6 != "" {
+					module.IPAddress6 = moduleData.IPAddress6
+					if err := ipam.Claim(&wgInterface, module.IPAddress6); err != nil {
+						return err
+					}
+				} else if module.IPAddress6, err = ipam.Allocate(&wgInterface, wgInterface.Network6); err != nil {
+					return fmt.Errorf("分配IPv6地址失败: %w", err)
+				}
+			}
+		}
+
+		if err := tx.Create(module).Error; err != nil {
+			return fmt.Errorf("保存模块失败: %w", err)
+		}
+		return ipam.Assign(wgInterface.ID, ModuleOwner(module.ID), module.IPAddress, module.IPAddress6)
+	}); err != nil {
+		return nil, err
 	}
 
 	// 更新接口连接数
@@ -312,26 +317,18 @@ func (ms *ModuleService) CreateModule(moduleData *models.ModuleCreateRequest) (*
 	return module, nil
 }
 
-// assignIPAddress 从IP池中分配IP地址
-func (ms *ModuleService) assignIPAddress(networkCIDR string, interfaceID uint) (string, error) {
-	// 获取网络的可用IP
-	var availableIP models.IPPool
-	if err := ms.db.Where("network = ? AND is_used = ?", networkCIDR, false).First(&availableIP).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return "", errors.New("没有可用的IP地址")
-		}
-		return "", fmt.Errorf("查询IP池失败: %w", err)
+// allocateAddresses 为接口自动分配IPv4地址，双栈接口同时分配IPv6地址
+func allocateAddresses(ipam *IPAMService, wgInterface *models.WireGuardInterface, ipAddress, ipAddress6 *string) error {
+	var err error
+	if *ipAddress, err = ipam.Allocate(wgInterface, wgInterface.Network); err != nil {
+		return fmt.Errorf("分配IP地址失败: %w", err)
 	}
-
-	// 标记IP为已使用
-	if err := ms.db.Model(&availableIP).Updates(map[string]interface{}{
-		"is_used":   true,
-		"module_id": nil, // 暂时不关联模块，创建成功后再更新
-	}).Error; err != nil {
-		return "", fmt.Errorf("更新IP池失败: %w", err)
+	if wgInterface.DualStack() {
+		if *ipAddress6, err = ipam.Allocate(wgInterface, wgInterface.Network6); err != nil {
+			return fmt.Errorf("分配IPv6地址失败: %w", err)
+		}
 	}
-
-	return availableIP.IPAddress, nil
+	return nil
 }
 
 // inferLocalIPFromAllowedIPs 从AllowedIPs中推导LocalIP
@@ -387,6 +384,11 @@ func (ms *ModuleService) validateModuleData(data *models.ModuleCreateRequest) er
 		return errors.New("网段格式不正确，请使用CIDR格式（如 192.168.50.0/24）")
 	}
 
+	// 手动指定的IPv6地址
+	if data.IPAddress6 != "" && !ipaddr.IsIPv6(data.IPAddress6) {
+		return errors.New("ip_address6必须是IPv6地址")
+	}
+
 	// 验证保活间隔
 	if data.PersistentKeepalive < 0 || data.PersistentKeepalive > 300 {
 		return errors.New("保活间隔必须在0-300秒之间")
@@ -490,6 +492,17 @@ func (ms *ModuleService) UpdateModule(id uint, updates map[string]interface{}) e
 		}
 	}
 
+	// 按列名更新不经过加密序列化器，敏感字段需要先加密
+	for _, column := range []string{"private_key", "preshared_key"} {
+		if value, ok := updates[column].(string); ok {
+			sealed, err := models.SealColumn(ms.db, &models.Module{}, column, id, value)
+			if err != nil {
+				return err
+			}
+			updates[column] = sealed
+		}
+	}
+
 	result := ms.db.Model(&models.Module{}).Where("id = ?", id).Updates(updates)
 	if result.Error != nil {
 		return fmt.Errorf("更新模块失败: %w", result.Error)
@@ -515,19 +528,46 @@ func (ms *ModuleService) DeleteModule(id uint) error {
 	// 保存接口ID用于后续配置更新
 	interfaceID := module.InterfaceID
 
-	// 先删除模块相关的用户VPN配置（硬删除）
-	if err := ms.db.Unscoped().Where("module_id = ?", id).Delete(&models.UserVPN{}).Error; err != nil {
-		return fmt.Errorf("删除模块用户VPN配置失败: %w", err)
-	}
+	if err := ms.db.Transaction(func(tx *gorm.DB) error {
+		ipam := &IPAMService{db: tx}
 
-	// 释放IP地址
-	if err := ms.releaseIPForInterface(interfaceID, module.IPAddress); err != nil {
-		return fmt.Errorf("释放IP地址失败: %w", err)
-	}
+		// 先删除模块相关的用户VPN配置（硬删除）并释放其地址
+		var userVPNs []models.UserVPN
+		if err := tx.Where("module_id = ?", id).Find(&userVPNs).Error; err != nil {
+			return fmt.Errorf("查询模块用户VPN配置失败: %w", err)
+		}
+		for _, userVPN := range userVPNs {
+			if err := deleteUserVPNDevices(tx, interfaceID, userVPN.ID); err != nil {
+				return err
+			}
+			if err := ipam.Release(interfaceID, UserVPNOwner(userVPN.ID)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("module_id = ?", id).Delete(&models.UserVPN{}).Error; err != nil {
+			return fmt.Errorf("删除模块用户VPN配置失败: %w", err)
+		}
+
+		// 删除模块的API凭证
+		if err := tx.Where("module_id = ?", id).Delete(&models.ModuleCredential{}).Error; err != nil {
+			return fmt.Errorf("删除模块API凭证失败: %w", err)
+		}
+		if err := tx.Where("module_id = ?", id).Delete(&models.ModuleJoinToken{}).Error; err != nil {
+			return fmt.Errorf("删除模块加入令牌失败: %w", err)
+		}
+
+		// 释放IP地址
+		if err := ipam.Release(interfaceID, ModuleOwner(module.ID)); err != nil {
+			return err
+		}
 
-	// 删除模块记录（硬删除）
-	if err := ms.db.Unscoped().Delete(&models.Module{}, id).Error; err != nil {
-		return fmt.Errorf("删除模块失败: %w", err)
+		// 删除模块记录（硬删除）
+		if err := tx.Unscoped().Delete(&models.Module{}, id).Error; err != nil {
+			return fmt.Errorf("删除模块失败: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 
 	// 自动更新WireGuard接口配置
@@ -577,21 +617,8 @@ func (ms *ModuleService) GenerateModuleConfig(id uint) (string, error) {
 		return "", fmt.Errorf("获取WireGuard接口配置失败: %w", err)
 	}
 
-	// 获取端点配置，按优先级选择：
-	var serverEndpoint string
-
-	// 1. 优先使用配置文件中的服务器IP + 接口端口
-	if cfg := getGlobalConfig(); cfg != nil && cfg.App.ServerIP != "" {
-		serverEndpoint = fmt.Sprintf("%s:%d", cfg.App.ServerIP, wgInterface.ListenPort)
-	} else {
-		// 2. 然后使用系统配置的endpoint
-		if systemEndpoint, err := database.GetSystemConfig("server.endpoint"); err == nil && systemEndpoint != "" {
-			serverEndpoint = systemEndpoint
-		} else {
-			// 3. 最后兜底：动态构建端点 vpn.eitec.com + 接口端口
-			serverEndpoint = fmt.Sprintf("vpn.eitec.com:%d", wgInterface.ListenPort)
-		}
-	}
+	// 获取端点配置
+	serverEndpoint := ms.resolveServerEndpoint(&wgInterface)
 
 	// 使用接口的DNS配置，如果没有则使用系统默认
 	dns := wgInterface.DNS
@@ -612,9 +639,33 @@ func (ms *ModuleService) GenerateModuleConfig(id uint) (string, error) {
 	// 记录配置生成日志
 	fmt.Printf("生成模块配置 - 模块ID: %d, 接口: %s, 网络: %s, 端点: %s, LocalIP: %s\n", id, wgInterface.Name, wgInterface.Network, serverEndpoint, moduleLocalIP)
 
+	// 配置只在下载、注册和模块拉取时生成，生成即视为模块已取得最新配置
+	if module.ConfigRefreshRequired {
+		if err := ms.db.Model(&models.Module{}).Where("id = ?", id).Update("config_refresh_required", false).Error; err != nil {
+			fmt.Printf("⚠️ 清除模块配置更新标记失败: %v\n", err)
+		}
+	}
+
 	return config, nil
 }
 
+// resolveServerEndpoint 获取接口对外的服务端端点
+func (ms *ModuleService) resolveServerEndpoint(wgInterface *models.WireGuardInterface) string {
+	// 按优先级选择：
+	// 1. 优先使用配置文件中的服务器IP + 接口端口
+	if cfg := getGlobalConfig(); cfg != nil && cfg.App.ServerIP != "" {
+		return fmt.Sprintf("%s:%d", cfg.App.ServerIP, wgInterface.ListenPort)
+	}
+
+	// 2. 然后使用系统配置的endpoint
+	if systemEndpoint, err := database.GetSystemConfig("server.endpoint"); err == nil && systemEndpoint != "" {
+		return systemEndpoint
+	}
+
+	// 3. 最后兜底：动态构建端点 vpn.eitec.com + 接口端口
+	return fmt.Sprintf("vpn.eitec.com:%d", wgInterface.ListenPort)
+}
+
 // GeneratePeerConfig 生成运维端Peer配置
 func (ms *ModuleService) GeneratePeerConfig(id uint) (string, error) {
 	module, err := ms.GetModule(id)
@@ -708,50 +759,169 @@ func (ms *ModuleService) GetTotalTraffic() (map[string]uint64, error) {
 	return traffic, nil
 }
 
-// SyncModuleStatus 同步模块状态 (从WireGuard获取实际状态)
+// SyncModuleStatus 同步模块和用户VPN状态 (从WireGuard获取实际状态)，并记录流量历史
 func (ms *ModuleService) SyncModuleStatus() error {
-	// 获取WireGuard状态
-	wgStatus, err := wireguard.GetWireGuardStatus("wg0")
+	var interfaces []models.WireGuardInterface
+	if err := ms.db.Find(&interfaces).Error; err != nil {
+		return fmt.Errorf("查询接口列表失败: %w", err)
+	}
+
+	trafficService := NewTrafficService()
+	now := time.Now()
+	for i := range interfaces {
+		if err := ms.syncInterfacePeers(&interfaces[i], trafficService, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncInterfacePeers 同步单个接口下的对等端状态，累计流量按计数器增量计算
+func (ms *ModuleService) syncInterfacePeers(wgInterface *models.WireGuardInterface, trafficService *TrafficService, now time.Time) error {
+	// 接口未运行时所有对等端视为离线
+	wgStatus, err := wireguard.GetWireGuardStatus(wgInterface.Name)
 	if err != nil {
-		return fmt.Errorf("获取WireGuard状态失败: %w", err)
+		wgStatus = map[string]wireguard.WireGuardPeer{}
 	}
 
-	// 获取所有模块
+	// 获取接口下的模块
 	var modules []models.Module
-	if err := ms.db.Find(&modules).Error; err != nil {
+	if err := ms.db.Where("interface_id = ?", wgInterface.ID).Find(&modules).Error; err != nil {
 		return fmt.Errorf("查询模块列表失败: %w", err)
 	}
 
 	// 更新模块状态
 	for _, module := range modules {
-		if peer, exists := wgStatus[module.PublicKey]; exists {
-			// 模块在线
-			status := models.ModuleStatusOnline
-
-			// 使用统一的超时常量检查是否长时间未握手
-			if time.Since(peer.LatestHandshake) > config.WireGuardOnlineTimeout {
-				status = models.ModuleStatusWarning
-			}
-
-			// 更新模块信息
-			ms.db.Model(&module).Updates(map[string]interface{}{
-				"status":           status,
-				"latest_handshake": peer.LatestHandshake,
-				"total_rx_bytes":   peer.TransferRxBytes,
-				"total_tx_bytes":   peer.TransferTxBytes,
-				"last_seen":        time.Now(),
-			})
-		} else {
+		peer, exists := wgStatus[module.PublicKey]
+		if !exists {
 			// 模块离线
 			ms.db.Model(&module).Updates(map[string]interface{}{
 				"status": models.ModuleStatusOffline,
 			})
+			continue
+		}
+
+		// 模块在线
+		status := models.ModuleStatusOnline
+
+		// 使用统一的超时常量检查是否长时间未握手，模块端公钥不一致时保持警告
+		if time.Since(peer.LatestHandshake) > config.WireGuardOnlineTimeout || module.PublicKeyMismatch {
+			status = models.ModuleStatusWarning
+		}
+
+		rxDelta, txDelta, err := trafficService.RecordPeer(wgInterface.ID, models.TrafficPeerModule, module.ID, module.PublicKey, peer.TransferRxBytes, peer.TransferTxBytes, now)
+		if err != nil {
+			return err
+		}
+
+		// 更新模块信息，累计流量不受接口重启影响
+		ms.db.Model(&module).Updates(map[string]interface{}{
+			"status":           status,
+			"latest_handshake": peer.LatestHandshake,
+			"total_rx_bytes":   gorm.Expr("total_rx_bytes + ?", rxDelta),
+			"total_tx_bytes":   gorm.Expr("total_tx_bytes + ?", txDelta),
+			"last_seen":        now,
+		})
+	}
+
+	// 更新接口下已激活的用户VPN
+	var userVPNs []models.UserVPN
+	if err := ms.db.Joins("JOIN modules ON user_vpns.module_id = modules.id").
+		Where("modules.interface_id = ? AND user_vpns.is_active = ?", wgInterface.ID, true).
+		Find(&userVPNs).Error; err != nil {
+		return fmt.Errorf("查询用户VPN列表失败: %w", err)
+	}
+
+	devices := make(map[uint][]models.UserVPNDevice)
+	if len(userVPNs) > 0 {
+		userVPNIDs := make([]uint, 0, len(userVPNs))
+		for _, userVPN := range userVPNs {
+			userVPNIDs = append(userVPNIDs, userVPN.ID)
+		}
+		var rows []models.UserVPNDevice
+		if err := ms.db.Where("user_vpn_id IN ?", userVPNIDs).Find(&rows).Error; err != nil {
+			return fmt.Errorf("查询用户VPN设备失败: %w", err)
+		}
+		for _, device := range rows {
+			devices[device.UserVPNID] = append(devices[device.UserVPNID], device)
+		}
+	}
+
+	for _, userVPN := range userVPNs {
+		peer, exists := wgStatus[userVPN.PublicKey]
+		online := exists && time.Since(peer.LatestHandshake) <= config.WireGuardOnlineTimeout
+
+		// 任一附加设备在线时用户也视为在线
+		deviceOnline, err := ms.syncUserDevices(wgInterface.ID, devices[userVPN.ID], wgStatus, trafficService, now)
+		if err != nil {
+			return err
+		}
+		online = online || deviceOnline
+
+		updates := map[string]interface{}{}
+		// 暂停和过期状态由管理员维护，不被同步覆盖
+		if userVPN.Status == models.UserVPNStatusOnline || userVPN.Status == models.UserVPNStatusOffline {
+			if online {
+				updates["status"] = models.UserVPNStatusOnline
+			} else {
+				updates["status"] = models.UserVPNStatusOffline
+			}
+		}
+
+		if exists {
+			rxDelta, txDelta, err := trafficService.RecordPeer(wgInterface.ID, models.TrafficPeerUserVPN, userVPN.ID, userVPN.PublicKey, peer.TransferRxBytes, peer.TransferTxBytes, now)
+			if err != nil {
+				return err
+			}
+			updates["total_rx_bytes"] = gorm.Expr("total_rx_bytes + ?", rxDelta)
+			updates["total_tx_bytes"] = gorm.Expr("total_tx_bytes + ?", txDelta)
+			if !peer.LatestHandshake.IsZero() {
+				updates["latest_handshake"] = peer.LatestHandshake
+			}
+			if online {
+				updates["last_seen"] = now
+			}
+		}
+
+		if len(updates) > 0 {
+			ms.db.Model(&userVPN).Updates(updates)
 		}
 	}
 
 	return nil
 }
 
+// syncUserDevices 同步用户VPN附加设备的握手时间和流量，返回是否有设备在线
+func (ms *ModuleService) syncUserDevices(interfaceID uint, devices []models.UserVPNDevice, wgStatus map[string]wireguard.WireGuardPeer, trafficService *TrafficService, now time.Time) (bool, error) {
+	anyOnline := false
+	for _, device := range devices {
+		peer, exists := wgStatus[device.PublicKey]
+		if !exists {
+			continue
+		}
+		online := time.Since(peer.LatestHandshake) <= config.WireGuardOnlineTimeout
+		anyOnline = anyOnline || online
+
+		rxDelta, txDelta, err := trafficService.RecordPeer(interfaceID, models.TrafficPeerUserDevice, device.ID, device.PublicKey, peer.TransferRxBytes, peer.TransferTxBytes, now)
+		if err != nil {
+			return false, err
+		}
+		updates := map[string]interface{}{
+			"total_rx_bytes": gorm.Expr("total_rx_bytes + ?", rxDelta),
+			"total_tx_bytes": gorm.Expr("total_tx_bytes + ?", txDelta),
+		}
+		if !peer.LatestHandshake.IsZero() {
+			updates["latest_handshake"] = peer.LatestHandshake
+		}
+		if online {
+			updates["last_seen"] = now
+		}
+		ms.db.Model(&device).Updates(updates)
+	}
+	return anyOnline, nil
+}
+
 // RegenerateModuleKeys 重新生成模块密钥
 func (ms *ModuleService) RegenerateModuleKeys(id uint) (*models.Module, error) {
 	// 检查模块是否存在
@@ -785,86 +955,6 @@ func (ms *ModuleService) RegenerateModuleKeys(id uint) (*models.Module, error) {
 	return ms.GetModule(id)
 }
 
-// getAvailableIPForInterface 为指定接口获取可用IP
-func (ms *ModuleService) getAvailableIPForInterface(interfaceID uint) (string, error) {
-	// 获取接口信息
-	var wgInterface models.WireGuardInterface
-	if err := ms.db.First(&wgInterface, interfaceID).Error; err != nil {
-		return "", fmt.Errorf("查询接口失败: %w", err)
-	}
-
-	// 从接口对应的网络段中获取可用IP
-	var ipPool models.IPPool
-	if err := ms.db.Where("network = ? AND is_used = ?", wgInterface.Network, false).First(&ipPool).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return "", fmt.Errorf("接口 %s 没有可用的IP地址", wgInterface.Name)
-		}
-		return "", fmt.Errorf("查询可用IP失败: %w", err)
-	}
-
-	return ipPool.IPAddress, nil
-}
-
-// allocateIPForInterface 为指定接口分配IP地址给模块
-func (ms *ModuleService) allocateIPForInterface(interfaceID uint, ip string, moduleID uint) error {
-	// 获取接口信息
-	var wgInterface models.WireGuardInterface
-	if err := ms.db.First(&wgInterface, interfaceID).Error; err != nil {
-		return fmt.Errorf("查询接口失败: %w", err)
-	}
-
-	// 检查IP是否已被使用
-	var existingIP models.IPPool
-	if err := ms.db.Where("network = ? AND ip_address = ?", wgInterface.Network, ip).First(&existingIP).Error; err == nil {
-		// IP已存在，检查是否可用
-		if existingIP.IsUsed {
-			return fmt.Errorf("IP地址 %s 已被使用", ip)
-		}
-		// 标记为已使用
-		if err := ms.db.Model(&existingIP).Updates(map[string]interface{}{
-			"is_used":   true,
-			"module_id": moduleID,
-		}).Error; err != nil {
-			return fmt.Errorf("更新IP池失败: %w", err)
-		}
-	} else if err == gorm.ErrRecordNotFound {
-		// IP不存在，创建新记录
-		newIPPool := models.IPPool{
-			Network:   wgInterface.Network,
-			IPAddress: ip,
-			IsUsed:    true,
-			ModuleID:  &moduleID,
-		}
-		if err := ms.db.Create(&newIPPool).Error; err != nil {
-			return fmt.Errorf("创建IP池记录失败: %w", err)
-		}
-	} else {
-		return fmt.Errorf("查询IP池失败: %w", err)
-	}
-
-	return nil
-}
-
-// releaseIPForInterface 释放指定接口的IP地址
-func (ms *ModuleService) releaseIPForInterface(interfaceID uint, ip string) error {
-	// 获取接口信息
-	var wgInterface models.WireGuardInterface
-	if err := ms.db.First(&wgInterface, interfaceID).Error; err != nil {
-		return fmt.Errorf("查询接口失败: %w", err)
-	}
-
-	// 硬删除IP池记录
-	result := ms.db.Unscoped().
-		Where("network = ? AND ip_address = ?", wgInterface.Network, ip).
-		Delete(&models.IPPool{})
-
-	if result.Error != nil {
-		return fmt.Errorf("释放IP地址失败: %w", result.Error)
-	}
-
-	return nil
-}
-
 // updateInterfaceConfig 更新WireGuard接口配置
 func (ms *ModuleService) updateInterfaceConfig(interfaceID uint) error {
 	// 获取接口信息
@@ -878,7 +968,7 @@ func (ms *ModuleService) updateInterfaceConfig(interfaceID uint) error {
 
 	// 重新生成配置文件（无论接口状态如何都要更新）
 	configContent := interfaceService.GenerateInterfaceConfig(&wgInterface)
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", wgInterface.Name)
+	configPath := wireguard.ConfigPath(wgInterface.Name)
 
 	// 写入配置文件
 	if err := wireguard.WriteConfigFile(configPath, configContent); err != nil {
@@ -887,13 +977,19 @@ func (ms *ModuleService) updateInterfaceConfig(interfaceID uint) error {
 
 	fmt.Printf("✅ 成功更新接口 %s 的配置文件: %s\n", wgInterface.Name, configPath)
 
-	// 只有当接口正在运行时才重新加载WireGuard
+	// 只有当接口正在运行时才同步对等端，只增删改有变化的Peer，不中断其他隧道
 	if wgInterface.Status == models.InterfaceStatusUp {
-		if err := wireguard.RestartWireGuard(wgInterface.Name); err != nil {
-			fmt.Printf("⚠️  重新加载WireGuard配置失败（但配置文件已更新）: %v\n", err)
-			// 不返回错误，因为配置文件已经更新成功
-		} else {
-			fmt.Printf("🔄 已重新加载WireGuard配置: %s\n", wgInterface.Name)
+		result, err := interfaceService.ReconcilePeers(&wgInterface)
+		if err != nil {
+			fmt.Printf("⚠️  热同步对等端失败，改为重启接口: %v\n", err)
+			if err := wireguard.RestartWireGuard(wgInterface.Name); err != nil {
+				fmt.Printf("⚠️  重新加载WireGuard配置失败（但配置文件已更新）: %v\n", err)
+				// 不返回错误，因为配置文件已经更新成功
+			} else {
+				fmt.Printf("🔄 已重新加载WireGuard配置: %s\n", wgInterface.Name)
+			}
+		} else if result.Changed() {
+			fmt.Printf("🔄 已同步接口 %s 的对等端: %s\n", wgInterface.Name, result)
 		}
 	} else {
 		fmt.Printf("💡 接口 %s 未运行，仅更新配置文件（启动时将自动应用）\n", wgInterface.Name)
@@ -0,0 +1,499 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/ipaddr"
+	"eitec-vpn/internal/shared/wgconf"
+
+	"gorm.io/gorm"
+)
+
+// RenumberService 修改模块、用户VPN的地址和接口重新编址
+type RenumberService struct {
+	db *gorm.DB
+}
+
+// familyPlan 单个地址族（IPv4或IPv6）的重新编址计划
+type familyPlan struct {
+	column         string // 对等端记录地址的字段，ip_address或ip_address6
+	oldNetwork     string
+	newNetwork     string
+	serverIP       string
+	holders        []ipHolder
+	addresses      []string // 与holders一一对应的新地址
+	reserved       []models.IPAllocation
+	reservations   []models.IPReservation
+	reservationIDs []uint // 旧网段内的保留范围，编址后删除
+}
+
+// NewRenumberService 创建重新编址服务
+func NewRenumberService() *RenumberService {
+	return &RenumberService{
+		db: database.DB,
+	}
+}
+
+// ChangeModuleAddress 修改模块的VPN地址
+func (rs *RenumberService) ChangeModuleAddress(moduleID uint, req *models.PeerAddressRequest) (*models.RenumberResult, error) {
+	var module models.Module
+	if err := rs.db.First(&module, moduleID).Error; err != nil {
+		return nil, fmt.Errorf("模块不存在: %w", err)
+	}
+	return rs.changeAddress(module.InterfaceID, ModuleOwner(module.ID), module.Name, module.IPAddress, module.IPAddress6, req)
+}
+
+// ChangeUserVPNAddress 修改用户VPN的地址
+func (rs *RenumberService) ChangeUserVPNAddress(userVPNID uint, req *models.PeerAddressRequest) (*models.RenumberResult, error) {
+	var userVPN models.UserVPN
+	if err := rs.db.Preload("Module").First(&userVPN, userVPNID).Error; err != nil {
+		return nil, fmt.Errorf("用户VPN不存在: %w", err)
+	}
+	if userVPN.Module == nil {
+		return nil, errors.New("用户VPN所属模块不存在")
+	}
+	return rs.changeAddress(userVPN.Module.InterfaceID, UserVPNOwner(userVPN.ID), userVPN.Username, userVPN.IPAddress, userVPN.IPAddress6, req)
+}
+
+// changeAddress 为持有者占用新地址并释放旧地址，地址和分配记录在同一事务中更新，完成后热同步接口对等端
+func (rs *RenumberService) changeAddress(interfaceID uint, owner IPOwner, name, ipAddress, ipAddress6 string, req *models.PeerAddressRequest) (*models.RenumberResult, error) {
+	var wgInterface models.WireGuardInterface
+	if err := rs.db.First(&wgInterface, interfaceID).Error; err != nil {
+		return nil, fmt.Errorf("查询接口失败: %w", err)
+	}
+	result := newRenumberResult(&wgInterface)
+
+	holders := []ipHolder{
+		{owner: owner, name: name, address: ipAddress, column: "ip_address"},
+		{owner: owner, name: name, address: ipAddress6, column: "ip_address6"},
+	}
+	requested := []string{req.IPAddress, req.IPAddress6}
+	networks := []string{wgInterface.Network, wgInterface.Network6}
+
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		ipam := &IPAMService{db: tx}
+		for i := range holders {
+			holder := &holders[i]
+			address := requested[i]
+			if address == "" || address == holder.address {
+				continue
+			}
+
+			var err error
+			if address == models.AddressAuto {
+				if address, err = ipam.Allocate(&wgInterface, networks[i]); err != nil {
+					return err
+				}
+			} else if err = ipam.Claim(&wgInterface, address); err != nil {
+				return err
+			}
+			address = canonicalIP(address)
+			if err := ipam.Assign(wgInterface.ID, owner, address); err != nil {
+				return err
+			}
+			if holder.address != "" {
+				if err := tx.Where("interface_id = ? AND ip_address = ? AND owner_type = ? AND owner_id = ?",
+					wgInterface.ID, holder.address, owner.Type, owner.ID).Delete(&models.IPAllocation{}).Error; err != nil {
+					return fmt.Errorf("释放旧地址失败: %w", err)
+				}
+			}
+			if err := updateHolderAddress(tx, holder, address); err != nil {
+				return err
+			}
+			addRenumberChange(result, owner, name, holder.address, address)
+		}
+		if len(result.Changes) == 0 {
+			return errors.New("地址没有变化")
+		}
+		return markConfigRefresh(tx, owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Applied = true
+
+	if err := NewModuleService().updateInterfaceConfig(wgInterface.ID); err != nil {
+		fmt.Printf("⚠️ 更新接口配置失败: %v\n", err)
+	}
+	return result, nil
+}
+
+// RenumberInterface 把接口迁移到新网段：服务器地址取新网段第一个地址，
+// 对等端尽量保持在网段内的序号不变，放不下的依次分配空闲地址。
+// DryRun时只返回计划；否则在同一事务中更新接口、对等端、分配记录和保留范围，
+// 重新生成服务端配置，并标记接口下的模块和用户VPN需要更新配置
+func (rs *RenumberService) RenumberInterface(interfaceID uint, req *models.InterfaceRenumberRequest) (*models.RenumberResult, error) {
+	var result *models.RenumberResult
+	var plans []*familyPlan
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		var wgInterface models.WireGuardInterface
+		if err := tx.First(&wgInterface, interfaceID).Error; err != nil {
+			return fmt.Errorf("接口不存在: %w", err)
+		}
+
+		var err error
+		if result, plans, err = planRenumber(tx, &wgInterface, req); err != nil {
+			return err
+		}
+		if req.DryRun {
+			return nil
+		}
+		return applyRenumber(tx, &wgInterface, plans)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if req.DryRun {
+		return result, nil
+	}
+	result.Applied = true
+
+	// 接口地址变化需要重新加载接口，对等端随配置文件一起更新
+	if err := NewWireGuardInterfaceService().UpdateInterfaceConfig(interfaceID); err != nil {
+		result.Warnings = append(result.Warnings, "更新接口配置失败: "+err.Error())
+	}
+	return result, nil
+}
+
+// planRenumber 校验新网段并生成各地址族的编址计划
+func planRenumber(db *gorm.DB, wgInterface *models.WireGuardInterface, req *models.InterfaceRenumberRequest) (*models.RenumberResult, []*familyPlan, error) {
+	result := newRenumberResult(wgInterface)
+	var plans []*familyPlan
+
+	if req.Network != "" {
+		network, err := validateRenumberNetwork(db, wgInterface, req.Network, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if network != wgInterface.Network {
+			plan, err := planFamily(db, wgInterface, "ip_address", network, result)
+			if err != nil {
+				return nil, nil, err
+			}
+			plans = append(plans, plan)
+			result.Network = network
+		}
+	}
+	if req.Network6 != "" {
+		if !wgInterface.DualStack() {
+			return nil, nil, fmt.Errorf("接口 %s 未启用IPv6", wgInterface.Name)
+		}
+		network6, err := validateRenumberNetwork(db, wgInterface, req.Network6, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		if network6 != wgInterface.Network6 {
+			plan, err := planFamily(db, wgInterface, "ip_address6", network6, result)
+			if err != nil {
+				return nil, nil, err
+			}
+			plans = append(plans, plan)
+			result.Network6 = network6
+		}
+	}
+	if len(plans) == 0 {
+		return nil, nil, errors.New("网段没有变化")
+	}
+
+	// 自定义的启停命令中出现旧网段时需要手动修改
+	for _, plan := range plans {
+		for _, command := range []string{wgInterface.PreUp, wgInterface.PostUp, wgInterface.PreDown, wgInterface.PostDown} {
+			if strings.Contains(command, plan.oldNetwork) {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("自定义启停命令中包含旧网段 %s，请手动修改", plan.oldNetwork))
+				break
+			}
+		}
+	}
+	return result, plans, nil
+}
+
+// validateRenumberNetwork 校验新网段：IPv4须为私有网段，IPv6须为ULA网段，且不能与其他接口的网段重叠
+func validateRenumberNetwork(db *gorm.DB, wgInterface *models.WireGuardInterface, network string, ipv6 bool) (string, error) {
+	ip, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return "", fmt.Errorf("无效的网络段格式: %w", err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ipv6 {
+		if ip.To4() != nil || !ipaddr.IsULA(ip) {
+			return "", errors.New("必须使用IPv6唯一本地地址网段 (fc00::/7)")
+		}
+		if ones < 48 || ones > 124 {
+			return "", errors.New("IPv6网段前缀长度必须在48到124之间")
+		}
+	} else {
+		if ip.To4() == nil || !ip.IsPrivate() {
+			return "", errors.New("必须使用私有IPv4网段")
+		}
+		if ones > 30 {
+			return "", errors.New("IPv4网段前缀长度不能大于30")
+		}
+	}
+
+	var others []models.WireGuardInterface
+	if err := db.Where("id <> ?", wgInterface.ID).Find(&others).Error; err != nil {
+		return "", fmt.Errorf("查询接口列表失败: %w", err)
+	}
+	for _, other := range others {
+		otherNetwork := other.Network
+		if ipv6 {
+			otherNetwork = other.Network6
+		}
+		if _, otherNet, err := net.ParseCIDR(otherNetwork); err == nil && (otherNet.Contains(ipNet.IP) || ipNet.Contains(otherNet.IP)) {
+			return "", fmt.Errorf("网段与接口 %s 的网段 %s 重叠", other.Name, otherNetwork)
+		}
+	}
+	return ipNet.String(), nil
+}
+
+// planFamily 计算一个地址族在新网段中的地址。手动占用的地址和保留范围按序号平移，放不下时删除并给出提示
+func planFamily(db *gorm.DB, wgInterface *models.WireGuardInterface, column, newNetwork string, result *models.RenumberResult) (*familyPlan, error) {
+	plan := &familyPlan{column: column, oldNetwork: wgInterface.Network, newNetwork: newNetwork}
+	if column == "ip_address6" {
+		plan.oldNetwork = wgInterface.Network6
+	}
+	_, oldNet, err := net.ParseCIDR(plan.oldNetwork)
+	if err != nil {
+		return nil, fmt.Errorf("无效的网段 %s: %w", plan.oldNetwork, err)
+	}
+	_, newNet, _ := net.ParseCIDR(newNetwork)
+	first, last, ok := usableRange(newNet)
+	if !ok {
+		return nil, fmt.Errorf("网段 %s 没有可分配的地址", newNetwork)
+	}
+
+	// 服务器地址固定为新网段第一个地址
+	plan.serverIP = ipaddr.Nth(newNet, first).String()
+	oldServerIP := serverAddress(wgInterface, plan.oldNetwork)
+	addRenumberChange(result, IPOwner{models.IPOwnerGateway, wgInterface.ID}, wgInterface.Name, oldServerIP, plan.serverIP)
+	occupied := []addressSpan{{first, first}}
+
+	// translate 旧地址在新网段中同序号的地址，超出可分配范围时返回false
+	translate := func(address string) (uint64, bool) {
+		offset, ok := ipaddr.Offset(oldNet, net.ParseIP(address))
+		return offset, ok && offset >= first && offset <= last
+	}
+
+	var reservations []models.IPReservation
+	if err := db.Where("interface_id = ?", wgInterface.ID).Order("id").Find(&reservations).Error; err != nil {
+		return nil, fmt.Errorf("查询保留地址失败: %w", err)
+	}
+	var reservedSpans []addressSpan
+	for _, reservation := range reservations {
+		span, ok := reservationSpan(oldNet, &reservation)
+		if !ok {
+			continue
+		}
+		plan.reservationIDs = append(plan.reservationIDs, reservation.ID)
+		if span.start < first || span.end > last {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("保留范围 %s-%s 超出新网段，将被删除", reservation.StartIP, reservation.EndIP))
+			continue
+		}
+		reservedSpans = append(reservedSpans, span)
+		plan.reservations = append(plan.reservations, models.IPReservation{
+			InterfaceID: wgInterface.ID,
+			StartIP:     ipaddr.Nth(newNet, span.start).String(),
+			EndIP:       ipaddr.Nth(newNet, span.end).String(),
+			Description: reservation.Description,
+		})
+	}
+
+	var reserved []models.IPAllocation
+	if err := db.Where("interface_id = ? AND network = ? AND owner_type = ?", wgInterface.ID, plan.oldNetwork, models.IPOwnerReserved).
+		Order("id").Find(&reserved).Error; err != nil {
+		return nil, fmt.Errorf("查询手动占用的地址失败: %w", err)
+	}
+	for _, allocation := range reserved {
+		offset, ok := translate(allocation.IPAddress)
+		if !ok || inSpans(offset, occupied) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("手动占用的地址 %s 在新网段中没有对应地址，将被释放", allocation.IPAddress))
+			continue
+		}
+		occupied = append(occupied, addressSpan{offset, offset})
+		newAddress := ipaddr.Nth(newNet, offset).String()
+		addRenumberChange(result, IPOwner{models.IPOwnerReserved, 0}, allocation.Description, allocation.IPAddress, newAddress)
+		plan.reserved = append(plan.reserved, models.IPAllocation{
+			InterfaceID: wgInterface.ID,
+			Network:     newNetwork,
+			IPAddress:   newAddress,
+			OwnerType:   models.IPOwnerReserved,
+			Description: allocation.Description,
+		})
+	}
+
+	holders, err := collectHolders(db, wgInterface)
+	if err != nil {
+		return nil, err
+	}
+	var pending []int
+	for _, holder := range holders {
+		if holder.column != column || holder.owner.Type == models.IPOwnerGateway {
+			continue
+		}
+		plan.holders = append(plan.holders, holder)
+		plan.addresses = append(plan.addresses, "")
+		offset, ok := translate(holder.address)
+		if !ok || inSpans(offset, occupied) {
+			pending = append(pending, len(plan.holders)-1)
+			continue
+		}
+		occupied = append(occupied, addressSpan{offset, offset})
+		plan.addresses[len(plan.holders)-1] = ipaddr.Nth(newNet, offset).String()
+	}
+
+	// 放不下的对等端按顺序分配空闲地址，跳过保留范围
+	for _, i := range pending {
+		offset, ok := firstFree(first, last, append(append([]addressSpan(nil), occupied...), reservedSpans...))
+		if !ok {
+			return nil, fmt.Errorf("新网段 %s 的地址不足以容纳接口下的全部对等端", newNetwork)
+		}
+		occupied = append(occupied, addressSpan{offset, offset})
+		plan.addresses[i] = ipaddr.Nth(newNet, offset).String()
+	}
+	for i, holder := range plan.holders {
+		addRenumberChange(result, holder.owner, holder.name, holder.address, plan.addresses[i])
+	}
+	return plan, nil
+}
+
+// applyRenumber 写入编址计划：接口网段和服务器地址、对等端地址、分配记录和保留范围
+func applyRenumber(tx *gorm.DB, wgInterface *models.WireGuardInterface, plans []*familyPlan) error {
+	updates := make(map[string]interface{})
+	for _, plan := range plans {
+		if plan.column == "ip_address6" {
+			updates["network6"], updates["server_ip6"] = plan.newNetwork, plan.serverIP
+		} else {
+			updates["network"], updates["server_ip"] = plan.newNetwork, plan.serverIP
+		}
+	}
+	if err := tx.Model(&models.WireGuardInterface{}).Where("id = ?", wgInterface.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("更新接口网段失败: %w", err)
+	}
+
+	for _, plan := range plans {
+		if err := tx.Where("interface_id = ? AND network = ?", wgInterface.ID, plan.oldNetwork).
+			Delete(&models.IPAllocation{}).Error; err != nil {
+			return fmt.Errorf("删除旧地址分配记录失败: %w", err)
+		}
+		if len(plan.reservationIDs) > 0 {
+			if err := tx.Delete(&models.IPReservation{}, plan.reservationIDs).Error; err != nil {
+				return fmt.Errorf("删除旧保留范围失败: %w", err)
+			}
+		}
+
+		allocations := append([]models.IPAllocation{{
+			InterfaceID: wgInterface.ID,
+			Network:     plan.newNetwork,
+			IPAddress:   plan.serverIP,
+			OwnerType:   models.IPOwnerGateway,
+			OwnerID:     wgInterface.ID,
+		}}, plan.reserved...)
+		for i := range plan.holders {
+			holder := &plan.holders[i]
+			allocations = append(allocations, models.IPAllocation{
+				InterfaceID: wgInterface.ID,
+				Network:     plan.newNetwork,
+				IPAddress:   plan.addresses[i],
+				OwnerType:   holder.owner.Type,
+				OwnerID:     holder.owner.ID,
+			})
+			if plan.addresses[i] != holder.address {
+				if err := updateHolderAddress(tx, holder, plan.addresses[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.CreateInBatches(allocations, 100).Error; err != nil {
+			return fmt.Errorf("写入地址分配记录失败: %w", err)
+		}
+		if len(plan.reservations) > 0 {
+			if err := tx.Create(&plan.reservations).Error; err != nil {
+				return fmt.Errorf("写入保留范围失败: %w", err)
+			}
+		}
+	}
+
+	// 对等端配置中包含接口网段，网段变化后全部需要更新
+	var moduleIDs []uint
+	if err := tx.Model(&models.Module{}).Where("interface_id = ?", wgInterface.ID).Pluck("id", &moduleIDs).Error; err != nil {
+		return fmt.Errorf("查询接口模块失败: %w", err)
+	}
+	if len(moduleIDs) == 0 {
+		return nil
+	}
+	if err := tx.Model(&models.Module{}).Where("id IN ?", moduleIDs).Update("config_refresh_required", true).Error; err != nil {
+		return fmt.Errorf("标记模块配置更新失败: %w", err)
+	}
+	if err := tx.Model(&models.UserVPN{}).Where("module_id IN ?", moduleIDs).Update("config_refresh_required", true).Error; err != nil {
+		return fmt.Errorf("标记用户VPN配置更新失败: %w", err)
+	}
+	if err := tx.Model(&models.UserVPNDevice{}).
+		Where("user_vpn_id IN (?)", tx.Model(&models.UserVPN{}).Select("id").Where("module_id IN ?", moduleIDs)).
+		Update("config_refresh_required", true).Error; err != nil {
+		return fmt.Errorf("标记用户VPN设备配置更新失败: %w", err)
+	}
+
+	// 用户VPN创建时把接口网段写入了AllowedIPs，需要替换为新网段
+	var userVPNs []models.UserVPN
+	if err := tx.Select("id", "allowed_ips").Where("module_id IN ?", moduleIDs).Find(&userVPNs).Error; err != nil {
+		return fmt.Errorf("查询用户VPN失败: %w", err)
+	}
+	for _, userVPN := range userVPNs {
+		entries := wgconf.SplitList(userVPN.AllowedIPs)
+		changed := false
+		for i, entry := range entries {
+			for _, plan := range plans {
+				if entry == plan.oldNetwork {
+					entries[i], changed = plan.newNetwork, true
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := tx.Model(&models.UserVPN{}).Where("id = ?", userVPN.ID).
+			Update("allowed_ips", strings.Join(entries, ", ")).Error; err != nil {
+			return fmt.Errorf("更新用户VPN AllowedIPs失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// markConfigRefresh 标记持有者需要更新配置
+func markConfigRefresh(db *gorm.DB, owner IPOwner) error {
+	if err := db.Model(ownerModel(owner)).Where("id = ?", owner.ID).Update("config_refresh_required", true).Error; err != nil {
+		return fmt.Errorf("标记配置更新失败: %w", err)
+	}
+	return nil
+}
+
+// newRenumberResult 以接口当前网段初始化编址结果
+func newRenumberResult(wgInterface *models.WireGuardInterface) *models.RenumberResult {
+	return &models.RenumberResult{
+		InterfaceID: wgInterface.ID,
+		Interface:   wgInterface.Name,
+		Network:     wgInterface.Network,
+		Network6:    wgInterface.Network6,
+		Changes:     []models.RenumberChange{},
+		Warnings:    []string{},
+	}
+}
+
+// addRenumberChange 记录地址变化，地址不变时忽略
+func addRenumberChange(result *models.RenumberResult, owner IPOwner, name, oldAddress, newAddress string) {
+	if oldAddress == newAddress {
+		return
+	}
+	result.Changes = append(result.Changes, models.RenumberChange{
+		OwnerType:  owner.Type,
+		OwnerID:    owner.ID,
+		Name:       name,
+		OldAddress: oldAddress,
+		NewAddress: newAddress,
+	})
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 流量历史查询限制
+const (
+	MaxTrafficPoints = 2000 // 单次查询最多返回的数据点数
+)
+
+// trafficResolutions 流量汇总粒度，由细到粗
+var trafficResolutions = []models.TrafficResolution{
+	models.TrafficResolutionMinute,
+	models.TrafficResolutionHour,
+	models.TrafficResolutionDay,
+}
+
+// TrafficService 流量历史服务：记录对等端流量增量并按1分钟/1小时/1天汇总
+type TrafficService struct {
+	db        *gorm.DB
+	retention map[models.TrafficResolution]time.Duration
+}
+
+// NewTrafficService 创建流量历史服务
+func NewTrafficService() *TrafficService {
+	ts := &TrafficService{
+		db: database.DB,
+		retention: map[models.TrafficResolution]time.Duration{
+			models.TrafficResolutionMinute: 48 * time.Hour,
+			models.TrafficResolutionHour:   90 * 24 * time.Hour,
+			models.TrafficResolutionDay:    730 * 24 * time.Hour,
+		},
+	}
+
+	if cfg := getGlobalConfig(); cfg != nil {
+		if cfg.Traffic.MinuteRetention > 0 {
+			ts.retention[models.TrafficResolutionMinute] = cfg.Traffic.MinuteRetention
+		}
+		if cfg.Traffic.HourRetention > 0 {
+			ts.retention[models.TrafficResolutionHour] = cfg.Traffic.HourRetention
+		}
+		if cfg.Traffic.DayRetention > 0 {
+			ts.retention[models.TrafficResolutionDay] = cfg.Traffic.DayRetention
+		}
+	}
+
+	return ts
+}
+
+// RecordPeer 记录一次读取到的对等端计数器，返回与上次读取相比的增量。
+// 对等端加入接口时计数器从零开始，因此首次读取、计数器变小或公钥变化（计数器重置）时当前计数即为增量
+func (ts *TrafficService) RecordPeer(interfaceID uint, peerType string, peerID uint, publicKey string, rxBytes, txBytes uint64, at time.Time) (uint64, uint64, error) {
+	var rxDelta, txDelta uint64
+
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		var counter models.TrafficCounter
+		err := tx.Where("peer_type = ? AND peer_id = ?", peerType, peerID).First(&counter).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			counter = models.TrafficCounter{PeerType: peerType, PeerID: peerID}
+			rxDelta, txDelta = rxBytes, txBytes
+		case err != nil:
+			return fmt.Errorf("查询流量计数器失败: %w", err)
+		case counter.PublicKey != publicKey || rxBytes < counter.RxBytes || txBytes < counter.TxBytes:
+			rxDelta, txDelta = rxBytes, txBytes
+		default:
+			rxDelta, txDelta = rxBytes-counter.RxBytes, txBytes-counter.TxBytes
+		}
+
+		counter.PublicKey = publicKey
+		counter.RxBytes = rxBytes
+		counter.TxBytes = txBytes
+		counter.SampledAt = at
+		if err := tx.Save(&counter).Error; err != nil {
+			return fmt.Errorf("保存流量计数器失败: %w", err)
+		}
+
+		if rxDelta == 0 && txDelta == 0 {
+			return nil
+		}
+		return ts.addSamples(tx, interfaceID, peerType, peerID, rxDelta, txDelta, at)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return rxDelta, txDelta, nil
+}
+
+// addSamples 将增量累加到各粒度的时间段中
+func (ts *TrafficService) addSamples(tx *gorm.DB, interfaceID uint, peerType string, peerID uint, rxBytes, txBytes uint64, at time.Time) error {
+	for _, resolution := range trafficResolutions {
+		sample := models.TrafficSample{
+			PeerType:    peerType,
+			PeerID:      peerID,
+			Resolution:  resolution,
+			BucketStart: at.UTC().Truncate(resolution.Duration()),
+			InterfaceID: interfaceID,
+			RxBytes:     rxBytes,
+			TxBytes:     txBytes,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "peer_type"}, {Name: "peer_id"}, {Name: "resolution"}, {Name: "bucket_start"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "rx_bytes"}, Value: gorm.Expr("rx_bytes + ?", rxBytes)},
+				{Column: clause.Column{Name: "tx_bytes"}, Value: gorm.Expr("tx_bytes + ?", txBytes)},
+			},
+		}).Create(&sample).Error; err != nil {
+			return fmt.Errorf("记录流量采样失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// ResetInterfaceCounters 接口重新启动后计数器从零开始，将该接口对等端的基线清零
+func (ts *TrafficService) ResetInterfaceCounters(interfaceID uint) error {
+	var moduleIDs []uint
+	if err := ts.db.Model(&models.Module{}).Where("interface_id = ?", interfaceID).Pluck("id", &moduleIDs).Error; err != nil {
+		return fmt.Errorf("查询接口模块失败: %w", err)
+	}
+	if len(moduleIDs) == 0 {
+		return nil
+	}
+
+	var userVPNIDs []uint
+	if err := ts.db.Model(&models.UserVPN{}).Where("module_id IN ?", moduleIDs).Pluck("id", &userVPNIDs).Error; err != nil {
+		return fmt.Errorf("查询接口用户VPN失败: %w", err)
+	}
+
+	reset := map[string]interface{}{"rx_bytes": 0, "tx_bytes": 0}
+	if err := ts.db.Model(&models.TrafficCounter{}).
+		Where("peer_type = ? AND peer_id IN ?", models.TrafficPeerModule, moduleIDs).
+		Updates(reset).Error; err != nil {
+		return fmt.Errorf("重置流量计数器失败: %w", err)
+	}
+	if len(userVPNIDs) > 0 {
+		if err := ts.db.Model(&models.TrafficCounter{}).
+			Where("peer_type = ? AND peer_id IN ?", models.TrafficPeerUserVPN, userVPNIDs).
+			Updates(reset).Error; err != nil {
+			return fmt.Errorf("重置流量计数器失败: %w", err)
+		}
+		if err := ts.db.Model(&models.TrafficCounter{}).
+			Where("peer_type = ? AND peer_id IN (?)", models.TrafficPeerUserDevice,
+				ts.db.Model(&models.UserVPNDevice{}).Select("id").Where("user_vpn_id IN ?", userVPNIDs)).
+			Updates(reset).Error; err != nil {
+			return fmt.Errorf("重置流量计数器失败: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Prune 按保留时长清理过期的流量历史
+func (ts *TrafficService) Prune(now time.Time) (int64, error) {
+	var total int64
+	for _, resolution := range trafficResolutions {
+		cutoff := now.UTC().Add(-ts.retention[resolution])
+		result := ts.db.Where("resolution = ? AND bucket_start < ?", resolution, cutoff).Delete(&models.TrafficSample{})
+		if result.Error != nil {
+			return total, fmt.Errorf("清理%s粒度流量历史失败: %w", resolution, result.Error)
+		}
+		total += result.RowsAffected
+	}
+	return total, nil
+}
+
+// TrafficQuery 流量历史查询条件。PeerType为空且InterfaceID为0时查询全部对等端
+type TrafficQuery struct {
+	PeerType    string
+	PeerID      uint
+	InterfaceID uint
+	From        time.Time
+	To          time.Time
+	Resolution  models.TrafficResolution // 为空时按时间范围自动选择
+}
+
+// Query 查询时间范围内的流量历史，没有流量的时间段以零值补齐
+func (ts *TrafficService) Query(query *TrafficQuery) (*models.TrafficHistory, error) {
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-24 * time.Hour)
+	}
+	if !query.From.Before(query.To) {
+		return nil, errors.New("开始时间必须早于结束时间")
+	}
+
+	resolution := query.Resolution
+	if resolution == "" {
+		resolution = ts.chooseResolution(query.From, query.To)
+	}
+	step := resolution.Duration()
+	if step == 0 {
+		return nil, fmt.Errorf("不支持的汇总粒度: %s", resolution)
+	}
+
+	from := query.From.UTC().Truncate(step)
+	to := query.To.UTC()
+	count := int(to.Sub(from)/step) + 1
+	if count > MaxTrafficPoints {
+		return nil, fmt.Errorf("时间范围内数据点过多(%d)，请缩小范围或使用更粗的粒度", count)
+	}
+
+	db := ts.db.Model(&models.TrafficSample{}).
+		Select("bucket_start, SUM(rx_bytes) AS rx_bytes, SUM(tx_bytes) AS tx_bytes").
+		Where("resolution = ? AND bucket_start >= ? AND bucket_start <= ?", resolution, from, to)
+	if query.PeerType != "" {
+		db = db.Where("peer_type = ? AND peer_id = ?", query.PeerType, query.PeerID)
+	}
+	if query.InterfaceID != 0 {
+		db = db.Where("interface_id = ?", query.InterfaceID)
+	}
+
+	var rows []struct {
+		BucketStart time.Time
+		RxBytes     uint64
+		TxBytes     uint64
+	}
+	if err := db.Group("bucket_start").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("查询流量历史失败: %w", err)
+	}
+
+	buckets := make(map[int64]models.TrafficPoint, len(rows))
+	for _, row := range rows {
+		buckets[row.BucketStart.Unix()] = models.TrafficPoint{RxBytes: row.RxBytes, TxBytes: row.TxBytes}
+	}
+
+	history := &models.TrafficHistory{
+		PeerType:    query.PeerType,
+		PeerID:      query.PeerID,
+		InterfaceID: query.InterfaceID,
+		Resolution:  resolution,
+		From:        from,
+		To:          to,
+		Points:      make([]models.TrafficPoint, 0, count),
+	}
+	for bucket := from; !bucket.After(to); bucket = bucket.Add(step) {
+		point := buckets[bucket.Unix()]
+		point.Time = bucket
+		history.TotalRx += point.RxBytes
+		history.TotalTx += point.TxBytes
+		history.Points = append(history.Points, point)
+	}
+
+	return history, nil
+}
+
+// chooseResolution 选择在保留时长内且数据点不超过上限的最细粒度
+func (ts *TrafficService) chooseResolution(from, to time.Time) models.TrafficResolution {
+	span := to.Sub(from)
+	age := time.Since(from)
+	for _, resolution := range trafficResolutions {
+		if age <= ts.retention[resolution] && int(span/resolution.Duration()) < MaxTrafficPoints {
+			return resolution
+		}
+	}
+	return models.TrafficResolutionDay
+}
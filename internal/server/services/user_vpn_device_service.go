@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/wireguard"
+
+	"gorm.io/gorm"
+)
+
+// ErrMaxDevicesReached 用户的设备数已达到MaxDevices
+var ErrMaxDevicesReached = errors.New("已达到最大设备数")
+
+// GetDevices 获取用户VPN的附加设备
+func (uvs *UserVPNService) GetDevices(userVPNID uint) ([]models.UserVPNDevice, error) {
+	if _, err := uvs.GetUserVPN(userVPNID); err != nil {
+		return nil, err
+	}
+
+	var devices []models.UserVPNDevice
+	if err := uvs.db.Where("user_vpn_id = ?", userVPNID).Order("id").Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("查询用户VPN设备失败: %w", err)
+	}
+	return devices, nil
+}
+
+// GetDevice 获取用户VPN的单个附加设备
+func (uvs *UserVPNService) GetDevice(userVPNID, deviceID uint) (*models.UserVPNDevice, error) {
+	var device models.UserVPNDevice
+	if err := uvs.db.Where("id = ? AND user_vpn_id = ?", deviceID, userVPNID).First(&device).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("设备不存在")
+		}
+		return nil, fmt.Errorf("查询用户VPN设备失败: %w", err)
+	}
+	return &device, nil
+}
+
+// CreateDevice 为用户VPN添加一台设备：生成独立的密钥，从接口分配地址。
+// 用户自身的密钥算作第一台设备，设备总数不超过MaxDevices
+func (uvs *UserVPNService) CreateDevice(userVPNID uint, req *models.UserVPNDeviceRequest) (*models.UserVPNDevice, error) {
+	userVPN, err := uvs.GetUserVPN(userVPNID)
+	if err != nil {
+		return nil, err
+	}
+
+	var wgInterface models.WireGuardInterface
+	if err := uvs.db.First(&wgInterface, userVPN.Module.InterfaceID).Error; err != nil {
+		return nil, fmt.Errorf("获取WireGuard接口配置失败: %w", err)
+	}
+
+	keyPair, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("生成密钥对失败: %w", err)
+	}
+	presharedKey, err := wireguard.GeneratePresharedKey()
+	if err != nil {
+		return nil, fmt.Errorf("生成预共享密钥失败: %w", err)
+	}
+
+	device := &models.UserVPNDevice{
+		UserVPNID:    userVPN.ID,
+		Name:         req.Name,
+		PublicKey:    keyPair.PublicKey,
+		PrivateKey:   keyPair.PrivateKey,
+		PresharedKey: presharedKey,
+	}
+
+	// 设备数检查、地址分配和创建记录在同一事务中完成
+	if err := uvs.db.Transaction(func(tx *gorm.DB) error {
+		var devices []models.UserVPNDevice
+		if err := tx.Select("id", "name").Where("user_vpn_id = ?", userVPN.ID).Find(&devices).Error; err != nil {
+			return fmt.Errorf("查询用户VPN设备失败: %w", err)
+		}
+		if len(devices)+1 >= userVPN.MaxDevices {
+			return fmt.Errorf("%w %d", ErrMaxDevicesReached, userVPN.MaxDevices)
+		}
+		for _, existing := range devices {
+			if existing.Name == req.Name {
+				return errors.New("该用户下设备名称已存在")
+			}
+		}
+
+		ipam := &IPAMService{db: tx}
+		if err := allocateAddresses(ipam, &wgInterface, &device.IPAddress, &device.IPAddress6); err != nil {
+			return err
+		}
+		if err := tx.Create(device).Error; err != nil {
+			return fmt.Errorf("创建用户VPN设备失败: %w", err)
+		}
+		return ipam.Assign(wgInterface.ID, UserDeviceOwner(device.ID), device.IPAddress, device.IPAddress6)
+	}); err != nil {
+		return nil, err
+	}
+
+	uvs.reconcileInterface(wgInterface.ID)
+
+	fmt.Printf("用户VPN设备添加成功 - 用户: %s, 设备: %s, IP: %s\n", userVPN.Username, device.Name, device.IPAddress)
+
+	return device, nil
+}
+
+// DeleteDevice 删除用户VPN的附加设备并释放其地址
+func (uvs *UserVPNService) DeleteDevice(userVPNID, deviceID uint) error {
+	userVPN, err := uvs.GetUserVPN(userVPNID)
+	if err != nil {
+		return err
+	}
+	if _, err := uvs.GetDevice(userVPNID, deviceID); err != nil {
+		return err
+	}
+
+	interfaceID := userVPN.Module.InterfaceID
+	if err := uvs.db.Transaction(func(tx *gorm.DB) error {
+		if err := (&IPAMService{db: tx}).Release(interfaceID, UserDeviceOwner(deviceID)); err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.UserVPNDevice{}, deviceID).Error; err != nil {
+			return fmt.Errorf("删除用户VPN设备失败: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	uvs.reconcileInterface(interfaceID)
+
+	return nil
+}
+
+// GenerateDeviceConfig 生成附加设备的客户端配置，与用户配置只有密钥和地址不同
+func (uvs *UserVPNService) GenerateDeviceConfig(userVPNID, deviceID uint) (string, error) {
+	userVPN, err := uvs.GetUserVPN(userVPNID)
+	if err != nil {
+		return "", err
+	}
+	device, err := uvs.GetDevice(userVPNID, deviceID)
+	if err != nil {
+		return "", err
+	}
+
+	var wgInterface models.WireGuardInterface
+	if err := uvs.db.First(&wgInterface, userVPN.Module.InterfaceID).Error; err != nil {
+		return "", fmt.Errorf("获取WireGuard接口配置失败: %w", err)
+	}
+
+	config := userVPNClientConfig(&wgInterface, userVPN, userVPNEndpoint(&wgInterface), device.PrivateKey, device.PresharedKey, device.IPAddress, device.IPAddress6)
+
+	if device.ConfigRefreshRequired {
+		if err := uvs.db.Model(device).Update("config_refresh_required", false).Error; err != nil {
+			fmt.Printf("⚠️ 清除用户VPN设备配置更新标记失败: %v\n", err)
+		}
+	}
+
+	return config, nil
+}
+
+// deleteUserVPNDevices 删除用户VPN的全部附加设备并释放地址，在删除用户VPN的事务中调用
+func deleteUserVPNDevices(tx *gorm.DB, interfaceID, userVPNID uint) error {
+	var deviceIDs []uint
+	if err := tx.Model(&models.UserVPNDevice{}).Where("user_vpn_id = ?", userVPNID).Pluck("id", &deviceIDs).Error; err != nil {
+		return fmt.Errorf("查询用户VPN设备失败: %w", err)
+	}
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+
+	owners := make([]IPOwner, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		owners = append(owners, UserDeviceOwner(id))
+	}
+	if err := (&IPAMService{db: tx}).Release(interfaceID, owners...); err != nil {
+		return err
+	}
+	if err := tx.Where("user_vpn_id = ?", userVPNID).Delete(&models.UserVPNDevice{}).Error; err != nil {
+		return fmt.Errorf("删除用户VPN设备失败: %w", err)
+	}
+	return nil
+}
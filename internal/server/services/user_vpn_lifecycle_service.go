@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"eitec-vpn/internal/server/models"
+)
+
+// lifecycleActor 后台到期检查写入审计日志时使用的操作者
+const lifecycleActor = "system"
+
+// UserVPNLifecycleResult 一次到期检查的结果，内容为用户名
+type UserVPNLifecycleResult struct {
+	Expired  []string `json:"expired"`  // 到期并从接口移除的用户
+	Restored []string `json:"restored"` // 到期时间延后、恢复接入的用户
+	Warned   []string `json:"warned"`   // 发出到期提醒的用户
+}
+
+// Changed 是否有用户的状态发生变化或发出了提醒
+func (r *UserVPNLifecycleResult) Changed() bool {
+	return len(r.Expired)+len(r.Restored)+len(r.Warned) > 0
+}
+
+// EnforceLifecycle 按到期时间维护用户VPN状态，由后台任务定期调用：
+// 到期的用户标记为已过期并从接口移除；到期时间被延后的已过期用户恢复为离线并重新加入接口；
+// 距到期不足warningDays天的用户发出一次提醒（写入审计日志），warningDays为0时不提醒。
+// 暂停的用户保持暂停，恢复后再按到期时间处理
+func (uvs *UserVPNService) EnforceLifecycle(now time.Time, warningDays int) (*UserVPNLifecycleResult, error) {
+	var userVPNs []models.UserVPN
+	if err := uvs.db.Preload("Module").Where("expires_at IS NOT NULL").Order("id").Find(&userVPNs).Error; err != nil {
+		return nil, fmt.Errorf("查询用户VPN失败: %w", err)
+	}
+
+	result := &UserVPNLifecycleResult{}
+	auditService := &AuditService{db: uvs.db}
+	interfaces := make(map[uint]bool)
+	warnBefore := time.Duration(warningDays) * 24 * time.Hour
+
+	for i := range userVPNs {
+		userVPN := &userVPNs[i]
+		expired := !now.Before(*userVPN.ExpiresAt)
+
+		switch {
+		case expired && userVPN.Status != models.UserVPNStatusExpired && userVPN.Status != models.UserVPNStatusSuspended:
+			if err := uvs.db.Model(userVPN).Update("status", models.UserVPNStatusExpired).Error; err != nil {
+				return nil, fmt.Errorf("标记用户VPN过期失败: %w", err)
+			}
+			result.Expired = append(result.Expired, userVPN.Username)
+			interfaces[userVPN.Module.InterfaceID] = true
+			recordLifecycle(auditService, userVPN, models.AuditUserVPNExpire,
+				fmt.Sprintf("已于 %s 到期，已从接口移除", userVPN.ExpiresAt.Format(time.RFC3339)))
+
+		case !expired && userVPN.Status == models.UserVPNStatusExpired:
+			if err := uvs.db.Model(userVPN).Updates(map[string]interface{}{
+				"status":           models.UserVPNStatusOffline,
+				"expiry_warned_at": nil,
+			}).Error; err != nil {
+				return nil, fmt.Errorf("恢复用户VPN状态失败: %w", err)
+			}
+			result.Restored = append(result.Restored, userVPN.Username)
+			interfaces[userVPN.Module.InterfaceID] = true
+			recordLifecycle(auditService, userVPN, models.AuditUserVPNRestore,
+				fmt.Sprintf("到期时间已延后至 %s，恢复接入", userVPN.ExpiresAt.Format(time.RFC3339)))
+
+		case !expired && warningDays > 0 && userVPN.ExpiryWarnedAt == nil && userVPN.Enabled(now) && userVPN.ExpiresAt.Sub(now) <= warnBefore:
+			if err := uvs.db.Model(userVPN).Update("expiry_warned_at", now).Error; err != nil {
+				return nil, fmt.Errorf("记录到期提醒失败: %w", err)
+			}
+			result.Warned = append(result.Warned, userVPN.Username)
+			message := fmt.Sprintf("将于 %s 到期", userVPN.ExpiresAt.Format(time.RFC3339))
+			if userVPN.Email != "" {
+				message += "，联系邮箱 " + userVPN.Email
+			}
+			log.Printf("用户VPN到期提醒: %s %s", userVPN.Username, message)
+			recordLifecycle(auditService, userVPN, models.AuditUserVPNExpiryWarning, message)
+		}
+	}
+
+	for interfaceID := range interfaces {
+		uvs.reconcileInterface(interfaceID)
+	}
+
+	return result, nil
+}
+
+// recordLifecycle 记录后台到期检查对用户VPN的操作
+func recordLifecycle(auditService *AuditService, userVPN *models.UserVPN, action, message string) {
+	auditService.Record(&models.AuditLog{
+		Actor:      lifecycleActor,
+		Action:     action,
+		TargetType: models.AuditTargetUserVPN,
+		TargetID:   userVPN.ID,
+		TargetName: userVPN.Username,
+		Success:    true,
+		Message:    message,
+	})
+}
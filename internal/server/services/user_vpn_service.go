@@ -8,6 +8,8 @@ import (
 	"eitec-vpn/internal/server/database"
 	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/ipaddr"
+	"eitec-vpn/internal/shared/wgconf"
 	"eitec-vpn/internal/shared/wireguard"
 
 	"gorm.io/gorm"
@@ -66,13 +68,6 @@ func (uvs *UserVPNService) CreateUserVPN(config *models.UserVPNConfig) (*models.
 		return nil, fmt.Errorf("获取WireGuard接口配置失败: %w", err)
 	}
 
-	// 从模块关联的接口获取可用IP
-	moduleService := NewModuleService()
-	ipAddress, err := moduleService.getAvailableIPForInterface(module.InterfaceID)
-	if err != nil {
-		return nil, fmt.Errorf("分配IP地址失败: %w", err)
-	}
-
 	// 智能生成AllowedIPs - 参考用户成功配置
 	allowedIPs := config.AllowedIPs
 	fmt.Printf("🔍 [AllowedIPs生成] 开始生成用户VPN AllowedIPs\n")
@@ -86,6 +81,10 @@ func (uvs *UserVPNService) CreateUserVPN(config *models.UserVPNConfig) (*models.
 
 		// 1. 首先添加VPN网段（如：10.10.0.0/24）
 		allowedIPs = wgInterface.Network
+		// 双栈接口同时添加IPv6 VPN网段
+		if wgInterface.DualStack() {
+			allowedIPs += fmt.Sprintf(", %s", wgInterface.Network6)
+		}
 		fmt.Printf("🔍 [AllowedIPs生成] 添加VPN网段: '%s'\n", allowedIPs)
 
 		// 2. 添加模块配置的内网段（如：192.168.50.0/24）
@@ -123,7 +122,6 @@ func (uvs *UserVPNService) CreateUserVPN(config *models.UserVPNConfig) (*models.
 		PublicKey:    keyPair.PublicKey,
 		PrivateKey:   keyPair.PrivateKey,
 		PresharedKey: presharedKey,
-		IPAddress:    ipAddress,
 		Status:       models.UserVPNStatusOffline,
 		AllowedIPs:   allowedIPs,
 		PersistentKA: 25,
@@ -132,25 +130,28 @@ func (uvs *UserVPNService) CreateUserVPN(config *models.UserVPNConfig) (*models.
 		MaxDevices:   maxDevices,
 	}
 
-	if err := uvs.db.Create(userVPN).Error; err != nil {
-		// 如果创建失败，释放IP地址
-		moduleService.releaseIPForInterface(module.InterfaceID, ipAddress)
-		return nil, fmt.Errorf("创建用户VPN失败: %w", err)
-	}
-
-	// 分配IP地址
-	if err := moduleService.allocateIPForInterface(module.InterfaceID, ipAddress, userVPN.ID); err != nil {
-		uvs.db.Delete(userVPN)
-		return nil, fmt.Errorf("分配IP地址失败: %w", err)
+	// 从模块关联的接口分配IP地址并创建记录，在同一事务中完成，失败时地址自动回滚
+	if err := uvs.db.Transaction(func(tx *gorm.DB) error {
+		ipam := &IPAMService{db: tx}
+		if err := allocateAddresses(ipam, &wgInterface, &userVPN.IPAddress, &userVPN.IPAddress6); err != nil {
+			return err
+		}
+		if err := tx.Create(userVPN).Error; err != nil {
+			return fmt.Errorf("创建用户VPN失败: %w", err)
+		}
+		return ipam.Assign(wgInterface.ID, UserVPNOwner(userVPN.ID), userVPN.IPAddress, userVPN.IPAddress6)
+	}); err != nil {
+		return nil, err
 	}
 
 	// 自动更新WireGuard接口配置
+	moduleService := NewModuleService()
 	if err := moduleService.updateInterfaceConfig(module.InterfaceID); err != nil {
 		// 记录错误但不影响用户VPN创建成功
 		fmt.Printf("警告：更新WireGuard配置失败 - 接口ID: %d, 错误: %v\n", module.InterfaceID, err)
 	}
 
-	fmt.Printf("用户VPN创建成功 - 模块ID: %d, 用户: %s, IP: %s\n", config.ModuleID, config.Username, ipAddress)
+	fmt.Printf("用户VPN创建成功 - 模块ID: %d, 用户: %s, IP: %s\n", config.ModuleID, config.Username, userVPN.IPAddress)
 
 	return userVPN, nil
 }
@@ -202,21 +203,7 @@ func (uvs *UserVPNService) GenerateUserVPNConfig(id uint) (string, error) {
 		return "", fmt.Errorf("获取WireGuard接口配置失败: %w", err)
 	}
 
-	// 获取端点配置，使用与模块配置相同的智能选择逻辑：
-	var serverEndpoint string
-
-	// 1. 优先使用配置文件中的服务器IP + 接口端口
-	if cfg := getGlobalConfigForUserVPN(); cfg != nil && cfg.App.ServerIP != "" {
-		serverEndpoint = fmt.Sprintf("%s:%d", cfg.App.ServerIP, wgInterface.ListenPort)
-	} else {
-		// 2. 然后使用系统配置的endpoint
-		if systemEndpoint, err := database.GetSystemConfig("server.endpoint"); err == nil && systemEndpoint != "" {
-			serverEndpoint = systemEndpoint
-		} else {
-			// 3. 最后兜底：动态构建端点 vpn.eitec.com + 接口端口
-			serverEndpoint = fmt.Sprintf("vpn.eitec.com:%d", wgInterface.ListenPort)
-		}
-	}
+	serverEndpoint := userVPNEndpoint(&wgInterface)
 
 	// 生成用户配置文件 - 完全匹配用户成功配置模板
 	fmt.Printf("📄 [配置生成] 开始生成用户VPN配置文件\n")
@@ -225,31 +212,79 @@ func (uvs *UserVPNService) GenerateUserVPNConfig(id uint) (string, error) {
 	fmt.Printf("📄 [配置生成] 服务端点: %s\n", serverEndpoint)
 
 	// 参考用户成功配置：user-client.conf
-	config := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s/32
-
-[Peer]
-PublicKey = %s
-PresharedKey = %s
-Endpoint = %s
-AllowedIPs = %s
-PersistentKeepalive = %d`,
-		userVPN.PrivateKey,
-		userVPN.IPAddress,
-		wgInterface.PublicKey,
-		userVPN.PresharedKey,
-		serverEndpoint,
-		userVPN.AllowedIPs,
-		userVPN.PersistentKA)
+	config := userVPNClientConfig(&wgInterface, userVPN, serverEndpoint, userVPN.PrivateKey, userVPN.PresharedKey, userVPN.IPAddress, userVPN.IPAddress6)
 
 	fmt.Printf("✅ [配置生成] 配置文件生成完成 - 用户ID: %d, 用户名: %s, AllowedIPs: %s\n", id, userVPN.Username, userVPN.AllowedIPs)
 
+	// 下载配置即视为用户已取得最新配置
+	if userVPN.ConfigRefreshRequired {
+		if err := uvs.db.Model(&models.UserVPN{}).Where("id = ?", id).Update("config_refresh_required", false).Error; err != nil {
+			fmt.Printf("⚠️ 清除用户VPN配置更新标记失败: %v\n", err)
+		}
+	}
+
 	return config, nil
 }
 
-// UpdateUserVPN 更新用户VPN信息
+// userVPNEndpoint 用户配置中的服务器端点，使用与模块配置相同的智能选择逻辑
+func userVPNEndpoint(wgInterface *models.WireGuardInterface) string {
+	// 1. 优先使用配置文件中的服务器IP + 接口端口
+	if cfg := getGlobalConfigForUserVPN(); cfg != nil && cfg.App.ServerIP != "" {
+		return fmt.Sprintf("%s:%d", cfg.App.ServerIP, wgInterface.ListenPort)
+	}
+	// 2. 然后使用系统配置的endpoint
+	if systemEndpoint, err := database.GetSystemConfig("server.endpoint"); err == nil && systemEndpoint != "" {
+		return systemEndpoint
+	}
+	// 3. 最后兜底：动态构建端点 vpn.eitec.com + 接口端口
+	return fmt.Sprintf("vpn.eitec.com:%d", wgInterface.ListenPort)
+}
+
+// userVPNClientConfig 生成用户客户端配置，用户自身和附加设备只有密钥和地址不同
+func userVPNClientConfig(wgInterface *models.WireGuardInterface, userVPN *models.UserVPN, endpoint, privateKey, presharedKey, ipAddress, ipAddress6 string) string {
+	conf := &wgconf.Config{
+		Interface: wgconf.Interface{
+			PrivateKey: privateKey,
+			Address:    ipaddr.Hosts(ipAddress, ipAddress6),
+		},
+		Peers: []wgconf.Peer{{
+			PublicKey:           wgInterface.PublicKey,
+			PresharedKey:        presharedKey,
+			Endpoint:            endpoint,
+			AllowedIPs:          wgconf.SplitList(userVPN.AllowedIPs),
+			PersistentKeepalive: userVPN.PersistentKA,
+		}},
+	}
+	return conf.String()
+}
+
+// userVPNPeerFields 影响服务端对等端的字段，修改后需要同步接口
+var userVPNPeerFields = []string{"is_active", "status", "expires_at", "persistent_keepalive"}
+
+// UpdateUserVPN 更新用户VPN信息。修改接入状态或到期时间后同步接口对等端：
+// 停用、暂停或过期的用户从接口移除，重新启用后恢复
 func (uvs *UserVPNService) UpdateUserVPN(id uint, updates map[string]interface{}) error {
+	if value, ok := updates["max_devices"]; ok {
+		maxDevices, ok := value.(float64)
+		if !ok || maxDevices < 1 || maxDevices > 10 || maxDevices != float64(int(maxDevices)) {
+			return errors.New("最大设备数必须是1到10之间的整数")
+		}
+		var count int64
+		if err := uvs.db.Model(&models.UserVPNDevice{}).Where("user_vpn_id = ?", id).Count(&count).Error; err != nil {
+			return fmt.Errorf("统计用户VPN设备失败: %w", err)
+		}
+		// 用户自身的密钥算作第一台设备
+		if int(count)+1 > int(maxDevices) {
+			return fmt.Errorf("用户已有 %d 台设备，请先删除多余的附加设备", count+1)
+		}
+	}
+
+	_, expiryChanged := updates["expires_at"]
+	if expiryChanged {
+		// 到期时间变化后重新提醒
+		updates["expiry_warned_at"] = nil
+	}
+
 	result := uvs.db.Model(&models.UserVPN{}).Where("id = ?", id).Updates(updates)
 	if result.Error != nil {
 		return fmt.Errorf("更新用户VPN失败: %w", result.Error)
@@ -261,9 +296,36 @@ func (uvs *UserVPNService) UpdateUserVPN(id uint, updates map[string]interface{}
 
 	fmt.Printf("用户VPN信息更新 - 用户VPN ID: %d\n", id)
 
+	userVPN, err := uvs.GetUserVPN(id)
+	if err != nil {
+		return err
+	}
+
+	// 已过期的用户延长到期时间后恢复接入，未显式指定状态时才自动恢复
+	if _, statusSet := updates["status"]; expiryChanged && !statusSet &&
+		userVPN.Status == models.UserVPNStatusExpired && (userVPN.ExpiresAt == nil || time.Now().Before(*userVPN.ExpiresAt)) {
+		if err := uvs.db.Model(&models.UserVPN{}).Where("id = ?", id).Update("status", models.UserVPNStatusOffline).Error; err != nil {
+			return fmt.Errorf("恢复用户VPN状态失败: %w", err)
+		}
+	}
+
+	for _, field := range userVPNPeerFields {
+		if _, ok := updates[field]; ok {
+			uvs.reconcileInterface(userVPN.Module.InterfaceID)
+			break
+		}
+	}
+
 	return nil
 }
 
+// reconcileInterface 用户接入状态变化后更新所在接口的配置和对等端，失败时只记录日志
+func (uvs *UserVPNService) reconcileInterface(interfaceID uint) {
+	if err := NewModuleService().updateInterfaceConfig(interfaceID); err != nil {
+		fmt.Printf("警告：更新WireGuard配置失败 - 接口ID: %d, 错误: %v\n", interfaceID, err)
+	}
+}
+
 // DeleteUserVPN 删除用户VPN
 func (uvs *UserVPNService) DeleteUserVPN(id uint) error {
 	userVPN, err := uvs.GetUserVPN(id)
@@ -274,18 +336,24 @@ func (uvs *UserVPNService) DeleteUserVPN(id uint) error {
 	// 保存接口ID用于后续配置更新
 	interfaceID := userVPN.Module.InterfaceID
 
-	// 释放IP地址
-	moduleService := NewModuleService()
-	if err := moduleService.releaseIPForInterface(interfaceID, userVPN.IPAddress); err != nil {
-		return fmt.Errorf("释放IP地址失败: %w", err)
-	}
-
-	// 删除用户VPN记录（硬删除）
-	if err := uvs.db.Unscoped().Delete(&models.UserVPN{}, id).Error; err != nil {
-		return fmt.Errorf("删除用户VPN失败: %w", err)
+	// 释放IP地址并删除用户VPN及其附加设备的记录（硬删除）
+	if err := uvs.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteUserVPNDevices(tx, interfaceID, userVPN.ID); err != nil {
+			return err
+		}
+		if err := (&IPAMService{db: tx}).Release(interfaceID, UserVPNOwner(userVPN.ID)); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&models.UserVPN{}, id).Error; err != nil {
+			return fmt.Errorf("删除用户VPN失败: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 
 	// 自动更新WireGuard接口配置
+	moduleService := NewModuleService()
 	if err := moduleService.updateInterfaceConfig(interfaceID); err != nil {
 		// 记录错误但不影响删除成功
 		fmt.Printf("警告：更新WireGuard配置失败 - 接口ID: %d, 错误: %v\n", interfaceID, err)
@@ -313,6 +381,11 @@ func (uvs *UserVPNService) UpdateUserVPNStatus(id uint, status models.UserVPNSta
 
 	fmt.Printf("状态变更为: %s - 用户VPN ID: %d\n", status.String(), id)
 
+	// 暂停和过期的用户从接口移除，恢复后重新加入
+	if userVPN, err := uvs.GetUserVPN(id); err == nil {
+		uvs.reconcileInterface(userVPN.Module.InterfaceID)
+	}
+
 	return nil
 }
 
@@ -5,20 +5,24 @@ import (
 	"fmt"
 	"net"
 	"os"
-
 	"strings"
+	"sync"
 	"time"
 
 	"eitec-vpn/internal/server/database"
 	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/ipaddr"
+	"eitec-vpn/internal/shared/utils"
+	"eitec-vpn/internal/shared/wgconf"
 	"eitec-vpn/internal/shared/wireguard"
 
-	"os/exec"
-
 	"gorm.io/gorm"
 )
 
+// network6Auto 创建接口时network6取该值表示自动生成ULA网段
+const network6Auto = "auto"
+
 // WireGuardInterfaceService WireGuard接口管理服务
 type WireGuardInterfaceService struct {
 	db *gorm.DB
@@ -44,6 +48,23 @@ func (wis *WireGuardInterfaceService) CreateInterface(template *models.Interface
 		return nil, fmt.Errorf("网络段验证失败: %w", err)
 	}
 
+	// 双栈接口：验证或自动生成IPv6网段
+	network6 := strings.TrimSpace(template.Network6)
+	if network6 == network6Auto {
+		generated, err := ipaddr.GenerateULA()
+		if err != nil {
+			return nil, err
+		}
+		network6 = generated
+	}
+	if network6 != "" {
+		if err := wis.validateNetwork6(network6); err != nil {
+			return nil, fmt.Errorf("IPv6网段验证失败: %w", err)
+		}
+		_, ipNet, _ := net.ParseCIDR(network6)
+		network6 = ipNet.String()
+	}
+
 	// 检查端口是否已被使用
 	if err := wis.checkPortAvailable(template.ListenPort); err != nil {
 		return nil, fmt.Errorf("端口验证失败: %w", err)
@@ -60,6 +81,12 @@ func (wis *WireGuardInterfaceService) CreateInterface(template *models.Interface
 	if err != nil {
 		return nil, fmt.Errorf("计算服务器IP失败: %w", err)
 	}
+	var serverIP6 string
+	if network6 != "" {
+		if serverIP6, err = wis.calculateServerIP(network6); err != nil {
+			return nil, fmt.Errorf("计算服务器IPv6地址失败: %w", err)
+		}
+	}
 
 	// 创建接口记录
 	wgInterface := &models.WireGuardInterface{
@@ -67,6 +94,8 @@ func (wis *WireGuardInterfaceService) CreateInterface(template *models.Interface
 		Description: template.Description,
 		Network:     template.Network,
 		ServerIP:    serverIP,
+		Network6:    network6,
+		ServerIP6:   serverIP6,
 		ListenPort:  template.ListenPort,
 		PublicKey:   keyPair.PublicKey,
 		PrivateKey:  keyPair.PrivateKey,
@@ -78,14 +107,14 @@ func (wis *WireGuardInterfaceService) CreateInterface(template *models.Interface
 		SaveConfig:  true,
 	}
 
-	if err := wis.db.Create(wgInterface).Error; err != nil {
-		return nil, fmt.Errorf("创建接口失败: %w", err)
-	}
-
-	// 为接口创建IP池
-	if err := wis.createIPPoolForInterface(wgInterface); err != nil {
-		wis.db.Delete(wgInterface)
-		return nil, fmt.Errorf("创建IP池失败: %w", err)
+	// 接口和服务器地址的分配记录在同一事务中写入
+	if err := wis.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(wgInterface).Error; err != nil {
+			return fmt.Errorf("创建接口失败: %w", err)
+		}
+		return (&IPAMService{db: tx}).ClaimGateway(wgInterface)
+	}); err != nil {
+		return nil, err
 	}
 
 	return wgInterface, nil
@@ -273,7 +302,7 @@ func (wis *WireGuardInterfaceService) StartInterface(id uint) error {
 	wis.db.Model(wgInterface).Update("status", models.InterfaceStatusStarting)
 
 	// 检查配置文件是否存在
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", wgInterface.Name)
+	configPath := wireguard.ConfigPath(wgInterface.Name)
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		// 配置文件不存在，生成配置文件
 		configContent := wis.GenerateInterfaceConfig(wgInterface)
@@ -292,16 +321,19 @@ func (wis *WireGuardInterfaceService) StartInterface(id uint) error {
 	// 更新状态为运行中
 	wis.db.Model(wgInterface).Update("status", models.InterfaceStatusUp)
 
+	// 接口启动后WireGuard计数器从零开始，基线清零保证启动后的流量全部计入增量
+	if err := NewTrafficService().ResetInterfaceCounters(wgInterface.ID); err != nil {
+		fmt.Printf("警告：重置接口 %s 流量计数器失败: %v\n", wgInterface.Name, err)
+	}
+
 	return nil
 }
 
 // startWireGuardInterface 启动WireGuard接口（内部方法）
 func (wis *WireGuardInterfaceService) startWireGuardInterface(interfaceName string) error {
-	// 使用wg-quick up命令启动接口
-	cmd := exec.Command("wg-quick", "up", interfaceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("启动WireGuard接口失败: %s, 输出: %s", err.Error(), string(output))
+	configPath := wireguard.ConfigPath(interfaceName)
+	if err := wireguard.QuickUp(interfaceName, configPath); err != nil {
+		return fmt.Errorf("启动WireGuard接口失败: %w", err)
 	}
 	return nil
 }
@@ -337,15 +369,13 @@ func (wis *WireGuardInterfaceService) StopInterface(id uint) error {
 
 // stopWireGuardInterface 停止WireGuard接口（内部方法）
 func (wis *WireGuardInterfaceService) stopWireGuardInterface(interfaceName string) error {
-	// 使用wg-quick down命令停止接口
-	cmd := exec.Command("wg-quick", "down", interfaceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
+	configPath := wireguard.ConfigPath(interfaceName)
+	if err := wireguard.QuickDown(interfaceName, configPath); err != nil {
 		// 如果接口不存在，不算错误
-		if strings.Contains(string(output), "does not exist") || strings.Contains(err.Error(), "does not exist") {
+		if errors.Is(err, wireguard.ErrDeviceNotFound) {
 			return nil
 		}
-		return fmt.Errorf("停止WireGuard接口失败: %s, 输出: %s", err.Error(), string(output))
+		return fmt.Errorf("停止WireGuard接口失败: %w", err)
 	}
 	return nil
 }
@@ -373,13 +403,15 @@ func (wis *WireGuardInterfaceService) DeleteInterface(id uint) error {
 	}
 
 	// 删除配置文件
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", wgInterface.Name)
+	configPath := wireguard.ConfigPath(wgInterface.Name)
 	if _, err := os.Stat(configPath); err == nil {
 		os.Remove(configPath)
 	}
 
-	// 删除IP池
-	wis.db.Unscoped().Where("network = ?", wgInterface.Network).Delete(&models.IPPool{})
+	// 释放接口的地址分配和保留范围
+	if err := (&IPAMService{db: wis.db}).ReleaseInterface(wgInterface.ID); err != nil {
+		return err
+	}
 
 	// 硬删除接口记录
 	if err := wis.db.Unscoped().Delete(wgInterface).Error; err != nil {
@@ -398,7 +430,7 @@ func (wis *WireGuardInterfaceService) UpdateInterfaceConfig(id uint) error {
 
 	// 生成最新的配置文件内容
 	configContent := wis.GenerateInterfaceConfig(wgInterface)
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", wgInterface.Name)
+	configPath := wireguard.ConfigPath(wgInterface.Name)
 
 	// 写入配置文件
 	if err := wireguard.WriteConfigFile(configPath, configContent); err != nil {
@@ -465,95 +497,57 @@ func (wis *WireGuardInterfaceService) checkPortAvailable(port int) error {
 	return nil
 }
 
-// calculateServerIP 计算服务器IP地址
-func (wis *WireGuardInterfaceService) calculateServerIP(network string) (string, error) {
-	_, ipNet, err := net.ParseCIDR(network)
+// validateNetwork6 验证IPv6网段：必须是未被使用的ULA网段 (fc00::/7)，前缀长度在48到124之间
+func (wis *WireGuardInterfaceService) validateNetwork6(network string) error {
+	ip, ipNet, err := net.ParseCIDR(network)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("无效的网络段格式: %w", err)
+	}
+	if ip.To4() != nil {
+		return errors.New("network6必须是IPv6网段")
+	}
+	if !ipaddr.IsULA(ip) {
+		return errors.New("必须使用IPv6唯一本地地址网段 (fc00::/7)")
+	}
+	if ones, _ := ipNet.Mask.Size(); ones < 48 || ones > 124 {
+		return errors.New("IPv6网段前缀长度必须在48到124之间")
 	}
 
-	// 使用网络段的第一个可用IP作为服务器IP
-	ip := ipNet.IP.To4()
-	if ip == nil {
-		return "", errors.New("不支持IPv6")
+	var existingInterface models.WireGuardInterface
+	if err := wis.db.Where("network6 = ?", ipNet.String()).First(&existingInterface).Error; err == nil {
+		return errors.New("IPv6网段已被使用")
 	}
 
-	// 第一个IP通常是网络地址，第二个IP作为服务器IP
-	ip[3] = ip[3] + 1
-	return ip.String(), nil
+	return nil
 }
 
-// createIPPoolForInterface 为接口创建IP池
-func (wis *WireGuardInterfaceService) createIPPoolForInterface(wgInterface *models.WireGuardInterface) error {
-	_, ipNet, err := net.ParseCIDR(wgInterface.Network)
+// calculateServerIP 计算服务器IP地址，IPv4和IPv6都使用网段内第一个地址
+func (wis *WireGuardInterfaceService) calculateServerIP(network string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(network)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// 生成IP池
-	ip := ipNet.IP.To4()
+	// 第一个IP通常是网络地址，第二个IP作为服务器IP
+	ip := ipaddr.Nth(ipNet, 1)
 	if ip == nil {
-		return errors.New("不支持IPv6")
+		return "", fmt.Errorf("网段 %s 太小", network)
 	}
-
-	// 跳过网络地址和服务器IP，从第三个IP开始
-	startIP := make(net.IP, 4)
-	copy(startIP, ip)
-	startIP[3] = startIP[3] + 2
-
-	// 计算可用IP数量
-	ones, bits := ipNet.Mask.Size()
-	availableIPs := 1<<(bits-ones) - 3 // 减去网络地址、广播地址和服务器IP
-
-	// 批量创建IP池记录
-	var ipPools []models.IPPool
-	for i := 0; i < availableIPs; i++ {
-		currentIP := make(net.IP, 4)
-		copy(currentIP, startIP)
-		currentIP[3] = currentIP[3] + byte(i)
-
-		// 检查是否超出网络范围
-		if !ipNet.Contains(currentIP) {
-			break
-		}
-
-		ipPools = append(ipPools, models.IPPool{
-			Network:   wgInterface.Network,
-			IPAddress: currentIP.String(),
-			IsUsed:    false,
-		})
-	}
-
-	// 批量插入
-	if len(ipPools) > 0 {
-		if err := wis.db.CreateInBatches(ipPools, 100).Error; err != nil {
-			return fmt.Errorf("批量创建IP池失败: %w", err)
-		}
-	}
-
-	return nil
+	return ip.String(), nil
 }
 
 // generateInterfaceConfig 生成接口配置
 func (wis *WireGuardInterfaceService) GenerateInterfaceConfig(wgInterface *models.WireGuardInterface) string {
-	var config strings.Builder
-
 	// Interface部分
-	config.WriteString("[Interface]\n")
-	config.WriteString(fmt.Sprintf("PrivateKey = %s\n", wgInterface.PrivateKey))
-	config.WriteString(fmt.Sprintf("Address = %s/24\n", wgInterface.ServerIP))
-	config.WriteString(fmt.Sprintf("ListenPort = %d\n", wgInterface.ListenPort))
-
-	if wgInterface.DNS != "" {
-		config.WriteString(fmt.Sprintf("DNS = %s\n", wgInterface.DNS))
-	}
-
-	if wgInterface.MTU > 0 {
-		config.WriteString(fmt.Sprintf("MTU = %d\n", wgInterface.MTU))
-	}
-
-	if wgInterface.SaveConfig {
-		config.WriteString("SaveConfig = true\n")
+	conf := &wgconf.Config{
+		Interface: wgconf.Interface{
+			PrivateKey: wgInterface.PrivateKey,
+			Address:    interfaceAddresses(wgInterface),
+			ListenPort: wgInterface.ListenPort,
+			DNS:        wgconf.SplitList(wgInterface.DNS),
+			MTU:        wgInterface.MTU,
+			SaveConfig: wgInterface.SaveConfig,
+		},
 	}
 
 	// 基础PostUp/PostDown规则 - 参考用户成功配置
@@ -564,112 +558,206 @@ func (wis *WireGuardInterfaceService) GenerateInterfaceConfig(wgInterface *model
 	}
 
 	if wgInterface.PostUp != "" {
-		config.WriteString(fmt.Sprintf("PostUp = %s\n", wgInterface.PostUp))
+		conf.Interface.PostUp = []string{wgInterface.PostUp}
 	} else {
 		// 智能生成PostUp规则：根据模块的网卡名称动态调整
 		// 如果所有模块都使用相同的网卡，则使用该网卡；否则使用默认网卡
 		smartNetworkInterface := wis.getSmartNetworkInterface(wgInterface.ID, networkInterface)
-		config.WriteString(fmt.Sprintf("PostUp = iptables -A FORWARD -i %%i -j ACCEPT; iptables -A FORWARD -o %%i -j ACCEPT; iptables -t nat -A POSTROUTING -o %s -j MASQUERADE\n", smartNetworkInterface))
+		conf.Interface.PostUp = []string{fmt.Sprintf("iptables -A FORWARD -i %%i -j ACCEPT; iptables -A FORWARD -o %%i -j ACCEPT; iptables -t nat -A POSTROUTING -o %s -j MASQUERADE", smartNetworkInterface)}
+		// 双栈接口：开启IPv6转发，并添加对应的ip6tables规则
+		if wgInterface.DualStack() {
+			conf.Interface.PostUp = append(conf.Interface.PostUp, fmt.Sprintf("sysctl -q -w net.ipv6.conf.all.forwarding=1; ip6tables -A FORWARD -i %%i -j ACCEPT; ip6tables -A FORWARD -o %%i -j ACCEPT; ip6tables -t nat -A POSTROUTING -o %s -j MASQUERADE", smartNetworkInterface))
+		}
 	}
 
 	if wgInterface.PostDown != "" {
-		config.WriteString(fmt.Sprintf("PostDown = %s\n", wgInterface.PostDown))
+		conf.Interface.PostDown = []string{wgInterface.PostDown}
 	} else {
 		// 智能生成PostDown规则：与PostUp保持一致
 		smartNetworkInterface := wis.getSmartNetworkInterface(wgInterface.ID, networkInterface)
-		config.WriteString(fmt.Sprintf("PostDown = iptables -D FORWARD -i %%i -j ACCEPT; iptables -D FORWARD -o %%i -j ACCEPT; iptables -t nat -D POSTROUTING -o %s -j MASQUERADE\n", smartNetworkInterface))
+		conf.Interface.PostDown = []string{fmt.Sprintf("iptables -D FORWARD -i %%i -j ACCEPT; iptables -D FORWARD -o %%i -j ACCEPT; iptables -t nat -D POSTROUTING -o %s -j MASQUERADE", smartNetworkInterface)}
+		if wgInterface.DualStack() {
+			conf.Interface.PostDown = append(conf.Interface.PostDown, fmt.Sprintf("ip6tables -D FORWARD -i %%i -j ACCEPT; ip6tables -D FORWARD -o %%i -j ACCEPT; ip6tables -t nat -D POSTROUTING -o %s -j MASQUERADE", smartNetworkInterface))
+		}
 	}
 
-	// 获取所有模块信息（用于生成Peer配置）
-	var modules []models.Module
-	wis.db.Where("interface_id = ?", wgInterface.ID).Find(&modules)
+	// 获取所有模块和用户VPN信息（用于生成Peer配置）
+	modules, userVPNs, devices := wis.interfacePeerRecords(wgInterface.ID)
 
 	// 注意：不再自动生成硬编码的iptables规则
 	// 用户反馈：这些规则不够灵活，应该由用户自定义或使用默认规则
 
 	if wgInterface.PreUp != "" {
-		config.WriteString(fmt.Sprintf("PreUp = %s\n", wgInterface.PreUp))
+		conf.Interface.PreUp = []string{wgInterface.PreUp}
 	}
 
 	if wgInterface.PreDown != "" {
-		config.WriteString(fmt.Sprintf("PreDown = %s\n", wgInterface.PreDown))
+		conf.Interface.PreDown = []string{wgInterface.PreDown}
 	}
 
 	// Peer部分 - 添加所有关联的模块
 	for _, module := range modules {
-		config.WriteString("\n[Peer]\n")
-		config.WriteString(fmt.Sprintf("# %s - %s\n", module.Name, module.Location))
-		config.WriteString(fmt.Sprintf("PublicKey = %s\n", module.PublicKey))
-
-		// 添加预共享密钥（如果有）
-		if module.PresharedKey != "" {
-			config.WriteString(fmt.Sprintf("PresharedKey = %s\n", module.PresharedKey))
-		}
-
-		// AllowedIPs格式：模块VPN_IP/32, 内网网段
-		config.WriteString(fmt.Sprintf("AllowedIPs = %s/32", module.IPAddress))
-		if module.AllowedIPs != "" && module.AllowedIPs != "192.168.1.0/24" {
-			config.WriteString(fmt.Sprintf(", %s", module.AllowedIPs))
+		// 尚未注册公钥的模块不写入配置，与desiredPeers保持一致
+		if module.PublicKey == "" {
+			continue
 		}
-		config.WriteString("\n")
+		conf.Peers = append(conf.Peers, wgconf.Peer{
+			Comments:     []string{fmt.Sprintf("%s - %s", module.Name, module.Location)},
+			PublicKey:    module.PublicKey,
+			PresharedKey: module.PresharedKey,
+			// AllowedIPs格式：模块VPN_IP/32, 内网网段
+			AllowedIPs:          moduleAllowedIPs(&module),
+			Endpoint:            module.Endpoint,
+			PersistentKeepalive: module.PersistentKA,
+		})
+	}
 
-		// 添加Endpoint（如果有配置）
-		if module.Endpoint != "" {
-			config.WriteString(fmt.Sprintf("Endpoint = %s\n", module.Endpoint))
-		}
+	// Peer部分 - 添加所有关联的用户VPN
+	for _, userVPN := range userVPNs {
+		// 参考用户成功配置：AllowedIPs = 10.10.0.3/32，双栈时再加上IPv6/128
+		// 只包含用户的VPN IP，不包含网段
+		// 注意：用户客户端配置中的Endpoint是服务器端点，服务端配置中不需要
+		conf.Peers = append(conf.Peers, wgconf.Peer{
+			Comments:            []string{"User: " + userVPN.Username},
+			PublicKey:           userVPN.PublicKey,
+			PresharedKey:        userVPN.PresharedKey,
+			AllowedIPs:          ipaddr.Hosts(userVPN.IPAddress, userVPN.IPAddress6),
+			PersistentKeepalive: userVPN.PersistentKA,
+		})
 
-		if module.PersistentKA > 0 {
-			config.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", module.PersistentKA))
+		// 用户的附加设备使用各自的密钥和地址
+		for _, device := range devices[userVPN.ID] {
+			conf.Peers = append(conf.Peers, wgconf.Peer{
+				Comments:            []string{fmt.Sprintf("User: %s - %s", userVPN.Username, device.Name)},
+				PublicKey:           device.PublicKey,
+				PresharedKey:        device.PresharedKey,
+				AllowedIPs:          ipaddr.Hosts(device.IPAddress, device.IPAddress6),
+				PersistentKeepalive: userVPN.PersistentKA,
+			})
 		}
 	}
 
-	// Peer部分 - 添加所有关联的用户VPN
-	var userVPNs []models.UserVPN
-	wis.db.Joins("JOIN modules ON user_vpns.module_id = modules.id").
-		Where("modules.interface_id = ? AND user_vpns.is_active = ?", wgInterface.ID, true).
-		Find(&userVPNs)
+	return conf.String()
+}
 
-	for _, userVPN := range userVPNs {
-		config.WriteString("\n[Peer]\n")
-		config.WriteString(fmt.Sprintf("# User: %s\n", userVPN.Username))
-		config.WriteString(fmt.Sprintf("PublicKey = %s\n", userVPN.PublicKey))
+// interfacePeerRecords 获取接口下的模块、允许接入的用户VPN及其附加设备（按用户VPN ID分组）。
+// 未激活、已暂停或已过期的用户不返回，这些用户的对等端不会写入配置
+func (wis *WireGuardInterfaceService) interfacePeerRecords(interfaceID uint) ([]models.Module, []models.UserVPN, map[uint][]models.UserVPNDevice) {
+	var modules []models.Module
+	wis.db.Where("interface_id = ?", interfaceID).Find(&modules)
 
-		// 参考用户成功配置：AllowedIPs = 10.10.0.3/32
-		// 只包含用户的VPN IP，不包含网段
-		config.WriteString(fmt.Sprintf("AllowedIPs = %s/32\n", userVPN.IPAddress))
+	var activeUserVPNs []models.UserVPN
+	wis.db.Joins("JOIN modules ON user_vpns.module_id = modules.id").
+		Where("modules.interface_id = ? AND user_vpns.is_active = ?", interfaceID, true).
+		Find(&activeUserVPNs)
 
-		// 添加预共享密钥（如果有）
-		if userVPN.PresharedKey != "" {
-			config.WriteString(fmt.Sprintf("PresharedKey = %s\n", userVPN.PresharedKey))
+	now := time.Now()
+	userVPNs := make([]models.UserVPN, 0, len(activeUserVPNs))
+	userVPNIDs := make([]uint, 0, len(activeUserVPNs))
+	for _, userVPN := range activeUserVPNs {
+		if userVPN.Enabled(now) {
+			userVPNs = append(userVPNs, userVPN)
+			userVPNIDs = append(userVPNIDs, userVPN.ID)
 		}
+	}
 
-		// 添加Endpoint（如果需要）
-		// 注意：用户客户端配置中的Endpoint是服务器端点，服务端配置中不需要
+	devices := make(map[uint][]models.UserVPNDevice)
+	if len(userVPNIDs) > 0 {
+		var rows []models.UserVPNDevice
+		wis.db.Where("user_vpn_id IN ?", userVPNIDs).Order("id").Find(&rows)
+		for _, device := range rows {
+			devices[device.UserVPNID] = append(devices[device.UserVPNID], device)
+		}
+	}
 
-		if userVPN.PersistentKA > 0 {
-			config.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", userVPN.PersistentKA))
+	return modules, userVPNs, devices
+}
+
+// interfaceAddresses 接口地址：服务器IP加上网段前缀长度，双栈接口同时包含IPv6地址
+func interfaceAddresses(wgInterface *models.WireGuardInterface) []string {
+	addresses := make([]string, 0, 2)
+	if address, err := ipaddr.InNetwork(wgInterface.ServerIP, wgInterface.Network); err == nil {
+		addresses = append(addresses, address)
+	}
+	if wgInterface.DualStack() {
+		if address, err := ipaddr.InNetwork(wgInterface.ServerIP6, wgInterface.Network6); err == nil {
+			addresses = append(addresses, address)
 		}
 	}
+	return addresses
+}
 
-	return config.String()
+// moduleAllowedIPs 模块Peer的AllowedIPs：模块VPN_IP/32（双栈时加上IPv6/128）加上内网网段
+func moduleAllowedIPs(module *models.Module) []string {
+	allowedIPs := ipaddr.Hosts(module.IPAddress, module.IPAddress6)
+	if module.AllowedIPs != "" && module.AllowedIPs != "192.168.1.0/24" {
+		allowedIPs = append(allowedIPs, wgconf.SplitList(module.AllowedIPs)...)
+	}
+	return allowedIPs
 }
 
-// GetAvailableIPForInterface 为指定接口获取可用IP
-func (wis *WireGuardInterfaceService) GetAvailableIPForInterface(interfaceID uint) (string, error) {
-	wgInterface, err := wis.GetInterface(interfaceID)
-	if err != nil {
-		return "", err
+// desiredPeers 根据数据库生成接口期望的对等端集合，与GenerateInterfaceConfig保持一致
+func (wis *WireGuardInterfaceService) desiredPeers(wgInterface *models.WireGuardInterface) []wireguard.PeerConfig {
+	modules, userVPNs, devices := wis.interfacePeerRecords(wgInterface.ID)
+
+	peers := make([]wireguard.PeerConfig, 0, len(modules)+len(userVPNs))
+	for i := range modules {
+		module := &modules[i]
+		// 尚未注册公钥的模块不下发
+		if module.PublicKey == "" {
+			continue
+		}
+		presharedKey := module.PresharedKey
+		keepalive := module.PersistentKA
+		peers = append(peers, wireguard.PeerConfig{
+			PublicKey:           module.PublicKey,
+			PresharedKey:        &presharedKey,
+			Endpoint:            module.Endpoint,
+			PersistentKeepalive: &keepalive,
+			AllowedIPs:          moduleAllowedIPs(module),
+		})
 	}
 
-	var ipPool models.IPPool
-	if err := wis.db.Where("network = ? AND is_used = ?", wgInterface.Network, false).First(&ipPool).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return "", fmt.Errorf("接口 %s 没有可用的IP地址", wgInterface.Name)
+	for i := range userVPNs {
+		userVPN := &userVPNs[i]
+		presharedKey := userVPN.PresharedKey
+		keepalive := userVPN.PersistentKA
+		peers = append(peers, wireguard.PeerConfig{
+			PublicKey:           userVPN.PublicKey,
+			PresharedKey:        &presharedKey,
+			PersistentKeepalive: &keepalive,
+			AllowedIPs:          ipaddr.Hosts(userVPN.IPAddress, userVPN.IPAddress6),
+		})
+
+		for j := range devices[userVPN.ID] {
+			device := &devices[userVPN.ID][j]
+			presharedKey := device.PresharedKey
+			peers = append(peers, wireguard.PeerConfig{
+				PublicKey:           device.PublicKey,
+				PresharedKey:        &presharedKey,
+				PersistentKeepalive: &keepalive,
+				AllowedIPs:          ipaddr.Hosts(device.IPAddress, device.IPAddress6),
+			})
 		}
-		return "", fmt.Errorf("查询可用IP失败: %w", err)
 	}
 
-	return ipPool.IPAddress, nil
+	return peers
+}
+
+// peerReconcileMu 对等端同步锁
+var peerReconcileMu sync.Mutex
+
+// ReconcilePeers 将运行中接口的对等端与数据库同步，只变更有差异的对等端，不中断其他隧道
+func (wis *WireGuardInterfaceService) ReconcilePeers(wgInterface *models.WireGuardInterface) (*wireguard.ReconcileResult, error) {
+	// 串行化同步，避免并发请求用过期的数据库快照覆盖较新的结果
+	peerReconcileMu.Lock()
+	defer peerReconcileMu.Unlock()
+
+	result, err := wireguard.ReconcilePeers(wireguard.GetBackend(), wgInterface.Name, wis.desiredPeers(wgInterface))
+	if err != nil {
+		return nil, fmt.Errorf("同步接口 %s 对等端失败: %w", wgInterface.Name, err)
+	}
+	return result, nil
 }
 
 // GetInterfaceTrafficStats 获取接口流量统计
@@ -749,234 +837,49 @@ type WireGuardPeerInfo struct {
 	LastSeenAgo         string    `json:"last_seen_ago"`
 }
 
-// ParseWireGuardShow 解析wg show命令的输出
+// ParseWireGuardShow 读取WireGuard接口的运行状态
 func (wis *WireGuardInterfaceService) ParseWireGuardShow(interfaceName string) (*WireGuardShowInfo, error) {
-	// 执行wg show命令
-	cmd := exec.Command("wg", "show", interfaceName)
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice(interfaceName)
 	if err != nil {
-		return nil, fmt.Errorf("执行wg show命令失败: %w", err)
-	}
-
-	// 解析输出
-	return wis.parseWireGuardShowOutput(string(output))
-}
-
-// parseWireGuardShowOutput 解析wg show命令的文本输出
-func (wis *WireGuardInterfaceService) parseWireGuardShowOutput(output string) (*WireGuardShowInfo, error) {
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	if len(lines) == 0 {
-		return nil, fmt.Errorf("wg show输出为空")
+		return nil, fmt.Errorf("读取WireGuard接口状态失败: %w", err)
 	}
 
 	info := &WireGuardShowInfo{
-		Peers: make([]WireGuardPeerInfo, 0),
+		InterfaceName: device.Name,
+		PublicKey:     device.PublicKey,
+		ListenPort:    device.ListenPort,
+		Peers:         make([]WireGuardPeerInfo, 0, len(device.Peers)),
+		TotalPeers:    len(device.Peers),
 	}
 
-	var currentPeer *WireGuardPeerInfo
-	var currentSection string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	now := time.Now()
+	for _, peer := range device.Peers {
+		peerInfo := WireGuardPeerInfo{
+			PublicKey:           peer.PublicKey,
+			Endpoint:            peer.Endpoint,
+			AllowedIPs:          peer.AllowedIPs,
+			LatestHandshake:     peer.LatestHandshake,
+			TransferRxBytes:     peer.ReceiveBytes,
+			TransferTxBytes:     peer.TransmitBytes,
+			TransferRxFormatted: utils.FormatBytes(peer.ReceiveBytes),
+			TransferTxFormatted: utils.FormatBytes(peer.TransmitBytes),
+			PersistentKeepalive: peer.PersistentKeepalive,
 		}
 
-		// 判断当前行属于哪个部分
-		if strings.HasPrefix(line, "interface:") {
-			currentSection = "interface"
-			info.InterfaceName = strings.TrimSpace(strings.TrimPrefix(line, "interface:"))
-		} else if strings.HasPrefix(line, "peer:") {
-			currentSection = "peer"
-			// 如果有上一个peer，先保存
-			if currentPeer != nil {
-				info.Peers = append(info.Peers, *currentPeer)
-			}
-			// 开始新的peer
-			currentPeer = &WireGuardPeerInfo{
-				PublicKey: strings.TrimSpace(strings.TrimPrefix(line, "peer:")),
-			}
-		} else if currentSection == "interface" {
-			// 解析接口信息
-			if strings.HasPrefix(line, "public key:") {
-				info.PublicKey = strings.TrimSpace(strings.TrimPrefix(line, "public key:"))
-			} else if strings.HasPrefix(line, "listening port:") {
-				portStr := strings.TrimSpace(strings.TrimPrefix(line, "listening port:"))
-				if port, err := parsePort(portStr); err == nil {
-					info.ListenPort = port
-				}
-			}
-		} else if currentSection == "peer" && currentPeer != nil {
-			// 解析peer信息
-			if strings.HasPrefix(line, "endpoint:") {
-				currentPeer.Endpoint = strings.TrimSpace(strings.TrimPrefix(line, "endpoint:"))
-			} else if strings.HasPrefix(line, "allowed ips:") {
-				ipsStr := strings.TrimSpace(strings.TrimPrefix(line, "allowed ips:"))
-				currentPeer.AllowedIPs = parseAllowedIPs(ipsStr)
-			} else if strings.HasPrefix(line, "latest handshake:") {
-				handshakeStr := strings.TrimSpace(strings.TrimPrefix(line, "latest handshake:"))
-				currentPeer.LatestHandshake, currentPeer.LastSeenAgo = parseLatestHandshake(handshakeStr)
-			} else if strings.HasPrefix(line, "transfer:") {
-				transferStr := strings.TrimSpace(strings.TrimPrefix(line, "transfer:"))
-				currentPeer.TransferRxBytes, currentPeer.TransferTxBytes, currentPeer.TransferRxFormatted, currentPeer.TransferTxFormatted = parseTransfer(transferStr)
-			} else if strings.HasPrefix(line, "persistent keepalive:") {
-				keepaliveStr := strings.TrimSpace(strings.TrimPrefix(line, "persistent keepalive:"))
-				currentPeer.PersistentKeepalive = parsePersistentKeepalive(keepaliveStr)
-			}
+		if !peer.LatestHandshake.IsZero() {
+			peerInfo.LastSeenAgo = utils.TimeAgo(peer.LatestHandshake)
 		}
-	}
-
-	// 保存最后一个peer
-	if currentPeer != nil {
-		info.Peers = append(info.Peers, *currentPeer)
-	}
-
-	// 计算统计信息
-	info.TotalPeers = len(info.Peers)
-	info.OnlinePeers = 0
-	now := time.Now()
 
-	for i := range info.Peers {
 		// 使用统一的超时常量判断peer是否在线
-		if info.Peers[i].LatestHandshake.After(now.Add(-config.WireGuardOnlineTimeout)) {
-			info.Peers[i].IsOnline = true
+		if peer.LatestHandshake.After(now.Add(-config.WireGuardOnlineTimeout)) {
+			peerInfo.IsOnline = true
 			info.OnlinePeers++
-		} else {
-			info.Peers[i].IsOnline = false
-		}
-	}
-
-	return info, nil
-}
-
-// parsePort 解析端口号
-func parsePort(portStr string) (int, error) {
-	var port int
-	_, err := fmt.Sscanf(portStr, "%d", &port)
-	return port, err
-}
-
-// parseAllowedIPs 解析允许的IP地址列表
-func parseAllowedIPs(ipsStr string) []string {
-	ips := strings.Split(ipsStr, ",")
-	result := make([]string, 0, len(ips))
-	for _, ip := range ips {
-		ip = strings.TrimSpace(ip)
-		if ip != "" {
-			result = append(result, ip)
-		}
-	}
-	return result
-}
-
-// parseLatestHandshake 解析最新握手时间
-func parseLatestHandshake(handshakeStr string) (time.Time, string) {
-	now := time.Now()
-
-	// 处理相对时间格式
-	if strings.Contains(handshakeStr, "ago") {
-		// 移除"ago"并解析时间
-		timeStr := strings.TrimSpace(strings.TrimSuffix(handshakeStr, "ago"))
-
-		// 解析各种时间格式
-		if strings.Contains(timeStr, "second") {
-			var seconds int
-			fmt.Sscanf(timeStr, "%d second", &seconds)
-			if strings.Contains(timeStr, "seconds") {
-				fmt.Sscanf(timeStr, "%d seconds", &seconds)
-			}
-			return now.Add(-time.Duration(seconds) * time.Second), handshakeStr
-		} else if strings.Contains(timeStr, "minute") {
-			var minutes int
-			fmt.Sscanf(timeStr, "%d minute", &minutes)
-			if strings.Contains(timeStr, "minutes") {
-				fmt.Sscanf(timeStr, "%d minutes", &minutes)
-			}
-			return now.Add(-time.Duration(minutes) * time.Minute), handshakeStr
-		} else if strings.Contains(timeStr, "hour") {
-			var hours int
-			fmt.Sscanf(timeStr, "%d hour", &hours)
-			if strings.Contains(timeStr, "hours") {
-				fmt.Sscanf(timeStr, "%d hours", &hours)
-			}
-			return now.Add(-time.Duration(hours) * time.Hour), handshakeStr
-		} else if strings.Contains(timeStr, "day") {
-			var days int
-			fmt.Sscanf(timeStr, "%d day", &days)
-			if strings.Contains(timeStr, "days") {
-				fmt.Sscanf(timeStr, "%d days", &days)
-			}
-			return now.Add(-time.Duration(days) * 24 * time.Hour), handshakeStr
 		}
-	}
-
-	// 如果无法解析，返回当前时间
-	return now, handshakeStr
-}
-
-// parseTransfer 解析传输数据
-func parseTransfer(transferStr string) (rxBytes, txBytes uint64, rxFormatted, txFormatted string) {
-	// 示例: "60.07 KiB received, 851.23 KiB sent"
-	parts := strings.Split(transferStr, ",")
-	if len(parts) != 2 {
-		return 0, 0, "", ""
-	}
 
-	// 解析接收数据
-	rxPart := strings.TrimSpace(parts[0])
-	rxFormatted = rxPart
-	if strings.Contains(rxPart, "received") {
-		rxBytes = parseDataSize(rxPart)
+		info.Peers = append(info.Peers, peerInfo)
 	}
 
-	// 解析发送数据
-	txPart := strings.TrimSpace(parts[1])
-	txFormatted = txPart
-	if strings.Contains(txPart, "sent") {
-		txBytes = parseDataSize(txPart)
-	}
-
-	return rxBytes, txBytes, rxFormatted, txFormatted
-}
-
-// parseDataSize 解析数据大小（KiB, MiB, GiB等）
-func parseDataSize(sizeStr string) uint64 {
-	var size float64
-	var unit string
-
-	// 提取数字和单位
-	fmt.Sscanf(sizeStr, "%f %s", &size, &unit)
-
-	// 根据单位转换为字节
-	switch strings.ToLower(unit) {
-	case "b", "byte", "bytes":
-		return uint64(size)
-	case "kib":
-		return uint64(size * 1024)
-	case "mib":
-		return uint64(size * 1024 * 1024)
-	case "gib":
-		return uint64(size * 1024 * 1024 * 1024)
-	case "kb":
-		return uint64(size * 1000)
-	case "mb":
-		return uint64(size * 1000 * 1000)
-	case "gb":
-		return uint64(size * 1000 * 1000 * 1000)
-	default:
-		return uint64(size)
-	}
-}
-
-// parsePersistentKeepalive 解析保活间隔
-func parsePersistentKeepalive(keepaliveStr string) int {
-	// 示例: "every 25 seconds"
-	if strings.Contains(keepaliveStr, "every") {
-		var seconds int
-		fmt.Sscanf(keepaliveStr, "every %d seconds", &seconds)
-		return seconds
-	}
-	return 0
+	return info, nil
 }
 
 // GetWireGuardShowInfo 获取WireGuard接口的show信息（便捷方法）
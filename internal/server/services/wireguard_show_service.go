@@ -2,12 +2,13 @@ package services
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 
 	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/wireguard"
 )
 
 // WireGuardShowService WireGuard状态检测服务
@@ -68,10 +69,10 @@ type TrafficData struct {
 // 核心检测方法
 // =====================================================
 
-// CheckWireGuardInstalled 检查WireGuard是否安装
+// CheckWireGuardInstalled 检查系统是否支持WireGuard
 func (wss *WireGuardShowService) CheckWireGuardInstalled() bool {
-	cmd := exec.Command("wg", "version")
-	return cmd.Run() == nil
+	_, err := wireguard.GetBackend().ListDevices()
+	return err == nil
 }
 
 // GetWireGuardVersion 获取WireGuard版本
@@ -80,10 +81,11 @@ func (wss *WireGuardShowService) GetWireGuardVersion() string {
 		return "not_installed"
 	}
 
+	// 未安装wireguard-tools时只能确认内核支持
 	cmd := exec.Command("wg", "version")
 	output, err := cmd.Output()
 	if err != nil {
-		return "unknown"
+		return "kernel"
 	}
 
 	// 提取版本信息
@@ -97,19 +99,9 @@ func (wss *WireGuardShowService) GetWireGuardVersion() string {
 
 // CheckServiceRunning 检查WireGuard服务是否运行
 func (wss *WireGuardShowService) CheckServiceRunning() bool {
-	if !wss.CheckWireGuardInstalled() {
-		return false
-	}
-
-	// 尝试执行wg show，如果有任何接口运行，服务就是运行的
-	cmd := exec.Command("wg", "show")
-	output, err := cmd.Output()
-	if err != nil {
-		return false
-	}
-
-	// 如果有输出，说明至少有一个接口在运行
-	return strings.TrimSpace(string(output)) != ""
+	// 至少有一个接口在运行，服务就是运行的
+	devices, err := wireguard.GetBackend().ListDevices()
+	return err == nil && len(devices) > 0
 }
 
 // GetSystemInfo 获取WireGuard系统完整信息
@@ -141,15 +133,7 @@ func (wss *WireGuardShowService) GetSystemInfo() *WireGuardSystemInfo {
 
 // GetInterfaceInfo 获取单个接口的实时状态
 func (wss *WireGuardShowService) GetInterfaceInfo(interfaceName string) (*InterfaceShowInfo, error) {
-	if !wss.CheckWireGuardInstalled() {
-		return &InterfaceShowInfo{
-			Name:     interfaceName,
-			IsActive: false,
-		}, nil
-	}
-
-	cmd := exec.Command("wg", "show", interfaceName, "dump")
-	output, err := cmd.Output()
+	device, err := wireguard.GetBackend().GetDevice(interfaceName)
 	if err != nil {
 		// 接口不存在或未激活
 		return &InterfaceShowInfo{
@@ -158,22 +142,23 @@ func (wss *WireGuardShowService) GetInterfaceInfo(interfaceName string) (*Interf
 		}, nil
 	}
 
-	return wss.parseInterfaceDump(interfaceName, string(output))
+	return wss.buildInterfaceInfo(device), nil
 }
 
 // GetAllInterfacesInfo 获取所有接口的实时状态
 func (wss *WireGuardShowService) GetAllInterfacesInfo() (map[string]*InterfaceShowInfo, error) {
-	if !wss.CheckWireGuardInstalled() {
-		return make(map[string]*InterfaceShowInfo), nil
-	}
+	interfaces := make(map[string]*InterfaceShowInfo)
 
-	cmd := exec.Command("wg", "show", "all", "dump")
-	output, err := cmd.Output()
+	devices, err := wireguard.GetBackend().ListDevices()
 	if err != nil {
-		return make(map[string]*InterfaceShowInfo), nil
+		return interfaces, nil
 	}
 
-	return wss.parseAllInterfacesDump(string(output))
+	for _, device := range devices {
+		interfaces[device.Name] = wss.buildInterfaceInfo(device)
+	}
+
+	return interfaces, nil
 }
 
 // GetPeerInfo 获取特定peer的实时状态
@@ -190,164 +175,61 @@ func (wss *WireGuardShowService) GetPeerInfo(interfaceName, publicKey string) (*
 	return nil, fmt.Errorf("peer not found")
 }
 
-// =====================================================
-// WireGuard dump格式解析
-// =====================================================
-
-// parseInterfaceDump 解析单个接口的dump输出
-func (wss *WireGuardShowService) parseInterfaceDump(interfaceName, output string) (*InterfaceShowInfo, error) {
+// buildInterfaceInfo 由接口运行状态生成show信息
+func (wss *WireGuardShowService) buildInterfaceInfo(device *wireguard.Device) *InterfaceShowInfo {
 	info := &InterfaceShowInfo{
-		Name:     interfaceName,
-		IsActive: true,
-		Peers:    make(map[string]*PeerShowInfo),
+		Name:       device.Name,
+		PublicKey:  device.PublicKey,
+		ListenPort: device.ListenPort,
+		IsActive:   true,
+		Peers:      make(map[string]*PeerShowInfo),
 	}
 
 	var totalRx, totalTx uint64
 	var lastHandshake *time.Time
 
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	isFirstLine := true
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
+	for _, p := range device.Peers {
+		peer := &PeerShowInfo{
+			PublicKey:    p.PublicKey,
+			Endpoint:     p.Endpoint,
+			AllowedIPs:   p.AllowedIPs,
+			TrafficStats: wss.formatTrafficData(p.ReceiveBytes, p.TransmitBytes),
+			PersistentKA: p.PersistentKeepalive,
 		}
 
-		fields := strings.Split(line, "\t")
-		if len(fields) < 2 {
-			continue
-		}
-
-		// 第一行是接口信息：private_key \t public_key \t listen_port \t off
-		if isFirstLine && len(fields) >= 3 {
-			info.PublicKey = fields[1] // 第二个字段是public_key
-			if port, err := strconv.Atoi(fields[2]); err == nil {
-				info.ListenPort = port
-			}
-			isFirstLine = false
-			continue
-		}
-
-		// 其他行是peer信息：peer_public_key \t preshared_key \t endpoint \t allowed_ips \t latest_handshake \t rx_bytes \t tx_bytes \t persistent_keepalive
-		if len(fields) >= 6 {
-			peer := &PeerShowInfo{
-				PublicKey:  fields[0],                     // 第一个字段就是peer的public_key
-				AllowedIPs: strings.Split(fields[3], ","), // 调整索引
-			}
-
-			// 解析端点
-			if len(fields) > 2 && fields[2] != "(none)" {
-				peer.Endpoint = fields[2]
-			}
+		if !p.LatestHandshake.IsZero() {
+			handshakeTime := p.LatestHandshake
+			peer.LatestHandshake = &handshakeTime
+			peer.LastSeenAgo = wss.formatTimeAgo(handshakeTime)
 
-			// 解析握手时间
-			if len(fields) > 4 && fields[4] != "0" && fields[4] != "" {
-				if timestamp, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
-					handshakeTime := time.Unix(timestamp, 0)
-					peer.LatestHandshake = &handshakeTime
-					peer.LastSeenAgo = wss.formatTimeAgo(handshakeTime)
-
-					// 使用统一的超时常量判断在线状态
-					timeSinceHandshake := time.Since(handshakeTime)
-					if timeSinceHandshake <= config.WireGuardOnlineTimeout {
-						peer.IsOnline = true
-						info.ActivePeers++
-					}
-
-					// 更新接口最后握手时间
-					if lastHandshake == nil || handshakeTime.After(*lastHandshake) {
-						lastHandshake = &handshakeTime
-					}
-				}
-			} else {
-				// 如果没有握手记录但有流量，也可能是在线的
-				// 检查是否有流量传输
-				var hasTraffic bool
-				if len(fields) > 5 {
-					if rx, err := strconv.ParseUint(fields[5], 10, 64); err == nil && rx > 0 {
-						hasTraffic = true
-					}
-				}
-				if len(fields) > 6 {
-					if tx, err := strconv.ParseUint(fields[6], 10, 64); err == nil && tx > 0 {
-						hasTraffic = true
-					}
-				}
-
-				if hasTraffic {
-					peer.IsOnline = true
-					info.ActivePeers++
-				}
+			// 使用统一的超时常量判断在线状态
+			if time.Since(handshakeTime) <= config.WireGuardOnlineTimeout {
+				peer.IsOnline = true
+				info.ActivePeers++
 			}
 
-			// 解析流量数据
-			var rxBytes, txBytes uint64
-			if len(fields) > 5 {
-				if rx, err := strconv.ParseUint(fields[5], 10, 64); err == nil {
-					rxBytes = rx
-					totalRx += rx
-				}
+			// 更新接口最后握手时间
+			if lastHandshake == nil || handshakeTime.After(*lastHandshake) {
+				lastHandshake = &handshakeTime
 			}
-			if len(fields) > 6 {
-				if tx, err := strconv.ParseUint(fields[6], 10, 64); err == nil {
-					txBytes = tx
-					totalTx += tx
-				}
-			}
-			peer.TrafficStats = wss.formatTrafficData(rxBytes, txBytes)
+		} else if p.ReceiveBytes > 0 || p.TransmitBytes > 0 {
+			// 如果没有握手记录但有流量，也可能是在线的
+			peer.IsOnline = true
+			info.ActivePeers++
+		}
 
-			// 解析保活间隔
-			if len(fields) > 7 && fields[7] != "off" {
-				if ka, err := strconv.Atoi(fields[7]); err == nil {
-					peer.PersistentKA = ka
-				}
-			}
+		totalRx += p.ReceiveBytes
+		totalTx += p.TransmitBytes
 
-			info.Peers[peer.PublicKey] = peer
-			info.PeerCount++
-		}
+		info.Peers[peer.PublicKey] = peer
+		info.PeerCount++
 	}
 
 	// 设置接口总流量和最后握手时间
 	info.TotalTraffic = wss.formatTrafficData(totalRx, totalTx)
 	info.LastHandshake = lastHandshake
 
-	return info, nil
-}
-
-// parseAllInterfacesDump 解析所有接口的dump输出
-func (wss *WireGuardShowService) parseAllInterfacesDump(output string) (map[string]*InterfaceShowInfo, error) {
-	interfaces := make(map[string]*InterfaceShowInfo)
-
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-
-	// 按接口分组
-	interfaceLines := make(map[string][]string)
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		fields := strings.Split(line, "\t")
-		if len(fields) >= 2 {
-			interfaceName := fields[0]
-			if interfaceLines[interfaceName] == nil {
-				interfaceLines[interfaceName] = make([]string, 0)
-			}
-			interfaceLines[interfaceName] = append(interfaceLines[interfaceName], line)
-		}
-	}
-
-	// 解析每个接口
-	for interfaceName, lines := range interfaceLines {
-		output := strings.Join(lines, "\n")
-		if info, err := wss.parseInterfaceDump(interfaceName, output); err == nil {
-			interfaces[interfaceName] = info
-		}
-	}
-
-	return interfaces, nil
+	return info
 }
 
 // =====================================================
@@ -434,7 +316,7 @@ func (wss *WireGuardShowService) IsPeerOnline(interfaceName, publicKey string) b
 
 // CheckConfigExists 检查配置文件是否存在
 func (wss *WireGuardShowService) CheckConfigExists(interfaceName string) bool {
-	configPath := fmt.Sprintf("/etc/wireguard/%s.conf", interfaceName)
-	cmd := exec.Command("test", "-f", configPath)
-	return cmd.Run() == nil
+	configPath := wireguard.ConfigPath(interfaceName)
+	_, err := os.Stat(configPath)
+	return err == nil
 }
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix 个人访问令牌前缀，认证中间件据此区分个人访问令牌和JWT
+const APITokenPrefix = "evp_"
+
+var (
+	// ErrAPITokenInvalid 令牌不存在、已过期、已吊销或所属用户已停用
+	ErrAPITokenInvalid = errors.New("个人访问令牌无效或已失效")
+	// ErrAPITokenNotFound 令牌不存在或不属于指定用户
+	ErrAPITokenNotFound = errors.New("令牌不存在")
+)
+
+// APITokenService 个人访问令牌服务，数据库中只保存令牌哈希
+type APITokenService struct {
+	db *gorm.DB
+}
+
+// NewAPITokenService 创建个人访问令牌服务
+func NewAPITokenService() *APITokenService {
+	return &APITokenService{
+		db: database.DB,
+	}
+}
+
+// IsAPIToken 判断Bearer令牌是否为个人访问令牌
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// CreateToken 为用户创建个人访问令牌，授权范围不能超出用户角色的权限，明文令牌只在创建时返回一次
+func (ts *APITokenService) CreateToken(user *models.User, req *models.APITokenRequest) (*models.APIToken, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, "", errors.New("令牌名称不能为空")
+	}
+	if req.ExpiresInDays < 0 {
+		return nil, "", errors.New("有效天数不能为负数")
+	}
+
+	var scopes []string
+	seen := make(map[models.Permission]bool)
+	for _, scope := range req.Scopes {
+		if !scope.Valid() {
+			return nil, "", fmt.Errorf("无效的授权范围: %s", scope)
+		}
+		if !user.Role.Can(scope) {
+			return nil, "", fmt.Errorf("授权范围超出当前角色的权限: %s", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, string(scope))
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("至少需要一个授权范围")
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	secret, err := utils.GenerateRandomString(40)
+	if err != nil {
+		return nil, "", fmt.Errorf("生成个人访问令牌失败: %w", err)
+	}
+	plaintext := APITokenPrefix + secret
+
+	token := &models.APIToken{
+		UserID:      user.ID,
+		Name:        name,
+		TokenPrefix: plaintext[:12],
+		TokenHash:   hashToken(plaintext),
+		Scopes:      strings.Join(scopes, ","),
+		ExpiresAt:   expiresAt,
+	}
+	if err := ts.db.Create(token).Error; err != nil {
+		return nil, "", fmt.Errorf("保存个人访问令牌失败: %w", err)
+	}
+
+	return token, plaintext, nil
+}
+
+// GetUserTokens 获取用户的全部令牌，包括已吊销和已过期的
+func (ts *APITokenService) GetUserTokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	if err := ts.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("查询个人访问令牌失败: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken 吊销属于用户的令牌，返回被吊销的令牌
+func (ts *APITokenService) RevokeToken(userID, tokenID uint) (*models.APIToken, error) {
+	var token models.APIToken
+	if err := ts.db.Where("id = ? AND user_id = ?", tokenID, userID).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPITokenNotFound
+		}
+		return nil, fmt.Errorf("查询个人访问令牌失败: %w", err)
+	}
+
+	if token.RevokedAt != nil {
+		return &token, nil
+	}
+
+	now := time.Now()
+	if err := ts.db.Model(&token).Update("revoked_at", now).Error; err != nil {
+		return nil, fmt.Errorf("吊销个人访问令牌失败: %w", err)
+	}
+	token.RevokedAt = &now
+
+	return &token, nil
+}
+
+// Authenticate 校验个人访问令牌，返回令牌及其所属用户，并记录最后使用时间和来源IP
+func (ts *APITokenService) Authenticate(plaintext, clientIP string) (*models.APIToken, *models.User, error) {
+	var token models.APIToken
+	if err := ts.db.Where("token_hash = ?", hashToken(plaintext)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrAPITokenInvalid
+		}
+		return nil, nil, fmt.Errorf("查询个人访问令牌失败: %w", err)
+	}
+	if !token.IsActive() {
+		return nil, nil, ErrAPITokenInvalid
+	}
+
+	// 用户停用或删除后令牌随之失效，角色变化后令牌权限随之变化
+	var user models.User
+	if err := ts.db.Where("id = ? AND is_active = ?", token.UserID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrAPITokenInvalid
+		}
+		return nil, nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	now := time.Now()
+	ts.db.Model(&token).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"last_used_ip": clientIP,
+	})
+	token.LastUsedAt = &now
+	token.LastUsedIP = clientIP
+
+	return &token, &user, nil
+}
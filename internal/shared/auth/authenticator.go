@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// Authenticator 用户名密码认证后端。UserService.Login 按顺序尝试各个后端，
+// 第一个认证成功的后端决定登录的用户
+type Authenticator interface {
+	// Name 后端名称，用于日志
+	Name() string
+	// Authenticate 校验用户名和密码，返回对应的本地用户，外部后端按需创建或更新本地用户。
+	// 用户不存在或密码错误时返回ErrInvalidCredentials，其他错误表示拒绝登录或后端故障
+	Authenticate(username, password string) (*models.User, error)
+}
+
+// LocalAuthenticator 本地账户认证，校验数据库中的bcrypt密码哈希
+type LocalAuthenticator struct {
+	db *gorm.DB
+}
+
+// NewLocalAuthenticator 创建本地账户认证后端
+func NewLocalAuthenticator() *LocalAuthenticator {
+	return &LocalAuthenticator{
+		db: database.DB,
+	}
+}
+
+// Name 后端名称
+func (a *LocalAuthenticator) Name() string {
+	return models.AuthSourceLocal
+}
+
+// Authenticate 校验本地账户密码，外部身份源创建的用户不能使用本地密码登录
+func (a *LocalAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	var user models.User
+	if err := a.db.Where("username = ? AND is_active = ?", username, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	if user.AuthSource != "" && user.AuthSource != models.AuthSourceLocal {
+		return nil, ErrInvalidCredentials
+	}
+	if !utils.CheckPassword(password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// ErrUsernameTaken 自动创建外部用户时用户名已被本地账户或其他外部用户使用
+var ErrUsernameTaken = errors.New("用户名已被其他账户使用，请联系管理员")
+
+// mapRole 根据用户组确定角色，匹配多个用户组时取权限最高的角色，没有匹配时使用默认角色。
+// 用户组名称不区分大小写
+func mapRole(mapping map[string]string, defaultRole string, groups []string) (models.UserRole, bool) {
+	var role models.UserRole
+	for _, group := range groups {
+		for name, value := range mapping {
+			mapped := models.UserRole(value)
+			if strings.EqualFold(name, group) && mapped.Valid() && !role.AtLeast(mapped) {
+				role = mapped
+			}
+		}
+	}
+	if role == "" {
+		role = models.UserRole(defaultRole)
+	}
+	return role, role.Valid()
+}
+
+// validateRoleMapping 检查角色映射和默认角色是否有效，prefix为配置项路径
+func validateRoleMapping(prefix string, mapping map[string]string, defaultRole string) error {
+	for group, role := range mapping {
+		if !models.UserRole(role).Valid() {
+			return fmt.Errorf("%s.role_mapping 中用户组 %s 的角色无效: %s", prefix, group, role)
+		}
+	}
+	if defaultRole != "" && !models.UserRole(defaultRole).Valid() {
+		return fmt.Errorf("%s.default_role 无效: %s", prefix, defaultRole)
+	}
+	return nil
+}
+
+// syncExternalUser 按来源和外部标识查找已关联的用户，每次登录都以外部身份源为准更新角色；
+// 用户不存在且允许自动创建时创建新用户。created表示用户是本次创建的
+func syncExternalUser(db *gorm.DB, source, externalID, username string, role models.UserRole, autoProvision bool) (user *models.User, created bool, err error) {
+	now := time.Now()
+	var existing models.User
+	err = db.Where("auth_source = ? AND external_id = ?", source, externalID).First(&existing).Error
+	if err == nil {
+		if !existing.IsActive {
+			return nil, false, ErrAccountDisabled
+		}
+		if err := db.Model(&existing).Updates(map[string]interface{}{"role": role, "last_login": now}).Error; err != nil {
+			return nil, false, fmt.Errorf("更新用户失败: %w", err)
+		}
+		existing.Role = role
+		existing.LastLogin = &now
+		return &existing, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("查询用户失败: %w", err)
+	}
+	if !autoProvision {
+		return nil, false, ErrAccessDenied
+	}
+
+	if username == "" || len(username) > 50 {
+		return nil, false, fmt.Errorf("外部身份源返回的用户名无效: %q", username)
+	}
+	// 不自动关联同名的本地账户，避免外部身份源中的用户名冒用本地管理员
+	var count int64
+	if err := db.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, false, fmt.Errorf("查询用户失败: %w", err)
+	}
+	if count > 0 {
+		return nil, false, fmt.Errorf("%w: %s", ErrUsernameTaken, username)
+	}
+
+	// 外部用户不能使用本地密码登录，保存一个无人知道的随机密码
+	secret, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return nil, false, err
+	}
+	password, err := utils.HashPassword(secret)
+	if err != nil {
+		return nil, false, fmt.Errorf("密码加密失败: %w", err)
+	}
+
+	user = &models.User{
+		Username:   username,
+		Password:   password,
+		Role:       role,
+		IsActive:   true,
+		LastLogin:  &now,
+		AuthSource: source,
+		ExternalID: externalID,
+	}
+	if err := db.Create(user).Error; err != nil {
+		return nil, false, fmt.Errorf("创建用户失败: %w", err)
+	}
+	log.Printf("自动创建%s用户 %s，角色 %s", source, username, role)
+	return user, true, nil
+}
@@ -6,15 +6,19 @@ import (
 	"time"
 
 	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/utils"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // JWTClaims JWT声明
 type JWTClaims struct {
-	UserID   uint      `json:"user_id"`
-	Username string    `json:"username"`
-	Type     TokenType `json:"type"`
+	UserID   uint            `json:"user_id"`
+	Username string          `json:"username"`
+	Role     models.UserRole `json:"role"`
+	Type     TokenType       `json:"type"`
+	// SessionID 令牌所属会话，会话撤销后令牌立即失效
+	SessionID uint `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -24,13 +28,23 @@ type TokenType string
 const (
 	AccessToken  TokenType = "access"
 	RefreshToken TokenType = "refresh"
+	// MFAToken 密码验证通过后等待输入两步验证码的临时令牌
+	MFAToken TokenType = "mfa"
+	// MFASetupToken 策略要求两步验证但用户尚未绑定时，用于首次绑定的临时令牌
+	MFASetupToken TokenType = "mfa_setup"
 )
 
+// MFATokenExpiry 两步验证临时令牌的有效期
+const MFATokenExpiry = 5 * time.Minute
+
 // TokenPair 令牌对
 type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"`
+
+	RefreshTokenID   string    `json:"-"` // 刷新令牌的jti，登记到会话用于轮换和重用检测
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
 // JWTService JWT服务
@@ -51,15 +65,22 @@ func NewJWTService(accessSecret, refreshSecret string, accessExpiry, refreshExpi
 	}
 }
 
-// GenerateTokenPair 生成令牌对
-func (j *JWTService) GenerateTokenPair(user *models.User) (*TokenPair, error) {
+// GenerateTokenPair 为会话生成令牌对，刷新令牌带有随机jti
+func (j *JWTService) GenerateTokenPair(user *models.User, sessionID uint) (*TokenPair, error) {
 	now := time.Now()
+	refreshExpiresAt := now.Add(j.refreshExpiry)
+	refreshTokenID, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("生成令牌ID失败: %w", err)
+	}
 
 	// 生成访问令牌
 	accessClaims := &JWTClaims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Type:     AccessToken,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		Type:      AccessToken,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "eitec-vpn",
 			Subject:   fmt.Sprintf("%d", user.ID),
@@ -78,14 +99,17 @@ func (j *JWTService) GenerateTokenPair(user *models.User) (*TokenPair, error) {
 
 	// 生成刷新令牌
 	refreshClaims := &JWTClaims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Type:     RefreshToken,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		Type:      RefreshToken,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        refreshTokenID,
 			Issuer:    "eitec-vpn",
 			Subject:   fmt.Sprintf("%d", user.ID),
 			Audience:  []string{"eitec-vpn-server"},
-			ExpiresAt: jwt.NewNumericDate(now.Add(j.refreshExpiry)),
+			ExpiresAt: jwt.NewNumericDate(refreshExpiresAt),
 			NotBefore: jwt.NewNumericDate(now),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
@@ -101,9 +125,42 @@ func (j *JWTService) GenerateTokenPair(user *models.User) (*TokenPair, error) {
 		AccessToken:  accessTokenString,
 		RefreshToken: refreshTokenString,
 		ExpiresIn:    int64(j.accessExpiry.Seconds()),
+
+		RefreshTokenID:   refreshTokenID,
+		RefreshExpiresAt: refreshExpiresAt,
 	}, nil
 }
 
+// GenerateMFAToken 生成两步验证临时令牌，tokenType为MFAToken或MFASetupToken
+func (j *JWTService) GenerateMFAToken(user *models.User, tokenType TokenType) (string, error) {
+	now := time.Now()
+	claims := &JWTClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Type:     tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "eitec-vpn",
+			Subject:   fmt.Sprintf("%d", user.ID),
+			Audience:  []string{"eitec-vpn-server"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenExpiry)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(j.accessSecret))
+	if err != nil {
+		return "", fmt.Errorf("生成两步验证令牌失败: %w", err)
+	}
+	return token, nil
+}
+
+// ValidateMFAToken 验证两步验证临时令牌
+func (j *JWTService) ValidateMFAToken(tokenString string, tokenType TokenType) (*JWTClaims, error) {
+	return j.validateToken(tokenString, j.accessSecret, tokenType)
+}
+
 // ValidateAccessToken 验证访问令牌
 func (j *JWTService) ValidateAccessToken(tokenString string) (*JWTClaims, error) {
 	return j.validateToken(tokenString, j.accessSecret, AccessToken)
@@ -146,7 +203,7 @@ func (j *JWTService) validateToken(tokenString, secret string, expectedType Toke
 	return claims, nil
 }
 
-// RefreshTokenPair 刷新令牌对
+// RefreshTokenPair 刷新令牌对，新令牌对属于同一会话。调用方需通过SessionManager消费旧刷新令牌并登记新令牌
 func (j *JWTService) RefreshTokenPair(refreshTokenString string, user *models.User) (*TokenPair, error) {
 	// 验证刷新令牌
 	claims, err := j.ValidateRefreshToken(refreshTokenString)
@@ -160,7 +217,7 @@ func (j *JWTService) RefreshTokenPair(refreshTokenString string, user *models.Us
 	}
 
 	// 生成新的令牌对
-	return j.GenerateTokenPair(user)
+	return j.GenerateTokenPair(user, claims.SessionID)
 }
 
 // ExtractTokenFromHeader 从HTTP头部提取令牌
@@ -198,33 +255,6 @@ func GetUserFromClaims(claims *JWTClaims) *models.User {
 	return &models.User{
 		ID:       claims.UserID,
 		Username: claims.Username,
+		Role:     claims.Role,
 	}
 }
-
-// CreateAPIKey 生成API密钥 (长期有效的特殊令牌)
-func (j *JWTService) CreateAPIKey(user *models.User, description string, expiryDays int) (string, error) {
-	now := time.Now()
-	expiry := now.AddDate(0, 0, expiryDays)
-
-	claims := &JWTClaims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Type:     "api_key",
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "eitec-vpn",
-			Subject:   fmt.Sprintf("api_%d", user.ID),
-			Audience:  []string{"eitec-vpn-api"},
-			ExpiresAt: jwt.NewNumericDate(expiry),
-			NotBefore: jwt.NewNumericDate(now),
-			IssuedAt:  jwt.NewNumericDate(now),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.accessSecret))
-}
-
-// ValidateAPIKey 验证API密钥
-func (j *JWTService) ValidateAPIKey(tokenString string) (*JWTClaims, error) {
-	return j.validateToken(tokenString, j.accessSecret, "api_key")
-}
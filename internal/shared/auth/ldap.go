@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/config"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// LDAPAuthenticator LDAP目录认证：用服务账号查找用户条目和所属组，再以用户DN和密码绑定校验密码，
+// 按所属组映射角色。每次认证使用新连接
+type LDAPAuthenticator struct {
+	db     *gorm.DB
+	config config.LDAPConfig
+}
+
+// LDAPTestResult LDAP连接测试结果
+type LDAPTestResult struct {
+	URL           string          `json:"url"`
+	TLS           bool            `json:"tls"`                // 连接是否经过TLS (ldaps或StartTLS)
+	UserDN        string          `json:"user_dn,omitempty"`  // 找到的用户条目
+	Username      string          `json:"username,omitempty"` // 登录后使用的用户名
+	Groups        []string        `json:"groups,omitempty"`   // 用户所属组
+	Role          models.UserRole `json:"role,omitempty"`     // 按所属组映射的角色，为空表示不允许登录
+	Authenticated bool            `json:"authenticated"`      // 提供密码时是否通过绑定校验
+}
+
+// NewLDAPAuthenticator 创建LDAP认证后端
+func NewLDAPAuthenticator(cfg config.LDAPConfig) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		db:     database.DB,
+		config: cfg,
+	}
+}
+
+// Name 后端名称
+func (a *LDAPAuthenticator) Name() string {
+	return models.AuthSourceLDAP
+}
+
+// Validate 检查角色映射和CA证书配置
+func (a *LDAPAuthenticator) Validate() error {
+	if err := validateRoleMapping("auth.ldap", a.config.RoleMapping, a.config.DefaultRole); err != nil {
+		return err
+	}
+	_, err := a.tlsConfig()
+	return err
+}
+
+// Authenticate 在目录中查找用户并校验密码，按所属组映射角色后同步到本地用户
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	// 空密码的简单绑定会被服务器当作匿名绑定而成功
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, _, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.bindUser(conn, entry.DN, password); err != nil {
+		return nil, err
+	}
+
+	role, ok := mapRole(a.config.RoleMapping, a.config.DefaultRole, groups)
+	if !ok {
+		return nil, ErrAccessDenied
+	}
+	user, _, err := syncExternalUser(a.db, models.AuthSourceLDAP, entry.DN, a.username(entry, username), role, a.config.AutoProvision)
+	return user, err
+}
+
+// TestConnection 测试连接和服务账号绑定。提供用户名时查找用户及其所属组，同时提供密码时校验密码，
+// 不会创建或修改本地用户
+func (a *LDAPAuthenticator) TestConnection(username, password string) (*LDAPTestResult, error) {
+	conn, secure, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &LDAPTestResult{URL: a.config.URL, TLS: secure}
+	if username == "" {
+		// 确认服务账号可以读取查找起点
+		if _, err := conn.Search(ldap.NewSearchRequest(a.config.BaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+			1, 0, false, "(objectClass=*)", []string{"dn"}, nil)); err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %w", a.config.BaseDN, err)
+		}
+		return result, nil
+	}
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	result.UserDN = entry.DN
+	result.Username = a.username(entry, username)
+	if result.Groups, err = a.groups(conn, entry); err != nil {
+		return nil, err
+	}
+	if role, ok := mapRole(a.config.RoleMapping, a.config.DefaultRole, result.Groups); ok {
+		result.Role = role
+	}
+
+	if password != "" {
+		if err := a.bindUser(conn, entry.DN, password); err != nil {
+			return nil, err
+		}
+		result.Authenticated = true
+	}
+	return result, nil
+}
+
+// connect 连接目录服务器，按配置升级TLS并以服务账号绑定，返回连接是否经过TLS
+func (a *LDAPAuthenticator) connect() (*ldap.Conn, bool, error) {
+	tlsConfig, err := a.tlsConfig()
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := ldap.DialURL(a.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, false, fmt.Errorf("连接LDAP服务器失败: %w", err)
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	secure := strings.HasPrefix(strings.ToLower(a.config.URL), "ldaps://")
+	if a.config.StartTLS && !secure {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, false, fmt.Errorf("LDAP StartTLS失败: %w", err)
+		}
+		secure = true
+	}
+
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			conn.Close()
+			return nil, false, fmt.Errorf("LDAP服务账号绑定失败: %w", err)
+		}
+	}
+	return conn, secure, nil
+}
+
+// tlsConfig 生成TLS配置，指定CA证书时只信任该CA
+func (a *LDAPAuthenticator) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: a.config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if u, err := url.Parse(a.config.URL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	if a.config.CACertFile != "" {
+		pem, err := os.ReadFile(a.config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取LDAP CA证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("LDAP CA证书 %s 格式错误", a.config.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// findUser 按用户过滤器查找唯一的用户条目，找不到时返回ErrInvalidCredentials
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(a.config.UserFilter, "%s", ldap.EscapeFilter(username))
+	attributes := []string{"dn", a.config.UsernameAttribute}
+	if a.config.GroupAttribute != "" {
+		attributes = append(attributes, a.config.GroupAttribute)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(a.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, filter, attributes, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("查找LDAP用户失败: %w", err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("用户名 %s 匹配到多个LDAP条目，请检查 user_filter", username)
+	}
+	return result.Entries[0], nil
+}
+
+// groups 获取用户所属组：用户条目中的组属性，以及在组查找起点下按成员关系查找到的组。
+// 每个组同时返回DN和CN，角色映射可以使用任意一种
+func (a *LDAPAuthenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	var dns []string
+	if a.config.GroupAttribute != "" {
+		dns = append(dns, entry.GetAttributeValues(a.config.GroupAttribute)...)
+	}
+
+	if a.config.GroupBaseDN != "" && a.config.GroupFilter != "" {
+		filter := strings.ReplaceAll(a.config.GroupFilter, "%s", ldap.EscapeFilter(entry.DN))
+		result, err := conn.Search(ldap.NewSearchRequest(a.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, 0, false, filter, []string{"dn"}, nil))
+		if err != nil {
+			return nil, fmt.Errorf("查找LDAP用户组失败: %w", err)
+		}
+		for _, group := range result.Entries {
+			dns = append(dns, group.DN)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var groups []string
+	add := func(name string) {
+		if key := strings.ToLower(name); name != "" && !seen[key] {
+			seen[key] = true
+			groups = append(groups, name)
+		}
+	}
+	for _, dn := range dns {
+		add(dn)
+		if parsed, err := ldap.ParseDN(dn); err == nil && len(parsed.RDNs) > 0 {
+			for _, attribute := range parsed.RDNs[0].Attributes {
+				if strings.EqualFold(attribute.Type, "cn") {
+					add(attribute.Value)
+				}
+			}
+		}
+	}
+	return groups, nil
+}
+
+// bindUser 以用户DN和密码绑定，密码错误时返回ErrInvalidCredentials
+func (a *LDAPAuthenticator) bindUser(conn *ldap.Conn, dn, password string) error {
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("LDAP用户绑定失败: %w", err)
+	}
+	return nil
+}
+
+// username 取用户名属性作为本地用户名，属性为空时使用登录时输入的用户名
+func (a *LDAPAuthenticator) username(entry *ldap.Entry, username string) string {
+	if value := strings.TrimSpace(entry.GetAttributeValue(a.config.UsernameAttribute)); value != "" {
+		return value
+	}
+	return username
+}
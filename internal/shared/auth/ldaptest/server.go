@@ -0,0 +1,274 @@
+// Package ldaptest 提供用于测试的内存LDAP目录服务器。
+// 只支持简单绑定、解绑，以及由相等、存在、与、或、非组成的过滤器查找，不支持StartTLS。
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP协议操作的应用标签
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opExtendedResponse = 24
+)
+
+// 结果码
+const (
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultNoSuchObject       = 32
+	resultInvalidCredentials = 49
+)
+
+// Entry 目录条目，Password不为空时可以用DN和该密码绑定
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server 内存LDAP目录服务器
+type Server struct {
+	listener net.Listener
+	entries  []Entry
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// NewServer 在本地随机端口启动目录服务器
+func NewServer(entries ...Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		listener: listener,
+		entries:  entries,
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.serve()
+	return s
+}
+
+// URL 服务器地址，如 ldap://127.0.0.1:38389
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Close 停止服务器并断开所有连接
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// serve 接受连接，每个连接一个goroutine
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle 按顺序处理一个连接上的请求
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch request.Tag {
+		case opBindRequest:
+			responses = append(responses, result(messageID, opBindResponse, s.bind(request)))
+		case opUnbindRequest:
+			return
+		case opSearchRequest:
+			responses = s.search(messageID, request)
+		default:
+			responses = append(responses, result(messageID, opExtendedResponse, resultProtocolError))
+		}
+
+		for _, response := range responses {
+			if _, err := conn.Write(response.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind 处理简单绑定，DN和密码都为空时为匿名绑定
+func (s *Server) bind(request *ber.Packet) int64 {
+	if len(request.Children) < 3 {
+		return resultProtocolError
+	}
+	dn, _ := request.Children[1].Value.(string)
+	password := request.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return resultSuccess
+	}
+
+	entry := s.find(dn)
+	if entry == nil || entry.Password == "" || entry.Password != password {
+		return resultInvalidCredentials
+	}
+	return resultSuccess
+}
+
+// search 处理查找，返回匹配的条目和结束响应。条目返回全部属性
+func (s *Server) search(messageID int64, request *ber.Packet) []*ber.Packet {
+	if len(request.Children) < 7 {
+		return []*ber.Packet{result(messageID, opSearchDone, resultProtocolError)}
+	}
+	base, _ := request.Children[0].Value.(string)
+	scope, _ := request.Children[1].Value.(int64)
+	filter := request.Children[6]
+
+	if scope == 0 && s.find(base) == nil {
+		return []*ber.Packet{result(messageID, opSearchDone, resultNoSuchObject)}
+	}
+
+	var responses []*ber.Packet
+	for i := range s.entries {
+		entry := &s.entries[i]
+		if !inScope(entry.DN, base, scope) || !matches(entry, filter) {
+			continue
+		}
+		responses = append(responses, searchEntry(messageID, entry))
+	}
+	return append(responses, result(messageID, opSearchDone, resultSuccess))
+}
+
+// find 按DN查找条目，DN不区分大小写
+func (s *Server) find(dn string) *Entry {
+	for i := range s.entries {
+		if strings.EqualFold(s.entries[i].DN, dn) {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+// inScope 条目是否在查找范围内，scope为0时只匹配起点本身，其他范围按整个子树处理
+func inScope(dn, base string, scope int64) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	if scope == 0 || base == "" {
+		return dn == base || base == ""
+	}
+	return dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+// matches 条目是否匹配过滤器，属性名和值都不区分大小写
+func matches(entry *Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case 0: // and
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case 1: // or
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case 2: // not
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case 3: // equalityMatch
+		if len(filter.Children) != 2 {
+			return false
+		}
+		name, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for _, v := range attribute(entry, name) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case 7: // present
+		return len(attribute(entry, filter.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+// attribute 获取条目的属性值，属性名不区分大小写
+func attribute(entry *Entry, name string) []string {
+	for key, values := range entry.Attributes {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// result 生成只包含结果码的响应
+func result(messageID int64, op ber.Tag, code int64) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return message(messageID, response)
+}
+
+// searchEntry 生成查找结果条目
+func searchEntry(messageID int64, entry *Entry) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attr.AppendChild(set)
+		attributes.AppendChild(attr)
+	}
+	response.AppendChild(attributes)
+	return message(messageID, response)
+}
+
+// message 把协议操作包装为LDAP消息
+func message(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	packet.AppendChild(op)
+	return packet
+}
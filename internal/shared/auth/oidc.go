@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// OIDCStateExpiry 发起单点登录到身份提供方回调之间允许的最长时间
+const OIDCStateExpiry = 10 * time.Minute
+
+var (
+	// ErrOIDCDisabled 未启用单点登录
+	ErrOIDCDisabled = errors.New("未启用单点登录")
+	// ErrOIDCState 登录状态cookie缺失、被篡改、已过期或与回调的state不一致
+	ErrOIDCState = errors.New("登录状态无效或已过期，请重新登录")
+	// ErrAccessDenied 用户组没有映射到任何角色，或未开启自动创建且用户不存在
+	ErrAccessDenied = errors.New("没有访问权限，请联系管理员")
+	// ErrAccountDisabled 账户已被停用
+	ErrAccountDisabled = errors.New("账户已被禁用")
+)
+
+// OIDCService OIDC单点登录服务：发起授权码+PKCE流程，校验回调后按身份提供方的用户组
+// 映射角色，并在首次登录时自动创建用户
+type OIDCService struct {
+	db     *gorm.DB
+	config config.OIDCConfig
+	client *oidc.Client
+	// stateKey 签名登录状态cookie的密钥，每次启动随机生成，重启会使进行中的登录失效
+	stateKey []byte
+}
+
+// oidcStateClaims 登录状态cookie中的内容，code_verifier只保存在浏览器的HttpOnly cookie中
+type oidcStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// NewOIDCService 创建OIDC单点登录服务
+func NewOIDCService(cfg config.OIDCConfig) *OIDCService {
+	stateKey := make([]byte, 32)
+	if _, err := rand.Read(stateKey); err != nil {
+		panic(fmt.Sprintf("生成登录状态密钥失败: %v", err))
+	}
+
+	return &OIDCService{
+		db:     database.DB,
+		config: cfg,
+		client: oidc.NewClient(oidc.Config{
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		}),
+		stateKey: stateKey,
+	}
+}
+
+// Enabled 是否启用单点登录
+func (s *OIDCService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// Name 登录页显示的身份提供方名称
+func (s *OIDCService) Name() string {
+	return s.config.Name
+}
+
+// SecureCookie 回调地址为HTTPS时登录状态cookie只通过HTTPS发送
+func (s *OIDCService) SecureCookie() bool {
+	return strings.HasPrefix(s.config.RedirectURL, "https://")
+}
+
+// Validate 检查角色映射中的角色是否有效
+func (s *OIDCService) Validate() error {
+	return validateRoleMapping("auth.oidc", s.config.RoleMapping, s.config.DefaultRole)
+}
+
+// Begin 发起单点登录，返回身份提供方的授权地址和需要写入cookie的登录状态
+func (s *OIDCService) Begin(ctx context.Context) (string, string, error) {
+	if !s.Enabled() {
+		return "", "", ErrOIDCDisabled
+	}
+
+	state, err := oidc.GenerateState()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.GenerateState()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := s.client.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &oidcStateClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDCStateExpiry)),
+		},
+	}).SignedString(s.stateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("生成登录状态失败: %w", err)
+	}
+
+	return authURL, cookie, nil
+}
+
+// Complete 处理身份提供方的回调：校验state，用授权码换取并校验ID令牌，返回登录的用户。
+// created表示用户是本次登录时自动创建的
+func (s *OIDCService) Complete(ctx context.Context, cookie, state, code string) (user *models.User, created bool, err error) {
+	if !s.Enabled() {
+		return nil, false, ErrOIDCDisabled
+	}
+
+	var claims oidcStateClaims
+	_, err = jwt.ParseWithClaims(cookie, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.stateKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return nil, false, ErrOIDCState
+	}
+
+	token, err := s.client.Exchange(ctx, code, claims.Verifier)
+	if err != nil {
+		return nil, false, err
+	}
+	idClaims, err := s.client.VerifyIDToken(ctx, token.IDToken, claims.Nonce)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return s.signIn(idClaims)
+}
+
+// MapRole 根据用户组确定角色，匹配多个用户组时取权限最高的角色，没有匹配时使用默认角色
+func (s *OIDCService) MapRole(groups []string) (models.UserRole, bool) {
+	return mapRole(s.config.RoleMapping, s.config.DefaultRole, groups)
+}
+
+// signIn 按sub查找已关联的用户，用户不存在且开启自动创建时创建新用户
+func (s *OIDCService) signIn(claims oidc.Claims) (*models.User, bool, error) {
+	role, ok := s.MapRole(claims.Strings(s.config.GroupsClaim))
+	if !ok {
+		return nil, false, ErrAccessDenied
+	}
+	return syncExternalUser(s.db, models.AuthSourceOIDC, claims.String("sub"), s.username(claims), role, s.config.AutoProvision)
+}
+
+// username 从声明中取用户名，配置的声明为空时依次尝试email和sub
+func (s *OIDCService) username(claims oidc.Claims) string {
+	for _, name := range []string{s.config.UsernameClaim, "email", "sub"} {
+		if value := strings.TrimSpace(claims.String(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// PasswordLoginEnabled 是否允许所有本地账户使用密码登录
+func PasswordLoginEnabled() bool {
+	cfg := config.GetGlobalServerConfig()
+	return cfg == nil || !cfg.Auth.DisablePasswordLogin
+}
+
+// passwordLoginAllowed 关闭密码登录后只有应急账户可以使用密码登录
+func passwordLoginAllowed(username string) bool {
+	if PasswordLoginEnabled() {
+		return true
+	}
+	return username == config.GetGlobalServerConfig().Auth.BreakGlassUser
+}
@@ -1,13 +1,30 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"sync"
+	"log"
 	"time"
 
+	"eitec-vpn/internal/server/database"
 	"eitec-vpn/internal/server/models"
 	"eitec-vpn/internal/shared/utils"
+
+	"gorm.io/gorm"
+)
+
+// touchInterval 会话活跃时间的最小更新间隔，避免每个请求都写数据库
+const touchInterval = time.Minute
+
+var (
+	// ErrSessionInvalid 会话不存在、已过期或已撤销
+	ErrSessionInvalid = errors.New("会话已失效，请重新登录")
+	// ErrSessionNotFound 会话不存在或不属于当前用户
+	ErrSessionNotFound = errors.New("会话不存在")
+	// ErrRefreshTokenReused 刷新令牌被重复使用，会话已被撤销
+	ErrRefreshTokenReused = errors.New("刷新令牌已被使用，会话已撤销")
 )
 
 // SessionInfo 会话信息
@@ -20,317 +37,314 @@ type SessionInfo struct {
 	UserAgent string    `json:"user_agent"`
 }
 
-// Session 会话结构
-type Session struct {
-	ID        string                 `json:"id"`
-	UserID    uint                   `json:"user_id"`
-	Username  string                 `json:"username"`
-	LoginTime time.Time              `json:"login_time"`
-	LastSeen  time.Time              `json:"last_seen"`
-	IPAddress string                 `json:"ip_address"`
-	UserAgent string                 `json:"user_agent"`
-	IsActive  bool                   `json:"is_active"`
-	Data      map[string]interface{} `json:"data"`
-}
-
-// SessionManager 会话管理器
+// SessionManager 会话管理器，会话和已签发的刷新令牌保存在数据库中，服务重启后仍然有效
 type SessionManager struct {
-	sessions     map[string]*Session
-	userSessions map[uint][]string // 用户ID -> 会话ID列表
-	mutex        sync.RWMutex
-	timeout      time.Duration
+	db      *gorm.DB
+	timeout time.Duration // 最长空闲时间
 }
 
 // NewSessionManager 创建会话管理器
 func NewSessionManager(timeout time.Duration) *SessionManager {
-	sm := &SessionManager{
-		sessions:     make(map[string]*Session),
-		userSessions: make(map[uint][]string),
-		timeout:      timeout,
+	return &SessionManager{
+		db:      database.DB,
+		timeout: timeout,
 	}
-
-	// 启动清理过期会话的协程
-	go sm.cleanupExpiredSessions()
-
-	return sm
 }
 
-// CreateSession 创建新会话
-func (sm *SessionManager) CreateSession(user *models.User, ipAddress, userAgent string) (*Session, error) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	sessionID, err := generateSessionID()
+// CreateSession 创建新会话，返回的会话Token字段为cookie明文
+func (sm *SessionManager) CreateSession(user *models.User, ipAddress, userAgent string) (*models.UserSession, error) {
+	token, err := generateSessionID()
 	if err != nil {
 		return nil, fmt.Errorf("生成会话ID失败: %w", err)
 	}
 
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
 	now := time.Now()
-	session := &Session{
-		ID:        sessionID,
+	session := &models.UserSession{
 		UserID:    user.ID,
 		Username:  user.Username,
-		LoginTime: now,
-		LastSeen:  now,
+		TokenHash: hashToken(token),
 		IPAddress: ipAddress,
 		UserAgent: userAgent,
-		IsActive:  true,
-		Data:      make(map[string]interface{}),
+		LastSeen:  now,
+		ExpiresAt: now.Add(sm.timeout),
 	}
-
-	// 存储会话
-	sm.sessions[sessionID] = session
-
-	// 更新用户会话列表
-	if sessions, exists := sm.userSessions[user.ID]; exists {
-		sm.userSessions[user.ID] = append(sessions, sessionID)
-	} else {
-		sm.userSessions[user.ID] = []string{sessionID}
+	if err := sm.db.Create(session).Error; err != nil {
+		return nil, fmt.Errorf("创建会话失败: %w", err)
 	}
 
+	session.Token = token
 	return session, nil
 }
 
-// GetSession 获取会话
-func (sm *SessionManager) GetSession(sessionID string) (*Session, error) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
-
-	session, exists := sm.sessions[sessionID]
-	if !exists {
-		return nil, errors.New("会话不存在")
+// GetSession 获取有效会话
+func (sm *SessionManager) GetSession(sessionID uint) (*models.UserSession, error) {
+	if sessionID == 0 {
+		return nil, ErrSessionInvalid
 	}
+	return sm.activeSession(sm.db.Where("id = ?", sessionID))
+}
 
-	if !session.IsActive {
-		return nil, errors.New("会话已失效")
+// GetSessionByToken 根据cookie中的会话令牌获取有效会话
+func (sm *SessionManager) GetSessionByToken(token string) (*models.UserSession, error) {
+	if token == "" {
+		return nil, ErrSessionInvalid
 	}
+	return sm.activeSession(sm.db.Where("token_hash = ?", hashToken(token)))
+}
 
-	// 检查是否过期
-	if time.Since(session.LastSeen) > sm.timeout {
-		return nil, errors.New("会话已过期")
+// ValidateSession 校验令牌所属会话仍然有效并更新活跃时间
+func (sm *SessionManager) ValidateSession(sessionID uint) (*models.UserSession, error) {
+	session, err := sm.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(session.LastSeen) >= touchInterval {
+		if err := sm.UpdateSession(session.ID); err != nil {
+			log.Printf("更新会话活跃时间失败: %v", err)
+		}
 	}
-
 	return session, nil
 }
 
-// UpdateSession 更新会话
-func (sm *SessionManager) UpdateSession(sessionID string) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	session, exists := sm.sessions[sessionID]
-	if !exists {
-		return errors.New("会话不存在")
+// AuthenticateAccessToken 校验访问令牌的会话属于令牌中的用户，并从数据库读取用户，
+// 用户停用或角色变化后立即生效，不等待访问令牌过期
+func (sm *SessionManager) AuthenticateAccessToken(claims *JWTClaims) (*models.UserSession, *models.User, error) {
+	session, err := sm.ValidateSession(claims.SessionID)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	session.LastSeen = time.Now()
-	return nil
-}
-
-// SetSessionData 设置会话数据
-func (sm *SessionManager) SetSessionData(sessionID, key string, value interface{}) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	session, exists := sm.sessions[sessionID]
-	if !exists {
-		return errors.New("会话不存在")
+	if session.UserID != claims.UserID {
+		return nil, nil, ErrSessionInvalid
 	}
 
-	if session.Data == nil {
-		session.Data = make(map[string]interface{})
+	var user models.User
+	if err := sm.db.Where("id = ? AND is_active = ?", session.UserID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSessionInvalid
+		}
+		return nil, nil, fmt.Errorf("查询用户失败: %w", err)
 	}
-	session.Data[key] = value
-
-	return nil
+	return session, &user, nil
 }
 
-// GetSessionData 获取会话数据
-func (sm *SessionManager) GetSessionData(sessionID, key string) (interface{}, error) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
-
-	session, exists := sm.sessions[sessionID]
-	if !exists {
-		return nil, errors.New("会话不存在")
+// UpdateSession 更新会话活跃时间
+func (sm *SessionManager) UpdateSession(sessionID uint) error {
+	if err := sm.db.Model(&models.UserSession{}).Where("id = ?", sessionID).Update("last_seen", time.Now()).Error; err != nil {
+		return fmt.Errorf("更新会话失败: %w", err)
 	}
+	return nil
+}
 
-	if session.Data == nil {
-		return nil, errors.New("数据不存在")
-	}
+// IssueRefreshToken 登记为会话签发的刷新令牌，会话有效期随之延长到刷新令牌过期
+func (sm *SessionManager) IssueRefreshToken(sessionID uint, pair *TokenPair) error {
+	return sm.db.Transaction(func(tx *gorm.DB) error {
+		var session models.UserSession
+		if err := tx.First(&session, sessionID).Error; err != nil {
+			return fmt.Errorf("查询会话失败: %w", err)
+		}
 
-	value, exists := session.Data[key]
-	if !exists {
-		return nil, errors.New("数据不存在")
-	}
+		record := &models.RefreshToken{
+			JTI:       pair.RefreshTokenID,
+			SessionID: session.ID,
+			UserID:    session.UserID,
+			ExpiresAt: pair.RefreshExpiresAt,
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return fmt.Errorf("登记刷新令牌失败: %w", err)
+		}
 
-	return value, nil
+		if pair.RefreshExpiresAt.After(session.ExpiresAt) {
+			if err := tx.Model(&session).Update("expires_at", pair.RefreshExpiresAt).Error; err != nil {
+				return fmt.Errorf("更新会话失败: %w", err)
+			}
+		}
+		return nil
+	})
 }
 
-// DestroySession 销毁会话
-func (sm *SessionManager) DestroySession(sessionID string) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+// UseRefreshToken 消费刷新令牌并返回所属会话，调用方随后为该会话签发新的令牌对。
+// 已使用过的令牌再次出现时撤销整个会话并返回ErrRefreshTokenReused
+func (sm *SessionManager) UseRefreshToken(claims *JWTClaims) (*models.UserSession, error) {
+	if claims.ID == "" || claims.SessionID == 0 {
+		return nil, ErrSessionInvalid
+	}
 
-	session, exists := sm.sessions[sessionID]
-	if !exists {
-		return errors.New("会话不存在")
+	// 条件更新，并发请求中同一刷新令牌只有一个能成功
+	result := sm.db.Model(&models.RefreshToken{}).
+		Where("jti = ? AND session_id = ? AND used_at IS NULL", claims.ID, claims.SessionID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("更新刷新令牌失败: %w", result.Error)
 	}
 
-	// 从用户会话列表中移除
-	if userSessions, exists := sm.userSessions[session.UserID]; exists {
-		for i, id := range userSessions {
-			if id == sessionID {
-				sm.userSessions[session.UserID] = append(userSessions[:i], userSessions[i+1:]...)
-				break
-			}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := sm.db.Model(&models.RefreshToken{}).Where("jti = ? AND session_id = ?", claims.ID, claims.SessionID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("查询刷新令牌失败: %w", err)
+		}
+		if count == 0 {
+			return nil, ErrSessionInvalid
 		}
 
-		// 如果用户没有其他会话，删除用户条目
-		if len(sm.userSessions[session.UserID]) == 0 {
-			delete(sm.userSessions, session.UserID)
+		log.Printf("⚠️ 用户 %s 的刷新令牌被重复使用，撤销会话 %d", claims.Username, claims.SessionID)
+		if err := sm.revoke(sm.db.Where("id = ?", claims.SessionID), models.SessionRevokedReuse); err != nil {
+			return nil, err
 		}
+		return nil, ErrRefreshTokenReused
 	}
 
-	// 删除会话
-	delete(sm.sessions, sessionID)
-	return nil
-}
-
-// DestroyUserSessions 销毁用户的所有会话
-func (sm *SessionManager) DestroyUserSessions(userID uint) error {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	sessionIDs, exists := sm.userSessions[userID]
-	if !exists {
-		return nil
+	session, err := sm.GetSession(claims.SessionID)
+	if err != nil {
+		return nil, err
 	}
-
-	// 销毁所有会话
-	for _, sessionID := range sessionIDs {
-		delete(sm.sessions, sessionID)
+	if err := sm.UpdateSession(session.ID); err != nil {
+		log.Printf("更新会话活跃时间失败: %v", err)
 	}
-
-	// 删除用户会话列表
-	delete(sm.userSessions, userID)
-	return nil
+	return session, nil
 }
 
-// GetUserSessions 获取用户的所有会话
-func (sm *SessionManager) GetUserSessions(userID uint) ([]*Session, error) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+// DestroySession 注销会话，会话的访问令牌和刷新令牌随之失效
+func (sm *SessionManager) DestroySession(sessionID uint) error {
+	return sm.revoke(sm.db.Where("id = ?", sessionID), models.SessionRevokedLogout)
+}
 
-	sessionIDs, exists := sm.userSessions[userID]
-	if !exists {
-		return []*Session{}, nil
+// RevokeUserSession 撤销用户自己的某个会话
+func (sm *SessionManager) RevokeUserSession(userID, sessionID uint) error {
+	session, err := sm.GetSession(sessionID)
+	if err != nil || session.UserID != userID {
+		return ErrSessionNotFound
 	}
+	return sm.revoke(sm.db.Where("id = ?", sessionID), models.SessionRevokedByUser)
+}
 
-	var sessions []*Session
-	for _, sessionID := range sessionIDs {
-		if session, exists := sm.sessions[sessionID]; exists && session.IsActive {
-			sessions = append(sessions, session)
-		}
+// DestroyOtherSessions 撤销用户除keepID以外的所有会话，返回撤销数量
+func (sm *SessionManager) DestroyOtherSessions(userID, keepID uint) (int64, error) {
+	var count int64
+	query := sm.db.Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID)
+	if err := query.Model(&models.UserSession{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("查询会话失败: %w", err)
 	}
-
-	return sessions, nil
+	if err := sm.revoke(sm.db.Where("user_id = ? AND id <> ?", userID, keepID), models.SessionRevokedByUser); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
-// GetAllSessions 获取所有活跃会话
-func (sm *SessionManager) GetAllSessions() []*Session {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+// DestroyUserSessions 撤销用户的所有会话，已签发的访问令牌和刷新令牌立即失效
+func (sm *SessionManager) DestroyUserSessions(userID uint) error {
+	return sm.revoke(sm.db.Where("user_id = ?", userID), models.SessionRevokedByAdmin)
+}
 
-	var sessions []*Session
-	for _, session := range sm.sessions {
-		if session.IsActive && time.Since(session.LastSeen) <= sm.timeout {
-			sessions = append(sessions, session)
-		}
-	}
+// GetUserSessions 获取用户的所有有效会话，按最近活跃时间倒序
+func (sm *SessionManager) GetUserSessions(userID uint) ([]models.UserSession, error) {
+	return sm.activeSessions(sm.db.Where("user_id = ?", userID))
+}
 
-	return sessions
+// GetAllSessions 获取所有有效会话
+func (sm *SessionManager) GetAllSessions() ([]models.UserSession, error) {
+	return sm.activeSessions(sm.db)
 }
 
-// IsUserOnline 检查用户是否在线
+// IsUserOnline 检查用户是否有有效会话
 func (sm *SessionManager) IsUserOnline(userID uint) bool {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+	sessions, err := sm.GetUserSessions(userID)
+	return err == nil && len(sessions) > 0
+}
 
-	sessionIDs, exists := sm.userSessions[userID]
-	if !exists {
-		return false
-	}
+// GetSessionCount 获取会话统计
+func (sm *SessionManager) GetSessionCount() (total, active int) {
+	var count int64
+	sm.db.Model(&models.UserSession{}).Count(&count)
+	sessions, _ := sm.GetAllSessions()
+	return int(count), len(sessions)
+}
+
+// CleanupExpiredSessions 删除已过期或已撤销的会话及其刷新令牌，返回删除的会话数量
+func (sm *SessionManager) CleanupExpiredSessions() (int64, error) {
+	now := time.Now()
+	var removed int64
+
+	err := sm.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("revoked_at IS NOT NULL OR expires_at <= ?", now)
+		if sm.timeout > 0 {
+			query = query.Or("last_seen < ?", now.Add(-sm.timeout))
+		}
 
-	// 检查是否有活跃会话
-	for _, sessionID := range sessionIDs {
-		if session, exists := sm.sessions[sessionID]; exists {
-			if session.IsActive && time.Since(session.LastSeen) <= sm.timeout {
-				return true
+		var ids []uint
+		if err := query.Model(&models.UserSession{}).Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("查询过期会话失败: %w", err)
+		}
+		if len(ids) > 0 {
+			if err := tx.Where("session_id IN ?", ids).Delete(&models.RefreshToken{}).Error; err != nil {
+				return fmt.Errorf("删除刷新令牌失败: %w", err)
+			}
+			if err := tx.Where("id IN ?", ids).Delete(&models.UserSession{}).Error; err != nil {
+				return fmt.Errorf("删除过期会话失败: %w", err)
 			}
 		}
-	}
+		removed = int64(len(ids))
 
-	return false
+		// 会话仍然有效时，已过期的刷新令牌也不再需要
+		if err := tx.Where("expires_at <= ?", now).Delete(&models.RefreshToken{}).Error; err != nil {
+			return fmt.Errorf("删除过期刷新令牌失败: %w", err)
+		}
+		return nil
+	})
+	return removed, err
 }
 
-// GetSessionCount 获取会话统计
-func (sm *SessionManager) GetSessionCount() (total, active int) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
-
-	total = len(sm.sessions)
-	for _, session := range sm.sessions {
-		if session.IsActive && time.Since(session.LastSeen) <= sm.timeout {
-			active++
+// activeSession 按条件查询单个有效会话
+func (sm *SessionManager) activeSession(query *gorm.DB) (*models.UserSession, error) {
+	var session models.UserSession
+	if err := query.First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
 		}
+		return nil, fmt.Errorf("查询会话失败: %w", err)
 	}
-
-	return total, active
+	if !session.IsActive(time.Now(), sm.timeout) {
+		return nil, ErrSessionInvalid
+	}
+	return &session, nil
 }
 
-// cleanupExpiredSessions 清理过期会话
-func (sm *SessionManager) cleanupExpiredSessions() {
-	ticker := time.NewTicker(5 * time.Minute) // 每5分钟清理一次
-	defer ticker.Stop()
-
-	for range ticker.C {
-		sm.mutex.Lock()
-
-		var expiredSessions []string
-		now := time.Now()
-
-		// 找出过期的会话
-		for sessionID, session := range sm.sessions {
-			if !session.IsActive || now.Sub(session.LastSeen) > sm.timeout {
-				expiredSessions = append(expiredSessions, sessionID)
-			}
-		}
+// activeSessions 按条件查询有效会话
+func (sm *SessionManager) activeSessions(query *gorm.DB) ([]models.UserSession, error) {
+	var sessions []models.UserSession
+	if err := query.Where("revoked_at IS NULL AND expires_at > ?", time.Now()).Order("last_seen DESC").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("查询会话失败: %w", err)
+	}
 
-		// 删除过期会话
-		for _, sessionID := range expiredSessions {
-			if session, exists := sm.sessions[sessionID]; exists {
-				// 从用户会话列表中移除
-				if userSessions, exists := sm.userSessions[session.UserID]; exists {
-					for i, id := range userSessions {
-						if id == sessionID {
-							sm.userSessions[session.UserID] = append(userSessions[:i], userSessions[i+1:]...)
-							break
-						}
-					}
-
-					// 如果用户没有其他会话，删除用户条目
-					if len(sm.userSessions[session.UserID]) == 0 {
-						delete(sm.userSessions, session.UserID)
-					}
-				}
-
-				delete(sm.sessions, sessionID)
-			}
+	now := time.Now()
+	active := sessions[:0]
+	for _, session := range sessions {
+		if session.IsActive(now, sm.timeout) {
+			active = append(active, session)
 		}
+	}
+	return active, nil
+}
 
-		sm.mutex.Unlock()
+// revoke 撤销符合条件的会话
+func (sm *SessionManager) revoke(query *gorm.DB, reason string) error {
+	err := query.Model(&models.UserSession{}).Where("revoked_at IS NULL").Updates(map[string]interface{}{
+		"revoked_at":    time.Now(),
+		"revoke_reason": reason,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("撤销会话失败: %w", err)
 	}
+	return nil
+}
+
+// hashToken 计算会话令牌和个人访问令牌的哈希，数据库中不保存明文
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // generateSessionID 生成会话ID
@@ -3,49 +3,155 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"log"
+	"strconv"
 	"time"
 
 	"eitec-vpn/internal/server/database"
 	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/config"
+	"eitec-vpn/internal/shared/lockout"
 	"eitec-vpn/internal/shared/utils"
 
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	// ErrPasswordLoginDisabled 已关闭密码登录且不是应急账户
+	ErrPasswordLoginDisabled = errors.New("已关闭密码登录，请使用单点登录")
+)
+
 // UserService 用户服务
 type UserService struct {
-	db *gorm.DB
+	db             *gorm.DB
+	lockout        *lockout.Tracker
+	authenticators []Authenticator
 }
 
-// NewUserService 创建用户服务
+// NewUserService 创建用户服务，密码登录依次尝试本地账户和配置启用的LDAP
 func NewUserService() *UserService {
+	authenticators := []Authenticator{NewLocalAuthenticator()}
+	if cfg := config.GetGlobalServerConfig(); cfg != nil && cfg.Auth.LDAP.Enabled {
+		authenticators = append(authenticators, NewLDAPAuthenticator(cfg.Auth.LDAP))
+	}
+
 	return &UserService{
-		db: database.DB,
+		db:             database.DB,
+		lockout:        lockout.NewTracker(database.DB, loginLockoutPolicy),
+		authenticators: authenticators,
 	}
 }
 
-// Login 用户登录
-func (us *UserService) Login(username, password string) (*models.User, error) {
-	var user models.User
-	if err := us.db.Where("username = ? AND is_active = ?", username, true).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("用户名或密码错误")
+// SetAuthenticators 替换密码登录的认证后端
+func (us *UserService) SetAuthenticators(authenticators ...Authenticator) {
+	us.authenticators = authenticators
+}
+
+// LDAP 获取LDAP认证后端，未启用LDAP时返回nil
+func (us *UserService) LDAP() *LDAPAuthenticator {
+	for _, authenticator := range us.authenticators {
+		if ldap, ok := authenticator.(*LDAPAuthenticator); ok {
+			return ldap
 		}
-		return nil, fmt.Errorf("查询用户失败: %w", err)
 	}
-	fmt.Println("user", user)
+	return nil
+}
+
+// loginLockoutPolicy 从系统配置读取登录锁定策略，缺省时为5次失败锁定15分钟
+func loginLockoutPolicy() lockout.Policy {
+	value, _ := database.GetSystemConfig("security.max_login_attempts")
+	maxAttempts, _ := strconv.Atoi(value)
+	if maxAttempts == 0 {
+		maxAttempts = 5
+	}
+
+	value, _ = database.GetSystemConfig("security.lockout_duration")
+	seconds, _ := strconv.Atoi(value)
+	if seconds == 0 {
+		seconds = 900
+	}
+
+	return lockout.DefaultPolicy(maxAttempts, time.Duration(seconds)*time.Second)
+}
 
-	// 验证密码
-	if !utils.CheckPassword(password, user.Password) {
-		return nil, errors.New("用户名或密码错误")
+// Login 用户登录。账户或来源IP失败次数过多时返回*lockout.LockedError，
+// 关闭密码登录后除应急账户外返回ErrPasswordLoginDisabled
+func (us *UserService) Login(username, password, clientIP string) (*models.User, error) {
+	if !passwordLoginAllowed(username) {
+		return nil, ErrPasswordLoginDisabled
+	}
+	if err := us.lockout.Attempt(username, clientIP); err != nil {
+		return nil, err
 	}
 
-	// 更新最后登录时间
+	user, err := us.authenticate(username, password)
+	if err != nil {
+		// 拒绝登录的原因（账户停用、没有权限等）同样计入失败次数
+		if lockErr := us.loginFailed(username, clientIP); !errors.Is(lockErr, ErrInvalidCredentials) {
+			return nil, lockErr
+		}
+		return nil, err
+	}
+
+	if err := us.lockout.Succeed(username, clientIP); err != nil {
+		log.Printf("清除登录失败记录失败: %v", err)
+	}
+
+	// 只更新最后登录时间，避免用旧数据覆盖并发修改的角色、状态或密码
 	now := time.Now()
 	user.LastLogin = &now
-	us.db.Save(&user)
+	if err := us.db.Model(user).Update("last_login", now).Error; err != nil {
+		log.Printf("更新最后登录时间失败: %v", err)
+	}
 
-	return &user, nil
+	return user, nil
+}
+
+// authenticate 依次尝试各认证后端，用户不存在或密码错误时尝试下一个后端。
+// 后端故障只记录日志，对用户统一返回ErrInvalidCredentials
+func (us *UserService) authenticate(username, password string) (*models.User, error) {
+	for _, authenticator := range us.authenticators {
+		user, err := authenticator.Authenticate(username, password)
+		switch {
+		case err == nil:
+			return user, nil
+		case errors.Is(err, ErrAccountDisabled), errors.Is(err, ErrAccessDenied), errors.Is(err, ErrUsernameTaken):
+			return nil, err
+		case !errors.Is(err, ErrInvalidCredentials):
+			log.Printf("%s认证失败: %v", authenticator.Name(), err)
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// loginFailed 记录登录失败，本次失败触发锁定时返回锁定错误
+func (us *UserService) loginFailed(username, clientIP string) error {
+	if err := us.lockout.Fail(username, clientIP); err != nil {
+		var locked *lockout.LockedError
+		if errors.As(err, &locked) {
+			log.Printf("登录失败次数过多，锁定%s %s 至 %s", locked.Kind, locked.Key, locked.Until.Format(time.RFC3339))
+			return err
+		}
+		log.Printf("记录登录失败失败: %v", err)
+	}
+	return ErrInvalidCredentials
+}
+
+// GetLockouts 获取当前被锁定的账户和IP
+func (us *UserService) GetLockouts() ([]lockout.Record, error) {
+	return us.lockout.Locked()
+}
+
+// GetLockoutEvents 获取最近的锁定和解锁事件
+func (us *UserService) GetLockoutEvents(limit int) ([]lockout.Event, error) {
+	return us.lockout.Events(limit)
+}
+
+// Unlock 手动解锁账户或IP
+func (us *UserService) Unlock(kind, key, actor string) error {
+	return us.lockout.Unlock(kind, key, actor)
 }
 
 // GetUserByID 根据ID获取用户
@@ -74,8 +180,28 @@ func (us *UserService) GetUserByUsername(username string) (*models.User, error)
 	return &user, nil
 }
 
-// CreateUser 创建用户
-func (us *UserService) CreateUser(username, password string) (*models.User, error) {
+// FindUser 根据ID获取用户，包括已停用的用户
+func (us *UserService) FindUser(id uint) (*models.User, error) {
+	var user models.User
+	if err := us.db.First(&user, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("用户不存在")
+		}
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	return &user, nil
+}
+
+// CreateUser 创建用户，role为空时为只读用户
+func (us *UserService) CreateUser(username, password string, role models.UserRole) (*models.User, error) {
+	if role == "" {
+		role = models.RoleViewer
+	}
+	if !role.Valid() {
+		return nil, fmt.Errorf("无效的角色: %s", role)
+	}
+
 	// 检查用户名是否已存在
 	var existingUser models.User
 	if err := us.db.Where("username = ?", username).First(&existingUser).Error; err == nil {
@@ -92,6 +218,7 @@ func (us *UserService) CreateUser(username, password string) (*models.User, erro
 	user := &models.User{
 		Username: username,
 		Password: hashedPassword,
+		Role:     role,
 		IsActive: true,
 	}
 
@@ -104,6 +231,12 @@ func (us *UserService) CreateUser(username, password string) (*models.User, erro
 
 // UpdateUser 更新用户信息
 func (us *UserService) UpdateUser(id uint, updates map[string]interface{}) error {
+	if role, exists := updates["role"]; exists {
+		if r, ok := role.(models.UserRole); !ok || !r.Valid() {
+			return fmt.Errorf("无效的角色: %v", role)
+		}
+	}
+
 	// 如果要更新密码，需要加密
 	if password, exists := updates["password"]; exists {
 		if passwordStr, ok := password.(string); ok && passwordStr != "" {
@@ -242,7 +375,7 @@ func (us *UserService) InitializeDefaultAdmin(username, password string) error {
 	}
 
 	// 创建默认管理员
-	_, err := us.CreateUser(username, password)
+	_, err := us.CreateUser(username, password, models.RoleAdmin)
 	return err
 }
 
@@ -299,40 +432,38 @@ func (as *AuthServiceImpl) ValidateAccessToken(token string) (*JWTClaims, error)
 	return as.jwtService.ValidateAccessToken(token)
 }
 
-// ValidateAPIKey 实现AuthService接口
-func (as *AuthServiceImpl) ValidateAPIKey(token string) (*JWTClaims, error) {
-	return as.jwtService.ValidateAPIKey(token)
-}
-
 // Login 用户登录
-func (as *AuthServiceImpl) Login(username, password, ipAddress, userAgent string) (*TokenPair, *Session, error) {
+func (as *AuthServiceImpl) Login(username, password, ipAddress, userAgent string) (*TokenPair, *models.UserSession, error) {
 	// 验证用户
-	user, err := as.userService.Login(username, password)
+	user, err := as.userService.Login(username, password, ipAddress)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// 生成JWT令牌对
-	tokenPair, err := as.jwtService.GenerateTokenPair(user)
+	// 创建会话
+	session, err := as.sessionManager.CreateSession(user, ipAddress, userAgent)
 	if err != nil {
-		return nil, nil, fmt.Errorf("生成令牌失败: %w", err)
+		return nil, nil, err
 	}
 
-	// 创建会话
-	session, err := as.sessionManager.CreateSession(user, ipAddress, userAgent)
+	// 生成JWT令牌对并登记刷新令牌
+	tokenPair, err := as.jwtService.GenerateTokenPair(user, session.ID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("创建会话失败: %w", err)
+		return nil, nil, fmt.Errorf("生成令牌失败: %w", err)
+	}
+	if err := as.sessionManager.IssueRefreshToken(session.ID, tokenPair); err != nil {
+		return nil, nil, err
 	}
 
 	return tokenPair, session, nil
 }
 
 // Logout 用户退出
-func (as *AuthServiceImpl) Logout(sessionID string) error {
+func (as *AuthServiceImpl) Logout(sessionID uint) error {
 	return as.sessionManager.DestroySession(sessionID)
 }
 
-// RefreshToken 刷新令牌
+// RefreshToken 刷新令牌，旧刷新令牌作废
 func (as *AuthServiceImpl) RefreshToken(refreshToken string) (*TokenPair, error) {
 	// 验证刷新令牌
 	claims, err := as.jwtService.ValidateRefreshToken(refreshToken)
@@ -340,6 +471,12 @@ func (as *AuthServiceImpl) RefreshToken(refreshToken string) (*TokenPair, error)
 		return nil, err
 	}
 
+	// 消费旧刷新令牌，重复使用时会话被撤销
+	session, err := as.sessionManager.UseRefreshToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	// 获取用户信息
 	user, err := as.userService.GetUserByID(claims.UserID)
 	if err != nil {
@@ -347,5 +484,12 @@ func (as *AuthServiceImpl) RefreshToken(refreshToken string) (*TokenPair, error)
 	}
 
 	// 生成新的令牌对
-	return as.jwtService.RefreshTokenPair(refreshToken, user)
+	tokenPair, err := as.jwtService.RefreshTokenPair(refreshToken, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.sessionManager.IssueRefreshToken(session.ID, tokenPair); err != nil {
+		return nil, err
+	}
+	return tokenPair, nil
 }
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"eitec-vpn/internal/server/database"
+	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/totp"
+	"eitec-vpn/internal/shared/utils"
+)
+
+const (
+	// totpIssuer 验证器应用中显示的发行方
+	totpIssuer = "EITEC VPN"
+	// recoveryCodeCount 每次生成的恢复码数量
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrInvalidTOTPCode 验证码或恢复码错误
+	ErrInvalidTOTPCode = errors.New("验证码错误")
+	// ErrTOTPAlreadyEnabled 已启用两步验证
+	ErrTOTPAlreadyEnabled = errors.New("已启用两步验证")
+	// ErrTOTPNotEnabled 未启用两步验证
+	ErrTOTPNotEnabled = errors.New("未启用两步验证")
+	// ErrTOTPRequired 系统要求所有用户启用两步验证
+	ErrTOTPRequired = errors.New("系统要求启用两步验证，不能关闭")
+)
+
+// TOTPEnrollment 两步验证绑定信息
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // 生成二维码用的otpauth URI
+}
+
+// TwoFactorRequired 系统是否要求所有用户启用两步验证
+func (us *UserService) TwoFactorRequired() bool {
+	value, _ := database.GetSystemConfig("security.require_2fa")
+	required, _ := strconv.ParseBool(value)
+	return required
+}
+
+// BeginTOTPEnrollment 为用户生成新的待确认密钥，启用前可重复调用
+func (us *UserService) BeginTOTPEnrollment(userID uint) (*TOTPEnrollment, error) {
+	user, err := us.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	// 按列名更新不经过序列化器，需要先加密
+	sealed, err := models.SealColumn(us.db, user, "totp_secret", user.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.db.Model(user).Update("totp_secret", sealed).Error; err != nil {
+		return nil, fmt.Errorf("保存TOTP密钥失败: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// EnableTOTP 校验待确认密钥的验证码后启用两步验证，返回明文恢复码（只返回这一次）。
+// 与登录第二步相同，失败计入登录锁定
+func (us *UserService) EnableTOTP(userID uint, code, clientIP string) ([]string, error) {
+	user, err := us.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+
+	if err := us.lockout.Attempt(user.Username, clientIP); err != nil {
+		return nil, err
+	}
+	counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), 0)
+	if !ok {
+		if lockErr := us.loginFailed(user.Username, clientIP); !errors.Is(lockErr, ErrInvalidCredentials) {
+			return nil, lockErr
+		}
+		return nil, ErrInvalidTOTPCode
+	}
+	if err := us.lockout.Succeed(user.Username, clientIP); err != nil {
+		log.Printf("清除登录失败记录失败: %v", err)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := us.db.Model(user).Updates(map[string]interface{}{
+		"totp_enabled":        true,
+		"totp_last_counter":   counter,
+		"totp_recovery_codes": hashes,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("启用两步验证失败: %w", err)
+	}
+
+	return codes, nil
+}
+
+// VerifySecondFactor 登录第二步：校验验证码或恢复码，恢复码使用后作废。
+// 失败计入登录锁定，避免在临时令牌有效期内穷举验证码
+func (us *UserService) VerifySecondFactor(userID uint, code, clientIP string) (*models.User, error) {
+	user, err := us.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	if err := us.lockout.Attempt(user.Username, clientIP); err != nil {
+		return nil, err
+	}
+
+	if err := us.checkSecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			if lockErr := us.loginFailed(user.Username, clientIP); !errors.Is(lockErr, ErrInvalidCredentials) {
+				return nil, lockErr
+			}
+		}
+		return nil, err
+	}
+
+	if err := us.lockout.Succeed(user.Username, clientIP); err != nil {
+		log.Printf("清除登录失败记录失败: %v", err)
+	}
+	return user, nil
+}
+
+// checkSecondFactor 校验验证码或恢复码并记录使用情况
+func (us *UserService) checkSecondFactor(user *models.User, code string) error {
+	if counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastCounter); ok {
+		// 条件更新，并发请求中同一验证码只有一个能成功
+		result := us.db.Model(&models.User{}).
+			Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+			Update("totp_last_counter", counter)
+		if result.Error != nil {
+			return fmt.Errorf("更新验证状态失败: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	hash := totp.HashRecoveryCode(code)
+	remaining := make([]string, 0, recoveryCodeCount)
+	found := false
+	for _, h := range strings.Split(user.TOTPRecoveryCodes, "\n") {
+		if h == "" {
+			continue
+		}
+		if h == hash && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+	if !found {
+		return ErrInvalidTOTPCode
+	}
+
+	result := us.db.Model(&models.User{}).
+		Where("id = ? AND totp_recovery_codes = ?", user.ID, user.TOTPRecoveryCodes).
+		Update("totp_recovery_codes", strings.Join(remaining, "\n"))
+	if result.Error != nil {
+		return fmt.Errorf("更新恢复码失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTOTPCode
+	}
+	log.Printf("用户 %s 使用恢复码登录，剩余 %d 个", user.Username, len(remaining))
+	return nil
+}
+
+// DisableTOTP 用户自行关闭两步验证，需要密码和当前验证码
+func (us *UserService) DisableTOTP(userID uint, password, code string) error {
+	if us.TwoFactorRequired() {
+		return ErrTOTPRequired
+	}
+
+	user, err := us.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if !utils.CheckPassword(password, user.Password) {
+		return errors.New("密码错误")
+	}
+	if err := us.checkSecondFactor(user, code); err != nil {
+		return err
+	}
+
+	return us.ResetTOTP(userID)
+}
+
+// ResetTOTP 清除用户的两步验证设置，用于管理员处理丢失设备的情况
+func (us *UserService) ResetTOTP(userID uint) error {
+	result := us.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_enabled":        false,
+		"totp_secret":         "",
+		"totp_last_counter":   0,
+		"totp_recovery_codes": "",
+	})
+	if result.Error != nil {
+		return fmt.Errorf("重置两步验证失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("用户不存在")
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes 校验验证码后重新生成恢复码，旧恢复码全部作废
+func (us *UserService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := us.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	if err := us.checkSecondFactor(user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := us.db.Model(&models.User{}).Where("id = ?", userID).Update("totp_recovery_codes", hashes).Error; err != nil {
+		return nil, fmt.Errorf("保存恢复码失败: %w", err)
+	}
+	return codes, nil
+}
+
+// newRecoveryCodes 生成恢复码，返回明文和换行分隔的哈希
+func newRecoveryCodes() ([]string, string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, "", err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+	return codes, strings.Join(hashes, "\n"), nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -52,6 +53,7 @@ type ServerConfig struct {
 		IdleTimeout    time.Duration `yaml:"idle_timeout"`
 		MaxHeaderBytes int           `yaml:"max_header_bytes"`
 		ServerIP       string        `yaml:"server_ip"`
+		TrustedProxies []string      `yaml:"trusted_proxies"` // 可信反向代理的IP或网段，为空时忽略X-Forwarded-For
 		TLS            struct {
 			Enabled  bool   `yaml:"enabled"`
 			Listen   string `yaml:"listen"`
@@ -66,6 +68,7 @@ type ServerConfig struct {
 		Network      string `yaml:"network"`
 		DNS          string `yaml:"dns"`
 		SyncInterval int    `yaml:"sync_interval"`
+		ConfigDir    string `yaml:"config_dir"`
 	} `yaml:"wireguard"`
 
 	Database struct {
@@ -73,6 +76,26 @@ type ServerConfig struct {
 		Path string `yaml:"path"`
 	} `yaml:"database"`
 
+	Traffic struct {
+		MinuteRetention time.Duration `yaml:"minute_retention"` // 1分钟粒度流量保留时长
+		HourRetention   time.Duration `yaml:"hour_retention"`   // 1小时粒度流量保留时长
+		DayRetention    time.Duration `yaml:"day_retention"`    // 1天粒度流量保留时长
+	} `yaml:"traffic"`
+
+	UserVPN struct {
+		CheckInterval     time.Duration `yaml:"check_interval"`      // 检查用户VPN到期的间隔
+		ExpiryWarningDays int           `yaml:"expiry_warning_days"` // 到期前多少天发出提醒，0为不提醒
+	} `yaml:"user_vpn"`
+
+	Metrics struct {
+		Token string `yaml:"token"` // /metrics 的Bearer令牌，为空时不校验
+	} `yaml:"metrics"`
+
+	Encryption struct {
+		MasterKeyFile          string   `yaml:"master_key_file"`           // 主密钥文件，不存在时自动生成；环境变量 EITEC_VPN_MASTER_KEY 优先
+		PreviousMasterKeyFiles []string `yaml:"previous_master_key_files"` // 轮换前的旧主密钥，仅用于解密，启动时自动重新加密
+	} `yaml:"encryption"`
+
 	Auth struct {
 		AdminUsername  string        `yaml:"admin_username"`
 		AdminPassword  string        `yaml:"admin_password"`
@@ -81,15 +104,59 @@ type ServerConfig struct {
 		AccessExpiry   time.Duration `yaml:"access_expiry"`
 		RefreshExpiry  time.Duration `yaml:"refresh_expiry"`
 		SessionTimeout time.Duration `yaml:"session_timeout"`
+		Disabled       bool          `yaml:"disabled"` // 关闭API认证，所有请求视为管理员 (仅用于本地调试)
+
+		DisablePasswordLogin bool       `yaml:"disable_password_login"` // 关闭本地密码登录，只保留应急账户
+		BreakGlassUser       string     `yaml:"break_glass_user"`       // 关闭密码登录后仍可使用密码登录的应急账户，默认为 admin_username
+		OIDC                 OIDCConfig `yaml:"oidc"`
+		LDAP                 LDAPConfig `yaml:"ldap"`
 	} `yaml:"auth"`
 }
 
+// LDAPConfig LDAP目录认证配置，启用后本地账户密码不匹配时再到目录服务器认证
+type LDAPConfig struct {
+	Enabled            bool              `yaml:"enabled"`
+	URL                string            `yaml:"url"`                  // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool              `yaml:"start_tls"`            // ldap:// 连接后升级为TLS
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify"` // 不校验服务器证书，仅用于测试
+	CACertFile         string            `yaml:"ca_cert_file"`         // 校验服务器证书的CA，为空时使用系统CA
+	Timeout            time.Duration     `yaml:"timeout"`              // 连接和请求超时
+	BindDN             string            `yaml:"bind_dn"`              // 查找用户使用的服务账号，为空时匿名查找
+	BindPassword       string            `yaml:"bind_password"`        // 服务账号密码
+	BaseDN             string            `yaml:"base_dn"`              // 查找用户的起点
+	UserFilter         string            `yaml:"user_filter"`          // 查找用户的过滤器，%s 替换为转义后的用户名
+	UsernameAttribute  string            `yaml:"username_attribute"`   // 作为用户名的属性
+	GroupAttribute     string            `yaml:"group_attribute"`      // 用户条目中列出所属组的属性，如 memberOf
+	GroupBaseDN        string            `yaml:"group_base_dn"`        // 按组查找成员关系的起点，为空时只使用 group_attribute
+	GroupFilter        string            `yaml:"group_filter"`         // 查找用户所属组的过滤器，%s 替换为用户DN
+	RoleMapping        map[string]string `yaml:"role_mapping"`         // 组DN或CN到角色的映射，匹配多个时取权限最高的角色
+	DefaultRole        string            `yaml:"default_role"`         // 没有匹配的组时的角色，为空时拒绝登录
+	AutoProvision      bool              `yaml:"auto_provision"`       // 首次登录时自动创建用户
+}
+
+// OIDCConfig OpenID Connect单点登录配置
+type OIDCConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	Name          string            `yaml:"name"`           // 登录页按钮显示的身份提供方名称
+	Issuer        string            `yaml:"issuer"`         // 身份提供方地址
+	ClientID      string            `yaml:"client_id"`      // 客户端ID
+	ClientSecret  string            `yaml:"client_secret"`  // 客户端密钥，公共客户端留空
+	RedirectURL   string            `yaml:"redirect_url"`   // 回调地址，如 https://vpn.example.com/api/v1/auth/oidc/callback
+	Scopes        []string          `yaml:"scopes"`         // 申请的scope
+	UsernameClaim string            `yaml:"username_claim"` // 作为用户名的声明
+	GroupsClaim   string            `yaml:"groups_claim"`   // 用户组声明
+	RoleMapping   map[string]string `yaml:"role_mapping"`   // 用户组到角色的映射，匹配多个时取权限最高的角色
+	DefaultRole   string            `yaml:"default_role"`   // 没有匹配的用户组时的角色，为空时拒绝登录
+	AutoProvision bool              `yaml:"auto_provision"` // 首次登录时自动创建用户
+}
+
 // ModuleConfig 模块端配置
 type ModuleConfig struct {
 	App struct {
-		Name   string `yaml:"name"`
-		Port   int    `yaml:"port"`
-		Secret string `yaml:"secret"`
+		Name           string   `yaml:"name"`
+		Port           int      `yaml:"port"`
+		Secret         string   `yaml:"secret"`
+		TrustedProxies []string `yaml:"trusted_proxies"` // 可信反向代理的IP或网段，为空时忽略X-Forwarded-For
 	} `yaml:"app"`
 
 	Module struct {
@@ -110,7 +177,18 @@ type ModuleConfig struct {
 
 	WireGuard struct {
 		Interface string `yaml:"interface"`
+		ConfigDir string `yaml:"config_dir"`
 	} `yaml:"wireguard"`
+
+	Auth struct {
+		SessionTimeout   time.Duration `yaml:"session_timeout"`    // Web界面登录会话有效期
+		MaxLoginAttempts int           `yaml:"max_login_attempts"` // 连续登录失败多少次后锁定账户
+		LockoutDuration  time.Duration `yaml:"lockout_duration"`   // 首次锁定时长，之后每次翻倍
+	} `yaml:"auth"`
+
+	Metrics struct {
+		Token string `yaml:"token"` // /metrics 的Bearer令牌，为空时不校验
+	} `yaml:"metrics"`
 }
 
 // findConfigFile 智能查找配置文件
@@ -138,6 +216,14 @@ func findConfigFile(filename string) (string, error) {
 	return "", fmt.Errorf("配置文件 %s 未找到，已搜索路径: %v", filename, candidates)
 }
 
+// ResolveConfigPath 获取配置文件的实际路径，未找到时返回原路径
+func ResolveConfigPath(configPath string) string {
+	if actualPath, err := findConfigFile(configPath); err == nil {
+		return actualPath
+	}
+	return configPath
+}
+
 // LoadServerConfig 加载服务器配置
 func LoadServerConfig(configPath string) (*ServerConfig, error) {
 	config := &ServerConfig{}
@@ -156,15 +242,31 @@ func LoadServerConfig(configPath string) (*ServerConfig, error) {
 	config.WireGuard.Network = "10.10.0.0/24"
 	config.WireGuard.DNS = "8.8.8.8,8.8.4.4"
 	config.WireGuard.SyncInterval = 300
+	config.WireGuard.ConfigDir = "/etc/wireguard"
 	config.Database.Type = "sqlite"
 	config.Database.Path = "data/eitec-vpn.db"
+	config.Traffic.MinuteRetention = 48 * time.Hour
+	config.Traffic.HourRetention = 90 * 24 * time.Hour
+	config.Traffic.DayRetention = 730 * 24 * time.Hour
+	config.UserVPN.CheckInterval = time.Minute
+	config.UserVPN.ExpiryWarningDays = 7
+	config.Encryption.MasterKeyFile = "data/master.key"
 	config.Auth.AdminUsername = "admin"
 	config.Auth.AdminPassword = "admin123"
-	config.Auth.JWTSecret = "your-jwt-secret-key"
-	config.Auth.RefreshSecret = "your-refresh-secret-key"
 	config.Auth.AccessExpiry = 1 * time.Hour
 	config.Auth.RefreshExpiry = 24 * time.Hour
 	config.Auth.SessionTimeout = 24 * time.Hour
+	config.Auth.OIDC.Name = "SSO"
+	config.Auth.OIDC.Scopes = []string{"openid", "profile", "email"}
+	config.Auth.OIDC.UsernameClaim = "preferred_username"
+	config.Auth.OIDC.GroupsClaim = "groups"
+	config.Auth.OIDC.AutoProvision = true
+	config.Auth.LDAP.Timeout = 10 * time.Second
+	config.Auth.LDAP.UserFilter = "(&(objectClass=person)(uid=%s))"
+	config.Auth.LDAP.UsernameAttribute = "uid"
+	config.Auth.LDAP.GroupAttribute = "memberOf"
+	config.Auth.LDAP.GroupFilter = "(|(member=%s)(uniqueMember=%s))"
+	config.Auth.LDAP.AutoProvision = true
 
 	if configPath != "" {
 		// 智能查找配置文件
@@ -187,6 +289,21 @@ func LoadServerConfig(configPath string) (*ServerConfig, error) {
 	if config.App.Secret == "" {
 		return nil, fmt.Errorf("app.secret 不能为空")
 	}
+	if err := validateTrustedProxies(config.App.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if oidc := &config.Auth.OIDC; oidc.Enabled && (oidc.Issuer == "" || oidc.ClientID == "" || oidc.RedirectURL == "") {
+		return nil, fmt.Errorf("启用OIDC时 auth.oidc.issuer、client_id 和 redirect_url 不能为空")
+	}
+	if ldap := &config.Auth.LDAP; ldap.Enabled && (ldap.URL == "" || ldap.BaseDN == "") {
+		return nil, fmt.Errorf("启用LDAP时 auth.ldap.url 和 base_dn 不能为空")
+	}
+	if err := validateJWTSecrets(config); err != nil {
+		return nil, err
+	}
+	if config.Auth.BreakGlassUser == "" {
+		config.Auth.BreakGlassUser = config.Auth.AdminUsername
+	}
 
 	return config, nil
 }
@@ -204,6 +321,10 @@ func LoadModuleConfig(configPath string) (*ModuleConfig, error) {
 	config.Server.ReportInterval = 60
 	config.Server.SyncInterval = 300
 	config.WireGuard.Interface = "wg0"
+	config.WireGuard.ConfigDir = "/etc/wireguard"
+	config.Auth.SessionTimeout = 24 * time.Hour
+	config.Auth.MaxLoginAttempts = 5
+	config.Auth.LockoutDuration = 15 * time.Minute
 
 	if configPath != "" {
 		// 智能查找配置文件
@@ -226,10 +347,53 @@ func LoadModuleConfig(configPath string) (*ModuleConfig, error) {
 	if config.App.Secret == "" {
 		return nil, fmt.Errorf("app.secret 不能为空")
 	}
+	if err := validateTrustedProxies(config.App.TrustedProxies); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
 
+// placeholderSecrets 曾随项目发布的示例密钥，任何人都可以用它们签发令牌
+var placeholderSecrets = map[string]bool{
+	"your-jwt-secret-key":                               true,
+	"your-refresh-secret-key":                           true,
+	"your-jwt-secret-key-change-this-in-production":     true,
+	"your-refresh-secret-key-change-this-in-production": true,
+}
+
+// validateJWTSecrets 启用认证时令牌密钥不能为空或使用示例值
+func validateJWTSecrets(config *ServerConfig) error {
+	if config.Auth.Disabled {
+		return nil
+	}
+	for _, secret := range []struct{ name, value string }{
+		{"auth.jwt_secret", config.Auth.JWTSecret},
+		{"auth.refresh_secret", config.Auth.RefreshSecret},
+	} {
+		if secret.value == "" {
+			return fmt.Errorf("%s 不能为空，请设置为随机字符串（如 openssl rand -base64 32 的输出）", secret.name)
+		}
+		if placeholderSecrets[secret.value] {
+			return fmt.Errorf("%s 仍为示例值，任何人都可以用它签发令牌，请设置为随机字符串", secret.name)
+		}
+	}
+	return nil
+}
+
+// validateTrustedProxies 检查可信代理均为IP或CIDR
+func validateTrustedProxies(proxies []string) error {
+	for _, proxy := range proxies {
+		if _, _, err := net.ParseCIDR(proxy); err == nil {
+			continue
+		}
+		if net.ParseIP(proxy) == nil {
+			return fmt.Errorf("app.trusted_proxies 包含无效地址: %s", proxy)
+		}
+	}
+	return nil
+}
+
 // SaveServerConfig 保存服务器配置
 func SaveServerConfig(config *ServerConfig, configPath string) error {
 	data, err := yaml.Marshal(config)
@@ -251,7 +415,8 @@ func SaveModuleConfig(config *ModuleConfig, configPath string) error {
 		return fmt.Errorf("序列化配置失败: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	// 配置中包含模块私钥和API密钥，仅允许所有者读写
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
 		return fmt.Errorf("保存配置文件失败: %w", err)
 	}
 
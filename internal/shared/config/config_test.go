@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadServerConfigRequiresJWTSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    string
+		wantErr string
+	}{
+		{name: "未设置密钥", auth: "", wantErr: "auth.jwt_secret 不能为空"},
+		{name: "示例密钥", auth: "  jwt_secret: \"your-jwt-secret-key-change-this-in-production\"\n  refresh_secret: \"r4nd0m-refresh\"\n", wantErr: "auth.jwt_secret 仍为示例值"},
+		{name: "示例刷新密钥", auth: "  jwt_secret: \"r4nd0m-access\"\n  refresh_secret: \"your-refresh-secret-key\"\n", wantErr: "auth.refresh_secret 仍为示例值"},
+		{name: "随机密钥", auth: "  jwt_secret: \"r4nd0m-access\"\n  refresh_secret: \"r4nd0m-refresh\"\n"},
+		// 关闭认证时不签发令牌，无需密钥
+		{name: "关闭认证", auth: "  disabled: true\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "server.yaml")
+			data := "app:\n  secret: \"test-secret\"\nauth:\n" + tt.auth
+			if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadServerConfig(path)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("不应拒绝启动: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("错误 %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShippedServerConfigRefusesToStart(t *testing.T) {
+	// 随项目发布的配置文件不带可用的密钥，必须由部署者设置
+	if _, err := LoadServerConfig(filepath.Join("..", "..", "..", "configs", "server.yaml")); err == nil || !strings.Contains(err.Error(), "jwt_secret") {
+		t.Errorf("示例配置应拒绝启动, 得到 %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+// Package envelope 实现数据库敏感字段的信封加密
+//
+// 每个值使用随机生成的数据密钥以AES-256-GCM加密，数据密钥再由主密钥加密后与密文一起保存，
+// 格式为 enc:v1:<主密钥ID>:<加密的数据密钥>:<密文>。主密钥ID为密钥SHA-256的前8位十六进制，
+// 轮换主密钥时旧密钥仍可用于解密，直到所有数据用新主密钥重新加密。
+// 密文以"表名:列名:主键"作为附加数据，复制到其他行或列后无法解密。
+// 不带前缀的值是升级前保存的明文，只在启动迁移完成前按明文读取，迁移后读取时返回ErrNotSealed。
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// KeySize 主密钥和数据密钥的长度
+	KeySize = 32
+	// EnvMasterKey 以base64提供主密钥的环境变量，优先于配置文件中的密钥文件
+	EnvMasterKey = "EITEC_VPN_MASTER_KEY"
+
+	prefix = "enc:v1:"
+)
+
+var (
+	// ErrNoKeyring 未加载主密钥
+	ErrNoKeyring = errors.New("未加载主密钥，无法加密敏感字段")
+	// ErrUnknownKey 密文使用的主密钥不在当前密钥环中
+	ErrUnknownKey = errors.New("密文使用的主密钥未加载")
+	// ErrNotSealed 值未加密，敏感字段迁移完成后不应再出现明文
+	ErrNotSealed = errors.New("敏感字段未加密")
+
+	encoding       = base64.RawStdEncoding
+	current        atomic.Pointer[Keyring]
+	allowPlaintext atomic.Bool
+)
+
+// Keyring 主密钥环，新数据使用主密钥加密，旧密钥只用于解密
+type Keyring struct {
+	primary *masterKey
+	keys    map[string]*masterKey
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKeyring 创建密钥环，primary用于加密，previous为轮换前的旧主密钥
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*masterKey)}
+	for i, raw := range append([][]byte{primary}, previous...) {
+		key, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = key
+		}
+		k.keys[key.id] = key
+	}
+	return k, nil
+}
+
+// Rotate 以新主密钥创建密钥环，当前所有密钥保留用于解密
+func (k *Keyring) Rotate(primary []byte) (*Keyring, error) {
+	key, err := newMasterKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	rotated := &Keyring{primary: key, keys: map[string]*masterKey{key.id: key}}
+	for id, old := range k.keys {
+		if id != key.id {
+			rotated.keys[id] = old
+		}
+	}
+	return rotated, nil
+}
+
+// PrimaryID 主密钥ID
+func (k *Keyring) PrimaryID() string {
+	return k.primary.id
+}
+
+// AAD 敏感字段的附加数据，格式为 表名:列名:主键
+func AAD(table, column string, primaryKey interface{}) string {
+	return fmt.Sprintf("%s:%s:%v", table, column, primaryKey)
+}
+
+// Seal 加密明文并绑定附加数据aad，空字符串不加密
+func (k *Keyring) Seal(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("生成数据密钥失败: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.primary.aead, dataKey, []byte(k.primary.id))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.primary.id + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(ciphertext), nil
+}
+
+// Open 解密Seal生成的值，aad须与加密时相同；空字符串原样返回，其他未加密的值返回ErrNotSealed
+func (k *Keyring) Open(value, aad string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	body, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return "", ErrNotSealed
+	}
+
+	parts := strings.Split(body, ":")
+	if len(parts) != 3 {
+		return "", errors.New("密文格式错误")
+	}
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, parts[0])
+	}
+
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %w", err)
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %w", err)
+	}
+
+	dataKey, err := open(key.aead, wrapped, []byte(key.id))
+	if err != nil {
+		return "", fmt.Errorf("解密数据密钥失败: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("解密失败: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReseal 值是否需要用当前主密钥重新加密（明文或使用旧主密钥加密）
+func (k *Keyring) NeedsReseal(value string) bool {
+	if value == "" {
+		return false
+	}
+	return KeyID(value) != k.primary.id
+}
+
+// IsSealed 值是否为Seal生成的密文
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyID 密文使用的主密钥ID，明文返回空字符串
+func KeyID(value string) string {
+	if !IsSealed(value) {
+		return ""
+	}
+	id, _, _ := strings.Cut(value[len(prefix):], ":")
+	return id
+}
+
+// SetKeyring 设置全局密钥环
+func SetKeyring(k *Keyring) {
+	current.Store(k)
+}
+
+// Default 全局密钥环，未设置时返回nil
+func Default() *Keyring {
+	return current.Load()
+}
+
+// Seal 使用全局密钥环加密
+func Seal(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	k := Default()
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+	return k.Seal(plaintext, aad)
+}
+
+// AllowPlaintext 设置是否按明文读取未加密的值，只在启动时加密升级前数据的迁移完成前开启
+func AllowPlaintext(allow bool) {
+	allowPlaintext.Store(allow)
+}
+
+// Open 使用全局密钥环解密，迁移完成前未加密的值原样返回
+func Open(value, aad string) (string, error) {
+	if value == "" || (!IsSealed(value) && allowPlaintext.Load()) {
+		return value, nil
+	}
+	k := Default()
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+	return k.Open(value, aad)
+}
+
+// GenerateKey 生成随机主密钥
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("生成主密钥失败: %w", err)
+	}
+	return key, nil
+}
+
+// ParseKey 解析base64编码的主密钥
+func ParseKey(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	key, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		key, err = encoding.DecodeString(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("主密钥必须为base64编码: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("主密钥长度必须为%d字节，实际为%d字节", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadKeyFile 读取主密钥文件
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取主密钥文件失败: %w", err)
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("主密钥文件 %s 无效: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadOrCreateKeyFile 读取主密钥文件，文件不存在时生成新密钥并以0600权限写入，created表示是否新建
+func LoadOrCreateKeyFile(path string) (key []byte, created bool, err error) {
+	if _, err := os.Stat(path); err == nil {
+		key, err := LoadKeyFile(path)
+		return key, false, err
+	} else if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("读取主密钥文件失败: %w", err)
+	}
+
+	key, err = GenerateKey()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("创建主密钥目录失败: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, false, fmt.Errorf("写入主密钥文件失败: %w", err)
+	}
+	return key, true, nil
+}
+
+// newMasterKey 根据主密钥创建加密器，ID为密钥SHA-256的前8位十六进制
+func newMasterKey(raw []byte) (*masterKey, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("主密钥长度必须为%d字节", KeySize)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// newAEAD 创建AES-256-GCM加密器
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %w", err)
+	}
+	return aead, nil
+}
+
+// seal 加密并在密文前附加随机nonce
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open 解密seal生成的数据
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("密文长度不足")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
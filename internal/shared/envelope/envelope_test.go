@@ -0,0 +1,140 @@
+package envelope
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, _ := GenerateKey()
+	keyring, err := NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const secret = "aGVsbG8td29ybGQtcHJpdmF0ZS1rZXktMzItYnl0ZXM="
+	aad := AAD("modules", "private_key", uint(1))
+	sealed, err := keyring.Seal(secret, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, secret) || KeyID(sealed) != keyring.PrimaryID() {
+		t.Fatalf("密文格式错误: %s", sealed)
+	}
+	again, _ := keyring.Seal(secret, aad)
+	if again == sealed {
+		t.Error("相同明文每次加密结果应不同")
+	}
+
+	plaintext, err := keyring.Open(sealed, aad)
+	if err != nil || plaintext != secret {
+		t.Fatalf("解密结果 %q, %v", plaintext, err)
+	}
+
+	// 未加密的值不能当作密文读取，空值不加密
+	if _, err := keyring.Open("legacy", aad); !errors.Is(err, ErrNotSealed) {
+		t.Errorf("明文应返回ErrNotSealed, 实际: %v", err)
+	}
+	if empty, _ := keyring.Seal("", aad); empty != "" {
+		t.Error("空值不应加密")
+	}
+	if empty, err := keyring.Open("", aad); err != nil || empty != "" {
+		t.Errorf("空值解密结果 %q, %v", empty, err)
+	}
+
+	// 篡改密文
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := keyring.Open(tampered, aad); err == nil {
+		t.Error("篡改的密文不应解密成功")
+	}
+}
+
+func TestAdditionalData(t *testing.T) {
+	key, _ := GenerateKey()
+	keyring, _ := NewKeyring(key)
+
+	if aad := AAD("modules", "private_key", uint(7)); aad != "modules:private_key:7" {
+		t.Errorf("附加数据 %q", aad)
+	}
+
+	// 密文复制到其他行或列后无法解密
+	sealed, _ := keyring.Seal("secret", AAD("modules", "private_key", 1))
+	for _, aad := range []string{
+		AAD("modules", "private_key", 2),
+		AAD("modules", "preshared_key", 1),
+		AAD("user_vpns", "private_key", 1),
+	} {
+		if _, err := keyring.Open(sealed, aad); err == nil {
+			t.Errorf("附加数据为 %s 时不应解密成功", aad)
+		}
+	}
+	if keyring.NeedsReseal(sealed) {
+		t.Error("使用主密钥加密的值不应需要重新加密")
+	}
+}
+
+func TestOpenPlaintext(t *testing.T) {
+	key, _ := GenerateKey()
+	keyring, _ := NewKeyring(key)
+	SetKeyring(keyring)
+	t.Cleanup(func() {
+		SetKeyring(nil)
+		AllowPlaintext(false)
+	})
+
+	// 迁移完成前升级前的明文原样返回，完成后读取报错
+	AllowPlaintext(true)
+	if plaintext, err := Open("legacy", "modules:private_key:1"); err != nil || plaintext != "legacy" {
+		t.Errorf("迁移前读取明文: %q, %v", plaintext, err)
+	}
+	AllowPlaintext(false)
+	if _, err := Open("legacy", "modules:private_key:1"); !errors.Is(err, ErrNotSealed) {
+		t.Errorf("迁移后读取明文应返回ErrNotSealed, 实际: %v", err)
+	}
+	if empty, err := Open("", "modules:private_key:1"); err != nil || empty != "" {
+		t.Errorf("空值: %q, %v", empty, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey, _ := GenerateKey()
+	newKey, _ := GenerateKey()
+	oldKeyring, _ := NewKeyring(oldKey)
+	sealed, _ := oldKeyring.Seal("secret", "")
+
+	newOnly, _ := NewKeyring(newKey)
+	if _, err := newOnly.Open(sealed, ""); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("缺少旧密钥时应返回ErrUnknownKey, 实际: %v", err)
+	}
+
+	rotated, _ := oldKeyring.Rotate(newKey)
+	if !rotated.NeedsReseal(sealed) || !rotated.NeedsReseal("plain") || rotated.NeedsReseal("") {
+		t.Error("NeedsReseal判断错误")
+	}
+	plaintext, err := rotated.Open(sealed, "")
+	if err != nil || plaintext != "secret" {
+		t.Fatalf("旧密钥解密失败: %v", err)
+	}
+	resealed, _ := rotated.Seal(plaintext, "")
+	if rotated.NeedsReseal(resealed) {
+		t.Error("重新加密后不应再需要轮换")
+	}
+}
+
+func TestLoadOrCreateKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "master.key")
+	key, created, err := LoadOrCreateKeyFile(path)
+	if err != nil || !created || len(key) != KeySize {
+		t.Fatalf("生成主密钥失败: created=%v err=%v", created, err)
+	}
+	loaded, created, err := LoadOrCreateKeyFile(path)
+	if err != nil || created || string(loaded) != string(key) {
+		t.Fatalf("读取主密钥失败: created=%v err=%v", created, err)
+	}
+
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Error("长度错误的密钥应被拒绝")
+	}
+}
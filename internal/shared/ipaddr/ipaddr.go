@@ -0,0 +1,118 @@
+// Package ipaddr 提供IPv4/IPv6通用的地址计算：网段内按序号取地址、单地址路由前缀和ULA网段生成
+package ipaddr
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// ulaPrefix IPv6唯一本地地址 fc00::/7 (RFC 4193)
+var ulaPrefix = &net.IPNet{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)}
+
+// IsIPv6 地址或网段是否为IPv6
+func IsIPv6(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		ip, _, _ = net.ParseCIDR(address)
+	}
+	return ip != nil && ip.To4() == nil
+}
+
+// IsULA 地址是否为IPv6唯一本地地址
+func IsULA(ip net.IP) bool {
+	return ip.To4() == nil && ulaPrefix.Contains(ip)
+}
+
+// Host 单个地址的路由前缀：IPv4为/32，IPv6为/128，地址为空时返回空
+func Host(address string) string {
+	if address == "" {
+		return ""
+	}
+	if IsIPv6(address) {
+		return address + "/128"
+	}
+	return address + "/32"
+}
+
+// Hosts 多个地址的路由前缀，跳过空地址
+func Hosts(addresses ...string) []string {
+	hosts := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if host := Host(address); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// InNetwork 地址加上所在网段的前缀长度，如 10.10.0.1 与 10.10.0.0/24 得到 10.10.0.1/24
+func InNetwork(address, network string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return "", fmt.Errorf("无效的网段 %s: %w", network, err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", address, ones), nil
+}
+
+// Nth 网段内第n个地址，0为网段地址本身；超出网段时返回nil
+func Nth(network *net.IPNet, n uint64) net.IP {
+	base := network.IP.Mask(network.Mask)
+	if ip4 := base.To4(); ip4 != nil {
+		base = ip4
+	}
+
+	value := new(big.Int).SetBytes(base)
+	value.Add(value, new(big.Int).SetUint64(n))
+	bytes := value.Bytes()
+	if len(bytes) > len(base) {
+		return nil
+	}
+
+	ip := make(net.IP, len(base))
+	copy(ip[len(ip)-len(bytes):], bytes)
+	if !network.Contains(ip) {
+		return nil
+	}
+	return ip
+}
+
+// Offset 地址在网段内的序号，与Nth互逆；地址不在网段内或序号超出uint64时返回false
+func Offset(network *net.IPNet, ip net.IP) (uint64, bool) {
+	if !network.Contains(ip) {
+		return 0, false
+	}
+	base := network.IP.Mask(network.Mask)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, base = ip4, base.To4()
+	}
+
+	value := new(big.Int).Sub(new(big.Int).SetBytes(ip), new(big.Int).SetBytes(base))
+	if !value.IsUint64() {
+		return 0, false
+	}
+	return value.Uint64(), true
+}
+
+// Size 网段内的地址数量，超过上限时返回上限
+func Size(network *net.IPNet, limit uint64) uint64 {
+	ones, bits := network.Mask.Size()
+	if bits-ones >= 64 {
+		return limit
+	}
+	return min(uint64(1)<<(bits-ones), limit)
+}
+
+// GenerateULA 随机生成一个IPv6唯一本地地址/64网段，如 fd3c:91a2:5e07:1::/64
+func GenerateULA() (string, error) {
+	// fd + 40位随机全局ID + 16位子网ID
+	prefix := make(net.IP, net.IPv6len)
+	prefix[0] = 0xfd
+	if _, err := rand.Read(prefix[1:6]); err != nil {
+		return "", fmt.Errorf("生成IPv6网段失败: %w", err)
+	}
+	prefix[7] = 1
+	return (&net.IPNet{IP: prefix, Mask: net.CIDRMask(64, 128)}).String(), nil
+}
@@ -0,0 +1,81 @@
+package ipaddr
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNth(t *testing.T) {
+	tests := []struct {
+		network string
+		n       uint64
+		want    string
+	}{
+		{"10.10.0.0/24", 1, "10.10.0.1"},
+		{"10.10.0.0/24", 255, "10.10.0.255"},
+		{"10.10.0.0/24", 256, "<nil>"},
+		{"10.10.0.0/16", 258, "10.10.1.2"},
+		{"fd00:1:2:3::/64", 2, "fd00:1:2:3::2"},
+		{"fd00:1:2:3::/64", 0x10000, "fd00:1:2:3::1:0"},
+		{"fd00::/126", 4, "<nil>"},
+	}
+	for _, tt := range tests {
+		_, network, err := net.ParseCIDR(tt.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Nth(network, tt.n).String(); got != tt.want {
+			t.Errorf("Nth(%s, %d) = %s, 期望 %s", tt.network, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	for _, network := range []string{"10.10.0.0/16", "fd00:1:2:3::/64"} {
+		_, ipNet, _ := net.ParseCIDR(network)
+		for _, n := range []uint64{0, 1, 258, 0xfffe} {
+			if got, ok := Offset(ipNet, Nth(ipNet, n)); !ok || got != n {
+				t.Errorf("Offset(%s, Nth(%d)) = %d, %v", network, n, got, ok)
+			}
+		}
+		if _, ok := Offset(ipNet, net.ParseIP("192.168.1.1")); ok {
+			t.Errorf("网段 %s 外的地址返回了序号", network)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	got := Hosts("10.10.0.2", "", "fd00::2")
+	if strings.Join(got, ",") != "10.10.0.2/32,fd00::2/128" {
+		t.Errorf("Hosts = %v", got)
+	}
+
+	address, err := InNetwork("fd00::1", "fd00::/64")
+	if err != nil || address != "fd00::1/64" {
+		t.Errorf("InNetwork = %s, %v", address, err)
+	}
+}
+
+func TestGenerateULA(t *testing.T) {
+	network, err := GenerateULA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatalf("生成的网段 %s 无效: %v", network, err)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones != 64 || !IsULA(ip) {
+		t.Errorf("生成的网段 %s 不是ULA /64", network)
+	}
+	if IsULA(net.ParseIP("2001:db8::1")) || IsULA(net.ParseIP("10.0.0.1")) {
+		t.Error("非ULA地址被识别为ULA")
+	}
+
+	_, small, _ := net.ParseCIDR("10.0.0.0/24")
+	_, large, _ := net.ParseCIDR(network)
+	if Size(small, 1000) != 256 || Size(large, 1000) != 1000 {
+		t.Errorf("Size = %d, %d", Size(small, 1000), Size(large, 1000))
+	}
+}
@@ -0,0 +1,371 @@
+// Package lockout 记录登录失败次数并临时锁定账户和来源IP
+//
+// 账户和来源IP分别计数：同一账户连续失败达到阈值后锁定该账户，同一IP对所有账户的
+// 失败总数达到IP阈值后锁定该IP。每次锁定的时长在前一次基础上翻倍，直到上限；
+// 长时间没有失败后计数和翻倍次数清零。记录保存在数据库中，服务端和模块端各自迁移。
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 锁定对象类型
+const (
+	KindUser = "user"
+	KindIP   = "ip"
+)
+
+// 事件类型
+const (
+	ActionLocked   = "locked"
+	ActionUnlocked = "unlocked"
+)
+
+// Policy 锁定策略
+type Policy struct {
+	MaxAttempts   int           // 账户连续失败多少次后锁定，<=0 表示不锁定
+	IPMaxAttempts int           // 同一IP失败多少次后锁定，<=0 表示不锁定
+	Duration      time.Duration // 首次锁定时长，之后每次翻倍
+	MaxDuration   time.Duration // 锁定时长上限，<=0 时为24小时
+	ResetAfter    time.Duration // 超过该时间没有失败则清零计数和翻倍次数
+}
+
+// DefaultPolicy 根据失败次数和锁定时长生成策略，IP阈值为账户阈值的4倍
+func DefaultPolicy(maxAttempts int, duration time.Duration) Policy {
+	return Policy{
+		MaxAttempts:   maxAttempts,
+		IPMaxAttempts: maxAttempts * 4,
+		Duration:      duration,
+		MaxDuration:   24 * time.Hour,
+		ResetAfter:    24 * time.Hour,
+	}
+}
+
+// Record 账户或IP的失败计数
+type Record struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Kind        string     `json:"kind" gorm:"size:10;not null;uniqueIndex:idx_login_lockout_key"`
+	Key         string     `json:"key" gorm:"size:100;not null;uniqueIndex:idx_login_lockout_key"`
+	Failures    int        `json:"failures"`     // 当前窗口内的连续失败次数
+	Lockouts    int        `json:"lockouts"`     // 连续锁定次数，用于计算翻倍时长
+	LockedUntil *time.Time `json:"locked_until"` // 为空或早于当前时间表示未锁定
+	LastFailure time.Time  `json:"last_failure" gorm:"index"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TableName 指定表名
+func (Record) TableName() string {
+	return "login_lockouts"
+}
+
+// IsLocked 在指定时间是否处于锁定状态
+func (r *Record) IsLocked(now time.Time) bool {
+	return r.LockedUntil != nil && now.Before(*r.LockedUntil)
+}
+
+// Event 锁定和解锁事件
+type Event struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Kind        string     `json:"kind" gorm:"size:10;not null;index"`
+	Key         string     `json:"key" gorm:"size:100;not null;index"`
+	Action      string     `json:"action" gorm:"size:20;not null"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until"`
+	ClientIP    string     `json:"client_ip" gorm:"size:64"` // 触发锁定的请求来源
+	Actor       string     `json:"actor" gorm:"size:50"`     // 手动解锁的管理员
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
+}
+
+// TableName 指定表名
+func (Event) TableName() string {
+	return "login_lockout_events"
+}
+
+// Models 需要迁移的模型
+func Models() []interface{} {
+	return []interface{}{&Record{}, &Event{}}
+}
+
+// ErrNotLocked 没有对应的失败记录
+var ErrNotLocked = errors.New("没有对应的锁定记录")
+
+// LockedError 账户或IP处于锁定状态
+type LockedError struct {
+	Kind  string
+	Key   string
+	Until time.Time
+}
+
+// Error 实现error接口
+func (e *LockedError) Error() string {
+	target := "账户"
+	if e.Kind == KindIP {
+		target = "来源IP"
+	}
+	return fmt.Sprintf("%s已被临时锁定，请在%s后重试", target, e.Until.Format("2006-01-02 15:04:05"))
+}
+
+// RetryAfter 距离解锁的剩余时间
+func (e *LockedError) RetryAfter() time.Duration {
+	if d := time.Until(e.Until); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Tracker 登录失败跟踪器，可并发使用
+type Tracker struct {
+	db     *gorm.DB
+	policy func() Policy
+	now    func() time.Time
+}
+
+// NewTracker 创建跟踪器，policy在每次调用时读取，便于运行时修改配置
+func NewTracker(db *gorm.DB, policy func() Policy) *Tracker {
+	return &Tracker{db: db, policy: policy, now: time.Now}
+}
+
+// Attempt 登录验证前预占一次尝试：原子地将账户和来源IP的失败次数加1，验证成功后由Succeed清除。
+// 已锁定时不计数并返回*LockedError；并发进行中的尝试超过阈值时立即锁定，
+// 避免多个请求同时通过检查后绕过次数限制
+func (t *Tracker) Attempt(username, clientIP string) error {
+	policy := t.policy()
+	now := t.now()
+
+	var locked *LockedError
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		for _, target := range targets(username, clientIP) {
+			record, err := reserve(tx, target, policy, now)
+			if err != nil {
+				return err
+			}
+			if record.IsLocked(now) {
+				// 返回错误回滚本次计数
+				return &LockedError{Kind: target.kind, Key: target.key, Until: *record.LockedUntil}
+			}
+			if limit := policy.limit(target.kind); limit > 0 && record.Failures > limit {
+				lockErr, err := lock(tx, record, policy, now, clientIP)
+				if err != nil {
+					return err
+				}
+				if locked == nil {
+					locked = lockErr
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if locked != nil {
+		return locked
+	}
+	return nil
+}
+
+// Fail 验证失败后调用，Attempt预占的次数即为本次失败。达到阈值时锁定并返回*LockedError，
+// 已被并发请求锁定时同样返回*LockedError
+func (t *Tracker) Fail(username, clientIP string) error {
+	policy := t.policy()
+	now := t.now()
+
+	var locked *LockedError
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		for _, target := range targets(username, clientIP) {
+			// 先写后读，SQLite事务在首次写入时加写锁，并发的Fail依次执行
+			result := tx.Model(&Record{}).Where("kind = ? AND key = ?", target.kind, target.key).Update("last_failure", now)
+			if result.Error != nil {
+				return fmt.Errorf("保存失败记录失败: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				// 验证期间已被解锁或登录成功清除
+				continue
+			}
+
+			var record Record
+			if err := tx.Where("kind = ? AND key = ?", target.kind, target.key).First(&record).Error; err != nil {
+				return fmt.Errorf("查询失败记录失败: %w", err)
+			}
+
+			var lockErr *LockedError
+			if record.IsLocked(now) {
+				lockErr = &LockedError{Kind: target.kind, Key: target.key, Until: *record.LockedUntil}
+			} else if limit := policy.limit(target.kind); limit > 0 && record.Failures >= limit {
+				var err error
+				if lockErr, err = lock(tx, &record, policy, now, clientIP); err != nil {
+					return err
+				}
+			}
+			if locked == nil {
+				locked = lockErr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if locked != nil {
+		return locked
+	}
+	return nil
+}
+
+// reserve 以upsert原子地增加失败次数，超过ResetAfter没有失败时从1重新计数，返回更新后的记录
+func reserve(tx *gorm.DB, target target, policy Policy, now time.Time) (*Record, error) {
+	failures := gorm.Expr("failures + 1")
+	lockouts := gorm.Expr("lockouts")
+	if policy.ResetAfter > 0 {
+		cutoff := now.Add(-policy.ResetAfter)
+		failures = gorm.Expr("CASE WHEN last_failure < ? THEN 1 ELSE failures + 1 END", cutoff)
+		lockouts = gorm.Expr("CASE WHEN last_failure < ? THEN 0 ELSE lockouts END", cutoff)
+	}
+
+	// 并发的首次失败由(kind, key)唯一索引合并为同一条记录
+	record := &Record{Kind: target.kind, Key: target.key, Failures: 1, LastFailure: now}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":     failures,
+			"lockouts":     lockouts,
+			"last_failure": now,
+			"updated_at":   now,
+		}),
+	}).Create(record).Error
+	if err != nil {
+		return nil, fmt.Errorf("保存失败记录失败: %w", err)
+	}
+
+	if err := tx.Where("kind = ? AND key = ?", target.kind, target.key).First(record).Error; err != nil {
+		return nil, fmt.Errorf("查询失败记录失败: %w", err)
+	}
+	return record, nil
+}
+
+// lock 锁定记录并写入事件，锁定时长按此前的锁定次数翻倍
+func lock(tx *gorm.DB, record *Record, policy Policy, now time.Time, clientIP string) (*LockedError, error) {
+	until := now.Add(policy.lockDuration(record.Lockouts))
+	event := &Event{
+		Kind:        record.Kind,
+		Key:         record.Key,
+		Action:      ActionLocked,
+		Failures:    record.Failures,
+		LockedUntil: &until,
+		ClientIP:    clientIP,
+	}
+	if err := tx.Create(event).Error; err != nil {
+		return nil, fmt.Errorf("记录锁定事件失败: %w", err)
+	}
+
+	err := tx.Model(record).Updates(map[string]interface{}{
+		"failures":     0,
+		"lockouts":     record.Lockouts + 1,
+		"locked_until": until,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("保存失败记录失败: %w", err)
+	}
+	return &LockedError{Kind: record.Kind, Key: record.Key, Until: until}, nil
+}
+
+// Succeed 登录成功后清除账户的失败记录，并清零来源IP的失败次数
+func (t *Tracker) Succeed(username, clientIP string) error {
+	if err := t.db.Where("kind = ? AND key = ?", KindUser, username).Delete(&Record{}).Error; err != nil {
+		return fmt.Errorf("清除失败记录失败: %w", err)
+	}
+	if clientIP == "" {
+		return nil
+	}
+	if err := t.db.Model(&Record{}).Where("kind = ? AND key = ?", KindIP, clientIP).Update("failures", 0).Error; err != nil {
+		return fmt.Errorf("清除失败记录失败: %w", err)
+	}
+	return nil
+}
+
+// Unlock 手动解锁账户或IP，actor为操作人
+func (t *Tracker) Unlock(kind, key, actor string) error {
+	if kind != KindUser && kind != KindIP {
+		return fmt.Errorf("无效的锁定类型: %s", kind)
+	}
+
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("kind = ? AND key = ?", kind, key).Delete(&Record{})
+		if result.Error != nil {
+			return fmt.Errorf("解锁失败: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotLocked
+		}
+		event := &Event{Kind: kind, Key: key, Action: ActionUnlocked, Actor: actor}
+		if err := tx.Create(event).Error; err != nil {
+			return fmt.Errorf("记录解锁事件失败: %w", err)
+		}
+		return nil
+	})
+}
+
+// Locked 当前处于锁定状态的记录
+func (t *Tracker) Locked() ([]Record, error) {
+	var records []Record
+	if err := t.db.Where("locked_until > ?", t.now()).Order("locked_until DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("查询锁定记录失败: %w", err)
+	}
+	return records, nil
+}
+
+// Events 最近的锁定和解锁事件，按时间倒序
+func (t *Tracker) Events(limit int) ([]Event, error) {
+	var events []Event
+	if err := t.db.Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("查询锁定事件失败: %w", err)
+	}
+	return events, nil
+}
+
+// limit 对应类型的失败阈值
+func (p Policy) limit(kind string) int {
+	if kind == KindIP {
+		return p.IPMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+// lockDuration 第lockouts+1次锁定的时长
+func (p Policy) lockDuration(lockouts int) time.Duration {
+	d := p.Duration
+	if d <= 0 {
+		d = 15 * time.Minute
+	}
+	limit := p.MaxDuration
+	if limit <= 0 {
+		limit = 24 * time.Hour
+	}
+	for i := 0; i < lockouts && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+type target struct {
+	kind string
+	key  string
+}
+
+// targets 需要计数的对象，空值不计数
+func targets(username, clientIP string) []target {
+	var result []target
+	if username != "" {
+		result = append(result, target{KindUser, username})
+	}
+	if clientIP != "" {
+		result = append(result, target{KindIP, clientIP})
+	}
+	return result
+}
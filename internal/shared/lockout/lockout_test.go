@@ -0,0 +1,174 @@
+package lockout
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestTracker(t *testing.T, policy Policy) (*Tracker, *time.Time) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "lockout.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := db.AutoMigrate(Models()...); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(db, func() Policy { return policy })
+	tracker.now = func() time.Time { return now }
+	return tracker, &now
+}
+
+// fail 一次完整的失败登录：预占尝试后验证失败
+func fail(tracker *Tracker, username, ip string) error {
+	if err := tracker.Attempt(username, ip); err != nil {
+		return err
+	}
+	return tracker.Fail(username, ip)
+}
+
+func failUntilLocked(t *testing.T, tracker *Tracker, username, ip string, attempts int) *LockedError {
+	t.Helper()
+	for i := 1; i < attempts; i++ {
+		if err := fail(tracker, username, ip); err != nil {
+			t.Fatalf("第%d次失败不应锁定: %v", i, err)
+		}
+	}
+	var locked *LockedError
+	if err := fail(tracker, username, ip); !errors.As(err, &locked) {
+		t.Fatalf("第%d次失败应锁定, 得到 %v", attempts, err)
+	}
+	return locked
+}
+
+func TestExponentialLockout(t *testing.T) {
+	tracker, now := newTestTracker(t, DefaultPolicy(3, time.Minute))
+
+	locked := failUntilLocked(t, tracker, "alice", "10.0.0.1", 3)
+	if locked.Kind != KindUser || !locked.Until.Equal(now.Add(time.Minute)) {
+		t.Errorf("首次锁定 %s 至 %v, 期望账户锁定1分钟", locked.Kind, locked.Until)
+	}
+	var checkErr *LockedError
+	if err := tracker.Attempt("alice", "10.0.0.2"); !errors.As(err, &checkErr) {
+		t.Errorf("锁定期间换IP仍应拒绝, 得到 %v", err)
+	}
+	if err := tracker.Attempt("bob", "10.0.0.1"); err != nil {
+		t.Errorf("其他账户不应受影响: %v", err)
+	}
+
+	// 解锁后再次达到阈值，锁定时长翻倍
+	*now = now.Add(2 * time.Minute)
+	if err := fail(tracker, "alice", "10.0.0.1"); err != nil {
+		t.Errorf("锁定到期后应允许登录: %v", err)
+	}
+	locked = failUntilLocked(t, tracker, "alice", "10.0.0.1", 2)
+	if want := now.Add(2 * time.Minute); !locked.Until.Equal(want) {
+		t.Errorf("第二次锁定至 %v, 期望 %v", locked.Until, want)
+	}
+
+	// 长时间没有失败后翻倍次数清零
+	*now = now.Add(25 * time.Hour)
+	locked = failUntilLocked(t, tracker, "alice", "10.0.0.1", 3)
+	if want := now.Add(time.Minute); !locked.Until.Equal(want) {
+		t.Errorf("重置后锁定至 %v, 期望 %v", locked.Until, want)
+	}
+}
+
+func TestIPLockoutAndUnlock(t *testing.T) {
+	tracker, _ := newTestTracker(t, Policy{MaxAttempts: 100, IPMaxAttempts: 4, Duration: time.Minute})
+
+	// 同一IP尝试不同账户，由IP计数触发锁定
+	for _, username := range []string{"a", "b", "c"} {
+		if err := fail(tracker, username, "10.0.0.9"); err != nil {
+			t.Fatalf("不应锁定: %v", err)
+		}
+	}
+	var locked *LockedError
+	if err := fail(tracker, "d", "10.0.0.9"); !errors.As(err, &locked) || locked.Kind != KindIP {
+		t.Fatalf("应锁定IP, 得到 %v", err)
+	}
+
+	records, err := tracker.Locked()
+	if err != nil || len(records) != 1 || records[0].Key != "10.0.0.9" {
+		t.Fatalf("锁定列表 %+v, %v", records, err)
+	}
+
+	if err := tracker.Unlock(KindIP, "10.0.0.9", "admin"); err != nil {
+		t.Fatalf("解锁失败: %v", err)
+	}
+	if err := tracker.Unlock(KindIP, "10.0.0.9", "admin"); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("重复解锁应返回ErrNotLocked, 得到 %v", err)
+	}
+	if err := tracker.Attempt("e", "10.0.0.9"); err != nil {
+		t.Errorf("解锁后应允许登录: %v", err)
+	}
+
+	events, err := tracker.Events(10)
+	if err != nil || len(events) != 2 || events[0].Action != ActionUnlocked || events[0].Actor != "admin" || events[1].Action != ActionLocked {
+		t.Errorf("事件 %+v, %v", events, err)
+	}
+}
+
+func TestSucceedResetsFailures(t *testing.T) {
+	tracker, _ := newTestTracker(t, DefaultPolicy(3, time.Minute))
+
+	fail(tracker, "alice", "10.0.0.1")
+	fail(tracker, "alice", "10.0.0.1")
+	if err := tracker.Succeed("alice", "10.0.0.1"); err != nil {
+		t.Fatalf("清除失败记录失败: %v", err)
+	}
+	// 成功登录后重新计数
+	failUntilLocked(t, tracker, "alice", "10.0.0.1", 3)
+}
+
+func TestConcurrentAttempts(t *testing.T) {
+	tracker, _ := newTestTracker(t, Policy{MaxAttempts: 3, IPMaxAttempts: 100, Duration: time.Minute})
+
+	// 并发请求同时通过检查时，预占的尝试次数不超过阈值
+	const workers = 20
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- tracker.Attempt("alice", "10.0.0.1")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	allowed := 0
+	for err := range errs {
+		var locked *LockedError
+		switch {
+		case err == nil:
+			allowed++
+		case !errors.As(err, &locked) || locked.Kind != KindUser:
+			t.Errorf("预占尝试失败: %v", err)
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("并发放行 %d 次尝试, 期望 3", allowed)
+	}
+
+	// 并发的首次记录合并为同一行，不因唯一索引冲突失败
+	var ip Record
+	if err := tracker.db.Where("kind = ? AND key = ?", KindIP, "10.0.0.1").First(&ip).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ip.Failures != 4 {
+		t.Errorf("IP失败次数 %d, 期望 4（锁定账户前的尝试）", ip.Failures)
+	}
+}
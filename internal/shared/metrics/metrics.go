@@ -0,0 +1,248 @@
+// Package metrics 以Prometheus文本格式导出指标
+//
+// 指标在每次抓取时由采集函数实时生成，HTTP请求耗时等需要累积的指标使用HistogramVec。
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType Prometheus文本格式的Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 指标类型
+const (
+	TypeGauge     = "gauge"
+	TypeCounter   = "counter"
+	TypeHistogram = "histogram"
+)
+
+// Label 标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample 样本
+type Sample struct {
+	Suffix string // 样本名后缀，如直方图的_bucket、_sum、_count
+	Labels []Label
+	Value  float64
+}
+
+// Family 指标族，同名样本共用HELP和TYPE
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// NewGauge 创建gauge指标族
+func NewGauge(name, help string) *Family {
+	return &Family{Name: name, Help: help, Type: TypeGauge}
+}
+
+// NewCounter 创建counter指标族
+func NewCounter(name, help string) *Family {
+	return &Family{Name: name, Help: help, Type: TypeCounter}
+}
+
+// Add 添加样本，labels为成对的标签名和值
+func (f *Family) Add(value float64, labels ...string) *Family {
+	f.Samples = append(f.Samples, Sample{Labels: pairs(labels), Value: value})
+	return f
+}
+
+// pairs 将成对的标签名和值转换为标签列表
+func pairs(labels []string) []Label {
+	if len(labels)%2 != 0 {
+		panic("metrics: 标签名和值必须成对出现")
+	}
+	result := make([]Label, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		result = append(result, Label{Name: labels[i], Value: labels[i+1]})
+	}
+	return result
+}
+
+// Write 以文本格式输出指标族，没有样本的指标族也会输出HELP和TYPE
+func Write(w io.Writer, families []*Family) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			bw.WriteString(family.Name)
+			bw.WriteString(sample.Suffix)
+			if len(sample.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", label.Name, escapeLabel(label.Value))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(sample.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+// formatValue 格式化样本值
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// DefaultBuckets 默认的耗时直方图分桶（秒）
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramVec 按标签分组的直方图，可并发使用
+type HistogramVec struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64 // 各分桶的计数（非累积）
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec 创建直方图，buckets为升序的分桶上界
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		name:       name,
+		help:       help,
+		buckets:    buckets,
+		labelNames: labelNames,
+		series:     make(map[string]*histogram),
+	}
+}
+
+// Observe 记录一次观测值，labelValues与创建时的标签名一一对应
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labelNames) {
+		panic(fmt.Sprintf("metrics: %s 需要 %d 个标签值", h.name, len(h.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = series
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.count++
+	series.sum += value
+}
+
+// Family 生成当前直方图的指标族快照
+func (h *HistogramVec) Family() *Family {
+	family := &Family{Name: h.name, Help: h.help, Type: TypeHistogram}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+		labels := make([]Label, len(h.labelNames))
+		for i, name := range h.labelNames {
+			labels[i] = Label{Name: name, Value: series.labelValues[i]}
+		}
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			family.Samples = append(family.Samples, Sample{
+				Suffix: "_bucket",
+				Labels: append(append([]Label(nil), labels...), Label{Name: "le", Value: formatValue(bound)}),
+				Value:  float64(cumulative),
+			})
+		}
+		family.Samples = append(family.Samples,
+			Sample{Suffix: "_bucket", Labels: append(append([]Label(nil), labels...), Label{Name: "le", Value: "+Inf"}), Value: float64(series.count)},
+			Sample{Suffix: "_sum", Labels: labels, Value: series.sum},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(series.count)},
+		)
+	}
+
+	return family
+}
+
+// Handler 创建/metrics处理函数。token不为空时要求请求携带 Authorization: Bearer <token>
+func Handler(token string, collect func() []*Family) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" {
+			provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+				c.String(http.StatusUnauthorized, "unauthorized\n")
+				return
+			}
+		}
+
+		c.Header("Content-Type", ContentType)
+		c.Status(http.StatusOK)
+		if err := Write(c.Writer, collect()); err != nil {
+			c.Error(err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWrite(t *testing.T) {
+	gauge := NewGauge("test_peers", "对等端数量").
+		Add(2, "interface", "wg0").
+		Add(0.5, "interface", `a"b\c`)
+	empty := NewCounter("test_bytes_total", "多行\n说明")
+
+	var out strings.Builder
+	if err := Write(&out, []*Family{gauge, empty}); err != nil {
+		t.Fatalf("输出失败: %v", err)
+	}
+
+	want := `# HELP test_peers 对等端数量
+# TYPE test_peers gauge
+test_peers{interface="wg0"} 2
+test_peers{interface="a\"b\\c"} 0.5
+# HELP test_bytes_total 多行\n说明
+# TYPE test_bytes_total counter
+`
+	if out.String() != want {
+		t.Errorf("输出不一致:\n%s\n期望:\n%s", out.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "耗时", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(3, "/a")
+
+	var out strings.Builder
+	if err := Write(&out, []*Family{h.Family()}); err != nil {
+		t.Fatalf("输出失败: %v", err)
+	}
+
+	for _, line := range []string{
+		`test_duration_seconds_bucket{route="/a",le="0.1"} 2`,
+		`test_duration_seconds_bucket{route="/a",le="1"} 3`,
+		`test_duration_seconds_bucket{route="/a",le="+Inf"} 4`,
+		`test_duration_seconds_sum{route="/a"} 3.65`,
+		`test_duration_seconds_count{route="/a"} 4`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("缺少 %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", Handler("secret", func() []*Family {
+		return []*Family{NewGauge("test_up", "是否运行").Add(1)}
+	}))
+
+	for _, tc := range []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != tc.code {
+			t.Errorf("Authorization %q 返回 %d, 期望 %d", tc.header, recorder.Code, tc.code)
+		}
+		if tc.code == http.StatusOK && !strings.Contains(recorder.Body.String(), "test_up 1\n") {
+			t.Errorf("响应缺少指标: %s", recorder.Body.String())
+		}
+	}
+}
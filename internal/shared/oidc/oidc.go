@@ -0,0 +1,418 @@
+// Package oidc 实现OpenID Connect授权码流程的客户端部分：发现文档、PKCE（S256）、
+// 授权码换取令牌以及基于JWKS的ID令牌校验。只支持RS256/RS384/RS512/PS256/ES256/ES384签名。
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// clockSkew 校验ID令牌时间时允许的时钟误差
+	clockSkew = time.Minute
+	// keysRefreshInterval 遇到未知kid时重新获取JWKS的最小间隔，防止被用来放大请求
+	keysRefreshInterval = time.Minute
+	// maxResponseSize 身份提供方响应的大小上限
+	maxResponseSize = 1 << 20
+)
+
+// signingMethods 接受的ID令牌签名算法，不接受none和HMAC
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}
+
+// ErrInvalidIDToken ID令牌签名、签发方、受众、有效期或nonce校验失败
+var ErrInvalidIDToken = errors.New("ID令牌无效")
+
+// Config 客户端配置
+type Config struct {
+	Issuer       string   // 身份提供方地址，发现文档位于 {Issuer}/.well-known/openid-configuration
+	ClientID     string   // 客户端ID
+	ClientSecret string   // 客户端密钥，公共客户端为空，仅依靠PKCE
+	RedirectURL  string   // 回调地址，需在身份提供方登记
+	Scopes       []string // 申请的scope，总会包含openid
+}
+
+// Provider 发现文档中用到的字段
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Token 令牌端点的响应
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Claims ID令牌中的声明
+type Claims map[string]interface{}
+
+// String 获取字符串声明，不存在或类型不符时返回空
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings 获取字符串数组声明，单个字符串视为只有一个元素的数组
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var result []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Client OIDC客户端，发现文档和签名公钥在首次使用时获取并缓存
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	provider    *Provider
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// NewClient 创建OIDC客户端
+func NewClient(config Config) *Client {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GenerateVerifier 生成PKCE code_verifier
+func GenerateVerifier() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallenge 计算S256方式的code_challenge
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// GenerateState 生成state或nonce使用的随机串
+func GenerateState() (string, error) {
+	return randomString(24)
+}
+
+// randomString 生成n字节随机数的base64url编码
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// AuthCodeURL 生成跳转到身份提供方的授权地址
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range c.config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.config.ClientID)
+	params.Set("redirect_uri", c.config.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange 使用授权码和code_verifier换取令牌
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if c.config.ClientSecret == "" {
+		form.Set("client_id", c.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("创建令牌请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	var token Token
+	if err := c.do(req, &token); err != nil {
+		return nil, fmt.Errorf("授权码换取令牌失败: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("令牌响应中没有id_token")
+	}
+	return &token, nil
+}
+
+// VerifyIDToken 校验ID令牌的签名、签发方、受众、有效期和nonce，返回其中的声明
+func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(c.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	result := Claims(claims)
+	if result.String("nonce") != nonce {
+		return nil, fmt.Errorf("%w: nonce不匹配", ErrInvalidIDToken)
+	}
+	// 存在多个受众时，授权方必须是本客户端
+	if audiences := result.Strings("aud"); len(audiences) > 1 && result.String("azp") != c.config.ClientID {
+		return nil, fmt.Errorf("%w: azp不匹配", ErrInvalidIDToken)
+	}
+	if result.String("sub") == "" {
+		return nil, fmt.Errorf("%w: 缺少sub", ErrInvalidIDToken)
+	}
+	return result, nil
+}
+
+// Discover 获取并缓存发现文档，签发方必须与配置一致
+func (c *Client) Discover(ctx context.Context) (*Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建发现请求失败: %w", err)
+	}
+
+	var provider Provider
+	if err := c.do(req, &provider); err != nil {
+		return nil, fmt.Errorf("获取OIDC发现文档失败: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != c.config.Issuer {
+		return nil, fmt.Errorf("发现文档的签发方 %s 与配置的 %s 不一致", provider.Issuer, c.config.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("发现文档缺少授权、令牌或JWKS端点")
+	}
+
+	c.provider = &provider
+	return c.provider, nil
+}
+
+// publicKey 根据kid查找签名公钥，找不到时重新获取JWKS以支持身份提供方轮换密钥
+func (c *Client) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.lookupKey(kid); ok {
+		return key, nil
+	}
+	if !c.keysFetched.IsZero() && time.Since(c.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+	}
+
+	keys, err := c.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.keys = keys
+	c.keysFetched = time.Now()
+
+	if key, ok := c.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+}
+
+// lookupKey 查找公钥，令牌未指定kid且只有一个公钥时使用该公钥
+func (c *Client) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey JWKS中的单个公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys 获取JWKS，忽略不支持的密钥类型和加密用途的密钥
+func (c *Client) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.provider.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建JWKS请求失败: %w", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := c.do(req, &set); err != nil {
+		return nil, fmt.Errorf("获取JWKS失败: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// publicKey 把JWK转换为公钥
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("不支持的椭圆曲线: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("公钥不在曲线上")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// decodeBigInt 解码base64url编码的大整数
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("JWK字段格式错误")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// do 发送请求并解析JSON响应，非2xx时返回身份提供方的错误描述
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+			return fmt.Errorf("%s: %s", oauthErr.Error, oauthErr.Description)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"eitec-vpn/internal/shared/oidc/oidctest"
+)
+
+// authorize 按浏览器的方式访问授权地址，返回回调地址中的参数
+func authorize(t *testing.T, authURL string) url.Values {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("访问授权地址失败: %v", err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("解析回调地址失败: %v", err)
+	}
+	return location.Query()
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	for _, secret := range []string{"client-secret", ""} {
+		idp := oidctest.NewProvider("eitec-vpn", secret)
+		defer idp.Close()
+		idp.SetUser(map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "groups": []string{"vpn-admins"}})
+
+		client := NewClient(Config{
+			Issuer:       idp.Issuer() + "/",
+			ClientID:     "eitec-vpn",
+			ClientSecret: secret,
+			RedirectURL:  "https://vpn.example.com/callback",
+			Scopes:       []string{"openid", "profile"},
+		})
+
+		verifier, _ := GenerateVerifier()
+		authURL, err := client.AuthCodeURL(ctx, "state-1", "nonce-1", CodeChallenge(verifier))
+		if err != nil {
+			t.Fatalf("生成授权地址失败: %v", err)
+		}
+		params := authorize(t, authURL)
+		if params.Get("state") != "state-1" || params.Get("code") == "" {
+			t.Fatalf("回调参数 %v", params)
+		}
+
+		// 错误的code_verifier不能换取令牌
+		if _, err := client.Exchange(ctx, params.Get("code"), "wrong-verifier"); err == nil {
+			t.Fatal("错误的code_verifier换取令牌成功")
+		}
+
+		params = authorize(t, authURL)
+		token, err := client.Exchange(ctx, params.Get("code"), verifier)
+		if err != nil {
+			t.Fatalf("换取令牌失败: %v", err)
+		}
+		// 授权码只能使用一次
+		if _, err := client.Exchange(ctx, params.Get("code"), verifier); err == nil {
+			t.Error("授权码重复使用成功")
+		}
+
+		claims, err := client.VerifyIDToken(ctx, token.IDToken, "nonce-1")
+		if err != nil {
+			t.Fatalf("校验ID令牌失败: %v", err)
+		}
+		if claims.String("preferred_username") != "alice" || len(claims.Strings("groups")) != 1 || claims.Strings("groups")[0] != "vpn-admins" {
+			t.Errorf("声明 %v", claims)
+		}
+		if _, err := client.VerifyIDToken(ctx, token.IDToken, "nonce-2"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("nonce不匹配返回 %v", err)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	ctx := context.Background()
+	idp := oidctest.NewProvider("eitec-vpn", "")
+	defer idp.Close()
+	other := oidctest.NewProvider("eitec-vpn", "")
+	defer other.Close()
+
+	client := NewClient(Config{Issuer: idp.Issuer(), ClientID: "eitec-vpn"})
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   idp.Issuer(),
+			"aud":   "eitec-vpn",
+			"sub":   "u-1",
+			"exp":   now.Add(time.Minute).Unix(),
+			"nonce": "n",
+		}
+	}
+	if _, err := client.VerifyIDToken(ctx, idp.SignIDToken(valid()), "n"); err != nil {
+		t.Fatalf("有效令牌校验失败: %v", err)
+	}
+
+	for name, mutate := range map[string]func(map[string]interface{}){
+		"过期":    func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"签发方":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"受众":    func(c map[string]interface{}) { c["aud"] = "other-client" },
+		"azp":   func(c map[string]interface{}) { c["aud"] = []string{"eitec-vpn", "other-client"} },
+		"缺少sub": func(c map[string]interface{}) { delete(c, "sub") },
+	} {
+		claims := valid()
+		mutate(claims)
+		if _, err := client.VerifyIDToken(ctx, idp.SignIDToken(claims), "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: 返回 %v", name, err)
+		}
+	}
+
+	// 其他身份提供方签发的令牌
+	claims := valid()
+	if _, err := client.VerifyIDToken(ctx, other.SignIDToken(claims), "n"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("其他密钥签名的令牌返回 %v", err)
+	}
+}
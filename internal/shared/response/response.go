@@ -2,7 +2,10 @@ package response
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -114,6 +117,14 @@ func InternalError(c *gin.Context, message string) {
 	Error(c, http.StatusInternalServerError, message)
 }
 
+// TooManyRequests 429错误响应，retryAfter大于0时设置Retry-After头
+func TooManyRequests(c *gin.Context, message string, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	Error(c, http.StatusTooManyRequests, message)
+}
+
 // Paged Gin框架分页响应
 func Paged(c *gin.Context, data interface{}, total int64, page, size int) {
 	c.JSON(http.StatusOK, PageResponse{
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B的SHA1测试向量，取后6位
+func TestCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		code, err := Code(secret, Counter(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("计算验证码失败: %v", err)
+		}
+		if code != tc.code {
+			t.Errorf("T=%d 得到 %s, 期望 %s", tc.unix, code, tc.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	previous, _ := Code(secret, Counter(now)-1)
+
+	counter, ok := Validate(secret, previous, now, 0)
+	if !ok || counter != Counter(now)-1 {
+		t.Fatalf("上一步的验证码应在容差内通过")
+	}
+	if _, ok := Validate(secret, previous, now, counter); ok {
+		t.Error("已使用的验证码不应再次通过")
+	}
+
+	old, _ := Code(secret, Counter(now)-3)
+	if _, ok := Validate(secret, old, now, 0); ok {
+		t.Error("超出容差的验证码不应通过")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("恢复码格式错误或重复: %s", code)
+		}
+		seen[code] = true
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Error("恢复码哈希应忽略大小写和连字符")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("EITEC VPN", "admin", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/EITEC%20VPN:admin?") || !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=EITEC+VPN") {
+		t.Errorf("URI格式错误: %s", uri)
+	}
+}
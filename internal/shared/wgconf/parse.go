@@ -0,0 +1,207 @@
+package wgconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error 配置错误，Line为0表示错误不对应原文中的具体行
+type Error struct {
+	Line    int
+	Section string
+	Msg     string
+}
+
+// Error 实现error接口
+func (e *Error) Error() string {
+	msg := e.Msg
+	if e.Section != "" {
+		msg = e.Section + " " + msg
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("第%d行: %s", e.Line, msg)
+	}
+	return msg
+}
+
+// ErrorList 多个配置错误
+type ErrorList []*Error
+
+// Error 实现error接口
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// section 解析时记录的段布局
+type section struct {
+	header   string            // 原始段头
+	lines    []layoutLine      // 段头之后的原始行
+	line     int               // 段头所在行号
+	keyLines map[string]int    // 每个键第一次出现的行号
+	parsed   map[string]string // 解析完成时各键的输出值，用于判断是否被修改
+	comments []string          // 解析完成时的段首注释
+}
+
+// layoutLine 原始行
+type layoutLine struct {
+	text    string // 原始内容
+	key     string // 规范键名，注释和空行为空
+	comment bool   // 是否为段首注释
+}
+
+// Parse 解析wg-quick格式配置，返回所有带行号的语法错误
+func Parse(content string) (*Config, error) {
+	cfg := &Config{parsed: true}
+	if content == "" {
+		return cfg, nil
+	}
+
+	text := strings.ReplaceAll(content, "\r\n", "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		cfg.noFinalNewline = true
+	}
+	text = strings.TrimSuffix(text, "\n")
+
+	var errs ErrorList
+	var current *section
+	var setValue func(key, value string) error
+	var comments *[]string
+	var keys []string
+	sectionName := ""
+	headerOpen := false
+	skipping := false
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+		current.parsed = make(map[string]string)
+		if sectionName == "[Interface]" {
+			for _, key := range interfaceKeys {
+				current.parsed[key] = strings.Join(cfg.Interface.values(key), "\n")
+			}
+			current.comments = append([]string(nil), cfg.Interface.Comments...)
+		} else {
+			peer := &cfg.Peers[len(cfg.Peers)-1]
+			for _, key := range peerKeys {
+				current.parsed[key] = strings.Join(peer.values(key), "\n")
+			}
+			current.comments = append([]string(nil), peer.Comments...)
+		}
+	}
+
+	for i, raw := range strings.Split(text, "\n") {
+		lineNo := i + 1
+		stripped := raw
+		if idx := strings.Index(stripped, "#"); idx >= 0 {
+			stripped = stripped[:idx]
+		}
+		stripped = strings.TrimSpace(stripped)
+
+		if stripped == "" {
+			if current == nil {
+				cfg.preamble = append(cfg.preamble, raw)
+				continue
+			}
+			line := layoutLine{text: raw}
+			if headerOpen && strings.HasPrefix(strings.TrimSpace(raw), "#") {
+				line.comment = true
+				*comments = append(*comments, commentText(raw))
+			} else {
+				headerOpen = false
+			}
+			current.lines = append(current.lines, line)
+			continue
+		}
+
+		if strings.HasPrefix(stripped, "[") && strings.HasSuffix(stripped, "]") {
+			finish()
+			current = nil
+			skipping = false
+			name := strings.TrimSpace(strings.Trim(stripped, "[]"))
+			switch strings.ToLower(name) {
+			case "interface":
+				if cfg.hasInterface {
+					errs = append(errs, &Error{Line: lineNo, Msg: "重复的[Interface]段"})
+					skipping = true
+					continue
+				}
+				cfg.hasInterface = true
+				cfg.interfacePos = len(cfg.Peers)
+				current = &section{header: raw, line: lineNo, keyLines: make(map[string]int)}
+				cfg.Interface.layout = current
+				setValue = cfg.Interface.set
+				comments = &cfg.Interface.Comments
+				keys = interfaceKeys
+				sectionName = "[Interface]"
+			case "peer":
+				current = &section{header: raw, line: lineNo, keyLines: make(map[string]int)}
+				cfg.Peers = append(cfg.Peers, Peer{layout: current})
+				peer := &cfg.Peers[len(cfg.Peers)-1]
+				setValue = peer.set
+				comments = &peer.Comments
+				keys = peerKeys
+				sectionName = "[Peer]"
+			default:
+				errs = append(errs, &Error{Line: lineNo, Msg: fmt.Sprintf("未知的段 [%s]", name)})
+				skipping = true
+				continue
+			}
+			headerOpen = true
+			continue
+		}
+
+		if skipping {
+			continue
+		}
+		headerOpen = false
+
+		key, value, found := strings.Cut(stripped, "=")
+		if !found {
+			errs = append(errs, &Error{Line: lineNo, Msg: fmt.Sprintf("格式错误，应为 键 = 值: %s", strings.TrimSpace(raw))})
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if current == nil {
+			errs = append(errs, &Error{Line: lineNo, Msg: fmt.Sprintf("%s 不在任何段中", key)})
+			continue
+		}
+
+		canonical, ok := canonicalKey(keys, key)
+		if !ok {
+			errs = append(errs, &Error{Line: lineNo, Section: sectionName, Msg: fmt.Sprintf("未知的键 %s", key)})
+			continue
+		}
+		if _, seen := current.keyLines[canonical]; seen && keyKinds[canonical] == kindScalar {
+			errs = append(errs, &Error{Line: lineNo, Section: sectionName, Msg: fmt.Sprintf("重复的键 %s", canonical)})
+			continue
+		}
+		if err := setValue(canonical, value); err != nil {
+			errs = append(errs, &Error{Line: lineNo, Section: sectionName, Msg: err.Error()})
+			continue
+		}
+
+		if _, seen := current.keyLines[canonical]; !seen {
+			current.keyLines[canonical] = lineNo
+		}
+		current.lines = append(current.lines, layoutLine{text: raw, key: canonical})
+	}
+	finish()
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+// commentText 去掉注释行的#前缀
+func commentText(line string) string {
+	text := strings.TrimPrefix(strings.TrimSpace(line), "#")
+	return strings.TrimPrefix(text, " ")
+}
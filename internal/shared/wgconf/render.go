@@ -0,0 +1,153 @@
+package wgconf
+
+import (
+	"strings"
+)
+
+// String 生成配置文本。解析得到且未修改的部分原样输出
+func (c *Config) String() string {
+	var lines []string
+	lines = append(lines, c.preamble...)
+
+	interfacePos := 0
+	if c.hasInterface {
+		interfacePos = c.interfacePos
+		if interfacePos > len(c.Peers) {
+			interfacePos = len(c.Peers)
+		}
+	}
+	renderInterface := c.hasInterface || !c.Interface.isEmpty()
+
+	for i := 0; i <= len(c.Peers); i++ {
+		if i == interfacePos && renderInterface {
+			lines = renderSection(lines, "[Interface]", c.Interface.layout, c.Interface.Comments, interfaceKeys, c.Interface.values)
+		}
+		if i < len(c.Peers) {
+			peer := &c.Peers[i]
+			lines = renderSection(lines, "[Peer]", peer.layout, peer.Comments, peerKeys, peer.values)
+		}
+	}
+
+	text := strings.Join(lines, "\n")
+	if !c.noFinalNewline {
+		text += "\n"
+	}
+	return text
+}
+
+// renderSection 输出一个段。有原文布局时保留未修改的行，否则按规范顺序输出
+func renderSection(lines []string, header string, layout *section, comments []string, keys []string, values func(string) []string) []string {
+	if layout == nil {
+		// 新段与前文之间空一行
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, header)
+		lines = appendComments(lines, comments)
+		for _, key := range keys {
+			lines = appendKey(lines, key, values(key), "")
+		}
+		return lines
+	}
+
+	lines = append(lines, layout.header)
+
+	commentsChanged := !equalStrings(comments, layout.comments)
+	commentsDone := !commentsChanged
+	if commentsChanged && len(layout.comments) == 0 {
+		lines = appendComments(lines, comments)
+		commentsDone = true
+	}
+
+	// 新增的键追加在最后一个键之后
+	lastKey := -1
+	for i, line := range layout.lines {
+		if line.key != "" {
+			lastKey = i
+		}
+	}
+
+	emitted := make(map[string]bool)
+	appendNewKeys := func() {
+		for _, key := range keys {
+			if _, exists := layout.keyLines[key]; !exists {
+				lines = appendKey(lines, key, values(key), "")
+			}
+		}
+	}
+	if lastKey < 0 {
+		appendNewKeys()
+	}
+
+	for i, line := range layout.lines {
+		switch {
+		case line.comment:
+			if !commentsChanged {
+				lines = append(lines, line.text)
+			} else if !commentsDone {
+				lines = appendComments(lines, comments)
+				commentsDone = true
+			}
+		case line.key == "":
+			lines = append(lines, line.text)
+		default:
+			current := values(line.key)
+			if strings.Join(current, "\n") == layout.parsed[line.key] {
+				lines = append(lines, line.text)
+			} else if !emitted[line.key] {
+				lines = appendKey(lines, line.key, current, inlineComment(line.text))
+				emitted[line.key] = true
+			}
+		}
+		if i == lastKey {
+			appendNewKeys()
+		}
+	}
+
+	return lines
+}
+
+// appendKey 输出键的所有值，每个值一行
+func appendKey(lines []string, key string, values []string, comment string) []string {
+	for i, value := range values {
+		line := key + " = " + value
+		if i == 0 && comment != "" {
+			line += " " + comment
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// appendComments 输出段首注释
+func appendComments(lines []string, comments []string) []string {
+	for _, comment := range comments {
+		if comment == "" {
+			lines = append(lines, "#")
+		} else {
+			lines = append(lines, "# "+comment)
+		}
+	}
+	return lines
+}
+
+// inlineComment 提取行尾注释
+func inlineComment(line string) string {
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		return line[idx:]
+	}
+	return ""
+}
+
+// equalStrings 比较两个字符串切片
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package wgconf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Validate 校验配置内容，返回所有带行号的错误
+func (c *Config) Validate() error {
+	var errs ErrorList
+
+	if !c.hasInterface && c.Interface.isEmpty() {
+		errs = append(errs, &Error{Msg: "配置缺少[Interface]段"})
+	} else {
+		errs = append(errs, c.Interface.validate()...)
+	}
+
+	seen := make(map[string]int)
+	for i := range c.Peers {
+		peer := &c.Peers[i]
+		errs = append(errs, peer.validate(i)...)
+		if peer.PublicKey == "" {
+			continue
+		}
+		if first, exists := seen[peer.PublicKey]; exists {
+			errs = append(errs, peer.errorf(i, "PublicKey", "公钥与第%d个Peer重复", first+1))
+		} else {
+			seen[peer.PublicKey] = i
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate 校验[Interface]段
+func (i *Interface) validate() ErrorList {
+	var errs ErrorList
+
+	if i.PrivateKey == "" {
+		errs = append(errs, i.errorf("PrivateKey", "缺少PrivateKey"))
+	} else if !validKey(i.PrivateKey) {
+		errs = append(errs, i.errorf("PrivateKey", "PrivateKey不是有效的WireGuard密钥"))
+	}
+
+	for _, address := range i.Address {
+		if !validAddress(address) {
+			errs = append(errs, i.errorf("Address", "Address无效: %s", address))
+		}
+	}
+
+	if i.ListenPort < 0 || i.ListenPort > 65535 {
+		errs = append(errs, i.errorf("ListenPort", "ListenPort超出范围: %d", i.ListenPort))
+	}
+
+	if i.FwMark != "" && !strings.EqualFold(i.FwMark, "off") {
+		if _, err := strconv.ParseUint(i.FwMark, 0, 32); err != nil {
+			errs = append(errs, i.errorf("FwMark", "FwMark无效: %s", i.FwMark))
+		}
+	}
+
+	if i.MTU != 0 && (i.MTU < 576 || i.MTU > 65535) {
+		errs = append(errs, i.errorf("MTU", "MTU超出范围: %d", i.MTU))
+	}
+
+	return errs
+}
+
+// validate 校验[Peer]段，index为对等端序号
+func (p *Peer) validate(index int) ErrorList {
+	var errs ErrorList
+
+	if p.PublicKey == "" {
+		errs = append(errs, p.errorf(index, "PublicKey", "缺少PublicKey"))
+	} else if !validKey(p.PublicKey) {
+		errs = append(errs, p.errorf(index, "PublicKey", "PublicKey不是有效的WireGuard密钥"))
+	}
+
+	if p.PresharedKey != "" && !validKey(p.PresharedKey) {
+		errs = append(errs, p.errorf(index, "PresharedKey", "PresharedKey不是有效的WireGuard密钥"))
+	}
+
+	for _, allowedIP := range p.AllowedIPs {
+		if !validAddress(allowedIP) {
+			errs = append(errs, p.errorf(index, "AllowedIPs", "AllowedIPs无效: %s", allowedIP))
+		}
+	}
+
+	if p.Endpoint != "" {
+		host, port, err := net.SplitHostPort(p.Endpoint)
+		if err != nil || host == "" {
+			errs = append(errs, p.errorf(index, "Endpoint", "Endpoint应为 主机:端口 格式: %s", p.Endpoint))
+		} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			errs = append(errs, p.errorf(index, "Endpoint", "Endpoint端口无效: %s", p.Endpoint))
+		}
+	}
+
+	if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+		errs = append(errs, p.errorf(index, "PersistentKeepalive", "PersistentKeepalive超出范围: %d", p.PersistentKeepalive))
+	}
+
+	return errs
+}
+
+// errorf 生成[Interface]段错误，定位到键所在行
+func (i *Interface) errorf(key, format string, args ...interface{}) *Error {
+	return &Error{Line: keyLine(i.layout, key), Section: "[Interface]", Msg: fmt.Sprintf(format, args...)}
+}
+
+// errorf 生成[Peer]段错误，定位到键所在行
+func (p *Peer) errorf(index int, key, format string, args ...interface{}) *Error {
+	return &Error{Line: keyLine(p.layout, key), Section: fmt.Sprintf("[Peer #%d]", index+1), Msg: fmt.Sprintf(format, args...)}
+}
+
+// keyLine 键所在行号，键不在原文中时返回段头行号
+func keyLine(layout *section, key string) int {
+	if layout == nil {
+		return 0
+	}
+	if line, exists := layout.keyLines[key]; exists {
+		return line
+	}
+	return layout.line
+}
+
+// validKey 检查是否为base64编码的32字节密钥
+func validKey(key string) bool {
+	data, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(data) == 32
+}
+
+// validAddress 检查是否为IP地址或CIDR网段
+func validAddress(address string) bool {
+	if strings.Contains(address, "/") {
+		_, _, err := net.ParseCIDR(address)
+		return err == nil
+	}
+	return net.ParseIP(address) != nil
+}
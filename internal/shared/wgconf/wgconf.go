@@ -0,0 +1,294 @@
+// Package wgconf 解析和生成wg-quick格式的WireGuard配置文件
+//
+// 解析得到的配置会记录原文布局，未修改的行（包括注释、空行和原有写法）原样输出，
+// 修改过的键按规范格式重写，新增的键追加在所在段末尾，从而保证读改写不丢失内容。
+package wgconf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config wg-quick配置文件
+type Config struct {
+	Interface Interface
+	Peers     []Peer
+
+	preamble       []string // 第一个段之前的注释和空行
+	interfacePos   int      // [Interface]之前的[Peer]数量
+	hasInterface   bool     // 原文中是否包含[Interface]
+	parsed         bool     // 是否由Parse生成
+	noFinalNewline bool     // 原文末尾没有换行
+}
+
+// Interface [Interface]段
+type Interface struct {
+	Comments   []string // 紧跟段头的注释，不含#
+	PrivateKey string
+	Address    []string
+	ListenPort int
+	FwMark     string // 保留原始写法，如 0xca6c、51820 或 off
+	DNS        []string
+	MTU        int
+	Table      string
+	PreUp      []string
+	PostUp     []string
+	PreDown    []string
+	PostDown   []string
+	SaveConfig bool
+
+	layout *section
+}
+
+// Peer [Peer]段
+type Peer struct {
+	Comments            []string // 紧跟段头的注释，不含#
+	PublicKey           string
+	PresharedKey        string
+	AllowedIPs          []string
+	Endpoint            string
+	PersistentKeepalive int
+
+	layout *section
+}
+
+// 键的取值形式
+const (
+	kindScalar = iota // 单值，不允许重复
+	kindList          // 逗号分隔的列表，可出现多行
+	kindMulti         // 每行一个条目，如PostUp
+)
+
+// interfaceKeys [Interface]段的键，顺序即规范输出顺序
+var interfaceKeys = []string{
+	"PrivateKey", "Address", "ListenPort", "FwMark", "DNS", "MTU", "Table",
+	"PreUp", "PostUp", "PreDown", "PostDown", "SaveConfig",
+}
+
+// peerKeys [Peer]段的键，顺序即规范输出顺序
+var peerKeys = []string{
+	"PublicKey", "PresharedKey", "AllowedIPs", "Endpoint", "PersistentKeepalive",
+}
+
+// keyKinds 键的取值形式
+var keyKinds = map[string]int{
+	"Address":    kindList,
+	"DNS":        kindList,
+	"AllowedIPs": kindList,
+	"PreUp":      kindMulti,
+	"PostUp":     kindMulti,
+	"PreDown":    kindMulti,
+	"PostDown":   kindMulti,
+}
+
+// canonicalKey 将大小写不敏感的键名转换为规范写法
+func canonicalKey(keys []string, key string) (string, bool) {
+	for _, k := range keys {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// set 为[Interface]段的键赋值
+func (i *Interface) set(key, value string) error {
+	switch key {
+	case "PrivateKey":
+		i.PrivateKey = value
+	case "Address":
+		i.Address = append(i.Address, SplitList(value)...)
+	case "ListenPort":
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("ListenPort无效: %s", value)
+		}
+		i.ListenPort = port
+	case "FwMark":
+		i.FwMark = value
+	case "DNS":
+		i.DNS = append(i.DNS, SplitList(value)...)
+	case "MTU":
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("MTU无效: %s", value)
+		}
+		i.MTU = mtu
+	case "Table":
+		i.Table = value
+	case "PreUp":
+		i.PreUp = append(i.PreUp, value)
+	case "PostUp":
+		i.PostUp = append(i.PostUp, value)
+	case "PreDown":
+		i.PreDown = append(i.PreDown, value)
+	case "PostDown":
+		i.PostDown = append(i.PostDown, value)
+	case "SaveConfig":
+		save, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("SaveConfig无效: %s", value)
+		}
+		i.SaveConfig = save
+	}
+	return nil
+}
+
+// values 获取[Interface]段键的输出值，每个元素对应一行
+func (i *Interface) values(key string) []string {
+	switch key {
+	case "PrivateKey":
+		return scalar(i.PrivateKey)
+	case "Address":
+		return list(i.Address)
+	case "ListenPort":
+		return number(i.ListenPort)
+	case "FwMark":
+		return scalar(i.FwMark)
+	case "DNS":
+		return list(i.DNS)
+	case "MTU":
+		return number(i.MTU)
+	case "Table":
+		return scalar(i.Table)
+	case "PreUp":
+		return i.PreUp
+	case "PostUp":
+		return i.PostUp
+	case "PreDown":
+		return i.PreDown
+	case "PostDown":
+		return i.PostDown
+	case "SaveConfig":
+		if i.SaveConfig {
+			return []string{"true"}
+		}
+	}
+	return nil
+}
+
+// set 为[Peer]段的键赋值
+func (p *Peer) set(key, value string) error {
+	switch key {
+	case "PublicKey":
+		p.PublicKey = value
+	case "PresharedKey":
+		p.PresharedKey = value
+	case "AllowedIPs":
+		p.AllowedIPs = append(p.AllowedIPs, SplitList(value)...)
+	case "Endpoint":
+		p.Endpoint = value
+	case "PersistentKeepalive":
+		if strings.EqualFold(value, "off") {
+			p.PersistentKeepalive = 0
+			return nil
+		}
+		keepalive, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("PersistentKeepalive无效: %s", value)
+		}
+		p.PersistentKeepalive = keepalive
+	}
+	return nil
+}
+
+// values 获取[Peer]段键的输出值，每个元素对应一行
+func (p *Peer) values(key string) []string {
+	switch key {
+	case "PublicKey":
+		return scalar(p.PublicKey)
+	case "PresharedKey":
+		return scalar(p.PresharedKey)
+	case "AllowedIPs":
+		return list(p.AllowedIPs)
+	case "Endpoint":
+		return scalar(p.Endpoint)
+	case "PersistentKeepalive":
+		return number(p.PersistentKeepalive)
+	}
+	return nil
+}
+
+// isEmpty 段中没有任何键和注释
+func (i *Interface) isEmpty() bool {
+	if len(i.Comments) > 0 {
+		return false
+	}
+	for _, key := range interfaceKeys {
+		if len(i.values(key)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Line 段头所在行号，不是由Parse得到时为0
+func (i *Interface) Line() int {
+	return keyLine(i.layout, "")
+}
+
+// Line 段头所在行号，不是由Parse得到时为0
+func (p *Peer) Line() int {
+	return keyLine(p.layout, "")
+}
+
+// ParseFile 读取并解析配置文件
+func ParseFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	return Parse(string(content))
+}
+
+// WriteFile 将配置写入文件，权限为0600
+func (c *Config) WriteFile(path string) error {
+	if err := os.WriteFile(path, []byte(c.String()), 0600); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// FindPeer 按公钥查找对等端
+func (c *Config) FindPeer(publicKey string) *Peer {
+	for i := range c.Peers {
+		if c.Peers[i].PublicKey == publicKey {
+			return &c.Peers[i]
+		}
+	}
+	return nil
+}
+
+// SplitList 拆分逗号分隔的列表，如 AllowedIPs 和 DNS
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func scalar(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+func list(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return []string{strings.Join(values, ", ")}
+}
+
+func number(value int) []string {
+	if value == 0 {
+		return nil
+	}
+	return []string{strconv.Itoa(value)}
+}
@@ -0,0 +1,251 @@
+package wgconf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testPrivateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testPublicKey  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	testPeerKey    = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+)
+
+const sampleConfig = `# 由管理平台生成
+# 请勿手动修改
+
+[Interface]
+# 主接口
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.10.0.1/24, fd00::1/64
+address=10.10.1.1/24
+ListenPort = 51820
+FwMark = 0xca6c
+Table = off
+MTU = 1380
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
+PostDown = iptables -D FORWARD -i %i -j ACCEPT   # 清理
+
+# 北京模块
+[Peer]
+# beijing - 机房A
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.10.0.2/32,192.168.50.0/24
+Endpoint = 203.0.113.1:51820
+PersistentKeepalive = off
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.10.0.3/32`
+
+func TestParseRoundTrip(t *testing.T) {
+	cfg, err := Parse(sampleConfig)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+
+	if got := cfg.String(); got != sampleConfig {
+		t.Errorf("未修改的配置输出不一致:\n%s", got)
+	}
+
+	if cfg.Interface.PrivateKey != testPrivateKey {
+		t.Errorf("PrivateKey = %s", cfg.Interface.PrivateKey)
+	}
+	if want := []string{"10.10.0.1/24", "fd00::1/64", "10.10.1.1/24"}; !equalStrings(cfg.Interface.Address, want) {
+		t.Errorf("Address = %v, 期望 %v", cfg.Interface.Address, want)
+	}
+	if cfg.Interface.ListenPort != 51820 || cfg.Interface.MTU != 1380 || cfg.Interface.FwMark != "0xca6c" || cfg.Interface.Table != "off" {
+		t.Errorf("Interface字段解析错误: %+v", cfg.Interface)
+	}
+	if len(cfg.Interface.PostUp) != 2 || len(cfg.Interface.PostDown) != 1 {
+		t.Errorf("PostUp = %v, PostDown = %v", cfg.Interface.PostUp, cfg.Interface.PostDown)
+	}
+	if !equalStrings(cfg.Interface.Comments, []string{"主接口"}) {
+		t.Errorf("Interface注释 = %v", cfg.Interface.Comments)
+	}
+
+	if len(cfg.Peers) != 2 {
+		t.Fatalf("Peer数量 = %d", len(cfg.Peers))
+	}
+	peer := cfg.Peers[0]
+	if !equalStrings(peer.Comments, []string{"beijing - 机房A"}) {
+		t.Errorf("Peer注释 = %v", peer.Comments)
+	}
+	if !equalStrings(peer.AllowedIPs, []string{"10.10.0.2/32", "192.168.50.0/24"}) || peer.Endpoint != "203.0.113.1:51820" || peer.PersistentKeepalive != 0 {
+		t.Errorf("Peer字段解析错误: %+v", peer)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("校验失败: %v", err)
+	}
+}
+
+func TestEditPreservesLayout(t *testing.T) {
+	cfg, err := Parse(sampleConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Interface.ListenPort = 51821
+	cfg.Interface.DNS = []string{"1.1.1.1"}
+	cfg.Interface.PostUp = cfg.Interface.PostUp[:1]
+	cfg.Peers[0].PersistentKeepalive = 25
+	cfg.Peers = cfg.Peers[:1]
+	cfg.Peers = append(cfg.Peers, Peer{
+		Comments:   []string{"新用户"},
+		PublicKey:  testPeerKey,
+		AllowedIPs: []string{"10.10.0.4/32"},
+	})
+
+	want := `# 由管理平台生成
+# 请勿手动修改
+
+[Interface]
+# 主接口
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.10.0.1/24, fd00::1/64
+address=10.10.1.1/24
+ListenPort = 51821
+FwMark = 0xca6c
+Table = off
+MTU = 1380
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostDown = iptables -D FORWARD -i %i -j ACCEPT   # 清理
+DNS = 1.1.1.1
+
+# 北京模块
+[Peer]
+# beijing - 机房A
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.10.0.2/32,192.168.50.0/24
+Endpoint = 203.0.113.1:51820
+PersistentKeepalive = 25
+
+[Peer]
+# 新用户
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.10.0.4/32`
+
+	if got := cfg.String(); got != want {
+		t.Errorf("修改后的输出:\n%s\n期望:\n%s", got, want)
+	}
+
+	reparsed, err := Parse(cfg.String())
+	if err != nil {
+		t.Fatalf("重新解析失败: %v", err)
+	}
+	if reparsed.Interface.ListenPort != 51821 || len(reparsed.Peers) != 2 || reparsed.Peers[1].PublicKey != testPeerKey {
+		t.Errorf("重新解析结果不一致: %+v", reparsed)
+	}
+}
+
+func TestRenderNewConfig(t *testing.T) {
+	cfg := &Config{
+		Interface: Interface{
+			PrivateKey: testPrivateKey,
+			Address:    []string{"10.10.0.2/32"},
+			PostUp:     []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+		},
+		Peers: []Peer{{
+			PublicKey:           testPublicKey,
+			AllowedIPs:          []string{"10.10.0.0/24", "192.168.50.0/24"},
+			Endpoint:            "vpn.example.com:51820",
+			PersistentKeepalive: 25,
+		}},
+	}
+
+	want := `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.10.0.2/32
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.10.0.0/24, 192.168.50.0/24
+Endpoint = vpn.example.com:51820
+PersistentKeepalive = 25
+`
+	if got := cfg.String(); got != want {
+		t.Errorf("输出:\n%s\n期望:\n%s", got, want)
+	}
+
+	reparsed, err := Parse(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reparsed.String(); got != want {
+		t.Errorf("重新输出不一致:\n%s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	content := `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+ListenPort = abc
+Bogus = 1
+
+[Peer]
+PublicKey
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+[Other]
+`
+	_, err := Parse(content)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("期望ErrorList, 得到 %v", err)
+	}
+
+	wantLines := []int{3, 4, 7, 9, 10}
+	if len(errs) != len(wantLines) {
+		t.Fatalf("错误数量 = %d: %v", len(errs), err)
+	}
+	for i, line := range wantLines {
+		if errs[i].Line != line {
+			t.Errorf("第%d个错误行号 = %d, 期望 %d: %v", i+1, errs[i].Line, line, errs[i])
+		}
+	}
+	if !strings.HasPrefix(errs[0].Error(), "第3行") {
+		t.Errorf("错误信息缺少行号: %s", errs[0])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	content := `[Interface]
+PrivateKey = invalid
+Address = 10.10.0.1/33
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = 203.0.113.1
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.10.0.3/32
+`
+	cfg, err := Parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs ErrorList
+	if !errors.As(cfg.Validate(), &errs) {
+		t.Fatal("期望校验失败")
+	}
+
+	wantLines := []int{2, 3, 7, 10}
+	if len(errs) != len(wantLines) {
+		t.Fatalf("错误数量 = %d: %v", len(errs), errs)
+	}
+	for i, line := range wantLines {
+		if errs[i].Line != line {
+			t.Errorf("第%d个错误行号 = %d, 期望 %d: %v", i+1, errs[i].Line, line, errs[i])
+		}
+	}
+
+	if err := (&Config{}).Validate(); err == nil {
+		t.Error("空配置应当校验失败")
+	}
+}
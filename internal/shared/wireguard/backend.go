@@ -0,0 +1,138 @@
+package wireguard
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDeviceNotFound WireGuard接口不存在
+var ErrDeviceNotFound = errors.New("WireGuard接口不存在")
+
+// MainRouteTable 主路由表，未指定Table时路由添加到主路由表
+const MainRouteTable = 254
+
+// Device WireGuard接口运行状态
+type Device struct {
+	Name         string `json:"name"`
+	PrivateKey   string `json:"-"`
+	PublicKey    string `json:"public_key"`
+	ListenPort   int    `json:"listen_port"`
+	FirewallMark int    `json:"firewall_mark"`
+	Peers        []Peer `json:"peers"`
+}
+
+// Peer WireGuard对等端运行状态
+type Peer struct {
+	PublicKey           string    `json:"public_key"`
+	PresharedKey        string    `json:"-"`
+	Endpoint            string    `json:"endpoint"`
+	AllowedIPs          []string  `json:"allowed_ips"`
+	PersistentKeepalive int       `json:"persistent_keepalive"`
+	LatestHandshake     time.Time `json:"latest_handshake"`
+	ReceiveBytes        uint64    `json:"receive_bytes"`
+	TransmitBytes       uint64    `json:"transmit_bytes"`
+}
+
+// PeerConfig 对等端配置变更，指针字段为nil表示保持不变
+type PeerConfig struct {
+	PublicKey           string
+	Remove              bool    // 删除该对等端
+	UpdateOnly          bool    // 仅当对等端已存在时才更新
+	PresharedKey        *string // 空字符串表示清除预共享密钥
+	Endpoint            string
+	PersistentKeepalive *int
+	ReplaceAllowedIPs   bool // 用AllowedIPs替换现有列表，否则追加
+	AllowedIPs          []string
+}
+
+// DeviceConfig 接口配置变更，指针字段为nil表示保持不变
+type DeviceConfig struct {
+	PrivateKey   *string
+	ListenPort   *int
+	FirewallMark *int
+	ReplacePeers bool // 用Peers替换全部现有对等端
+	Peers        []PeerConfig
+}
+
+// WireGuardBackend WireGuard接口控制后端
+type WireGuardBackend interface {
+	// CreateDevice 创建WireGuard网络接口
+	CreateDevice(name string) error
+	// DeleteDevice 删除网络接口
+	DeleteDevice(name string) error
+	// DeviceExists 检查网络接口是否存在
+	DeviceExists(name string) bool
+	// SetLinkUp 设置MTU（0表示不修改）并启用接口
+	SetLinkUp(name string, mtu int) error
+	// AddAddress 为接口添加地址，如 10.10.0.1/24
+	AddAddress(name, cidr string) error
+	// AddRoute 在指定路由表中添加经由接口的路由
+	AddRoute(name, cidr string, table int) error
+	// DeleteRoute 删除指定路由表中经由接口的路由
+	DeleteRoute(name, cidr string, table int) error
+	// ConfigureDevice 增量配置接口和对等端
+	ConfigureDevice(name string, cfg *DeviceConfig) error
+	// GetDevice 读取接口及对等端状态
+	GetDevice(name string) (*Device, error)
+	// ListDevices 读取所有WireGuard接口状态
+	ListDevices() ([]*Device, error)
+}
+
+// HookRunner 可选接口，后端实现后由其代为执行wg-quick的Pre/Post钩子命令
+type HookRunner interface {
+	RunHook(interfaceName, command string) error
+}
+
+var (
+	backendMu      sync.RWMutex
+	defaultBackend WireGuardBackend
+)
+
+// SetBackend 设置全局WireGuard后端
+func SetBackend(backend WireGuardBackend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	defaultBackend = backend
+}
+
+// GetBackend 获取全局WireGuard后端，未设置时使用内核netlink实现
+func GetBackend() WireGuardBackend {
+	backendMu.RLock()
+	backend := defaultBackend
+	backendMu.RUnlock()
+	if backend != nil {
+		return backend
+	}
+
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if defaultBackend == nil {
+		defaultBackend = NewNetlinkBackend()
+	}
+	return defaultBackend
+}
+
+// decodeKey 解码base64格式的WireGuard密钥
+func decodeKey(key string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(data) != 32 {
+		return nil, fmt.Errorf("无效的WireGuard密钥: %s", key)
+	}
+	return data, nil
+}
+
+// encodeKey 编码WireGuard密钥，全零密钥视为未设置
+func encodeKey(data []byte) string {
+	if len(data) != 32 {
+		return ""
+	}
+	for _, b := range data {
+		if b != 0 {
+			return base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	return ""
+}
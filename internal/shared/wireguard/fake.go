@@ -0,0 +1,419 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeBackend 内存中的模拟WireGuard后端，用于测试和无root权限的开发环境
+type FakeBackend struct {
+	mu        sync.Mutex
+	devices   map[string]*fakeDevice
+	hooks     []string
+	failHooks map[string]bool
+}
+
+// fakeDevice 模拟接口状态
+type fakeDevice struct {
+	device    Device
+	addresses []string
+	routes    []string
+	mtu       int
+	up        bool
+}
+
+// NewFakeBackend 创建模拟后端
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		devices: make(map[string]*fakeDevice),
+	}
+}
+
+// CreateDevice 创建WireGuard网络接口
+func (fb *FakeBackend) CreateDevice(name string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if _, exists := fb.devices[name]; exists {
+		return fmt.Errorf("创建接口 %s 失败: 接口已存在", name)
+	}
+	fb.devices[name] = &fakeDevice{device: Device{Name: name}}
+	return nil
+}
+
+// DeleteDevice 删除网络接口
+func (fb *FakeBackend) DeleteDevice(name string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if _, exists := fb.devices[name]; !exists {
+		return ErrDeviceNotFound
+	}
+	delete(fb.devices, name)
+	return nil
+}
+
+// DeviceExists 检查网络接口是否存在
+func (fb *FakeBackend) DeviceExists(name string) bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	_, exists := fb.devices[name]
+	return exists
+}
+
+// SetLinkUp 设置MTU（0表示不修改）并启用接口
+func (fb *FakeBackend) SetLinkUp(name string, mtu int) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	dev, exists := fb.devices[name]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	if mtu > 0 {
+		dev.mtu = mtu
+	}
+	dev.up = true
+	return nil
+}
+
+// AddAddress 为接口添加地址
+func (fb *FakeBackend) AddAddress(name, cidr string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	dev, exists := fb.devices[name]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("无效的地址 %s: %w", cidr, err)
+	}
+	for _, address := range dev.addresses {
+		if address == cidr {
+			return fmt.Errorf("地址 %s 已存在", cidr)
+		}
+	}
+	dev.addresses = append(dev.addresses, cidr)
+	return nil
+}
+
+// AddRoute 在指定路由表中添加经由接口的路由，路由已存在时不报错
+func (fb *FakeBackend) AddRoute(name, cidr string, table int) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	dev, exists := fb.devices[name]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	route, err := fakeRoute(cidr, table)
+	if err != nil {
+		return err
+	}
+	for _, existing := range dev.routes {
+		if existing == route {
+			return nil
+		}
+	}
+	dev.routes = append(dev.routes, route)
+	return nil
+}
+
+// DeleteRoute 删除指定路由表中经由接口的路由，路由不存在时不报错
+func (fb *FakeBackend) DeleteRoute(name, cidr string, table int) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	dev, exists := fb.devices[name]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	route, err := fakeRoute(cidr, table)
+	if err != nil {
+		return err
+	}
+	for i, existing := range dev.routes {
+		if existing == route {
+			dev.routes = append(dev.routes[:i], dev.routes[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// fakeRoute 路由的记录形式，主路由表只记录网段，其他路由表附加 table <编号>
+func fakeRoute(cidr string, table int) (string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("无效的路由 %s: %w", cidr, err)
+	}
+	if table == MainRouteTable {
+		return ipNet.String(), nil
+	}
+	return fmt.Sprintf("%s table %d", ipNet, table), nil
+}
+
+// ConfigureDevice 增量配置接口和对等端，语义与内核一致
+func (fb *FakeBackend) ConfigureDevice(name string, cfg *DeviceConfig) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	dev, exists := fb.devices[name]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	// 先完整校验，保证失败时不留下部分修改
+	if cfg.PrivateKey != nil && *cfg.PrivateKey != "" {
+		if _, err := decodeKey(*cfg.PrivateKey); err != nil {
+			return err
+		}
+	}
+	for _, peer := range cfg.Peers {
+		if _, err := decodeKey(peer.PublicKey); err != nil {
+			return err
+		}
+		if peer.PresharedKey != nil && *peer.PresharedKey != "" {
+			if _, err := decodeKey(*peer.PresharedKey); err != nil {
+				return err
+			}
+		}
+		for _, cidr := range peer.AllowedIPs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("无效的AllowedIPs %s: %w", cidr, err)
+			}
+		}
+	}
+
+	if cfg.PrivateKey != nil {
+		dev.device.PrivateKey = *cfg.PrivateKey
+		dev.device.PublicKey = ""
+		if *cfg.PrivateKey != "" {
+			dev.device.PublicKey, _ = PublicKeyFromPrivate(*cfg.PrivateKey)
+		}
+	}
+	if cfg.ListenPort != nil {
+		dev.device.ListenPort = *cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil {
+		dev.device.FirewallMark = *cfg.FirewallMark
+	}
+	if cfg.ReplacePeers {
+		dev.device.Peers = nil
+	}
+
+	for _, change := range cfg.Peers {
+		index := dev.peerIndex(change.PublicKey)
+
+		if change.Remove {
+			if index >= 0 {
+				dev.device.Peers = append(dev.device.Peers[:index], dev.device.Peers[index+1:]...)
+			}
+			continue
+		}
+
+		if index < 0 {
+			if change.UpdateOnly {
+				continue
+			}
+			dev.device.Peers = append(dev.device.Peers, Peer{PublicKey: change.PublicKey})
+			index = len(dev.device.Peers) - 1
+		}
+
+		peer := &dev.device.Peers[index]
+		if change.PresharedKey != nil {
+			peer.PresharedKey = *change.PresharedKey
+		}
+		if change.Endpoint != "" {
+			peer.Endpoint = resolveEndpoint(change.Endpoint)
+		}
+		if change.PersistentKeepalive != nil {
+			peer.PersistentKeepalive = *change.PersistentKeepalive
+		}
+		if change.ReplaceAllowedIPs {
+			peer.AllowedIPs = nil
+		}
+		for _, cidr := range change.AllowedIPs {
+			_, ipNet, _ := net.ParseCIDR(cidr)
+			dev.assignAllowedIP(index, ipNet.String())
+		}
+	}
+
+	return nil
+}
+
+// peerIndex 查找对等端下标，不存在时返回-1
+func (dev *fakeDevice) peerIndex(publicKey string) int {
+	for i := range dev.device.Peers {
+		if dev.device.Peers[i].PublicKey == publicKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// assignAllowedIP 将网段分配给对等端，与内核一样同一网段只属于一个对等端
+func (dev *fakeDevice) assignAllowedIP(index int, cidr string) {
+	for i := range dev.device.Peers {
+		peer := &dev.device.Peers[i]
+		for j, existing := range peer.AllowedIPs {
+			if existing != cidr {
+				continue
+			}
+			if i == index {
+				return
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs[:j], peer.AllowedIPs[j+1:]...)
+			break
+		}
+	}
+	dev.device.Peers[index].AllowedIPs = append(dev.device.Peers[index].AllowedIPs, cidr)
+}
+
+// GetDevice 读取接口及对等端状态
+func (fb *FakeBackend) GetDevice(name string) (*Device, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	dev, exists := fb.devices[name]
+	if !exists {
+		return nil, ErrDeviceNotFound
+	}
+	return dev.snapshot(), nil
+}
+
+// ListDevices 读取所有WireGuard接口状态
+func (fb *FakeBackend) ListDevices() ([]*Device, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	devices := make([]*Device, 0, len(fb.devices))
+	for _, dev := range fb.devices {
+		devices = append(devices, dev.snapshot())
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+// snapshot 复制接口状态，避免调用方修改内部数据
+func (dev *fakeDevice) snapshot() *Device {
+	device := dev.device
+	device.Peers = make([]Peer, len(dev.device.Peers))
+	for i, peer := range dev.device.Peers {
+		peer.AllowedIPs = append([]string(nil), peer.AllowedIPs...)
+		device.Peers[i] = peer
+	}
+	return &device
+}
+
+// RunHook 记录Pre/Post钩子命令而不实际执行，通过FailHook指定的命令返回错误
+func (fb *FakeBackend) RunHook(interfaceName, command string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	fb.hooks = append(fb.hooks, command)
+	if fb.failHooks[command] {
+		return fmt.Errorf("执行命令失败: %s", command)
+	}
+	return nil
+}
+
+// FailHook 使指定的钩子命令执行失败
+func (fb *FakeBackend) FailHook(command string) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if fb.failHooks == nil {
+		fb.failHooks = make(map[string]bool)
+	}
+	fb.failHooks[command] = true
+}
+
+// Hooks 获取已记录的钩子命令
+func (fb *FakeBackend) Hooks() []string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	return append([]string(nil), fb.hooks...)
+}
+
+// SimulateHandshake 模拟对等端完成握手，endpoint非空时模拟对端漫游到新地址
+func (fb *FakeBackend) SimulateHandshake(name, publicKey, endpoint string, at time.Time) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	peer, err := fb.peer(name, publicKey)
+	if err != nil {
+		return err
+	}
+	peer.LatestHandshake = at
+	if endpoint != "" {
+		peer.Endpoint = resolveEndpoint(endpoint)
+	}
+	return nil
+}
+
+// AddTraffic 累加对等端的收发字节数
+func (fb *FakeBackend) AddTraffic(name, publicKey string, receiveBytes, transmitBytes uint64) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	peer, err := fb.peer(name, publicKey)
+	if err != nil {
+		return err
+	}
+	peer.ReceiveBytes += receiveBytes
+	peer.TransmitBytes += transmitBytes
+	return nil
+}
+
+// peer 查找对等端，调用方需持有锁
+func (fb *FakeBackend) peer(name, publicKey string) (*Peer, error) {
+	dev, exists := fb.devices[name]
+	if !exists {
+		return nil, ErrDeviceNotFound
+	}
+	index := dev.peerIndex(publicKey)
+	if index < 0 {
+		return nil, fmt.Errorf("接口 %s 不存在对等端 %s", name, publicKey)
+	}
+	return &dev.device.Peers[index], nil
+}
+
+// Addresses 获取接口地址
+func (fb *FakeBackend) Addresses(name string) []string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if dev, exists := fb.devices[name]; exists {
+		return append([]string(nil), dev.addresses...)
+	}
+	return nil
+}
+
+// Routes 获取经由接口的路由，主路由表以外的路由带有 table <编号> 后缀
+func (fb *FakeBackend) Routes(name string) []string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if dev, exists := fb.devices[name]; exists {
+		return append([]string(nil), dev.routes...)
+	}
+	return nil
+}
+
+// LinkState 获取接口是否启用及MTU
+func (fb *FakeBackend) LinkState(name string) (up bool, mtu int) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if dev, exists := fb.devices[name]; exists {
+		return dev.up, dev.mtu
+	}
+	return false, 0
+}
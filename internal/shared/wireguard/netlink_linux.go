@@ -0,0 +1,856 @@
+//go:build linux
+
+package wireguard
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlink消息相关常量
+const (
+	wgGenlName    = "wireguard"
+	wgGenlVersion = 1
+
+	// 单条SET_DEVICE消息的大小上限，超出时拆分为多条消息
+	maxSetDeviceMessageSize = 16 * 1024
+	// 单条消息中一个对等端携带的AllowedIPs数量上限
+	maxAllowedIPsPerMessage = 256
+
+	netlinkRecvBufferSize = 64 * 1024
+	netlinkTimeout        = 5 * time.Second
+)
+
+// NetlinkBackend 基于内核netlink的WireGuard后端，不依赖wireguard-tools
+type NetlinkBackend struct {
+	mu       sync.Mutex
+	familyID uint16
+}
+
+// NewNetlinkBackend 创建netlink后端
+func NewNetlinkBackend() *NetlinkBackend {
+	return &NetlinkBackend{}
+}
+
+// CreateDevice 创建WireGuard网络接口
+func (nb *NetlinkBackend) CreateDevice(name string) error {
+	attrs := &netlinkAttrs{}
+	attrs.putString(unix.IFLA_IFNAME, name)
+	attrs.nested(unix.IFLA_LINKINFO, func(info *netlinkAttrs) {
+		info.putString(unix.IFLA_INFO_KIND, wgGenlName)
+	})
+
+	payload := append(ifInfoMsg(0, 0, 0), attrs.buf...)
+	if _, err := routeRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, payload); err != nil {
+		return fmt.Errorf("创建WireGuard接口 %s 失败: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteDevice 删除网络接口
+func (nb *NetlinkBackend) DeleteDevice(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+
+	if _, err := routeRequest(unix.RTM_DELLINK, 0, ifInfoMsg(iface.Index, 0, 0)); err != nil {
+		if errors.Is(err, unix.ENODEV) {
+			return ErrDeviceNotFound
+		}
+		return fmt.Errorf("删除接口 %s 失败: %w", name, err)
+	}
+	return nil
+}
+
+// DeviceExists 检查网络接口是否存在
+func (nb *NetlinkBackend) DeviceExists(name string) bool {
+	_, err := net.InterfaceByName(name)
+	return err == nil
+}
+
+// SetLinkUp 设置MTU并启用接口
+func (nb *NetlinkBackend) SetLinkUp(name string, mtu int) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+
+	attrs := &netlinkAttrs{}
+	if mtu > 0 {
+		attrs.putUint32(unix.IFLA_MTU, uint32(mtu))
+	}
+
+	payload := append(ifInfoMsg(iface.Index, unix.IFF_UP, unix.IFF_UP), attrs.buf...)
+	if _, err := routeRequest(unix.RTM_NEWLINK, 0, payload); err != nil {
+		return fmt.Errorf("启用接口 %s 失败: %w", name, err)
+	}
+	return nil
+}
+
+// AddAddress 为接口添加地址
+func (nb *NetlinkBackend) AddAddress(name, cidr string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("无效的地址 %s: %w", cidr, err)
+	}
+	family, ipBytes := ipFamily(ip)
+	prefixLen, _ := ipNet.Mask.Size()
+
+	// struct ifaddrmsg
+	msg := make([]byte, unix.SizeofIfAddrmsg)
+	msg[0] = uint8(family)
+	msg[1] = uint8(prefixLen)
+	binary.NativeEndian.PutUint32(msg[4:8], uint32(iface.Index))
+
+	attrs := &netlinkAttrs{}
+	attrs.putBytes(unix.IFA_LOCAL, ipBytes)
+	attrs.putBytes(unix.IFA_ADDRESS, ipBytes)
+
+	if _, err := routeRequest(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, append(msg, attrs.buf...)); err != nil {
+		return fmt.Errorf("设置接口 %s 地址 %s 失败: %w", name, cidr, err)
+	}
+	return nil
+}
+
+// AddRoute 在指定路由表中添加经由接口的路由，路由已存在时不报错
+func (nb *NetlinkBackend) AddRoute(name, cidr string, table int) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("无效的路由 %s: %w", cidr, err)
+	}
+
+	msg := routeMessage(iface.Index, ipNet, table, unix.RT_SCOPE_LINK)
+	if _, err := routeRequest(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, msg); err != nil {
+		if errors.Is(err, unix.EEXIST) {
+			return nil
+		}
+		return fmt.Errorf("添加路由 %s dev %s 失败: %w", cidr, name, err)
+	}
+	return nil
+}
+
+// DeleteRoute 删除指定路由表中经由接口的路由，路由不存在时不报错。
+// 只匹配AddRoute和wg-quick添加的路由(proto boot)，不会删除内核为接口地址生成的路由
+func (nb *NetlinkBackend) DeleteRoute(name, cidr string, table int) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ErrDeviceNotFound
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("无效的路由 %s: %w", cidr, err)
+	}
+
+	msg := routeMessage(iface.Index, ipNet, table, unix.RT_SCOPE_NOWHERE)
+	if _, err := routeRequest(unix.RTM_DELROUTE, 0, msg); err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return nil
+		}
+		return fmt.Errorf("删除路由 %s dev %s 失败: %w", cidr, name, err)
+	}
+	return nil
+}
+
+// routeMessage 构造经由接口的路由消息，删除时scope为RT_SCOPE_NOWHERE表示不限。
+// 路由表编号大于255时rtm_table只有8位，与iproute2一样通过RTA_TABLE传递
+func routeMessage(index int, ipNet *net.IPNet, table int, scope uint8) []byte {
+	family, dst := ipFamily(ipNet.IP)
+	prefixLen, _ := ipNet.Mask.Size()
+
+	// struct rtmsg
+	msg := make([]byte, unix.SizeofRtMsg)
+	msg[0] = uint8(family)
+	msg[1] = uint8(prefixLen)
+	msg[5] = unix.RTPROT_BOOT
+	msg[6] = scope
+	msg[7] = unix.RTN_UNICAST
+
+	attrs := &netlinkAttrs{}
+	attrs.putBytes(unix.RTA_DST, dst)
+	attrs.putUint32(unix.RTA_OIF, uint32(index))
+	if table < 256 {
+		msg[4] = uint8(table)
+	} else {
+		msg[4] = unix.RT_TABLE_UNSPEC
+		attrs.putUint32(unix.RTA_TABLE, uint32(table))
+	}
+	return append(msg, attrs.buf...)
+}
+
+// ConfigureDevice 增量配置接口和对等端，对等端较多时拆分为多条消息
+func (nb *NetlinkBackend) ConfigureDevice(name string, cfg *DeviceConfig) error {
+	conn, err := dialNetlink(unix.NETLINK_GENERIC)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	familyID, err := nb.wireguardFamily(conn)
+	if err != nil {
+		return err
+	}
+
+	// 第一条消息携带接口级配置
+	device := &netlinkAttrs{}
+	device.putString(unix.WGDEVICE_A_IFNAME, name)
+	if cfg.PrivateKey != nil {
+		key := make([]byte, 32)
+		if *cfg.PrivateKey != "" {
+			if key, err = decodeKey(*cfg.PrivateKey); err != nil {
+				return err
+			}
+		}
+		device.putBytes(unix.WGDEVICE_A_PRIVATE_KEY, key)
+	}
+	if cfg.ListenPort != nil {
+		device.putUint16(unix.WGDEVICE_A_LISTEN_PORT, uint16(*cfg.ListenPort))
+	}
+	if cfg.FirewallMark != nil {
+		device.putUint32(unix.WGDEVICE_A_FWMARK, uint32(*cfg.FirewallMark))
+	}
+	if cfg.ReplacePeers {
+		device.putUint32(unix.WGDEVICE_A_FLAGS, unix.WGDEVICE_F_REPLACE_PEERS)
+	}
+
+	var peers [][]byte
+	for i := range cfg.Peers {
+		chunks, err := encodePeer(&cfg.Peers[i])
+		if err != nil {
+			return err
+		}
+		peers = append(peers, chunks...)
+	}
+
+	for _, attrs := range setDeviceMessages(name, device, peers) {
+		_, err := conn.execute(familyID, 0, genlMessage(unix.WG_CMD_SET_DEVICE, wgGenlVersion, attrs))
+		if errors.Is(err, unix.ENODEV) {
+			return ErrDeviceNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("配置WireGuard接口 %s 失败: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setDeviceMessages 把接口级属性和编码后的对等端拆分为若干条SET_DEVICE消息的属性。
+// 第一条消息携带接口级属性，后续消息只携带接口名；每条消息不超过maxSetDeviceMessageSize，
+// 单个对等端分段超过上限时单独成一条消息
+func setDeviceMessages(name string, device *netlinkAttrs, peers [][]byte) [][]byte {
+	var messages [][]byte
+
+	// 同一消息中的对等端必须放在同一个WGDEVICE_A_PEERS属性内
+	flush := func(attrs *netlinkAttrs, batch [][]byte) {
+		if len(batch) > 0 {
+			attrs.nested(unix.WGDEVICE_A_PEERS, func(list *netlinkAttrs) {
+				for _, peer := range batch {
+					list.buf = append(list.buf, peer...)
+				}
+			})
+		}
+		messages = append(messages, attrs.buf)
+	}
+
+	msg := device
+	var batch [][]byte
+	// 预留WGDEVICE_A_PEERS属性头
+	size := len(msg.buf) + unix.SizeofNlAttr
+	for _, peer := range peers {
+		if len(batch) > 0 && size+len(peer) > maxSetDeviceMessageSize {
+			flush(msg, batch)
+			msg = &netlinkAttrs{}
+			msg.putString(unix.WGDEVICE_A_IFNAME, name)
+			batch = nil
+			size = len(msg.buf) + unix.SizeofNlAttr
+		}
+		batch = append(batch, peer)
+		size += len(peer)
+	}
+
+	flush(msg, batch)
+	return messages
+}
+
+// GetDevice 读取接口及对等端状态
+func (nb *NetlinkBackend) GetDevice(name string) (*Device, error) {
+	conn, err := dialNetlink(unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	familyID, err := nb.wireguardFamily(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := &netlinkAttrs{}
+	attrs.putString(unix.WGDEVICE_A_IFNAME, name)
+
+	replies, err := conn.execute(familyID, unix.NLM_F_DUMP, genlMessage(unix.WG_CMD_GET_DEVICE, wgGenlVersion, attrs.buf))
+	if err != nil {
+		if errors.Is(err, unix.ENODEV) || errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, fmt.Errorf("读取WireGuard接口 %s 失败: %w", name, err)
+	}
+
+	device := &Device{Name: name}
+	for _, reply := range replies {
+		if len(reply) < unix.GENL_HDRLEN {
+			continue
+		}
+		if err := parseDevice(device, reply[unix.GENL_HDRLEN:]); err != nil {
+			return nil, err
+		}
+	}
+	return device, nil
+}
+
+// ListDevices 读取所有WireGuard接口状态
+func (nb *NetlinkBackend) ListDevices() ([]*Device, error) {
+	// 先确认内核支持WireGuard
+	conn, err := dialNetlink(unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	_, err = nb.wireguardFamily(conn)
+	conn.close()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("获取网络接口列表失败: %w", err)
+	}
+
+	var devices []*Device
+	for _, iface := range ifaces {
+		device, err := nb.GetDevice(iface.Name)
+		if err != nil {
+			if errors.Is(err, ErrDeviceNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// wireguardFamily 获取wireguard通用netlink族ID
+func (nb *NetlinkBackend) wireguardFamily(conn *netlinkConn) (uint16, error) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	if nb.familyID != 0 {
+		return nb.familyID, nil
+	}
+
+	attrs := &netlinkAttrs{}
+	attrs.putString(unix.CTRL_ATTR_FAMILY_NAME, wgGenlName)
+
+	replies, err := conn.execute(unix.GENL_ID_CTRL, 0, genlMessage(unix.CTRL_CMD_GETFAMILY, 1, attrs.buf))
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return 0, errors.New("内核不支持WireGuard（wireguard模块未加载）")
+		}
+		return 0, fmt.Errorf("查询wireguard netlink族失败: %w", err)
+	}
+
+	for _, reply := range replies {
+		if len(reply) < unix.GENL_HDRLEN {
+			continue
+		}
+		err := forEachAttr(reply[unix.GENL_HDRLEN:], func(typ uint16, data []byte) error {
+			if typ == unix.CTRL_ATTR_FAMILY_ID && len(data) >= 2 {
+				nb.familyID = binary.NativeEndian.Uint16(data)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if nb.familyID == 0 {
+		return 0, errors.New("未找到wireguard netlink族")
+	}
+	return nb.familyID, nil
+}
+
+// encodePeer 编码对等端属性，AllowedIPs过多时拆分为多段
+func encodePeer(peer *PeerConfig) ([][]byte, error) {
+	publicKey, err := decodeKey(peer.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoint []byte
+	if peer.Endpoint != "" && !peer.Remove {
+		addr, err := net.ResolveUDPAddr("udp", peer.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("解析对等端地址 %s 失败: %w", peer.Endpoint, err)
+		}
+		endpoint = encodeSockaddr(addr)
+	}
+
+	var presharedKey []byte
+	if peer.PresharedKey != nil {
+		presharedKey = make([]byte, 32)
+		if *peer.PresharedKey != "" {
+			if presharedKey, err = decodeKey(*peer.PresharedKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var flags uint32
+	if peer.Remove {
+		flags |= unix.WGPEER_F_REMOVE_ME
+	}
+	if peer.ReplaceAllowedIPs {
+		flags |= unix.WGPEER_F_REPLACE_ALLOWEDIPS
+	}
+	if peer.UpdateOnly {
+		flags |= unix.WGPEER_F_UPDATE_ONLY
+	}
+
+	allowedIPs := make([]*net.IPNet, 0, len(peer.AllowedIPs))
+	for _, cidr := range peer.AllowedIPs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的AllowedIPs %s: %w", cidr, err)
+		}
+		allowedIPs = append(allowedIPs, ipNet)
+	}
+
+	var chunks [][]byte
+	for first := true; first || len(allowedIPs) > 0; first = false {
+		batch := allowedIPs
+		if len(batch) > maxAllowedIPsPerMessage {
+			batch = batch[:maxAllowedIPsPerMessage]
+		}
+		allowedIPs = allowedIPs[len(batch):]
+
+		chunk := &netlinkAttrs{}
+		chunk.nested(0, func(attrs *netlinkAttrs) {
+			attrs.putBytes(unix.WGPEER_A_PUBLIC_KEY, publicKey)
+			// 后续分段只追加AllowedIPs
+			if first {
+				if flags != 0 {
+					attrs.putUint32(unix.WGPEER_A_FLAGS, flags)
+				}
+				if presharedKey != nil {
+					attrs.putBytes(unix.WGPEER_A_PRESHARED_KEY, presharedKey)
+				}
+				if endpoint != nil {
+					attrs.putBytes(unix.WGPEER_A_ENDPOINT, endpoint)
+				}
+				if peer.PersistentKeepalive != nil {
+					attrs.putUint16(unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL, uint16(*peer.PersistentKeepalive))
+				}
+			}
+			if len(batch) == 0 {
+				return
+			}
+			attrs.nested(unix.WGPEER_A_ALLOWEDIPS, func(list *netlinkAttrs) {
+				for _, ipNet := range batch {
+					family, ip := ipFamily(ipNet.IP)
+					ones, _ := ipNet.Mask.Size()
+					list.nested(0, func(allowed *netlinkAttrs) {
+						allowed.putUint16(unix.WGALLOWEDIP_A_FAMILY, uint16(family))
+						allowed.putBytes(unix.WGALLOWEDIP_A_IPADDR, ip)
+						allowed.putUint8(unix.WGALLOWEDIP_A_CIDR_MASK, uint8(ones))
+					})
+				}
+			})
+		})
+		chunks = append(chunks, chunk.buf)
+	}
+
+	return chunks, nil
+}
+
+// parseDevice 解析GET_DEVICE响应，同一对等端可能跨多条消息
+func parseDevice(device *Device, data []byte) error {
+	return forEachAttr(data, func(typ uint16, value []byte) error {
+		switch typ {
+		case unix.WGDEVICE_A_IFNAME:
+			device.Name = nullTerminated(value)
+		case unix.WGDEVICE_A_PRIVATE_KEY:
+			device.PrivateKey = encodeKey(value)
+		case unix.WGDEVICE_A_PUBLIC_KEY:
+			device.PublicKey = encodeKey(value)
+		case unix.WGDEVICE_A_LISTEN_PORT:
+			if len(value) >= 2 {
+				device.ListenPort = int(binary.NativeEndian.Uint16(value))
+			}
+		case unix.WGDEVICE_A_FWMARK:
+			if len(value) >= 4 {
+				device.FirewallMark = int(binary.NativeEndian.Uint32(value))
+			}
+		case unix.WGDEVICE_A_PEERS:
+			return forEachAttr(value, func(_ uint16, peerData []byte) error {
+				var peer Peer
+				if err := parsePeer(&peer, peerData); err != nil {
+					return err
+				}
+				if n := len(device.Peers); n > 0 && device.Peers[n-1].PublicKey == peer.PublicKey {
+					device.Peers[n-1].AllowedIPs = append(device.Peers[n-1].AllowedIPs, peer.AllowedIPs...)
+					return nil
+				}
+				device.Peers = append(device.Peers, peer)
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// parsePeer 解析对等端属性
+func parsePeer(peer *Peer, data []byte) error {
+	return forEachAttr(data, func(typ uint16, value []byte) error {
+		switch typ {
+		case unix.WGPEER_A_PUBLIC_KEY:
+			peer.PublicKey = encodeKey(value)
+		case unix.WGPEER_A_PRESHARED_KEY:
+			peer.PresharedKey = encodeKey(value)
+		case unix.WGPEER_A_ENDPOINT:
+			peer.Endpoint = decodeSockaddr(value)
+		case unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL:
+			if len(value) >= 2 {
+				peer.PersistentKeepalive = int(binary.NativeEndian.Uint16(value))
+			}
+		case unix.WGPEER_A_LAST_HANDSHAKE_TIME:
+			// struct __kernel_timespec
+			if len(value) >= 16 {
+				sec := int64(binary.NativeEndian.Uint64(value[0:8]))
+				nsec := int64(binary.NativeEndian.Uint64(value[8:16]))
+				if sec != 0 || nsec != 0 {
+					peer.LatestHandshake = time.Unix(sec, nsec)
+				}
+			}
+		case unix.WGPEER_A_RX_BYTES:
+			if len(value) >= 8 {
+				peer.ReceiveBytes = binary.NativeEndian.Uint64(value)
+			}
+		case unix.WGPEER_A_TX_BYTES:
+			if len(value) >= 8 {
+				peer.TransmitBytes = binary.NativeEndian.Uint64(value)
+			}
+		case unix.WGPEER_A_ALLOWEDIPS:
+			return forEachAttr(value, func(_ uint16, allowedData []byte) error {
+				var ip net.IP
+				var mask int
+				err := forEachAttr(allowedData, func(typ uint16, v []byte) error {
+					switch typ {
+					case unix.WGALLOWEDIP_A_IPADDR:
+						ip = net.IP(append([]byte(nil), v...))
+					case unix.WGALLOWEDIP_A_CIDR_MASK:
+						if len(v) >= 1 {
+							mask = int(v[0])
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if ip != nil {
+					peer.AllowedIPs = append(peer.AllowedIPs, ip.String()+"/"+strconv.Itoa(mask))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// ipFamily 返回地址族和地址字节
+func ipFamily(ip net.IP) (int, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return unix.AF_INET, ip4
+	}
+	return unix.AF_INET6, ip.To16()
+}
+
+// encodeSockaddr 编码struct sockaddr_in/sockaddr_in6
+func encodeSockaddr(addr *net.UDPAddr) []byte {
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b := make([]byte, unix.SizeofSockaddrInet4)
+		binary.NativeEndian.PutUint16(b[0:2], unix.AF_INET)
+		binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+		copy(b[4:8], ip4)
+		return b
+	}
+
+	b := make([]byte, unix.SizeofSockaddrInet6)
+	binary.NativeEndian.PutUint16(b[0:2], unix.AF_INET6)
+	binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+	copy(b[8:24], addr.IP.To16())
+	if addr.Zone != "" {
+		if iface, err := net.InterfaceByName(addr.Zone); err == nil {
+			binary.NativeEndian.PutUint32(b[24:28], uint32(iface.Index))
+		}
+	}
+	return b
+}
+
+// decodeSockaddr 解码struct sockaddr_in/sockaddr_in6
+func decodeSockaddr(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	switch binary.NativeEndian.Uint16(b[0:2]) {
+	case unix.AF_INET:
+		if len(b) < 8 {
+			return ""
+		}
+		port := int(binary.BigEndian.Uint16(b[2:4]))
+		return net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(port))
+	case unix.AF_INET6:
+		if len(b) < 24 {
+			return ""
+		}
+		port := int(binary.BigEndian.Uint16(b[2:4]))
+		return net.JoinHostPort(net.IP(b[8:24]).String(), strconv.Itoa(port))
+	}
+	return ""
+}
+
+// nullTerminated 去除C字符串结尾的\0
+func nullTerminated(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// ifInfoMsg 构造struct ifinfomsg
+func ifInfoMsg(index int, flags, change uint32) []byte {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(msg[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(msg[8:12], flags)
+	binary.NativeEndian.PutUint32(msg[12:16], change)
+	return msg
+}
+
+// genlMessage 构造通用netlink消息体
+func genlMessage(cmd, version uint8, attrs []byte) []byte {
+	msg := make([]byte, unix.GENL_HDRLEN, unix.GENL_HDRLEN+len(attrs))
+	msg[0] = cmd
+	msg[1] = version
+	return append(msg, attrs...)
+}
+
+// routeRequest 发送一次rtnetlink请求
+func routeRequest(msgType, flags uint16, payload []byte) ([][]byte, error) {
+	conn, err := dialNetlink(unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+	return conn.execute(msgType, flags, payload)
+}
+
+// netlinkConn netlink套接字
+type netlinkConn struct {
+	fd  int
+	seq uint32
+}
+
+// dialNetlink 打开netlink套接字
+func dialNetlink(protocol int) (*netlinkConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return nil, fmt.Errorf("打开netlink套接字失败: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("绑定netlink套接字失败: %w", err)
+	}
+
+	tv := unix.NsecToTimeval(netlinkTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("设置netlink超时失败: %w", err)
+	}
+
+	return &netlinkConn{fd: fd, seq: uint32(time.Now().Unix())}, nil
+}
+
+// close 关闭套接字
+func (c *netlinkConn) close() {
+	unix.Close(c.fd)
+}
+
+// execute 发送请求并收集响应，非DUMP请求等待ACK，DUMP请求读取到NLMSG_DONE
+func (c *netlinkConn) execute(msgType, flags uint16, payload []byte) ([][]byte, error) {
+	c.seq++
+	seq := c.seq
+
+	dump := flags&unix.NLM_F_DUMP == unix.NLM_F_DUMP
+	flags |= unix.NLM_F_REQUEST
+	if !dump {
+		flags |= unix.NLM_F_ACK
+	}
+
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], flags)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, payload...)
+
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("发送netlink请求失败: %w", err)
+	}
+
+	var replies [][]byte
+	buf := make([]byte, netlinkRecvBufferSize)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("接收netlink响应失败: %w", err)
+		}
+
+		data := buf[:n]
+		for len(data) >= unix.NLMSG_HDRLEN {
+			length := int(binary.NativeEndian.Uint32(data[0:4]))
+			if length < unix.NLMSG_HDRLEN || length > len(data) {
+				return nil, errors.New("netlink响应格式错误")
+			}
+			typ := binary.NativeEndian.Uint16(data[4:6])
+			replySeq := binary.NativeEndian.Uint32(data[8:12])
+			body := data[unix.NLMSG_HDRLEN:length]
+			data = data[min(nlmsgAlign(length), len(data)):]
+
+			if replySeq != seq {
+				continue
+			}
+
+			switch typ {
+			case unix.NLMSG_ERROR:
+				if len(body) < 4 {
+					return nil, errors.New("netlink错误响应格式错误")
+				}
+				if code := int32(binary.NativeEndian.Uint32(body[0:4])); code != 0 {
+					return nil, unix.Errno(-code)
+				}
+				return replies, nil
+			case unix.NLMSG_DONE:
+				if len(body) >= 4 {
+					if code := int32(binary.NativeEndian.Uint32(body[0:4])); code < 0 {
+						return nil, unix.Errno(-code)
+					}
+				}
+				return replies, nil
+			default:
+				replies = append(replies, append([]byte(nil), body...))
+			}
+		}
+	}
+}
+
+// nlmsgAlign netlink消息按4字节对齐
+func nlmsgAlign(n int) int {
+	return (n + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
+
+// netlinkAttrs netlink属性编码器
+type netlinkAttrs struct {
+	buf []byte
+}
+
+// putBytes 追加属性
+func (a *netlinkAttrs) putBytes(typ uint16, value []byte) {
+	header := make([]byte, unix.SizeofNlAttr)
+	binary.NativeEndian.PutUint16(header[0:2], uint16(unix.SizeofNlAttr+len(value)))
+	binary.NativeEndian.PutUint16(header[2:4], typ)
+	a.buf = append(a.buf, header...)
+	a.buf = append(a.buf, value...)
+	a.pad()
+}
+
+// putString 追加以\0结尾的字符串属性
+func (a *netlinkAttrs) putString(typ uint16, value string) {
+	a.putBytes(typ, append([]byte(value), 0))
+}
+
+// putUint8 追加u8属性
+func (a *netlinkAttrs) putUint8(typ uint16, value uint8) {
+	a.putBytes(typ, []byte{value})
+}
+
+// putUint16 追加u16属性
+func (a *netlinkAttrs) putUint16(typ uint16, value uint16) {
+	b := make([]byte, 2)
+	binary.NativeEndian.PutUint16(b, value)
+	a.putBytes(typ, b)
+}
+
+// putUint32 追加u32属性
+func (a *netlinkAttrs) putUint32(typ uint16, value uint32) {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, value)
+	a.putBytes(typ, b)
+}
+
+// nested 追加嵌套属性
+func (a *netlinkAttrs) nested(typ uint16, fn func(*netlinkAttrs)) {
+	start := len(a.buf)
+	a.buf = append(a.buf, make([]byte, unix.SizeofNlAttr)...)
+	fn(a)
+	binary.NativeEndian.PutUint16(a.buf[start:start+2], uint16(len(a.buf)-start))
+	binary.NativeEndian.PutUint16(a.buf[start+2:start+4], typ|unix.NLA_F_NESTED)
+}
+
+// pad 按4字节对齐
+func (a *netlinkAttrs) pad() {
+	for len(a.buf)%unix.NLA_ALIGNTO != 0 {
+		a.buf = append(a.buf, 0)
+	}
+}
+
+// forEachAttr 遍历netlink属性
+func forEachAttr(data []byte, fn func(typ uint16, value []byte) error) error {
+	for len(data) >= unix.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		typ := binary.NativeEndian.Uint16(data[2:4]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if length < unix.SizeofNlAttr || length > len(data) {
+			return errors.New("netlink属性格式错误")
+		}
+		if err := fn(typ, data[unix.SizeofNlAttr:length]); err != nil {
+			return err
+		}
+		aligned := (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+//go:build linux
+
+package wireguard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 以下字节布局按小端序书写
+func requireLittleEndian(t *testing.T) {
+	t.Helper()
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("字节布局按小端序书写")
+	}
+}
+
+// repeatKey 32字节全为b的密钥
+func repeatKey(b byte) (string, []byte) {
+	key := bytes.Repeat([]byte{b}, 32)
+	return base64.StdEncoding.EncodeToString(key), key
+}
+
+// join 拼接字节片段
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestEncodeSockaddr(t *testing.T) {
+	requireLittleEndian(t)
+
+	tests := []struct {
+		endpoint string
+		want     []byte
+	}{
+		{
+			endpoint: "192.0.2.1:51820",
+			// sockaddr_in: family(2) port(2,网络序) addr(4) zero(8)
+			want: []byte{0x02, 0x00, 0xca, 0x6c, 192, 0, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			endpoint: "[2001:db8::1]:51820",
+			// sockaddr_in6: family(2) port(2) flowinfo(4) addr(16) scope_id(4)
+			want: []byte{
+				0x0a, 0x00, 0xca, 0x6c, 0, 0, 0, 0,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+				0, 0, 0, 0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		addr, err := net.ResolveUDPAddr("udp", tt.endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := encodeSockaddr(addr)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeSockaddr(%s) = % x, 期望 % x", tt.endpoint, got, tt.want)
+		}
+		if decoded := decodeSockaddr(got); decoded != tt.endpoint {
+			t.Errorf("decodeSockaddr = %s, 期望 %s", decoded, tt.endpoint)
+		}
+	}
+}
+
+func TestRouteMessage(t *testing.T) {
+	requireLittleEndian(t)
+
+	_, ipNet, _ := net.ParseCIDR("192.168.60.0/24")
+	got := routeMessage(7, ipNet, MainRouteTable, unix.RT_SCOPE_NOWHERE)
+	want := join(
+		// rtmsg: family dst_len src_len tos table protocol scope type flags(4)
+		[]byte{unix.AF_INET, 24, 0, 0, unix.RT_TABLE_MAIN, unix.RTPROT_BOOT, unix.RT_SCOPE_NOWHERE, unix.RTN_UNICAST, 0, 0, 0, 0},
+		[]byte{8, 0, unix.RTA_DST, 0, 192, 168, 60, 0},
+		[]byte{8, 0, unix.RTA_OIF, 0, 7, 0, 0, 0},
+	)
+	if !bytes.Equal(got, want) {
+		t.Errorf("routeMessage = % x, 期望 % x", got, want)
+	}
+
+	// 51820超出rtm_table的范围，通过RTA_TABLE传递
+	_, ipNet, _ = net.ParseCIDR("0.0.0.0/0")
+	got = routeMessage(7, ipNet, 51820, unix.RT_SCOPE_LINK)
+	want = join(
+		[]byte{unix.AF_INET, 0, 0, 0, unix.RT_TABLE_UNSPEC, unix.RTPROT_BOOT, unix.RT_SCOPE_LINK, unix.RTN_UNICAST, 0, 0, 0, 0},
+		[]byte{8, 0, unix.RTA_DST, 0, 0, 0, 0, 0},
+		[]byte{8, 0, unix.RTA_OIF, 0, 7, 0, 0, 0},
+		[]byte{8, 0, unix.RTA_TABLE, 0, 0x6c, 0xca, 0, 0},
+	)
+	if !bytes.Equal(got, want) {
+		t.Errorf("routeMessage(table 51820) = % x, 期望 % x", got, want)
+	}
+}
+
+func TestEncodePeer(t *testing.T) {
+	requireLittleEndian(t)
+
+	publicKey, keyBytes := repeatKey(0x01)
+	keepalive := 25
+	publicKeyAttr := join([]byte{0x24, 0x00, 0x01, 0x00}, keyBytes)
+
+	tests := []struct {
+		name string
+		peer PeerConfig
+		want []byte
+	}{
+		{
+			name: "IPv4端点",
+			peer: PeerConfig{
+				PublicKey:           publicKey,
+				Endpoint:            "192.0.2.1:51820",
+				PersistentKeepalive: &keepalive,
+				ReplaceAllowedIPs:   true,
+				AllowedIPs:          []string{"10.0.0.2/32"},
+			},
+			want: join(
+				[]byte{0x6c, 0x00, 0x00, 0x80}, // 对等端 (嵌套)
+				publicKeyAttr,
+				[]byte{0x08, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x00},                                       // FLAGS = REPLACE_ALLOWEDIPS
+				[]byte{0x14, 0x00, 0x04, 0x00, 0x02, 0x00, 0xca, 0x6c, 192, 0, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0}, // ENDPOINT
+				[]byte{0x06, 0x00, 0x05, 0x00, 0x19, 0x00, 0x00, 0x00},                                       // PERSISTENT_KEEPALIVE_INTERVAL + 填充
+				[]byte{0x20, 0x00, 0x09, 0x80},                                                               // ALLOWEDIPS (嵌套)
+				[]byte{0x1c, 0x00, 0x00, 0x80},
+				[]byte{0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00}, // FAMILY = AF_INET
+				[]byte{0x08, 0x00, 0x02, 0x00, 10, 0, 0, 2},            // IPADDR
+				[]byte{0x05, 0x00, 0x03, 0x00, 32, 0x00, 0x00, 0x00},   // CIDR_MASK
+			),
+		},
+		{
+			name: "IPv6端点",
+			peer: PeerConfig{
+				PublicKey:  publicKey,
+				Endpoint:   "[2001:db8::1]:51820",
+				AllowedIPs: []string{"fd00::2/128"},
+			},
+			want: join(
+				[]byte{0x74, 0x00, 0x00, 0x80},
+				publicKeyAttr,
+				[]byte{0x20, 0x00, 0x04, 0x00, 0x0a, 0x00, 0xca, 0x6c, 0, 0, 0, 0},
+				[]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0, 0, 0, 0},
+				[]byte{0x2c, 0x00, 0x09, 0x80},
+				[]byte{0x28, 0x00, 0x00, 0x80},
+				[]byte{0x06, 0x00, 0x01, 0x00, 0x0a, 0x00, 0x00, 0x00}, // FAMILY = AF_INET6
+				[]byte{0x14, 0x00, 0x02, 0x00, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02},
+				[]byte{0x05, 0x00, 0x03, 0x00, 128, 0x00, 0x00, 0x00},
+			),
+		},
+		{
+			name: "删除对等端不携带端点",
+			peer: PeerConfig{
+				PublicKey: publicKey,
+				Endpoint:  "192.0.2.1:51820",
+				Remove:    true,
+			},
+			want: join(
+				[]byte{0x30, 0x00, 0x00, 0x80},
+				publicKeyAttr,
+				[]byte{0x08, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00}, // FLAGS = REMOVE_ME
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		chunks, err := encodePeer(&tt.peer)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(chunks) != 1 {
+			t.Fatalf("%s: 分段数 = %d, 期望 1", tt.name, len(chunks))
+		}
+		if !bytes.Equal(chunks[0], tt.want) {
+			t.Errorf("%s:\n得到 % x\n期望 % x", tt.name, chunks[0], tt.want)
+		}
+	}
+}
+
+func TestEncodePeerSplitsAllowedIPs(t *testing.T) {
+	publicKey, _ := repeatKey(0x02)
+	presharedKey := ""
+	peer := PeerConfig{PublicKey: publicKey, PresharedKey: &presharedKey, Endpoint: "192.0.2.1:51820"}
+	for i := 0; i < 600; i++ {
+		peer.AllowedIPs = append(peer.AllowedIPs, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+
+	chunks, err := encodePeer(&peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("分段数 = %d, 期望 3", len(chunks))
+	}
+
+	// 只有第一段携带预共享密钥和端点，各段都携带公钥
+	device := &Device{}
+	for i, chunk := range chunks {
+		var types []uint16
+		forEachAttr(chunk[unix.SizeofNlAttr:], func(typ uint16, _ []byte) error {
+			types = append(types, typ)
+			return nil
+		})
+		hasEndpoint := false
+		for _, typ := range types {
+			hasEndpoint = hasEndpoint || typ == unix.WGPEER_A_ENDPOINT || typ == unix.WGPEER_A_PRESHARED_KEY
+		}
+		if types[0] != unix.WGPEER_A_PUBLIC_KEY || hasEndpoint != (i == 0) {
+			t.Errorf("第%d段属性 = %v", i+1, types)
+		}
+
+		// 分段在解析时合并为同一个对等端
+		reply := &netlinkAttrs{}
+		reply.nested(unix.WGDEVICE_A_PEERS, func(list *netlinkAttrs) { list.buf = append(list.buf, chunk...) })
+		if err := parseDevice(device, reply.buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(device.Peers) != 1 || len(device.Peers[0].AllowedIPs) != 600 {
+		t.Fatalf("解析结果: %d 个对等端", len(device.Peers))
+	}
+	if got := device.Peers[0].AllowedIPs[599]; got != "10.2.87.0/24" {
+		t.Errorf("最后一个AllowedIPs = %s", got)
+	}
+}
+
+func TestSetDeviceMessages(t *testing.T) {
+	_, privateKey := repeatKey(0x03)
+	device := &netlinkAttrs{}
+	device.putString(unix.WGDEVICE_A_IFNAME, "wg0")
+	device.putBytes(unix.WGDEVICE_A_PRIVATE_KEY, privateKey)
+	device.putUint32(unix.WGDEVICE_A_FLAGS, unix.WGDEVICE_F_REPLACE_PEERS)
+
+	const peerCount = 500
+	var peers [][]byte
+	var keys []string
+	for i := 0; i < peerCount; i++ {
+		key := make([]byte, 32)
+		binary.BigEndian.PutUint32(key, uint32(i+1))
+		keys = append(keys, base64.StdEncoding.EncodeToString(key))
+		chunks, err := encodePeer(&PeerConfig{
+			PublicKey:  keys[i],
+			Endpoint:   "[2001:db8::1]:51820",
+			AllowedIPs: []string{fmt.Sprintf("10.0.%d.%d/32", i/256, i%256), "192.168.0.0/16"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, chunks...)
+	}
+
+	messages := setDeviceMessages("wg0", device, peers)
+	if len(messages) < 2 {
+		t.Fatalf("%d 个对等端只生成了 %d 条消息", peerCount, len(messages))
+	}
+
+	var got []string
+	for i, msg := range messages {
+		if len(msg) > maxSetDeviceMessageSize {
+			t.Errorf("第%d条消息 %d 字节，超过上限 %d", i+1, len(msg), maxSetDeviceMessageSize)
+		}
+		var types []uint16
+		parsed := &Device{}
+		forEachAttr(msg, func(typ uint16, _ []byte) error {
+			types = append(types, typ)
+			return nil
+		})
+		if err := parseDevice(parsed, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		// 第一条消息携带接口级属性，后续消息只有接口名和对等端
+		wantTypes := []uint16{unix.WGDEVICE_A_IFNAME, unix.WGDEVICE_A_PEERS}
+		if i == 0 {
+			wantTypes = []uint16{unix.WGDEVICE_A_IFNAME, unix.WGDEVICE_A_PRIVATE_KEY, unix.WGDEVICE_A_FLAGS, unix.WGDEVICE_A_PEERS}
+		}
+		if fmt.Sprint(types) != fmt.Sprint(wantTypes) || parsed.Name != "wg0" {
+			t.Errorf("第%d条消息属性 = %v, 接口名 %q", i+1, types, parsed.Name)
+		}
+		for _, peer := range parsed.Peers {
+			got = append(got, peer.PublicKey)
+		}
+	}
+
+	if strings.Join(got, ",") != strings.Join(keys, ",") {
+		t.Errorf("拆分后对等端 %d 个，顺序或内容与输入不一致", len(got))
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	privateKey, privateBytes := repeatKey(0x04)
+	publicKey, publicBytes := repeatKey(0x05)
+	peerKey, peerBytes := repeatKey(0x06)
+	presharedKey, presharedBytes := repeatKey(0x07)
+
+	handshake := make([]byte, 16)
+	binary.NativeEndian.PutUint64(handshake[0:8], 1700000000)
+	binary.NativeEndian.PutUint64(handshake[8:16], 500)
+	endpoint, err := net.ResolveUDPAddr("udp", "[2001:db8::1]:51820")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.NativeEndian.PutUint64(b, v)
+		return b
+	}
+	allowedIP := func(list *netlinkAttrs, family uint16, ip net.IP, mask uint8) {
+		list.nested(0, func(allowed *netlinkAttrs) {
+			allowed.putUint16(unix.WGALLOWEDIP_A_FAMILY, family)
+			allowed.putBytes(unix.WGALLOWEDIP_A_IPADDR, ip)
+			allowed.putUint8(unix.WGALLOWEDIP_A_CIDR_MASK, mask)
+		})
+	}
+
+	// 内核对等端较多时分多条消息返回，同一对等端的AllowedIPs可能跨消息
+	first := &netlinkAttrs{}
+	first.putString(unix.WGDEVICE_A_IFNAME, "wg0")
+	first.putBytes(unix.WGDEVICE_A_PRIVATE_KEY, privateBytes)
+	first.putBytes(unix.WGDEVICE_A_PUBLIC_KEY, publicBytes)
+	first.putUint16(unix.WGDEVICE_A_LISTEN_PORT, 51820)
+	first.putUint32(unix.WGDEVICE_A_FWMARK, 0x51)
+	first.nested(unix.WGDEVICE_A_PEERS, func(peers *netlinkAttrs) {
+		peers.nested(0, func(peer *netlinkAttrs) {
+			peer.putBytes(unix.WGPEER_A_PUBLIC_KEY, peerBytes)
+			peer.putBytes(unix.WGPEER_A_PRESHARED_KEY, presharedBytes)
+			peer.putBytes(unix.WGPEER_A_ENDPOINT, encodeSockaddr(endpoint))
+			peer.putUint16(unix.WGPEER_A_PERSISTENT_KEEPALIVE_INTERVAL, 25)
+			peer.putBytes(unix.WGPEER_A_LAST_HANDSHAKE_TIME, handshake)
+			peer.putBytes(unix.WGPEER_A_RX_BYTES, u64(4096))
+			peer.putBytes(unix.WGPEER_A_TX_BYTES, u64(2048))
+			peer.nested(unix.WGPEER_A_ALLOWEDIPS, func(list *netlinkAttrs) {
+				allowedIP(list, unix.AF_INET, net.IPv4(10, 0, 0, 2).To4(), 32)
+			})
+		})
+	})
+	second := &netlinkAttrs{}
+	second.putString(unix.WGDEVICE_A_IFNAME, "wg0")
+	second.nested(unix.WGDEVICE_A_PEERS, func(peers *netlinkAttrs) {
+		peers.nested(0, func(peer *netlinkAttrs) {
+			peer.putBytes(unix.WGPEER_A_PUBLIC_KEY, peerBytes)
+			peer.nested(unix.WGPEER_A_ALLOWEDIPS, func(list *netlinkAttrs) {
+				allowedIP(list, unix.AF_INET6, net.ParseIP("fd00::2"), 128)
+			})
+		})
+	})
+
+	device := &Device{}
+	for _, reply := range [][]byte{first.buf, second.buf} {
+		if err := parseDevice(device, reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if device.Name != "wg0" || device.PrivateKey != privateKey || device.PublicKey != publicKey ||
+		device.ListenPort != 51820 || device.FirewallMark != 0x51 {
+		t.Errorf("接口属性解析错误: %+v", device)
+	}
+	if len(device.Peers) != 1 {
+		t.Fatalf("对等端 %d 个, 期望 1 个", len(device.Peers))
+	}
+	peer := device.Peers[0]
+	if peer.PublicKey != peerKey || peer.PresharedKey != presharedKey || peer.Endpoint != "[2001:db8::1]:51820" ||
+		peer.PersistentKeepalive != 25 || peer.ReceiveBytes != 4096 || peer.TransmitBytes != 2048 {
+		t.Errorf("对等端属性解析错误: %+v", peer)
+	}
+	if !peer.LatestHandshake.Equal(time.Unix(1700000000, 500)) {
+		t.Errorf("握手时间 = %v", peer.LatestHandshake)
+	}
+	if strings.Join(peer.AllowedIPs, ",") != "10.0.0.2/32,fd00::2/128" {
+		t.Errorf("AllowedIPs = %v", peer.AllowedIPs)
+	}
+
+	if err := parseDevice(&Device{}, []byte{0xff, 0x00, 0x01, 0x00}); err == nil {
+		t.Error("属性长度越界时应返回错误")
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !linux
+
+package wireguard
+
+import "errors"
+
+// errNetlinkUnsupported 非Linux系统不支持netlink
+var errNetlinkUnsupported = errors.New("当前系统不支持netlink WireGuard后端")
+
+// NetlinkBackend 非Linux系统上的占位实现
+type NetlinkBackend struct{}
+
+// NewNetlinkBackend 创建netlink后端
+func NewNetlinkBackend() *NetlinkBackend {
+	return &NetlinkBackend{}
+}
+
+// CreateDevice 创建WireGuard网络接口
+func (nb *NetlinkBackend) CreateDevice(name string) error { return errNetlinkUnsupported }
+
+// DeleteDevice 删除网络接口
+func (nb *NetlinkBackend) DeleteDevice(name string) error { return errNetlinkUnsupported }
+
+// DeviceExists 检查网络接口是否存在
+func (nb *NetlinkBackend) DeviceExists(name string) bool { return false }
+
+// SetLinkUp 设置MTU并启用接口
+func (nb *NetlinkBackend) SetLinkUp(name string, mtu int) error { return errNetlinkUnsupported }
+
+// AddAddress 为接口添加地址
+func (nb *NetlinkBackend) AddAddress(name, cidr string) error { return errNetlinkUnsupported }
+
+// AddRoute 添加经由接口的路由
+func (nb *NetlinkBackend) AddRoute(name, cidr string, table int) error { return errNetlinkUnsupported }
+
+// DeleteRoute 删除经由接口的路由
+func (nb *NetlinkBackend) DeleteRoute(name, cidr string, table int) error {
+	return errNetlinkUnsupported
+}
+
+// ConfigureDevice 增量配置接口和对等端
+func (nb *NetlinkBackend) ConfigureDevice(name string, cfg *DeviceConfig) error {
+	return errNetlinkUnsupported
+}
+
+// GetDevice 读取接口及对等端状态
+func (nb *NetlinkBackend) GetDevice(name string) (*Device, error) {
+	return nil, errNetlinkUnsupported
+}
+
+// ListDevices 读取所有WireGuard接口状态
+func (nb *NetlinkBackend) ListDevices() ([]*Device, error) {
+	return nil, errNetlinkUnsupported
+}
@@ -0,0 +1,36 @@
+package wireguard
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// DefaultConfigDir WireGuard配置文件默认目录
+const DefaultConfigDir = "/etc/wireguard"
+
+var (
+	configDirMu sync.RWMutex
+	configDir   = DefaultConfigDir
+)
+
+// SetConfigDir 设置WireGuard配置文件目录，空字符串恢复默认目录
+func SetConfigDir(dir string) {
+	configDirMu.Lock()
+	defer configDirMu.Unlock()
+	if dir == "" {
+		dir = DefaultConfigDir
+	}
+	configDir = dir
+}
+
+// ConfigDir 获取WireGuard配置文件目录
+func ConfigDir() string {
+	configDirMu.RLock()
+	defer configDirMu.RUnlock()
+	return configDir
+}
+
+// ConfigPath 获取接口配置文件路径，如 /etc/wireguard/wg0.conf
+func ConfigPath(interfaceName string) string {
+	return filepath.Join(ConfigDir(), interfaceName+".conf")
+}
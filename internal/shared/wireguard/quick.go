@@ -0,0 +1,223 @@
+package wireguard
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"eitec-vpn/internal/shared/wgconf"
+)
+
+// quickDeviceConfig 将wg-quick配置转换为后端接口配置
+func quickDeviceConfig(config *wgconf.Config) (*DeviceConfig, error) {
+	device := &DeviceConfig{ReplacePeers: true}
+
+	privateKey := config.Interface.PrivateKey
+	device.PrivateKey = &privateKey
+	if config.Interface.ListenPort > 0 {
+		port := config.Interface.ListenPort
+		device.ListenPort = &port
+	}
+	if config.Interface.FwMark != "" && !strings.EqualFold(config.Interface.FwMark, "off") {
+		mark, err := strconv.ParseUint(config.Interface.FwMark, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("FwMark无效: %s", config.Interface.FwMark)
+		}
+		fwmark := int(mark)
+		device.FirewallMark = &fwmark
+	}
+
+	for _, peer := range config.Peers {
+		presharedKey := peer.PresharedKey
+		keepalive := peer.PersistentKeepalive
+		device.Peers = append(device.Peers, PeerConfig{
+			PublicKey:           peer.PublicKey,
+			PresharedKey:        &presharedKey,
+			Endpoint:            peer.Endpoint,
+			PersistentKeepalive: &keepalive,
+			ReplaceAllowedIPs:   true,
+			AllowedIPs:          peer.AllowedIPs,
+		})
+	}
+
+	return device, nil
+}
+
+// QuickUp 按wg-quick格式配置文件启动接口，通过WireGuard后端完成，不依赖wg-quick
+func QuickUp(interfaceName, configPath string) error {
+	cfg, err := wgconf.ParseFile(configPath)
+	if err != nil {
+		return fmt.Errorf("解析配置文件失败: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("配置文件验证失败: %w", err)
+	}
+
+	routes, err := parseQuickRoutes(cfg.Interface.Table)
+	if err != nil {
+		return err
+	}
+
+	backend := GetBackend()
+	if backend.DeviceExists(interfaceName) {
+		return fmt.Errorf("接口 %s 已存在", interfaceName)
+	}
+
+	if err := runHooks(backend, interfaceName, cfg.Interface.PreUp); err != nil {
+		return err
+	}
+
+	if err := backend.CreateDevice(interfaceName); err != nil {
+		return err
+	}
+
+	// 与wg-quick一样，接口创建后任何一步失败都删除接口，包括PostUp
+	if err := quickSetup(backend, interfaceName, cfg, routes); err != nil {
+		quickCleanup(backend, interfaceName)
+		return err
+	}
+
+	if len(cfg.Interface.DNS) > 0 {
+		log.Printf("接口 %s 配置了DNS %v，当前启动方式不修改系统DNS", interfaceName, cfg.Interface.DNS)
+	}
+
+	if err := runHooks(backend, interfaceName, cfg.Interface.PostUp); err != nil {
+		quickCleanup(backend, interfaceName)
+		return err
+	}
+	return nil
+}
+
+// quickCleanup 删除启动失败的接口
+func quickCleanup(backend WireGuardBackend, interfaceName string) {
+	if err := backend.DeleteDevice(interfaceName); err != nil {
+		log.Printf("删除启动失败的接口 %s 失败: %v", interfaceName, err)
+	}
+	forgetEndpoints(interfaceName)
+}
+
+// quickRoutes 按Table配置添加AllowedIPs路由的方式
+type quickRoutes struct {
+	table        int  // 路由表，0表示不添加路由
+	defaultRoute bool // 是否添加/0路由
+}
+
+// parseQuickRoutes 解析Table配置，语义与wg-quick相同：off不添加路由，
+// 未设置或auto添加到主路由表并跳过需要策略路由的默认路由，指定路由表时所有AllowedIPs都添加到该表
+func parseQuickRoutes(table string) (quickRoutes, error) {
+	switch strings.ToLower(table) {
+	case "off":
+		return quickRoutes{}, nil
+	case "", "auto":
+		return quickRoutes{table: MainRouteTable}, nil
+	case "main":
+		return quickRoutes{table: MainRouteTable, defaultRoute: true}, nil
+	}
+	id, err := strconv.ParseUint(table, 10, 32)
+	if err != nil || id == 0 {
+		return quickRoutes{}, fmt.Errorf("Table无效: %s", table)
+	}
+	return quickRoutes{table: int(id), defaultRoute: true}, nil
+}
+
+// quickSetup 配置已创建的接口：密钥与对等端、地址、MTU和路由
+func quickSetup(backend WireGuardBackend, interfaceName string, cfg *wgconf.Config, routes quickRoutes) error {
+	device, err := quickDeviceConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if err := backend.ConfigureDevice(interfaceName, device); err != nil {
+		return err
+	}
+	rememberEndpoints(interfaceName, device.Peers)
+
+	for _, address := range cfg.Interface.Address {
+		if !strings.Contains(address, "/") {
+			if strings.Contains(address, ":") {
+				address += "/128"
+			} else {
+				address += "/32"
+			}
+		}
+		if err := backend.AddAddress(interfaceName, address); err != nil {
+			return err
+		}
+	}
+
+	mtu := cfg.Interface.MTU
+	if mtu == 0 {
+		mtu = 1420
+	}
+	if err := backend.SetLinkUp(interfaceName, mtu); err != nil {
+		return err
+	}
+
+	if routes.table == 0 {
+		return nil
+	}
+
+	for _, peer := range cfg.Peers {
+		for _, allowedIP := range peer.AllowedIPs {
+			// 主路由表中的全局路由需要策略路由配合，不自动添加
+			if !routes.defaultRoute && strings.HasSuffix(allowedIP, "/0") {
+				log.Printf("接口 %s 跳过默认路由 %s", interfaceName, allowedIP)
+				continue
+			}
+			if err := backend.AddRoute(interfaceName, allowedIP, routes.table); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// QuickDown 停止按wg-quick格式配置启动的接口，接口不存在时返回ErrDeviceNotFound
+func QuickDown(interfaceName, configPath string) error {
+	backend := GetBackend()
+	if !backend.DeviceExists(interfaceName) {
+		return ErrDeviceNotFound
+	}
+
+	// 配置文件缺失时仍然删除接口，只是不执行钩子
+	hooks := wgconf.Interface{}
+	if cfg, err := wgconf.ParseFile(configPath); err == nil {
+		hooks = cfg.Interface
+	}
+
+	if err := runHooks(backend, interfaceName, hooks.PreDown); err != nil {
+		log.Printf("执行PreDown失败: %v", err)
+	}
+
+	if err := backend.DeleteDevice(interfaceName); err != nil {
+		return err
+	}
+	forgetEndpoints(interfaceName)
+
+	if err := runHooks(backend, interfaceName, hooks.PostDown); err != nil {
+		log.Printf("执行PostDown失败: %v", err)
+	}
+
+	return nil
+}
+
+// runHooks 执行Pre/Post钩子命令，%i替换为接口名
+func runHooks(backend WireGuardBackend, interfaceName string, hooks []string) error {
+	runner, _ := backend.(HookRunner)
+	for _, hook := range hooks {
+		command := strings.ReplaceAll(hook, "%i", interfaceName)
+		if runner != nil {
+			if err := runner.RunHook(interfaceName, command); err != nil {
+				return err
+			}
+			continue
+		}
+		output, err := exec.Command("sh", "-c", command).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("执行命令失败: %s, 错误: %v, 输出: %s", command, err, output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeQuickConfig 写入wg-quick格式的测试配置，table为空时不设置Table
+func writeQuickConfig(t *testing.T, table, postUp string) string {
+	t.Helper()
+
+	config := "[Interface]\n" +
+		"PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n" +
+		"Address = 10.10.0.1/24\n"
+	if table != "" {
+		config += "Table = " + table + "\n"
+	}
+	if postUp != "" {
+		config += "PostUp = " + postUp + "\n"
+	}
+	config += "\n[Peer]\n" +
+		"PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\n" +
+		"AllowedIPs = 0.0.0.0/0, 192.168.50.0/24\n"
+
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// useFakeBackend 测试期间使用模拟后端
+func useFakeBackend(t *testing.T) *FakeBackend {
+	t.Helper()
+	backend := NewFakeBackend()
+	SetBackend(backend)
+	t.Cleanup(func() {
+		SetBackend(nil)
+		forgetEndpoints("wg0")
+	})
+	return backend
+}
+
+func TestQuickUpRouteTable(t *testing.T) {
+	tests := []struct {
+		table string
+		want  []string
+	}{
+		{"", []string{"192.168.50.0/24"}},
+		{"auto", []string{"192.168.50.0/24"}},
+		{"off", nil},
+		{"51820", []string{"0.0.0.0/0 table 51820", "192.168.50.0/24 table 51820"}},
+		{"main", []string{"0.0.0.0/0", "192.168.50.0/24"}},
+	}
+
+	for _, tt := range tests {
+		backend := useFakeBackend(t)
+		if err := QuickUp("wg0", writeQuickConfig(t, tt.table, "")); err != nil {
+			t.Fatalf("Table = %q: %v", tt.table, err)
+		}
+		routes := backend.Routes("wg0")
+		sort.Strings(routes)
+		if !equalStrings(routes, tt.want) {
+			t.Errorf("Table = %q: 路由 %v, 期望 %v", tt.table, routes, tt.want)
+		}
+	}
+
+	backend := useFakeBackend(t)
+	if err := QuickUp("wg0", writeQuickConfig(t, "vpn", "")); err == nil {
+		t.Error("无效的Table应当报错")
+	}
+	if backend.DeviceExists("wg0") {
+		t.Error("配置无效时不应创建接口")
+	}
+}
+
+func TestQuickUpPostUpFailureRemovesDevice(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.FailHook("false")
+
+	if err := QuickUp("wg0", writeQuickConfig(t, "", "false")); err == nil {
+		t.Fatal("PostUp失败时应当返回错误")
+	}
+	if backend.DeviceExists("wg0") {
+		t.Error("PostUp失败后接口未删除")
+	}
+	if _, known := lastEndpoints("wg0"); known {
+		t.Error("PostUp失败后仍保留接口的Endpoint记录")
+	}
+
+	// 接口已删除，可以再次启动
+	if err := QuickUp("wg0", writeQuickConfig(t, "", "")); err != nil {
+		t.Fatalf("重新启动失败: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package wireguard
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// configuredEndpoints 各接口上次下发的对等端Endpoint，用于区分配置变化和对端漫游
+var (
+	configuredEndpointsMu sync.Mutex
+	configuredEndpoints   = make(map[string]map[string]string)
+)
+
+// ReconcileResult 对等端同步结果
+type ReconcileResult struct {
+	Added   []string `json:"added"`   // 新增的对等端公钥
+	Updated []string `json:"updated"` // 更新的对等端公钥
+	Removed []string `json:"removed"` // 删除的对等端公钥
+}
+
+// Changed 是否有对等端发生变化
+func (r *ReconcileResult) Changed() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed) > 0
+}
+
+// String 同步结果摘要
+func (r *ReconcileResult) String() string {
+	return fmt.Sprintf("新增 %d, 更新 %d, 删除 %d", len(r.Added), len(r.Updated), len(r.Removed))
+}
+
+// ReconcilePeers 将运行中接口的对等端同步为期望状态，只增删改有变化的对等端，不影响其他已建立的会话
+func ReconcilePeers(backend WireGuardBackend, interfaceName string, desired []PeerConfig) (*ReconcileResult, error) {
+	device, err := backend.GetDevice(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*Peer, len(device.Peers))
+	oldRoutes := make(map[string]bool)
+	for i := range device.Peers {
+		current[device.Peers[i].PublicKey] = &device.Peers[i]
+		haveIPs, _ := normalizeAllowedIPs(device.Peers[i].AllowedIPs)
+		for _, cidr := range haveIPs {
+			oldRoutes[cidr] = true
+		}
+	}
+	endpoints, endpointsKnown := lastEndpoints(interfaceName)
+
+	result := &ReconcileResult{}
+	var changes []PeerConfig
+	var newRoutes []string
+	wantedRoutes := make(map[string]bool)
+	wanted := make(map[string]bool, len(desired))
+
+	for _, want := range desired {
+		if want.PublicKey == "" || wanted[want.PublicKey] {
+			continue
+		}
+		wanted[want.PublicKey] = true
+
+		allowedIPs, err := normalizeAllowedIPs(want.AllowedIPs)
+		if err != nil {
+			return nil, err
+		}
+		for _, cidr := range allowedIPs {
+			wantedRoutes[cidr] = true
+		}
+
+		have, exists := current[want.PublicKey]
+		if !exists {
+			change := want
+			change.AllowedIPs = allowedIPs
+			change.ReplaceAllowedIPs = true
+			changes = append(changes, change)
+			newRoutes = append(newRoutes, allowedIPs...)
+			result.Added = append(result.Added, want.PublicKey)
+			continue
+		}
+
+		change := PeerConfig{PublicKey: want.PublicKey, UpdateOnly: true}
+		changed := false
+
+		wantPSK := ""
+		if want.PresharedKey != nil {
+			wantPSK = *want.PresharedKey
+		}
+		if wantPSK != have.PresharedKey {
+			change.PresharedKey = &wantPSK
+			changed = true
+		}
+
+		wantKA := 0
+		if want.PersistentKeepalive != nil {
+			wantKA = *want.PersistentKeepalive
+		}
+		if wantKA != have.PersistentKeepalive {
+			change.PersistentKeepalive = &wantKA
+			changed = true
+		}
+
+		// 对端漫游后内核中的地址与配置不同，只在配置本身变化时重新设置，
+		// 服务重启后没有记录时与内核比较；未指定Endpoint时保留对端漫游后的地址
+		if want.Endpoint != "" {
+			endpointChanged := want.Endpoint != endpoints[want.PublicKey]
+			if !endpointsKnown {
+				endpointChanged = resolveEndpoint(want.Endpoint) != have.Endpoint
+			}
+			if endpointChanged {
+				change.Endpoint = want.Endpoint
+				changed = true
+			}
+		}
+
+		haveIPs, _ := normalizeAllowedIPs(have.AllowedIPs)
+		if !equalStrings(allowedIPs, haveIPs) {
+			change.AllowedIPs = allowedIPs
+			change.ReplaceAllowedIPs = true
+			newRoutes = append(newRoutes, allowedIPs...)
+			changed = true
+		}
+
+		if changed {
+			changes = append(changes, change)
+			result.Updated = append(result.Updated, want.PublicKey)
+		}
+	}
+
+	for _, peer := range device.Peers {
+		if !wanted[peer.PublicKey] {
+			changes = append(changes, PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+			result.Removed = append(result.Removed, peer.PublicKey)
+		}
+	}
+
+	if len(changes) == 0 {
+		rememberEndpoints(interfaceName, desired)
+		return result, nil
+	}
+
+	if err := backend.ConfigureDevice(interfaceName, &DeviceConfig{Peers: changes}); err != nil {
+		return nil, err
+	}
+	rememberEndpoints(interfaceName, desired)
+
+	// 删除的对等端和更新时去掉的AllowedIPs不再需要路由，与wg-quick重启后的效果一致
+	for cidr := range oldRoutes {
+		if wantedRoutes[cidr] || strings.HasSuffix(cidr, "/0") {
+			continue
+		}
+		if err := backend.DeleteRoute(interfaceName, cidr, MainRouteTable); err != nil {
+			log.Printf("删除接口 %s 的路由 %s 失败: %v", interfaceName, cidr, err)
+		}
+	}
+
+	// 新的AllowedIPs需要路由，已存在的路由会被忽略
+	for _, cidr := range newRoutes {
+		if strings.HasSuffix(cidr, "/0") {
+			continue
+		}
+		if err := backend.AddRoute(interfaceName, cidr, MainRouteTable); err != nil {
+			log.Printf("为接口 %s 添加路由 %s 失败: %v", interfaceName, cidr, err)
+		}
+	}
+
+	return result, nil
+}
+
+// rememberEndpoints 记录下发给接口的对等端Endpoint
+func rememberEndpoints(interfaceName string, peers []PeerConfig) {
+	endpoints := make(map[string]string, len(peers))
+	for _, peer := range peers {
+		endpoints[peer.PublicKey] = peer.Endpoint
+	}
+
+	configuredEndpointsMu.Lock()
+	defer configuredEndpointsMu.Unlock()
+	configuredEndpoints[interfaceName] = endpoints
+}
+
+// forgetEndpoints 接口删除后清除记录
+func forgetEndpoints(interfaceName string) {
+	configuredEndpointsMu.Lock()
+	defer configuredEndpointsMu.Unlock()
+	delete(configuredEndpoints, interfaceName)
+}
+
+// lastEndpoints 获取上次下发的Endpoint，服务重启后没有记录时返回false
+func lastEndpoints(interfaceName string) (map[string]string, bool) {
+	configuredEndpointsMu.Lock()
+	defer configuredEndpointsMu.Unlock()
+	endpoints, ok := configuredEndpoints[interfaceName]
+	return endpoints, ok
+}
+
+// normalizeAllowedIPs 将AllowedIPs规范化为排序后的网段形式，与内核返回的格式一致
+func normalizeAllowedIPs(allowedIPs []string) ([]string, error) {
+	normalized := make([]string, 0, len(allowedIPs))
+	seen := make(map[string]bool, len(allowedIPs))
+	for _, cidr := range allowedIPs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的AllowedIPs %s: %w", cidr, err)
+		}
+		if key := ipNet.String(); !seen[key] {
+			seen[key] = true
+			normalized = append(normalized, key)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// resolveEndpoint 将Endpoint解析为内核返回的IP:端口格式，解析失败时原样返回
+func resolveEndpoint(endpoint string) string {
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return addr.String()
+}
+
+// equalStrings 比较两个字符串切片
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
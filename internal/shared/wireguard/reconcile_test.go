@@ -0,0 +1,205 @@
+package wireguard
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair.PublicKey
+}
+
+func TestReconcilePeers(t *testing.T) {
+	t.Cleanup(func() { forgetEndpoints("wg0") })
+	backend := NewFakeBackend()
+	if err := backend.CreateDevice("wg0"); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, updated, removed, added := testKey(t), testKey(t), testKey(t), testKey(t)
+	keepalive := 25
+	if err := backend.ConfigureDevice("wg0", &DeviceConfig{Peers: []PeerConfig{
+		{PublicKey: kept, AllowedIPs: []string{"10.10.0.2/32"}, PersistentKeepalive: &keepalive},
+		{PublicKey: updated, AllowedIPs: []string{"10.10.0.3/32"}},
+		{PublicKey: removed, AllowedIPs: []string{"10.10.0.4/32"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := time.Now().Truncate(time.Second)
+	if err := backend.SimulateHandshake("wg0", kept, "198.51.100.7:40000", handshake); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.AddTraffic("wg0", kept, 100, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ReconcilePeers(backend, "wg0", []PeerConfig{
+		{PublicKey: kept, AllowedIPs: []string{"10.10.0.2/32"}, PersistentKeepalive: &keepalive},
+		{PublicKey: updated, AllowedIPs: []string{"10.10.0.3/32", "192.168.50.0/24"}},
+		{PublicKey: added, AllowedIPs: []string{"10.10.0.5/32"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Added) != 1 || result.Added[0] != added {
+		t.Errorf("Added = %v", result.Added)
+	}
+	if len(result.Updated) != 1 || result.Updated[0] != updated {
+		t.Errorf("Updated = %v", result.Updated)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != removed {
+		t.Errorf("Removed = %v", result.Removed)
+	}
+
+	device, err := backend.GetDevice("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := make(map[string]Peer)
+	for _, peer := range device.Peers {
+		peers[peer.PublicKey] = peer
+	}
+	if _, ok := peers[removed]; ok {
+		t.Error("多余的对等端未删除")
+	}
+	if got := peers[updated].AllowedIPs; len(got) != 2 {
+		t.Errorf("更新后的AllowedIPs = %v", got)
+	}
+
+	// 未变化的对等端保留握手、流量和漫游后的Endpoint
+	peer := peers[kept]
+	if !peer.LatestHandshake.Equal(handshake) || peer.ReceiveBytes != 100 || peer.TransmitBytes != 200 {
+		t.Errorf("未变化的对等端状态被重置: %+v", peer)
+	}
+	if peer.Endpoint != "198.51.100.7:40000" {
+		t.Errorf("Endpoint = %s", peer.Endpoint)
+	}
+
+	// 再次同步应当没有变化
+	result, err = ReconcilePeers(backend, "wg0", []PeerConfig{
+		{PublicKey: kept, AllowedIPs: []string{"10.10.0.2/32"}, PersistentKeepalive: &keepalive},
+		{PublicKey: updated, AllowedIPs: []string{"192.168.50.0/24", "10.10.0.3/32"}},
+		{PublicKey: added, AllowedIPs: []string{"10.10.0.5/32"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed() {
+		t.Errorf("重复同步产生变化: %s", result)
+	}
+
+	if routes := backend.Routes("wg0"); len(routes) != 3 {
+		t.Errorf("新增的AllowedIPs路由 = %v", routes)
+	}
+}
+
+func TestReconcilePeersRemovesStaleRoutes(t *testing.T) {
+	t.Cleanup(func() { forgetEndpoints("wg0") })
+	backend := NewFakeBackend()
+	if err := backend.CreateDevice("wg0"); err != nil {
+		t.Fatal(err)
+	}
+
+	shrunk, removed := testKey(t), testKey(t)
+	if err := backend.ConfigureDevice("wg0", &DeviceConfig{Peers: []PeerConfig{
+		{PublicKey: shrunk, AllowedIPs: []string{"10.10.0.2/32", "192.168.60.0/24"}},
+		{PublicKey: removed, AllowedIPs: []string{"10.10.0.3/32", "192.168.70.0/24"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	// 接口地址对应的路由不属于任何对等端，应当保留
+	for _, cidr := range []string{"10.10.0.0/24", "10.10.0.2/32", "192.168.60.0/24", "10.10.0.3/32", "192.168.70.0/24"} {
+		if err := backend.AddRoute("wg0", cidr, MainRouteTable); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 192.168.70.0/24从被删除的对等端移到保留的对等端，路由不应被删除
+	result, err := ReconcilePeers(backend, "wg0", []PeerConfig{
+		{PublicKey: shrunk, AllowedIPs: []string{"10.10.0.2/32", "192.168.70.0/24"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 1 || len(result.Removed) != 1 {
+		t.Fatalf("同步结果: %s", result)
+	}
+
+	routes := backend.Routes("wg0")
+	sort.Strings(routes)
+	if want := []string{"10.10.0.0/24", "10.10.0.2/32", "192.168.70.0/24"}; !equalStrings(routes, want) {
+		t.Errorf("路由 = %v, 期望 %v", routes, want)
+	}
+}
+
+func TestReconcilePeersKeepsRoamedEndpoint(t *testing.T) {
+	t.Cleanup(func() { forgetEndpoints("wg0") })
+	backend := NewFakeBackend()
+	if err := backend.CreateDevice("wg0"); err != nil {
+		t.Fatal(err)
+	}
+
+	key := testKey(t)
+	desired := []PeerConfig{{PublicKey: key, Endpoint: "198.51.100.10:51820", AllowedIPs: []string{"10.10.0.2/32"}}}
+	if result, err := ReconcilePeers(backend, "wg0", desired); err != nil || len(result.Added) != 1 {
+		t.Fatalf("result = %v, err = %v", result, err)
+	}
+
+	// 对端漫游后配置未变化，不应重置为配置的地址
+	if err := backend.SimulateHandshake("wg0", key, "203.0.113.5:40000", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ReconcilePeers(backend, "wg0", desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed() {
+		t.Errorf("漫游后同步产生变化: %s", result)
+	}
+	if peer := fakePeer(t, backend, key); peer.Endpoint != "203.0.113.5:40000" {
+		t.Errorf("漫游后的Endpoint被重置为 %s", peer.Endpoint)
+	}
+
+	// 配置的Endpoint变化时重新设置
+	desired[0].Endpoint = "198.51.100.11:51820"
+	result, err = ReconcilePeers(backend, "wg0", desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 1 {
+		t.Errorf("Updated = %v", result.Updated)
+	}
+	if peer := fakePeer(t, backend, key); peer.Endpoint != "198.51.100.11:51820" {
+		t.Errorf("Endpoint = %s, 期望 198.51.100.11:51820", peer.Endpoint)
+	}
+}
+
+// fakePeer 读取模拟后端中的对等端
+func fakePeer(t *testing.T, backend *FakeBackend, publicKey string) Peer {
+	t.Helper()
+	device, err := backend.GetDevice("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range device.Peers {
+		if peer.PublicKey == publicKey {
+			return peer
+		}
+	}
+	t.Fatalf("对等端 %s 不存在", publicKey)
+	return Peer{}
+}
+
+func TestReconcilePeersDeviceNotFound(t *testing.T) {
+	if _, err := ReconcilePeers(NewFakeBackend(), "wg0", nil); err != ErrDeviceNotFound {
+		t.Errorf("err = %v, 期望 ErrDeviceNotFound", err)
+	}
+}
@@ -3,14 +3,15 @@ package wireguard
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"regexp"
-	"strings"
 	"time"
 
 	"eitec-vpn/internal/server/models"
+	"eitec-vpn/internal/shared/ipaddr"
+	"eitec-vpn/internal/shared/wgconf"
 
 	"golang.org/x/crypto/curve25519"
 )
@@ -60,10 +61,6 @@ func GenerateModuleConfig(module *models.Module, wgInterface *models.WireGuardIn
 
 // GenerateModuleConfigWithLocalIP 生成模块配置文件内容（支持指定local_ip）
 func GenerateModuleConfigWithLocalIP(module *models.Module, wgInterface *models.WireGuardInterface, serverEndpoint, dns, moduleLocalIP string) string {
-	config := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s/32`, module.PrivateKey, module.IPAddress)
-
 	// 根据用户成功配置，不添加DNS字段
 	// 用户反馈：不需要配置DNS，保持配置简洁
 
@@ -102,35 +99,51 @@ Address = %s/32`, module.PrivateKey, module.IPAddress)
 		moduleNetworkInterface = "wlan0" // 默认使用wlan0
 	}
 
-	// 添加完整的PostUp/PostDown规则，参考用户成功配置
-	// 包含FORWARD规则和NAT规则，实现完整的内网穿透功能
-	config += fmt.Sprintf(`
-# 完整的防火墙规则 - 实现内网穿透功能
-# 参考用户成功配置：包含FORWARD和NAT规则
-PostUp = iptables -A FORWARD -i %%i -o %s -j ACCEPT; iptables -A FORWARD -i %s -o %%i -m state --state RELATED,ESTABLISHED -j ACCEPT; iptables -t nat -A POSTROUTING -s %s -o %s -j SNAT --to-source %s
-PostDown = iptables -D FORWARD -i %%i -o %s -j ACCEPT; iptables -D FORWARD -i %s -o %%i -m state --state RELATED,ESTABLISHED -j ACCEPT; iptables -t nat -D POSTROUTING -s %s -o %s -j SNAT --to-source %s`,
-		moduleNetworkInterface, moduleNetworkInterface, wgInterface.Network, moduleNetworkInterface, finalLocalIP,
-		moduleNetworkInterface, moduleNetworkInterface, wgInterface.Network, moduleNetworkInterface, finalLocalIP)
-
 	// 根据用户成功配置的模式设置AllowedIPs
 	// 参考：AllowedIPs = 10.10.0.0/24 (整个VPN网段，实现VPN内部互通)
-	allowedIPs := wgInterface.Network // 使用接口的整个网络段，如 10.10.0.0/24
-
-	config += fmt.Sprintf(`
-
-[Peer]
-PublicKey = %s
-Endpoint = %s
-AllowedIPs = %s`, wgInterface.PublicKey, serverEndpoint, allowedIPs)
+	allowedIPs := []string{wgInterface.Network} // 使用接口的整个网络段，如 10.10.0.0/24
+
+	config := &wgconf.Config{
+		Interface: wgconf.Interface{
+			// 完整的PostUp/PostDown规则，包含FORWARD规则和NAT规则，实现完整的内网穿透功能
+			Comments:   []string{"完整的防火墙规则 - 实现内网穿透功能"},
+			PrivateKey: module.PrivateKey,
+			Address:    ipaddr.Hosts(module.IPAddress, module.IPAddress6),
+			PostUp: []string{fmt.Sprintf("iptables -A FORWARD -i %%i -o %s -j ACCEPT; iptables -A FORWARD -i %s -o %%i -m state --state RELATED,ESTABLISHED -j ACCEPT; iptables -t nat -A POSTROUTING -s %s -o %s -j SNAT --to-source %s",
+				moduleNetworkInterface, moduleNetworkInterface, wgInterface.Network, moduleNetworkInterface, finalLocalIP)},
+			PostDown: []string{fmt.Sprintf("iptables -D FORWARD -i %%i -o %s -j ACCEPT; iptables -D FORWARD -i %s -o %%i -m state --state RELATED,ESTABLISHED -j ACCEPT; iptables -t nat -D POSTROUTING -s %s -o %s -j SNAT --to-source %s",
+				moduleNetworkInterface, moduleNetworkInterface, wgInterface.Network, moduleNetworkInterface, finalLocalIP)},
+		},
+		Peers: []wgconf.Peer{{
+			PublicKey:           wgInterface.PublicKey,
+			PresharedKey:        module.PresharedKey,
+			Endpoint:            serverEndpoint,
+			PersistentKeepalive: module.PersistentKA,
+		}},
+	}
 
-	// 如果有预共享密钥，添加到配置中
-	if module.PresharedKey != "" {
-		config += fmt.Sprintf("\nPresharedKey = %s", module.PresharedKey)
+	// 双栈：同时路由IPv6网段，内网侧的IPv6流量做地址伪装
+	if wgInterface.DualStack() && module.IPAddress6 != "" {
+		allowedIPs = append(allowedIPs, wgInterface.Network6)
+		config.Interface.PostUp = append(config.Interface.PostUp, fmt.Sprintf("ip6tables -A FORWARD -i %%i -o %s -j ACCEPT; ip6tables -A FORWARD -i %s -o %%i -m state --state RELATED,ESTABLISHED -j ACCEPT; ip6tables -t nat -A POSTROUTING -s %s -o %s -j MASQUERADE",
+			moduleNetworkInterface, moduleNetworkInterface, wgInterface.Network6, moduleNetworkInterface))
+		config.Interface.PostDown = append(config.Interface.PostDown, fmt.Sprintf("ip6tables -D FORWARD -i %%i -o %s -j ACCEPT; ip6tables -D FORWARD -i %s -o %%i -m state --state RELATED,ESTABLISHED -j ACCEPT; ip6tables -t nat -D POSTROUTING -s %s -o %s -j MASQUERADE",
+			moduleNetworkInterface, moduleNetworkInterface, wgInterface.Network6, moduleNetworkInterface))
 	}
+	config.Peers[0].AllowedIPs = allowedIPs
 
-	config += fmt.Sprintf("\nPersistentKeepalive = %d", module.PersistentKA)
+	return config.String()
+}
 
-	return config
+// InjectPrivateKey 为[Interface]段中为空的PrivateKey填入本地私钥
+// 通过加入令牌注册的模块私钥只保存在模块本地，服务器下发的配置不包含私钥
+func InjectPrivateKey(configContent, privateKey string) string {
+	config, err := wgconf.Parse(configContent)
+	if err != nil || config.Interface.PrivateKey != "" {
+		return configContent
+	}
+	config.Interface.PrivateKey = privateKey
+	return config.String()
 }
 
 // GeneratePeerConfig 生成运维端Peer配置
@@ -140,17 +153,15 @@ func GeneratePeerConfig(module *models.Module) string {
 		allowedIPs = GetDefaultInternalNetwork()
 	}
 
-	config := fmt.Sprintf(`[Peer]
-# %s - %s
-PublicKey = %s
-AllowedIPs = %s/32, %s`,
-		module.Name,
-		module.Location,
-		module.PublicKey,
-		module.IPAddress,
-		allowedIPs)
-
-	return config
+	config := &wgconf.Config{
+		Peers: []wgconf.Peer{{
+			Comments:   []string{fmt.Sprintf("%s - %s", module.Name, module.Location)},
+			PublicKey:  module.PublicKey,
+			AllowedIPs: append(ipaddr.Hosts(module.IPAddress, module.IPAddress6), wgconf.SplitList(allowedIPs)...),
+		}},
+	}
+
+	return config.String()
 }
 
 // GenerateServerConfig 生成服务器端WireGuard配置
@@ -166,49 +177,38 @@ func GenerateServerConfig(serverPrivateKey, serverIP string, port int, modules [
 	// 计算VPN网络段（从服务器IP推导）
 	vpnNetwork := DeduceNetworkFromServerIP(serverIP)
 
-	config := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s/24
-ListenPort = %d
-SaveConfig = true
-
-# PostUp和PostDown脚本用于防火墙规则和内网穿透
-PostUp = iptables -t nat -A POSTROUTING -s %s -o %s -j MASQUERADE; iptables -A INPUT -p udp -m udp --dport %d -j ACCEPT; iptables -A FORWARD -i %s -j ACCEPT; iptables -A FORWARD -o %s -j ACCEPT;
-PostDown = iptables -t nat -D POSTROUTING -s %s -o %s -j MASQUERADE; iptables -D INPUT -p udp -m udp --dport %d -j ACCEPT; iptables -D FORWARD -i %s -j ACCEPT; iptables -D FORWARD -o %s -j ACCEPT;
-
-`, serverPrivateKey, serverIP, port, vpnNetwork, externalInterface, port, interfaceName, interfaceName, vpnNetwork, externalInterface, port, interfaceName, interfaceName)
-
-	// 注意：不再自动生成硬编码的iptables规则
-	// 用户反馈：这些规则不够灵活，应该由用户自定义或使用默认规则
-
-	config += "\n"
+	config := &wgconf.Config{
+		Interface: wgconf.Interface{
+			PrivateKey: serverPrivateKey,
+			Address:    []string{serverIP + "/24"},
+			ListenPort: port,
+			SaveConfig: true,
+			// PostUp和PostDown脚本用于防火墙规则和内网穿透
+			PostUp: []string{fmt.Sprintf("iptables -t nat -A POSTROUTING -s %s -o %s -j MASQUERADE; iptables -A INPUT -p udp -m udp --dport %d -j ACCEPT; iptables -A FORWARD -i %s -j ACCEPT; iptables -A FORWARD -o %s -j ACCEPT;",
+				vpnNetwork, externalInterface, port, interfaceName, interfaceName)},
+			PostDown: []string{fmt.Sprintf("iptables -t nat -D POSTROUTING -s %s -o %s -j MASQUERADE; iptables -D INPUT -p udp -m udp --dport %d -j ACCEPT; iptables -D FORWARD -i %s -j ACCEPT; iptables -D FORWARD -o %s -j ACCEPT;",
+				vpnNetwork, externalInterface, port, interfaceName, interfaceName)},
+		},
+	}
 
 	// 添加所有模块的Peer配置
 	for _, module := range modules {
-		config += fmt.Sprintf(`
-[Peer]
-# %s - %s
-PublicKey = %s
-AllowedIPs = %s/32`,
-			module.Name,
-			module.Location,
-			module.PublicKey,
-			module.IPAddress)
+		allowedIPs := ipaddr.Hosts(module.IPAddress, module.IPAddress6)
 
 		// 如果模块配置了内网访问，添加到AllowedIPs
 		if module.AllowedIPs != "" && !IsDefaultInternalNetwork(module.AllowedIPs) {
-			config += fmt.Sprintf(",%s", module.AllowedIPs)
-		}
-
-		// 添加预共享密钥（如果有）
-		if module.PresharedKey != "" {
-			config += fmt.Sprintf("\nPresharedKey = %s", module.PresharedKey)
+			allowedIPs = append(allowedIPs, wgconf.SplitList(module.AllowedIPs)...)
 		}
 
-		config += "\n"
+		config.Peers = append(config.Peers, wgconf.Peer{
+			Comments:     []string{fmt.Sprintf("%s - %s", module.Name, module.Location)},
+			PublicKey:    module.PublicKey,
+			PresharedKey: module.PresharedKey,
+			AllowedIPs:   allowedIPs,
+		})
 	}
 
-	return config
+	return config.String()
 }
 
 // WriteConfigFile 写入配置文件
@@ -221,16 +221,18 @@ func WriteConfigFile(configPath, content string) error {
 
 // RestartWireGuard 重启WireGuard接口
 func RestartWireGuard(interfaceName string) error {
+	configPath := ConfigPath(interfaceName)
+
 	// 停止接口
-	if err := exec.Command("wg-quick", "down", interfaceName).Run(); err != nil {
+	if err := QuickDown(interfaceName, configPath); err != nil {
 		// 如果接口不存在，忽略错误
-		if !strings.Contains(err.Error(), "does not exist") {
+		if !errors.Is(err, ErrDeviceNotFound) {
 			return fmt.Errorf("停止WireGuard接口失败: %w", err)
 		}
 	}
 
 	// 启动接口
-	if err := exec.Command("wg-quick", "up", interfaceName).Run(); err != nil {
+	if err := QuickUp(interfaceName, configPath); err != nil {
 		return fmt.Errorf("启动WireGuard接口失败: %w", err)
 	}
 
@@ -239,13 +241,25 @@ func RestartWireGuard(interfaceName string) error {
 
 // GetWireGuardStatus 获取WireGuard状态信息
 func GetWireGuardStatus(interfaceName string) (map[string]WireGuardPeer, error) {
-	cmd := exec.Command("wg", "show", interfaceName, "dump")
-	output, err := cmd.Output()
+	device, err := GetBackend().GetDevice(interfaceName)
 	if err != nil {
 		return nil, fmt.Errorf("获取WireGuard状态失败: %w", err)
 	}
 
-	return parseWireGuardStatus(string(output))
+	peers := make(map[string]WireGuardPeer, len(device.Peers))
+	for _, peer := range device.Peers {
+		peers[peer.PublicKey] = WireGuardPeer{
+			PublicKey:       peer.PublicKey,
+			Endpoint:        peer.Endpoint,
+			AllowedIPs:      peer.AllowedIPs,
+			LatestHandshake: peer.LatestHandshake,
+			TransferRxBytes: peer.ReceiveBytes,
+			TransferTxBytes: peer.TransmitBytes,
+			PersistentKA:    peer.PersistentKeepalive,
+		}
+	}
+
+	return peers, nil
 }
 
 // WireGuardPeer Peer状态信息
@@ -259,91 +273,6 @@ type WireGuardPeer struct {
 	PersistentKA    int       `json:"persistent_keepalive"`
 }
 
-// parseWireGuardStatus 解析wg show dump输出
-func parseWireGuardStatus(output string) (map[string]WireGuardPeer, error) {
-	peers := make(map[string]WireGuardPeer)
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		fields := strings.Split(line, "\t")
-		if len(fields) < 6 {
-			continue
-		}
-
-		// 跳过接口行
-		if fields[0] != "" && len(fields) == 6 {
-			continue
-		}
-
-		publicKey := fields[0]
-		if publicKey == "" {
-			continue
-		}
-
-		peer := WireGuardPeer{
-			PublicKey: publicKey,
-			Endpoint:  fields[2],
-		}
-
-		// 解析AllowedIPs
-		if fields[3] != "" {
-			peer.AllowedIPs = strings.Split(fields[3], ",")
-		}
-
-		// 解析最新握手时间
-		if fields[4] != "0" {
-			if timestamp, err := parseTimestamp(fields[4]); err == nil {
-				peer.LatestHandshake = timestamp
-			}
-		}
-
-		// 解析传输字节数
-		if rxBytes, err := parseBytes(fields[5]); err == nil {
-			peer.TransferRxBytes = rxBytes
-		}
-		if len(fields) > 6 {
-			if txBytes, err := parseBytes(fields[6]); err == nil {
-				peer.TransferTxBytes = txBytes
-			}
-		}
-
-		// 解析PersistentKeepalive
-		if len(fields) > 7 && fields[7] != "0" {
-			if ka, err := parseBytes(fields[7]); err == nil {
-				peer.PersistentKA = int(ka)
-			}
-		}
-
-		peers[publicKey] = peer
-	}
-
-	return peers, nil
-}
-
-// parseTimestamp 解析时间戳
-func parseTimestamp(s string) (time.Time, error) {
-	timestamp, err := time.Parse("2006-01-02 15:04:05", s)
-	if err != nil {
-		// 尝试Unix时间戳
-		if unix, err2 := time.Parse("1136239445", s); err2 == nil {
-			return unix, nil
-		}
-		return time.Time{}, err
-	}
-	return timestamp, nil
-}
-
-// parseBytes 解析字节数
-func parseBytes(s string) (uint64, error) {
-	var bytes uint64
-	_, err := fmt.Sscanf(s, "%d", &bytes)
-	return bytes, err
-}
-
 // IsWireGuardInstalled 检查WireGuard是否已安装
 func IsWireGuardInstalled() bool {
 	_, err := exec.LookPath("wg")
@@ -352,29 +281,34 @@ func IsWireGuardInstalled() bool {
 
 // IsInterfaceUp 检查接口是否已启动
 func IsInterfaceUp(interfaceName string) bool {
-	cmd := exec.Command("wg", "show", interfaceName)
-	return cmd.Run() == nil
+	_, err := GetBackend().GetDevice(interfaceName)
+	return err == nil
 }
 
-// ValidateConfig 验证WireGuard配置文件
-func ValidateConfig(configContent string) error {
-	// 检查必需的部分
-	if !strings.Contains(configContent, "[Interface]") {
-		return fmt.Errorf("配置缺少[Interface]部分")
+// PublicKeyFromPrivate 由私钥计算公钥
+func PublicKeyFromPrivate(privateKey string) (string, error) {
+	data, err := decodeKey(privateKey)
+	if err != nil {
+		return "", err
 	}
 
-	// 检查私钥
-	privateKeyRegex := regexp.MustCompile(`PrivateKey\s*=\s*([A-Za-z0-9+/]{43}=)`)
-	if !privateKeyRegex.MatchString(configContent) {
-		return fmt.Errorf("配置缺少有效的PrivateKey")
-	}
+	var publicKey [32]byte
+	curve25519.ScalarBaseMult(&publicKey, (*[32]byte)(data))
+	return base64.StdEncoding.EncodeToString(publicKey[:]), nil
+}
 
-	// 检查地址
-	addressRegex := regexp.MustCompile(`Address\s*=\s*(\d+\.\d+\.\d+\.\d+/\d+)`)
-	if !addressRegex.MatchString(configContent) {
-		return fmt.Errorf("配置缺少有效的Address")
+// ValidateConfig 验证WireGuard配置文件，错误信息包含行号
+func ValidateConfig(configContent string) error {
+	config, err := wgconf.Parse(configContent)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if len(config.Interface.Address) == 0 {
+		return fmt.Errorf("配置缺少Address")
 	}
-
 	return nil
 }
 
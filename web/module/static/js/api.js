@@ -95,8 +95,8 @@ class API {
         } catch (error) {
             console.error(`API请求失败 [${endpoint}]:`, error);
             
-            // 如果是认证错误，清除token并跳转登录
-            if (error.message.includes('401') || error.message.includes('403')) {
+            // 如果是认证错误，清除token并跳转登录；403为权限不足，保留登录状态
+            if (error.message.includes('401')) {
                 AuthManager.clearToken();
                 setTimeout(() => {
                     window.location.href = '/login';
@@ -593,7 +593,14 @@ function hideConfigUploadModal() {
     }
 }
 
-function logout() {
+async function logout() {
+    // 先让服务端删除会话，失败时仍然清除本地token
+    try {
+        await API.post('/auth/logout', {});
+    } catch (error) {
+        console.warn('服务端登出失败:', error);
+    }
+
     // 使用封装的认证管理器清除token
     AuthManager.clearToken();
     
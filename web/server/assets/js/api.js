@@ -132,6 +132,7 @@ class API {
      */
     clearAuth() {
         localStorage.removeItem(this.tokenKey);
+        localStorage.removeItem('refresh_token');
     }
 }
 
@@ -433,10 +434,18 @@ class AuthAPI extends API {
     }
 
     /**
-     * 刷新token
+     * 刷新token (刷新令牌只能使用一次，保存服务端返回的新令牌对)
      */
     async refreshToken() {
-        return this.post('/auth/refresh');
+        const result = await this.post('/auth/refresh', {
+            refresh_token: localStorage.getItem('refresh_token')
+        });
+        const tokens = result && result.data;
+        if (tokens && tokens.access_token) {
+            localStorage.setItem(this.tokenKey, tokens.access_token);
+            localStorage.setItem('refresh_token', tokens.refresh_token);
+        }
+        return result;
     }
 
     /**